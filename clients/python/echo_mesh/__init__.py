@@ -0,0 +1,7 @@
+"""Python client for the Echo System decentralized local AI mesh."""
+
+from .client import EchoClient, EchoError
+from .models import *  # noqa: F401,F403
+
+__all__ = ["EchoClient", "EchoError"]
+__version__ = "0.1.0"
@@ -0,0 +1,679 @@
+"""HTTP client for the Echo System orchestrator (standard library only)."""
+
+import json
+import time
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Callable, Dict, Iterator, List, Optional
+
+from .models import (
+    AlertsResponse,
+    AsyncTask,
+    ChatMessage,
+    ChunkResult,
+    CompressOptions,
+    Conversation,
+    FairShareStats,
+    FeedbackSummary,
+    FileInfo,
+    ModelListResponse,
+    NodeAvailability,
+    NodeInfo,
+    PipelineResult,
+    PipelineRun,
+    PipelineRunSummary,
+    PipelineStep,
+    SharedResult,
+    ShareLink,
+    SummarizeResult,
+    TaskChunk,
+    TaskFeedback,
+    TaskHistoryPage,
+    TaskLineageRecord,
+    TaskResult,
+    TimeoutStats,
+    Topology,
+    VersionInfo,
+)
+
+
+class EchoError(Exception):
+    """A non-2xx answer from the orchestrator.
+
+    `status` is the HTTP status and `body` the parsed JSON body. Errors are
+    problem+json (see models.Problem): `type` names the failure
+    ("urn:echo:problem:not-found", ...), `retryable` says whether the same
+    request may work later and `task_id` is the task it concerns, if any.
+    A failed pipeline answers 500 with its partial PipelineResult instead,
+    leaving `type` empty.
+    """
+
+    def __init__(self, status: int, message: str, body: Any = None):
+        super().__init__("%d: %s" % (status, message))
+        self.status = status
+        self.body = body
+        problem = body if isinstance(body, dict) else {}
+        self.type = problem.get("type", "")
+        self.retryable = bool(problem.get("retryable", False))
+        self.task_id = problem.get("task_id", "")
+
+
+class EchoClient:
+    """Submits tasks and pipelines to an orchestrator and lists its nodes.
+
+        client = EchoClient("http://localhost:8080")
+        print(client.task("Explain recursion", type="text")["content"])
+        for chunk in client.stream("Write a haiku"):
+            print(chunk["token"], end="", flush=True)
+    """
+
+    def __init__(
+        self,
+        base_url: str = "http://localhost:8080",
+        timeout: float = 300,
+        headers: Optional[Dict[str, str]] = None,
+        api_key: Optional[str] = None,
+    ):
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+        # Sent with every request; the orchestrator's -pass-headers decides
+        # which of them reach the nodes' backends
+        self.headers = dict(headers or {})
+        # One of the orchestrator's -client-keys: tasks are attributed to
+        # its name in events and pipeline history
+        if api_key:
+            self.headers["Authorization"] = "Bearer " + api_key
+        self.headers.setdefault("User-Agent", "echo-mesh-python")
+
+    # ─── Tasks ───────────────────────────────────────────────────────────────
+
+    def task(
+        self,
+        prompt: str = "",
+        *,
+        type: Optional[str] = None,
+        model_hint: Optional[str] = None,
+        language: Optional[str] = None,
+        min_quality: Optional[str] = None,
+        format: Optional[str] = None,
+        target_node: Optional[str] = None,
+        messages: Optional[List[ChatMessage]] = None,
+        allow_cloud: bool = False,
+        metadata: Optional[Dict[str, str]] = None,
+        task_id: Optional[str] = None,
+        files: Optional[List[str]] = None,
+        compress: Optional[CompressOptions] = None,
+        options: Optional[Dict[str, Any]] = None,
+        queue_timeout_ms: Optional[int] = None,
+        verify: bool = False,
+    ) -> TaskResult:
+        """Run a task and wait for the full result (POST /task).
+
+        `files` are IDs from upload_file; their content goes ahead of the
+        prompt (images beside it). `compress` ({"target_tokens": 800,
+        "model": "qwen2:0.5b"}) has a small model condense the prompt first.
+        `options` ({"temperature": 0.2}) tunes the backend's sampling, over
+        the orchestrator's defaults for the task type. `min_quality`
+        ("small", "medium" or "large") keeps the task off smaller models.
+        While no node can take the task it waits at the orchestrator, for
+        `queue_timeout_ms` if given (negative fails at once). `verify` has
+        a second model check the answer; the result's "verification" holds
+        its verdict ("pass", "fail", "unsure" or "unverified") and
+        confidence.
+        """
+        body = _task_request(prompt, type, model_hint, language, min_quality, format, target_node, messages, allow_cloud, metadata, task_id, files, compress, options, queue_timeout_ms, verify)
+        return self._request("POST", "/task", body)
+
+    def submit(
+        self,
+        prompt: str = "",
+        *,
+        type: Optional[str] = None,
+        model_hint: Optional[str] = None,
+        language: Optional[str] = None,
+        min_quality: Optional[str] = None,
+        format: Optional[str] = None,
+        target_node: Optional[str] = None,
+        messages: Optional[List[ChatMessage]] = None,
+        allow_cloud: bool = False,
+        metadata: Optional[Dict[str, str]] = None,
+        task_id: Optional[str] = None,
+        files: Optional[List[str]] = None,
+        compress: Optional[CompressOptions] = None,
+        options: Optional[Dict[str, Any]] = None,
+        queue_timeout_ms: Optional[int] = None,
+        verify: bool = False,
+    ) -> AsyncTask:
+        """Queue a task without waiting for it (POST /task/async).
+
+        Takes the same arguments as task(). Poll async_task() with the
+        returned task_id, or wait() for the result.
+        """
+        body = _task_request(prompt, type, model_hint, language, min_quality, format, target_node, messages, allow_cloud, metadata, task_id, files, compress, options, queue_timeout_ms, verify)
+        return self._request("POST", "/task/async", body)
+
+    def async_task(self, task_id: str) -> AsyncTask:
+        """A submitted task's status, and its result once done (GET /task/{id})."""
+        return self._request("GET", "/task/" + urllib.parse.quote(task_id))
+
+    def wait(self, task_id: str, interval: float = 2.0, timeout: Optional[float] = None) -> TaskResult:
+        """Poll a submitted task until it finishes and return its result.
+
+        Raises EchoError with the task's problem if it failed, and
+        TimeoutError if `timeout` seconds pass first.
+        """
+        deadline = None if timeout is None else time.monotonic() + timeout
+        while True:
+            task = self.async_task(task_id)
+            if task["status"] == "done":
+                return task["result"]
+            if task["status"] == "failed":
+                problem = task.get("error") or {}
+                raise EchoError(problem.get("status", 500), problem.get("detail", "task failed"), problem)
+            if deadline is not None and time.monotonic() + interval > deadline:
+                raise TimeoutError("task %s still %s" % (task_id, task["status"]))
+            time.sleep(interval)
+
+    def stream(
+        self,
+        prompt: str = "",
+        *,
+        type: Optional[str] = None,
+        model_hint: Optional[str] = None,
+        language: Optional[str] = None,
+        min_quality: Optional[str] = None,
+        format: Optional[str] = None,
+        target_node: Optional[str] = None,
+        messages: Optional[List[ChatMessage]] = None,
+        allow_cloud: bool = False,
+        metadata: Optional[Dict[str, str]] = None,
+        task_id: Optional[str] = None,
+        files: Optional[List[str]] = None,
+        compress: Optional[CompressOptions] = None,
+        options: Optional[Dict[str, Any]] = None,
+        queue_timeout_ms: Optional[int] = None,
+        verify: bool = False,
+        mode: str = "delta",
+        granularity: str = "token",
+        on_failover: Optional[Callable[[Dict[str, Any]], None]] = None,
+    ) -> Iterator[TaskChunk]:
+        """Run a task and yield its chunks as they arrive (POST /task/stream).
+
+        In "delta" mode each chunk carries the next piece in `token`; in
+        "full" mode `text` holds everything generated so far. With
+        granularity "word" or "sentence" text arrives in whole words or
+        sentences rather than token by token. The last chunk
+        has done=True, and `error` set if the task failed mid-stream.
+        With format="json", a last chunk with json_repaired=True holds
+        the repaired document in `text`, replacing the streamed text.
+        When a node fails before its first token and the task moves on,
+        on_failover (if given) receives the failover event: failed_node,
+        reason, next_node.
+        """
+        body = _task_request(prompt, type, model_hint, language, min_quality, format, target_node, messages, allow_cloud, metadata, task_id, files, compress, options, queue_timeout_ms, verify)
+        body["stream_mode"] = mode
+        if granularity != "token":
+            body["stream_granularity"] = granularity
+        with self._open("POST", "/task/stream", body) as resp:
+            yield from _read_chunks(resp, on_failover)
+
+    def resume(self, task_id: str, received: int = 0) -> Iterator[TaskChunk]:
+        """Resume a stream after a dropped connection or an orchestrator
+        restart (GET /task/stream/{id}), yielding the chunks after the
+        first `received` bytes of the answer: the UTF-8 length of the
+        tokens joined so far in "delta" mode, of the last `text` in "full"
+        mode. Raises EchoError 410 if the node lost a still-running stream.
+        """
+        path = "/task/stream/" + urllib.parse.quote(task_id, safe="")
+        if received:
+            path += "?" + urllib.parse.urlencode({"last_event_id": received})
+        with self._open("GET", path) as resp:
+            yield from _read_chunks(resp, None)
+
+    def embed(
+        self,
+        input: List[str],
+        *,
+        model_hint: Optional[str] = None,
+        target_node: Optional[str] = None,
+        metadata: Optional[Dict[str, str]] = None,
+        task_id: Optional[str] = None,
+        options: Optional[Dict[str, Any]] = None,
+    ) -> TaskResult:
+        """Embed up to 2048 texts in one task (POST /task, type embed).
+
+        The node sends them to its backend in batches; the result's
+        `embeddings` has one vector per text, in order, and `embed_timings`
+        the batch and per-text times.
+        """
+        if not input:
+            raise ValueError("input is required")
+        body: Dict[str, Any] = {"type": "embed", "input": list(input)}
+        for key, value in (
+            ("model_hint", model_hint),
+            ("target_node", target_node),
+            ("metadata", metadata),
+            ("task_id", task_id),
+            ("options", options),
+        ):
+            if value:
+                body[key] = value
+        return self._request("POST", "/task", body)
+
+    # ─── Files ───────────────────────────────────────────────────────────────
+
+    def upload_file(self, data: bytes, name: Optional[str] = None, content_type: str = "application/octet-stream") -> FileInfo:
+        """Upload a file for tasks to reference by ID (POST /files).
+
+        The ID is the content's SHA-256, so uploading the same bytes again
+        returns the stored file.
+        """
+        path = "/files"
+        if name:
+            path += "?" + urllib.parse.urlencode({"name": name})
+        with self._open("POST", path, raw=data, content_type=content_type) as resp:
+            return json.load(resp)
+
+    def list_files(self) -> List[FileInfo]:
+        """List uploaded files, newest first (GET /files)."""
+        return self._request("GET", "/files")["files"]
+
+    # ─── Pipelines ───────────────────────────────────────────────────────────
+
+    def pipeline(
+        self,
+        initial_input: str,
+        steps: Optional[List[PipelineStep]] = None,
+        *,
+        template: Optional[str] = None,
+        allow_cloud: bool = False,
+        variables: Optional[Dict[str, str]] = None,
+        metadata: Optional[Dict[str, str]] = None,
+        pipeline_id: Optional[str] = None,
+    ) -> PipelineResult:
+        """Run a pipeline of steps, or a built-in template (POST /pipeline).
+
+        `variables` fill {{var.<name>}} in the steps' templates. Raises
+        EchoError if a step fails; its `body` holds the partial result.
+        """
+        body: Dict[str, Any] = {"initial_input": initial_input, "steps": steps or []}
+        if template:
+            body["template"] = template
+        if allow_cloud:
+            body["allow_cloud"] = True
+        if variables:
+            body["variables"] = variables
+        if metadata:
+            body["metadata"] = metadata
+        if pipeline_id:
+            body["pipeline_id"] = pipeline_id
+        return self._request("POST", "/pipeline", body)
+
+    def pipeline_yaml(self, document: str) -> PipelineResult:
+        """Run a pipeline written in YAML (POST /pipeline as application/yaml).
+
+        The document has the fields of a JSON pipeline; block scalars (|, >)
+        keep multi-line prompts readable. Raises EchoError if a step fails,
+        or with status 400 if the document can't be read.
+        """
+        with self._open("POST", "/pipeline", raw=document.encode("utf-8"), content_type="application/yaml") as resp:
+            return json.load(resp)
+
+    def summarize(
+        self,
+        text: str,
+        *,
+        focus: Optional[str] = None,
+        max_words: Optional[int] = None,
+        chunk_tokens: Optional[int] = None,
+        split: Optional[str] = None,
+        overlap_tokens: Optional[int] = None,
+        model_hint: Optional[str] = None,
+        reduce_model_hint: Optional[str] = None,
+        max_parallel: Optional[int] = None,
+        allow_cloud: bool = False,
+        metadata: Optional[Dict[str, str]] = None,
+    ) -> SummarizeResult:
+        """Summarize a long document map-reduce style (POST /summarize).
+
+        The orchestrator chunks `text`, summarizes the chunks in parallel
+        and combines them; `chunks` in the result traces each one. Raises
+        EchoError if a chunk fails; its `body` holds the traces so far.
+        """
+        body: Dict[str, Any] = {"text": text}
+        for name, value in (
+            ("focus", focus),
+            ("max_words", max_words),
+            ("chunk_tokens", chunk_tokens),
+            ("split", split),
+            ("overlap_tokens", overlap_tokens),
+            ("model_hint", model_hint),
+            ("reduce_model_hint", reduce_model_hint),
+            ("max_parallel", max_parallel),
+            ("metadata", metadata),
+        ):
+            if value:
+                body[name] = value
+        if allow_cloud:
+            body["allow_cloud"] = True
+        return self._request("POST", "/summarize", body)
+
+    def chunk(
+        self,
+        text: str,
+        chunk_tokens: int,
+        *,
+        split: Optional[str] = None,
+        overlap_tokens: Optional[int] = None,
+    ) -> ChunkResult:
+        """Cut a text into chunks of at most `chunk_tokens` (POST /chunk).
+
+        `split` is "paragraphs" (default), "sentences", "markdown" or
+        "tokens". The chunks' texts suit embed(), for retrieval.
+        """
+        body: Dict[str, Any] = {"text": text, "chunk_tokens": chunk_tokens}
+        if split:
+            body["split"] = split
+        if overlap_tokens:
+            body["overlap_tokens"] = overlap_tokens
+        return self._request("POST", "/chunk", body)
+
+    def pipeline_runs(self, source: Optional[str] = None) -> List[PipelineRunSummary]:
+        """List persisted pipeline runs, newest first (GET /pipelines/runs).
+
+        `source` keeps the runs whose client key name or remote IP equals
+        it, or whose user agent contains it.
+        """
+        path = "/pipelines/runs"
+        if source:
+            path += "?" + urllib.parse.urlencode({"source": source})
+        return self._request("GET", path)["runs"]
+
+    def pipeline_run(self, pipeline_id: str) -> PipelineRun:
+        """Get one persisted pipeline run (GET /pipelines/runs/{id})."""
+        return self._request("GET", "/pipelines/runs/" + urllib.parse.quote(pipeline_id, safe=""))
+
+    def task_lineage(self, task_id: str) -> TaskLineageRecord:
+        """The lineage tree a task belongs to: pipeline, steps, retries, mirrors."""
+        return self._request("GET", "/tasks/" + urllib.parse.quote(task_id, safe="") + "/lineage")
+
+    def history(
+        self,
+        *,
+        node: Optional[str] = None,
+        type: Optional[str] = None,
+        since: Optional[Any] = None,
+        until: Optional[Any] = None,
+        limit: Optional[int] = None,
+        cursor: Optional[str] = None,
+    ) -> TaskHistoryPage:
+        """A page of finished tasks, newest first (GET /history).
+
+        `since` and `until` take Unix ms, an RFC 3339 time or a span back
+        from now such as "24h". Pass the page's "next_cursor" as `cursor`
+        for the next one; the last page has none.
+        """
+        params = {key: value for key, value in (
+            ("node", node), ("type", type), ("since", since), ("until", until), ("limit", limit), ("cursor", cursor),
+        ) if value}
+        path = "/history"
+        if params:
+            path += "?" + urllib.parse.urlencode(params)
+        return self._request("GET", path)
+
+    def feedback(
+        self,
+        task_id: str,
+        rating: Optional[str] = None,
+        *,
+        comment: Optional[str] = None,
+        corrected_output: Optional[str] = None,
+    ) -> TaskFeedback:
+        """Rate a task's output "up" or "down", comment on it or give the
+        output it should have been (POST /tasks/{id}/feedback).
+
+        Posting again replaces the task's feedback.
+        """
+        body = {key: value for key, value in (("rating", rating), ("comment", comment), ("corrected_output", corrected_output)) if value}
+        if not body:
+            raise ValueError("rating, comment or corrected_output is required")
+        return self._request("POST", "/tasks/" + urllib.parse.quote(task_id, safe="") + "/feedback", body)
+
+    def task_feedback(self, task_id: str) -> TaskFeedback:
+        """The feedback given on a task (GET /tasks/{id}/feedback)."""
+        return self._request("GET", "/tasks/" + urllib.parse.quote(task_id, safe="") + "/feedback")
+
+    # ─── Conversations ───────────────────────────────────────────────────────
+
+    def start_conversation(
+        self,
+        *,
+        type: Optional[str] = None,
+        model: Optional[str] = None,
+        node_id: Optional[str] = None,
+        messages: Optional[List[ChatMessage]] = None,
+    ) -> Conversation:
+        """Start a conversation the orchestrator keeps (POST /conversations).
+
+        `model` and `node_id` pin its turns to a model and/or a node;
+        `messages` seed the history, e.g. with a system prompt.
+        """
+        body: Dict[str, Any] = {}
+        for key, value in (("type", type), ("model", model), ("node_id", node_id), ("messages", messages)):
+            if value:
+                body[key] = value
+        return self._request("POST", "/conversations", body)
+
+    def conversation(self, conversation_id: str) -> Conversation:
+        """A conversation's history, pin and recorded switches."""
+        return self._request("GET", _conversation_path(conversation_id))
+
+    def delete_conversation(self, conversation_id: str) -> Conversation:
+        """Delete a conversation (DELETE /conversations/{id})."""
+        return self._request("DELETE", _conversation_path(conversation_id))
+
+    def say(
+        self,
+        conversation_id: str,
+        content: str,
+        *,
+        format: Optional[str] = None,
+        options: Optional[Dict[str, Any]] = None,
+        allow_cloud: bool = False,
+        metadata: Optional[Dict[str, str]] = None,
+    ) -> TaskResult:
+        """Send the next user turn and wait for the answer.
+
+        The turn runs over the history on the pinned model and node; it and
+        the answer join the history once the task succeeds.
+        """
+        body: Dict[str, Any] = {"content": content}
+        if format:
+            body["format"] = format
+        if options:
+            body["options"] = options
+        if allow_cloud:
+            body["allow_cloud"] = True
+        if metadata:
+            body["metadata"] = metadata
+        return self._request("POST", _conversation_path(conversation_id) + "/messages", body)
+
+    def pin_conversation(self, conversation_id: str, model: Optional[str] = None, node_id: Optional[str] = None) -> Conversation:
+        """Pin a conversation to a model and/or node, replacing its pin."""
+        body = {k: v for k, v in (("model", model), ("node_id", node_id)) if v}
+        return self._request("PUT", _conversation_path(conversation_id) + "/pin", body)
+
+    def unpin_conversation(self, conversation_id: str) -> Conversation:
+        """Let routing choose the model and node for its turns again."""
+        return self._request("DELETE", _conversation_path(conversation_id) + "/pin")
+
+    def switch_model(self, conversation_id: str, model: str, node_id: Optional[str] = None) -> Conversation:
+        """Move a conversation to another model midway.
+
+        The pinned node is kept unless `node_id` names another. If the new
+        model's context window is smaller and the history no longer fits,
+        the older turns are summarized; the last entry of `changes` says
+        how many.
+        """
+        body = {"model": model}
+        if node_id:
+            body["node_id"] = node_id
+        return self._request("POST", _conversation_path(conversation_id) + "/switch", body)
+
+    # ─── Share links ─────────────────────────────────────────────────────────
+
+    def share_task(self, task_id: str, expires_in: Optional[str] = None) -> ShareLink:
+        """Make a signed link to one task's result (POST /tasks/{id}/share).
+
+        `expires_in` is a duration such as "72h" (default 24h, at most
+        720h). Anyone with the link's `url` can read the result until then.
+        """
+        body = {"expires_in": expires_in} if expires_in else {}
+        return self._request("POST", "/tasks/" + urllib.parse.quote(task_id, safe="") + "/share", body)
+
+    def share_pipeline_run(self, pipeline_id: str, expires_in: Optional[str] = None) -> ShareLink:
+        """Make a signed link to one pipeline run (POST /pipelines/runs/{id}/share)."""
+        body = {"expires_in": expires_in} if expires_in else {}
+        return self._request("POST", "/pipelines/runs/" + urllib.parse.quote(pipeline_id, safe="") + "/share", body)
+
+    def shared(self, token: str) -> SharedResult:
+        """Open a share link by its token (GET /share/{token})."""
+        return self._request("GET", "/share/" + urllib.parse.quote(token, safe=""))
+
+    # ─── Nodes ───────────────────────────────────────────────────────────────
+
+    def nodes(self) -> List[NodeInfo]:
+        """List the registered nodes (GET /status)."""
+        return self._request("GET", "/status")["nodes"]
+
+    def version(self) -> VersionInfo:
+        """The orchestrator's build and mesh API version (GET /version)."""
+        return self._request("GET", "/version")
+
+    def topology(self) -> Topology:
+        """Which nodes see which others over mDNS, with RTTs (GET /topology)."""
+        return self._request("GET", "/topology")
+
+    def node_models(self, node_id: str) -> ModelListResponse:
+        """List the models installed on a node, with details from its Ollama."""
+        return self._request("GET", "/nodes/" + urllib.parse.quote(node_id, safe="") + "/models")
+
+    def availability(self, window: Optional[str] = None) -> List[NodeAvailability]:
+        """Each node's uptime and offlines over a window (GET /stats/availability).
+
+        `window` is a span such as "6h" or "7d" (default 24h, at most 30d).
+        Only time the orchestrator was running counts.
+        """
+        path = "/stats/availability"
+        if window:
+            path += "?" + urllib.parse.urlencode({"window": window})
+        return self._request("GET", path)["nodes"]
+
+    def feedback_stats(self, window: Optional[str] = None, type: Optional[str] = None) -> FeedbackSummary:
+        """Task feedback per model, per model and type, and per node, best
+        score first (GET /stats/feedback).
+
+        `window` is a span such as "7d" (default 30d), or "all".
+        """
+        params = {key: value for key, value in (("window", window), ("type", type)) if value}
+        path = "/stats/feedback"
+        if params:
+            path += "?" + urllib.parse.urlencode(params)
+        return self._request("GET", path)
+
+    def fair_share_stats(self) -> FairShareStats:
+        """Recent GPU-seconds, shares and held tasks per tenant, with the
+        fairness index (GET /stats/fairshare)."""
+        return self._request("GET", "/stats/fairshare")
+
+    def timeout_stats(self) -> TimeoutStats:
+        """How long each node may take on each model before its tasks fail
+        over (GET /stats/timeouts)."""
+        return self._request("GET", "/stats/timeouts")
+
+    # ─── Alerts ──────────────────────────────────────────────────────────────
+
+    def alerts(self) -> AlertsResponse:
+        """Firing and recently resolved alerts, with the enabled rules (GET /alerts)."""
+        return self._request("GET", "/alerts")
+
+    # ─── HTTP ────────────────────────────────────────────────────────────────
+
+    def _open(self, method: str, path: str, body: Any = None, raw: Optional[bytes] = None, content_type: str = ""):
+        data = raw
+        headers = dict(self.headers, Accept="application/json")
+        if raw is not None:
+            headers["Content-Type"] = content_type
+        if body is not None:
+            data = json.dumps(body).encode("utf-8")
+            headers["Content-Type"] = "application/json"
+        req = urllib.request.Request(self.base_url + path, data=data, headers=headers, method=method)
+        try:
+            return urllib.request.urlopen(req, timeout=self.timeout)
+        except urllib.error.HTTPError as e:
+            text = e.read().decode("utf-8", "replace").strip()
+            try:
+                parsed = json.loads(text)
+            except ValueError:
+                parsed = None
+            message = text
+            if isinstance(parsed, dict):
+                message = parsed.get("detail") or parsed.get("error") or text
+            raise EchoError(e.code, message, parsed) from None
+
+    def _request(self, method: str, path: str, body: Any = None) -> Any:
+        with self._open(method, path, body) as resp:
+            return json.load(resp)
+
+
+def _read_chunks(resp, on_failover: Optional[Callable[[Dict[str, Any]], None]]) -> Iterator[TaskChunk]:
+    event = ""
+    for raw in resp:
+        line = raw.decode("utf-8").strip()
+        if line.startswith("event:"):
+            event = line[len("event:"):].strip()
+            continue
+        if not line.startswith("data:"):
+            continue
+        data = json.loads(line[len("data:"):])
+        name, event = event, ""
+        if name == "failover":
+            if on_failover is not None:
+                on_failover(data)
+            continue
+        yield data
+        if data.get("done"):
+            return
+
+
+def _task_request(prompt, type, model_hint, language, min_quality, format, target_node, messages, allow_cloud, metadata, task_id, files, compress, options, queue_timeout_ms, verify) -> Dict[str, Any]:
+    if not prompt and not messages:
+        raise ValueError("prompt or messages is required")
+    body: Dict[str, Any] = {"prompt": prompt}
+    for key, value in (
+        ("type", type),
+        ("model_hint", model_hint),
+        ("language", language),
+        ("min_quality", min_quality),
+        ("format", format),
+        ("target_node", target_node),
+        ("messages", messages),
+        ("metadata", metadata),
+        ("task_id", task_id),
+        ("files", files),
+        ("compress", compress),
+        ("options", options),
+        ("queue_timeout_ms", queue_timeout_ms),
+    ):
+        if value:
+            body[key] = value
+    if allow_cloud:
+        body["allow_cloud"] = True
+    if verify:
+        body["verify"] = True
+    return body
+
+
+def _conversation_path(conversation_id: str) -> str:
+    return "/conversations/" + urllib.parse.quote(conversation_id, safe="")
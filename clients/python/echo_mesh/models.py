@@ -0,0 +1,1176 @@
+"""Types of the Echo System orchestrator API.
+
+Code generated by generate.py from http://localhost:8080/openapi.json; DO NOT EDIT.
+"""
+
+from typing import Dict, List, Literal, TypedDict
+
+AlertRule = Literal['node_offline', 'error_rate', 'queue_depth', 'disk_low']
+AlertState = Literal['firing', 'resolved']
+DeferredStatus = Literal['queued', 'bundled', 'done']
+HealthGrade = Literal['green', 'yellow', 'red']
+LineageRelation = Literal['step', 'item', 'retry', 'mirror']
+NodeStatus = Literal['idle', 'busy', 'overloaded', 'offline', 'backend_down', 'absent']
+OutputFormat = Literal['json']
+PipelinePolicy = Literal['fifo', 'shortest-remaining']
+PipelineRunStatus = Literal['running', 'succeeded', 'failed', 'interrupted']
+ProblemType = Literal['urn:echo:problem:invalid-request', 'urn:echo:problem:unauthorized', 'urn:echo:problem:not-found', 'urn:echo:problem:conflict', 'urn:echo:problem:gone', 'urn:echo:problem:too-large', 'urn:echo:problem:internal', 'urn:echo:problem:node-failed', 'urn:echo:problem:unavailable', 'urn:echo:problem:timeout', 'urn:echo:problem:insufficient-storage']
+RolloutStatus = Literal['active', 'rolled_back']
+RoutingStrategy = Literal['least-loaded', 'round-robin']
+StreamGranularity = Literal['token', 'word', 'sentence']
+StreamMode = Literal['delta', 'full']
+TaskType = Literal['text', 'code', 'vision', 'summarize', 'embed']
+ThermalState = Literal['normal', 'throttled', 'hot']
+
+
+class Alert(TypedDict, total=False):
+    fired_at: int
+    id: str
+    message: str
+    resolved_at: int
+    rule: "AlertRule"
+    state: "AlertState"
+    subject: str
+    threshold: float
+    value: float
+
+
+class AlertRuleConfig(TypedDict, total=False):
+    rule: "AlertRule"
+    threshold: float
+
+
+class AlertsResponse(TypedDict, total=False):
+    channels: List[str]
+    firing: List["Alert"]
+    resolved: List["Alert"]
+    rules: List["AlertRuleConfig"]
+
+
+class AliasList(TypedDict, total=False):
+    aliases: List["ModelAlias"]
+    count: int
+
+
+class AliasRollout(TypedDict, total=False):
+    candidate: "RolloutStats"
+    max_error_rate: float
+    max_latency_ratio: float
+    min_samples: int
+    percent: float
+    reason: str
+    stable: "RolloutStats"
+    started_at: int
+    status: "RolloutStatus"
+    target: str
+
+
+class AsyncTask(TypedDict, total=False):
+    error: "Problem"
+    finished_at: int
+    result: "TaskResult"
+    started_at: int
+    status: str
+    status_url: str
+    submitted_at: int
+    task_id: str
+
+
+class AvailabilityResponse(TypedDict, total=False):
+    nodes: List["NodeAvailability"]
+    window_secs: int
+
+
+class BundleClaimRequest(TypedDict, total=False):
+    max_tasks: int
+    node_id: str
+
+
+class BundleUpload(TypedDict, total=False):
+    node_id: str
+    results: List["TaskResult"]
+
+
+class BundleUploadResponse(TypedDict, total=False):
+    accepted: int
+    duplicates: int
+    unknown: int
+
+
+class CapabilityDelta(TypedDict, total=False):
+    added: List["ModelCapability"]
+    base: int
+    removed: List[str]
+    seq: int
+
+
+class ChatMessage(TypedDict, total=False):
+    content: str
+    role: str
+
+
+class ChunkRequest(TypedDict, total=False):
+    chunk_tokens: int
+    overlap_tokens: int
+    split: str
+    text: str
+
+
+class ChunkResult(TypedDict, total=False):
+    chunks: List["TextChunk"]
+    split: str
+
+
+class ClearedResponse(TypedDict, total=False):
+    cleared: int
+
+
+class CloudUsage(TypedDict, total=False):
+    daily_tokens: int
+    day: str
+    enabled: bool
+    model: str
+    refused: int
+    requests: int
+    tokens: int
+
+
+class CompressOptions(TypedDict, total=False):
+    model: str
+    target_tokens: int
+
+
+class Conversation(TypedDict, total=False):
+    changes: List["ConversationChange"]
+    conversation_id: str
+    created_at: int
+    messages: List["ConversationMessage"]
+    model: str
+    node_id: str
+    summary: str
+    summary_through: int
+    type: "TaskType"
+    updated_at: int
+
+
+class ConversationChange(TypedDict, total=False):
+    at: int
+    at_message: int
+    from_model: str
+    from_node: str
+    kind: str
+    model: str
+    node_id: str
+    note: str
+    summarized: int
+
+
+class ConversationMessage(TypedDict, total=False):
+    at: int
+    content: str
+    model: str
+    node_id: str
+    role: str
+    task_id: str
+
+
+class ConversationPinRequest(TypedDict, total=False):
+    model: str
+    node_id: str
+
+
+class ConversationRequest(TypedDict, total=False):
+    messages: List["ChatMessage"]
+    model: str
+    node_id: str
+    type: "TaskType"
+
+
+class ConversationTurn(TypedDict, total=False):
+    allow_cloud: bool
+    content: str
+    format: "OutputFormat"
+    metadata: Dict[str, str]
+    options: Dict[str, object]
+
+
+class DashboardClient(TypedDict, total=False):
+    coalesced: int
+    connected_at: int
+    dropped: int
+    framing: str
+    queued: int
+    remote_addr: str
+
+
+class DashboardList(TypedDict, total=False):
+    clients: List["DashboardClient"]
+    slow_disconnects: int
+
+
+class DeadLetter(TypedDict, total=False):
+    attempts: int
+    error: str
+    failed_at: int
+    id: str
+    request: "TaskRequest"
+
+
+class DeadLetterList(TypedDict, total=False):
+    count: int
+    tasks: List["DeadLetter"]
+
+
+class DeferredTask(TypedDict, total=False):
+    bundle_id: str
+    completed_at: int
+    node_id: str
+    queued_at: int
+    request: "TaskRequest"
+    result: "TaskResult"
+    status: "DeferredStatus"
+
+
+class DrainResponse(TypedDict, total=False):
+    draining: bool
+    node_id: str
+
+
+class EmbedTimings(TypedDict, total=False):
+    batch_ms: List[int]
+    item_ms: List[int]
+    items: int
+    items_per_sec: float
+    load_ms: int
+    pipelined: bool
+    prompt_tokens: int
+    requests: int
+    total_ms: int
+
+
+class EvictResponse(TypedDict, total=False):
+    evicted: bool
+    node_id: str
+
+
+class FairShareConfig(TypedDict, total=False):
+    enabled: bool
+    half_life_s: int
+    max_wait_ms: int
+    weights: Dict[str, float]
+
+
+class FairShareStats(TypedDict, total=False):
+    enabled: bool
+    fairness: float
+    tenants: List["TenantShare"]
+    waiting: int
+
+
+class FeedbackRequest(TypedDict, total=False):
+    comment: str
+    corrected_output: str
+    rating: str
+
+
+class FeedbackStats(TypedDict, total=False):
+    comments: int
+    corrections: int
+    down: int
+    last_at: int
+    model: str
+    node_id: str
+    score: float
+    task_type: "TaskType"
+    up: int
+
+
+class FeedbackSummary(TypedDict, total=False):
+    models: List["FeedbackStats"]
+    nodes: List["FeedbackStats"]
+    since: int
+    tasks: int
+    workloads: List["FeedbackStats"]
+
+
+class FileInfo(TypedDict, total=False):
+    content_type: str
+    created_at: int
+    id: str
+    name: str
+    size_bytes: int
+
+
+class FileList(TypedDict, total=False):
+    count: int
+    files: List["FileInfo"]
+
+
+class FlushResponse(TypedDict, total=False):
+    flushed: List[str]
+    load_profiles: int
+
+
+class HeartbeatRequest(TypedDict, total=False):
+    active_tasks: int
+    api_version: int
+    capabilities_checked: int
+    capability_changes: "CapabilityDelta"
+    link: "LinkQuality"
+    loaded_memory: List["LoadedModel"]
+    loaded_models: List[str]
+    node_id: str
+    peers: List["PeerLink"]
+    resources: "Resources"
+    slots: List["ModelSlots"]
+    status: "NodeStatus"
+    thermal: "Thermal"
+    time: int
+    version: str
+
+
+class IngestResponse(TypedDict, total=False):
+    status: str
+
+
+class LineageNode(TypedDict, total=False):
+    attempts: List["TaskAttempt"]
+    children: List["LineageNode"]
+    error: str
+    id: str
+    model_used: str
+    parent: str
+    pipeline: bool
+    relation: "LineageRelation"
+    routed_to: str
+    success: bool
+    timestamp: int
+
+
+class LinkQuality(TypedDict, total=False):
+    grade: str
+    jitter_ms: float
+    loss: float
+    probes: int
+    rtt_ms: float
+
+
+class LoadedModel(TypedDict, total=False):
+    expires_at: int
+    name: str
+    size_bytes: int
+    vram_bytes: int
+
+
+class LockList(TypedDict, total=False):
+    count: int
+    locks: List["ModelLock"]
+
+
+class MirrorOutput(TypedDict, total=False):
+    content: str
+    error: str
+    latency_ms: int
+    model_used: str
+    node_id: str
+    success: bool
+
+
+class MirrorRecord(TypedDict, total=False):
+    candidate: "MirrorOutput"
+    primary: "MirrorOutput"
+    prompt: str
+    task_id: str
+    task_type: "TaskType"
+    timestamp: int
+
+
+class MirrorResults(TypedDict, total=False):
+    count: int
+    enabled: bool
+    model: str
+    node_id: str
+    percent: float
+    results: List["MirrorRecord"]
+
+
+class ModelAlias(TypedDict, total=False):
+    name: str
+    rollout: "AliasRollout"
+    target: str
+
+
+class ModelCapability(TypedDict, total=False):
+    context_length: int
+    exclusive: bool
+    languages: List[str]
+    name: str
+    tier: str
+    types: List["TaskType"]
+
+
+class ModelDetail(TypedDict, total=False):
+    context_length: int
+    declared: bool
+    digest: str
+    expires_at: int
+    family: str
+    format: str
+    last_used: int
+    loaded: bool
+    modified_at: int
+    name: str
+    parameter_size: str
+    quantization: str
+    size_bytes: int
+    vram_bytes: int
+
+
+ModelFallback = TypedDict("ModelFallback", {
+    "from": str,
+    "node_id": str,
+    "reason": str,
+    "to": str,
+}, total=False)
+
+
+class ModelListResponse(TypedDict, total=False):
+    details: List["ModelDetail"]
+    missing: List[str]
+    models: List[str]
+    node_id: str
+
+
+class ModelLock(TypedDict, total=False):
+    held_ms: int
+    model: str
+    node_id: str
+    task_id: str
+    waiters: int
+
+
+class ModelSlots(TypedDict, total=False):
+    free: int
+    model: str
+    total: int
+
+
+class NodeAvailability(TypedDict, total=False):
+    last_offline_at: int
+    mtbo_secs: int
+    node_id: str
+    offlines: int
+    online: bool
+    tracked_secs: int
+    up_secs: int
+    uptime_pct: float
+    window_secs: int
+
+
+class NodeInfo(TypedDict, total=False):
+    active_tasks: int
+    agent_host: str
+    agent_port: int
+    api_version: int
+    availability: "NodeAvailability"
+    avg_latency_ms: float
+    busy_threshold: int
+    canary: bool
+    capabilities: List["ModelCapability"]
+    capabilities_changed: int
+    capabilities_confirmed: int
+    capabilities_stale: bool
+    capability_seq: int
+    clock_skew_ms: int
+    draining: bool
+    effective_busy_threshold: int
+    exec: List[str]
+    failure_rate: float
+    health: "HealthGrade"
+    health_reason: str
+    identity: str
+    last_failure_at: int
+    last_heartbeat: int
+    link: "LinkQuality"
+    loaded_memory: List["LoadedModel"]
+    loaded_models: List[str]
+    local: bool
+    models: List[str]
+    node_id: str
+    ollama_port: int
+    probing: bool
+    pull: bool
+    registered_at: int
+    reputation: float
+    resources: "Resources"
+    slots: List["ModelSlots"]
+    status: "NodeStatus"
+    thermal: "Thermal"
+    timings: "NodeTimings"
+    transfer: "TaskTransfer"
+    version: str
+
+
+class NodeTimings(TypedDict, total=False):
+    avg_first_token_ms: float
+    avg_generation_ms: float
+    avg_load_ms: float
+    avg_queue_ms: float
+    samples: int
+    tokens_per_sec: float
+
+
+class PeerLink(TypedDict, total=False):
+    addr: str
+    loss: float
+    node_id: str
+    reachable: bool
+    rtt_ms: float
+
+
+class PipelineExec(TypedDict, total=False):
+    code: str
+    fix_template: str
+    language: str
+    max_fixes: int
+    timeout_ms: int
+
+
+class PipelineFetch(TypedDict, total=False):
+    max_chars: int
+    url: str
+
+
+class PipelineItemResult(TypedDict, total=False):
+    content: str
+    error: str
+    index: int
+    latency_ms: int
+    model_used: str
+    routed_to: str
+    success: bool
+    task_id: str
+
+
+class PipelineMap(TypedDict, total=False):
+    chunk_tokens: int
+    join: str
+    max_parallel: int
+    overlap_tokens: int
+    split: str
+
+
+class PipelineRequest(TypedDict, total=False):
+    allow_cloud: bool
+    initial_input: str
+    language: str
+    metadata: Dict[str, str]
+    pipeline_id: str
+    source: "TaskSource"
+    steps: List["PipelineStep"]
+    template: str
+    variables: Dict[str, str]
+
+
+class PipelineResult(TypedDict, total=False):
+    error: str
+    final_output: str
+    latency_ms: int
+    metadata: Dict[str, str]
+    pipeline_id: str
+    source: "TaskSource"
+    steps: List["PipelineStepResult"]
+    success: bool
+    total_steps: int
+
+
+class PipelineRun(TypedDict, total=False):
+    definition: "PipelineRequest"
+    error: str
+    final_output: str
+    finished_at: int
+    latency_ms: int
+    pipeline_id: str
+    resumed_at: int
+    started_at: int
+    status: "PipelineRunStatus"
+    steps: List["PipelineStepResult"]
+
+
+class PipelineRunList(TypedDict, total=False):
+    count: int
+    runs: List["PipelineRunSummary"]
+
+
+class PipelineRunSummary(TypedDict, total=False):
+    completed_steps: int
+    finished_at: int
+    latency_ms: int
+    metadata: Dict[str, str]
+    pipeline_id: str
+    source: "TaskSource"
+    started_at: int
+    status: "PipelineRunStatus"
+    total_steps: int
+
+
+class PipelineSchedule(TypedDict, total=False):
+    policy: "PipelinePolicy"
+    running: int
+    slots: int
+    waiting: int
+
+
+class PipelineStep(TypedDict, total=False):
+    compress: "CompressOptions"
+    exec: "PipelineExec"
+    fetch: "PipelineFetch"
+    language: str
+    map: "PipelineMap"
+    model_hint: str
+    prompt_template: str
+    type: "TaskType"
+
+
+class PipelineStepResult(TypedDict, total=False):
+    attempt: int
+    content: str
+    error: str
+    items: List["PipelineItemResult"]
+    latency_ms: int
+    model_used: str
+    recovered: bool
+    routed_to: str
+    step_index: int
+    success: bool
+    task_id: str
+    task_type: "TaskType"
+
+
+class PipelineTemplate(TypedDict, total=False):
+    description: str
+    file: str
+    input: str
+    name: str
+    steps: List["PipelineStep"]
+
+
+class PlacementError(TypedDict, total=False):
+    available_bytes: int
+    error: str
+    message: str
+    model: str
+    node_id: str
+    required_bytes: int
+
+
+class Problem(TypedDict, total=False):
+    detail: str
+    instance: str
+    retryable: bool
+    status: int
+    task_id: str
+    title: str
+    type: "ProblemType"
+
+
+class PullRequest(TypedDict, total=False):
+    model: str
+    node_id: str
+    size_bytes: int
+    types: List["TaskType"]
+    vram_bytes: int
+
+
+class PullResult(TypedDict, total=False):
+    error: str
+    latency_ms: int
+    model: str
+    node_id: str
+    success: bool
+
+
+class QueueEntry(TypedDict, total=False):
+    id: str
+    kind: str
+    model_hint: str
+    position: int
+    promoted: bool
+    queued_at: int
+    remaining_steps: int
+    source: "TaskSource"
+    target_node: str
+    tenant: str
+    type: "TaskType"
+    waited_ms: int
+
+
+class QueueList(TypedDict, total=False):
+    depth: int
+    entries: List["QueueEntry"]
+
+
+class RegisterRequest(TypedDict, total=False):
+    agent_host: str
+    agent_port: int
+    api_version: int
+    busy_threshold: int
+    canary: bool
+    capabilities: List["ModelCapability"]
+    capability_seq: int
+    exec: List[str]
+    identity_key: str
+    identity_proof: str
+    models: List[str]
+    node_id: str
+    ollama_port: int
+    pull: bool
+    slots: List["ModelSlots"]
+    status: "NodeStatus"
+    time: int
+    version: str
+
+
+class RegisterResponse(TypedDict, total=False):
+    api_version: int
+    session_token: str
+    status: str
+    version: str
+
+
+class RequestStatsResponse(TypedDict, total=False):
+    routes: List["RouteStats"]
+    since: int
+
+
+class Resources(TypedDict, total=False):
+    disk_free_bytes: int
+    disk_total_bytes: int
+    vram_free_bytes: int
+    vram_total_bytes: int
+
+
+class RolloutStats(TypedDict, total=False):
+    avg_latency_ms: float
+    failures: int
+    tasks: int
+
+
+class RouteStats(TypedDict, total=False):
+    avg_ms: float
+    client_errors: int
+    max_ms: int
+    panics: int
+    rate_limited: int
+    requests: int
+    route: str
+    server_errors: int
+    total_ms: int
+
+
+class RoutingConfig(TypedDict, total=False):
+    strategy: "RoutingStrategy"
+
+
+class RoutingPreview(TypedDict, total=False):
+    nodes: List["NodeInfo"]
+    routing: Dict[str, str]
+
+
+class RoutingWeights(TypedDict, total=False):
+    latency: float
+    link: float
+    load: float
+    locality: float
+    reputation: float
+
+
+class SelfTestCheck(TypedDict, total=False):
+    check: str
+    latency_ms: int
+    message: str
+    model: str
+    status: str
+
+
+class SelfTestReport(TypedDict, total=False):
+    backend: str
+    checks: List["SelfTestCheck"]
+    duration_ms: int
+    node_id: str
+    ok: bool
+    started_at: int
+    version: str
+
+
+class SelfTestRequest(TypedDict, total=False):
+    max_skew_ms: int
+    models: List[str]
+    reference_ms: int
+
+
+class ShareLink(TypedDict, total=False):
+    expires_at: int
+    pipeline_id: str
+    task_id: str
+    token: str
+    url: str
+
+
+class ShareRequest(TypedDict, total=False):
+    expires_in: str
+
+
+class SharedResult(TypedDict, total=False):
+    expires_at: int
+    pipeline_id: str
+    result: "TaskResult"
+    run: "PipelineRun"
+    task_id: str
+
+
+class StatsPoint(TypedDict, total=False):
+    avg_latency_ms: float
+    completion_tokens: int
+    failed_tasks: int
+    pipelines: int
+    prompt_tokens: int
+    queue_depth: List[int]
+    queue_depth_max: int
+    received_bytes: int
+    sent_bytes: int
+    tasks: int
+    timestamp: int
+    warm_hit_rate: float
+
+
+class StatsSeriesResponse(TypedDict, total=False):
+    points: List["StatsPoint"]
+    step_secs: int
+    window_secs: int
+
+
+class StatusResponse(TypedDict, total=False):
+    node_count: int
+    nodes: List["NodeInfo"]
+    server_time: int
+
+
+class SummarizeRequest(TypedDict, total=False):
+    allow_cloud: bool
+    chunk_tokens: int
+    focus: str
+    language: str
+    max_parallel: int
+    max_words: int
+    metadata: Dict[str, str]
+    model_hint: str
+    overlap_tokens: int
+    pipeline_id: str
+    reduce_model_hint: str
+    split: str
+    text: str
+
+
+class SummarizeResult(TypedDict, total=False):
+    chunk_tokens: int
+    chunks: List["SummaryChunk"]
+    error: str
+    latency_ms: int
+    metadata: Dict[str, str]
+    pipeline_id: str
+    reduce: "PipelineStepResult"
+    run_url: str
+    success: bool
+    summary: str
+
+
+class SummaryChunk(TypedDict, total=False):
+    error: str
+    index: int
+    latency_ms: int
+    model_used: str
+    routed_to: str
+    success: bool
+    summary: str
+    task_id: str
+    tokens: int
+
+
+class SwitchoverRequest(TypedDict, total=False):
+    url: str
+
+
+class SwitchoverResponse(TypedDict, total=False):
+    clients: int
+    url: str
+
+
+class TaskAttempt(TypedDict, total=False):
+    error: str
+    error_code: str
+    latency_ms: int
+    model: str
+    node_id: str
+
+
+class TaskBundle(TypedDict, total=False):
+    bundle_id: str
+    issued_at: int
+    node_id: str
+    tasks: List["TaskRequest"]
+
+
+class TaskChunk(TypedDict, total=False):
+    dedup_of: str
+    deduplicated: bool
+    done: bool
+    error: str
+    json_repaired: bool
+    latency_ms: int
+    metadata: Dict[str, str]
+    routed_to: str
+    task_id: str
+    text: str
+    timings: "TaskTimings"
+    token: str
+    transfer: "TaskTransfer"
+    verification: "Verification"
+
+
+class TaskFeedback(TypedDict, total=False):
+    comment: str
+    corrected_output: str
+    created_at: int
+    model: str
+    node_id: str
+    rating: str
+    source: "TaskSource"
+    task_id: str
+    task_type: "TaskType"
+    updated_at: int
+
+
+class TaskHistoryPage(TypedDict, total=False):
+    count: int
+    next_cursor: str
+    tasks: List["TaskRecord"]
+
+
+class TaskLineage(TypedDict, total=False):
+    attempt: int
+    item: int
+    pipeline_id: str
+    step_index: int
+
+
+class TaskLineageRecord(TypedDict, total=False):
+    feedback: "TaskFeedback"
+    lineage: "TaskLineage"
+    run_url: str
+    step: "PipelineStepResult"
+    task_id: str
+    tree: "LineageNode"
+
+
+class TaskRecord(TypedDict, total=False):
+    completion_tokens: int
+    content: str
+    error: str
+    failed_attempts: int
+    latency_ms: int
+    metadata: Dict[str, str]
+    model_hint: str
+    model_used: str
+    pipeline_id: str
+    prompt: str
+    prompt_tokens: int
+    routed_to: str
+    source: "TaskSource"
+    stream: bool
+    success: bool
+    task_id: str
+    timestamp: int
+    type: "TaskType"
+
+
+class TaskRequest(TypedDict, total=False):
+    allow_cloud: bool
+    compress: "CompressOptions"
+    files: List[str]
+    format: "OutputFormat"
+    input: List[str]
+    keep_alive: str
+    language: str
+    lineage: "TaskLineage"
+    messages: List["ChatMessage"]
+    metadata: Dict[str, str]
+    min_quality: str
+    model_hint: str
+    no_dedup: bool
+    options: Dict[str, object]
+    prompt: str
+    queue_timeout_ms: int
+    snapshot_interval_ms: int
+    source: "TaskSource"
+    stream_granularity: "StreamGranularity"
+    stream_mode: "StreamMode"
+    target_node: str
+    task_id: str
+    timeout_ms: int
+    type: "TaskType"
+    verify: bool
+
+
+class TaskResult(TypedDict, total=False):
+    attempts: List["TaskAttempt"]
+    checksum: str
+    completion_tokens: int
+    content: str
+    dedup_of: str
+    deduplicated: bool
+    embed_timings: "EmbedTimings"
+    embeddings: List[List[float]]
+    error: str
+    error_code: str
+    json_repaired: bool
+    latency_ms: int
+    lineage: "TaskLineage"
+    metadata: Dict[str, str]
+    model_fallback: "ModelFallback"
+    model_used: str
+    partial: bool
+    prompt_tokens: int
+    routed_to: str
+    source: "TaskSource"
+    success: bool
+    task_id: str
+    task_type: "TaskType"
+    timings: "TaskTimings"
+    transfer: "TaskTransfer"
+    verification: "Verification"
+
+
+class TaskSource(TypedDict, total=False):
+    key: str
+    remote_ip: str
+    user_agent: str
+
+
+class TaskTimeout(TypedDict, total=False):
+    adaptive: bool
+    model: str
+    node_id: str
+    p99_ms: int
+    samples: int
+    timed_out: int
+    timeout_ms: int
+
+
+class TaskTimings(TypedDict, total=False):
+    first_token_ms: int
+    generation_ms: int
+    load_ms: int
+    queue_ms: int
+    tokens_per_sec: float
+
+
+class TaskTransfer(TypedDict, total=False):
+    received_bytes: int
+    sent_bytes: int
+
+
+class TemplateList(TypedDict, total=False):
+    count: int
+    templates: List["PipelineTemplate"]
+
+
+class TenantShare(TypedDict, total=False):
+    fair_share: float
+    gpu_seconds: float
+    held: int
+    overdue: int
+    share: float
+    tasks: int
+    tenant: str
+    total_gpu_seconds: float
+    wait_ms: int
+    waiting: int
+    weight: float
+
+
+class TextChunk(TypedDict, total=False):
+    index: int
+    text: str
+    tokens: int
+
+
+class Thermal(TypedDict, total=False):
+    cpu_temp_c: float
+    gpu_temp_c: float
+    state: "ThermalState"
+
+
+class TimeoutStats(TypedDict, total=False):
+    default_ms: int
+    enabled: bool
+    min_ms: int
+    timeouts: List["TaskTimeout"]
+
+
+class Topology(TypedDict, total=False):
+    generated_at: int
+    links: List["TopologyLink"]
+    nodes: List["TopologyNode"]
+
+
+TopologyLink = TypedDict("TopologyLink", {
+    "from": str,
+    "loss": float,
+    "mutual": bool,
+    "reachable": bool,
+    "rtt_ms": float,
+    "to": str,
+}, total=False)
+
+
+class TopologyNode(TypedDict, total=False):
+    health: "HealthGrade"
+    link: "LinkQuality"
+    node_id: str
+    reported_at: int
+    reporting: bool
+    status: "NodeStatus"
+
+
+class Verification(TypedDict, total=False):
+    confidence: float
+    error: str
+    issues: str
+    latency_ms: int
+    model: str
+    node_id: str
+    same_model: bool
+    verdict: str
+
+
+class VersionInfo(TypedDict, total=False):
+    api_version: int
+    arch: str
+    build_date: str
+    commit: str
+    go_version: str
+    os: str
+    version: str
+
+
+class WorkItem(TypedDict, total=False):
+    headers: Dict[str, str]
+    request: "TaskRequest"
+    work_id: str
+
+
+class WorkResult(TypedDict, total=False):
+    node_id: str
+    result: "TaskResult"
+    work_id: str
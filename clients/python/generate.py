@@ -0,0 +1,100 @@
+#!/usr/bin/env python3
+"""Regenerate echo_mesh/models.py from the orchestrator's OpenAPI spec.
+
+    python generate.py                                   # http://localhost:8080/openapi.json
+    python generate.py --spec http://mesh:8080/openapi.json
+    python generate.py --spec openapi.json               # a saved copy
+
+Every object schema under components/schemas becomes a TypedDict
+(total=False, as the spec marks no field required) and every string enum a
+Literal alias. Objects with a field named like a Python keyword ("from")
+use the functional TypedDict syntax.
+"""
+
+import argparse
+import json
+import keyword
+import os
+import urllib.request
+
+HEADER = '''"""Types of the Echo System orchestrator API.
+
+Code generated by generate.py from {source}; DO NOT EDIT.
+"""
+
+from typing import Dict, List, Literal, TypedDict
+'''
+
+
+def load_spec(source):
+    if source.startswith(("http://", "https://")):
+        with urllib.request.urlopen(source) as resp:
+            return json.load(resp)
+    with open(source) as f:
+        return json.load(f)
+
+
+def annotation(schema):
+    if "$ref" in schema:
+        return '"%s"' % schema["$ref"].rsplit("/", 1)[1]
+    kind = schema.get("type")
+    if kind == "string":
+        if "enum" in schema:
+            return "Literal[%s]" % ", ".join(repr(v) for v in schema["enum"])
+        return "str"
+    if kind == "integer":
+        return "int"
+    if kind == "number":
+        return "float"
+    if kind == "boolean":
+        return "bool"
+    if kind == "array":
+        return "List[%s]" % annotation(schema["items"])
+    if kind == "object" and "additionalProperties" in schema:
+        return "Dict[str, %s]" % annotation(schema["additionalProperties"])
+    return "object"
+
+
+def render(spec, source):
+    aliases, classes = [], []
+    for name, schema in sorted(spec["components"]["schemas"].items()):
+        if schema.get("type") != "object":
+            aliases.append("%s = %s" % (name, annotation(schema)))
+            continue
+        props = schema.get("properties", {})
+        if any(keyword.iskeyword(field) for field in props):
+            lines = ['%s = TypedDict("%s", {' % (name, name)]
+            for field, prop in props.items():
+                lines.append('    "%s": %s,' % (field, annotation(prop)))
+            lines.append("}, total=False)")
+        else:
+            lines = ["class %s(TypedDict, total=False):" % name]
+            for field, prop in props.items():
+                lines.append("    %s: %s" % (field, annotation(prop)))
+            if len(lines) == 1:
+                lines.append("    pass")
+        classes.append("\n".join(lines))
+
+    if "://" not in source:
+        source = os.path.basename(source)
+    out = [HEADER.format(source=source)]
+    out.extend(aliases)
+    out.append("")
+    out.extend("\n" + c + "\n" for c in classes)
+    return "\n".join(out)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--spec", default="http://localhost:8080/openapi.json", help="spec URL or file")
+    parser.add_argument("--out", default=os.path.join(os.path.dirname(__file__), "echo_mesh", "models.py"))
+    args = parser.parse_args()
+
+    code = render(load_spec(args.spec), args.spec)
+    with open(args.out, "w") as f:
+        f.write(code)
+    print("wrote", args.out)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,872 @@
+// meshsim/agent.go
+// In-process mock node-agents.
+//
+// A mockAgent speaks the real agent protocol — it registers, heartbeats
+// every second and serves /execute and /execute/stream (streams can be
+// reattached to with GET /execute/stream/{id}) and /exec, or in pull mode
+// polls GET /work — but answers
+// instantly (or after a configured delay) with a canned response instead
+// of calling Ollama. Scenarios flip its behaviour at runtime to simulate
+// failing, slow or silent nodes.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+// behaviour is how a mock agent answers tasks.
+type behaviour int32
+
+const (
+	behaveOK     behaviour = iota // answer normally
+	behaveFail                    // answer 500 with a non-JSON body (triggers failover)
+	behaveSilent                  // stop heartbeating; still answers if reached
+	behaveOOM                     // fail tasks with an OOM error code, as if the model didn't fit
+	behaveFull                    // refuse tasks with 409, as if its -memory-budget were used up
+	behaveHang                    // take tasks and never answer, as a wedged backend does
+)
+
+// mockAgent is one simulated node.
+type mockAgent struct {
+	id     string
+	model  string
+	types  []shared.TaskType
+	delay  time.Duration
+	orch   string
+	server *http.Server
+	port   int
+
+	exclusive bool               // declare the model exclusive (one generation at a time)
+	languages []string           // languages declared for the model
+	tier      shared.QualityTier // size class declared for the model
+	busyAt    int                // busy threshold declared; 0 = the default
+	pull      bool               // registered in pull mode: fetches tasks from GET /work
+	canary    bool               // registered as a canary: only targeted tasks reach it
+	exec      []string           // languages declared for exec steps
+	key       ed25519.PrivateKey // signs registrations, as with an identity file; nil = unsigned
+
+	token     atomic.Value // session token (string) from the last registration
+	peers     atomic.Value // []shared.PeerLink reported in heartbeats, as if found over mDNS
+	thermal   atomic.Value // *shared.Thermal reported in heartbeats
+	link      atomic.Value // *shared.LinkQuality reported in heartbeats
+	resources atomic.Value // *shared.Resources reported in heartbeats
+	loaded    atomic.Value // []string models reported loaded in heartbeats
+	memory    atomic.Value // []shared.LoadedModel reported in heartbeats
+	tenant    atomic.Value // simPassHeader of the last task, passed through by the orchestrator
+	options   atomic.Value // map[string]any generation options of the last task
+
+	mode      atomic.Int32
+	active    atomic.Int64
+	maxActive atomic.Int64 // highest concurrency seen
+	executed  atomic.Int64
+	keptHot   atomic.Int64 // tasks received with a keep_alive
+	conns     atomic.Int64 // connections accepted
+	skewMs    atomic.Int64 // how far ahead the agent's clock runs
+	unchecked atomic.Bool  // leave out when it last checked its models, as an agent that can't read its backend
+	modelsOff atomic.Bool  // answer GET /models with 503
+	corrupt   atomic.Int64 // results still to be sent with their content cut short
+	resends   atomic.Int64 // /execute?resend=1 requests
+	fetched   atomic.Int64 // task files fetched from the orchestrator
+	execRuns  atomic.Int64 // programs run through /exec
+
+	streams sync.Map // task ID → *mockStream
+
+	orphanMu sync.Mutex
+	orphans  []*shared.OrphanedTask // pipeline steps whose orchestrator went away
+	claimed  atomic.Int64           // orphans claimed with DELETE /orphans/{id}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// startAgent listens on an ephemeral port, registers with the orchestrator
+// and starts heartbeating.
+func startAgent(orch, id, model string, delay time.Duration, types ...shared.TaskType) (*mockAgent, error) {
+	a, err := listenAgent(orch, id, model, delay, types...)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.start(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// listenAgent starts a mock agent's HTTP server on an ephemeral port.
+func listenAgent(orch, id, model string, delay time.Duration, types ...shared.TaskType) (*mockAgent, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	a := &mockAgent{
+		id:    id,
+		model: model,
+		types: types,
+		delay: delay,
+		orch:  orch,
+		port:  ln.Addr().(*net.TCPAddr).Port,
+		stop:  make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /execute", a.handleExecute)
+	mux.HandleFunc("POST /execute/stream", a.handleExecuteStream)
+	mux.HandleFunc("GET /execute/stream/{id}", a.handleReattachStream)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /models", a.handleModels)
+	mux.HandleFunc("POST /exec", a.handleExec)
+	mux.HandleFunc("POST /selftest", a.handleSelfTest)
+	mux.HandleFunc("GET /orphans", a.handleOrphans)
+	mux.HandleFunc("DELETE /orphans/{id}", a.handleClaimOrphan)
+	a.server = &http.Server{Handler: mux, ConnState: func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			a.conns.Add(1)
+		}
+	}}
+	go a.server.Serve(ln)
+	return a, nil
+}
+
+// start registers the agent and starts heartbeating.
+func (a *mockAgent) start() error {
+	if err := a.register(); err != nil {
+		a.close()
+		return fmt.Errorf("register %s: %w", a.id, err)
+	}
+	go a.heartbeatLoop()
+	return nil
+}
+
+// restart stops the agent and starts it again on another port, as a new
+// process would: without its session token, with its identity key.
+func (a *mockAgent) restart() (*mockAgent, error) {
+	a.close()
+	b, err := listenAgent(a.orch, a.id, a.model, a.delay, a.types...)
+	if err != nil {
+		return nil, err
+	}
+	b.key = a.key
+	return b, b.start()
+}
+
+func (a *mockAgent) setMode(b behaviour) { a.mode.Store(int32(b)) }
+
+// corruptNext makes the agent cut the content of its next n results short
+// after computing their checksums, as a flaky link would.
+func (a *mockAgent) corruptNext(n int) { a.corrupt.Store(int64(n)) }
+
+// result is the agent's answer to req, with its checksum.
+func (a *mockAgent) result(req shared.TaskRequest, started time.Time) shared.TaskResult {
+	if len(req.Input) > 0 {
+		return a.embedResult(req, started)
+	}
+	content := a.answer(req)
+	res := shared.TaskResult{
+		TaskID:    req.TaskID,
+		Content:   content,
+		ModelUsed: a.model,
+		Success:   true,
+		Timings:   mockTimings(started),
+		Checksum:  shared.ContentChecksum(content),
+	}
+	if a.corrupt.Add(-1) >= 0 {
+		res.Content = content[:len(content)/2]
+	} else {
+		a.corrupt.Store(0)
+	}
+	return res
+}
+
+// embedResult embeds an embed task's input as a real agent would in
+// batches of 64: each text becomes [its length, its index], so scenarios
+// can check the vectors come back in order.
+func (a *mockAgent) embedResult(req shared.TaskRequest, started time.Time) shared.TaskResult {
+	t := &shared.EmbedTimings{Items: len(req.Input)}
+	vectors := make([][]float32, len(req.Input))
+	for i, text := range req.Input {
+		vectors[i] = []float32{float32(len(text)), float32(i)}
+		t.ItemMs = append(t.ItemMs, 0)
+		if i%64 == 0 {
+			t.Requests++
+			t.BatchMs = append(t.BatchMs, 0)
+		}
+	}
+	t.TotalMs = time.Since(started).Milliseconds()
+	return shared.TaskResult{
+		TaskID:       req.TaskID,
+		ModelUsed:    a.model,
+		Success:      true,
+		Embeddings:   vectors,
+		EmbedTimings: t,
+		Checksum:     shared.ContentChecksum(""),
+	}
+}
+
+// setPeers makes the agent report seeing others, each reachable with the
+// given RTT.
+func (a *mockAgent) setPeers(rttMs float64, others ...*mockAgent) {
+	links := []shared.PeerLink{}
+	for _, o := range others {
+		links = append(links, shared.PeerLink{NodeID: o.id, Addr: fmt.Sprintf("127.0.0.1:%d", o.port), Reachable: true, RTTMs: rttMs})
+	}
+	a.peers.Store(links)
+}
+
+// setThermal makes the agent report a CPU temperature and thermal state.
+func (a *mockAgent) setThermal(state shared.ThermalState, cpuTempC float64) {
+	a.thermal.Store(&shared.Thermal{CPUTempC: cpuTempC, State: state})
+}
+
+// setLink makes the agent report its link to the orchestrator.
+func (a *mockAgent) setLink(rttMs, jitterMs, loss float64) {
+	a.link.Store(&shared.LinkQuality{RTTMs: rttMs, JitterMs: jitterMs, Loss: loss, Probes: 60})
+}
+
+// setLoaded makes the agent report models as loaded in its backend.
+func (a *mockAgent) setLoaded(models ...string) {
+	a.loaded.Store(models)
+}
+
+// setLoadedMemory makes the agent report models as loaded with what they
+// hold, as an Ollama agent does.
+func (a *mockAgent) setLoadedMemory(models ...shared.LoadedModel) {
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = m.Name
+	}
+	a.memory.Store(models)
+	a.loaded.Store(names)
+}
+
+// setDisk makes the agent report the free and total space of its models
+// volume.
+func (a *mockAgent) setDisk(free, total uint64) {
+	a.resources.Store(&shared.Resources{DiskFreeBytes: free, DiskTotalBytes: total})
+}
+
+// setClockSkew makes the agent's clock run ahead by d (behind if negative).
+func (a *mockAgent) setClockSkew(d time.Duration) { a.skewMs.Store(d.Milliseconds()) }
+
+// clock is the time on the agent's clock, in Unix ms.
+func (a *mockAgent) clock() int64 { return time.Now().UnixMilli() + a.skewMs.Load() }
+
+// setExclusive re-registers the agent with its model declared exclusive.
+func (a *mockAgent) setExclusive() error {
+	a.exclusive = true
+	return a.register()
+}
+
+// setLanguages re-registers the agent with its model declaring languages.
+func (a *mockAgent) setLanguages(langs ...string) error {
+	a.languages = langs
+	return a.register()
+}
+
+// setTier re-registers the agent with its model declaring a size class.
+func (a *mockAgent) setTier(tier shared.QualityTier) error {
+	a.tier = tier
+	return a.register()
+}
+
+// setBusyThreshold re-registers the agent declaring it busy from n
+// active tasks.
+func (a *mockAgent) setBusyThreshold(n int) error {
+	a.busyAt = n
+	return a.register()
+}
+
+// setCanary re-registers the agent as a canary node.
+func (a *mockAgent) setCanary() error {
+	a.canary = true
+	return a.register()
+}
+
+// setExec re-registers the agent declaring a sandbox for languages.
+func (a *mockAgent) setExec(langs ...string) error {
+	a.exec = langs
+	return a.register()
+}
+
+// setIdentity re-registers the agent signing with key.
+func (a *mockAgent) setIdentity(key ed25519.PrivateKey) error {
+	a.key = key
+	return a.register()
+}
+
+// setPull re-registers the agent in pull mode and stops its HTTP server,
+// so tasks only reach it through GET /work.
+func (a *mockAgent) setPull() error {
+	a.pull = true
+	a.server.Close()
+	if err := a.register(); err != nil {
+		return err
+	}
+	go a.pullLoop()
+	return nil
+}
+
+// pullLoop fetches and answers tasks until the agent is closed.
+func (a *mockAgent) pullLoop() {
+	for {
+		select {
+		case <-a.stop:
+			return
+		default:
+		}
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/work?node_id=%s&wait=1", a.orch, a.id), nil)
+		req.Header.Set("Authorization", "Bearer "+a.session())
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		var item shared.WorkItem
+		ok := resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&item) == nil
+		resp.Body.Close()
+		if !ok {
+			continue
+		}
+		a.tenant.Store(item.Headers[simPassHeader])
+		end := a.begin(item.Request)
+		started := time.Now()
+		time.Sleep(a.delay)
+		end()
+		// Posted again when the orchestrator refuses it, as agents do
+		for attempt := 0; attempt < 3; attempt++ {
+			err := sendJSON("POST", a.orch+"/results/ingest", a.session(), shared.WorkResult{
+				WorkID: item.WorkID,
+				NodeID: a.id,
+				Result: a.result(item.Request, started),
+			}, nil)
+			if err == nil || !strings.Contains(err.Error(), "422") {
+				break
+			}
+			a.resends.Add(1)
+		}
+	}
+}
+
+// begin marks a generation as running and returns its end function.
+func (a *mockAgent) begin(req shared.TaskRequest) func() {
+	if req.KeepAlive != "" {
+		a.keptHot.Add(1)
+	}
+	a.options.Store(req.Options)
+	n := a.active.Add(1)
+	for {
+		max := a.maxActive.Load()
+		if n <= max || a.maxActive.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	a.executed.Add(1)
+	return func() { a.active.Add(-1) }
+}
+
+func (a *mockAgent) register() error {
+	req := shared.RegisterRequest{
+		NodeID:        a.id,
+		AgentHost:     "127.0.0.1",
+		AgentPort:     a.port,
+		Models:        []string{a.model},
+		Capabilities:  []shared.ModelCapability{{Name: a.model, Types: a.types, Exclusive: a.exclusive, Languages: a.languages, Tier: a.tier}},
+		Canary:        a.canary,
+		Exec:          a.exec,
+		Status:        shared.StatusIdle,
+		BusyThreshold: a.busyAt,
+		Pull:          a.pull,
+		Time:          a.clock(),
+		Version:       simAgentVersion,
+		APIVersion:    shared.MeshAPIVersion,
+	}
+	if a.key != nil {
+		req.IdentityKey = base64.StdEncoding.EncodeToString(a.key.Public().(ed25519.PublicKey))
+		req.IdentityProof = shared.SignIdentityProof(a.key, &req)
+	}
+	var resp shared.RegisterResponse
+	if err := sendJSON("POST", a.orch+"/register", a.session(), req, &resp); err != nil {
+		return err
+	}
+	a.token.Store(resp.SessionToken)
+	return nil
+}
+
+// session returns the agent's current session token.
+func (a *mockAgent) session() string {
+	token, _ := a.token.Load().(string)
+	return token
+}
+
+// heartbeatLoop reports status every second until the agent is closed.
+// Silent agents skip beats, and re-register when they come back — the
+// orchestrator may have evicted them meanwhile.
+func (a *mockAgent) heartbeatLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	wasSilent := false
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		if behaviour(a.mode.Load()) == behaveSilent {
+			wasSilent = true
+			continue
+		}
+		if wasSilent {
+			a.register()
+			wasSilent = false
+		}
+		active := int(a.active.Load())
+		status := shared.StatusIdle
+		if active > 0 {
+			status = shared.StatusBusy
+		}
+		peers, _ := a.peers.Load().([]shared.PeerLink)
+		thermal, _ := a.thermal.Load().(*shared.Thermal)
+		link, _ := a.link.Load().(*shared.LinkQuality)
+		resources, _ := a.resources.Load().(*shared.Resources)
+		loaded, _ := a.loaded.Load().([]string)
+		memory, _ := a.memory.Load().([]shared.LoadedModel)
+		err := sendJSON("POST", a.orch+"/heartbeat", a.session(), shared.HeartbeatRequest{
+			NodeID:      a.id,
+			Status:      status,
+			ActiveTasks: active,
+			Peers:       peers,
+			Resources:   resources,
+			Thermal:     thermal,
+			Link:        link,
+			Loaded:      loaded,
+			Time:        a.clock(),
+
+			LoadedMemory:        memory,
+			CapabilitiesChecked: a.capabilitiesChecked(),
+			Version:             simAgentVersion,
+			APIVersion:          shared.MeshAPIVersion,
+		}, nil)
+		if err != nil && strings.HasPrefix(err.Error(), "404") {
+			// A restarted orchestrator has forgotten us, as a real agent finds
+			a.register()
+		}
+	}
+}
+
+// changeCapabilities sends a heartbeat carrying d, as an agent does when
+// its models change.
+func (a *mockAgent) changeCapabilities(d shared.CapabilityDelta) error {
+	return sendJSON("POST", a.orch+"/heartbeat", a.session(), shared.HeartbeatRequest{
+		NodeID:            a.id,
+		Status:            shared.StatusIdle,
+		Time:              a.clock(),
+		CapabilityChanges: &d,
+		Version:           simAgentVersion,
+		APIVersion:        shared.MeshAPIVersion,
+	}, nil)
+}
+
+// capabilitiesChecked is when the agent last checked its models: just now
+// on its clock, unless it's set not to say.
+func (a *mockAgent) capabilitiesChecked() int64 {
+	if a.unchecked.Load() {
+		return 0
+	}
+	return a.clock()
+}
+
+// handleModels lists the agent's model, as last used just now on its clock.
+// handleExec pretends to run a program: code containing "raise" exits 1
+// with a traceback, anything else prints "ran: " and the code.
+func (a *mockAgent) handleExec(w http.ResponseWriter, r *http.Request) {
+	var req shared.ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	a.execRuns.Add(1)
+	result := shared.ExecResult{ExecID: req.ExecID, NodeID: a.id, Stdout: "ran: " + req.Code}
+	if strings.Contains(req.Code, "raise") {
+		result.ExitCode, result.Stdout, result.Stderr = 1, "", "Traceback (most recent call last):\nValueError"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (a *mockAgent) handleModels(w http.ResponseWriter, r *http.Request) {
+	if a.modelsOff.Load() {
+		http.Error(w, "backend unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shared.ModelListResponse{
+		Models:  []string{a.model},
+		Details: []shared.ModelDetail{{Name: a.model, Declared: true, LastUsed: a.clock()}},
+	})
+}
+
+// handleSelfTest reports the checks of the real agent's POST /selftest: its
+// model fails while the agent is set to fail, and its clock is off by the
+// simulated skew.
+func (a *mockAgent) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	var req shared.SelfTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	report := shared.SelfTestReport{NodeID: a.id, OK: true, Backend: "ollama", Version: shared.Version, StartedAt: a.clock()}
+	clock := shared.SelfTestCheck{Check: "clock", Status: shared.SelfTestOK, Message: "in sync"}
+	if skew := a.clock() - req.ReferenceMs; req.ReferenceMs > 0 && (skew > req.MaxSkewMs || skew < -req.MaxSkewMs) {
+		clock.Status, clock.Message = shared.SelfTestWarn, fmt.Sprintf("%dms off the caller's clock", skew)
+	}
+	model := shared.SelfTestCheck{Check: "model", Model: a.model, Status: shared.SelfTestOK, Message: "generated a token", LatencyMs: 1}
+	if behaviour(a.mode.Load()) == behaveFail {
+		model.Status, model.Message, report.OK = shared.SelfTestFail, "simulated failure", false
+	}
+	report.Checks = []shared.SelfTestCheck{
+		clock,
+		{Check: "backend", Status: shared.SelfTestOK, Message: "ollama answers with 1 installed models"},
+		model,
+		{Check: "disk", Status: shared.SelfTestOK, Message: "plenty free"},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// close stops heartbeats and the HTTP server.
+func (a *mockAgent) close() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		a.server.Close()
+	})
+}
+
+func (a *mockAgent) handleExecute(w http.ResponseWriter, r *http.Request) {
+	var req shared.TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if behaviour(a.mode.Load()) == behaveFail {
+		http.Error(w, "simulated failure", http.StatusInternalServerError)
+		return
+	}
+
+	if behaviour(a.mode.Load()) == behaveOOM {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared.TaskResult{
+			TaskID:    req.TaskID,
+			ModelUsed: a.model,
+			Error:     "model requires more system memory than is available",
+			ErrorCode: shared.ErrCodeOOM,
+		})
+		return
+	}
+	if behaviour(a.mode.Load()) == behaveFull {
+		a.refuse(w, req)
+		return
+	}
+
+	if behaviour(a.mode.Load()) == behaveHang {
+		<-r.Context().Done()
+		return
+	}
+
+	if err := a.attachFiles(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.tenant.Store(r.Header.Get(simPassHeader))
+	started := time.Now()
+	if r.URL.Query().Get("resend") != "" {
+		// Kept from the first reply, as a real agent does: no generation
+		a.resends.Add(1)
+	} else {
+		defer a.begin(req)()
+		if req.Lineage == nil {
+			time.Sleep(a.delay)
+		} else if !a.waitStep(r.Context(), req, started) {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.result(req, started))
+}
+
+// waitStep sleeps through a pipeline step's delay. If the orchestrator
+// goes away first the step finishes as an orphan, as on a real agent, and
+// false is returned.
+func (a *mockAgent) waitStep(ctx context.Context, req shared.TaskRequest, started time.Time) bool {
+	timer := time.NewTimer(a.delay)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+	}
+	orphan := &shared.OrphanedTask{TaskID: req.TaskID, Lineage: req.Lineage, Status: shared.OrphanRunning, StartedAt: a.clock()}
+	a.orphanMu.Lock()
+	a.orphans = append(a.orphans, orphan)
+	a.orphanMu.Unlock()
+	go func() {
+		<-timer.C
+		result := a.result(req, started)
+		a.orphanMu.Lock()
+		defer a.orphanMu.Unlock()
+		orphan.Status, orphan.Result, orphan.FinishedAt = shared.OrphanDone, &result, a.clock()
+	}()
+	return false
+}
+
+func (a *mockAgent) handleOrphans(w http.ResponseWriter, r *http.Request) {
+	a.orphanMu.Lock()
+	list := shared.OrphanList{NodeID: a.id, Tasks: []shared.OrphanedTask{}}
+	for _, o := range a.orphans {
+		list.Tasks = append(list.Tasks, *o)
+	}
+	a.orphanMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (a *mockAgent) handleClaimOrphan(w http.ResponseWriter, r *http.Request) {
+	a.orphanMu.Lock()
+	defer a.orphanMu.Unlock()
+	for i, o := range a.orphans {
+		if o.TaskID == r.PathValue("id") {
+			a.orphans = append(a.orphans[:i], a.orphans[i+1:]...)
+			a.claimed.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "no such orphaned task", http.StatusNotFound)
+}
+
+// handleExecuteStream answers with one NDJSON chunk per word of the reply.
+func (a *mockAgent) handleExecuteStream(w http.ResponseWriter, r *http.Request) {
+	var req shared.TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if behaviour(a.mode.Load()) == behaveFail {
+		http.Error(w, "simulated failure", http.StatusInternalServerError)
+		return
+	}
+	if behaviour(a.mode.Load()) == behaveFull {
+		a.refuse(w, req)
+		return
+	}
+
+	if err := a.attachFiles(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Like the real agent, generation outlives the orchestrator's connection
+	st := &mockStream{}
+	a.streams.Store(req.TaskID, st)
+	go func() {
+		defer a.begin(req)()
+		started := time.Now()
+		for _, word := range strings.Fields(a.answer(req)) {
+			st.add(word + " ")
+			time.Sleep(a.delay / 10)
+		}
+		st.finish(shared.TaskChunk{TaskID: req.TaskID, Done: true, RoutedTo: a.id, Timings: mockTimings(started)})
+	}()
+	a.follow(w, r, req.TaskID, st)
+}
+
+// refuse answers like an agent whose memory budget has no room for the
+// task's model.
+func (a *mockAgent) refuse(w http.ResponseWriter, req shared.TaskRequest) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(shared.TaskResult{
+		TaskID:    req.TaskID,
+		ModelUsed: a.model,
+		Error:     a.model + " (5.0 GiB) would exceed the memory budget of 8.0 GiB alongside sim-other (4.0 GiB)",
+		ErrorCode: shared.ErrCodeMemoryBudget,
+	})
+}
+
+func (a *mockAgent) handleReattachStream(w http.ResponseWriter, r *http.Request) {
+	st, ok := a.streams.Load(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no such stream", http.StatusNotFound)
+		return
+	}
+	a.follow(w, r, r.PathValue("id"), st.(*mockStream))
+}
+
+// mockStream is a streamed task's words so far, and its done chunk once
+// it finished.
+type mockStream struct {
+	mu    sync.Mutex
+	words []string
+	final *shared.TaskChunk
+}
+
+func (st *mockStream) add(word string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.words = append(st.words, word)
+}
+
+func (st *mockStream) finish(final shared.TaskChunk) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.final = &final
+}
+
+// follow writes a stream's words from the first as NDJSON chunks, then
+// its done chunk, until it finishes or the client leaves.
+func (a *mockAgent) follow(w http.ResponseWriter, r *http.Request, taskID string, st *mockStream) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	sent := 0
+	for {
+		st.mu.Lock()
+		words, final := st.words[sent:], st.final
+		st.mu.Unlock()
+		for _, word := range words {
+			enc.Encode(shared.TaskChunk{TaskID: taskID, Token: word, RoutedTo: a.id})
+		}
+		sent += len(words)
+		if final != nil {
+			enc.Encode(*final)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if final != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// mockTimings reports the whole simulated delay as generation: mock nodes
+// have no queue and answer their first token at once.
+func mockTimings(started time.Time) *shared.TaskTimings {
+	return &shared.TaskTimings{GenerationMs: time.Since(started).Milliseconds()}
+}
+
+// reply is the canned answer; it names the node so scenarios can check
+// which node produced a pipeline's output.
+func (a *mockAgent) reply(prompt string) string {
+	if len(prompt) > 40 {
+		prompt = prompt[:40]
+	}
+	return fmt.Sprintf("%s answered: %s", a.id, prompt)
+}
+
+// answer is the canned answer to req: reply, or for format=json tasks a
+// JSON document carrying the same. Prompts ending in "unterminated" get
+// the document without its closing brace, as if cut off. Checks of verify
+// tasks' answers fail answers saying "wrong" and pass the others.
+func (a *mockAgent) answer(req shared.TaskRequest) string {
+	if req.Metadata["echo.verify_for"] != "" {
+		_, answer, _ := strings.Cut(req.Prompt, "ANSWER:")
+		if strings.Contains(answer, "wrong") {
+			return `{"verdict": "fail", "confidence": 0.9, "issues": "the answer says it is wrong"}`
+		}
+		return `{"verdict": "pass", "confidence": 0.9, "issues": ""}`
+	}
+	if req.Format != shared.FormatJSON {
+		return a.reply(req.Prompt)
+	}
+	doc, _ := json.Marshal(map[string]string{"node": a.id, "answer": a.reply(req.Prompt)})
+	if strings.HasSuffix(req.Prompt, "unterminated") {
+		doc = doc[:len(doc)-1]
+	}
+	return string(doc)
+}
+
+// attachFiles fetches a task's files from the orchestrator and puts them
+// ahead of its prompt, as the real agent does (without its cache).
+func (a *mockAgent) attachFiles(req *shared.TaskRequest) error {
+	if len(req.Files) == 0 {
+		return nil
+	}
+	var files []shared.TaskFile
+	for _, id := range req.Files {
+		resp, err := httpClient.Get(a.orch + "/files/" + id)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			return fmt.Errorf("fetching file %s: %s", id, resp.Status)
+		}
+		a.fetched.Add(1)
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		files = append(files, shared.TaskFile{Name: params["filename"], Data: data})
+	}
+	prompt, _, err := shared.ExpandFiles(req.Prompt, files)
+	req.Prompt = prompt
+	return err
+}
+
+// postJSON posts body to url and decodes the response into out (if non-nil).
+func postJSON(url string, body, out any) error {
+	return sendJSON("POST", url, "", body, out)
+}
+
+// sendJSON sends body (nil = none) to url with token as the bearer token
+// (if non-empty) and decodes the response into out (if non-nil).
+func sendJSON(method, url, token string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return httpError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,113 @@
+// meshsim/cbor.go
+// A CBOR decoder for the events of dashboards that ask for the
+// echo-mesh.cbor subprotocol: the part of RFC 8949 the orchestrator
+// writes (integers, floats, text, arrays, maps, booleans and null).
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// decodeCBOR decodes a single CBOR item into the values encoding/json
+// produces, with integers as int64.
+func decodeCBOR(data []byte) (any, error) {
+	v, rest, err := readCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("cbor: %d bytes after the item", len(rest))
+	}
+	return v, nil
+}
+
+func readCBOR(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20, 21:
+			return info == 21, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		case 26:
+			if len(data) < 5 {
+				return nil, nil, fmt.Errorf("cbor: short float32")
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data[1:]))), data[5:], nil
+		case 27:
+			if len(data) < 9 {
+				return nil, nil, fmt.Errorf("cbor: short float64")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), data[9:], nil
+		}
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	n, data, err := readCBORArg(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		return int64(n), data, nil
+	case 1:
+		return -1 - int64(n), data, nil
+	case 3:
+		if uint64(len(data)) < n {
+			return nil, nil, fmt.Errorf("cbor: short text")
+		}
+		return string(data[:n]), data[n:], nil
+	case 4:
+		items := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item any
+			if item, data, err = readCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		m := make(map[string]any, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v any
+			if k, data, err = readCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("cbor: map key %v isn't text", k)
+			}
+			if v, data, err = readCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			m[key] = v
+		}
+		return m, data, nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// readCBORArg reads the argument of an item's head.
+func readCBORArg(info byte, data []byte) (uint64, []byte, error) {
+	size := map[byte]int{24: 1, 25: 2, 26: 4, 27: 8}[info]
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case size == 0:
+		return 0, nil, fmt.Errorf("cbor: unsupported argument %d", info)
+	case len(data) < size:
+		return 0, nil, fmt.Errorf("cbor: unexpected end")
+	}
+	var n uint64
+	for _, b := range data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, data[size:], nil
+}
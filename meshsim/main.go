@@ -0,0 +1,212 @@
+// meshsim/main.go
+// End-to-end harness: a simulated mesh driven against a real orchestrator.
+//
+// meshsim starts an orchestrator binary (or uses one already running),
+// spins up mock agents in-process and runs scripted scenarios — capability
+// routing, load spreading, failover, dead-lettering, draining, pipelines,
+// streaming, context shaping, routing weights, the API spec and eviction —
+// asserting where every task was routed. It exits non-zero if any
+// scenario fails, so it can gate CI:
+//
+//	go build -o bin/orchestrator ./orchestrator
+//	go run ./meshsim -orchestrator-bin bin/orchestrator
+//
+// With -agent-bin the scenarios that need a real node-agent run too (see
+// realagent.go); event-bus runs two orchestrator replicas of its own (see
+// replicas.go).
+//
+// The orchestrator always listens on :8080, so only one can run at a time.
+// Point meshsim at a dedicated orchestrator: scenarios evict their own
+// nodes when done, but any other registered node takes part in routing.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"echo-system/shared"
+)
+
+// httpClient bounds every call so a wedged orchestrator fails the run
+// instead of hanging it.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func main() {
+	os.Exit(run())
+}
+
+// run executes the harness and returns the process exit code; it's split
+// from main so deferred cleanup (stopping the orchestrator) runs.
+func run() int {
+	orchURL := flag.String("orchestrator", "http://localhost:8080", "Orchestrator base URL")
+	orchBin := flag.String("orchestrator-bin", "", "Start this orchestrator binary for the run (with a temporary -data-dir) instead of using a running one")
+	flag.StringVar(&eventBusURL, "event-bus", "", "Broker for the event-bus scenario's replicas, as their -event-bus (default: a Redis started in-process)")
+	flag.StringVar(&agentBin, "agent-bin", "", "node-agent binary for the scenarios that run a real agent against a fake Ollama (partial-timeout); they need -orchestrator-bin too and pass without running otherwise")
+	adminToken := flag.String("admin-token", "", "Admin token of the orchestrator, if it was started with -admin-token")
+	runFilter := flag.String("run", "", "Only run scenarios whose name matches this regular expression")
+	skipSlow := flag.Bool("short", false, "Skip slow scenarios (heartbeat eviction waits ~20s)")
+	list := flag.Bool("list", false, "List scenarios and exit")
+	flag.Parse()
+
+	if *list {
+		for _, sc := range scenarios {
+			fmt.Printf("%-22s %s\n", sc.name, sc.desc)
+		}
+		return 0
+	}
+
+	filter, err := regexp.Compile(*runFilter)
+	if err != nil {
+		log.Fatalf("[Sim] Invalid -run pattern: %v", err)
+	}
+
+	if *orchBin != "" {
+		orchestratorBin = *orchBin
+		stop, err := startOrchestrator(*orchBin, *orchURL)
+		if err != nil {
+			log.Fatalf("[Sim] %v", err)
+		}
+		defer stop()
+	} else if err := waitReady(*orchURL, 2*time.Second); err != nil {
+		log.Fatalf("[Sim] Orchestrator not reachable at %s: %v", *orchURL, err)
+	}
+
+	passed, failed, skipped := 0, 0, 0
+	for _, sc := range scenarios {
+		if !filter.MatchString(sc.name) || (*skipSlow && sc.slow) {
+			skipped++
+			continue
+		}
+		s := &sim{orch: *orchURL, adminToken: *adminToken, prefix: "sim-" + sc.name + "-"}
+		started := time.Now()
+		err := s.run(sc)
+		elapsed := time.Since(started).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %-22s %8s  %v\n", sc.name, elapsed, err)
+			continue
+		}
+		passed++
+		fmt.Printf("ok    %-22s %8s\n", sc.name, elapsed)
+	}
+
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// startOrchestrator launches bin with a throwaway data dir and waits until
+// it answers. The returned function stops it and removes the data dir.
+func startOrchestrator(bin, url string) (func(), error) {
+	if waitReady(url, 200*time.Millisecond) == nil {
+		return nil, fmt.Errorf("an orchestrator is already running at %s — stop it or drop -orchestrator-bin", url)
+	}
+	dir, err := os.MkdirTemp("", "meshsim-")
+	if err != nil {
+		return nil, err
+	}
+	logPath := filepath.Join(dir, "orchestrator.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	inventoryPath := filepath.Join(dir, "inventory.json")
+	if err := os.WriteFile(inventoryPath, []byte(simInventory), 0o644); err != nil {
+		return nil, err
+	}
+	templatesDir := filepath.Join(dir, "templates")
+	if err := os.Mkdir(templatesDir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(templatesDir, "sim-yaml.yaml"), []byte(simPipelineTemplate), 0o644); err != nil {
+		return nil, err
+	}
+
+	if alertHook, err = startAlertReceiver(); err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	launch := func() error {
+		cmd = exec.Command(bin, "-data-dir", filepath.Join(dir, "data"), "-fallback-models", simFallbackModels, "-inventory", inventoryPath,
+			"-alert-interval", "1s", "-alert-webhook", alertHook.url, "-fetch-allow", "127.0.0.1", "-pass-headers", simPassHeader,
+			"-client-keys", simClientKeyName+"="+simClientKey, "-task-options", simTaskOptions, "-context-windows", simContextWindows,
+			"-sse-keepalive", simSSEKeepAlive.String(), "-pipeline-templates", templatesDir, "-task-timeout-min", simTaskTimeoutMin.String(),
+			"-capability-ttl", simCapabilityTTL.String())
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("starting %s: %w", bin, err)
+		}
+		if err := waitReady(url, 10*time.Second); err != nil {
+			return fmt.Errorf("orchestrator did not come up: %w", err)
+		}
+		return nil
+	}
+	kill := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	stop := func() {
+		kill()
+		logFile.Close()
+		os.RemoveAll(dir)
+	}
+	if err := launch(); err != nil {
+		if cmd.Process != nil {
+			stop()
+		} else {
+			logFile.Close()
+		}
+		return nil, err
+	}
+	restartOrchestrator = func() error {
+		kill()
+		log.Printf("[Sim] Restarting %s", bin)
+		return launch()
+	}
+	log.Printf("[Sim] Started %s (log: %s)", bin, logPath)
+	return stop, nil
+}
+
+// restartOrchestrator kills the orchestrator meshsim started and starts it
+// again on the same data dir, as after a crash; nil when meshsim didn't
+// start it.
+var restartOrchestrator func() error
+
+// waitReady polls GET /status until it answers or timeout passes.
+func waitReady(url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := httpClient.Get(url + "/status")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// httpError turns a non-2xx response into an error carrying its message.
+func httpError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s", resp.Status, shared.ProblemMessage(body))
+}
@@ -0,0 +1,214 @@
+// meshsim/realagent.go
+// A real node-agent in front of a fake Ollama.
+//
+// Most scenarios only need the agent protocol, which mock agents speak.
+// What the agent does with its backend — stopping a generation when the
+// task's timeout_ms runs out and returning the words so far — needs the
+// real binary: given -agent-bin (and -orchestrator-bin, for an
+// orchestrator of its own with a short -task-timeout), a scenario runs
+// one against fakeOllama, which streams words as slowly as asked. Without
+// them those scenarios pass without running.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+// orchestratorBin and agentBin are -orchestrator-bin and -agent-bin.
+var orchestratorBin, agentBin string
+
+// fakeOllama serves the Ollama API calls an agent makes, for one model.
+// Generations stream words one per wordEvery; a silent backend takes
+// generations and never answers, as one still loading a model.
+type fakeOllama struct {
+	model     string
+	words     []string
+	wordEvery time.Duration
+	silent    atomic.Bool
+	server    *http.Server
+	port      int
+}
+
+// startFakeOllama serves model on a free port, streaming words.
+func startFakeOllama(model string, words []string, wordEvery time.Duration) (*fakeOllama, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	o := &fakeOllama{model: model, words: words, wordEvery: wordEvery, port: ln.Addr().(*net.TCPAddr).Port}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"version": "0.0.0-meshsim"})
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"models": []map[string]any{{"name": model}}})
+	})
+	mux.HandleFunc("GET /api/ps", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"models": []any{}})
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"model_info": map[string]any{}})
+	})
+	mux.HandleFunc("POST /api/generate", o.generate)
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	})
+	o.server = &http.Server{Handler: mux}
+	go o.server.Serve(ln)
+	return o, nil
+}
+
+// generate streams the words as NDJSON chunks, or answers a probe (stream
+// false) at once.
+func (o *fakeOllama) generate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Stream != nil && !*req.Stream {
+		json.NewEncoder(w).Encode(map[string]any{"model": o.model, "response": o.words[0], "done": true})
+		return
+	}
+	if o.silent.Load() {
+		<-r.Context().Done()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, word := range o.words {
+		if i > 0 {
+			word = " " + word
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(o.wordEvery):
+		}
+		enc.Encode(map[string]any{"model": o.model, "response": word, "done": false})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	enc.Encode(map[string]any{"model": o.model, "response": "", "done": true})
+}
+
+func (o *fakeOllama) close() { o.server.Close() }
+
+// freePort returns a TCP port nothing listens on at the moment.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// startProcess runs bin in dir with its output in dir/name.log. The
+// returned function kills it.
+func startProcess(dir, name, bin string, args ...string) (func(), error) {
+	logFile, err := os.Create(filepath.Join(dir, name+".log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("starting %s: %w", bin, err)
+	}
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		logFile.Close()
+	}, nil
+}
+
+// realMesh is an orchestrator of its own with one real agent registered.
+type realMesh struct {
+	orch   string // the orchestrator's base URL
+	nodeID string // the real agent's
+	stops  []func()
+	dir    string
+}
+
+// startRealMesh starts -orchestrator-bin with orchArgs on a free port and
+// a throwaway data dir, and -agent-bin as node nodeID serving the fake
+// backend's model, and waits for the agent to register.
+func startRealMesh(nodeID string, backend *fakeOllama, orchArgs ...string) (*realMesh, error) {
+	dir, err := os.MkdirTemp("", "meshsim-real-")
+	if err != nil {
+		return nil, err
+	}
+	m := &realMesh{nodeID: nodeID, dir: dir}
+	orch, stop, err := startReplica(dir, "orchestrator", orchArgs...)
+	if err != nil {
+		return nil, err // leaving its log
+	}
+	m.orch = orch
+	m.stops = append(m.stops, stop)
+
+	agentPort, err := freePort()
+	if err != nil {
+		m.close()
+		return nil, err
+	}
+	stop, err = startProcess(dir, "agent", agentBin, "-id", nodeID, "-identity", "", "-host", "127.0.0.1",
+		"-port", strconv.Itoa(agentPort), "-orchestrator", m.orch, "-ollama-host", "127.0.0.1", "-ollama-models-dir", dir,
+		"-ollama-port", strconv.Itoa(backend.port), "-models", backend.model, "-capabilities", backend.model+":text")
+	if err != nil {
+		m.close()
+		return nil, err
+	}
+	m.stops = append(m.stops, stop)
+	if err := m.waitRegistered(10 * time.Second); err != nil {
+		m.kill()
+		return nil, err
+	}
+	log.Printf("[Sim] Started %s and %s as %s (logs: %s)", orchestratorBin, agentBin, nodeID, dir)
+	return m, nil
+}
+
+// waitRegistered polls the orchestrator's GET /status until the real agent
+// is listed.
+func (m *realMesh) waitRegistered(timeout time.Duration) error {
+	s := &sim{orch: m.orch}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if node, err := s.node(m.nodeID); err == nil && node.Status != shared.StatusOffline {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("agent %s did not register within %v (see %s)", m.nodeID, timeout, filepath.Join(m.dir, "agent.log"))
+}
+
+// kill stops the agent and the orchestrator, leaving their logs.
+func (m *realMesh) kill() {
+	for i := len(m.stops) - 1; i >= 0; i-- {
+		m.stops[i]()
+	}
+}
+
+// close stops the agent and the orchestrator and removes their files.
+func (m *realMesh) close() {
+	m.kill()
+	os.RemoveAll(m.dir)
+}
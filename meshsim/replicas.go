@@ -0,0 +1,55 @@
+// meshsim/replicas.go
+// Orchestrators of a scenario's own, beside the one the run drives.
+//
+// A scenario that needs other orchestrator flags (a short -task-timeout) or
+// more than one orchestrator (replicas sharing -event-bus) starts
+// -orchestrator-bin itself, on a free port with a data dir under dir. The
+// broker for event-bus is -event-bus if given, otherwise an in-process
+// Redis (miniredis), so the scenario runs without one installed.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// eventBusURL is -event-bus.
+var eventBusURL string
+
+// startReplica starts -orchestrator-bin with args as name, logging to
+// dir/name.log, and waits until it answers. It returns its base URL and a
+// function that stops it.
+func startReplica(dir, name string, args ...string) (string, func(), error) {
+	port, err := freePort()
+	if err != nil {
+		return "", nil, err
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	args = append([]string{"-listen", fmt.Sprintf("127.0.0.1:%d", port), "-data-dir", filepath.Join(dir, name+"-data")}, args...)
+	stop, err := startProcess(dir, name, orchestratorBin, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := waitReady(url, 10*time.Second); err != nil {
+		stop()
+		return "", nil, fmt.Errorf("%s did not come up (see %s): %w", name, filepath.Join(dir, name+".log"), err)
+	}
+	return url, stop, nil
+}
+
+// startBroker returns the bus URL for replicas: -event-bus, or a Redis
+// started in-process. The returned function stops the latter.
+func startBroker() (string, func(), error) {
+	if eventBusURL != "" {
+		return eventBusURL, func() {}, nil
+	}
+	broker, err := miniredis.Run()
+	if err != nil {
+		return "", nil, err
+	}
+	return "redis://" + broker.Addr(), broker.Close, nil
+}
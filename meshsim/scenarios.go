@@ -0,0 +1,4623 @@
+// meshsim/scenarios.go
+// Scripted scenarios and the helpers they share.
+//
+// Every scenario gets its own agents, named "sim-<scenario>-<n>", and they
+// are closed and evicted from the orchestrator when it ends, so scenarios
+// don't see each other's nodes.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"echo-system/shared"
+)
+
+// scenario is one end-to-end check.
+type scenario struct {
+	name string
+	desc string
+	slow bool
+	run  func(s *sim) error
+}
+
+var scenarios = []scenario{
+	{name: "capability-routing", desc: "tasks go to the node declaring their type", run: capabilityRouting},
+	{name: "load-spread", desc: "concurrent tasks spread across equal nodes", run: loadSpread},
+	{name: "round-robin", desc: "round-robin strategy rotates through equal nodes", run: roundRobinRouting},
+	{name: "latency-weight", desc: "a latency routing weight steers tasks to the faster node", run: latencyWeight},
+	{name: "failover", desc: "tasks fail over from a failing node, which stops grading green", run: failover},
+	{name: "failover-report", desc: "failed attempts are reported in results and as stream events", run: failoverReport},
+	{name: "dead-letter", desc: "tasks failing everywhere are dead-lettered and retryable", run: deadLetter},
+	{name: "model-fallback", desc: "an OOM on the requested model falls back down its chain", run: modelFallback},
+	{name: "alias-rollout", desc: "an alias rollout rolls back on errors and promotes when healthy", run: aliasRollout},
+	{name: "dedup", desc: "identical concurrent tasks share one generation", run: dedupTasks},
+	{name: "pull-mode", desc: "pull-mode agents fetch tasks from GET /work and post results back", run: pullMode},
+	{name: "session-tokens", desc: "heartbeats and re-registration without the node's session token are refused", run: sessionTokens},
+	{name: "capability-delta", desc: "models added and removed through heartbeats route at once, without re-registering", run: capabilityDelta},
+	{name: "capability-ttl", desc: "models left unconfirmed past -capability-ttl go stale, and re-validating them drops the one gone from the backend", run: capabilityTTL},
+	{name: "topology", desc: "peers reported in heartbeats show up as links in GET /topology", run: topologyMap},
+	{name: "language-routing", desc: "tasks with a language hint prefer models declaring it", run: languageRouting},
+	{name: "min-quality", desc: "tasks with a min_quality only run on models of that size class or larger", run: minQuality},
+	{name: "thermal-shedding", desc: "nodes reporting they run hot get no tasks while others are free", run: thermalShedding},
+	{name: "link-quality", desc: "a node on a lossy link is graded poor, turns yellow and loses tasks to a wired one under the link weight", run: linkQuality},
+	{name: "pass-headers", desc: "client headers named in -pass-headers travel with tasks to push and pull agents", run: passHeaders},
+	{name: "versions", desc: "agents report their versions and ones speaking another mesh API are refused", run: versions},
+	{name: "task-options", desc: "-task-options defaults reach agents under the task's own options", run: taskOptions},
+	{name: "task-sources", desc: "tasks and pipeline runs are attributed to their client key, IP and user agent", run: taskSources},
+	{name: "memory-budget", desc: "tasks a node refuses for its memory budget go to another node, which isn't held against it", run: memoryBudget},
+	{name: "warm-routing", desc: "tasks go to the node with their model already loaded and count as warm hits; models past their unload time are cold", run: warmRouting},
+	{name: "canary", desc: "canary nodes get only tasks targeted at them, which never fail over", run: canaryNode},
+	{name: "clock-skew", desc: "nodes with a skewed clock are flagged and their times relayed in orchestrator time", run: clockSkew},
+	{name: "selftest", desc: "an admin runs a node's self-test remotely and gets its checks, clock compared to the orchestrator's", run: selfTest},
+	{name: "compress", desc: "a small model compresses long prompts and pipeline inputs before the large model runs", run: compress},
+	{name: "files", desc: "tasks reference uploaded files by ID and the agent fetches them", run: taskFiles},
+	{name: "inventory", desc: "inventory nodes show as absent until they register", run: inventoryNodes},
+	{name: "alerts", desc: "a node reporting low disk fires an alert that resolves once space is freed", run: alertRules},
+	{name: "drain", desc: "drained nodes get no new tasks", run: drain},
+	{name: "pipeline", desc: "pipeline steps route by type and carry lineage", run: pipeline},
+	{name: "pipeline-variables", desc: "{{var.*}} resolves from a pipeline's variables; undefined ones are refused", run: pipelineVariables},
+	{name: "pipeline-yaml", desc: "pipelines posted as YAML and templates loaded from YAML files run like JSON ones", run: pipelineYAML},
+	{name: "lineage", desc: "dead-letter retries and pipeline re-runs join the failed step's lineage tree", run: lineage},
+	{name: "share", desc: "share links serve one task result or pipeline run until they expire or are revoked", run: shareLinks},
+	{name: "pipeline-schedule", desc: "shortest-remaining gives a pipeline's last step a slot before a new pipeline's first", run: pipelineSchedule},
+	{name: "fair-share", desc: "under contention a light tenant's task goes before a heavy tenant's held ones", run: fairShare},
+	{name: "queue-admin", desc: "GET /admin/queue lists held tasks in order, and promoting or cancelling one changes what runs", run: queueAdmin},
+	{name: "node-queue", desc: "a task with no node waits at the orchestrator and runs once one registers, or fails with 503 after its queue timeout", run: nodeQueue},
+	{name: "pipeline-fetch", desc: "a fetch step hands a page's readable text to later steps, on allowed hosts only", run: pipelineFetch},
+	{name: "pipeline-exec", desc: "exec steps run code on sandbox nodes and have failing code fixed", run: pipelineExec},
+	{name: "pipeline-map", desc: "map steps fan items out across nodes in parallel", run: pipelineMap},
+	{name: "summarize", desc: "POST /summarize chunks a document, summarizes the chunks across nodes and combines them; POST /chunk cuts text the same way", run: summarize},
+	{name: "result-checksums", desc: "results damaged between agent and orchestrator are caught by their checksum and sent again once", run: resultChecksums},
+	{name: "stream", desc: "streamed tasks relay chunks and a final done chunk", run: stream},
+	{name: "stream-resume", desc: "a stream cut off by an orchestrator restart resumes from Last-Event-ID", run: streamResume},
+	{name: "async-task", desc: "POST /task/async answers at once and GET /task/{id} follows the task to its result or failure", run: asyncTask},
+	{name: "adaptive-timeout", desc: "a fast node that hangs fails over after its adaptive timeout rather than the full -task-timeout", run: adaptiveTimeout},
+	{name: "partial-timeout", desc: "a real agent out of time mid-answer returns the words so far as partial; one with no words yet fails over (needs -agent-bin)", run: partialTimeout},
+	{name: "agent-auth", desc: "a real agent refuses /exec, /pull and orphan claims without its session token; pulls through the orchestrator carry it (needs -agent-bin)", run: agentAuth},
+	{name: "event-bus", desc: "two orchestrator replicas sharing -event-bus: a dashboard on one sees the other's tasks, tagged with its replica (needs -orchestrator-bin)", run: eventBus},
+	{name: "pipeline-recovery", desc: "a pipeline interrupted by an orchestrator restart resumes with the step its node finished meanwhile", run: pipelineRecovery},
+	{name: "stream-granularity", desc: "sentence granularity batches streamed tokens into sentences", run: streamGranularity},
+	{name: "json-mode", desc: "format=json output that isn't valid JSON is repaired before the task ends", run: jsonMode},
+	{name: "verify", desc: "tasks with verify have another model check their answer, on /task and on the stream's done chunk", run: verifyTasks},
+	{name: "task-history", desc: "finished tasks are listed by GET /history, filtered, a page at a time, and survive a restart", run: taskHistory},
+	{name: "task-timings", desc: "agent timing splits reach results and node stats", run: taskTimings},
+	{name: "embed-batch", desc: "an embed task's inputs come back as vectors in order with batch timings, and input is refused where it can't be embedded", run: embedBatch},
+	{name: "transfer", desc: "results, final chunks and node stats count the bytes exchanged with agents", run: transfer},
+	{name: "back-to-back", desc: "tasks following each other on a node keep its model loaded and reuse connections", run: backToBack},
+	{name: "exclusive-model", desc: "tasks for an exclusive model never run concurrently", run: exclusiveModel},
+	{name: "context-shaping", desc: "long chats are summarized to fit the model window", run: contextShaping},
+	{name: "conversations", desc: "conversations keep their pinned model and node, and switching to a smaller window summarizes them once", run: conversationPins},
+	{name: "task-feedback", desc: "feedback on tasks is summed per model, type and node, and a thumbs down costs the node reputation once", run: taskFeedback},
+	{name: "sse-proxy", desc: "task streams turn off proxy buffering, open with a retry hint and get keep-alive comments while quiet", run: sseProxy},
+	{name: "openapi", desc: "every documented GET endpoint without required parameters answers", run: openAPI},
+	{name: "agent-identity", desc: "an agent's identity key takes its node back at once after a restart on another port, with its stats, and keeps others from claiming it", run: agentIdentity},
+	{name: "problem-json", desc: "errors are problem+json with a type, the task and whether to retry", run: problemJSON},
+	{name: "request-chain", desc: "CORS preflights list the route's methods and refuse unknown headers, and every request is counted per route", run: requestChain},
+	{name: "dashboard-queues", desc: "connected dashboards are listed with their event queue counters", run: dashboardQueues},
+	{name: "dashboard-cbor", desc: "dashboards asking for echo-mesh.cbor get the same events as smaller binary CBOR messages", run: dashboardCBOR},
+	{name: "switchover", desc: "an admin switchover sends dashboards the new primary's URL and disconnects them", run: switchoverDashboards},
+	{name: "eviction", desc: "silent nodes go offline, stop receiving tasks and lose availability", slow: true, run: eviction},
+}
+
+// sim is the per-scenario context.
+type sim struct {
+	orch       string
+	adminToken string
+	prefix     string
+	agents     []*mockAgent
+}
+
+// run executes a scenario and always cleans up its agents.
+func (s *sim) run(sc scenario) error {
+	defer s.cleanup()
+	return sc.run(s)
+}
+
+// agent starts a mock agent named after the scenario.
+func (s *sim) agent(model string, delay time.Duration, types ...shared.TaskType) (*mockAgent, error) {
+	id := fmt.Sprintf("%s%d", s.prefix, len(s.agents)+1)
+	a, err := startAgent(s.orch, id, model, delay, types...)
+	if err != nil {
+		return nil, err
+	}
+	s.agents = append(s.agents, a)
+	return a, nil
+}
+
+// agentsN starts n identical agents.
+func (s *sim) agentsN(n int, model string, delay time.Duration, types ...shared.TaskType) ([]*mockAgent, error) {
+	var list []*mockAgent
+	for i := 0; i < n; i++ {
+		a, err := s.agent(model, delay, types...)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+func (s *sim) cleanup() {
+	for _, a := range s.agents {
+		a.close()
+		s.admin("DELETE", "/admin/nodes/"+a.id, nil, nil)
+	}
+}
+
+// task runs a task through POST /task.
+func (s *sim) task(taskType shared.TaskType, prompt string) (*shared.TaskResult, error) {
+	return s.taskWithID("", taskType, prompt)
+}
+
+func (s *sim) taskWithID(taskID string, taskType shared.TaskType, prompt string) (*shared.TaskResult, error) {
+	var result shared.TaskResult
+	// Scenarios count tasks per node, so repeated prompts must each run
+	req := shared.TaskRequest{TaskID: taskID, Type: taskType, Prompt: prompt, NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return &result, fmt.Errorf("task %s failed: %s", result.TaskID, result.Error)
+	}
+	return &result, nil
+}
+
+// admin calls an admin endpoint with the configured token.
+func (s *sim) admin(method, path string, body, out any) error {
+	return sendJSON(method, s.orch+path, s.adminToken, body, out)
+}
+
+// nodeStatus returns a node's status as the orchestrator sees it.
+func (s *sim) nodeStatus(nodeID string) (shared.NodeStatus, error) {
+	node, err := s.node(nodeID)
+	if err != nil {
+		return "", err
+	}
+	return node.Status, nil
+}
+
+// node looks a node up in GET /status.
+func (s *sim) node(nodeID string) (*shared.NodeInfo, error) {
+	resp, err := httpClient.Get(s.orch + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var status struct {
+		Nodes []shared.NodeInfo `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	for i := range status.Nodes {
+		if status.Nodes[i].NodeID == nodeID {
+			return &status.Nodes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("node %s is not registered", nodeID)
+}
+
+// expectRoutedTo runs n sequential tasks and checks each landed on want.
+func (s *sim) expectRoutedTo(n int, taskType shared.TaskType, want *mockAgent) error {
+	for i := 0; i < n; i++ {
+		result, err := s.task(taskType, "route me")
+		if err != nil {
+			return err
+		}
+		if result.RoutedTo != want.id {
+			return fmt.Errorf("%s task %d routed to %s, want %s", taskType, i+1, result.RoutedTo, want.id)
+		}
+	}
+	return nil
+}
+
+// ─── Scenarios ────────────────────────────────────────────────────────────────
+
+func capabilityRouting(s *sim) error {
+	coder, err := s.agent("codellama", 0, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+	if err := s.expectRoutedTo(3, shared.TaskTypeCode, coder); err != nil {
+		return err
+	}
+	if err := s.expectRoutedTo(3, shared.TaskTypeText, writer); err != nil {
+		return err
+	}
+	return s.expectRoutedTo(2, shared.TaskTypeSummarize, writer)
+}
+
+func loadSpread(s *sim) error {
+	nodes, err := s.agentsN(3, "mistral", 400*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.task(shared.TaskTypeText, "spread me"); err != nil {
+				errs <- err
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	for _, a := range nodes {
+		if a.executed.Load() == 0 {
+			return fmt.Errorf("%s got no tasks out of 6 concurrent ones (%s)", a.id, executedSummary(nodes))
+		}
+	}
+	return nil
+}
+
+func roundRobinRouting(s *sim) error {
+	nodes, err := s.agentsN(3, "mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	var prev shared.RoutingConfig
+	if err := s.admin("GET", "/admin/routing", nil, &prev); err != nil {
+		return err
+	}
+	if err := s.admin("PUT", "/admin/routing", shared.RoutingConfig{Strategy: shared.StrategyRoundRobin}, nil); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/routing", prev, nil)
+
+	// In node ID order, each task going to the node after the last one's
+	ids := make([]string, len(nodes))
+	for i, a := range nodes {
+		ids[i] = a.id
+	}
+	slices.Sort(ids)
+	last := -1
+	for i := 0; i < 6; i++ {
+		res, err := s.task(shared.TaskTypeText, "rotate me")
+		if err != nil {
+			return err
+		}
+		at := slices.Index(ids, res.RoutedTo)
+		if last >= 0 && at != (last+1)%len(ids) {
+			return fmt.Errorf("task %d went to %s after %s, want the next node in ID order %v", i+1, res.RoutedTo, ids[last], ids)
+		}
+		last = at
+	}
+	for _, a := range nodes {
+		if a.executed.Load() != 2 {
+			return fmt.Errorf("6 tasks over 3 nodes not evenly rotated (%s)", executedSummary(nodes))
+		}
+	}
+	return nil
+}
+
+func latencyWeight(s *sim) error {
+	fast, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	slow, err := s.agent("mistral", 150*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	// Give each node a latency history by draining the other one
+	for _, pair := range [][2]*mockAgent{{fast, slow}, {slow, fast}} {
+		if err := s.admin("POST", "/admin/nodes/"+pair[1].id+"/drain", nil, nil); err != nil {
+			return err
+		}
+		if err := s.expectRoutedTo(2, shared.TaskTypeText, pair[0]); err != nil {
+			return err
+		}
+		if err := s.admin("DELETE", "/admin/nodes/"+pair[1].id+"/drain", nil, nil); err != nil {
+			return err
+		}
+	}
+
+	var prev shared.RoutingWeights
+	if err := s.admin("GET", "/admin/routing/weights", nil, &prev); err != nil {
+		return err
+	}
+	if err := s.admin("PUT", "/admin/routing/weights", shared.RoutingWeights{Latency: 1}, nil); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/routing/weights", prev, nil)
+	return s.expectRoutedTo(4, shared.TaskTypeText, fast)
+}
+
+func failover(s *sim) error {
+	bad, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	good, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	bad.setMode(behaveFail)
+	if err := s.expectRoutedTo(4, shared.TaskTypeText, good); err != nil {
+		return err
+	}
+
+	// The failures show in the failing node's health grade
+	node, err := s.node(bad.id)
+	if err != nil {
+		return err
+	}
+	if node.Health == shared.HealthGreen || node.HealthReason == "" {
+		return fmt.Errorf("failing node health %q (%q), want yellow or red with a reason", node.Health, node.HealthReason)
+	}
+	if node, err = s.node(good.id); err != nil {
+		return err
+	}
+	if node.Health != shared.HealthGreen {
+		return fmt.Errorf("healthy node health %q (%q), want green", node.Health, node.HealthReason)
+	}
+	return nil
+}
+
+// failoverReport hints models that only the failing agents declare, so
+// they're always tried first.
+func failoverReport(s *sim) error {
+	good, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	bad, err := s.agent("sim-big", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	bad.setMode(behaveFail)
+	var res shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-big", Prompt: "retry me", NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return err
+	}
+	if res.RoutedTo != good.id {
+		return fmt.Errorf("task routed to %s, want %s", res.RoutedTo, good.id)
+	}
+	if len(res.Attempts) != 1 || res.Attempts[0].NodeID != bad.id || res.Attempts[0].Error == "" {
+		return fmt.Errorf("attempts %+v, want one failure on %s", res.Attempts, bad.id)
+	}
+
+	badStream, err := s.agent("sim-huge", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	badStream.setMode(behaveFail)
+	data, _ := json.Marshal(shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-huge", Prompt: "stream elsewhere", NoDedup: true})
+	resp, err := httpClient.Post(s.orch+"/task/stream", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(resp)
+	}
+	// The other failing agent may be tried too before the stream reaches
+	// the good one
+	var failovers []shared.FailoverEvent
+	var final *shared.TaskChunk
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && final == nil {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if event == "failover" {
+			var f shared.FailoverEvent
+			if err := json.Unmarshal([]byte(payload), &f); err != nil {
+				return fmt.Errorf("bad failover event %q: %w", payload, err)
+			}
+			failovers = append(failovers, f)
+		} else {
+			var chunk shared.TaskChunk
+			if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+				return fmt.Errorf("bad chunk %q: %w", payload, err)
+			}
+			if chunk.Done {
+				final = &chunk
+			}
+		}
+		event = ""
+	}
+	if len(failovers) == 0 || failovers[0].FailedNode != badStream.id || failovers[0].Attempt != 1 ||
+		failovers[len(failovers)-1].NextNode != good.id {
+		return fmt.Errorf("failover events %+v, want %s first and %s last", failovers, badStream.id, good.id)
+	}
+	if final == nil || final.RoutedTo != good.id || final.Error != "" {
+		return fmt.Errorf("final chunk %+v, want a success from %s", final, good.id)
+	}
+	return nil
+}
+
+func deadLetter(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	a.setMode(behaveFail)
+
+	taskID := uuid.New().String()
+	if _, err := s.taskWithID(taskID, shared.TaskTypeText, "doomed"); err == nil {
+		return fmt.Errorf("task succeeded although its only node fails")
+	}
+
+	var dlq struct {
+		Tasks []shared.DeadLetter `json:"tasks"`
+	}
+	if err := s.admin("GET", "/admin/dlq", nil, &dlq); err != nil {
+		return err
+	}
+	var entry *shared.DeadLetter
+	for i := range dlq.Tasks {
+		if dlq.Tasks[i].Request.TaskID == taskID {
+			entry = &dlq.Tasks[i]
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("task %s not in the dead-letter queue", taskID)
+	}
+
+	// Once the node recovers (and its suspect mark clears on the next
+	// heartbeat) the retry must succeed and leave the queue
+	a.setMode(behaveOK)
+	time.Sleep(1500 * time.Millisecond)
+	var result shared.TaskResult
+	if err := s.admin("POST", "/admin/dlq/"+entry.ID+"/retry", nil, &result); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	if result.RoutedTo != a.id {
+		return fmt.Errorf("retry routed to %s, want %s", result.RoutedTo, a.id)
+	}
+	return nil
+}
+
+// simFallbackModels is the -fallback-models chain meshsim starts the
+// orchestrator with; against a running orchestrator, model-fallback needs it
+// set the same way.
+const simFallbackModels = "*=sim-large,sim-small"
+
+func modelFallback(s *sim) error {
+	large, err := s.agent("sim-large", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	small, err := s.agent("sim-small", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	large.setMode(behaveOOM)
+
+	var res shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-large", Prompt: "too big for you", NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return fmt.Errorf("%w (is the orchestrator running with -fallback-models %q?)", err, simFallbackModels)
+	}
+	if res.RoutedTo != small.id {
+		return fmt.Errorf("task routed to %s, want %s", res.RoutedTo, small.id)
+	}
+	want := shared.ModelFallback{From: "sim-large", To: "sim-small", NodeID: large.id, Reason: shared.ErrCodeOOM}
+	if res.ModelFallback == nil || *res.ModelFallback != want {
+		return fmt.Errorf("model_fallback %+v, want %+v", res.ModelFallback, want)
+	}
+	return nil
+}
+
+func aliasRollout(s *sim) error {
+	blue, err := s.agent("sim-blue", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	green, err := s.agent("sim-green", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	green.setMode(behaveOOM)
+
+	alias := s.prefix + "smart"
+	path := "/admin/aliases/" + alias
+	if err := s.admin("PUT", path, shared.ModelAlias{Target: "sim-blue"}, nil); err != nil {
+		return err
+	}
+	defer s.admin("DELETE", path, nil, nil)
+	rollout := shared.AliasRollout{Target: "sim-green", Percent: 50, MinSamples: 4}
+	if err := s.admin("POST", path+"/rollout", rollout, nil); err != nil {
+		return err
+	}
+
+	// Every task sent to the failing green model counts against it, so the
+	// rollout must roll back within a handful of tasks
+	var a shared.ModelAlias
+	for i := 0; i < 40; i++ {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: alias, Prompt: "hello", NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if a, err = s.alias(alias); err != nil {
+			return err
+		}
+		if a.Rollout != nil && a.Rollout.Status == shared.RolloutRolledBack {
+			break
+		}
+	}
+	if a.Rollout == nil || a.Rollout.Status != shared.RolloutRolledBack {
+		return fmt.Errorf("rollout not rolled back: %+v", a.Rollout)
+	}
+	if err := s.expectRoutedToModel(5, alias, blue); err != nil {
+		return fmt.Errorf("after rollback: %w", err)
+	}
+
+	// A healthy green at 100% takes all the traffic, then becomes the target
+	green.setMode(behaveOK)
+	rollout.Percent = 100
+	if err := s.admin("POST", path+"/rollout", rollout, nil); err != nil {
+		return err
+	}
+	if err := s.expectRoutedToModel(3, alias, green); err != nil {
+		return fmt.Errorf("at 100%%: %w", err)
+	}
+	var promoted shared.ModelAlias
+	if err := s.admin("POST", path+"/promote", nil, &promoted); err != nil {
+		return err
+	}
+	if promoted.Target != "sim-green" || promoted.Rollout != nil {
+		return fmt.Errorf("after promote alias is %+v, want target sim-green and no rollout", promoted)
+	}
+	return nil
+}
+
+// alias fetches one alias from the admin list.
+func (s *sim) alias(name string) (shared.ModelAlias, error) {
+	var list struct {
+		Aliases []shared.ModelAlias `json:"aliases"`
+	}
+	if err := s.admin("GET", "/admin/aliases", nil, &list); err != nil {
+		return shared.ModelAlias{}, err
+	}
+	for _, a := range list.Aliases {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+	return shared.ModelAlias{}, fmt.Errorf("alias %s not listed", name)
+}
+
+// expectRoutedToModel sends n text tasks hinted with model and checks
+// that all of them ran on want.
+func (s *sim) expectRoutedToModel(n int, model string, want *mockAgent) error {
+	for i := 0; i < n; i++ {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: model, Prompt: "hello", NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if res.RoutedTo != want.id || res.ModelUsed != want.model {
+			return fmt.Errorf("task %d ran %s on %s, want %s on %s", i+1, res.ModelUsed, res.RoutedTo, want.model, want.id)
+		}
+	}
+	return nil
+}
+
+func dedupTasks(s *sim) error {
+	a, err := s.agent("mistral", 300*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	// The prompt is unique to this run so an earlier run's result, still
+	// within the dedup window, can't be joined
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "mash me " + uuid.New().String()}
+	const n = 4
+	results := make([]shared.TaskResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = postJSON(s.orch+"/task", req, &results[i])
+		}(i)
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	var leader string
+	joined := 0
+	for i, res := range results {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if res.Content != a.reply(req.Prompt) {
+			return fmt.Errorf("task %d got %q, want %q", i+1, res.Content, a.reply(req.Prompt))
+		}
+		if res.Deduplicated {
+			joined++
+		} else {
+			leader = res.TaskID
+		}
+	}
+	if got := a.executed.Load(); got != 1 {
+		return fmt.Errorf("%d identical concurrent tasks ran %d times, want once", n, got)
+	}
+	if joined != n-1 {
+		return fmt.Errorf("%d of %d results flagged deduplicated, want %d", joined, n, n-1)
+	}
+	for _, res := range results {
+		if res.Deduplicated && res.DedupOf != leader {
+			return fmt.Errorf("task %s dedup_of %q, want %q", res.TaskID, res.DedupOf, leader)
+		}
+	}
+
+	// Opting out runs the task again
+	req.NoDedup = true
+	var res shared.TaskResult
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return err
+	}
+	if res.Deduplicated || a.executed.Load() != 2 {
+		return fmt.Errorf("no_dedup task was not run again (deduplicated=%v, executions %d)", res.Deduplicated, a.executed.Load())
+	}
+	return nil
+}
+
+// pullMode hints a model only the pull-mode agent has, whose HTTP server
+// is closed — tasks can only reach it through its work queue.
+func pullMode(s *sim) error {
+	a, err := s.agent("sim-pull", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := a.setPull(); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		prompt := fmt.Sprintf("pulled %d", i)
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-pull", Prompt: prompt, NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if res.RoutedTo != a.id || res.Content != a.reply(prompt) {
+			return fmt.Errorf("task %d: routed to %s with %q, want %s with %q", i+1, res.RoutedTo, res.Content, a.id, a.reply(prompt))
+		}
+	}
+
+	chunks, err := s.streamTask(shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-pull", Prompt: "pulled stream", NoDedup: true})
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, c := range chunks {
+		text.WriteString(c.Token)
+	}
+	if last := chunks[len(chunks)-1]; last.Error != "" || text.String() != a.reply("pulled stream") {
+		return fmt.Errorf("streamed %q (error %q), want %q", text.String(), last.Error, a.reply("pulled stream"))
+	}
+	if n := a.executed.Load(); n != 4 {
+		return fmt.Errorf("pull-mode agent ran %d tasks, want 4", n)
+	}
+	return nil
+}
+
+func sessionTokens(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	spoof := shared.HeartbeatRequest{NodeID: a.id, Status: shared.StatusOffline}
+	for _, token := range []string{"", "not-the-token"} {
+		err := sendJSON("POST", s.orch+"/heartbeat", token, spoof, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), "401") {
+			return fmt.Errorf("heartbeat with token %q: %v, want 401", token, err)
+		}
+	}
+	takeover := shared.RegisterRequest{NodeID: a.id, AgentHost: "127.0.0.1", AgentPort: 1, Models: []string{"mistral"}}
+	if err := postJSON(s.orch+"/register", takeover, nil); err == nil || !strings.HasPrefix(err.Error(), "409") {
+		return fmt.Errorf("re-registering a live node without its token: %v, want 409", err)
+	}
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.Status == shared.StatusOffline || node.AgentPort != a.port {
+		return fmt.Errorf("node is %s on port %d after spoofing, want it untouched on %d", node.Status, node.AgentPort, a.port)
+	}
+
+	// The agent itself can still re-register, and heartbeat with the new token
+	if err := a.setExclusive(); err != nil {
+		return err
+	}
+	return sendJSON("POST", s.orch+"/heartbeat", a.session(), shared.HeartbeatRequest{NodeID: a.id, Status: shared.StatusIdle}, nil)
+}
+
+func topologyMap(s *sim) error {
+	agents, err := s.agentsN(3, "mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	a, b, c := agents[0], agents[1], agents[2]
+	a.setPeers(1.5, b)
+	b.setPeers(2.5, a)
+	time.Sleep(1500 * time.Millisecond) // a heartbeat each
+
+	resp, err := httpClient.Get(s.orch + "/topology")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var topo shared.Topology
+	if err := json.NewDecoder(resp.Body).Decode(&topo); err != nil {
+		return err
+	}
+	reporting := map[string]bool{}
+	for _, n := range topo.Nodes {
+		reporting[n.NodeID] = n.Reporting
+	}
+	if !reporting[a.id] || !reporting[b.id] || reporting[c.id] {
+		return fmt.Errorf("reporting nodes %v, want %s and %s but not %s", reporting, a.id, b.id, c.id)
+	}
+	var links []shared.TopologyLink
+	for _, l := range topo.Links {
+		if strings.HasPrefix(l.From, s.prefix) {
+			links = append(links, l)
+		}
+	}
+	want := []shared.TopologyLink{
+		{From: a.id, To: b.id, Reachable: true, RTTMs: 1.5, Mutual: true},
+		{From: b.id, To: a.id, Reachable: true, RTTMs: 2.5, Mutual: true},
+	}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		return fmt.Errorf("links %+v, want %+v", links, want)
+	}
+	return nil
+}
+
+func languageRouting(s *sim) error {
+	if _, err := s.agent("mistral", 0, shared.TaskTypeText); err != nil {
+		return err
+	}
+	qwen, err := s.agent("qwen2", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := qwen.setLanguages("zh", "en"); err != nil {
+		return err
+	}
+
+	// Regional variants match on the primary subtag
+	for i, lang := range []string{"zh", "zh-TW", "ZH", "zh"} {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, Language: lang, Prompt: "你好", NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if res.RoutedTo != qwen.id {
+			return fmt.Errorf("%s task %d routed to %s, want %s", lang, i+1, res.RoutedTo, qwen.id)
+		}
+	}
+
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: "gravity",
+		Language:     "zh",
+		Steps:        []shared.PipelineStep{{Type: shared.TaskTypeText, PromptTemplate: "Answer in {{language}}: {{initial_input}}"}},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	if !result.Success || len(result.Steps) != 1 {
+		return fmt.Errorf("pipeline failed (%d steps): %s", len(result.Steps), result.Error)
+	}
+	if want := qwen.reply("Answer in zh: gravity"); result.Steps[0].RoutedTo != qwen.id || result.FinalOutput != want {
+		return fmt.Errorf("pipeline step on %s answered %q, want %q on %s", result.Steps[0].RoutedTo, result.FinalOutput, want, qwen.id)
+	}
+	return nil
+}
+
+func minQuality(s *sim) error {
+	small, err := s.agent("sim-phi3", 0, shared.TaskTypeText, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+	if err := small.setTier(shared.QualitySmall); err != nil {
+		return err
+	}
+	large, err := s.agent("sim-llama13", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := large.setTier(shared.QualityLarge); err != nil {
+		return err
+	}
+	// Declares no tier, so it never meets a min_quality
+	if _, err := s.agent("sim-unsized", 0, shared.TaskTypeText); err != nil {
+		return err
+	}
+
+	for _, q := range []shared.QualityTier{shared.QualityMedium, shared.QualityLarge, shared.QualityLarge} {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, MinQuality: q, Prompt: "explain entropy", NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if res.RoutedTo != large.id || res.ModelUsed != "sim-llama13" {
+			return fmt.Errorf("%s task ran %s on %s, want sim-llama13 on %s", q, res.ModelUsed, res.RoutedTo, large.id)
+		}
+	}
+	var res shared.TaskResult
+	if err := postJSON(s.orch+"/task", shared.TaskRequest{Type: shared.TaskTypeCode, MinQuality: shared.QualitySmall, Prompt: "fizzbuzz", NoDedup: true}, &res); err != nil {
+		return err
+	}
+	if res.RoutedTo != small.id {
+		return fmt.Errorf("small code task routed to %s, want %s", res.RoutedTo, small.id)
+	}
+
+	// Only the small model writes code
+	err = postJSON(s.orch+"/task", shared.TaskRequest{Type: shared.TaskTypeCode, MinQuality: shared.QualityLarge, Prompt: "fizzbuzz", NoDedup: true, QueueTimeoutMs: -1}, &res)
+	if err == nil && res.Success {
+		return fmt.Errorf("large code task ran on %s, want no node", res.RoutedTo)
+	}
+	err = postJSON(s.orch+"/task", shared.TaskRequest{Type: shared.TaskTypeText, MinQuality: shared.QualityLarge, TargetNode: small.id, Prompt: "hi", NoDedup: true, QueueTimeoutMs: -1}, &res)
+	if err == nil && res.Success {
+		return fmt.Errorf("large task targeted at the small node ran there")
+	}
+	err = postJSON(s.orch+"/task", shared.TaskRequest{Type: shared.TaskTypeText, MinQuality: "huge", Prompt: "hi"}, &res)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("unknown min_quality: %v, want 400", err)
+	}
+	return nil
+}
+
+func drain(s *sim) error {
+	drained, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	other, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := s.admin("POST", "/admin/nodes/"+drained.id+"/drain", nil, nil); err != nil {
+		return err
+	}
+	if err := s.expectRoutedTo(4, shared.TaskTypeText, other); err != nil {
+		return err
+	}
+	if err := s.admin("DELETE", "/admin/nodes/"+drained.id+"/drain", nil, nil); err != nil {
+		return err
+	}
+	other.setMode(behaveFail)
+	return s.expectRoutedTo(1, shared.TaskTypeText, drained)
+}
+
+func thermalShedding(s *sim) error {
+	hot, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	cool, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	hot.setThermal(shared.ThermalHot, 93)
+	if err := s.waitForNode(hot.id, func(n *shared.NodeInfo) bool { return n.Status == shared.StatusBusy }); err != nil {
+		return err
+	}
+	node, err := s.node(hot.id)
+	if err != nil {
+		return err
+	}
+	if node.Health != shared.HealthYellow || !strings.Contains(node.HealthReason, "93°C") {
+		return fmt.Errorf("hot node health %s (%q), want yellow naming its temperature", node.Health, node.HealthReason)
+	}
+	if err := s.expectRoutedTo(4, shared.TaskTypeText, cool); err != nil {
+		return err
+	}
+
+	hot.setThermal(shared.ThermalNormal, 60)
+	return s.waitForNode(hot.id, func(n *shared.NodeInfo) bool { return n.Status == shared.StatusIdle })
+}
+
+func linkQuality(s *sim) error {
+	wifi, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	wired, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := sendJSON("GET", s.orch+"/ping", "", nil, nil); err != nil {
+		return fmt.Errorf("GET /ping: %v", err)
+	}
+	wifi.setLink(320, 90, 0.12)
+	wired.setLink(1.5, 0.3, 0)
+	for _, a := range []*mockAgent{wifi, wired} {
+		if err := s.waitForNode(a.id, func(n *shared.NodeInfo) bool { return n.Link != nil }); err != nil {
+			return err
+		}
+	}
+	node, err := s.node(wifi.id)
+	if err != nil {
+		return err
+	}
+	if node.Link.Grade != shared.LinkPoor || node.Health != shared.HealthYellow || !strings.Contains(node.HealthReason, "12% loss") {
+		return fmt.Errorf("lossy node link %+v, health %s (%q); want poor and yellow naming its loss", node.Link, node.Health, node.HealthReason)
+	}
+	var topo shared.Topology
+	if err := sendJSON("GET", s.orch+"/topology", "", nil, &topo); err != nil {
+		return err
+	}
+	grades := map[string]shared.LinkGrade{}
+	for _, n := range topo.Nodes {
+		if n.Link != nil {
+			grades[n.NodeID] = n.Link.Grade
+		}
+	}
+	if grades[wifi.id] != shared.LinkPoor || grades[wired.id] != shared.LinkGood {
+		return fmt.Errorf("topology grades links %v, want %s poor and %s good", grades, wifi.id, wired.id)
+	}
+
+	var prev shared.RoutingWeights
+	if err := s.admin("GET", "/admin/routing/weights", nil, &prev); err != nil {
+		return err
+	}
+	if err := s.admin("PUT", "/admin/routing/weights", shared.RoutingWeights{Load: 1, Link: 1}, nil); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/routing/weights", prev, nil)
+	return s.expectRoutedTo(4, shared.TaskTypeText, wired)
+}
+
+func warmRouting(s *sim) error {
+	cold, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	warm, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	// Ollama reports untagged models with their :latest tag
+	warm.setLoaded("mistral:latest")
+	if err := s.waitForNode(warm.id, func(n *shared.NodeInfo) bool { return len(n.LoadedModels) > 0 }); err != nil {
+		return err
+	}
+	if err := s.expectRoutedTo(4, shared.TaskTypeText, warm); err != nil {
+		return err
+	}
+
+	var series struct {
+		Points []shared.StatsPoint `json:"points"`
+	}
+	if err := sendJSON("GET", s.orch+"/stats/series?window=1h&step=1h", "", nil, &series); err != nil {
+		return err
+	}
+	if n := len(series.Points); n == 0 || series.Points[n-1].WarmHitRate <= 0 {
+		return fmt.Errorf("stats series reports no warm hits: %+v", series.Points)
+	}
+
+	// The other node's last /api/ps listed the model too, but its
+	// keep-alive has run out since. Unload times are on each agent's clock
+	const gib = 1 << 30
+	warm.setClockSkew(30 * time.Second)
+	expires := time.Now().Add(5 * time.Minute).UnixMilli()
+	warm.setLoadedMemory(shared.LoadedModel{Name: "mistral:latest", SizeBytes: 6 * gib, VRAMBytes: 4 * gib, ExpiresAt: warm.clock() + 5*60_000})
+	cold.setLoadedMemory(shared.LoadedModel{Name: "mistral:latest", SizeBytes: 5 * gib, VRAMBytes: 5 * gib, ExpiresAt: cold.clock() - 1000})
+	for _, a := range []*mockAgent{warm, cold} {
+		if err := s.waitForNode(a.id, func(n *shared.NodeInfo) bool { return len(n.LoadedMemory) > 0 }); err != nil {
+			return err
+		}
+	}
+	node, err := s.node(warm.id)
+	if err != nil {
+		return err
+	}
+	m := node.LoadedMemory[0]
+	if m.VRAMBytes != 4*gib || m.SizeBytes != 6*gib {
+		return fmt.Errorf("/status shows %s's loaded memory as %+v", warm.id, m)
+	}
+	if d := m.ExpiresAt - expires; d < -5000 || d > 5000 {
+		return fmt.Errorf("%s's expires_at is %dms off the orchestrator's clock", warm.id, d)
+	}
+	return s.expectRoutedTo(4, shared.TaskTypeText, warm)
+}
+
+// simTaskOptions is the -task-options meshsim starts the orchestrator
+// with; against a running orchestrator, task-options needs it set the same
+// way.
+const simTaskOptions = "code=temperature:0.1,num_predict:1024"
+
+func taskOptions(s *sim) error {
+	a, err := s.agent("codellama", 0, shared.TaskTypeCode, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	run := func(req shared.TaskRequest) (map[string]any, error) {
+		req.TargetNode, req.NoDedup = a.id, true
+		var res shared.TaskResult
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return nil, err
+		}
+		opts, _ := a.options.Load().(map[string]any)
+		return opts, nil
+	}
+
+	// The code defaults, under the task's own options
+	opts, err := run(shared.TaskRequest{Type: shared.TaskTypeCode, Prompt: "sort a list", Options: map[string]any{"num_predict": 64, "seed": 7}})
+	if err != nil {
+		return err
+	}
+	want := map[string]any{"temperature": 0.1, "num_predict": 64.0, "seed": 7.0}
+	if fmt.Sprint(opts) != fmt.Sprint(want) {
+		return fmt.Errorf("code task reached %s with options %v, want %v (is the orchestrator running with -task-options %q?)", a.id, opts, want, simTaskOptions)
+	}
+
+	// No defaults for text: the task's options go as they are
+	if opts, err = run(shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "hello"}); err != nil {
+		return err
+	}
+	if len(opts) != 0 {
+		return fmt.Errorf("text task without options reached %s with %v", a.id, opts)
+	}
+	return nil
+}
+
+// simAgentVersion is the build mock agents report.
+const simAgentVersion = "v0.0.0-meshsim"
+
+func versions(s *sim) error {
+	var info shared.VersionInfo
+	if err := sendJSON("GET", s.orch+"/version", "", nil, &info); err != nil {
+		return err
+	}
+	if info.Version == "" || info.APIVersion != shared.MeshAPIVersion || info.OS == "" || info.Arch == "" {
+		return fmt.Errorf("GET /version = %+v, want a version and mesh API v%d", info, shared.MeshAPIVersion)
+	}
+
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.Version != simAgentVersion || node.APIVersion != shared.MeshAPIVersion {
+		return fmt.Errorf("%s shows version %q api %d, want %q and %d", a.id, node.Version, node.APIVersion, simAgentVersion, shared.MeshAPIVersion)
+	}
+
+	// Another mesh API version is refused, on registering and after
+	other := shared.RegisterRequest{
+		NodeID:     s.prefix + "future",
+		AgentHost:  "127.0.0.1",
+		AgentPort:  1,
+		Models:     []string{"mistral"},
+		Version:    "v99.0.0",
+		APIVersion: shared.MeshAPIVersion + 1,
+	}
+	err = postJSON(s.orch+"/register", other, nil)
+	if err == nil || !strings.Contains(err.Error(), "426") {
+		return fmt.Errorf("registering with mesh API v%d: got %v, want 426", other.APIVersion, err)
+	}
+	if _, err := s.node(other.NodeID); err == nil {
+		return fmt.Errorf("%s was registered despite its mesh API version", other.NodeID)
+	}
+	hb := shared.HeartbeatRequest{NodeID: a.id, Status: shared.StatusIdle, APIVersion: shared.MeshAPIVersion + 1}
+	if err := sendJSON("POST", s.orch+"/heartbeat", a.session(), hb, nil); err == nil || !strings.Contains(err.Error(), "426") {
+		return fmt.Errorf("heartbeat with mesh API v%d: got %v, want 426", hb.APIVersion, err)
+	}
+	return nil
+}
+
+// simPassHeader is the -pass-headers meshsim starts the orchestrator with;
+// against a running orchestrator, pass-headers needs it set the same way.
+const simPassHeader = "X-Sim-Tenant"
+
+func passHeaders(s *sim) error {
+	push, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	pull, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := pull.setPull(); err != nil {
+		return err
+	}
+
+	// Deduplicated tasks too: their generation runs apart from the request
+	for _, a := range []*mockAgent{push, pull} {
+		data, _ := json.Marshal(shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "who am I", TargetNode: a.id})
+		req, _ := http.NewRequest("POST", s.orch+"/task", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(simPassHeader, "acme")
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("task for %s: HTTP %d", a.id, resp.StatusCode)
+		}
+		if got, _ := a.tenant.Load().(string); got != "acme" {
+			return fmt.Errorf("%s got %s %q, want \"acme\" (is the orchestrator running with -pass-headers %s?)", a.id, simPassHeader, got, simPassHeader)
+		}
+	}
+
+	// Headers the orchestrator isn't told to pass stay behind
+	var res shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "anonymous", TargetNode: push.id, NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return err
+	}
+	if got, _ := push.tenant.Load().(string); got != "" {
+		return fmt.Errorf("%s got %s %q on a task without it", push.id, simPassHeader, got)
+	}
+	return nil
+}
+
+// simClientKey is the -client-keys token meshsim starts the orchestrator
+// with, named simClientKeyName.
+const (
+	simClientKeyName = "sim-laptop"
+	simClientKey     = "sim-laptop-key"
+)
+
+func capabilityDelta(s *sim) error {
+	a, err := s.agent("sim-caps", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	before, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.orch, "http")+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Where the next code task goes, as "<node> (model: <model>)"
+	codeRoute := func() (string, error) {
+		var debug struct {
+			Routing map[string]string `json:"routing"`
+		}
+		err := sendJSON("GET", s.orch+"/debug/routing", "", nil, &debug)
+		return debug.Routing[string(shared.TaskTypeCode)], err
+	}
+	const onCoder = "(model: sim-caps-coder)"
+	if route, err := codeRoute(); err != nil || strings.Contains(route, onCoder) {
+		return fmt.Errorf("code tasks route to %q (%v) before there is a code model", route, err)
+	}
+
+	// A pull finishing: the coder is routable from this heartbeat on
+	coder := shared.ModelCapability{Name: "sim-caps-coder", Types: []shared.TaskType{shared.TaskTypeCode}, ContextLength: 16384}
+	if err := a.changeCapabilities(shared.CapabilityDelta{Base: 0, Seq: 1, Added: []shared.ModelCapability{coder}}); err != nil {
+		return err
+	}
+	if route, err := codeRoute(); err != nil || route != a.id+" "+onCoder {
+		return fmt.Errorf("code tasks route to %q (%v) after the delta, want %s %s", route, err, a.id, onCoder)
+	}
+	if err := s.expectRoutedTo(1, shared.TaskTypeCode, a); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("no capability_changed event: %v", err)
+		}
+		var ev struct {
+			Type string                 `json:"type"`
+			Data shared.CapabilityEvent `json:"data"`
+		}
+		if json.Unmarshal(data, &ev) != nil || ev.Type != "capability_changed" || ev.Data.NodeID != a.id {
+			continue
+		}
+		if len(ev.Data.Added) != 1 || ev.Data.Added[0].Name != coder.Name || len(ev.Data.Capabilities) != 2 {
+			return fmt.Errorf("capability_changed %+v, want %s added to 2 capabilities", ev.Data, coder.Name)
+		}
+		break
+	}
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.RegisteredAt != before.RegisteredAt || node.CapabilitySeq != 1 || len(node.Models) != 2 {
+		return fmt.Errorf("node after the delta: registered at %d (was %d), capability v%d, models %v; want the same registration at v1 with 2 models",
+			node.RegisteredAt, before.RegisteredAt, node.CapabilitySeq, node.Models)
+	}
+
+	// A resent delta is acknowledged again; one built on a version the
+	// orchestrator never saw sends the agent back to register
+	if err := a.changeCapabilities(shared.CapabilityDelta{Base: 0, Seq: 1, Added: []shared.ModelCapability{coder}}); err != nil {
+		return fmt.Errorf("resent delta: %v", err)
+	}
+	if err := a.changeCapabilities(shared.CapabilityDelta{Base: 5, Seq: 6, Removed: []string{a.model}}); err == nil || !strings.Contains(err.Error(), "409") {
+		return fmt.Errorf("delta from v5: %v, want 409", err)
+	}
+
+	// ollama rm
+	if err := a.changeCapabilities(shared.CapabilityDelta{Base: 1, Seq: 2, Removed: []string{coder.Name}}); err != nil {
+		return err
+	}
+	if route, err := codeRoute(); err != nil || strings.Contains(route, onCoder) {
+		return fmt.Errorf("code tasks route to %q (%v) after the code model was removed", route, err)
+	}
+	return s.expectRoutedTo(2, shared.TaskTypeText, a)
+}
+
+func taskSources(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.orch, "http")+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// A source the client makes up is replaced
+	var res shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "who asked", TargetNode: a.id, NoDedup: true,
+		Source: &shared.TaskSource{Key: "someone-else"}}
+	if err := sendJSON("POST", s.orch+"/task", simClientKey, req, &res); err != nil {
+		return err
+	}
+	if res.Source == nil || res.Source.Key != simClientKeyName || res.Source.RemoteIP == "" || res.Source.UserAgent == "" {
+		return fmt.Errorf("result source %+v, want key %s with IP and user agent (is the orchestrator running with -client-keys %s=%s?)",
+			res.Source, simClientKeyName, simClientKeyName, simClientKey)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("no task_done event for %s: %v", res.TaskID, err)
+		}
+		var ev struct {
+			Type string           `json:"type"`
+			Data shared.TaskEvent `json:"data"`
+		}
+		if json.Unmarshal(data, &ev) != nil || ev.Type != "task_done" || ev.Data.TaskID != res.TaskID {
+			continue
+		}
+		if ev.Data.Source == nil || ev.Data.Source.Key != simClientKeyName {
+			return fmt.Errorf("task_done source %+v, want key %s", ev.Data.Source, simClientKeyName)
+		}
+		break
+	}
+
+	// Pipeline runs keep their source and are filtered on it
+	var keyed, anonymous shared.PipelineResult
+	pipe := shared.PipelineRequest{InitialInput: "x", Steps: []shared.PipelineStep{{Type: shared.TaskTypeText, PromptTemplate: "{{initial_input}}"}}}
+	if err := sendJSON("POST", s.orch+"/pipeline", simClientKey, pipe, &keyed); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(pipe)
+	hreq, _ := http.NewRequest("POST", s.orch+"/pipeline", bytes.NewReader(data))
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("User-Agent", "meshsim-kiosk/1.0")
+	resp, err := httpClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	json.NewDecoder(resp.Body).Decode(&anonymous)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pipeline: HTTP %d", resp.StatusCode)
+	}
+
+	listed := func(source string) (map[string]bool, error) {
+		var list struct {
+			Runs []shared.PipelineRunSummary `json:"runs"`
+		}
+		if err := sendJSON("GET", s.orch+"/pipelines/runs?source="+url.QueryEscape(source), "", nil, &list); err != nil {
+			return nil, err
+		}
+		ids := make(map[string]bool)
+		for _, run := range list.Runs {
+			ids[run.PipelineID] = true
+		}
+		return ids, nil
+	}
+	byKey, err := listed(simClientKeyName)
+	if err != nil {
+		return err
+	}
+	if !byKey[keyed.PipelineID] || byKey[anonymous.PipelineID] {
+		return fmt.Errorf("?source=%s lists %v, want %s and not %s", simClientKeyName, byKey, keyed.PipelineID, anonymous.PipelineID)
+	}
+	byAgent, err := listed("KIOSK")
+	if err != nil {
+		return err
+	}
+	if !byAgent[anonymous.PipelineID] || byAgent[keyed.PipelineID] {
+		return fmt.Errorf("?source=KIOSK lists %v, want %s only", byAgent, anonymous.PipelineID)
+	}
+	return nil
+}
+
+func memoryBudget(s *sim) error {
+	full, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	spare, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	// Warm, so the full node is tried first
+	full.setLoaded("mistral")
+	full.setMode(behaveFull)
+	if err := s.waitForNode(full.id, func(n *shared.NodeInfo) bool { return len(n.LoadedModels) > 0 }); err != nil {
+		return err
+	}
+
+	refused := 0
+	for i := 0; i < 3; i++ {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "no room", NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if res.RoutedTo != spare.id {
+			return fmt.Errorf("task routed to %s, want %s", res.RoutedTo, spare.id)
+		}
+		for _, a := range res.Attempts {
+			if a.NodeID == full.id && a.ErrorCode == shared.ErrCodeMemoryBudget {
+				refused++
+			}
+		}
+	}
+	if refused == 0 {
+		return fmt.Errorf("no attempt on %s was refused with %s", full.id, shared.ErrCodeMemoryBudget)
+	}
+
+	node, err := s.node(full.id)
+	if err != nil {
+		return err
+	}
+	if node.Status == shared.StatusOverloaded {
+		return fmt.Errorf("%s marked overloaded after refusing tasks", full.id)
+	}
+	return nil
+}
+
+func pipelineVariables(s *sim) error {
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: "notes",
+		Variables:    map[string]string{"audience": "execs", "tone": "{{prev_output}}"},
+		Steps: []shared.PipelineStep{
+			{Type: shared.TaskTypeText, PromptTemplate: "For {{var.audience}}, {{var.tone}}: {{initial_input}}"},
+		},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	// Values go in as written: a placeholder in one isn't expanded
+	if want := writer.reply("For execs, {{prev_output}}: notes"); !result.Success || result.FinalOutput != want {
+		return fmt.Errorf("pipeline output %q (%s), want %q", result.FinalOutput, result.Error, want)
+	}
+
+	req.Steps[0].PromptTemplate = "For {{var.readers}}: {{initial_input}}"
+	err = postJSON(s.orch+"/pipeline", req, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "var.readers") {
+		return fmt.Errorf("undefined variable: %v, want a 400 naming it", err)
+	}
+	return nil
+}
+
+// simPipelineTemplate is the template file meshsim gives the orchestrator
+// through -pipeline-templates, as sim-yaml.yaml.
+const simPipelineTemplate = `# named after the file
+description: Folded prompt
+steps:
+  - type: text
+    prompt_template: >-
+      Shout
+      {{initial_input}}
+`
+
+func pipelineYAML(s *sim) error {
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	post := func(doc string) (shared.PipelineResult, error) {
+		var result shared.PipelineResult
+		resp, err := httpClient.Post(s.orch+"/pipeline", "application/yaml", strings.NewReader(doc))
+		if err != nil {
+			return result, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return result, httpError(resp)
+		}
+		return result, json.NewDecoder(resp.Body).Decode(&result)
+	}
+
+	result, err := post(`initial_input: notes
+variables: {audience: execs}
+steps:
+  - type: text   # the prompt keeps its lines
+    prompt_template: |
+      For {{var.audience}}:
+
+        {{initial_input}}
+`)
+	if err != nil {
+		return err
+	}
+	if want := writer.reply("For execs:\n\n  notes\n"); !result.Success || result.FinalOutput != want {
+		return fmt.Errorf("YAML pipeline output %q (%s), want %q", result.FinalOutput, result.Error, want)
+	}
+
+	if result, err = post("template: sim-yaml\ninitial_input: notes\n"); err != nil {
+		return fmt.Errorf("template from a file: %v (is the orchestrator running with -pipeline-templates?)", err)
+	}
+	if want := writer.reply("Shout notes"); !result.Success || result.FinalOutput != want {
+		return fmt.Errorf("template output %q (%s), want %q", result.FinalOutput, result.Error, want)
+	}
+	var list struct {
+		Templates []shared.PipelineTemplate `json:"templates"`
+	}
+	if err := sendJSON("GET", s.orch+"/pipelines/templates/builtin", "", nil, &list); err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(list.Templates, func(t shared.PipelineTemplate) bool { return t.Name == "sim-yaml" }); i < 0 || list.Templates[i].File != "sim-yaml.yaml" {
+		return fmt.Errorf("templates don't list sim-yaml from sim-yaml.yaml")
+	}
+
+	_, err = post("initial_input: notes\nsteps:\n  - type: text\n    prompt_template: [unclosed\n")
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "line 4") {
+		return fmt.Errorf("unreadable YAML: %v, want a 400 naming line 4", err)
+	}
+	return nil
+}
+
+// waitForNode polls GET /status for up to 5s until ok holds for the node.
+func (s *sim) waitForNode(nodeID string, ok func(*shared.NodeInfo) bool) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		node, err := s.node(nodeID)
+		if err != nil {
+			return err
+		}
+		if ok(node) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %s still %s after 5s", nodeID, node.Status)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func pipeline(s *sim) error {
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	coder, err := s.agent("codellama", 0, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: "a todo app",
+		Steps: []shared.PipelineStep{
+			{Type: shared.TaskTypeText, PromptTemplate: "Spec for {{initial_input}}"},
+			{Type: shared.TaskTypeCode, PromptTemplate: "Implement: {{prev_output}}"},
+		},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	if !result.Success || len(result.Steps) != 2 {
+		return fmt.Errorf("pipeline failed (%d steps): %s", len(result.Steps), result.Error)
+	}
+	if result.Steps[0].RoutedTo != writer.id || result.Steps[1].RoutedTo != coder.id {
+		return fmt.Errorf("steps routed to %s, %s; want %s, %s",
+			result.Steps[0].RoutedTo, result.Steps[1].RoutedTo, writer.id, coder.id)
+	}
+	if result.Steps[0].TaskID == result.Steps[1].TaskID {
+		return fmt.Errorf("steps share task ID %s", result.Steps[0].TaskID)
+	}
+	if !strings.HasPrefix(result.FinalOutput, coder.id) {
+		return fmt.Errorf("final output %q isn't the last step's", result.FinalOutput)
+	}
+
+	resp, err := httpClient.Get(s.orch + "/tasks/" + result.Steps[1].TaskID + "/lineage")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lineage: %w", httpError(resp))
+	}
+	var rec shared.TaskLineageRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return err
+	}
+	if rec.Lineage == nil || rec.Lineage.PipelineID != result.PipelineID || rec.Lineage.StepIndex != 1 {
+		return fmt.Errorf("lineage %+v doesn't point at step 1 of %s", rec.Lineage, result.PipelineID)
+	}
+	return nil
+}
+
+func pipelineSchedule(s *sim) error {
+	const step = 300 * time.Millisecond
+	if _, err := s.agent("mistral", step, shared.TaskTypeText); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/pipelines/schedule", shared.PipelineSchedule{Policy: shared.PipelineFIFO, Slots: 0}, nil)
+
+	// With one slot, a two-step pipeline finishes its first step while a
+	// three-step one waits: fifo runs the newcomer's first step next,
+	// shortest-remaining the two-step pipeline's last
+	pipelineOf := func(n int) shared.PipelineRequest {
+		req := shared.PipelineRequest{InitialInput: "schedule me"}
+		for i := 0; i < n; i++ {
+			req.Steps = append(req.Steps, shared.PipelineStep{Type: shared.TaskTypeText})
+		}
+		return req
+	}
+	shortLatency := func(policy shared.PipelinePolicy) (time.Duration, error) {
+		var schedule shared.PipelineSchedule
+		if err := s.admin("PUT", "/admin/pipelines/schedule", shared.PipelineSchedule{Policy: policy, Slots: 1}, &schedule); err != nil {
+			return 0, err
+		}
+		if schedule.Policy != policy || schedule.Slots != 1 {
+			return 0, fmt.Errorf("schedule set to %+v", schedule)
+		}
+		var short, long shared.PipelineResult
+		shortErr := make(chan error, 1)
+		go func() { shortErr <- postJSON(s.orch+"/pipeline", pipelineOf(2), &short) }()
+		time.Sleep(step / 3)
+		if err := postJSON(s.orch+"/pipeline", pipelineOf(3), &long); err != nil {
+			return 0, err
+		}
+		if err := <-shortErr; err != nil {
+			return 0, err
+		}
+		if !short.Success || !long.Success {
+			return 0, fmt.Errorf("pipelines failed: %q, %q", short.Error, long.Error)
+		}
+		return time.Duration(short.LatencyMs) * time.Millisecond, nil
+	}
+
+	fifo, err := shortLatency(shared.PipelineFIFO)
+	if err != nil {
+		return err
+	}
+	if fifo < step*5/2 {
+		return fmt.Errorf("fifo: two-step pipeline took %v, want it to wait behind the new pipeline's first step", fifo)
+	}
+	shortest, err := shortLatency(shared.PipelineShortestRemaining)
+	if err != nil {
+		return err
+	}
+	if shortest >= step*5/2 {
+		return fmt.Errorf("shortest-remaining: two-step pipeline took %v, want about %v", shortest, 2*step)
+	}
+	return nil
+}
+
+func fairShare(s *sim) error {
+	const delay = 300 * time.Millisecond
+	a, err := s.agent("sim-fair", delay, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := a.setBusyThreshold(1); err != nil {
+		return err
+	}
+	// Anonymous tasks weigh a hundredth, so anonymous is the heavy tenant
+	// however much the client key has been used before
+	cfg := shared.FairShareConfig{Enabled: true, HalfLifeS: 600, MaxWaitMs: 10_000, Weights: map[string]float64{"anonymous": 0.01}}
+	if err := s.admin("PUT", "/admin/fairshare", cfg, nil); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/fairshare", shared.FairShareConfig{Enabled: false, HalfLifeS: 600, MaxWaitMs: 30_000, Weights: map[string]float64{}}, nil)
+
+	req := func(prompt string) shared.TaskRequest {
+		return shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-fair", Prompt: prompt, NoDedup: true}
+	}
+	var res shared.TaskResult
+	if err := postJSON(s.orch+"/task", req("warm-up"), &res); err != nil {
+		return err
+	}
+
+	// One anonymous task keeps the node busy while two more and then one
+	// from the client key are held
+	var mu sync.Mutex
+	var finished []string
+	run := func(name, key string, errs chan<- error) {
+		var res shared.TaskResult
+		err := sendJSON("POST", s.orch+"/task", key, req(name), &res)
+		mu.Lock()
+		finished = append(finished, name)
+		mu.Unlock()
+		errs <- err
+	}
+	errs := make(chan error, 4)
+	go run("anon-1", "", errs)
+	time.Sleep(delay / 6)
+	go run("anon-2", "", errs)
+	go run("anon-3", "", errs)
+	time.Sleep(delay / 6)
+	go run("laptop", simClientKey, errs)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	if want := []string{"anon-1", "laptop"}; len(finished) != 4 || finished[0] != want[0] || finished[1] != want[1] {
+		return fmt.Errorf("tasks finished in order %v, want %v first", finished, want)
+	}
+
+	var stats shared.FairShareStats
+	if err := sendJSON("GET", s.orch+"/stats/fairshare", "", nil, &stats); err != nil {
+		return err
+	}
+	held := map[string]int{}
+	for _, t := range stats.Tenants {
+		held[t.Tenant] = t.Held
+		if t.Tenant == "anonymous" && (t.Weight != 0.01 || t.GPUSeconds < 2*delay.Seconds()) {
+			return fmt.Errorf("anonymous tenant %+v, want weight 0.01 and at least %.1f GPU-seconds", t, 2*delay.Seconds())
+		}
+	}
+	if !stats.Enabled || stats.Waiting != 0 || held["anonymous"] < 2 || held[simClientKeyName] < 1 {
+		return fmt.Errorf("stats %+v, want anonymous tasks held twice and %s once", stats, simClientKeyName)
+	}
+	if stats.Fairness <= 0 || stats.Fairness > 1 {
+		return fmt.Errorf("fairness %v, want (0, 1]", stats.Fairness)
+	}
+	return nil
+}
+
+func queueAdmin(s *sim) error {
+	const delay = 300 * time.Millisecond
+	a, err := s.agent("sim-queue", delay, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := a.setBusyThreshold(1); err != nil {
+		return err
+	}
+	cfg := shared.FairShareConfig{Enabled: true, HalfLifeS: 600, MaxWaitMs: 10_000}
+	if err := s.admin("PUT", "/admin/fairshare", cfg, nil); err != nil {
+		return err
+	}
+	defer s.admin("PUT", "/admin/fairshare", shared.FairShareConfig{Enabled: false, HalfLifeS: 600, MaxWaitMs: 30_000, Weights: map[string]float64{}}, nil)
+
+	// One task keeps the node busy while three more are held, in order
+	var mu sync.Mutex
+	var finished []string
+	errs := make(map[string]error)
+	var wg sync.WaitGroup
+	run := func(name string) {
+		defer wg.Done()
+		var res shared.TaskResult
+		req := shared.TaskRequest{TaskID: "sim-queue-" + name, Type: shared.TaskTypeText, ModelHint: "sim-queue", Prompt: name, NoDedup: true}
+		err := postJSON(s.orch+"/task", req, &res)
+		mu.Lock()
+		defer mu.Unlock()
+		finished = append(finished, name)
+		errs[name] = err
+	}
+	for _, name := range []string{"busy", "first", "second", "third"} {
+		wg.Add(1)
+		go run(name)
+		time.Sleep(delay / 8)
+	}
+
+	var list shared.QueueList
+	if err := s.admin("GET", "/admin/queue", nil, &list); err != nil {
+		return err
+	}
+	var ids []string
+	for i, e := range list.Entries {
+		if e.Kind != shared.QueueHeld || e.Position != i || e.Tenant != "anonymous" || e.ModelHint != "sim-queue" {
+			return fmt.Errorf("entry %d is %+v, want a held sim-queue task at position %d", i, e, i)
+		}
+		ids = append(ids, e.ID)
+	}
+	if want := "[sim-queue-first sim-queue-second sim-queue-third]"; fmt.Sprint(ids) != want || list.Depth != 3 {
+		return fmt.Errorf("queue holds %v (depth %d), want %s", ids, list.Depth, want)
+	}
+
+	// The third goes next once promoted; the second never runs
+	var promoted shared.QueueEntry
+	if err := s.admin("POST", "/admin/queue/sim-queue-third/promote", nil, &promoted); err != nil {
+		return err
+	}
+	if promoted.Position != 0 || !promoted.Promoted {
+		return fmt.Errorf("promoted entry is %+v, want position 0", promoted)
+	}
+	if err := s.admin("POST", "/admin/queue/sim-queue-second/cancel", nil, nil); err != nil {
+		return err
+	}
+	if err := s.admin("POST", "/admin/queue/no-such-task/cancel", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("cancelling an unknown ID: got %v, want 404", err)
+	}
+	wg.Wait()
+
+	if err := errs["second"]; err == nil || !strings.Contains(err.Error(), "410") {
+		return fmt.Errorf("cancelled task answered %v, want 410", err)
+	}
+	for _, name := range []string{"busy", "first", "third"} {
+		if errs[name] != nil {
+			return fmt.Errorf("task %s: %v", name, errs[name])
+		}
+	}
+	if want := "[second busy third first]"; fmt.Sprint(finished) != want {
+		return fmt.Errorf("tasks finished in order %v, want %s", finished, want)
+	}
+	if err := s.admin("GET", "/admin/queue", nil, &list); err != nil {
+		return err
+	}
+	if list.Depth != 0 || len(list.Entries) != 0 {
+		return fmt.Errorf("queue still holds %+v", list)
+	}
+	return nil
+}
+
+func nodeQueue(s *sim) error {
+	// The tasks target the next agent, which hasn't registered yet
+	id := fmt.Sprintf("%s%d", s.prefix, len(s.agents)+1)
+	errs := make(map[string]error)
+	results := make(map[string]*shared.TaskResult)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	submit := func(name string, queueTimeoutMs int64) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var res shared.TaskResult
+			req := shared.TaskRequest{TaskID: "sim-nodeq-" + name, Type: shared.TaskTypeText, TargetNode: id, Prompt: name, NoDedup: true, QueueTimeoutMs: queueTimeoutMs}
+			err := postJSON(s.orch+"/task", req, &res)
+			mu.Lock()
+			defer mu.Unlock()
+			errs[name], results[name] = err, &res
+		}()
+	}
+	submit("late", 0)
+	submit("gone", 0)
+	submit("impatient", 300)
+	submit("now", -1)
+	time.Sleep(150 * time.Millisecond)
+
+	var list shared.QueueList
+	if err := s.admin("GET", "/admin/queue", nil, &list); err != nil {
+		return err
+	}
+	waiting := make(map[string]bool)
+	for _, e := range list.Entries {
+		if e.Kind == shared.QueueNodes && e.TargetNode == id {
+			waiting[e.ID] = true
+		}
+	}
+	if len(waiting) != 3 || waiting["sim-nodeq-now"] {
+		return fmt.Errorf("waiting for a node: %v, want late, gone and impatient", waiting)
+	}
+	if err := s.admin("POST", "/admin/queue/sim-nodeq-late/promote", nil, nil); err == nil || !strings.Contains(err.Error(), "409") {
+		return fmt.Errorf("promoting a task waiting for a node: got %v, want 409", err)
+	}
+	if err := s.admin("POST", "/admin/queue/sim-nodeq-gone/cancel", nil, nil); err != nil {
+		return err
+	}
+
+	// The impatient task gives up before the node arrives; the other runs
+	time.Sleep(400 * time.Millisecond)
+	if _, err := s.agent("sim-nodeq", 0, shared.TaskTypeText); err != nil {
+		return err
+	}
+	wg.Wait()
+	for name, want := range map[string]string{"gone": "410", "impatient": "503", "now": "503"} {
+		if err := errs[name]; err == nil || !strings.Contains(err.Error(), want) {
+			return fmt.Errorf("task %s answered %v, want %s", name, err, want)
+		}
+	}
+	if err := errs["late"]; err != nil {
+		return fmt.Errorf("task waiting for its node: %v", err)
+	}
+	if res := results["late"]; !res.Success || res.RoutedTo != id {
+		return fmt.Errorf("task waiting for its node ran as %+v, want it on %s", res, id)
+	}
+	if err := s.admin("GET", "/admin/queue", nil, &list); err != nil {
+		return err
+	}
+	if list.Depth != 0 {
+		return fmt.Errorf("queue still holds %+v", list)
+	}
+	return nil
+}
+
+// fetchPage is the article pipelineFetch serves.
+const fetchPage = `<html><head><title>Menu</title><script>track()</script></head>
+<body><nav>Home | About</nav><p>Echo &amp; the mesh.</p><p>Second paragraph.</p></body></html>`
+
+func pipelineFetch(s *sim) error {
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, fetchPage)
+	}))
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: "/article",
+		Steps: []shared.PipelineStep{
+			{Fetch: &shared.PipelineFetch{URL: fmt.Sprintf("http://127.0.0.1:%d{{initial_input}}", port)}},
+			{Type: shared.TaskTypeText, PromptTemplate: "Sum up {{prev_output}}"},
+			{Type: shared.TaskTypeText, PromptTemplate: "{{fetched_content}}"},
+		},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	const text = "Echo & the mesh.\nSecond paragraph."
+	if !result.Success || len(result.Steps) != 3 || result.Steps[0].Content != text {
+		return fmt.Errorf("fetch step %+v, want content %q", result.Steps, text)
+	}
+	// The last step still sees the page, two steps later
+	if want := writer.reply(text); result.FinalOutput != want {
+		return fmt.Errorf("final output %q, want %q", result.FinalOutput, want)
+	}
+
+	// localhost isn't in -fetch-allow
+	req.Steps[0].Fetch.URL = fmt.Sprintf("http://localhost:%d/article", port)
+	err = postJSON(s.orch+"/pipeline", req, nil)
+	if err == nil || !strings.Contains(err.Error(), "not in -fetch-allow") {
+		return fmt.Errorf("fetch from a host not allowed: %v, want it refused", err)
+	}
+	return nil
+}
+
+func pipelineExec(s *sim) error {
+	coder, err := s.agent("codellama", 0, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+	runner, err := s.agent("tiny", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := runner.setExec("python"); err != nil {
+		return err
+	}
+
+	// The first program raises; the fix the coder returns runs
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: "raise ValueError",
+		Steps: []shared.PipelineStep{
+			{Type: shared.TaskTypeCode, PromptTemplate: "{{initial_input}}"},
+			{Exec: &shared.PipelineExec{Language: "python", MaxFixes: 1}},
+		},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	if len(result.Steps) != 2 || result.Steps[1].RoutedTo != runner.id || runner.execRuns.Load() != 2 || coder.executed.Load() != 2 {
+		return fmt.Errorf("steps %+v, %d runs, %d code tasks; want 2 runs on %s and a fix", result.Steps, runner.execRuns.Load(), coder.executed.Load(), runner.id)
+	}
+	if want := "ran: " + coder.reply("This python program failed."); !strings.HasPrefix(result.FinalOutput, want) {
+		return fmt.Errorf("final output %q, want the fix's output %q", result.FinalOutput, want)
+	}
+
+	// Without fixes the step fails with the traceback
+	req.Steps[1].Exec.MaxFixes = 0
+	if result, err = s.failedPipeline(req); err != nil {
+		return err
+	}
+	if !strings.Contains(result.Error, "exit status 1") || !strings.Contains(result.Error, "ValueError") {
+		return fmt.Errorf("failing code: %q, want the step failed with its traceback", result.Error)
+	}
+
+	// Languages no node runs fail at once
+	req.Steps[1].Exec.Language = "node"
+	if result, err = s.failedPipeline(req); err != nil {
+		return err
+	}
+	if !strings.Contains(result.Error, "no node runs node code") {
+		return fmt.Errorf("unsupported language: %q, want no node found", result.Error)
+	}
+	return nil
+}
+
+// failedPipeline runs a pipeline expected to fail and returns its result.
+func (s *sim) failedPipeline(req shared.PipelineRequest) (shared.PipelineResult, error) {
+	var result shared.PipelineResult
+	data, _ := json.Marshal(req)
+	resp, err := httpClient.Post(s.orch+"/pipeline", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		return result, fmt.Errorf("pipeline answered %s, want it failed", resp.Status)
+	}
+	return result, json.NewDecoder(resp.Body).Decode(&result)
+}
+
+func summarize(s *sim) error {
+	nodes, err := s.agentsN(3, "mistral", 100*time.Millisecond, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+	paragraphs := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "Paragraph %d. %s\n\n", i, strings.Repeat("alpha beta gamma ", 15))
+		}
+		return b.String()
+	}
+
+	var res shared.SummarizeResult
+	if err := postJSON(s.orch+"/summarize", shared.SummarizeRequest{Text: paragraphs(6), ChunkTokens: 100}, &res); err != nil {
+		return err
+	}
+	if !res.Success || len(res.Chunks) != 6 || res.Reduce == nil {
+		return fmt.Errorf("summarize: success=%v, %d chunks, reduce %v: %s", res.Success, len(res.Chunks), res.Reduce != nil, res.Error)
+	}
+	for i, c := range res.Chunks {
+		if !c.Success || c.Tokens > 100 || c.TaskID == "" || c.Summary == "" {
+			return fmt.Errorf("chunk %d traced as %+v", i, c)
+		}
+	}
+	if !strings.Contains(res.Summary, "the 6 consecutive") || res.Summary != res.Reduce.Content {
+		return fmt.Errorf("summary %q isn't the reduce step's", res.Summary)
+	}
+	used := 0
+	for _, n := range nodes {
+		if n.executed.Load() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		return fmt.Errorf("chunks ran on %d node(s), want them spread (%s)", used, executedSummary(nodes))
+	}
+	// The run keeps the chunks, one paragraph each, in document order
+	var run shared.PipelineRun
+	if err := sendJSON("GET", s.orch+res.RunURL, "", nil, &run); err != nil {
+		return fmt.Errorf("run %s: %v", res.RunURL, err)
+	}
+	var chunks []string
+	if err := json.Unmarshal([]byte(run.Definition.InitialInput), &chunks); err != nil || len(chunks) != 6 {
+		return fmt.Errorf("run's initial_input isn't the 6 chunks: %v", err)
+	}
+	for i, c := range chunks {
+		if !strings.HasPrefix(c, fmt.Sprintf("Paragraph %d.", i)) {
+			return fmt.Errorf("chunk %d starts %.20q", i, c)
+		}
+	}
+
+	// POST /chunk cuts the same way, and overlapping chunks repeat the
+	// end of the one before
+	var cut shared.ChunkResult
+	if err := postJSON(s.orch+"/chunk", shared.ChunkRequest{Text: paragraphs(6), ChunkTokens: 100}, &cut); err != nil {
+		return err
+	}
+	if len(cut.Chunks) != 6 || cut.Chunks[2].Text != chunks[2] {
+		return fmt.Errorf("POST /chunk cut %d chunks, want the 6 of /summarize", len(cut.Chunks))
+	}
+	if err := postJSON(s.orch+"/chunk", shared.ChunkRequest{Text: paragraphs(6), ChunkTokens: 100, OverlapTokens: 20, Split: "sentences"}, &cut); err != nil {
+		return err
+	}
+	for i, c := range cut.Chunks {
+		if c.Tokens > 100 {
+			return fmt.Errorf("overlapping chunk %d is %d tokens, over 100", i, c.Tokens)
+		}
+		if i > 0 && !startsWithEndOf(c.Text, cut.Chunks[i-1].Text) {
+			return fmt.Errorf("chunk %d doesn't start with the end of chunk %d: %.30q", i, i-1, c.Text)
+		}
+	}
+	if err := postJSON(s.orch+"/chunk", shared.ChunkRequest{Text: "text", ChunkTokens: 100, Split: "pages"}, nil); err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("unknown split: got %v, want 400", err)
+	}
+
+	// A short document is one step
+	var short shared.SummarizeResult
+	if err := postJSON(s.orch+"/summarize", shared.SummarizeRequest{Text: "Just one line."}, &short); err != nil {
+		return err
+	}
+	if !short.Success || len(short.Chunks) != 1 || short.Reduce != nil || short.Chunks[0].TaskID == "" {
+		return fmt.Errorf("short document summarized as %+v", short)
+	}
+
+	// Too many chunks for their summaries to fit the reduce model
+	err = postJSON(s.orch+"/summarize", shared.SummarizeRequest{Text: paragraphs(100), ChunkTokens: 100}, nil)
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		return fmt.Errorf("100 chunks: got %v, want 413", err)
+	}
+	return nil
+}
+
+// startsWithEndOf reports whether chunk starts with the last words of prev.
+func startsWithEndOf(chunk, prev string) bool {
+	words, prevWords := strings.Fields(chunk), strings.Fields(prev)
+	for k := 1; k <= len(prevWords) && k < len(words); k++ {
+		if slices.Equal(words[:k], prevWords[len(prevWords)-k:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func resultChecksums(s *sim) error {
+	push, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	pull, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := pull.setPull(); err != nil {
+		return err
+	}
+	send := func(a *mockAgent, prompt string) (shared.TaskResult, error) {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: prompt, TargetNode: a.id, NoDedup: true}
+		err := postJSON(s.orch+"/task", req, &res)
+		return res, err
+	}
+
+	// A reply cut short is asked for again, and the agent resends it
+	// without generating it again
+	push.corruptNext(1)
+	res, err := send(push, "checksum me")
+	if err != nil {
+		return err
+	}
+	if want := push.reply("checksum me"); res.Content != want || res.Checksum != "" {
+		return fmt.Errorf("result %q (checksum %q), want %q without a checksum", res.Content, res.Checksum, want)
+	}
+	if push.resends.Load() != 1 || push.executed.Load() != 1 {
+		return fmt.Errorf("%s had %d resends for %d generations, want 1 for 1", push.id, push.resends.Load(), push.executed.Load())
+	}
+
+	// Asked once only: damaged twice, the node has failed the task
+	push.corruptNext(2)
+	if _, err := send(push, "checksum me twice"); err == nil {
+		return fmt.Errorf("a task whose result was damaged twice succeeded")
+	}
+	if push.resends.Load() != 2 {
+		return fmt.Errorf("%s had %d resends, want 2", push.id, push.resends.Load())
+	}
+
+	// A pull-mode agent posts a refused result again
+	pull.corruptNext(1)
+	res, err = send(pull, "checksum me pulled")
+	if err != nil {
+		return err
+	}
+	if want := pull.reply("checksum me pulled"); res.Content != want {
+		return fmt.Errorf("pulled result %q, want %q", res.Content, want)
+	}
+	if pull.resends.Load() != 1 {
+		return fmt.Errorf("%s posted its result again %d times, want 1", pull.id, pull.resends.Load())
+	}
+	return nil
+}
+
+func pipelineMap(s *sim) error {
+	const delay = 200 * time.Millisecond
+	nodes, err := s.agentsN(3, "mistral", delay, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+
+	sections := []string{"s1", "s2", "s3", "s4", "s5", "s6"}
+	var result shared.PipelineResult
+	req := shared.PipelineRequest{
+		InitialInput: strings.Join(sections, "\n\n"),
+		Steps: []shared.PipelineStep{{
+			Type:           shared.TaskTypeSummarize,
+			PromptTemplate: "Summarize {{item}}",
+			Map:            &shared.PipelineMap{Join: "|", MaxParallel: len(sections)},
+		}},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, &result); err != nil {
+		return err
+	}
+	if !result.Success || len(result.Steps) != 1 {
+		return fmt.Errorf("pipeline failed: %s", result.Error)
+	}
+	step := result.Steps[0]
+	if len(step.Items) != len(sections) {
+		return fmt.Errorf("%d item results, want %d", len(step.Items), len(sections))
+	}
+
+	// Outputs are joined in item order whatever order they finished in
+	parts := strings.Split(result.FinalOutput, "|")
+	if len(parts) != len(sections) {
+		return fmt.Errorf("final output has %d parts, want %d: %q", len(parts), len(sections), result.FinalOutput)
+	}
+	for i, part := range parts {
+		if !strings.HasSuffix(part, "Summarize "+sections[i]) {
+			return fmt.Errorf("part %d is %q, want the output for %s", i, part, sections[i])
+		}
+	}
+	if step.LatencyMs >= int64(len(sections))*delay.Milliseconds()*2/3 {
+		return fmt.Errorf("map took %dms — items didn't run in parallel", step.LatencyMs)
+	}
+	used := 0
+	for _, n := range nodes {
+		if n.executed.Load() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		return fmt.Errorf("items ran on %d node(s), want them spread (%s)", used, executedSummary(nodes))
+	}
+
+	resp, err := httpClient.Get(s.orch + "/tasks/" + step.Items[2].TaskID + "/lineage")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("item lineage: %w", httpError(resp))
+	}
+	var rec shared.TaskLineageRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return err
+	}
+	if rec.Lineage == nil || rec.Lineage.PipelineID != result.PipelineID || rec.Lineage.Item != 3 {
+		return fmt.Errorf("item lineage %+v, want item 3 of %s", rec.Lineage, result.PipelineID)
+	}
+	return nil
+}
+
+// lineageTree fetches the lineage tree taskID belongs to.
+func (s *sim) lineageTree(taskID string) (*shared.LineageNode, error) {
+	var rec shared.TaskLineageRecord
+	if err := sendJSON("GET", s.orch+"/tasks/"+taskID+"/lineage", "", nil, &rec); err != nil {
+		return nil, fmt.Errorf("lineage of %s: %w", taskID, err)
+	}
+	if rec.Tree == nil {
+		return nil, fmt.Errorf("lineage of %s has no tree", taskID)
+	}
+	return rec.Tree, nil
+}
+
+func lineage(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	a.setMode(behaveFail)
+
+	req := shared.PipelineRequest{
+		PipelineID:   uuid.New().String(),
+		InitialInput: "notes",
+		Steps:        []shared.PipelineStep{{Type: shared.TaskTypeText, PromptTemplate: "Summarize {{initial_input}}"}},
+	}
+	if err := postJSON(s.orch+"/pipeline", req, nil); err == nil {
+		return fmt.Errorf("pipeline succeeded although its only node fails")
+	}
+	var failed shared.PipelineRun
+	if err := sendJSON("GET", s.orch+"/pipelines/runs/"+req.PipelineID, "", nil, &failed); err != nil {
+		return err
+	}
+	if len(failed.Steps) != 1 {
+		return fmt.Errorf("failed run has %d steps, want 1", len(failed.Steps))
+	}
+	stepID := failed.Steps[0].TaskID
+
+	var dlq struct {
+		Tasks []shared.DeadLetter `json:"tasks"`
+	}
+	if err := s.admin("GET", "/admin/dlq", nil, &dlq); err != nil {
+		return err
+	}
+	var entry *shared.DeadLetter
+	for i := range dlq.Tasks {
+		if dlq.Tasks[i].Request.TaskID == stepID {
+			entry = &dlq.Tasks[i]
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("step task %s not in the dead-letter queue", stepID)
+	}
+
+	a.setMode(behaveOK)
+	time.Sleep(1500 * time.Millisecond) // let the suspect mark clear
+	var retried shared.TaskResult
+	if err := s.admin("POST", "/admin/dlq/"+entry.ID+"/retry", nil, &retried); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	if retried.TaskID == stepID {
+		return fmt.Errorf("dead-letter retry reused the step's task ID")
+	}
+
+	// pipeline → failed step → successful retry
+	tree, err := s.lineageTree(retried.TaskID)
+	if err != nil {
+		return err
+	}
+	if tree.ID != req.PipelineID || !tree.Pipeline || len(tree.Children) != 1 {
+		return fmt.Errorf("tree root %s (pipeline=%v, %d children), want pipeline %s with its step", tree.ID, tree.Pipeline, len(tree.Children), req.PipelineID)
+	}
+	step := tree.Children[0]
+	if step.ID != stepID || step.Relation != shared.RelationStep || step.Success || len(step.Children) != 1 {
+		return fmt.Errorf("step node %+v, want failed step %s with one retry", step, stepID)
+	}
+	if retry := step.Children[0]; retry.ID != retried.TaskID || retry.Relation != shared.RelationRetry || !retry.Success || retry.RoutedTo != a.id {
+		return fmt.Errorf("retry node %+v, want %s succeeding on %s", retry, retried.TaskID, a.id)
+	}
+
+	// Re-running the pipeline retries the step as attempt 2
+	var rerun shared.PipelineResult
+	if err := postJSON(s.orch+"/pipeline", req, &rerun); err != nil {
+		return err
+	}
+	if len(rerun.Steps) != 1 || rerun.Steps[0].Attempt != 2 {
+		return fmt.Errorf("re-run steps %+v, want attempt 2", rerun.Steps)
+	}
+	if tree, err = s.lineageTree(rerun.Steps[0].TaskID); err != nil {
+		return err
+	}
+	if len(tree.Children) != 1 || len(tree.Children[0].Children) != 2 {
+		return fmt.Errorf("after the re-run the step has %d retries, want 2", len(tree.Children[0].Children))
+	}
+	if rerunNode := tree.Children[0].Children[1]; rerunNode.ID != rerun.Steps[0].TaskID || rerunNode.Relation != shared.RelationRetry {
+		return fmt.Errorf("re-run node %+v, want retry %s", rerunNode, rerun.Steps[0].TaskID)
+	}
+
+	// Not while the pipeline_id's run is still going
+	if _, err := s.agent("mistral", 2*time.Second, shared.TaskTypeText); err != nil {
+		return err
+	}
+	a.setMode(behaveFail)
+	running := make(chan error, 1)
+	go func() { running <- postJSON(s.orch+"/pipeline", req, nil) }()
+	time.Sleep(500 * time.Millisecond)
+	if err := postJSON(s.orch+"/pipeline", req, nil); err == nil || !strings.HasPrefix(err.Error(), "409") {
+		return fmt.Errorf("re-run while the run is going: %v, want 409", err)
+	}
+	if err := <-running; err != nil {
+		return fmt.Errorf("third run: %w", err)
+	}
+	return nil
+}
+
+func stream(s *sim) error {
+	a, err := s.agent("mistral", 100*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	data, _ := json.Marshal(shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "stream me please", NoDedup: true})
+	resp, err := httpClient.Post(s.orch+"/task/stream", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(resp)
+	}
+
+	var text strings.Builder
+	var final *shared.TaskChunk
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk shared.TaskChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return fmt.Errorf("bad chunk %q: %w", line, err)
+		}
+		text.WriteString(chunk.Token)
+		if chunk.Done {
+			final = &chunk
+			break
+		}
+	}
+	if final == nil {
+		return fmt.Errorf("stream ended without a done chunk")
+	}
+	if final.RoutedTo != a.id {
+		return fmt.Errorf("stream routed to %s, want %s", final.RoutedTo, a.id)
+	}
+	if final.Timings == nil {
+		return fmt.Errorf("done chunk carries no timings")
+	}
+	if want := a.reply("stream me please"); strings.TrimSpace(text.String()) != want {
+		return fmt.Errorf("streamed %q, want %q", text.String(), want)
+	}
+	return nil
+}
+
+func streamResume(s *sim) error {
+	// 10 words at 300ms each, so the stream outlasts the restart
+	a, err := s.agent("mistral", 3*time.Second, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	taskID := s.prefix + uuid.New().String()
+	prompt := "resume me after the orchestrator restarts"
+	data, _ := json.Marshal(shared.TaskRequest{TaskID: taskID, Type: shared.TaskTypeText, Prompt: prompt, NoDedup: true})
+	resp, err := httpClient.Post(s.orch+"/task/stream", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return httpError(resp)
+	}
+	head, lastID, _, err := readSSE(resp.Body, 2)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if lastID != fmt.Sprint(len(head)) {
+		return fmt.Errorf("last event id %q after %d bytes", lastID, len(head))
+	}
+
+	// Without an orchestrator of its own to restart, the dropped
+	// connection alone is resumed
+	if restartOrchestrator != nil {
+		if err := restartOrchestrator(); err != nil {
+			return err
+		}
+	}
+
+	req, _ := http.NewRequest("GET", s.orch+"/task/stream/"+taskID, nil)
+	req.Header.Set("Last-Event-ID", lastID)
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(resp)
+	}
+	rest, _, final, err := readSSE(resp.Body, 0)
+	if err != nil {
+		return err
+	}
+	if final == nil || final.Error != "" || final.RoutedTo != a.id || final.TaskID != taskID {
+		return fmt.Errorf("resumed stream ended with %+v", final)
+	}
+	want := a.reply(prompt)
+	if got := strings.TrimSpace(head + rest); got != want {
+		return fmt.Errorf("streamed %q then resumed %q, want %q", head, rest, want)
+	}
+
+	// Once finished, the whole answer can be fetched again
+	resp, err = httpClient.Get(s.orch + "/task/stream/" + taskID + "?last_event_id=0")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	text, _, replay, err := readSSE(resp.Body, 0)
+	if err != nil {
+		return err
+	}
+	if replay == nil || strings.TrimSpace(text) != want {
+		return fmt.Errorf("replay sent %q, want %q", text, want)
+	}
+	return nil
+}
+
+func pipelineRecovery(s *sim) error {
+	// Only an orchestrator meshsim started can be restarted
+	if restartOrchestrator == nil {
+		return nil
+	}
+	a, err := s.agent("mistral", 2*time.Second, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	pipelineID := s.prefix + uuid.New().String()
+	step := shared.PipelineStep{Type: shared.TaskTypeText, ModelHint: "mistral", PromptTemplate: "{{prev_output}}!"}
+	req := shared.PipelineRequest{PipelineID: pipelineID, InitialInput: "overnight", Steps: []shared.PipelineStep{step, step, step}}
+	go postJSON(s.orch+"/pipeline", req, nil)
+
+	// Restart while the second step runs: the agent finishes it as an orphan
+	time.Sleep(3 * time.Second)
+	if err := restartOrchestrator(); err != nil {
+		return err
+	}
+
+	var run shared.PipelineRun
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if err := sendJSON("GET", s.orch+"/pipelines/runs/"+pipelineID, "", nil, &run); err != nil {
+			return err
+		}
+		if run.Status == shared.RunSucceeded || run.Status == shared.RunFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("run still %s 30s after the restart", run.Status)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	if run.Status != shared.RunSucceeded || run.ResumedAt == 0 || len(run.Steps) != 3 {
+		return fmt.Errorf("run %s (%s), resumed_at %d, %d steps; want a resumed success with 3", run.Status, run.Error, run.ResumedAt, len(run.Steps))
+	}
+	if second := run.Steps[1]; !second.Recovered || second.RoutedTo != a.id || second.Attempt != 1 {
+		return fmt.Errorf("second step %+v, want it recovered from %s as attempt 1", second, a.id)
+	}
+	if want := a.reply(a.reply(a.reply("overnight!")+"!") + "!"); run.FinalOutput != want {
+		return fmt.Errorf("final output %q, want %q", run.FinalOutput, want)
+	}
+	if n := a.executed.Load(); n != 3 {
+		return fmt.Errorf("agent ran %d tasks, want 3: the recovered step isn't run again", n)
+	}
+	if a.claimed.Load() != 1 {
+		return fmt.Errorf("%d orphans claimed from the agent, want 1", a.claimed.Load())
+	}
+	return nil
+}
+
+// simCapabilityTTL is the orchestrator's -capability-ttl, so capabilities
+// go stale within seconds.
+const simCapabilityTTL = 3 * time.Second
+
+func capabilityTTL(s *sim) error {
+	a, err := s.agent("sim-ttl", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	// A model the agent advertises but its backend no longer lists
+	gone := shared.ModelCapability{Name: "sim-ttl-gone", Types: []shared.TaskType{shared.TaskTypeCode}}
+	if err := a.changeCapabilities(shared.CapabilityDelta{Base: 0, Seq: 1, Added: []shared.ModelCapability{gone}}); err != nil {
+		return err
+	}
+
+	// Agents that check their models stay confirmed past the TTL
+	time.Sleep(simCapabilityTTL + time.Second)
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.CapabilitiesStale || len(node.Models) != 2 {
+		return fmt.Errorf("checked node is %+v, want fresh capabilities for 2 models", node)
+	}
+
+	// One that stops checking goes stale, and stays so while its models
+	// can't be listed
+	a.unchecked.Store(true)
+	a.modelsOff.Store(true)
+	time.Sleep(simCapabilityTTL)
+	err = s.waitForNode(a.id, func(n *shared.NodeInfo) bool {
+		return n.CapabilitiesStale && n.Health == shared.HealthYellow && strings.HasPrefix(n.HealthReason, "models unconfirmed")
+	})
+	if err != nil {
+		return fmt.Errorf("unchecked node: %v", err)
+	}
+
+	// Re-validation drops the missing model and confirms the other
+	a.modelsOff.Store(false)
+	err = s.waitForNode(a.id, func(n *shared.NodeInfo) bool {
+		return !n.CapabilitiesStale && n.Health == shared.HealthGreen
+	})
+	if err != nil {
+		return fmt.Errorf("re-validated node: %v", err)
+	}
+	node, err = s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(node.Models) != "[sim-ttl]" || len(node.Capabilities) != 1 {
+		return fmt.Errorf("re-validated node has models %v and %d capabilities, want only sim-ttl", node.Models, len(node.Capabilities))
+	}
+	return nil
+}
+
+// simTaskTimeoutMin is the orchestrator's -task-timeout-min, so a hung
+// fast node fails over in seconds.
+const simTaskTimeoutMin = 2 * time.Second
+
+// adaptiveTimeout hints a model only the fast node has, so it's tried first.
+func asyncTask(s *sim) error {
+	if _, err := s.agent("sim-async", 500*time.Millisecond, shared.TaskTypeText); err != nil {
+		return err
+	}
+	bad, err := s.agent("sim-async", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	bad.setMode(behaveFail)
+
+	// Submitting answers before the node does
+	submit := func(target string) (shared.AsyncTask, error) {
+		var task shared.AsyncTask
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-async", TargetNode: target, Prompt: "take your time", NoDedup: true}
+		err := postJSON(s.orch+"/task/async", req, &task)
+		return task, err
+	}
+	started := time.Now()
+	task, err := submit(s.agents[0].id)
+	if err != nil {
+		return err
+	}
+	if took := time.Since(started); took > 400*time.Millisecond || task.TaskID == "" || task.Status == shared.AsyncDone {
+		return fmt.Errorf("submit took %v and answered %+v", took, task)
+	}
+	if err := postJSON(s.orch+"/task/async", shared.TaskRequest{TaskID: task.TaskID, Prompt: "again"}, nil); err == nil || !strings.Contains(err.Error(), "409") {
+		return fmt.Errorf("resubmitting task %s: got %v, want 409", task.TaskID, err)
+	}
+
+	// Polling follows it to its result
+	poll := func(task shared.AsyncTask) (shared.AsyncTask, error) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var got shared.AsyncTask
+			if err := sendJSON("GET", s.orch+task.StatusURL, "", nil, &got); err != nil {
+				return got, err
+			}
+			if got.Status == shared.AsyncDone || got.Status == shared.AsyncFailed {
+				return got, nil
+			}
+			if time.Now().After(deadline) {
+				return got, fmt.Errorf("task %s still %s after 5s", task.TaskID, got.Status)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	done, err := poll(task)
+	if err != nil {
+		return err
+	}
+	if done.Status != shared.AsyncDone || done.Result == nil || !done.Result.Success || done.Result.TaskID != task.TaskID || done.StartedAt == 0 || done.FinishedAt < done.StartedAt {
+		return fmt.Errorf("finished task reported as %+v", done)
+	}
+
+	// A task every node fails carries the problem POST /task would answer
+	task, err = submit(bad.id)
+	if err != nil {
+		return err
+	}
+	failed, err := poll(task)
+	if err != nil {
+		return err
+	}
+	if failed.Status != shared.AsyncFailed || failed.Error == nil || failed.Error.Status != http.StatusServiceUnavailable || !failed.Error.Retryable {
+		return fmt.Errorf("failed task reported as %+v (error %+v)", failed, failed.Error)
+	}
+	if err := sendJSON("GET", s.orch+"/task/no-such-task", "", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("unknown task: got %v, want 404", err)
+	}
+	return nil
+}
+
+func adaptiveTimeout(s *sim) error {
+	fast, err := s.agent("sim-gpu", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	run := func() (shared.TaskResult, time.Duration, error) {
+		var res shared.TaskResult
+		started := time.Now()
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-gpu", Prompt: "be quick", NoDedup: true}
+		err := postJSON(s.orch+"/task", req, &res)
+		return res, time.Since(started), err
+	}
+	timeoutOf := func() (shared.TaskTimeout, shared.TimeoutStats, error) {
+		var stats shared.TimeoutStats
+		if err := sendJSON("GET", s.orch+"/stats/timeouts", "", nil, &stats); err != nil {
+			return shared.TaskTimeout{}, stats, err
+		}
+		for _, t := range stats.Timeouts {
+			if t.NodeID == fast.id && t.Model == "sim-gpu" {
+				return t, stats, nil
+			}
+		}
+		return shared.TaskTimeout{}, stats, fmt.Errorf("no timeout listed for sim-gpu on %s", fast.id)
+	}
+
+	// Without history the node gets the full allowance
+	if _, _, err := run(); err != nil {
+		return err
+	}
+	t, stats, err := timeoutOf()
+	if err != nil {
+		return err
+	}
+	if t.Adaptive || t.TimeoutMs != stats.DefaultMs {
+		return fmt.Errorf("after 1 task: timeout %dms (adaptive %v), want the default %dms", t.TimeoutMs, t.Adaptive, stats.DefaultMs)
+	}
+	for i := 1; i < 20; i++ {
+		if _, _, err := run(); err != nil {
+			return err
+		}
+	}
+	if t, _, err = timeoutOf(); err != nil {
+		return err
+	}
+	if !t.Adaptive || t.Samples != 20 || t.TimeoutMs >= stats.DefaultMs || t.TimeoutMs < stats.MinMs {
+		return fmt.Errorf("after 20 tasks: %+v, want an adaptive timeout between %dms and %dms", t, stats.MinMs, stats.DefaultMs)
+	}
+
+	// Hung, it's given up on after its own timeout, not the task's
+	slow, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	fast.setMode(behaveHang)
+	res, took, err := run()
+	if err != nil {
+		return err
+	}
+	if res.RoutedTo != slow.id || len(res.Attempts) != 1 || res.Attempts[0].NodeID != fast.id || !strings.Contains(res.Attempts[0].Error, "no answer within") {
+		return fmt.Errorf("routed to %s after %+v, want %s after a timeout on %s", res.RoutedTo, res.Attempts, slow.id, fast.id)
+	}
+	if limit := time.Duration(t.TimeoutMs)*time.Millisecond + 3*time.Second; took > limit {
+		return fmt.Errorf("failed over after %v, want within %v", took, limit)
+	}
+	if t, _, err = timeoutOf(); err != nil {
+		return err
+	}
+	if t.TimedOut != 1 {
+		return fmt.Errorf("timed_out %d, want 1", t.TimedOut)
+	}
+	return nil
+}
+
+// partialTaskTimeout is the -task-timeout of partial-timeout's own
+// orchestrator: the agent is told to stop 2s before it.
+const partialTaskTimeout = 5 * time.Second
+
+func partialTimeout(s *sim) error {
+	// A real agent, under an orchestrator of its own with a short timeout
+	if orchestratorBin == "" || agentBin == "" {
+		return nil
+	}
+	words := make([]string, 200)
+	for i := range words {
+		words[i] = fmt.Sprintf("word%d", i+1)
+	}
+	backend, err := startFakeOllama("slowpoke", words, 50*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+	mesh, err := startRealMesh(s.prefix+"real", backend, "-task-timeout", partialTaskTimeout.String())
+	if err != nil {
+		return err
+	}
+	defer mesh.close()
+
+	// Out of time mid-answer: the words so far come back, marked partial
+	task := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "slowpoke", Prompt: "go on"}
+	var res shared.TaskResult
+	if err := postJSON(mesh.orch+"/task", task, &res); err != nil {
+		return err
+	}
+	full := strings.Join(words, " ")
+	if !res.Partial || res.Success || res.RoutedTo != mesh.nodeID {
+		return fmt.Errorf("result partial=%v success=%v on %s, want a partial result from %s", res.Partial, res.Success, res.RoutedTo, mesh.nodeID)
+	}
+	if res.Content == "" || len(res.Content) >= len(full) || !strings.HasPrefix(full, res.Content) {
+		return fmt.Errorf("partial content %q, want the first words of the answer", res.Content)
+	}
+
+	// Out of time before the first word: nothing to keep, so another node
+	// answers in the time left
+	other, err := startAgent(mesh.orch, s.prefix+"other", "mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	defer other.close()
+	backend.silent.Store(true)
+	res = shared.TaskResult{}
+	if err := postJSON(mesh.orch+"/task", task, &res); err != nil {
+		return err
+	}
+	if res.Partial || !res.Success || res.RoutedTo != other.id {
+		return fmt.Errorf("result partial=%v success=%v on %s, want a failover to %s", res.Partial, res.Success, res.RoutedTo, other.id)
+	}
+	if len(res.Attempts) != 1 || res.Attempts[0].NodeID != mesh.nodeID {
+		return fmt.Errorf("attempts %+v, want the timed-out one on %s", res.Attempts, mesh.nodeID)
+	}
+	return nil
+}
+
+func agentAuth(s *sim) error {
+	// A real agent: only the orchestrator it registered with knows the token
+	if orchestratorBin == "" || agentBin == "" {
+		return nil
+	}
+	backend, err := startFakeOllama("authmodel", []string{"ok"}, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+	mesh, err := startRealMesh(s.prefix+"real", backend)
+	if err != nil {
+		return err
+	}
+	defer mesh.close()
+	ms := &sim{orch: mesh.orch}
+	node, err := ms.node(mesh.nodeID)
+	if err != nil {
+		return err
+	}
+	agentURL := shared.BaseURL(node.AgentHost, node.AgentPort)
+
+	// Anyone else on the network gets 401
+	calls := []struct {
+		method, path string
+		body         any
+	}{
+		{"POST", "/exec", shared.ExecRequest{Language: "sh", Code: "echo pwned"}},
+		{"POST", "/pull", shared.PullRequest{Model: "huge:70b"}},
+		{"DELETE", "/orphans/some-task", nil},
+	}
+	for _, call := range calls {
+		for _, token := range []string{"", "not-the-token"} {
+			err := sendJSON(call.method, agentURL+call.path, token, call.body, nil)
+			if err == nil || !strings.HasPrefix(err.Error(), "401") {
+				return fmt.Errorf("%s %s with token %q: %v, want 401", call.method, call.path, token, err)
+			}
+		}
+	}
+
+	// The orchestrator sends it: a pull through the admin API gets through
+	deadline := time.Now().Add(10 * time.Second)
+	for node.Resources == nil || node.Resources.DiskTotalBytes == 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s reported no disk space", mesh.nodeID)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if node, err = ms.node(mesh.nodeID); err != nil {
+			return err
+		}
+	}
+	var pulled shared.PullResult
+	if err := ms.admin("POST", "/admin/nodes/"+mesh.nodeID+"/pull", shared.PullRequest{Model: "tiny", SizeBytes: 1}, &pulled); err != nil {
+		return fmt.Errorf("pull through the orchestrator: %v", err)
+	}
+	if !pulled.Success {
+		return fmt.Errorf("pull through the orchestrator failed: %s", pulled.Error)
+	}
+	return nil
+}
+
+func eventBus(s *sim) error {
+	// Two replicas of their own on one broker
+	if orchestratorBin == "" {
+		return nil
+	}
+	busURL, stopBroker, err := startBroker()
+	if err != nil {
+		return err
+	}
+	defer stopBroker()
+	dir, err := os.MkdirTemp("", "meshsim-bus-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	replicas := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		url, stop, err := startReplica(dir, "replica-"+name, "-event-bus", busURL,
+			"-event-channel", s.prefix+"events", "-replica-id", s.prefix+name)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		replicas[name] = url
+	}
+
+	// A dashboard on b, a task on a
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(replicas["b"], "http")+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	a, err := startAgent(replicas["a"], s.prefix+"node", "mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	var res shared.TaskResult
+	if err := postJSON(replicas["a"]+"/task", shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "seen from b", NoDedup: true}, &res); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("no task_done for %s on replica b: %v", res.TaskID, err)
+		}
+		var ev struct {
+			Type    string           `json:"type"`
+			Replica string           `json:"replica"`
+			Data    shared.TaskEvent `json:"data"`
+		}
+		if json.Unmarshal(data, &ev) != nil || ev.Type != "task_done" || ev.Data.TaskID != res.TaskID {
+			continue
+		}
+		if ev.Replica != s.prefix+"a" {
+			return fmt.Errorf("task_done relayed with replica %q, want %sa", ev.Replica, s.prefix)
+		}
+		return nil
+	}
+}
+
+// readSSE reads a task stream's chunk events until the done chunk, or
+// until n chunks when n > 0, and returns their text, the last event id
+// and the done chunk.
+func readSSE(body io.Reader, n int) (text, lastID string, final *shared.TaskChunk, err error) {
+	var b strings.Builder
+	read := 0
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+			lastID = id
+			continue
+		}
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk shared.TaskChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return "", "", nil, fmt.Errorf("bad chunk %q: %w", line, err)
+		}
+		b.WriteString(chunk.Token)
+		if chunk.Done {
+			return b.String(), lastID, &chunk, nil
+		}
+		if read++; read == n {
+			return b.String(), lastID, nil, nil
+		}
+	}
+	return "", "", nil, fmt.Errorf("stream ended without a done chunk")
+}
+
+func streamGranularity(s *sim) error {
+	a, err := s.agent("mistral", 50*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	// The mock streams one word at a time
+	prompt := "One two. Three four! Five"
+	chunks, err := s.streamTask(shared.TaskRequest{
+		Type: shared.TaskTypeText, Prompt: prompt, StreamGranularity: shared.GranularitySentence, NoDedup: true,
+	})
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for i, c := range chunks {
+		text.WriteString(c.Token)
+		if !c.Done && !strings.HasSuffix(c.Token, ". ") && !strings.HasSuffix(c.Token, "! ") {
+			return fmt.Errorf("chunk %d %q doesn't end a sentence", i+1, c.Token)
+		}
+	}
+	if len(chunks) != 3 {
+		return fmt.Errorf("got %d chunks, want 3 (two sentences and the rest on done)", len(chunks))
+	}
+	if want := a.reply(prompt); strings.TrimSpace(text.String()) != want {
+		return fmt.Errorf("streamed %q, want %q", text.String(), want)
+	}
+	return nil
+}
+
+func jsonMode(s *sim) error {
+	a, err := s.agent("mistral", 50*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	for _, prompt := range []string{"list three facts", "leave it unterminated"} {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, Format: shared.FormatJSON, Prompt: prompt, NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if !res.Success || !json.Valid([]byte(res.Content)) {
+			return fmt.Errorf("task %q: success=%v, content %q isn't JSON (%s)", prompt, res.Success, res.Content, res.Error)
+		}
+		if want := prompt != "list three facts"; res.JSONRepaired != want {
+			return fmt.Errorf("task %q: json_repaired=%v, want %v", prompt, res.JSONRepaired, want)
+		}
+	}
+
+	chunks, err := s.streamTask(shared.TaskRequest{Type: shared.TaskTypeText, Format: shared.FormatJSON, Prompt: "stream it unterminated", NoDedup: true})
+	if err != nil {
+		return err
+	}
+	done := chunks[len(chunks)-1]
+	if done.Error != "" || !done.JSONRepaired || !json.Valid([]byte(done.Text)) {
+		return fmt.Errorf("done chunk: error %q, json_repaired=%v, text %q", done.Error, done.JSONRepaired, done.Text)
+	}
+	if got := a.executed.Load(); got != 5 {
+		return fmt.Errorf("agent ran %d generations, want 5 (three tasks and two repairs)", got)
+	}
+
+	err = postJSON(s.orch+"/task", shared.TaskRequest{Prompt: "x", Format: "yaml"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("format yaml: got %v, want 400", err)
+	}
+	return nil
+}
+
+func verifyTasks(s *sim) error {
+	answering, err := s.agent("mistral", 20*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	checking, err := s.agent("llama3", 20*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	for prompt, want := range map[string]shared.Verdict{"what is 17 * 23": shared.VerdictPass, "say something wrong": shared.VerdictFail} {
+		var res shared.TaskResult
+		req := shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "mistral", Prompt: prompt, Verify: true, NoDedup: true}
+		if err := postJSON(s.orch+"/task", req, &res); err != nil {
+			return err
+		}
+		if want := answering.reply(prompt); !res.Success || res.Content != want {
+			return fmt.Errorf("task %q: success=%v, content %q, want %q (%s)", prompt, res.Success, res.Content, want, res.Error)
+		}
+		v := res.Verification
+		if v == nil {
+			return fmt.Errorf("task %q: result carries no verification", prompt)
+		}
+		if v.Verdict != want || v.Confidence != 0.9 {
+			return fmt.Errorf("task %q: verdict %s (%.2f), want %s (0.90): %s", prompt, v.Verdict, v.Confidence, want, v.Error)
+		}
+		if v.Model != "llama3" || v.NodeID != checking.id || v.SameModel {
+			return fmt.Errorf("task %q: checked by %s on %s (same_model=%v), want llama3 on %s", prompt, v.Model, v.NodeID, v.SameModel, checking.id)
+		}
+		if (want == shared.VerdictFail) != (v.Issues != "") {
+			return fmt.Errorf("task %q: verdict %s with issues %q", prompt, v.Verdict, v.Issues)
+		}
+	}
+
+	chunks, err := s.streamTask(shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "mistral", Prompt: "stream it", Verify: true, NoDedup: true})
+	if err != nil {
+		return err
+	}
+	done := chunks[len(chunks)-1]
+	if done.Error != "" || done.Verification == nil || done.Verification.Verdict != shared.VerdictPass {
+		return fmt.Errorf("done chunk: error %q, verification %+v", done.Error, done.Verification)
+	}
+
+	err = postJSON(s.orch+"/task", shared.TaskRequest{Type: shared.TaskTypeEmbed, Input: []string{"x"}, Verify: true}, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("embed with verify: got %v, want 400", err)
+	}
+	return nil
+}
+
+func taskHistory(s *sim) error {
+	texter, err := s.agent("mistral", 10*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	coder, err := s.agent("codellama", 10*time.Millisecond, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+	startedAt := time.Now().UnixMilli()
+	for i := 1; i <= 3; i++ {
+		if _, err := s.task(shared.TaskTypeText, fmt.Sprintf("history %d", i)); err != nil {
+			return err
+		}
+	}
+	tags := map[string]string{"ticket": "HIST-7", "team": "infra"}
+	coded := shared.TaskRequest{Type: shared.TaskTypeCode, Prompt: "history in code", NoDedup: true, Metadata: tags}
+	if err := postJSON(s.orch+"/task", coded, nil); err != nil {
+		return err
+	}
+	if _, err := s.streamTask(shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "history streamed", NoDedup: true}); err != nil {
+		return err
+	}
+
+	history := func(query string) (shared.TaskHistoryPage, error) {
+		var page shared.TaskHistoryPage
+		err := sendJSON("GET", s.orch+"/history?"+query, "", nil, &page)
+		return page, err
+	}
+	check := func(query string, wantPrompts ...string) error {
+		page, err := history(query)
+		if err != nil {
+			return fmt.Errorf("history?%s: %w", query, err)
+		}
+		var got []string
+		for _, t := range page.Tasks {
+			got = append(got, t.Prompt)
+		}
+		if strings.Join(got, "|") != strings.Join(wantPrompts, "|") {
+			return fmt.Errorf("history?%s: tasks %q, want %q", query, got, wantPrompts)
+		}
+		return nil
+	}
+
+	page, err := history("node=" + texter.id)
+	if err != nil {
+		return err
+	}
+	if page.Count != 4 || page.NextCursor != "" {
+		return fmt.Errorf("node %s: %d tasks (next_cursor %q), want 4 on one page", texter.id, page.Count, page.NextCursor)
+	}
+	newest := page.Tasks[0]
+	if !newest.Stream || newest.ModelUsed != "mistral" || !newest.Success || strings.TrimSpace(newest.Content) != texter.reply("history streamed") {
+		return fmt.Errorf("streamed task recorded as %+v", newest)
+	}
+	if err := check("node="+texter.id+"&limit=2", "history streamed", "history 3"); err != nil {
+		return err
+	}
+	page, _ = history("node=" + texter.id + "&limit=2")
+	if err := check("node="+texter.id+"&limit=2&cursor="+page.NextCursor, "history 2", "history 1"); err != nil {
+		return err
+	}
+	if err := check("node="+coder.id+"&type=code", "history in code"); err != nil {
+		return err
+	}
+	if err := check("node=" + texter.id + "&type=code"); err != nil {
+		return err
+	}
+	if err := check(fmt.Sprintf("node=%s&until=%d", texter.id, startedAt)); err != nil {
+		return err
+	}
+	if err := check(fmt.Sprintf("node=%s&since=%d", coder.id, startedAt), "history in code"); err != nil {
+		return err
+	}
+	if _, err := history("limit=0"); err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("limit=0: got %v, want 400", err)
+	}
+
+	// Only an orchestrator meshsim started can be restarted
+	if restartOrchestrator == nil {
+		return nil
+	}
+	if err := restartOrchestrator(); err != nil {
+		return err
+	}
+	if err := check("node="+coder.id, "history in code"); err != nil {
+		return err
+	}
+	page, err = history("node=" + coder.id)
+	if err != nil {
+		return err
+	}
+	if got := page.Tasks[0].Metadata; !reflect.DeepEqual(got, tags) {
+		return fmt.Errorf("metadata after the restart %v, want %v", got, tags)
+	}
+	return nil
+}
+
+// streamTask runs a task through POST /task/stream and returns its
+// chunks, up to and including the done chunk.
+func (s *sim) streamTask(req shared.TaskRequest) ([]shared.TaskChunk, error) {
+	data, _ := json.Marshal(req)
+	resp, err := httpClient.Post(s.orch+"/task/stream", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpError(resp)
+	}
+	var chunks []shared.TaskChunk
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk shared.TaskChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("bad chunk %q: %w", line, err)
+		}
+		chunks = append(chunks, chunk)
+		if chunk.Done {
+			return chunks, nil
+		}
+	}
+	return nil, fmt.Errorf("stream ended without a done chunk")
+}
+
+func taskTimings(s *sim) error {
+	const delay = 80 * time.Millisecond
+	a, err := s.agent("mistral", delay, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	const n = 3
+	for i := 0; i < n; i++ {
+		res, err := s.task(shared.TaskTypeText, "time me")
+		if err != nil {
+			return err
+		}
+		if res.Timings == nil {
+			return fmt.Errorf("task %d: result carries no timings", i+1)
+		}
+		if res.Timings.GenerationMs < delay.Milliseconds() {
+			return fmt.Errorf("task %d: generation %dms, want at least %dms", i+1, res.Timings.GenerationMs, delay.Milliseconds())
+		}
+	}
+
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.Timings == nil || node.Timings.Samples != n {
+		return fmt.Errorf("node timings %+v, want %d samples", node.Timings, n)
+	}
+	if node.Timings.AvgGenerationMs < float64(delay.Milliseconds()) {
+		return fmt.Errorf("node average generation %.0fms, want at least %dms", node.Timings.AvgGenerationMs, delay.Milliseconds())
+	}
+	return nil
+}
+
+func embedBatch(s *sim) error {
+	if _, err := s.agent("nomic-embed-text", 0, shared.TaskTypeEmbed); err != nil {
+		return err
+	}
+	const n = 100
+	req := shared.TaskRequest{Type: shared.TaskTypeEmbed, NoDedup: true}
+	for i := 0; i < n; i++ {
+		req.Input = append(req.Input, strings.Repeat("x", i+1))
+	}
+	var res shared.TaskResult
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return err
+	}
+	if !res.Success || len(res.Embeddings) != n {
+		return fmt.Errorf("embed task: success %v with %d embeddings, want %d", res.Success, len(res.Embeddings), n)
+	}
+	for i, v := range res.Embeddings {
+		if len(v) != 2 || v[0] != float32(i+1) || v[1] != float32(i) {
+			return fmt.Errorf("embedding %d is %v, want the one for input %d", i, v, i)
+		}
+	}
+	if t := res.EmbedTimings; t == nil || t.Items != n || t.Requests != 2 || len(t.ItemMs) != n {
+		return fmt.Errorf("embed timings %+v, want %d items in 2 requests", res.EmbedTimings, n)
+	}
+	if res.PromptTokens == 0 {
+		return fmt.Errorf("embed task counted no prompt tokens")
+	}
+
+	refused := []struct {
+		path string
+		req  shared.TaskRequest
+	}{
+		{"/task", shared.TaskRequest{Type: shared.TaskTypeText, Input: []string{"a"}}},
+		{"/task", shared.TaskRequest{Type: shared.TaskTypeEmbed, Input: []string{"a", ""}}},
+		{"/task", shared.TaskRequest{Type: shared.TaskTypeEmbed, Input: []string{"a"}, AllowCloud: true}},
+		{"/task/stream", shared.TaskRequest{Type: shared.TaskTypeEmbed, Input: []string{"a"}}},
+	}
+	for _, r := range refused {
+		if err := postJSON(s.orch+r.path, r.req, nil); err == nil || !strings.Contains(err.Error(), "400") {
+			return fmt.Errorf("POST %s with input %q, type %s: %v, want 400", r.path, r.req.Input, r.req.Type, err)
+		}
+	}
+	return nil
+}
+
+func transfer(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	prompt := strings.Repeat("a long document to summarize ", 400)
+	res, err := s.task(shared.TaskTypeText, prompt)
+	if err != nil {
+		return err
+	}
+	if t := res.Transfer; t == nil || t.SentBytes < int64(len(prompt)) || t.ReceivedBytes <= 0 {
+		return fmt.Errorf("result transfer %+v, want at least %d bytes sent and some received", t, len(prompt))
+	}
+
+	chunks, err := s.streamTask(shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "stream a little", NoDedup: true})
+	if err != nil {
+		return err
+	}
+	done := chunks[len(chunks)-1]
+	if t := done.Transfer; t == nil || t.SentBytes <= 0 || t.ReceivedBytes <= 0 {
+		return fmt.Errorf("done chunk transfer %+v, want bytes both ways", t)
+	}
+
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	want := shared.TaskTransfer{
+		SentBytes:     res.Transfer.SentBytes + done.Transfer.SentBytes,
+		ReceivedBytes: res.Transfer.ReceivedBytes + done.Transfer.ReceivedBytes,
+	}
+	if node.Transfer == nil || *node.Transfer != want {
+		return fmt.Errorf("node transfer %+v, want %+v", node.Transfer, want)
+	}
+	return nil
+}
+
+func canaryNode(s *sim) error {
+	prod, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	canary, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := canary.setCanary(); err != nil {
+		return err
+	}
+
+	for i := 0; i < 6; i++ {
+		res, err := s.task(shared.TaskTypeText, "production traffic")
+		if err != nil {
+			return err
+		}
+		if res.RoutedTo != prod.id {
+			return fmt.Errorf("task %d routed to %s, want the production node %s", i+1, res.RoutedTo, prod.id)
+		}
+	}
+
+	var res shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "try the new version", TargetNode: canary.id, NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &res); err != nil {
+		return err
+	}
+	if res.RoutedTo != canary.id {
+		return fmt.Errorf("targeted task routed to %s, want %s", res.RoutedTo, canary.id)
+	}
+
+	// A failing target fails the task rather than moving it
+	canary.setMode(behaveFail)
+	before := prod.executed.Load()
+	if err := postJSON(s.orch+"/task", req, nil); err == nil || !strings.Contains(err.Error(), "503") {
+		return fmt.Errorf("task targeting a failing canary: got %v, want 503", err)
+	}
+	if got := prod.executed.Load(); got != before {
+		return fmt.Errorf("task targeting a failing canary failed over to %s", prod.id)
+	}
+	node, err := s.node(canary.id)
+	if err != nil {
+		return err
+	}
+	if !node.Canary {
+		return fmt.Errorf("GET /status doesn't mark %s as a canary", canary.id)
+	}
+	return nil
+}
+
+func compress(s *sim) error {
+	small, err := s.agent("tiny", 0, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+	large, err := s.agent("large", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	opts := &shared.CompressOptions{TargetTokens: 100, Model: "tiny"}
+	transcript := strings.Repeat("A long transcript of the weekly meeting. ", 200)
+
+	// Short prompts are left alone
+	var result shared.TaskResult
+	req := shared.TaskRequest{Prompt: "Say hi", ModelHint: "large", Compress: opts, NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return err
+	}
+	if small.executed.Load() != 0 || result.Metadata["echo.compress"] != "" {
+		return fmt.Errorf("short prompt was compressed (%q)", result.Metadata["echo.compress"])
+	}
+
+	req.Prompt = transcript + "What was decided?"
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return err
+	}
+	if small.executed.Load() != 1 || result.RoutedTo != large.id {
+		return fmt.Errorf("long prompt: %d compressions, routed to %s, want 1 and %s", small.executed.Load(), result.RoutedTo, large.id)
+	}
+	// The large model read tiny's compressed version
+	if note := result.Metadata["echo.compress"]; !strings.HasPrefix(note, "compressed") || !strings.Contains(result.Content, small.id) {
+		return fmt.Errorf("long prompt: note %q, content %q, want the compressed prompt", note, result.Content)
+	}
+
+	// A compress step feeds the next step the compressed input
+	var run shared.PipelineResult
+	pipeline := shared.PipelineRequest{
+		InitialInput: transcript,
+		Steps: []shared.PipelineStep{
+			{Compress: opts},
+			{Type: shared.TaskTypeText, ModelHint: "large", PromptTemplate: "{{prev_output}}"},
+		},
+	}
+	if err := postJSON(s.orch+"/pipeline", pipeline, &run); err != nil {
+		return err
+	}
+	if len(run.Steps) != 2 || run.Steps[0].RoutedTo != small.id || !strings.Contains(run.FinalOutput, small.id) {
+		return fmt.Errorf("pipeline steps %+v, final output %q, want a compress step on %s", run.Steps, run.FinalOutput, small.id)
+	}
+	return nil
+}
+
+// uploadFile posts data to POST /files and returns the stored file and
+// the response status.
+func (s *sim) uploadFile(name string, data []byte) (shared.FileInfo, int, error) {
+	var info shared.FileInfo
+	resp, err := httpClient.Post(s.orch+"/files?name="+name, "text/plain", bytes.NewReader(data))
+	if err != nil {
+		return info, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return info, resp.StatusCode, httpError(resp)
+	}
+	return info, resp.StatusCode, json.NewDecoder(resp.Body).Decode(&info)
+}
+
+func taskFiles(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	notes := []byte("Quarterly numbers are up " + uuid.New().String() + "\n")
+	info, status, err := s.uploadFile("notes.txt", notes)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated || info.ID != shared.FileID(notes) || info.SizeBytes != int64(len(notes)) {
+		return fmt.Errorf("upload answered %d with %+v, want 201 and the content's hash", status, info)
+	}
+	if again, status, err := s.uploadFile("copy.txt", notes); err != nil || status != http.StatusOK || again.ID != info.ID || again.Name != "notes.txt" {
+		return fmt.Errorf("re-upload answered %d with %+v (%v), want 200 and the first upload", status, again, err)
+	}
+
+	var result shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "Summarize the notes", Files: []string{info.ID}, NoDedup: true}
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return err
+	}
+	if !result.Success || !strings.Contains(result.Content, "--- file: notes.txt ---") {
+		return fmt.Errorf("task with a file answered %q, want the file ahead of the prompt", result.Content)
+	}
+	if n := a.fetched.Load(); n != 1 {
+		return fmt.Errorf("agent fetched %d files, want 1", n)
+	}
+
+	req.Files = []string{shared.FileID([]byte("never uploaded"))}
+	if err := postJSON(s.orch+"/task", req, &result); err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("task with an unknown file: got %v, want 400", err)
+	}
+
+	if err := s.admin("DELETE", "/admin/files/"+info.ID, nil, nil); err != nil {
+		return err
+	}
+	req.Files = []string{info.ID}
+	if err := postJSON(s.orch+"/task", req, &result); err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("task with a deleted file: got %v, want 400", err)
+	}
+	return nil
+}
+
+func clockSkew(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if node, err := s.node(a.id); err != nil || node.Health != shared.HealthGreen {
+		return fmt.Errorf("node with a synced clock: %+v (%v), want green", node, err)
+	}
+
+	a.setClockSkew(time.Hour)
+	if err := s.waitForNode(a.id, func(n *shared.NodeInfo) bool {
+		return n.ClockSkewMs > 59*60_000 && n.Health == shared.HealthYellow && strings.Contains(n.HealthReason, "clock")
+	}); err != nil {
+		return fmt.Errorf("an hour ahead: %w", err)
+	}
+
+	var list shared.ModelListResponse
+	if err := sendJSON("GET", s.orch+"/nodes/"+a.id+"/models", "", nil, &list); err != nil {
+		return err
+	}
+	if len(list.Details) != 1 {
+		return fmt.Errorf("model list has %d details, want 1", len(list.Details))
+	}
+	if off := time.Since(time.UnixMilli(list.Details[0].LastUsed)).Abs(); off > 5*time.Second {
+		return fmt.Errorf("last_used relayed %s off orchestrator time", off.Round(time.Second))
+	}
+
+	a.setClockSkew(0)
+	return s.waitForNode(a.id, func(n *shared.NodeInfo) bool { return n.Health == shared.HealthGreen })
+}
+
+func selfTest(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	var report shared.SelfTestReport
+	if err := s.admin("POST", "/admin/nodes/"+a.id+"/selftest", nil, &report); err != nil {
+		return err
+	}
+	if !report.OK || report.NodeID != a.id || len(report.Checks) != 4 {
+		return fmt.Errorf("healthy node's self-test: %+v, want ok with 4 checks", report)
+	}
+
+	a.setMode(behaveFail)
+	a.setClockSkew(time.Hour)
+	if err := s.waitForNode(a.id, func(n *shared.NodeInfo) bool { return n.ClockSkewMs > 59*60_000 }); err != nil {
+		return fmt.Errorf("an hour ahead: %w", err)
+	}
+	report = shared.SelfTestReport{}
+	if err := s.admin("POST", "/admin/nodes/"+a.id+"/selftest", shared.SelfTestRequest{Models: []string{"mistral"}}, &report); err != nil {
+		return err
+	}
+	statuses := make(map[string]string)
+	for _, c := range report.Checks {
+		statuses[c.Check] = c.Status
+	}
+	if report.OK || statuses["model"] != shared.SelfTestFail || statuses["clock"] != shared.SelfTestWarn {
+		return fmt.Errorf("failing, skewed node's self-test: ok=%v, checks %v; want a failed model and a clock warning", report.OK, statuses)
+	}
+	if off := time.Since(time.UnixMilli(report.StartedAt)).Abs(); off > 5*time.Second {
+		return fmt.Errorf("started_at relayed %s off orchestrator time", off.Round(time.Second))
+	}
+	a.setMode(behaveOK)
+	a.setClockSkew(0)
+
+	err = s.admin("POST", "/admin/nodes/sim-no-such-node/selftest", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("self-test of an unknown node: %v, want 404", err)
+	}
+	return nil
+}
+
+// simInventory is the -inventory file meshsim starts the orchestrator with;
+// against a running orchestrator, inventory needs it set the same way.
+const simInventory = `{"nodes": [
+  {"node_id": "sim-inventory-1", "host": "127.0.0.1", "models": ["mistral"]},
+  {"node_id": "sim-inventory-ghost", "host": "10.0.0.99", "port": 9001, "models": ["mistral"]}
+]}`
+
+func inventoryNodes(s *sim) error {
+	ghost, err := s.node("sim-inventory-ghost")
+	if err != nil {
+		return fmt.Errorf("%v — start the orchestrator with meshsim's inventory", err)
+	}
+	if ghost.Status != shared.StatusAbsent || ghost.AgentHost != "10.0.0.99" || ghost.AgentPort != 9001 {
+		return fmt.Errorf("never-registered node: status %s at %s:%d, want absent at 10.0.0.99:9001", ghost.Status, ghost.AgentHost, ghost.AgentPort)
+	}
+	if node, err := s.node("sim-inventory-1"); err != nil || node.Status != shared.StatusAbsent {
+		return fmt.Errorf("inventory node before it joins: %+v (%v), want absent", node, err)
+	}
+
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	node, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if node.Status == shared.StatusAbsent {
+		return fmt.Errorf("%s still absent after registering", a.id)
+	}
+	return nil
+}
+
+func backToBack(s *sim) error {
+	a, err := s.agent("mistral", 100*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	// Two batches of concurrent tasks: every task but the very first
+	// continues the run, and the second batch reuses the first's connections
+	const batch = 6
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, batch)
+		for i := 0; i < batch; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: fmt.Sprintf("summarize section %d.%d", round, i), NoDedup: true}
+				if err := postJSON(s.orch+"/task", req, nil); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	if got := a.executed.Load(); got != 2*batch {
+		return fmt.Errorf("agent ran %d of %d tasks", got, 2*batch)
+	}
+	if got := a.keptHot.Load(); got != 2*batch-1 {
+		return fmt.Errorf("%d tasks carried a keep_alive, want %d (all but the first)", got, 2*batch-1)
+	}
+	if got := a.conns.Load(); got > batch {
+		return fmt.Errorf("orchestrator opened %d connections to the agent for %d tasks, %d at a time", got, 2*batch, batch)
+	}
+	return nil
+}
+
+func exclusiveModel(s *sim) error {
+	a, err := s.agent("llama3:70b", 200*time.Millisecond, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	if err := a.setExclusive(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.task(shared.TaskTypeText, "one at a time"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	if got := a.executed.Load(); got != 4 {
+		return fmt.Errorf("agent ran %d of 4 tasks", got)
+	}
+	if max := a.maxActive.Load(); max != 1 {
+		return fmt.Errorf("exclusive model ran %d generations at once", max)
+	}
+	return nil
+}
+
+func contextShaping(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+
+	// ~40 turns of ~150 tokens overflow the default 4096-token window
+	msgs := []shared.ChatMessage{{Role: "system", Content: "You are terse."}}
+	turn := strings.Repeat("the mesh routes tasks to local nodes ", 25)
+	for i := 0; i < 20; i++ {
+		msgs = append(msgs,
+			shared.ChatMessage{Role: "user", Content: fmt.Sprintf("question %d: %s", i, turn)},
+			shared.ChatMessage{Role: "assistant", Content: fmt.Sprintf("answer %d: %s", i, turn)})
+	}
+	msgs = append(msgs, shared.ChatMessage{Role: "user", Content: "and finally?"})
+
+	var result shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Messages: msgs, Metadata: map[string]string{"client": "meshsim"}}
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return err
+	}
+	note := result.Metadata["echo.context"]
+	if !strings.HasPrefix(note, "summarized ") {
+		return fmt.Errorf("no summarization note in metadata %v", result.Metadata)
+	}
+	if result.Metadata["client"] != "meshsim" {
+		return fmt.Errorf("client metadata lost: %v", result.Metadata)
+	}
+	if got := a.executed.Load(); got != 2 {
+		return fmt.Errorf("agent ran %d tasks, want 2 (summary + reply)", got)
+	}
+
+	// A short chat passes through untouched
+	var short shared.TaskResult
+	req.Messages = msgs[len(msgs)-3:]
+	if err := postJSON(s.orch+"/task", req, &short); err != nil {
+		return err
+	}
+	if note, ok := short.Metadata["echo.context"]; ok {
+		return fmt.Errorf("short chat was shaped: %s", note)
+	}
+	return nil
+}
+
+// simContextWindows is the -context-windows meshsim starts the
+// orchestrator with; against a running orchestrator, conversations needs
+// it set the same way.
+const simContextWindows = "sim-longctx:32768,sim-shortctx:1024"
+
+func conversationPins(s *sim) error {
+	long, err := s.agent("sim-longctx", 0, shared.TaskTypeText, shared.TaskTypeSummarize)
+	if err != nil {
+		return err
+	}
+	short, err := s.agent("sim-shortctx", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+
+	var conv shared.Conversation
+	start := shared.ConversationRequest{Type: shared.TaskTypeText, Model: "sim-longctx",
+		Messages: []shared.ChatMessage{{Role: "system", Content: "You are terse."}}}
+	if err := postJSON(s.orch+"/conversations", start, &conv); err != nil {
+		return err
+	}
+	base := s.orch + "/conversations/" + conv.ConversationID
+	say := func(content string) (shared.TaskResult, error) {
+		var res shared.TaskResult
+		err := postJSON(base+"/messages", shared.ConversationTurn{Content: content}, &res)
+		return res, err
+	}
+
+	// Six turns of ~200 tokens fit the long model's window, not the short one's
+	turn := strings.Repeat("the mesh routes tasks to local nodes ", 25)
+	for i := 0; i < 6; i++ {
+		res, err := say(fmt.Sprintf("question %d: %s", i, turn))
+		if err != nil {
+			return err
+		}
+		if res.RoutedTo != long.id {
+			return fmt.Errorf("turn %d of a conversation pinned to sim-longctx ran on %s", i, res.RoutedTo)
+		}
+	}
+	if err := sendJSON("GET", base, "", nil, &conv); err != nil {
+		return err
+	}
+	if len(conv.Messages) != 13 {
+		return fmt.Errorf("conversation has %d messages after 6 turns, want 13", len(conv.Messages))
+	}
+	if last := conv.Messages[12]; last.Role != "assistant" || last.Model != "sim-longctx" || last.NodeID != long.id || last.TaskID == "" {
+		return fmt.Errorf("last message is %+v, want sim-longctx's answer on %s", last, long.id)
+	}
+
+	// Switching to the small window summarizes the older turns once
+	if err := postJSON(base+"/switch", shared.ConversationPinRequest{Model: "sim-shortctx"}, &conv); err != nil {
+		return err
+	}
+	change := conv.Changes[len(conv.Changes)-1]
+	if change.Kind != shared.ConversationSwitch || change.FromModel != "sim-longctx" || change.Model != "sim-shortctx" || change.AtMessage != 13 {
+		return fmt.Errorf("switch recorded as %+v", change)
+	}
+	if change.Summarized == 0 || conv.Summary == "" || conv.SummaryThrough <= 1 {
+		return fmt.Errorf("switch to a smaller window summarized nothing (%+v; is the orchestrator running with -context-windows %q?)", change, simContextWindows)
+	}
+	if len(conv.Messages) != 13 {
+		return fmt.Errorf("switch changed the history to %d messages", len(conv.Messages))
+	}
+	res, err := say("and now?")
+	if err != nil {
+		return err
+	}
+	if res.RoutedTo != short.id {
+		return fmt.Errorf("turn after the switch ran on %s, want %s", res.RoutedTo, short.id)
+	}
+	if note, ok := res.Metadata["echo.context"]; ok {
+		return fmt.Errorf("turn after the switch was shaped again: %s", note)
+	}
+
+	// Pinning a node the model isn't on is refused; pinning the node alone works
+	err = sendJSON("PUT", base+"/pin", "", shared.ConversationPinRequest{Model: "sim-longctx", NodeID: short.id}, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("pinning %s to a model it lacks: got %v, want 400", short.id, err)
+	}
+	if err := sendJSON("PUT", base+"/pin", "", shared.ConversationPinRequest{NodeID: long.id}, &conv); err != nil {
+		return err
+	}
+	if res, err = say("still there?"); err != nil {
+		return err
+	}
+	if res.RoutedTo != long.id {
+		return fmt.Errorf("turn pinned to %s ran on %s", long.id, res.RoutedTo)
+	}
+	var unpinned shared.Conversation
+	if err := sendJSON("DELETE", base+"/pin", "", nil, &unpinned); err != nil {
+		return err
+	}
+	if change := unpinned.Changes[len(unpinned.Changes)-1]; change.Kind != shared.ConversationUnpin || change.FromNode != long.id || unpinned.NodeID != "" {
+		return fmt.Errorf("unpin recorded as %+v", change)
+	}
+	if len(unpinned.Changes) != 4 {
+		return fmt.Errorf("conversation recorded %d changes, want 4 (pin, switch, pin, unpin)", len(unpinned.Changes))
+	}
+
+	if err := sendJSON("DELETE", base, "", nil, nil); err != nil {
+		return err
+	}
+	if err := sendJSON("GET", base, "", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("deleted conversation: got %v, want 404", err)
+	}
+	return nil
+}
+
+func taskFeedback(s *sim) error {
+	const model = "feedback-model"
+	a, err := s.agent(model, 0, shared.TaskTypeCode)
+	if err != nil {
+		return err
+	}
+	good, err := s.task(shared.TaskTypeCode, "write a good function")
+	if err != nil {
+		return err
+	}
+	bad, err := s.task(shared.TaskTypeCode, "write a bad function")
+	if err != nil {
+		return err
+	}
+	give := func(taskID string, req shared.FeedbackRequest) (*shared.TaskFeedback, error) {
+		var fb shared.TaskFeedback
+		err := postJSON(s.orch+"/tasks/"+taskID+"/feedback", req, &fb)
+		return &fb, err
+	}
+	if _, err := give(good.TaskID, shared.FeedbackRequest{Rating: shared.RatingUp}); err != nil {
+		return err
+	}
+	before, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	down := shared.FeedbackRequest{Rating: shared.RatingDown, Comment: "off by one", CorrectedOutput: "func f() {}"}
+	fb, err := give(bad.TaskID, down)
+	if err != nil {
+		return err
+	}
+	if fb.NodeID != a.id || fb.Model != model || fb.TaskType != shared.TaskTypeCode {
+		return fmt.Errorf("feedback recorded as %+v, want node %s, model %s, type code", fb, a.id, model)
+	}
+	after, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if after.Reputation >= before.Reputation {
+		return fmt.Errorf("reputation %.3f after a thumbs down, want below %.3f", after.Reputation, before.Reputation)
+	}
+	if _, err := give(bad.TaskID, down); err != nil {
+		return err
+	}
+	if again, err := s.node(a.id); err != nil || again.Reputation != after.Reputation {
+		return fmt.Errorf("reputation after the same rating again: %v (err %v), want %.3f unchanged", again, err, after.Reputation)
+	}
+
+	var summary shared.FeedbackSummary
+	if err := sendJSON("GET", s.orch+"/stats/feedback?type=code", "", nil, &summary); err != nil {
+		return err
+	}
+	want := func(list []shared.FeedbackStats, match func(shared.FeedbackStats) bool, what string) error {
+		for _, st := range list {
+			if match(st) {
+				if st.Up != 1 || st.Down != 1 || st.Comments != 1 || st.Corrections != 1 || st.Score != 0.5 {
+					return fmt.Errorf("%s feedback %+v, want 1 up, 1 down, 1 comment, 1 correction, score 0.5", what, st)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("no %s in the feedback summary %+v", what, summary)
+	}
+	if err := want(summary.Models, func(st shared.FeedbackStats) bool { return st.Model == model }, "model"); err != nil {
+		return err
+	}
+	if err := want(summary.Workloads, func(st shared.FeedbackStats) bool { return st.Model == model && st.TaskType == shared.TaskTypeCode }, "workload"); err != nil {
+		return err
+	}
+	if err := want(summary.Nodes, func(st shared.FeedbackStats) bool { return st.NodeID == a.id }, "node"); err != nil {
+		return err
+	}
+
+	var rec shared.TaskFeedback
+	if err := sendJSON("GET", s.orch+"/tasks/"+bad.TaskID+"/feedback", "", nil, &rec); err != nil || rec.Comment != down.Comment {
+		return fmt.Errorf("GET the task's feedback: %+v (err %v), want the comment", rec, err)
+	}
+	if _, err := give("no-such-task", shared.FeedbackRequest{Rating: shared.RatingUp}); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("feedback on an unknown task: %v, want 404", err)
+	}
+	if _, err := give(good.TaskID, shared.FeedbackRequest{Rating: "meh"}); err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("feedback with an unknown rating: %v, want 400", err)
+	}
+	return nil
+}
+
+// simSSEKeepAlive is the orchestrator's -sse-keepalive, short enough for
+// a slow stream to get keep-alives between its words.
+const simSSEKeepAlive = 100 * time.Millisecond
+
+func sseProxy(s *sim) error {
+	// Words come 400ms apart
+	if _, err := s.agent("sim-slowstream", 4*time.Second, shared.TaskTypeText); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(shared.TaskRequest{Type: shared.TaskTypeText, ModelHint: "sim-slowstream", Prompt: "one two three", NoDedup: true})
+	resp, err := httpClient.Post(s.orch+"/task/stream", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(resp)
+	}
+	if got := resp.Header.Get("X-Accel-Buffering"); got != "no" {
+		return fmt.Errorf("X-Accel-Buffering %q, want no", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); !strings.Contains(got, "no-transform") {
+		return fmt.Errorf("Cache-Control %q, want no-transform", got)
+	}
+
+	var lines []string
+	keepAlives, done := 0, false
+	scanner := bufio.NewScanner(resp.Body)
+	for !done && scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.HasPrefix(line, ":") {
+			keepAlives++
+		}
+		if payload, ok := strings.CutPrefix(line, "data: "); ok {
+			var chunk shared.TaskChunk
+			if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+				return fmt.Errorf("bad chunk %q: %w", payload, err)
+			}
+			done = chunk.Done
+		}
+	}
+	if !done {
+		return fmt.Errorf("stream ended without a done chunk")
+	}
+	if lines[0] != "retry: 3000" {
+		return fmt.Errorf("stream opens with %q, want the retry hint", lines[0])
+	}
+	if keepAlives == 0 {
+		return fmt.Errorf("no keep-alive comments between words %v apart", 4*time.Second/10)
+	}
+	return nil
+}
+
+func openAPI(s *sim) error {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Required bool `json:"required"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	if err := s.admin("GET", "/openapi.json", nil, &spec); err != nil {
+		return err
+	}
+	if len(spec.Paths) == 0 {
+		return fmt.Errorf("spec documents no paths")
+	}
+	for path, ops := range spec.Paths {
+		// Skip parameterized paths and the WebSocket upgrade
+		get, ok := ops["get"]
+		if !ok || strings.Contains(path, "{") || path == "/ws" {
+			continue
+		}
+		required := false
+		for _, p := range get.Parameters {
+			required = required || p.Required
+		}
+		if required {
+			continue
+		}
+		if err := s.admin("GET", path, nil, nil); err != nil {
+			return fmt.Errorf("GET %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func shareLinks(s *sim) error {
+	writer, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	var result shared.TaskResult
+	req := shared.TaskRequest{Type: shared.TaskTypeText, Prompt: "share me", NoDedup: true, Metadata: map[string]string{"user": "alice"}}
+	if err := postJSON(s.orch+"/task", req, &result); err != nil {
+		return err
+	}
+	private, err := s.task(shared.TaskTypeText, "keep me private")
+	if err != nil {
+		return err
+	}
+	var link shared.ShareLink
+	if err := postJSON(s.orch+"/tasks/"+result.TaskID+"/share", shared.ShareRequest{ExpiresIn: "1h"}, &link); err != nil {
+		return err
+	}
+	if link.TaskID != result.TaskID || link.ExpiresAt <= time.Now().Add(59*time.Minute).UnixMilli() {
+		return fmt.Errorf("share link %+v: want task %s for an hour", link, result.TaskID)
+	}
+	var got shared.SharedResult
+	if err := sendJSON("GET", s.orch+link.URL, "", nil, &got); err != nil {
+		return err
+	}
+	if got.Result == nil || got.Result.Content != writer.reply("share me") || got.Run != nil {
+		return fmt.Errorf("share link served %+v, want the task's result only", got)
+	}
+	if got.Result.Metadata != nil {
+		return fmt.Errorf("shared result exposes the request metadata %v", got.Result.Metadata)
+	}
+
+	// A link can't be altered to reach another result
+	payload, mac, _ := strings.Cut(link.Token, ".")
+	claims, _ := base64.RawURLEncoding.DecodeString(payload)
+	claims = bytes.Replace(claims, []byte(result.TaskID), []byte(private.TaskID), 1)
+	forged := "/share/" + base64.RawURLEncoding.EncodeToString(claims) + "." + mac
+	if err := sendJSON("GET", s.orch+forged, "", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("altered share link: got %v, want 404", err)
+	}
+	var short shared.ShareLink
+	if err := postJSON(s.orch+"/tasks/"+result.TaskID+"/share", shared.ShareRequest{ExpiresIn: "1ms"}, &short); err != nil {
+		return err
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := sendJSON("GET", s.orch+short.URL, "", nil, nil); err == nil || !strings.Contains(err.Error(), "410") {
+		return fmt.Errorf("expired share link: got %v, want 410", err)
+	}
+	if err := postJSON(s.orch+"/tasks/no-such-task/share", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		return fmt.Errorf("sharing an unknown task: got %v, want 404", err)
+	}
+
+	var run shared.PipelineResult
+	preq := shared.PipelineRequest{InitialInput: "a poem", Steps: []shared.PipelineStep{{Type: shared.TaskTypeText, PromptTemplate: "Write {{initial_input}}"}}}
+	if err := postJSON(s.orch+"/pipeline", preq, &run); err != nil {
+		return err
+	}
+	var runLink shared.ShareLink
+	if err := postJSON(s.orch+"/pipelines/runs/"+run.PipelineID+"/share", nil, &runLink); err != nil {
+		return err
+	}
+	got = shared.SharedResult{}
+	if err := sendJSON("GET", s.orch+runLink.URL, "", nil, &got); err != nil {
+		return err
+	}
+	if got.Run == nil || got.Run.PipelineID != run.PipelineID || got.Run.FinalOutput != run.FinalOutput {
+		return fmt.Errorf("pipeline share link served %+v, want run %s", got, run.PipelineID)
+	}
+
+	// Revoking replaces the key: every link stops working
+	if err := s.admin("DELETE", "/admin/shares", nil, nil); err != nil {
+		return err
+	}
+	for _, url := range []string{link.URL, runLink.URL} {
+		if err := sendJSON("GET", s.orch+url, "", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+			return fmt.Errorf("revoked share link: got %v, want 404", err)
+		}
+	}
+	return nil
+}
+
+func problemJSON(s *sim) error {
+	problem := func(body string) (shared.Problem, error) {
+		var p shared.Problem
+		resp, err := httpClient.Post(s.orch+"/task", "application/json", strings.NewReader(body))
+		if err != nil {
+			return p, err
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != shared.ProblemContentType {
+			return p, fmt.Errorf("%s answered with Content-Type %q, want %s", resp.Status, ct, shared.ProblemContentType)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			return p, err
+		}
+		if p.Status != resp.StatusCode {
+			return p, fmt.Errorf("problem status %d on a %s response", p.Status, resp.Status)
+		}
+		return p, nil
+	}
+
+	p, err := problem(`{"prompt": `)
+	if err != nil {
+		return err
+	}
+	if p.Type != shared.ProblemInvalidRequest || p.Retryable || p.Instance != "/task" {
+		return fmt.Errorf("malformed task: got %+v, want a non-retryable %s for /task", p, shared.ProblemInvalidRequest)
+	}
+
+	p, err = problem(`{"task_id": "problem-1", "prompt": "hello", "target_node": "no-such-node", "no_dedup": true, "queue_timeout_ms": -1}`)
+	if err != nil {
+		return err
+	}
+	if p.Type != shared.ProblemUnavailable || !p.Retryable || p.TaskID != "problem-1" || p.Detail == "" {
+		return fmt.Errorf("task for a missing node: got %+v, want a retryable %s for task problem-1", p, shared.ProblemUnavailable)
+	}
+	return nil
+}
+
+func agentIdentity(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	if err := a.setIdentity(key); err != nil {
+		return err
+	}
+	if _, err := s.task(shared.TaskTypeText, "before the restart"); err != nil {
+		return err
+	}
+	before, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if before.Identity == "" || before.Transfer == nil {
+		return fmt.Errorf("node %s before the restart: identity %q, transfer %v; want both", a.id, before.Identity, before.Transfer)
+	}
+
+	// Another agent claiming the ID, with its own key or none
+	impostor, err := listenAgent(s.orch, a.id, "mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	s.agents = append(s.agents, impostor)
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	for _, k := range []ed25519.PrivateKey{other, nil} {
+		impostor.key = k
+		if err := impostor.register(); err == nil || !strings.Contains(err.Error(), "pinned") {
+			return fmt.Errorf("another agent (key %v) registering %s: %v, want a refusal naming the pinned identity", k != nil, a.id, err)
+		}
+	}
+
+	// A restart on another port, before the node has timed out
+	b, err := a.restart()
+	s.agents = append(s.agents, b)
+	if err != nil {
+		return fmt.Errorf("agent %s restarted with its identity: %w", a.id, err)
+	}
+	after, err := s.node(a.id)
+	if err != nil {
+		return err
+	}
+	if after.AgentPort != b.port || after.Identity != before.Identity || after.Transfer == nil ||
+		after.Transfer.SentBytes != before.Transfer.SentBytes || after.Reputation != before.Reputation {
+		return fmt.Errorf("node %s after the restart: %+v, want port %d with the identity, transfer and reputation of %+v", a.id, after, b.port, before)
+	}
+	if _, err := s.task(shared.TaskTypeText, "after the restart"); err != nil {
+		return err
+	}
+
+	// A registration signed long ago doesn't get in again
+	replay := shared.RegisterRequest{NodeID: a.id, AgentHost: "127.0.0.1", AgentPort: b.port, Time: 1,
+		IdentityKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), APIVersion: shared.MeshAPIVersion}
+	replay.IdentityProof = shared.SignIdentityProof(key, &replay)
+	if err := postJSON(s.orch+"/register", replay, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		return fmt.Errorf("replayed registration: %v, want 401", err)
+	}
+
+	// Nor does a fresh one moved to another address
+	moved := replay
+	moved.Time = time.Now().UnixMilli() + 60_000
+	moved.IdentityProof = shared.SignIdentityProof(key, &moved)
+	moved.AgentHost, moved.AgentPort = "10.0.0.66", 6666
+	if err := postJSON(s.orch+"/register", moved, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		return fmt.Errorf("registration signed for another address: %v, want 401", err)
+	}
+
+	// Evicting the node forgets its identity
+	b.close()
+	if err := s.admin("DELETE", "/admin/nodes/"+a.id, nil, nil); err != nil {
+		return err
+	}
+	impostor.key = other
+	if err := impostor.register(); err != nil {
+		return fmt.Errorf("agent with a new key after the eviction: %w", err)
+	}
+	return nil
+}
+
+func requestChain(s *sim) error {
+	preflight := func(path, method, headers string) (*http.Response, error) {
+		req, err := http.NewRequest("OPTIONS", s.orch+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Origin", "http://sim.example")
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	resp, err := preflight("/task", "POST", "Content-Type, Authorization")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") == "" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "POST, OPTIONS" {
+		return fmt.Errorf("preflight for POST /task: %s with headers %v, want 204 allowing POST only", resp.Status, resp.Header)
+	}
+	if resp, err = preflight("/task", "POST", "X-Sim-Unknown"); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("preflight asking for a header outside -cors-headers: %s, want 403", resp.Status)
+	}
+	if resp, err = preflight("/no-such-route", "GET", ""); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("preflight for a path with no route: %s, want 404", resp.Status)
+	}
+
+	type requestStats struct {
+		Routes []shared.RouteStats `json:"routes"`
+	}
+	taskStats := func() (shared.RouteStats, error) {
+		var stats requestStats
+		if err := sendJSON("GET", s.orch+"/debug/requests", "", nil, &stats); err != nil {
+			return shared.RouteStats{}, err
+		}
+		for _, rs := range stats.Routes {
+			if rs.Route == "POST /task" {
+				return rs, nil
+			}
+		}
+		return shared.RouteStats{}, nil
+	}
+	before, err := taskStats()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.agent("mistral", 0, shared.TaskTypeText); err != nil {
+		return err
+	}
+	if _, err := s.task(shared.TaskTypeText, "counted"); err != nil {
+		return err
+	}
+	if err := postJSON(s.orch+"/task", map[string]any{"prompt": 1}, nil); err == nil {
+		return fmt.Errorf("a task with a numeric prompt was accepted")
+	}
+	after, err := taskStats()
+	if err != nil {
+		return err
+	}
+	if after.Requests-before.Requests < 2 || after.ClientErrors-before.ClientErrors < 1 {
+		return fmt.Errorf("POST /task went from %+v to %+v, want 2 more requests, 1 more client error", before, after)
+	}
+	return nil
+}
+
+func eviction(s *sim) error {
+	silent, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	other, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	silent.setMode(behaveSilent)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		status, err := s.nodeStatus(silent.id)
+		if err != nil {
+			return err
+		}
+		if status == shared.StatusOffline {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s still %s 30s after its last heartbeat", silent.id, status)
+		}
+		time.Sleep(time.Second)
+	}
+	if err := s.expectRoutedTo(3, shared.TaskTypeText, other); err != nil {
+		return err
+	}
+
+	// Heartbeats resume → the node re-registers and is routable again
+	silent.setMode(behaveOK)
+	other.setMode(behaveFail)
+	time.Sleep(2 * time.Second)
+	if err := s.expectRoutedTo(1, shared.TaskTypeText, silent); err != nil {
+		return err
+	}
+
+	// The outage shows in its availability, and not in the other's
+	var report struct {
+		WindowSecs int64                     `json:"window_secs"`
+		Nodes      []shared.NodeAvailability `json:"nodes"`
+	}
+	if err := sendJSON("GET", s.orch+"/stats/availability?window=1h", "", nil, &report); err != nil {
+		return err
+	}
+	if report.WindowSecs != 3600 {
+		return fmt.Errorf("availability window_secs = %d, want 3600", report.WindowSecs)
+	}
+	got := map[string]shared.NodeAvailability{}
+	for _, a := range report.Nodes {
+		got[a.NodeID] = a
+	}
+	down, up := got[silent.id], got[other.id]
+	if down.Offlines != 1 || !down.Online || down.UptimePct >= 100 || down.UptimePct <= 0 || down.MTBOSecs == 0 || down.LastOfflineAt == 0 {
+		return fmt.Errorf("availability of %s after one outage: %+v", silent.id, down)
+	}
+	if up.Offlines != 0 || !up.Online || up.UptimePct < 99 || up.MTBOSecs != 0 {
+		return fmt.Errorf("availability of %s, up throughout: %+v", other.id, up)
+	}
+	node, err := s.node(silent.id)
+	if err != nil {
+		return err
+	}
+	if node.Availability == nil || node.Availability.Offlines != 1 || node.Availability.WindowSecs != 86400 {
+		return fmt.Errorf("GET /status availability of %s = %+v, want 24h figures with one offline", silent.id, node.Availability)
+	}
+	return nil
+}
+
+func executedSummary(nodes []*mockAgent) string {
+	parts := make([]string, len(nodes))
+	for i, a := range nodes {
+		parts[i] = fmt.Sprintf("%s=%d", a.id, a.executed.Load())
+	}
+	return strings.Join(parts, " ")
+}
+
+// alertHook receives the -alert-webhook notifications of an orchestrator
+// meshsim started; nil against a running one.
+var alertHook *alertReceiver
+
+// alertReceiver records the alerts POSTed to it.
+type alertReceiver struct {
+	url string
+
+	mu   sync.Mutex
+	seen []shared.Alert
+}
+
+func startAlertReceiver() (*alertReceiver, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	h := &alertReceiver{url: "http://" + ln.Addr().String()}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a shared.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.mu.Lock()
+		h.seen = append(h.seen, a)
+		h.mu.Unlock()
+	}))
+	return h, nil
+}
+
+// received reports whether the alert was delivered in the given state.
+func (h *alertReceiver) received(id string, state shared.AlertState) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, a := range h.seen {
+		if a.ID == id && a.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForAlert polls GET /alerts until the alert is in the given state.
+// Rules are checked every -alert-interval, so allow a default one.
+func (s *sim) waitForAlert(id string, state shared.AlertState) (*shared.Alert, error) {
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		var list struct {
+			Firing   []shared.Alert `json:"firing"`
+			Resolved []shared.Alert `json:"resolved"`
+		}
+		if err := sendJSON("GET", s.orch+"/alerts", "", nil, &list); err != nil {
+			return nil, err
+		}
+		for _, a := range append(list.Firing, list.Resolved...) {
+			if a.ID == id && a.State == state {
+				return &a, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("alert %s never %s", id, state)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func alertRules(s *sim) error {
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	id := "disk_low:" + a.id
+
+	a.setDisk(1<<30, 100<<30)
+	alert, err := s.waitForAlert(id, shared.AlertFiring)
+	if err != nil {
+		return err
+	}
+	if alert.Subject != a.id || alert.Value >= alert.Threshold {
+		return fmt.Errorf("disk alert %+v, want %s below its threshold", alert, a.id)
+	}
+
+	a.setDisk(50<<30, 100<<30)
+	if _, err := s.waitForAlert(id, shared.AlertResolved); err != nil {
+		return err
+	}
+	if alertHook != nil {
+		deadline := time.Now().Add(2 * time.Second)
+		for !alertHook.received(id, shared.AlertResolved) {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("webhook never got %s resolved", id)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !alertHook.received(id, shared.AlertFiring) {
+			return fmt.Errorf("webhook never got %s firing", id)
+		}
+	}
+	return nil
+}
+
+func dashboardQueues(s *sim) error {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.orch, "http")+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return fmt.Errorf("no initial event: %v", err)
+	}
+
+	var list struct {
+		Clients []struct {
+			RemoteAddr  string `json:"remote_addr"`
+			ConnectedAt int64  `json:"connected_at"`
+			Dropped     int64  `json:"dropped"`
+		} `json:"clients"`
+	}
+	if err := sendJSON("GET", s.orch+"/debug/dashboards", "", nil, &list); err != nil {
+		return err
+	}
+	for _, c := range list.Clients {
+		if c.RemoteAddr == conn.LocalAddr().String() {
+			if c.ConnectedAt == 0 || c.Dropped != 0 {
+				return fmt.Errorf("dashboard listed as %+v", c)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("dashboard %s not in %+v", conn.LocalAddr(), list.Clients)
+}
+
+func dashboardCBOR(s *sim) error {
+	url := "ws" + strings.TrimPrefix(s.orch, "http") + "/ws"
+	dial := func(protocol string) (*websocket.Conn, error) {
+		dialer := *websocket.DefaultDialer
+		dialer.Subprotocols = []string{protocol}
+		conn, _, err := dialer.Dial(url, nil)
+		if err == nil && conn.Subprotocol() != protocol {
+			conn.Close()
+			return nil, fmt.Errorf("asked for %s, got subprotocol %q", protocol, conn.Subprotocol())
+		}
+		return conn, err
+	}
+	bin, err := dial("echo-mesh.cbor")
+	if err != nil {
+		return err
+	}
+	defer bin.Close()
+	text, err := dial("echo-mesh.json")
+	if err != nil {
+		return err
+	}
+	defer text.Close()
+	// A client is registered with the hub before its initial snapshot is
+	// sent: once that's read, no event can slip past it
+	for _, conn := range []*websocket.Conn{bin, text} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return fmt.Errorf("no initial snapshot on %s: %v", conn.Subprotocol(), err)
+		}
+	}
+
+	a, err := s.agent("mistral", 0, shared.TaskTypeText)
+	if err != nil {
+		return err
+	}
+	// The node_registered event for a, as received and its payload as a
+	// generic value
+	registered := func(conn *websocket.Conn, wantType int, decode func([]byte) (any, error)) ([]byte, any, error) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return nil, nil, fmt.Errorf("no node_registered event for %s: %v", a.id, err)
+			}
+			if msgType != wantType {
+				return nil, nil, fmt.Errorf("got a message of type %d, want %d", msgType, wantType)
+			}
+			v, err := decode(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Compare as JSON would read them: every number a float64
+			raw, _ := json.Marshal(v)
+			var ev struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			var payload struct {
+				NodeID string `json:"node_id"`
+			}
+			if json.Unmarshal(raw, &ev) != nil || ev.Type != "node_registered" ||
+				json.Unmarshal(ev.Data, &payload) != nil || payload.NodeID != a.id {
+				continue
+			}
+			var generic any
+			json.Unmarshal(ev.Data, &generic)
+			return data, generic, nil
+		}
+	}
+	binData, binEvent, err := registered(bin, websocket.BinaryMessage, decodeCBOR)
+	if err != nil {
+		return fmt.Errorf("cbor: %v", err)
+	}
+	textData, textEvent, err := registered(text, websocket.TextMessage, func(data []byte) (any, error) {
+		var v any
+		return v, json.Unmarshal(data, &v)
+	})
+	if err != nil {
+		return fmt.Errorf("json: %v", err)
+	}
+	if !reflect.DeepEqual(binEvent, textEvent) {
+		return fmt.Errorf("the CBOR event's data %v differs from the JSON one's %v", binEvent, textEvent)
+	}
+	if len(binData) >= len(textData) {
+		return fmt.Errorf("the CBOR event is %d bytes, the JSON one %d", len(binData), len(textData))
+	}
+
+	var list struct {
+		Clients []struct {
+			RemoteAddr string `json:"remote_addr"`
+			Framing    string `json:"framing"`
+		} `json:"clients"`
+	}
+	if err := sendJSON("GET", s.orch+"/debug/dashboards", "", nil, &list); err != nil {
+		return err
+	}
+	for _, c := range list.Clients {
+		if c.RemoteAddr == bin.LocalAddr().String() && c.Framing != "cbor" {
+			return fmt.Errorf("the CBOR dashboard is listed with framing %q", c.Framing)
+		}
+	}
+	return nil
+}
+
+func switchoverDashboards(s *sim) error {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.orch, "http")+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The client is registered before its initial snapshot is sent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return fmt.Errorf("no initial event: %v", err)
+	}
+
+	var resp struct {
+		URL     string `json:"url"`
+		Clients int    `json:"clients"`
+	}
+	if err := s.admin("POST", "/admin/switchover", shared.SwitchoverRequest{URL: "http://standby.example:8080/"}, &resp); err != nil {
+		return err
+	}
+	if resp.URL != "http://standby.example:8080" || resp.Clients < 1 {
+		return fmt.Errorf("switchover answered %+v, want the URL without its slash and at least 1 client", resp)
+	}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("WebSocket closed before a switchover event: %v", err)
+		}
+		var evt struct {
+			Type string                 `json:"type"`
+			Data shared.SwitchoverEvent `json:"data"`
+		}
+		if json.Unmarshal(msg, &evt) != nil || evt.Type != "switchover" {
+			continue
+		}
+		if evt.Data.Reason != "failover" || evt.Data.URL != resp.URL {
+			return fmt.Errorf("switchover event %+v, want failover to %s", evt.Data, resp.URL)
+		}
+		break
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		return fmt.Errorf("WebSocket still open after the switchover event")
+	}
+
+	err = s.admin("POST", "/admin/switchover", shared.SwitchoverRequest{URL: "standby:8080"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		return fmt.Errorf("switchover to a URL without a scheme: %v, want 400", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// node-agent/backendheaders.go
+// Extra headers on requests to the backend.
+//
+// A backend that wants authentication — Ollama behind an authenticating
+// reverse proxy on another host, a llama.cpp server started with
+// --api-key — gets -backend-headers on every request the agent makes to
+// it, generations as well as probes and model listings:
+//
+//	-backend-headers 'Authorization=Bearer ${OLLAMA_PROXY_KEY};X-Org=acme'
+//
+// Values are expanded from the environment, so secrets needn't show up in
+// the process list. Headers a client sent with its task come along too
+// when both the orchestrator's and this agent's -pass-headers name them;
+// where both set one, -backend-headers wins.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"echo-system/shared"
+)
+
+var (
+	// backendHeaders are set on every backend request; from -backend-headers.
+	backendHeaders = http.Header{}
+
+	// passHeaderNames are the task headers handed to the backend; from
+	// -pass-headers.
+	passHeaderNames []string
+)
+
+type passedHeadersKey struct{}
+
+// parseBackendHeaders parses -backend-headers: Name=value pairs separated
+// by semicolons, values expanded from the environment.
+func parseBackendHeaders(flag string) (http.Header, error) {
+	h := http.Header{}
+	for _, entry := range strings.Split(flag, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -backend-headers entry %q (want Name=value)", strings.TrimSpace(entry))
+		}
+		names, err := shared.ParseHeaderNames(name)
+		if err != nil || len(names) != 1 {
+			return nil, fmt.Errorf("invalid -backend-headers name %q", strings.TrimSpace(name))
+		}
+		h.Set(names[0], os.ExpandEnv(strings.TrimSpace(value)))
+	}
+	return h, nil
+}
+
+// withPassedHeaders attaches the task headers this agent passes on to the
+// backend requests made under ctx.
+func withPassedHeaders(ctx context.Context, h http.Header) context.Context {
+	picked := shared.PickHeaders(h, passHeaderNames)
+	if picked == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, passedHeadersKey{}, picked)
+}
+
+// setBackendHeaders adds the passed and configured headers to a backend
+// request.
+func setBackendHeaders(req *http.Request) {
+	picked, _ := req.Context().Value(passedHeadersKey{}).(map[string]string)
+	for name, value := range picked {
+		req.Header.Set(name, value)
+	}
+	for name, values := range backendHeaders {
+		req.Header[name] = values
+	}
+}
+
+// headerMap turns a work item's headers back into an http.Header.
+func headerMap(m map[string]string) http.Header {
+	h := http.Header{}
+	for name, value := range m {
+		h.Set(name, value)
+	}
+	return h
+}
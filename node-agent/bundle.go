@@ -0,0 +1,191 @@
+// node-agent/bundle.go
+// Offline task bundles.
+//
+// With -bundle-size > 0 the agent claims batches of deferred low-priority
+// tasks from the orchestrator while it's idle. A claimed bundle is written
+// to -bundle-dir and executed against the local Ollama, with progress saved
+// after every task — so it keeps running if the node loses its connection
+// (or even restarts). Results are uploaded once the orchestrator is
+// reachable again, and the local file is removed after a successful upload.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	bundleInterval    = 30 * time.Second
+	bundleTaskTimeout = 10 * time.Minute
+)
+
+// localBundle is a claimed bundle plus the results produced so far.
+type localBundle struct {
+	Bundle  shared.TaskBundle   `json:"bundle"`
+	Results []shared.TaskResult `json:"results"`
+}
+
+// bundleLoop claims, runs and uploads bundles forever. Bundles left on disk
+// by a previous run are finished first.
+func bundleLoop(cfg Config) {
+	if err := os.MkdirAll(cfg.BundleDir, 0o755); err != nil {
+		log.Printf("[Bundle] Disabled — cannot create %s: %v", cfg.BundleDir, err)
+		return
+	}
+	ticker := time.NewTicker(bundleInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		pending, err := pendingBundles(cfg.BundleDir)
+		if err != nil {
+			log.Printf("[Bundle] Listing %s failed: %v", cfg.BundleDir, err)
+			continue
+		}
+		if len(pending) > 0 {
+			for _, path := range pending {
+				processBundle(cfg, path)
+			}
+			continue
+		}
+
+		// Only take on background work while idle and healthy
+		if atomic.LoadInt64(&activeTasks) > 0 || backendDown.Load() {
+			continue
+		}
+		if path, ok := claimBundle(cfg); ok {
+			processBundle(cfg, path)
+		}
+	}
+}
+
+// pendingBundles lists bundle files still waiting to be run or uploaded.
+func pendingBundles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*.json"))
+}
+
+// claimBundle asks the orchestrator for a bundle and saves it locally.
+func claimBundle(cfg Config) (string, bool) {
+	var bundle shared.TaskBundle
+	req := shared.BundleClaimRequest{NodeID: cfg.NodeID, MaxTasks: cfg.BundleSize}
+	if err := postJSON(cfg.OrchestratorURL+"/bundles/claim", req, &bundle); err != nil {
+		return "", false
+	}
+	if len(bundle.Tasks) == 0 {
+		return "", false
+	}
+	path := filepath.Join(cfg.BundleDir, bundle.BundleID+".json")
+	if err := saveBundle(path, &localBundle{Bundle: bundle}); err != nil {
+		log.Printf("[Bundle] Failed to save bundle %s: %v", bundle.BundleID, err)
+		return "", false
+	}
+	log.Printf("[Bundle] Claimed bundle %s (%d tasks)", bundle.BundleID, len(bundle.Tasks))
+	prefetchFiles(cfg, bundle.Tasks)
+	return path, true
+}
+
+// processBundle runs any tasks without a result, then tries to upload.
+func processBundle(cfg Config, path string) {
+	lb, err := loadBundle(path)
+	if err != nil {
+		log.Printf("[Bundle] Dropping unreadable bundle %s: %v", path, err)
+		os.Remove(path)
+		return
+	}
+
+	for i := len(lb.Results); i < len(lb.Bundle.Tasks); i++ {
+		if backendDown.Load() {
+			log.Printf("[Bundle] Ollama is down — pausing bundle %s at task %d/%d",
+				lb.Bundle.BundleID, i+1, len(lb.Bundle.Tasks))
+			return
+		}
+		lb.Results = append(lb.Results, runBundleTask(cfg, lb.Bundle.Tasks[i]))
+		if err := saveBundle(path, lb); err != nil {
+			log.Printf("[Bundle] Failed to save progress for %s: %v", lb.Bundle.BundleID, err)
+		}
+	}
+
+	upload := shared.BundleUpload{NodeID: cfg.NodeID, Results: lb.Results}
+	var resp shared.BundleUploadResponse
+	url := fmt.Sprintf("%s/bundles/%s/results", cfg.OrchestratorURL, lb.Bundle.BundleID)
+	if err := postJSON(url, upload, &resp); err != nil {
+		log.Printf("[Bundle] Upload of %s failed, will retry: %v", lb.Bundle.BundleID, err)
+		return
+	}
+	log.Printf("[Bundle] Uploaded %s: %d accepted, %d duplicate, %d unknown",
+		lb.Bundle.BundleID, resp.Accepted, resp.Duplicates, resp.Unknown)
+	os.Remove(path)
+}
+
+// runBundleTask executes one bundled task against the local backend.
+func runBundleTask(cfg Config, req shared.TaskRequest) shared.TaskResult {
+	atomic.AddInt64(&activeTasks, 1)
+	defer atomic.AddInt64(&activeTasks, -1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), bundleTaskTimeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	model := resolveModel(cfg, req)
+	release, err := memGuard.admit(model)
+	if err != nil {
+		return refusal(req, model, err)
+	}
+	defer release()
+	defer slots.acquire(model)()
+	if req.Type == shared.TaskTypeEmbed {
+		return embedTask(ctx, cfg, model, req, startedAt)
+	}
+	content, timings, err := generate(ctx, cfg, model, req)
+	result := shared.TaskResult{
+		TaskID:    req.TaskID,
+		ModelUsed: model,
+		TaskType:  req.Type,
+		LatencyMs: time.Since(startedAt).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorCode = ollamaErrorCode(err)
+		return result
+	}
+	result.Content = content
+	result.Timings = timings
+	result.Success = true
+	return result
+}
+
+func loadBundle(path string) (*localBundle, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lb localBundle
+	if err := json.Unmarshal(raw, &lb); err != nil {
+		return nil, err
+	}
+	if lb.Bundle.BundleID == "" || strings.ContainsAny(lb.Bundle.BundleID, "/\\") {
+		return nil, fmt.Errorf("invalid bundle id %q", lb.Bundle.BundleID)
+	}
+	return &lb, nil
+}
+
+// saveBundle atomically replaces the bundle file (write temp + rename).
+func saveBundle(path string, lb *localBundle) error {
+	data, err := json.Marshal(lb)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,246 @@
+// node-agent/capabilities.go
+// Capability changes sent with heartbeats.
+//
+// The models a node serves change while it runs: a pull finishes, someone
+// runs ollama rm, a model is re-created with another context length.
+// Rather than re-registering, which resets the session and makes the
+// orchestrator probe every model again, the agent sends what changed with
+// its next heartbeat, sent early so routing adapts within seconds.
+//
+// After each good watchdog probe the agent compares what the backend has
+// with what it advertises. Declared models (-models, -capabilities) that
+// disappear are removed, and come back as declared once reinstalled;
+// models pulled through POST /pull are added with the task types the pull
+// named. Each model also carries its trained context length, which the
+// orchestrator won't fit prompts beyond. Every change bumps the capability
+// version; heartbeats carry all changes since the last version the
+// orchestrator acknowledged, and registering sends everything afresh.
+// Heartbeats also say when the comparison last succeeded: capabilities
+// the orchestrator hasn't had confirmed for -capability-ttl turn stale,
+// and it re-validates them itself.
+
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// advertised is what the agent tells the orchestrator it serves.
+var advertised *capabilityTracker
+
+// capabilityTracker holds the advertised models and the changes the
+// orchestrator hasn't acknowledged yet.
+type capabilityTracker struct {
+	mu     sync.Mutex
+	caps   []shared.ModelCapability // advertised now
+	models []string                 // advertised now; may also name models without capabilities, which stay
+
+	// Every capability ever advertised, by name, to come back as it was
+	// when its model is reinstalled
+	known map[string]shared.ModelCapability
+
+	seq     int64           // bumped on every change
+	acked   int64           // the latest version the orchestrator has
+	changed map[string]bool // models changed since acked
+	checked int64           // Unix ms of the last comparison with the backend
+
+	kick chan struct{} // wakes the heartbeat loop after a change
+}
+
+func newCapabilityTracker(caps []shared.ModelCapability, models []string) *capabilityTracker {
+	t := &capabilityTracker{
+		caps:    slices.Clone(caps),
+		models:  slices.Clone(models),
+		known:   make(map[string]shared.ModelCapability),
+		changed: make(map[string]bool),
+		kick:    make(chan struct{}, 1),
+	}
+	for _, c := range caps {
+		t.known[c.Name] = c
+	}
+	return t
+}
+
+// current returns the advertised capabilities and models.
+func (t *capabilityTracker) current() ([]shared.ModelCapability, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.caps), slices.Clone(t.models)
+}
+
+// registration returns what to register with and counts it acknowledged
+// once sent: a registration carries everything.
+func (t *capabilityTracker) registration() ([]shared.ModelCapability, []string, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.acked = t.seq
+	clear(t.changed)
+	return slices.Clone(t.caps), slices.Clone(t.models), t.seq
+}
+
+// pending returns the changes the orchestrator hasn't acknowledged, nil if
+// there are none.
+func (t *capabilityTracker) pending() *shared.CapabilityDelta {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seq == t.acked {
+		return nil
+	}
+	d := &shared.CapabilityDelta{Base: t.acked, Seq: t.seq}
+	for name := range t.changed {
+		if i := t.capIndex(name); i >= 0 {
+			d.Added = append(d.Added, t.caps[i])
+		} else {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	slices.Sort(d.Removed)
+	slices.SortFunc(d.Added, func(a, b shared.ModelCapability) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return d
+}
+
+// checkedAt returns when the advertised models were last compared with
+// the backend's, Unix ms; 0 if they never were.
+func (t *capabilityTracker) checkedAt() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checked
+}
+
+// ack records that the orchestrator applied the changes up to seq.
+func (t *capabilityTracker) ack(seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq > t.acked && seq == t.seq {
+		t.acked = seq
+		clear(t.changed)
+	}
+}
+
+// pulled starts advertising a model pulled through POST /pull, as
+// declared if it was, otherwise for types (text and summarize if none);
+// refresh fills in its details.
+func (t *capabilityTracker) pulled(model string, types []shared.TaskType) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.capIndex(model) >= 0 {
+		return
+	}
+	c, ok := t.known[model]
+	if !ok {
+		if len(types) == 0 {
+			types = []shared.TaskType{shared.TaskTypeText, shared.TaskTypeSummarize}
+		}
+		c = shared.ModelCapability{Name: model, Types: types}
+		t.known[model] = c
+	}
+	t.add(c)
+	t.bump(model)
+}
+
+// refresh compares the advertised models with those the backend has and
+// records the differences. Nothing changes if the backend can't be read.
+func (t *capabilityTracker) refresh(cfg Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchdogTimeout)
+	defer cancel()
+	var details []shared.ModelDetail
+	if llama != nil {
+		details = llama.describe(ctx)
+	} else {
+		var err error
+		if details, err = describeOllamaModels(ctx, cfg.OllamaHost, cfg.OllamaPort); err != nil {
+			return
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checked = time.Now().UnixMilli()
+	for name, known := range t.known {
+		detail, installed := findDetail(details, name)
+		i := t.capIndex(name)
+		switch {
+		case i >= 0 && !installed:
+			t.remove(name)
+			log.Printf("[Agent:%s] Model %s is gone from the backend — no longer advertised", cfg.NodeID, name)
+			t.bump(name)
+		case i < 0 && installed:
+			c := known
+			describeCapability(&c, detail)
+			t.add(c)
+			log.Printf("[Agent:%s] Model %s is installed — advertising it", cfg.NodeID, name)
+			t.bump(name)
+		case i >= 0:
+			c := t.caps[i]
+			describeCapability(&c, detail)
+			if c.ContextLength != t.caps[i].ContextLength || c.Tier != t.caps[i].Tier {
+				log.Printf("[Agent:%s] Model %s: context %d, tier %q", cfg.NodeID, name, c.ContextLength, c.Tier)
+				t.caps[i] = c
+				t.bump(name)
+			}
+		}
+	}
+}
+
+// describeCapability fills in what the backend says about a model: its
+// trained context length, and its size class unless declared.
+func describeCapability(c *shared.ModelCapability, d shared.ModelDetail) {
+	c.ContextLength = d.ContextLength
+	if c.Tier == "" {
+		c.Tier = shared.TierForParams(d.ParameterSize)
+	}
+}
+
+// findDetail returns the backend's details of a model.
+func findDetail(details []shared.ModelDetail, name string) (shared.ModelDetail, bool) {
+	for _, d := range details {
+		if shared.SameModel(d.Name, name) {
+			return d, true
+		}
+	}
+	return shared.ModelDetail{}, false
+}
+
+// add advertises c. Must be called with t.mu held.
+func (t *capabilityTracker) add(c shared.ModelCapability) {
+	if i := t.capIndex(c.Name); i >= 0 {
+		t.caps[i] = c
+	} else {
+		t.caps = append(t.caps, c)
+	}
+	if !slices.Contains(t.models, c.Name) {
+		t.models = append(t.models, c.Name)
+	}
+}
+
+// remove stops advertising a model. Must be called with t.mu held.
+func (t *capabilityTracker) remove(name string) {
+	t.caps = slices.DeleteFunc(t.caps, func(c shared.ModelCapability) bool { return c.Name == name })
+	t.models = slices.DeleteFunc(t.models, func(m string) bool { return m == name })
+}
+
+// capIndex returns the index of a model's capability, -1 if it has none.
+// Must be called with t.mu held.
+func (t *capabilityTracker) capIndex(name string) int {
+	return slices.IndexFunc(t.caps, func(c shared.ModelCapability) bool { return c.Name == name })
+}
+
+// bump records a change to a model and wakes the heartbeat loop. Must be
+// called with t.mu held.
+func (t *capabilityTracker) bump(name string) {
+	t.seq++
+	t.changed[name] = true
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
@@ -5,12 +5,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/mdns"
+
+	"echo-system/shared"
 )
 
 const (
@@ -21,59 +26,136 @@ const (
 
 // discoverOrchestrator uses mDNS to find the orchestrator on the local network.
 // It blocks up to mdnsTimeout while scanning. Returns the orchestrator URL
-// (e.g. "http://192.168.1.10:8080") or an error if nothing was found.
+// (e.g. "http://192.168.1.10:8080") of the first compatible orchestrator
+// found, an *incompatibleError if every one found was incompatible, or an
+// error if nothing was found.
 func discoverOrchestrator() (string, error) {
 	log.Println("[mDNS] Searching for orchestrator on the network...")
 
-	entriesCh := make(chan *mdns.ServiceEntry, 4)
-	var found *mdns.ServiceEntry
-
-	// Run lookup in a goroutine — the library blocks until timeout
+	// Lookup never blocks on the channel; it returns when its query ends
+	entriesCh := make(chan *mdns.ServiceEntry, 16)
+	lookupDone := make(chan struct{})
 	go func() {
 		_ = mdns.Lookup(mdnsServiceName, entriesCh)
+		close(lookupDone)
 	}()
 
-	// Wait for the first result or timeout
-	select {
-	case entry := <-entriesCh:
-		if entry != nil {
-			found = entry
+	incompatible := &incompatibleError{}
+	try := func(entry *mdns.ServiceEntry) (string, bool) {
+		url, err := orchestratorURL(entry)
+		if err != nil {
+			log.Printf("[mDNS] Skipping orchestrator %s: %v", entry.Name, err)
+			incompatible.reasons = append(incompatible.reasons, fmt.Sprintf("%s: %v", entry.Name, err))
+			return "", false
 		}
-	case <-time.After(mdnsTimeout):
+		log.Printf("[mDNS] Found orchestrator at %s (version %s)", url, parseTXT(entry.InfoFields)["version"])
+		return url, true
 	}
 
-	// Drain remaining entries (non-blocking)
-	go func() {
-		for range entriesCh {
+	timeout := time.After(mdnsTimeout)
+	for {
+		select {
+		case entry := <-entriesCh:
+			if url, ok := try(entry); ok {
+				return url, nil
+			}
+			continue
+		case <-lookupDone:
+		case <-timeout:
 		}
-	}()
+		break
+	}
+	// Entries that arrived as the lookup ended
+	for len(entriesCh) > 0 {
+		if url, ok := try(<-entriesCh); ok {
+			return url, nil
+		}
+	}
+
+	if len(incompatible.reasons) > 0 {
+		return "", incompatible
+	}
+	return "", fmt.Errorf("no orchestrator found via mDNS within %s", mdnsTimeout)
+}
+
+// orchestratorURL checks an advertised orchestrator against what this
+// agent supports and returns the URL to reach it.
+func orchestratorURL(entry *mdns.ServiceEntry) (string, error) {
+	txt := parseTXT(entry.InfoFields)
 
-	if found == nil {
-		return "", fmt.Errorf("no orchestrator found via mDNS within %s", mdnsTimeout)
+	// Orchestrators older than the TXT capability records advertise none
+	// of these and speak API v1 over plain HTTP without auth
+	if api := txt["api"]; api != "" && api != strconv.Itoa(shared.MeshAPIVersion) {
+		return "", fmt.Errorf("orchestrator %s speaks mesh API v%s but this agent (%s) speaks v%d; run matching versions",
+			txt["version"], api, shared.Version, shared.MeshAPIVersion)
+	}
+	proto := txt["proto"]
+	switch proto {
+	case "", "http", "https":
+	default:
+		return "", fmt.Errorf("orchestrator requires the %s protocol, which this agent doesn't support (http and https only)", proto)
+	}
+	switch auth := txt["auth"]; auth {
+	case "", "none":
+	default:
+		return "", fmt.Errorf("orchestrator requires %s authentication for agents, which this agent doesn't support", auth)
 	}
 
+	// An orchestrator behind a reverse proxy advertises its external URL
+	// (url=...) or sub-path (path=...) in TXT records
+	if url := txt["url"]; url != "" {
+		return url, nil
+	}
 	// Prefer IPv4
-	ip := found.AddrV4
+	ip := entry.AddrV4
 	if ip == nil {
-		ip = found.Addr
+		ip = entry.Addr
 	}
 	if ip == nil {
-		return "", fmt.Errorf("mDNS entry found but has no IP address")
+		return "", fmt.Errorf("mDNS entry has no IP address")
 	}
+	if proto == "" {
+		proto = "http"
+	}
+	return fmt.Sprintf("%s://%s%s", proto, net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port)), txt["path"]), nil
+}
+
+// incompatibleError reports that orchestrators were found but this agent
+// can't work with any of them. Retrying won't help.
+type incompatibleError struct {
+	reasons []string
+}
+
+func (e *incompatibleError) Error() string {
+	return "no compatible orchestrator found via mDNS:\n  " + strings.Join(e.reasons, "\n  ")
+}
 
-	url := fmt.Sprintf("http://%s:%d", ip.String(), found.Port)
-	log.Printf("[mDNS] Found orchestrator at %s", url)
-	return url, nil
+// parseTXT turns key=value TXT record fields into a map; fields without
+// '=' are ignored.
+func parseTXT(fields []string) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			m[k] = v
+		}
+	}
+	return m
 }
 
 // discoverOrchestratorWithRetry keeps trying mDNS discovery until the orchestrator
-// is found. This is used when no -orchestrator flag is provided.
+// is found. This is used when no -orchestrator flag is provided. It exits
+// at once if the only orchestrators found are incompatible, rather than
+// leaving the agent retrying, or failing to register, with no clear cause.
 func discoverOrchestratorWithRetry() string {
 	for {
 		url, err := discoverOrchestrator()
 		if err == nil {
 			return url
 		}
+		var incompatible *incompatibleError
+		if errors.As(err, &incompatible) {
+			log.Fatalf("[mDNS] %v", err)
+		}
 		log.Printf("[mDNS] %v — retrying in 3s", err)
 		time.Sleep(3 * time.Second)
 	}
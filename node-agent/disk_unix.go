@@ -0,0 +1,18 @@
+//go:build !windows
+
+// node-agent/disk_unix.go
+// Free disk space via statfs on Linux/macOS.
+
+package main
+
+import "syscall"
+
+// diskSpace returns free (available to unprivileged users) and total bytes
+// on the volume holding path.
+func diskSpace(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
@@ -0,0 +1,269 @@
+// node-agent/embed.go
+// Embed tasks, batched.
+//
+// An embed task carries its texts as input; the agent sends them to the
+// backend -embed-batch-size at a time, in one request per batch, instead
+// of one request per text: Ollama's /api/embed, or the llama.cpp server's
+// /v1/embeddings (started with --embedding). Ingesting a pile of document
+// chunks then costs a handful of requests rather than thousands. Ollama
+// releases from before /api/embed only embed one text per request on
+// /api/embeddings; for them the texts are pipelined, embedPipelineDepth
+// requests in flight at once, which keeps Ollama busy without batching.
+//
+// The result carries one vector per input, in order, and EmbedTimings:
+// the time of each backend request and each input, and the throughput.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+// embedBatchSize is the most inputs sent in one backend request; set from
+// -embed-batch-size.
+var embedBatchSize = 64
+
+// embedPipelineDepth is how many one-input requests are in flight at once
+// for backends that can't batch; Ollama queues what it can't run yet.
+const embedPipelineDepth = 4
+
+// ollamaNoBatchEmbed is set once Ollama has answered that it has no
+// /api/embed, so later tasks pipeline straight away.
+var ollamaNoBatchEmbed atomic.Bool
+
+// embedTask runs an embed task: its input, or else its prompt (with its
+// files), through the backend.
+func embedTask(ctx context.Context, cfg Config, model string, req shared.TaskRequest, startedAt time.Time) shared.TaskResult {
+	inputs := req.Input
+	if len(inputs) == 0 {
+		prompt, images, err := attachFiles(ctx, cfg, req)
+		if err == nil && len(images) > 0 {
+			err = fmt.Errorf("embed tasks can't take image files")
+		}
+		if err != nil {
+			return shared.TaskResult{TaskID: req.TaskID, ModelUsed: model, TaskType: req.Type, Error: err.Error()}
+		}
+		inputs = []string{prompt}
+	}
+
+	vectors, timings, err := embed(ctx, cfg, model, req, inputs)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timeout")
+		}
+		return shared.TaskResult{
+			TaskID:    req.TaskID,
+			ModelUsed: model,
+			TaskType:  req.Type,
+			LatencyMs: time.Since(startedAt).Milliseconds(),
+			Error:     err.Error(),
+			ErrorCode: ollamaErrorCode(err),
+		}
+	}
+	mode := "batched"
+	if timings.Pipelined {
+		mode = "pipelined"
+	}
+	log.Printf("[Agent:%s] Task %s: embedded %d inputs in %d %s requests, %dms (%.1f/s)",
+		cfg.NodeID, req.TaskID, timings.Items, timings.Requests, mode, timings.TotalMs, timings.ItemsPerSec)
+	modelUse.touch(model)
+	return shared.TaskResult{
+		TaskID:       req.TaskID,
+		ModelUsed:    model,
+		TaskType:     req.Type,
+		LatencyMs:    time.Since(startedAt).Milliseconds(),
+		Success:      true,
+		Embeddings:   vectors,
+		EmbedTimings: timings,
+		Checksum:     shared.ContentChecksum(""),
+	}
+}
+
+// embed embeds inputs on the configured backend, batched when it can.
+func embed(ctx context.Context, cfg Config, model string, req shared.TaskRequest, inputs []string) ([][]float32, *shared.EmbedTimings, error) {
+	started := time.Now()
+	t := &shared.EmbedTimings{Items: len(inputs), ItemMs: make([]int64, len(inputs))}
+	vectors := make([][]float32, len(inputs))
+
+	switch {
+	case llama != nil:
+		if err := embedBatches(inputs, vectors, t, func(batch []string) ([][]float32, int64, int, error) {
+			return llama.embed(ctx, batch)
+		}); err != nil {
+			return nil, nil, err
+		}
+	case !ollamaNoBatchEmbed.Load():
+		err := embedBatches(inputs, vectors, t, func(batch []string) ([][]float32, int64, int, error) {
+			return embedOllama(ctx, cfg, model, req, batch)
+		})
+		if !missingEndpoint(err) {
+			if err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+		log.Printf("[Agent:%s] Ollama has no /api/embed (older than 0.3?) — pipelining embeddings one input per request", cfg.NodeID)
+		ollamaNoBatchEmbed.Store(true)
+		*t = shared.EmbedTimings{Items: len(inputs), ItemMs: make([]int64, len(inputs))}
+		fallthrough
+	default:
+		if err := embedPipelined(ctx, cfg, model, req, inputs, vectors, t); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	t.TotalMs = time.Since(started).Milliseconds()
+	if secs := time.Since(started).Seconds(); secs > 0 {
+		t.ItemsPerSec = float64(len(inputs)) / secs
+	}
+	return vectors, t, nil
+}
+
+// embedBatches sends inputs through call embedBatchSize at a time. call
+// returns a batch's vectors, the backend's load time and token count.
+func embedBatches(inputs []string, vectors [][]float32, t *shared.EmbedTimings, call func(batch []string) ([][]float32, int64, int, error)) error {
+	for start := 0; start < len(inputs); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(inputs))
+		sentAt := time.Now()
+		got, loadMs, tokens, err := call(inputs[start:end])
+		if err != nil {
+			return err
+		}
+		if len(got) != end-start {
+			return fmt.Errorf("backend returned %d embeddings for %d inputs", len(got), end-start)
+		}
+		ms := time.Since(sentAt).Milliseconds()
+		copy(vectors[start:end], got)
+		for i := start; i < end; i++ {
+			t.ItemMs[i] = ms / int64(end-start)
+		}
+		t.Requests++
+		t.BatchMs = append(t.BatchMs, ms)
+		t.LoadMs += loadMs
+		t.PromptTokens += tokens
+	}
+	return nil
+}
+
+// embedPipelined embeds inputs one per request, embedPipelineDepth
+// requests at once, on Ollama's /api/embeddings.
+func embedPipelined(ctx context.Context, cfg Config, model string, req shared.TaskRequest, inputs []string, vectors [][]float32, t *shared.EmbedTimings) error {
+	t.Pipelined = true
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		depth    = make(chan struct{}, embedPipelineDepth)
+	)
+	for i, input := range inputs {
+		select {
+		case depth <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, input string) {
+			defer wg.Done()
+			defer func() { <-depth }()
+			sentAt := time.Now()
+			var resp struct {
+				Embedding []float32 `json:"embedding"`
+			}
+			err := postOllama(ctx, cfg, "/api/embeddings", ollamaEmbedBody(model, req, "prompt", input), &resp)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			vectors[i] = resp.Embedding
+			t.ItemMs[i] = time.Since(sentAt).Milliseconds()
+			t.Requests++
+		}(i, input)
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// embedOllama embeds a batch on Ollama's /api/embed.
+func embedOllama(ctx context.Context, cfg Config, model string, req shared.TaskRequest, batch []string) ([][]float32, int64, int, error) {
+	var resp struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		LoadDuration    int64       `json:"load_duration"` // nanoseconds
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := postOllama(ctx, cfg, "/api/embed", ollamaEmbedBody(model, req, "input", batch), &resp); err != nil {
+		return nil, 0, 0, err
+	}
+	return resp.Embeddings, resp.LoadDuration / int64(time.Millisecond), resp.PromptEvalCount, nil
+}
+
+// ollamaEmbedBody builds an embeddings request for model with the task's
+// keep-alive and options, its texts under key.
+func ollamaEmbedBody(model string, req shared.TaskRequest, key string, texts any) map[string]any {
+	body := map[string]any{"model": model, key: texts}
+	if req.KeepAlive != "" {
+		body["keep_alive"] = req.KeepAlive
+	}
+	if len(req.Options) > 0 {
+		body["options"] = req.Options
+	}
+	return body
+}
+
+// postOllama posts a JSON body to one of Ollama's endpoints and decodes
+// its answer into out.
+func postOllama(ctx context.Context, cfg Config, path string, body, out any) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", shared.BaseURL(cfg.OllamaHost, cfg.OllamaPort)+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama unreachable on %s — is it running? (%w)", ollamaAddr(cfg.OllamaHost, cfg.OllamaPort), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(msg, &e) == nil && e.Error != "" {
+			msg = []byte(e.Error)
+		}
+		return &ollamaError{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// missingEndpoint reports whether Ollama answered that it has no such
+// endpoint, rather than, say, no such model (also a 404, but in JSON).
+func missingEndpoint(err error) bool {
+	oe, ok := err.(*ollamaError)
+	return ok && oe.Status == http.StatusNotFound && oe.Message == "404 page not found"
+}
@@ -0,0 +1,201 @@
+// node-agent/exec.go
+// The code sandbox for pipeline exec steps.
+//
+// Off unless -exec names a container engine (docker or podman). Then POST
+// /exec runs a program in a throwaway container: its source on the
+// interpreter's stdin, no network, a read-only root with a small /tmp, no
+// capabilities, an unprivileged user and the -exec-cpus, -exec-memory and
+// -exec-timeout limits. -exec-runtime picks a stronger OCI runtime such as
+// gVisor's runsc. The languages it runs are the keys of -exec-images, and
+// are declared at registration so the orchestrator sends exec steps only to
+// nodes that can run them. Images are pulled on first use, which counts
+// against the time limit — pull them ahead of time. Only the orchestrator
+// may call /exec: it must send the node's session token (fromOrchestrator).
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+// execMaxOutput is how much of each of stdout and stderr is returned.
+const execMaxOutput = 64 << 10
+
+// execInterpreters is the command reading a program from stdin, per
+// language.
+var execInterpreters = map[string][]string{
+	"python": {"python3", "-"},
+	"sh":     {"sh", "-s"},
+	"node":   {"node", "-"},
+}
+
+// defaultExecImages is the -exec-images default.
+const defaultExecImages = "python=python:3.12-alpine,sh=alpine:3.20,node=node:20-alpine"
+
+// sandbox runs exec requests; nil unless -exec is set.
+var sandbox *execSandbox
+
+type execSandbox struct {
+	engine  string            // docker or podman
+	runtime string            // OCI runtime ("" = the engine's default)
+	images  map[string]string // language → image
+	cpus    string
+	memory  string
+	timeout time.Duration
+}
+
+// newSandbox configures the sandbox from the -exec flags. images is
+// "language=image,..." over the languages in execInterpreters.
+func newSandbox(engine, runtime, images, cpus, memory string, timeout time.Duration) (*execSandbox, error) {
+	if engine != "docker" && engine != "podman" {
+		return nil, fmt.Errorf("-exec must be docker or podman, got %q", engine)
+	}
+	if _, err := exec.LookPath(engine); err != nil {
+		return nil, fmt.Errorf("-exec: %w", err)
+	}
+	s := &execSandbox{engine: engine, runtime: runtime, images: make(map[string]string), cpus: cpus, memory: memory, timeout: timeout}
+	for _, part := range strings.Split(images, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		lang, image, ok := strings.Cut(part, "=")
+		if !ok || image == "" {
+			return nil, fmt.Errorf("-exec-images: want language=image, got %q", part)
+		}
+		if _, known := execInterpreters[lang]; !known {
+			return nil, fmt.Errorf("-exec-images: unknown language %q", lang)
+		}
+		s.images[lang] = image
+	}
+	if len(s.images) == 0 {
+		return nil, fmt.Errorf("-exec-images names no languages")
+	}
+	return s, nil
+}
+
+// languages lists the languages the sandbox runs, for registration.
+func (s *execSandbox) languages() []string {
+	if s == nil {
+		return nil
+	}
+	langs := make([]string, 0, len(s.images))
+	for lang := range s.images {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// run executes req in a fresh container.
+func (s *execSandbox) run(ctx context.Context, nodeID string, req shared.ExecRequest) shared.ExecResult {
+	result := shared.ExecResult{ExecID: req.ExecID, NodeID: nodeID}
+	image, ok := s.images[req.Language]
+	if !ok {
+		result.Error = fmt.Sprintf("language %q not supported here (have %s)", req.Language, strings.Join(s.languages(), ", "))
+		return result
+	}
+	timeout := s.timeout
+	if req.TimeoutMs > 0 && time.Duration(req.TimeoutMs)*time.Millisecond < timeout {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+
+	name := "echo-exec-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	args := []string{"run", "--rm", "-i", "--name", name,
+		"--network", "none",
+		"--cpus", s.cpus, "--memory", s.memory, "--memory-swap", s.memory, "--pids-limit", "128",
+		"--read-only", "--tmpfs", "/tmp:rw,size=64m", "--workdir", "/tmp",
+		"--cap-drop", "ALL", "--security-opt", "no-new-privileges", "--user", "65534:65534",
+	}
+	if s.runtime != "" {
+		args = append(args, "--runtime", s.runtime)
+	}
+	args = append(args, image)
+	args = append(args, execInterpreters[req.Language]...)
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, s.engine, args...)
+	cmd.Stdin = strings.NewReader(req.Code)
+	stdout := &cappedBuffer{max: execMaxOutput}
+	stderr := &cappedBuffer{max: execMaxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	started := time.Now()
+	err := cmd.Run()
+	result.LatencyMs = time.Since(started).Milliseconds()
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	result.Truncated = stdout.cut || stderr.cut
+
+	switch {
+	case runCtx.Err() != nil:
+		// Killing the engine's client leaves the container running
+		go exec.Command(s.engine, "rm", "-f", name).Run()
+		result.TimedOut = ctx.Err() == nil
+		result.ExitCode = -1
+		if !result.TimedOut {
+			result.Error = "cancelled"
+		}
+	case err == nil:
+	case cmd.ProcessState != nil && cmd.ProcessState.ExitCode() != 125:
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	default:
+		// 125: the engine couldn't start the container
+		result.Error = fmt.Sprintf("%s run failed: %v: %s", s.engine, err, strings.TrimSpace(result.Stderr))
+	}
+	return result
+}
+
+// cappedBuffer keeps the first max bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+	cut bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.cut = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// ─── Exec: POST /exec ─────────────────────────────────────────────────────────
+
+func makeExecHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sandbox == nil {
+			http.Error(w, "code execution is off on this node (see -exec)", http.StatusNotFound)
+			return
+		}
+		var req shared.ExecRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt64(&activeTasks, 1)
+		defer atomic.AddInt64(&activeTasks, -1)
+
+		log.Printf("[Agent:%s] Running %s code for %s (%d bytes)", cfg.NodeID, req.Language, req.ExecID, len(req.Code))
+		result := sandbox.run(r.Context(), cfg.NodeID, req)
+		log.Printf("[Agent:%s] Exec %s: exit %d in %dms (timed out: %v) %s", cfg.NodeID, req.ExecID, result.ExitCode, result.LatencyMs, result.TimedOut, result.Error)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,205 @@
+// node-agent/files.go
+// The files tasks reference, fetched from the orchestrator and cached.
+//
+// A task's "files" are IDs of uploads to the orchestrator's POST /files.
+// Before generating, the agent fetches each from GET /files/{id} — unless
+// it's in -file-cache already — and puts text files and the text in zip
+// archives ahead of the prompt, images beside it (see shared/files.go). IDs
+// are content hashes, so a cached file never goes stale and is checked
+// against its ID when fetched. The cache is trimmed, least recently used
+// first, to -file-cache-bytes.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// fileFetchTimeout bounds fetching one file from the orchestrator.
+const fileFetchTimeout = 2 * time.Minute
+
+var files *fileCache
+
+// fileCache keeps fetched files under dir: the content as <id>, its name
+// as <id>.name.
+type fileCache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex // serializes trimming
+}
+
+func newFileCache(dir string, maxBytes int64) *fileCache {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("[Files] Cannot create cache dir %s: %v", dir, err)
+	}
+	return &fileCache{dir: dir, maxBytes: maxBytes}
+}
+
+// get returns a file's name and content, from the cache or else fetched
+// from the orchestrator.
+func (c *fileCache) get(ctx context.Context, cfg Config, id string) (shared.TaskFile, error) {
+	if !shared.ValidFileID(id) {
+		return shared.TaskFile{}, fmt.Errorf("invalid file ID %q", id)
+	}
+	path := filepath.Join(c.dir, id)
+	if data, err := os.ReadFile(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		name, _ := os.ReadFile(path + ".name")
+		return shared.TaskFile{Name: fileLabel(string(name), id), Data: data}, nil
+	}
+
+	f, err := c.fetch(ctx, cfg, id)
+	if err != nil {
+		return f, err
+	}
+	if err := c.store(id, f); err != nil {
+		log.Printf("[Files] Not caching %s: %v", id[:12], err)
+	}
+	f.Name = fileLabel(f.Name, id)
+	return f, nil
+}
+
+// fetch downloads a file from the orchestrator and checks it against its ID.
+func (c *fileCache) fetch(ctx context.Context, cfg Config, id string) (shared.TaskFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, fileFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.OrchestratorURL+"/files/"+id, nil)
+	if err != nil {
+		return shared.TaskFile{}, err
+	}
+	authorize(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return shared.TaskFile{}, fmt.Errorf("fetching file %s: %w", id[:12], err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return shared.TaskFile{}, fmt.Errorf("fetching file %s: HTTP %d", id[:12], resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return shared.TaskFile{}, fmt.Errorf("fetching file %s: %w", id[:12], err)
+	}
+	if shared.FileID(data) != id {
+		return shared.TaskFile{}, fmt.Errorf("file %s arrived corrupted", id[:12])
+	}
+	f := shared.TaskFile{Data: data}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		f.Name = params["filename"]
+	}
+	return f, nil
+}
+
+// store caches a fetched file, then trims the cache.
+func (c *fileCache) store(id string, f shared.TaskFile) error {
+	path := filepath.Join(c.dir, id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, f.Data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if f.Name != "" {
+		os.WriteFile(path+".name", []byte(f.Name), 0o644)
+	}
+	c.trim()
+	return nil
+}
+
+// trim removes the least recently used files until the cache fits in
+// maxBytes.
+func (c *fileCache) trim() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type entry struct {
+		id   string
+		size int64
+		used time.Time
+	}
+	var entries []entry
+	var total int64
+	dirEntries, _ := os.ReadDir(c.dir)
+	for _, de := range dirEntries {
+		if !shared.ValidFileID(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{de.Name(), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			return
+		}
+		path := filepath.Join(c.dir, e.id)
+		os.Remove(path)
+		os.Remove(path + ".name")
+		total -= e.size
+	}
+}
+
+// fileLabel names a file in prompts: its upload name, else its ID.
+func fileLabel(name, id string) string {
+	if name != "" {
+		return name
+	}
+	return id
+}
+
+// attachFiles puts a task's files in front of its prompt and returns the
+// prompt with the images to send beside it, base64-encoded for Ollama.
+func attachFiles(ctx context.Context, cfg Config, task shared.TaskRequest) (string, []string, error) {
+	if len(task.Files) == 0 {
+		return task.Prompt, nil, nil
+	}
+	fetched := make([]shared.TaskFile, 0, len(task.Files))
+	for _, id := range task.Files {
+		f, err := files.get(ctx, cfg, id)
+		if err != nil {
+			return "", nil, err
+		}
+		fetched = append(fetched, f)
+	}
+	prompt, images, err := shared.ExpandFiles(task.Prompt, fetched)
+	if err != nil {
+		return "", nil, err
+	}
+	encoded := make([]string, len(images))
+	for i, img := range images {
+		encoded[i] = base64.StdEncoding.EncodeToString(img)
+	}
+	return prompt, encoded, nil
+}
+
+// prefetchFiles caches the files a bundle's tasks reference while the
+// orchestrator is reachable, so they can run after it no longer is.
+func prefetchFiles(cfg Config, tasks []shared.TaskRequest) {
+	for _, task := range tasks {
+		for _, id := range task.Files {
+			if _, err := files.get(context.Background(), cfg, id); err != nil {
+				log.Printf("[Files] Prefetch for task %s failed: %v", task.TaskID, err)
+			}
+		}
+	}
+}
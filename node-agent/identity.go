@@ -0,0 +1,111 @@
+// node-agent/identity.go
+// The agent's identity file.
+//
+// On first start the agent generates an identity — a UUID, the node ID it
+// will go by and an Ed25519 key pair — and keeps it in -identity. The node
+// ID is the one it would have had until then (host name and port), but it
+// stays the same from then on, when the host is renamed or the agent moves
+// to another port with its file, so the orchestrator keeps the node's
+// stats and reputation. Registrations are signed with the key, which the
+// orchestrator pins to the node ID: a restarted agent takes its node back
+// at once, and nobody else can claim it (see shared/identity.go).
+//
+// -id still names the node; the key then vouches for that ID instead.
+// Two agents must not share an identity file, so the default,
+// identity-<port>.json, names it after the agent's port: agents on one
+// host each get their own. An agent moving to another port keeps its node
+// by being given its old file with -identity.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// agentIdentity is the content of the identity file.
+type agentIdentity struct {
+	ID         string `json:"id"` // UUID
+	NodeID     string `json:"node_id"`
+	PublicKey  string `json:"public_key"`  // base64 Ed25519 public key
+	PrivateKey string `json:"private_key"` // base64 Ed25519 seed
+	CreatedAt  int64  `json:"created_at"`
+
+	key ed25519.PrivateKey
+}
+
+// loadIdentity reads the identity file at path, creating it with nodeID
+// if there is none.
+func loadIdentity(path, nodeID string) (*agentIdentity, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createIdentity(path, nodeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var id agentIdentity
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(id.PrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize || id.NodeID == "" {
+		return nil, fmt.Errorf("%s is not an identity file (remove it to generate a new identity, which the orchestrator must be told to accept)", path)
+	}
+	id.key = ed25519.NewKeyFromSeed(seed)
+	id.PublicKey = base64.StdEncoding.EncodeToString(id.key.Public().(ed25519.PublicKey))
+	return &id, nil
+}
+
+// createIdentity generates an identity and saves it, readable only by its
+// owner.
+func createIdentity(path, nodeID string) (*agentIdentity, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := &agentIdentity{
+		ID:         uuid.NewString(),
+		NodeID:     nodeID,
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		PrivateKey: base64.StdEncoding.EncodeToString(key.Seed()),
+		CreatedAt:  time.Now().UnixMilli(),
+		key:        key,
+	}
+	data, _ := json.MarshalIndent(id, "", "  ")
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	log.Printf("[Agent] Created identity %s for node %s in %s", shared.IdentityFingerprint(id.PublicKey), nodeID, path)
+	return id, nil
+}
+
+// sign fills in a registration's identity key and proof.
+func (id *agentIdentity) sign(req *shared.RegisterRequest) {
+	if id == nil {
+		return
+	}
+	req.IdentityKey = id.PublicKey
+	req.IdentityProof = shared.SignIdentityProof(id.key, req)
+}
@@ -0,0 +1,145 @@
+// node-agent/link.go
+// Measuring the agent's link to the orchestrator.
+//
+// A node can be perfectly healthy and still be a poor place for a stream:
+// on bad Wi-Fi its tokens arrive in bursts and its results late, and
+// nothing in its heartbeat shows why. Every -link-probe-interval the agent
+// sends linkProbes small requests to the orchestrator's GET /ping, a
+// linkProbeGap apart, and times each; one not answered within
+// linkProbeTimeout counts as lost. Heartbeats carry the median round trip,
+// the jitter and the loss over the last linkWindow probes, and the
+// orchestrator grades the link, weighs it in routing and shows it on the
+// dashboard.
+//
+// Probes go over a kept-alive connection, so a round trip is the network's
+// plus the orchestrator's time to answer; TCP's own retransmits show up as
+// slow probes and jitter rather than loss.
+
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// linkProbes is how many probes a round sends, linkProbeGap apart.
+	linkProbes   = 5
+	linkProbeGap = 200 * time.Millisecond
+	// linkProbeTimeout is how long a probe may take before it's lost.
+	linkProbeTimeout = 2 * time.Second
+	// linkWindow is how many recent probes the figures cover.
+	linkWindow = 60
+)
+
+// linkSample is one probe: its round trip, or lost.
+type linkSample struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// linkMonitor keeps the recent probes. A nil monitor means probing is
+// off; its methods are no-ops.
+type linkMonitor struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	samples []linkSample // a ring of the last linkWindow
+	next    int
+}
+
+// link is the agent's monitor; nil when -link-probe-interval is 0.
+var link *linkMonitor
+
+// newLinkMonitor returns a monitor probing orchestratorURL.
+func newLinkMonitor(orchestratorURL string) *linkMonitor {
+	return &linkMonitor{
+		url:    orchestratorURL + "/ping",
+		client: &http.Client{Timeout: linkProbeTimeout},
+	}
+}
+
+// linkLoop probes a round immediately and then every interval.
+func linkLoop(interval time.Duration) {
+	if link == nil {
+		return
+	}
+	for {
+		for i := 0; i < linkProbes; i++ {
+			if i > 0 {
+				time.Sleep(linkProbeGap)
+			}
+			link.add(link.probe())
+		}
+		time.Sleep(interval)
+	}
+}
+
+// probe times one request to the orchestrator. Any answer counts, even a
+// 404 from an orchestrator without GET /ping.
+func (m *linkMonitor) probe() linkSample {
+	start := time.Now()
+	resp, err := m.client.Get(m.url)
+	if err != nil {
+		return linkSample{lost: true}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return linkSample{rtt: time.Since(start)}
+}
+
+// add keeps a probe, dropping the oldest beyond linkWindow.
+func (m *linkMonitor) add(s linkSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) < linkWindow {
+		m.samples = append(m.samples, s)
+		return
+	}
+	m.samples[m.next] = s
+	m.next = (m.next + 1) % linkWindow
+}
+
+// report sums up the recent probes for heartbeats; nil before the first.
+func (m *linkMonitor) report() *shared.LinkQuality {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == 0 {
+		return nil
+	}
+	// Oldest first, for the jitter between consecutive probes
+	ordered := append(slices.Clone(m.samples[m.next:]), m.samples[:m.next]...)
+	q := &shared.LinkQuality{Probes: len(ordered)}
+	var rtts []float64
+	lost := 0
+	for _, s := range ordered {
+		if s.lost {
+			lost++
+			continue
+		}
+		ms := float64(s.rtt.Microseconds()) / 1000
+		if len(rtts) > 0 {
+			q.JitterMs += math.Abs(ms - rtts[len(rtts)-1])
+		}
+		rtts = append(rtts, ms)
+	}
+	q.Loss = float64(lost) / float64(len(ordered))
+	if len(rtts) > 1 {
+		q.JitterMs /= float64(len(rtts) - 1)
+	}
+	if len(rtts) > 0 {
+		slices.Sort(rtts)
+		q.RTTMs = rtts[len(rtts)/2]
+	}
+	return q
+}
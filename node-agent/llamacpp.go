@@ -0,0 +1,478 @@
+// node-agent/llamacpp.go
+// llama.cpp backend for devices that can't run Ollama (containers, NAS
+// boxes): the agent runs llama.cpp's llama-server — or a llamafile, which
+// embeds it — on a GGUF model itself, and generates through its HTTP API.
+//
+//	node-agent -backend llamacpp -gguf /models/mistral-7b.Q4_K_M.gguf
+//
+// The server is a child process on a loopback port. The agent starts it,
+// waits for the model to load before registering, restarts it whenever it
+// exits (the watchdog also restarts it when it stops answering, reporting
+// backend_down meanwhile) and stops it on shutdown. It serves the one model
+// it was started with, named by -models (default: the GGUF file name), so
+// model pulls are refused.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// Backends selectable with -backend.
+const (
+	backendOllama   = "ollama"
+	backendLlamaCpp = "llamacpp"
+)
+
+// llama is the llama.cpp server this agent runs; nil with the Ollama backend.
+var llama *llamaServer
+
+const (
+	// llamaStopTimeout is how long the server gets to exit after an
+	// interrupt before it is killed.
+	llamaStopTimeout = 5 * time.Second
+
+	// Restart backoff after the server exits, doubling up to the max. A run
+	// that lasted longer than llamaStableRun resets it.
+	llamaRestartMin = 2 * time.Second
+	llamaRestartMax = time.Minute
+	llamaStableRun  = time.Minute
+)
+
+// llamaServer supervises a llama-server (or llamafile) child process.
+type llamaServer struct {
+	bin   string   // llama-server or llamafile executable
+	args  []string // its full argument list
+	port  int      // loopback port it listens on
+	gguf  string   // model file ("" = weights embedded in a llamafile)
+	model string   // name tasks, /models and the orchestrator use
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd // running process, nil between restarts
+	stopping bool
+	exited   chan struct{} // closed when the supervisor returns
+}
+
+// newLlamaServer prepares the server command; extraArgs are appended
+// verbatim (e.g. "-ngl 99 -c 8192").
+func newLlamaServer(bin, gguf string, port int, extraArgs, model string) (*llamaServer, error) {
+	if gguf != "" {
+		if _, err := os.Stat(gguf); err != nil {
+			return nil, fmt.Errorf("-gguf: %w", err)
+		}
+	} else if !isLlamafile(bin) {
+		return nil, fmt.Errorf("-backend %s needs -gguf unless -llama-server is a llamafile with embedded weights", backendLlamaCpp)
+	}
+	var args []string
+	if isLlamafile(bin) {
+		args = append(args, "--server", "--nobrowser")
+	}
+	if gguf != "" {
+		args = append(args, "-m", gguf)
+	}
+	args = append(args, "--host", "127.0.0.1", "--port", strconv.Itoa(port))
+	args = append(args, strings.Fields(extraArgs)...)
+	return &llamaServer{bin: bin, args: args, port: port, gguf: gguf, model: model, exited: make(chan struct{})}, nil
+}
+
+// isLlamafile reports whether bin is a llamafile, which needs --server to
+// run as one.
+func isLlamafile(bin string) bool {
+	return strings.Contains(strings.ToLower(filepath.Base(bin)), "llamafile")
+}
+
+// ggufModelName names a model after its file: "mistral-7b.Q4_K_M.gguf" →
+// "mistral-7b.Q4_K_M".
+func ggufModelName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+func (s *llamaServer) baseURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", s.port)
+}
+
+// ─── Process lifecycle ────────────────────────────────────────────────────────
+
+// start runs the server in the background, restarting it whenever it
+// exits until stop is called.
+func (s *llamaServer) start() {
+	go s.supervise()
+}
+
+func (s *llamaServer) supervise() {
+	defer close(s.exited)
+	backoff := llamaRestartMin
+	for {
+		cmd := exec.Command(s.bin, s.args...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		bindToAgent(cmd)
+
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			return
+		}
+		log.Printf("[llama.cpp] Starting %s %s", s.bin, strings.Join(s.args, " "))
+		err := cmd.Start()
+		if err == nil {
+			s.cmd = cmd
+		}
+		s.mu.Unlock()
+
+		startedAt := time.Now()
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		s.mu.Lock()
+		s.cmd = nil
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		if time.Since(startedAt) > llamaStableRun {
+			backoff = llamaRestartMin
+		}
+		log.Printf("[llama.cpp] Server exited (%v) — restarting in %v", err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, llamaRestartMax)
+	}
+}
+
+// restart stops the running process; the supervisor starts a new one.
+func (s *llamaServer) restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil {
+		log.Printf("[llama.cpp] Restarting unresponsive server")
+		s.cmd.Process.Kill()
+	}
+}
+
+// stop shuts the server down for good, interrupting it first so it can
+// free the model cleanly.
+func (s *llamaServer) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	cmd := s.cmd
+	s.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	log.Printf("[llama.cpp] Stopping server")
+	if runtime.GOOS == "windows" {
+		cmd.Process.Kill()
+	} else {
+		cmd.Process.Signal(os.Interrupt)
+	}
+	select {
+	case <-s.exited:
+	case <-time.After(llamaStopTimeout):
+		cmd.Process.Kill()
+		<-s.exited
+	}
+}
+
+// waitReady blocks until the server has loaded its model, or ctx ends.
+func (s *llamaServer) waitReady(ctx context.Context) error {
+	for {
+		if err := s.health(ctx); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// errLlamaLoading is the health of a server still loading its model.
+var errLlamaLoading = errors.New("loading model")
+
+// health checks GET /health, which answers 503 while the model loads.
+func (s *llamaServer) health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL()+"/health", nil)
+	if err != nil {
+		return err
+	}
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusServiceUnavailable:
+		return errLlamaLoading
+	}
+	return fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// ─── Generation ───────────────────────────────────────────────────────────────
+
+type llamaRequest struct {
+	Prompt      string `json:"prompt"`
+	Stream      bool   `json:"stream"`
+	NPredict    int    `json:"n_predict,omitempty"`
+	CachePrompt bool   `json:"cache_prompt"`
+
+	// Sampling, from the task's options (see sampleWith)
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *float64 `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *float64 `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+
+	// Grammar-constrained sampling; the empty schema {} allows any JSON
+	JSONSchema json.RawMessage `json:"json_schema,omitempty"`
+}
+
+type llamaChunk struct {
+	Content string `json:"content"`
+	Stop    bool   `json:"stop"`
+
+	// Set on the final chunk
+	Timings *struct {
+		PromptMs           float64 `json:"prompt_ms"`
+		PredictedPerSecond float64 `json:"predicted_per_second"`
+	} `json:"timings,omitempty"`
+}
+
+// sampleWith sets the request's sampling from a task's options, which are
+// named as Ollama names them; llama.cpp shares most of the names. Options
+// it doesn't have are ignored.
+func (r *llamaRequest) sampleWith(opts map[string]any) {
+	num := func(name string) *float64 {
+		if v, ok := opts[name].(float64); ok {
+			return &v
+		}
+		return nil
+	}
+	if n := num("num_predict"); n != nil {
+		r.NPredict = int(*n)
+	}
+	r.Temperature, r.TopK, r.TopP, r.MinP = num("temperature"), num("top_k"), num("top_p"), num("min_p")
+	r.RepeatPenalty, r.Seed = num("repeat_penalty"), num("seed")
+	if stop, ok := opts["stop"].([]any); ok {
+		for _, s := range stop {
+			if s, ok := s.(string); ok {
+				r.Stop = append(r.Stop, s)
+			}
+		}
+	}
+}
+
+// stream sends a prompt to the server and calls onToken for each streamed
+// token, like streamOllama.
+func (s *llamaServer) stream(ctx context.Context, prompt string, format shared.OutputFormat, opts map[string]any, onToken func(token string, done bool, timings *shared.TaskTimings)) (*shared.TaskTimings, error) {
+	sentAt := time.Now()
+	req := llamaRequest{Prompt: prompt, Stream: true, CachePrompt: true}
+	if format == shared.FormatJSON {
+		req.JSONSchema = json.RawMessage("{}")
+	}
+	req.sampleWith(opts)
+	resp, err := s.complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The server streams SSE: "data: {...}" lines
+	var firstTokenAt time.Time
+	lines := shared.NewLineReader(resp.Body, maxLineBytes)
+	for {
+		line, err := lines.Next()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if err == io.EOF {
+				return nil, fmt.Errorf("llama.cpp stream ended before the response was done")
+			}
+			return nil, fmt.Errorf("reading llama.cpp stream: %w", err)
+		}
+		data, ok := bytes.CutPrefix(line, []byte("data: "))
+		if !ok {
+			continue
+		}
+		var chunk llamaChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			continue
+		}
+		if firstTokenAt.IsZero() {
+			firstTokenAt = time.Now()
+		}
+		if !chunk.Stop {
+			onToken(chunk.Content, false, nil)
+			continue
+		}
+		timings := llamaTimings(chunk, sentAt, firstTokenAt, time.Now())
+		modelUse.touch(s.model)
+		onToken(chunk.Content, true, timings)
+		return timings, nil
+	}
+}
+
+// probe runs a 1-token generation.
+func (s *llamaServer) probe(ctx context.Context) error {
+	resp, err := s.complete(ctx, llamaRequest{Prompt: "hi", NPredict: 1})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// complete posts to /completion, turning a non-200 answer into an error.
+func (s *llamaServer) complete(ctx context.Context, body llamaRequest) (*http.Response, error) {
+	return s.post(ctx, "/completion", body)
+}
+
+// embed embeds a batch of inputs on /v1/embeddings, which the server only
+// serves when started with --embedding (in -llama-args). It returns the
+// vectors in input order and the tokens the inputs came to; the model is
+// loaded already, so there's no load time.
+func (s *llamaServer) embed(ctx context.Context, inputs []string) ([][]float32, int64, int, error) {
+	resp, err := s.post(ctx, "/v1/embeddings", map[string]any{"input": inputs})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, 0, 0, fmt.Errorf("reading llama.cpp embeddings: %w", err)
+	}
+	if len(out.Data) != len(inputs) {
+		return nil, 0, 0, fmt.Errorf("llama.cpp returned %d embeddings for %d inputs", len(out.Data), len(inputs))
+	}
+	vectors := make([][]float32, len(inputs))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, 0, 0, fmt.Errorf("llama.cpp returned an embedding for input %d of %d", d.Index, len(inputs))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, 0, out.Usage.PromptTokens, nil
+}
+
+// post posts a JSON body to one of the server's endpoints, turning a
+// non-200 answer into an error.
+func (s *llamaServer) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL()+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("llama.cpp server unreachable on :%d (%w)", s.port, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(msg, &e) == nil && e.Error.Message != "" {
+			msg = []byte(e.Error.Message)
+		}
+		return nil, fmt.Errorf("llama.cpp returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// llamaTimings splits a task's time like splitTimings. The model is loaded
+// when the server starts, so there's no load time; queue wait is the time
+// to first token less prompt evaluation.
+func llamaTimings(final llamaChunk, sentAt, firstTokenAt, doneAt time.Time) *shared.TaskTimings {
+	t := &shared.TaskTimings{
+		FirstTokenMs: firstTokenAt.Sub(sentAt).Milliseconds(),
+		GenerationMs: doneAt.Sub(firstTokenAt).Milliseconds(),
+	}
+	if final.Timings != nil {
+		t.QueueMs = max(t.FirstTokenMs-int64(final.Timings.PromptMs), 0)
+		t.TokensPerSec = final.Timings.PredictedPerSecond
+	}
+	return t
+}
+
+// ─── GET /models ──────────────────────────────────────────────────────────────
+
+// quantRe finds the quantization in a GGUF file name ("…Q4_K_M.gguf").
+var quantRe = regexp.MustCompile(`(?i)\b(I?Q\d(_[A-Z0-9]+)*|BF16|F16|F32)\b`)
+
+// describe reports the server's model, with its context window from
+// GET /props when the server is up.
+func (s *llamaServer) describe(ctx context.Context) []shared.ModelDetail {
+	d := shared.ModelDetail{Name: s.model, Format: "gguf"}
+	if s.gguf != "" {
+		if fi, err := os.Stat(s.gguf); err == nil {
+			d.SizeBytes = uint64(fi.Size())
+			d.ModifiedAt = fi.ModTime().UnixMilli()
+		}
+		d.Quantization = strings.ToUpper(quantRe.FindString(filepath.Base(s.gguf)))
+	}
+	if s.health(ctx) == nil {
+		d.Loaded = true
+		d.ContextLength = s.contextLength(ctx)
+	}
+	return []shared.ModelDetail{d}
+}
+
+func (s *llamaServer) contextLength(ctx context.Context) int {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL()+"/props", nil)
+	if err != nil {
+		return 0
+	}
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	var props struct {
+		Settings struct {
+			NCtx int `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+	}
+	json.NewDecoder(resp.Body).Decode(&props)
+	return props.Settings.NCtx
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+// node-agent/llamacpp_linux.go
+// Tie the llama.cpp server's lifetime to the agent's on Linux.
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// bindToAgent has the kernel kill the server if the agent dies without
+// stopping it, so a crashed agent doesn't leave a model loaded in memory.
+func bindToAgent(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+// node-agent/llamacpp_other.go
+// Elsewhere the llama.cpp server is only stopped by a clean agent shutdown.
+
+package main
+
+import "os/exec"
+
+func bindToAgent(cmd *exec.Cmd) {}
@@ -0,0 +1,151 @@
+// node-agent/loaded.go
+// Which models the backend holds in memory, reported in heartbeats.
+//
+// The orchestrator prefers a node that already has a task's model loaded
+// over one that would first spend seconds loading it. After each good
+// probe the watchdog re-reads Ollama's /api/ps; a model that finished a
+// generation here since then counts as loaded too, as Ollama keeps it
+// resident afterwards. The llama.cpp server holds its one model while it
+// answers /health.
+//
+// Ollama also says what each loaded model holds — in all and on the GPU —
+// and when it will unload it if it sits idle; heartbeats carry that as
+// loaded_memory, so the orchestrator can tell a model about to be evicted
+// from one that will stay warm. The memory guard sizes models it has no
+// -model-sizes entry for by what /api/ps last said they took.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// loadedModels is the backend's last answer about what it has in memory.
+type loadedModels struct {
+	mu     sync.Mutex
+	models []string
+	memory []shared.LoadedModel // what they hold; Ollama only
+	at     time.Time            // when the backend last answered
+
+	sizes map[string]uint64 // the memory each model held when last seen loaded
+}
+
+var loaded = &loadedModels{sizes: make(map[string]uint64)}
+
+// refresh re-reads the loaded models after a good probe. If /api/ps
+// can't be read, the last answer is kept.
+func (l *loadedModels) refresh(cfg Config) {
+	var models []string
+	var memory []shared.LoadedModel
+	if llama != nil {
+		models = []string{llama.model}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), watchdogTimeout)
+		defer cancel()
+		var ps struct {
+			Models []struct {
+				Name      string    `json:"name"`
+				Size      uint64    `json:"size"`
+				SizeVRAM  uint64    `json:"size_vram"`
+				ExpiresAt time.Time `json:"expires_at"`
+			} `json:"models"`
+		}
+		if err := ollamaJSON(ctx, cfg.OllamaHost, cfg.OllamaPort, "GET", "/api/ps", nil, &ps); err != nil {
+			return
+		}
+		for _, m := range ps.Models {
+			models = append(models, m.Name)
+			lm := shared.LoadedModel{Name: m.Name, SizeBytes: m.Size, VRAMBytes: m.SizeVRAM}
+			if !m.ExpiresAt.IsZero() {
+				lm.ExpiresAt = m.ExpiresAt.UnixMilli()
+			}
+			memory = append(memory, lm)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.models, l.memory, l.at = models, memory, time.Now()
+	for _, m := range memory {
+		// On a GPU box the VRAM is what the budget covers
+		if m.VRAMBytes > 0 {
+			l.sizes[m.Name] = m.VRAMBytes
+		} else if m.SizeBytes > 0 {
+			l.sizes[m.Name] = m.SizeBytes
+		}
+	}
+}
+
+// clear forgets the loaded models after a failed probe: a backend that
+// doesn't answer (or a llama.cpp server still loading) serves nothing
+// warm.
+func (l *loadedModels) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.models, l.memory, l.at = nil, nil, time.Now()
+}
+
+// report lists the loaded models for a heartbeat: the backend's last
+// answer plus the models used since.
+func (l *loadedModels) report() []string {
+	if backendDown.Load() {
+		return nil
+	}
+	l.mu.Lock()
+	models := append([]string(nil), l.models...)
+	at := l.at
+	l.mu.Unlock()
+	for _, m := range modelUse.since(at) {
+		if !containsSameModel(models, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// memoryReport lists what the loaded models hold for a heartbeat; nil
+// while the backend is down. A model used since /api/ps was read has had
+// its unload time pushed back by an unknown amount, so it goes without one.
+func (l *loadedModels) memoryReport() []shared.LoadedModel {
+	if backendDown.Load() {
+		return nil
+	}
+	l.mu.Lock()
+	memory := append([]shared.LoadedModel(nil), l.memory...)
+	at := l.at
+	l.mu.Unlock()
+	used := modelUse.since(at)
+	for i := range memory {
+		if containsSameModel(used, memory[i].Name) {
+			memory[i].ExpiresAt = 0
+		}
+	}
+	return memory
+}
+
+// size returns the memory model held when it was last seen loaded.
+func (l *loadedModels) size(model string) (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n, ok := l.sizes[model]; ok {
+		return n, true
+	}
+	for name, n := range l.sizes {
+		if shared.SameModel(name, model) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func containsSameModel(installed []string, name string) bool {
+	for _, m := range installed {
+		if shared.SameModel(m, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -9,10 +9,11 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -20,6 +21,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
@@ -33,43 +36,190 @@ import (
 // orchestrator always knows the true load on this node.
 var activeTasks int64
 
+// sessionToken holds the session token (a string) the orchestrator issued
+// at registration. Heartbeats, work polls and result uploads carry it, as
+// does re-registering while the orchestrator still counts us alive; the
+// orchestrator sends it back on the calls that change this machine (see
+// fromOrchestrator).
+var sessionToken atomic.Value
+
+// maxLineBytes is the longest line accepted from the backend's token
+// stream; set from -max-line-bytes.
+var maxLineBytes = shared.DefaultMaxLineBytes
+
 // ─── Config ───────────────────────────────────────────────────────────────────
 
 type Config struct {
-	NodeID          string
-	AgentHost       string // hostname/IP this agent is reachable at
-	AgentPort       int    // this agent's HTTP server port
-	OllamaHost      string // Ollama hostname (default: localhost)
-	OllamaPort      int    // local Ollama port
-	OrchestratorURL string
-	Models          []string
-	Capabilities    []shared.ModelCapability // which task types each model handles
+	NodeID           string
+	AgentHost        string // hostname/IP this agent is reachable at
+	AgentPort        int    // this agent's HTTP server port
+	Listen           string // address the HTTP server binds: ":9001" or "unix:/path"
+	OllamaHost       string // Ollama hostname (default: localhost)
+	OllamaPort       int    // local Ollama port
+	OrchestratorURL  string
+	Models           []string                 // as declared; advertised holds those served now
+	Capabilities     []shared.ModelCapability // which task types each model handles, as declared
+	BusyThreshold    int                      // active tasks at which this node reports busy
+	ModelsDir        string                   // Ollama models directory (for disk space reporting)
+	OllamaRestartCmd string                   // shell command that restarts a locally-managed Ollama ("" = never restart)
+	CompressMinBytes int                      // compress /execute results at least this large (-1 = never)
+	BundleSize       int                      // max deferred tasks claimed per offline bundle (0 = bundling off)
+	BundleDir        string                   // where claimed bundles and their progress are kept
+	Pull             bool                     // fetch tasks from the orchestrator's GET /work instead of serving /execute
+	PullWorkers      int                      // tasks pulled and run at once in pull mode
+	Canary           bool                     // take only mirrored and targeted tasks
+	Exec             []string                 // languages the -exec sandbox runs (none = off)
+	Identity         *agentIdentity           // signs registrations; nil without -identity
 }
 
 func main() {
 	// Flags — makes it easy to run two instances with different ports
-	nodeID := flag.String("id", "", "Unique node ID (e.g. node-a)")
+	nodeID := flag.String("id", "", "Unique node ID (e.g. node-a); default: the one in -identity, first generated from the hostname and port")
+	identityPath := flag.String("identity", "identity-<port>.json", "File keeping this agent's node ID and key pair, created on first start, so the node stays the same across restarts and host changes; <port> is replaced by -port, so agents on one host keep separate files (empty = no identity: the ID is hostname-port and registrations are unsigned)")
 	agentPort := flag.Int("port", 9001, "Port this agent listens on")
 	ollamaPort := flag.Int("ollama-port", 11434, "Local Ollama port")
-	orchURL := flag.String("orchestrator", "auto", "Orchestrator URL ('auto' = mDNS discovery)")
+	orchURL := flag.String("orchestrator", "auto", "Orchestrator URL, or unix:/path for an orchestrator on this host's socket ('auto' = mDNS discovery)")
 	agentHost := flag.String("host", "", "Hostname/IP this agent is reachable at (default: auto-detect)")
-	ollamaHost := flag.String("ollama-host", "localhost", "Ollama hostname (for Docker: service name)")
+	ollamaHost := flag.String("ollama-host", "localhost", "Ollama hostname (for Docker: service name), or unix:/path for an Ollama socket")
+	listen := flag.String("listen", "", "Address to serve on, or unix:/path to serve only same-host orchestrators through a socket (default: :<port>)")
 	modelsFlag := flag.String("models", "mistral", "Comma-separated model names")
 	// capabilities format: "mistral:text,summarize;codellama:code"
 	// Each entry is "modelname:type1,type2" separated by semicolons.
 	capsFlag := flag.String("capabilities", "", "Model capabilities, e.g. mistral:text,summarize;codellama:code")
+	modelsDir := flag.String("ollama-models-dir", defaultModelsDir(), "Ollama models directory, used to report free disk space")
+	restartCmd := flag.String("ollama-restart-cmd", "", "Shell command to restart a locally-managed Ollama when the watchdog finds it dead (e.g. \"systemctl restart ollama\")")
+	compressMin := flag.Int("compress-min-bytes", shared.DefaultCompressMinBytes, "Compress /execute results of at least this many bytes with zstd/gzip when the orchestrator accepts it (-1 = never)")
+	parallelFlag := flag.String("parallel", "", "Parallel generations per model: one number for all models (\"4\") or \"mistral:4,codellama:2\" (default: $OLLAMA_NUM_PARALLEL, else undeclared)")
+	bundleSize := flag.Int("bundle-size", 0, "Claim offline bundles of up to this many deferred tasks while idle (0 = disabled)")
+	bundleDir := flag.String("bundle-dir", "bundles", "Directory for claimed offline bundles and their results")
+	fileCacheDir := flag.String("file-cache", "file-cache", "Directory caching the files tasks reference, fetched from the orchestrator")
+	fileCacheBytes := flag.Int64("file-cache-bytes", 1<<30, "Trim the file cache, least recently used first, to this many bytes")
+	languagesFlag := flag.String("languages", "", "Languages each model is notably good at, e.g. qwen2:zh,en;mistral:en,fr (tasks with a matching language hint prefer them)")
+	tiersFlag := flag.String("tiers", "", "Size class of each model, small, medium or large, e.g. phi3:small,llama3:70b:large, for tasks with a min_quality (default: from its parameter count)")
+	exclusiveFlag := flag.String("exclusive", "", "Comma-separated models that must run one generation at a time (e.g. llama3:70b); the orchestrator serializes their tasks")
+	backend := flag.String("backend", backendOllama, "Generation backend: ollama, or llamacpp to run llama.cpp's server on -gguf where Ollama can't be installed")
+	ggufPath := flag.String("gguf", "", "GGUF model file served by the llamacpp backend")
+	llamaBin := flag.String("llama-server", "llama-server", "llama.cpp server executable, or a llamafile, for the llamacpp backend")
+	llamaPort := flag.Int("llama-port", 8090, "Loopback port the llamacpp backend's server listens on")
+	llamaArgs := flag.String("llama-args", "", "Extra arguments for the llamacpp backend's server (e.g. \"-ngl 99 -c 8192\")")
+	pull := flag.Bool("pull", false, "Fetch tasks from the orchestrator (GET /work) instead of waiting for it to connect — for agents behind NAT or a firewall")
+	pullWorkers := flag.Int("pull-workers", 1, "Tasks pulled and run at once with -pull")
+	canary := flag.Bool("canary", false, "Join as a canary: take only mirrored tasks and tasks that target this node, never normal routing (for trying a new Ollama version or model)")
+	execEngine := flag.String("exec", "", "Run pipeline exec steps' code in sandboxed containers with this engine: docker or podman (empty = no code execution)")
+	execRuntime := flag.String("exec-runtime", "", "OCI runtime for -exec containers, e.g. runsc for gVisor (default: the engine's)")
+	execImages := flag.String("exec-images", defaultExecImages, "Languages -exec runs and the image for each, as language=image (python, sh, node)")
+	execCPUs := flag.String("exec-cpus", "1", "CPUs each -exec container may use")
+	execMemory := flag.String("exec-memory", "256m", "Memory each -exec container may use")
+	execTimeout := flag.Duration("exec-timeout", 30*time.Second, "Longest an -exec program may run")
+	flag.DurationVar(&streamGrace, "stream-grace", streamGrace, "Keep generating a streamed task or pipeline step this long after the orchestrator's connection drops, for it to reattach or collect it after a restart")
+	flag.IntVar(&maxLineBytes, "max-line-bytes", shared.DefaultMaxLineBytes, "Longest single line accepted from the backend's token stream")
+	flag.IntVar(&embedBatchSize, "embed-batch-size", embedBatchSize, "Most embed task inputs sent to the backend in one request")
+	linkInterval := flag.Duration("link-probe-interval", 10*time.Second, "How often to time a round of probes to the orchestrator, for the link quality heartbeats report (0 = off)")
+	peerDiscovery := flag.Bool("peer-discovery", true, "Advertise this agent over mDNS (_echo-node._tcp) and report the peers it sees, with RTT, for the orchestrator's topology map")
+	busyThreshold := flag.Int("busy-threshold", 5, "Active tasks at which this node reports busy (the orchestrator may adapt it from observed latency)")
+	thermalThrottle := flag.Float64("thermal-throttle", 0, "CPU/GPU temperature (°C) above which the node advertises half its capacity (0 = off)")
+	thermalBusy := flag.Float64("thermal-busy", 0, "CPU/GPU temperature (°C) above which the node reports busy (0 = off)")
+	memoryBudget := flag.String("memory-budget", "", "VRAM (or RAM) the models running at once may use, e.g. 24GiB; tasks whose model would exceed it are refused with 409 (empty = no limit)")
+	backendHeadersFlag := flag.String("backend-headers", "", "Headers sent with every backend request, as Name=value pairs separated by ';' with $VARS expanded, e.g. 'Authorization=Bearer ${OLLAMA_KEY}'")
+	passHeaders := flag.String("pass-headers", "", "Comma-separated task headers, passed through by the orchestrator's -pass-headers, to hand on to the backend")
+	modelSizes := flag.String("model-sizes", "", "Memory each model takes once loaded, for -memory-budget, e.g. llama3:70b=40GiB,mistral=5GiB")
+	showVersion := flag.Bool("version", false, "Print the build's version and mesh API version and exit")
 	flag.Parse()
+	if *showVersion {
+		fmt.Println("echo-node-agent", shared.BuildInfo())
+		return
+	}
+	if embedBatchSize < 1 {
+		log.Fatalf("[Agent] -embed-batch-size must be at least 1")
+	}
 
+	var identity *agentIdentity
+	if *identityPath != "" {
+		hostname, _ := os.Hostname()
+		path := strings.ReplaceAll(*identityPath, "<port>", strconv.Itoa(*agentPort))
+		id, err := loadIdentity(path, fmt.Sprintf("%s-%d", hostname, *agentPort))
+		if err != nil {
+			log.Fatalf("[Agent] -identity: %v", err)
+		}
+		identity = id
+		if *nodeID == "" {
+			*nodeID = id.NodeID
+		}
+	}
 	if *nodeID == "" {
 		hostname, _ := os.Hostname()
 		*nodeID = fmt.Sprintf("%s-%d", hostname, *agentPort)
 	}
 
+	if *busyThreshold < 1 {
+		*busyThreshold = 5
+	}
+	if *pullWorkers < 1 {
+		*pullWorkers = 1
+	}
+	thermal = newThermalMonitor(*thermalThrottle, *thermalBusy)
+	if *execEngine != "" {
+		s, err := newSandbox(*execEngine, *execRuntime, *execImages, *execCPUs, *execMemory, *execTimeout)
+		if err != nil {
+			log.Fatalf("[Agent] %v", err)
+		}
+		sandbox = s
+		log.Printf("[Agent] exec sandbox: %s (runtime %q) runs %v", s.engine, s.runtime, s.languages())
+	}
+	files = newFileCache(*fileCacheDir, *fileCacheBytes)
+
+	switch *backend {
+	case backendOllama:
+	case backendLlamaCpp:
+		// The server runs one model: name it after the file unless -models says
+		if !flagSet("models") {
+			name := ggufModelName(*ggufPath)
+			if *ggufPath == "" {
+				name = ggufModelName(*llamaBin)
+			}
+			*modelsFlag = name
+		}
+		if !flagSet("ollama-models-dir") && *ggufPath != "" {
+			*modelsDir = filepath.Dir(*ggufPath)
+		}
+		server, err := newLlamaServer(*llamaBin, *ggufPath, *llamaPort, *llamaArgs, strings.Split(*modelsFlag, ",")[0])
+		if err != nil {
+			log.Fatalf("[Agent] %v", err)
+		}
+		llama = server
+	default:
+		log.Fatalf("[Agent] Unknown -backend %q (want %s or %s)", *backend, backendOllama, backendLlamaCpp)
+	}
+
 	models := strings.Split(*modelsFlag, ",")
 	caps := parseCapabilities(*capsFlag, models)
 	log.Printf("[Agent] capabilities flag raw value: %q", *capsFlag)
+	markExclusive(caps, *exclusiveFlag)
+	markLanguages(caps, *languagesFlag)
+	markTiers(caps, *tiersFlag)
+	inferTiers(caps, *ollamaHost, *ollamaPort)
 	for _, c := range caps {
-		log.Printf("[Agent] capability: model=%s types=%v", c.Name, c.Types)
+		log.Printf("[Agent] capability: model=%s types=%v exclusive=%v languages=%v tier=%s", c.Name, c.Types, c.Exclusive, c.Languages, c.Tier)
+	}
+	advertised = newCapabilityTracker(caps, models)
+	var err error
+	if slots, err = parseSlots(*parallelFlag, models); err != nil {
+		log.Fatalf("[Agent] %v", err)
+	}
+	for _, s := range slots.report() {
+		log.Printf("[Agent] slots: model=%s parallel=%d", s.Model, s.Total)
+	}
+	if backendHeaders, err = parseBackendHeaders(*backendHeadersFlag); err != nil {
+		log.Fatalf("[Agent] %v", err)
+	}
+	if passHeaderNames, err = shared.ParseHeaderNames(*passHeaders); err != nil {
+		log.Fatalf("[Agent] -pass-headers: %v", err)
+	}
+	if memGuard, err = parseMemoryGuard(*memoryBudget, *modelSizes); err != nil {
+		log.Fatalf("[Agent] %v", err)
+	}
+	if memGuard != nil {
+		log.Printf("[Agent] memory budget: %s for %d sized models", formatBytes(memGuard.budget), len(memGuard.sizes))
 	}
 
 	// Phase 6: mDNS auto-discovery
@@ -77,26 +227,95 @@ func main() {
 	if orchestratorURL == "auto" || orchestratorURL == "" {
 		log.Println("[Agent] No orchestrator URL specified — using mDNS discovery")
 		orchestratorURL = discoverOrchestratorWithRetry()
+	} else if path, ok := shared.SocketPath(orchestratorURL); ok {
+		orchestratorURL = "http://" + shared.SocketHost(path)
 	}
 
-	// Determine the host this agent is reachable at
+	// Determine the host this agent is reachable at. Behind a socket that's
+	// the socket itself, which the orchestrator dials in place of host:port
+	if *listen == "" {
+		*listen = fmt.Sprintf(":%d", *agentPort)
+	}
 	resolvedHost := *agentHost
-	if resolvedHost == "" {
+	if _, ok := shared.SocketPath(*listen); ok {
+		resolvedHost = *listen
+	} else if resolvedHost == "" {
 		resolvedHost = getPreferredOutboundIP()
 	}
 
 	cfg := Config{
-		NodeID:          *nodeID,
-		AgentHost:       resolvedHost,
-		AgentPort:       *agentPort,
-		OllamaHost:      *ollamaHost,
-		OllamaPort:      *ollamaPort,
-		OrchestratorURL: orchestratorURL,
-		Models:          models,
-		Capabilities:    caps,
+		NodeID:           *nodeID,
+		AgentHost:        resolvedHost,
+		AgentPort:        *agentPort,
+		Listen:           *listen,
+		OllamaHost:       *ollamaHost,
+		OllamaPort:       *ollamaPort,
+		OrchestratorURL:  orchestratorURL,
+		Models:           models,
+		Capabilities:     caps,
+		BusyThreshold:    *busyThreshold,
+		ModelsDir:        *modelsDir,
+		OllamaRestartCmd: *restartCmd,
+		CompressMinBytes: *compressMin,
+		BundleSize:       *bundleSize,
+		BundleDir:        *bundleDir,
+		Pull:             *pull,
+		PullWorkers:      *pullWorkers,
+		Canary:           *canary,
+		Exec:             sandbox.languages(),
+		Identity:         identity,
+	}
+
+	if llama != nil {
+		log.Printf("[Agent:%s] Starting (agent %s, llama.cpp :%d serving %s)", cfg.NodeID, cfg.Listen, llama.port, llama.model)
+		llama.start()
+	} else {
+		log.Printf("[Agent:%s] Starting (agent %s, ollama %s)", cfg.NodeID, cfg.Listen, ollamaAddr(cfg.OllamaHost, cfg.OllamaPort))
+	}
+
+	// Advertise to and browse for the other agents; heartbeats report the
+	// peers seen for the orchestrator's topology map
+	if _, onSocket := shared.SocketPath(cfg.Listen); *peerDiscovery && !onSocket {
+		if stop, err := startPeerDiscovery(cfg); err != nil {
+			log.Printf("[Peers] Peer discovery off: %v", err)
+		} else {
+			defer stop()
+		}
+	}
+
+	// Time the link to the orchestrator; heartbeats report it (see link.go)
+	if *linkInterval > 0 {
+		link = newLinkMonitor(cfg.OrchestratorURL)
+		go linkLoop(*linkInterval)
 	}
 
-	log.Printf("[Agent:%s] Starting (agent :%d, ollama :%d)", cfg.NodeID, cfg.AgentPort, cfg.OllamaPort)
+	// Measure disk/VRAM in the background; heartbeats report the latest sample
+	go resourceLoop(cfg.ModelsDir)
+
+	// Shed load while the box runs hot (see thermal.go)
+	go thermalLoop()
+
+	// Watch the Ollama backend so we stop receiving tasks while it's dead
+	go watchdogLoop(cfg)
+
+	// Start HTTP server first so the orchestrator can reach us (e.g. to
+	// probe capabilities) as soon as we register
+	srv := startServer(cfg)
+
+	// Work through deferred tasks locally, even while disconnected — a
+	// bundle saved before a restart resumes without waiting to register
+	if cfg.BundleSize > 0 {
+		go bundleLoop(cfg)
+	}
+
+	// Don't take tasks before llama.cpp has loaded the model
+	if llama != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		if err := llama.waitReady(ctx); err != nil {
+			log.Printf("[Agent:%s] llama.cpp server not ready after %v — registering anyway", cfg.NodeID, probeTimeout)
+		}
+		cancel()
+	}
 
 	// Register with orchestrator (retry until it's up)
 	registerWithRetry(cfg)
@@ -104,87 +323,200 @@ func main() {
 	// Start heartbeat in background
 	go heartbeatLoop(cfg)
 
-	// Start HTTP server
-	runServer(cfg)
+	// Behind NAT the orchestrator can't reach /execute; fetch tasks instead
+	if cfg.Pull {
+		for i := 0; i < cfg.PullWorkers; i++ {
+			go workLoop(cfg)
+		}
+	}
+
+	waitForShutdown(cfg, srv)
 }
 
 // ─── Registration ─────────────────────────────────────────────────────────────
 
 func registerWithRetry(cfg Config) {
 	req := shared.RegisterRequest{
-		NodeID:       cfg.NodeID,
-		AgentHost:    cfg.AgentHost,
-		AgentPort:    cfg.AgentPort,
-		OllamaPort:   cfg.OllamaPort,
-		Models:       cfg.Models,
-		Capabilities: cfg.Capabilities,
-		Status:       shared.StatusIdle,
+		NodeID:        cfg.NodeID,
+		AgentHost:     cfg.AgentHost,
+		AgentPort:     cfg.AgentPort,
+		OllamaPort:    cfg.OllamaPort,
+		Status:        shared.StatusIdle,
+		BusyThreshold: cfg.BusyThreshold,
+		Slots:         slots.report(),
+		Pull:          cfg.Pull,
+		Canary:        cfg.Canary,
+		Exec:          cfg.Exec,
+		Version:       shared.Version,
+		APIVersion:    shared.MeshAPIVersion,
 	}
 
 	for {
-		err := postJSON(cfg.OrchestratorURL+"/register", req, nil)
+		req.Capabilities, req.Models, req.CapabilitySeq = advertised.registration()
+		req.Time = time.Now().UnixMilli()
+		cfg.Identity.sign(&req)
+		var resp shared.RegisterResponse
+		err := postJSON(cfg.OrchestratorURL+"/register", req, &resp)
 		if err == nil {
-			log.Printf("[Agent:%s] Registered with orchestrator", cfg.NodeID)
+			sessionToken.Store(resp.SessionToken)
+			log.Printf("[Agent:%s] Registered with orchestrator %s", cfg.NodeID, resp.Version)
+			switch {
+			case resp.APIVersion != 0 && resp.APIVersion != shared.MeshAPIVersion:
+				// An orchestrator from before it checked
+				log.Printf("[Agent:%s] WARNING: the orchestrator speaks mesh API v%d, this agent v%d; run matching versions",
+					cfg.NodeID, resp.APIVersion, shared.MeshAPIVersion)
+			case shared.IsRelease(resp.Version) && shared.IsRelease(shared.Version) && resp.Version != shared.Version:
+				log.Printf("[Agent:%s] The orchestrator runs %s, this agent %s", cfg.NodeID, resp.Version, shared.Version)
+			}
 			return
 		}
-		log.Printf("[Agent:%s] Orchestrator not ready, retrying in 3s: %v", cfg.NodeID, err)
-		time.Sleep(3 * time.Second)
+		// 409: our previous registration (e.g. before a restart) hasn't
+		// timed out yet and we no longer have its token — unless our
+		// identity vouches for us — or the node ID is pinned to another
+		// agent's identity. 426: the
+		// orchestrator speaks another mesh API version, and will until
+		// one side is upgraded
+		wait := 3 * time.Second
+		if strings.HasPrefix(err.Error(), fmt.Sprintf("HTTP %d", http.StatusUpgradeRequired)) {
+			wait = 30 * time.Second
+		}
+		log.Printf("[Agent:%s] Registration failed, retrying in %s: %v", cfg.NodeID, wait, err)
+		time.Sleep(wait)
 	}
 }
 
 // ─── Heartbeat ────────────────────────────────────────────────────────────────
 
+// heartbeatLoop reports to the orchestrator every 3 seconds, and right
+// away when the advertised capabilities change.
 func heartbeatLoop(cfg Config) {
 	ticker := time.NewTicker(3 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-advertised.kick:
+		}
 		count := int(atomic.LoadInt64(&activeTasks))
 		status := shared.StatusIdle
-		if count >= 5 {
+		// With declared slots, capacity is the slots rather than the
+		// node-wide task count
+		if slots != nil {
+			if slots.full() {
+				status = shared.StatusBusy
+			}
+		} else if count >= cfg.BusyThreshold {
 			status = shared.StatusBusy
 		}
+		if thermal.current() == shared.ThermalHot {
+			status = shared.StatusBusy
+		}
+		if backendDown.Load() {
+			status = shared.StatusBackendDown
+		}
 
 		hb := shared.HeartbeatRequest{
 			NodeID:      cfg.NodeID,
 			Status:      status,
 			ActiveTasks: count,
+			Resources:   currentResources(),
+			Slots:       slots.report(),
+			Peers:       currentPeers(),
+			Thermal:     thermal.report(),
+			Link:        link.report(),
+			Loaded:      loaded.report(),
+			Time:        time.Now().UnixMilli(),
+
+			LoadedMemory:        loaded.memoryReport(),
+			CapabilityChanges:   advertised.pending(),
+			CapabilitiesChecked: advertised.checkedAt(),
+			Version:             shared.Version,
+			APIVersion:          shared.MeshAPIVersion,
 		}
 		err := postJSON(cfg.OrchestratorURL+"/heartbeat", hb, nil)
 		if err != nil {
-			// Any failure (network blip or 404 = orchestrator restarted) triggers re-register
+			// Any failure (network blip, 404 = orchestrator restarted, 401 =
+			// our token was replaced, 409 = it missed capability changes)
+			// triggers re-register
 			log.Printf("[Agent:%s] Heartbeat failed (%v) — re-registering", cfg.NodeID, err)
 			registerWithRetry(cfg)
+		} else if d := hb.CapabilityChanges; d != nil {
+			advertised.ack(d.Seq)
 		}
 	}
 }
 
 // ─── HTTP Server ──────────────────────────────────────────────────────────────
 
-func runServer(cfg Config) {
+// startServer binds the agent's HTTP port and serves in the background.
+func startServer(cfg Config) *http.Server {
 	mux := http.NewServeMux()
 
 	// Orchestrator calls these to execute tasks
 	mux.HandleFunc("POST /execute", makeExecuteHandler(cfg))
 	mux.HandleFunc("POST /execute/stream", makeExecuteStreamHandler(cfg))
+	mux.HandleFunc("GET /execute/stream/{id}", makeReattachStreamHandler(cfg))
+
+	// A restarted orchestrator collects the pipeline steps it lost (see orphans.go)
+	mux.HandleFunc("GET /orphans", makeOrphansHandler(cfg))
+	mux.HandleFunc("DELETE /orphans/{id}", fromOrchestrator(makeClaimOrphanHandler(cfg)))
+
+	// Orchestrator calls these to verify declared capabilities
+	mux.HandleFunc("GET /models", makeModelsHandler(cfg))
+	mux.HandleFunc("POST /probe", makeProbeHandler(cfg))
+	mux.HandleFunc("POST /pull", fromOrchestrator(makePullHandler(cfg)))
+
+	// Operators call this, directly or through the orchestrator's admin API
+	mux.HandleFunc("POST /selftest", makeSelfTestHandler(cfg))
+
+	// Orchestrator calls this to run pipeline exec steps (see exec.go)
+	mux.HandleFunc("POST /exec", fromOrchestrator(makeExecHandler(cfg)))
 
 	// Health check
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "ok")
 	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared.BuildInfo())
+	})
 
-	addr := fmt.Sprintf(":%d", cfg.AgentPort)
-	log.Printf("[Agent:%s] HTTP server on %s", cfg.NodeID, addr)
+	log.Printf("[Agent:%s] HTTP server on %s", cfg.NodeID, cfg.Listen)
 
-	// Graceful shutdown
-	srv := &http.Server{Addr: addr, Handler: mux}
+	// Bind synchronously so the port is reachable before we register
+	ln, err := shared.Listen(cfg.Listen)
+	if err != nil {
+		log.Fatalf("[Agent:%s] Listen error: %v", cfg.NodeID, err)
+	}
+	srv := &http.Server{Handler: mux}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("[Agent:%s] Server error: %v", cfg.NodeID, err)
 		}
 	}()
+	return srv
+}
+
+// fromOrchestrator serves h only to callers presenting this node's current
+// session token, answering 401 otherwise. It guards the endpoints that run
+// containers, download models or drop results: anyone on the LAN can reach
+// the agent, but only the orchestrator it registered with knows the token.
+func fromOrchestrator(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := sessionToken.Load().(string)
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "missing or wrong session token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
 
+// waitForShutdown blocks until SIGINT/SIGTERM, then shuts the server down gracefully.
+func waitForShutdown(cfg Config, srv *http.Server) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -192,6 +524,20 @@ func runServer(cfg Config) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
+	if llama != nil {
+		llama.stop()
+	}
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 // ─── Execute (non-streaming) ──────────────────────────────────────────────────
@@ -204,34 +550,96 @@ func makeExecuteHandler(cfg Config) http.HandlerFunc {
 			return
 		}
 
-		log.Printf("[Agent:%s] Executing task %s", cfg.NodeID, req.TaskID)
-		startedAt := time.Now()
-		atomic.AddInt64(&activeTasks, 1)
-		defer atomic.AddInt64(&activeTasks, -1)
+		if r.URL.Query().Get("resend") != "" {
+			if result, ok := sent.get(req.TaskID); ok {
+				log.Printf("[Agent:%s] Resending the result of task %s", cfg.NodeID, req.TaskID)
+				shared.WriteJSON(w, r, http.StatusOK, result, cfg.CompressMinBytes)
+				return
+			}
+		}
 
-		model := resolveModel(cfg, req.ModelHint, req.Type)
-		content, err := callOllama(r.Context(), cfg.OllamaHost, cfg.OllamaPort, model, req.Prompt, false)
-		if err != nil {
-			result := shared.TaskResult{
-				TaskID:  req.TaskID,
-				Success: false,
-				Error:   err.Error(),
+		log.Printf("[Agent:%s] Executing task %s", cfg.NodeID, req.TaskID)
+		ctx := withPassedHeaders(r.Context(), r.Header)
+		var result shared.TaskResult
+		if req.Lineage != nil && req.Lineage.PipelineID != "" {
+			// Pipeline steps outlive a lost orchestrator (see orphans.go)
+			var ok bool
+			if result, ok = orphans.execute(ctx, cfg, req); !ok {
+				return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(result)
-			return
+		} else {
+			result = executeTask(ctx, cfg, req)
+		}
+		sent.add(result)
+		status := http.StatusOK
+		if result.ErrorCode == shared.ErrCodeMemoryBudget {
+			status = http.StatusConflict
 		}
+		shared.WriteJSON(w, r, status, result, cfg.CompressMinBytes)
+	}
+}
 
-		result := shared.TaskResult{
+// executeTask runs a task against the backend, for /execute and for
+// tasks pulled in pull mode.
+func executeTask(ctx context.Context, cfg Config, req shared.TaskRequest) shared.TaskResult {
+	startedAt := time.Now()
+	model := resolveModel(cfg, req)
+	release, err := memGuard.admit(model)
+	if err != nil {
+		log.Printf("[Agent:%s] Refusing task %s: %v", cfg.NodeID, req.TaskID, err)
+		return refusal(req, model, err)
+	}
+	defer release()
+	atomic.AddInt64(&activeTasks, 1)
+	defer atomic.AddInt64(&activeTasks, -1)
+	defer slots.acquire(model)()
+
+	// Stop a little before the orchestrator gives up on us, so what was
+	// generated by then can still be returned
+	genCtx := ctx
+	if req.TimeoutMs > 0 {
+		var cancel context.CancelFunc
+		genCtx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	if req.Type == shared.TaskTypeEmbed {
+		return embedTask(genCtx, cfg, model, req, startedAt)
+	}
+	content, timings, err := generate(genCtx, cfg, model, req)
+	if err != nil && genCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		log.Printf("[Agent:%s] Task %s timed out after %d chars", cfg.NodeID, req.TaskID, len(content))
+		return shared.TaskResult{
 			TaskID:    req.TaskID,
 			Content:   content,
 			ModelUsed: model,
 			TaskType:  req.Type,
 			LatencyMs: time.Since(startedAt).Milliseconds(),
-			Success:   true,
+			Error:     "timeout",
+			Partial:   content != "",
+			Checksum:  shared.ContentChecksum(content),
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(result)
+	}
+	if err != nil {
+		return shared.TaskResult{
+			TaskID:    req.TaskID,
+			ModelUsed: model,
+			TaskType:  req.Type,
+			Success:   false,
+			Error:     err.Error(),
+			ErrorCode: ollamaErrorCode(err),
+		}
+	}
+
+	logTimings(cfg, req.TaskID, timings)
+	return shared.TaskResult{
+		TaskID:    req.TaskID,
+		Content:   content,
+		ModelUsed: model,
+		TaskType:  req.Type,
+		LatencyMs: time.Since(startedAt).Milliseconds(),
+		Success:   true,
+		Timings:   timings,
+		Checksum:  shared.ContentChecksum(content),
 	}
 }
 
@@ -246,102 +654,132 @@ func makeExecuteStreamHandler(cfg Config) http.HandlerFunc {
 		}
 
 		log.Printf("[Agent:%s] Streaming task %s", cfg.NodeID, req.TaskID)
-		atomic.AddInt64(&activeTasks, 1)
-		defer atomic.AddInt64(&activeTasks, -1)
-		model := resolveModel(cfg, req.ModelHint, req.Type)
-
-		w.Header().Set("Content-Type", "application/x-ndjson")
-		w.Header().Set("Transfer-Encoding", "chunked")
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "streaming not supported", 500)
+		// Generation runs on if this connection drops, so a restarted
+		// orchestrator can reattach (see streams.go)
+		s, refused := streams.start(cfg, req, r.Header)
+		if refused != nil {
+			shared.WriteJSON(w, r, http.StatusConflict, *refused, cfg.CompressMinBytes)
 			return
 		}
-
-		err := streamOllama(r.Context(), cfg.OllamaHost, cfg.OllamaPort, model, req.Prompt, func(token string, done bool) {
-			chunk := shared.TaskChunk{
-				TaskID: req.TaskID,
-				Token:  token,
-				Done:   done,
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "%s\n", data)
-			flusher.Flush()
-		})
-
-		if err != nil {
-			log.Printf("[Agent:%s] Stream error: %v", cfg.NodeID, err)
-		}
+		writeChunks(w, r, s)
 	}
 }
 
 // ─── Ollama integration ───────────────────────────────────────────────────────
 
 type ollamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model     string   `json:"model"`
+	Prompt    string   `json:"prompt"`
+	Images    []string `json:"images,omitempty"` // base64, for multimodal models
+	Stream    bool     `json:"stream"`
+	Format    string   `json:"format,omitempty"`     // "json" constrains the output to JSON
+	KeepAlive string   `json:"keep_alive,omitempty"` // how long to keep the model loaded afterwards
+
+	Options map[string]any `json:"options,omitempty"` // the task's generation options, as they are
 }
 
 type ollamaChunk struct {
 	Response string `json:"response"`
 	Done     bool   `json:"done"`
-}
-
-// callOllama sends a prompt to Ollama and returns the full response.
-func callOllama(ctx context.Context, host string, port int, model, prompt string, stream bool) (string, error) {
-	body, _ := json.Marshal(ollamaRequest{Model: model, Prompt: prompt, Stream: false})
-	url := fmt.Sprintf("http://%s:%d/api/generate", host, port)
+	Error    string `json:"error,omitempty"` // Ollama failed mid-stream
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	// Set on the final chunk, in nanoseconds
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalCount          int64 `json:"eval_count"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// generate sends a prompt to the backend and returns the full response. It
+// streams internally so the time to the first token can be measured, and
+// so that on failure the text generated until then is still returned.
+func generate(ctx context.Context, cfg Config, model string, task shared.TaskRequest) (string, *shared.TaskTimings, error) {
+	var content strings.Builder
+	timings, err := streamGenerate(ctx, cfg, model, task, func(token string, done bool, _ *shared.TaskTimings) {
+		content.WriteString(token)
+	})
 	if err != nil {
-		return "", fmt.Errorf("ollama unreachable on :%d — is it running? (%w)", port, err)
+		return content.String(), nil, err
 	}
-	defer resp.Body.Close()
+	return content.String(), timings, nil
+}
 
-	var result ollamaChunk
-	raw, err := io.ReadAll(resp.Body)
+// streamGenerate streams a task's prompt from the configured backend: the
+// llama.cpp server when the agent runs one, otherwise Ollama. The task's
+// files go ahead of the prompt, or beside it for images (see files.go); its
+// format constrains the output (see shared.TaskRequest.Format) and its
+// options tune the sampling; its keep-alive only means something to
+// Ollama, as llama.cpp never unloads.
+func streamGenerate(ctx context.Context, cfg Config, model string, task shared.TaskRequest, onToken func(token string, done bool, timings *shared.TaskTimings)) (*shared.TaskTimings, error) {
+	prompt, images, err := attachFiles(ctx, cfg, task)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if err := json.Unmarshal(raw, &result); err != nil {
-		return "", fmt.Errorf("failed to parse ollama response: %w", err)
+	task.Prompt = prompt
+	if llama != nil {
+		if len(images) > 0 {
+			return nil, fmt.Errorf("the llama.cpp backend can't take image files")
+		}
+		return llama.stream(ctx, task.Prompt, task.Format, task.Options, onToken)
 	}
-	return result.Response, nil
+	return streamOllama(ctx, cfg.OllamaHost, cfg.OllamaPort, model, task, images, onToken)
 }
 
-// streamOllama sends a prompt to Ollama and calls onToken for each streamed token.
-func streamOllama(ctx context.Context, host string, port int, model, prompt string, onToken func(token string, done bool)) error {
-	body, _ := json.Marshal(ollamaRequest{Model: model, Prompt: prompt, Stream: true})
-	url := fmt.Sprintf("http://%s:%d/api/generate", host, port)
+// streamOllama sends a task's prompt, and any images (base64), to Ollama
+// and calls onToken for each streamed token; the final call carries the
+// task's timings, which are also returned.
+func streamOllama(ctx context.Context, host string, port int, model string, task shared.TaskRequest, images []string, onToken func(token string, done bool, timings *shared.TaskTimings)) (*shared.TaskTimings, error) {
+	body, _ := json.Marshal(ollamaRequest{
+		Model:     model,
+		Prompt:    task.Prompt,
+		Images:    images,
+		Stream:    true,
+		Format:    string(task.Format),
+		KeepAlive: task.KeepAlive,
+		Options:   task.Options,
+	})
+	url := shared.BaseURL(host, port) + "/api/generate"
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setBackendHeaders(req)
 
+	sentAt := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("ollama unreachable on :%d (%w)", port, err)
+		return nil, fmt.Errorf("ollama unreachable on %s — is it running? (%w)", ollamaAddr(host, port), err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(msg, &body) == nil && body.Error != "" {
+			msg = []byte(body.Error)
+		}
+		return nil, &ollamaError{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
+	var firstTokenAt time.Time
+	lines := shared.NewLineReader(resp.Body, maxLineBytes)
+	for {
+		line, err := lines.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("ollama stream ended before the response was done")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading ollama stream: %w", err)
+		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
-		line := scanner.Bytes()
 		if len(line) == 0 {
 			continue
 		}
@@ -349,28 +787,100 @@ func streamOllama(ctx context.Context, host string, port int, model, prompt stri
 		if err := json.Unmarshal(line, &chunk); err != nil {
 			continue
 		}
-		onToken(chunk.Response, chunk.Done)
-		if chunk.Done {
-			break
+		if chunk.Error != "" {
+			return nil, &ollamaError{Status: resp.StatusCode, Message: chunk.Error}
+		}
+		if firstTokenAt.IsZero() {
+			firstTokenAt = time.Now()
 		}
+		if !chunk.Done {
+			onToken(chunk.Response, false, nil)
+			continue
+		}
+		timings := splitTimings(chunk, sentAt, firstTokenAt, time.Now())
+		modelUse.touch(model)
+		onToken(chunk.Response, true, timings)
+		return timings, nil
 	}
-	return scanner.Err()
 }
 
-// resolveModel picks the right model for this task.
-// Priority: explicit model_hint > task type match via capabilities > first model
-func resolveModel(cfg Config, hint string, taskType shared.TaskType) string {
-	if hint != "" {
-		return hint
+// ollamaAddr names where Ollama is reached, for logs: ":11434" or the
+// -ollama-host socket ("unix:/run/ollama.sock").
+func ollamaAddr(host string, port int) string {
+	if _, ok := shared.SocketPath(host); ok {
+		return host
 	}
-	// Use capabilities to find the best model for the task type
-	if taskType != shared.TaskTypeAny {
-		if m := shared.BestModelForType(cfg.Capabilities, taskType); m != "" {
-			return m
-		}
+	return fmt.Sprintf(":%d", port)
+}
+
+// ollamaError is an error answered by Ollama itself.
+type ollamaError struct {
+	Status  int
+	Message string
+}
+
+func (e *ollamaError) Error() string {
+	if e.Status == http.StatusOK {
+		return "ollama failed mid-stream: " + e.Message
 	}
-	if len(cfg.Models) > 0 {
-		return cfg.Models[0]
+	return fmt.Sprintf("ollama returned %d: %s", e.Status, e.Message)
+}
+
+// ollamaErrorCode classifies an Ollama failure for the orchestrator's
+// model fallback: a missing model, or one that doesn't fit in memory.
+func ollamaErrorCode(err error) string {
+	var oe *ollamaError
+	if !errors.As(err, &oe) {
+		return ""
+	}
+	msg := strings.ToLower(oe.Message)
+	switch {
+	case oe.Status == http.StatusNotFound || strings.Contains(msg, "not found"):
+		return shared.ErrCodeModelNotFound
+	case strings.Contains(msg, "out of memory") || strings.Contains(msg, "more system memory") ||
+		strings.Contains(msg, "insufficient memory") || strings.Contains(msg, "unable to allocate"):
+		return shared.ErrCodeOOM
+	}
+	return ""
+}
+
+// splitTimings combines wall-clock times with the durations Ollama reports
+// on its final chunk. Queue wait is what's left of the time to first token
+// once model loading and prompt evaluation are taken out.
+func splitTimings(final ollamaChunk, sentAt, firstTokenAt, doneAt time.Time) *shared.TaskTimings {
+	t := &shared.TaskTimings{
+		LoadMs:       final.LoadDuration / int64(time.Millisecond),
+		FirstTokenMs: firstTokenAt.Sub(sentAt).Milliseconds(),
+		GenerationMs: doneAt.Sub(firstTokenAt).Milliseconds(),
+	}
+	t.QueueMs = t.FirstTokenMs - t.LoadMs - final.PromptEvalDuration/int64(time.Millisecond)
+	if t.QueueMs < 0 {
+		t.QueueMs = 0
+	}
+	if final.EvalDuration > 0 {
+		t.TokensPerSec = float64(final.EvalCount) / time.Duration(final.EvalDuration).Seconds()
+	}
+	return t
+}
+
+// logTimings logs where a task's time went on this node.
+func logTimings(cfg Config, taskID string, t *shared.TaskTimings) {
+	if t == nil {
+		return
+	}
+	log.Printf("[Agent:%s] Task %s: queue %dms, load %dms, first token %dms, generation %dms (%.1f tok/s)",
+		cfg.NodeID, taskID, t.QueueMs, t.LoadMs, t.FirstTokenMs, t.GenerationMs, t.TokensPerSec)
+}
+
+// resolveModel picks the right model for this task.
+// Priority: explicit model_hint > task type match via capabilities, models
+// declaring the task's language first > first model, of the task's
+// min_quality tier or larger if it has one (shared.ResolveModel, so the
+// orchestrator can predict it for slot accounting)
+func resolveModel(cfg Config, req shared.TaskRequest) string {
+	caps, models := advertised.current()
+	if m := shared.ResolveModel(caps, models, req.ModelHint, req.Type, req.Language, req.MinQuality); m != "" {
+		return m
 	}
 	return "mistral"
 }
@@ -418,6 +928,81 @@ func parseCapabilities(flag string, models []string) []shared.ModelCapability {
 	return caps
 }
 
+// markExclusive flags the capabilities named in the -exclusive flag.
+func markExclusive(caps []shared.ModelCapability, flag string) {
+	for _, name := range strings.Split(flag, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		found := false
+		for i := range caps {
+			if caps[i].Name == name {
+				caps[i].Exclusive = true
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("[Agent] -exclusive: model %q has no capabilities declared — ignoring", name)
+		}
+	}
+}
+
+// markLanguages records the languages named in the -languages flag
+// ("qwen2:zh,en;mistral:en,fr") on the models' capabilities.
+func markLanguages(caps []shared.ModelCapability, flag string) {
+	for _, entry := range strings.Split(flag, ";") {
+		// Model names may contain ':' (llama3:70b:en); languages never do
+		entry = strings.TrimSpace(entry)
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			continue
+		}
+		name, langs := entry[:sep], entry[sep+1:]
+		found := false
+		for i := range caps {
+			if caps[i].Name != strings.TrimSpace(name) {
+				continue
+			}
+			found = true
+			for _, l := range strings.Split(langs, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					caps[i].Languages = append(caps[i].Languages, l)
+				}
+			}
+		}
+		if !found {
+			log.Printf("[Agent] -languages: model %q has no capabilities declared — ignoring", name)
+		}
+	}
+}
+
+// markTiers records the size classes named in the -tiers flag
+// ("phi3:small,llama3:70b:large") on the models' capabilities.
+func markTiers(caps []shared.ModelCapability, flag string) {
+	for _, entry := range strings.Split(flag, ",") {
+		// Model names may contain ':' (llama3:70b:large); tiers never do
+		entry = strings.TrimSpace(entry)
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			continue
+		}
+		name, tier := strings.TrimSpace(entry[:sep]), shared.QualityTier(strings.TrimSpace(entry[sep+1:]))
+		if !shared.ValidQuality(tier) {
+			log.Fatalf("[Agent] -tiers: unknown tier %q for %s (want small, medium or large)", tier, name)
+		}
+		found := false
+		for i := range caps {
+			if caps[i].Name == name {
+				caps[i].Tier = tier
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("[Agent] -tiers: model %q has no capabilities declared — ignoring", name)
+		}
+	}
+}
+
 // ─── HTTP helper ─────────────────────────────────────────────────────────────
 
 func postJSON(url string, payload any, out any) error {
@@ -425,7 +1010,13 @@ func postJSON(url string, payload any, out any) error {
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	authorize(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -433,10 +1024,17 @@ func postJSON(url string, payload any, out any) error {
 
 	if resp.StatusCode >= 400 {
 		raw, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(raw))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, shared.ProblemMessage(raw))
 	}
 	if out != nil {
 		return json.NewDecoder(resp.Body).Decode(out)
 	}
 	return nil
 }
+
+// authorize adds the session token to a request to the orchestrator.
+func authorize(req *http.Request) {
+	if token, _ := sessionToken.Load().(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
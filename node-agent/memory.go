@@ -0,0 +1,173 @@
+// node-agent/memory.go
+// A hard memory budget for the models running at once.
+//
+// Ollama loads a second model next to a busy one if it thinks both fit,
+// and when its estimate is wrong the generation dies with an out-of-memory
+// error — or the box starts swapping. With -memory-budget (the VRAM, or RAM
+// on a CPU-only box, that models may use) and -model-sizes (what each
+// model takes once loaded) the agent does the arithmetic itself: a task
+// whose model would push the models currently generating past the budget
+// is refused with 409 and error code MEMORY_BUDGET, and the orchestrator
+// routes it to another node. More generations of a model that's already
+// running cost nothing extra. Loaded models that sit idle don't count:
+// Ollama unloads them to make room. A model without a -model-sizes entry
+// is sized by what Ollama's /api/ps said it held when last loaded here;
+// one this agent has never seen loaded isn't guarded.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"echo-system/shared"
+)
+
+// memoryGuard counts the generations running per model against the
+// budget. A nil guard means no budget was declared; its methods admit
+// everything.
+type memoryGuard struct {
+	budget uint64
+	sizes  map[string]uint64 // footprint per model, from -model-sizes
+
+	mu      sync.Mutex
+	running map[string]int // generations currently running per model
+}
+
+// memGuard is the agent's guard; nil unless -memory-budget is set.
+var memGuard *memoryGuard
+
+// parseMemoryGuard parses -memory-budget and -model-sizes
+// ("llama3:70b=40GiB,mistral=5GiB"). An empty budget returns nil.
+func parseMemoryGuard(budgetFlag, sizesFlag string) (*memoryGuard, error) {
+	if strings.TrimSpace(budgetFlag) == "" {
+		if strings.TrimSpace(sizesFlag) != "" {
+			return nil, fmt.Errorf("-model-sizes needs -memory-budget")
+		}
+		return nil, nil
+	}
+	budget, err := parseByteSize(budgetFlag)
+	if err != nil || budget == 0 {
+		return nil, fmt.Errorf("invalid -memory-budget %q", budgetFlag)
+	}
+	g := &memoryGuard{budget: budget, sizes: make(map[string]uint64), running: make(map[string]int)}
+	for _, entry := range strings.Split(sizesFlag, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		model, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid -model-sizes entry %q (want model=size)", entry)
+		}
+		n, err := parseByteSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q for %s", size, model)
+		}
+		g.sizes[strings.TrimSpace(model)] = n
+	}
+	return g, nil
+}
+
+// parseByteSize parses a size like "24GiB", "512M" or "8gb". K, M, G and T
+// are binary multiples with or without "i" and "B"; a bare number is bytes.
+func parseByteSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(s, "KMGTIB")
+	shift := 0
+	switch strings.TrimSuffix(strings.TrimSuffix(s[len(num):], "B"), "I") {
+	case "":
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	case "T":
+		shift = 40
+	default:
+		return 0, fmt.Errorf("unknown unit in %q", s)
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(f * float64(uint64(1)<<shift)), nil
+}
+
+// footprint returns model's declared size, or else the size it was last
+// seen loaded with.
+func (g *memoryGuard) footprint(model string) (uint64, bool) {
+	if n, ok := g.sizes[model]; ok {
+		return n, true
+	}
+	for name, n := range g.sizes {
+		if shared.SameModel(name, model) {
+			return n, true
+		}
+	}
+	return loaded.size(model)
+}
+
+// admit marks a generation of model as running if it fits the budget next
+// to the models already running, and returns the function that releases
+// it. It fails when the model doesn't fit.
+func (g *memoryGuard) admit(model string) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if size, ok := g.footprint(model); ok && g.running[model] == 0 {
+		var used uint64
+		var others []string
+		for m, n := range g.running {
+			if n == 0 || shared.SameModel(m, model) {
+				continue
+			}
+			s, _ := g.footprint(m)
+			used += s
+			others = append(others, fmt.Sprintf("%s (%s)", m, formatBytes(s)))
+		}
+		if used+size > g.budget {
+			sort.Strings(others)
+			alongside := ""
+			if len(others) > 0 {
+				alongside = " alongside " + strings.Join(others, ", ")
+			}
+			return nil, fmt.Errorf("%s (%s) would exceed the memory budget of %s%s",
+				model, formatBytes(size), formatBytes(g.budget), alongside)
+		}
+	}
+	g.running[model]++
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.running[model]--; g.running[model] <= 0 {
+			delete(g.running, model)
+		}
+	}, nil
+}
+
+// refusal is the result of a task refused for the budget.
+func refusal(req shared.TaskRequest, model string, err error) shared.TaskResult {
+	return shared.TaskResult{
+		TaskID:    req.TaskID,
+		ModelUsed: model,
+		TaskType:  req.Type,
+		Error:     err.Error(),
+		ErrorCode: shared.ErrCodeMemoryBudget,
+	}
+}
+
+// formatBytes renders a byte count in GiB/MiB.
+func formatBytes(b uint64) string {
+	const gib, mib = 1 << 30, 1 << 20
+	if b >= gib {
+		return fmt.Sprintf("%.1f GiB", float64(b)/gib)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(b)/mib)
+}
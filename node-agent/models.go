@@ -0,0 +1,268 @@
+// node-agent/models.go
+// GET /models: the models this node's Ollama has, in detail.
+//
+// Besides the names the orchestrator's capability probe needs, each model is
+// described from Ollama's /api/tags (size, family, quantization), /api/show
+// (context length) and /api/ps (loaded, VRAM held), plus when this agent
+// last generated with it. Models declared with -models but not installed
+// are listed as missing — the usual cause of a capability mismatch.
+//
+// The parameter counts Ollama reports also give models without a -tiers
+// entry their size class, which tasks with a min_quality route on.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// ─── GET /models ──────────────────────────────────────────────────────────────
+
+func makeModelsHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var details []shared.ModelDetail
+		if llama != nil {
+			details = llama.describe(r.Context())
+		} else {
+			var err error
+			details, err = describeOllamaModels(r.Context(), cfg.OllamaHost, cfg.OllamaPort)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+
+		resp := shared.ModelListResponse{Models: make([]string, len(details)), Details: details}
+		for i := range details {
+			d := &details[i]
+			resp.Models[i] = d.Name
+			d.LastUsed = modelUse.last(d.Name)
+			for _, m := range cfg.Models {
+				if shared.SameModel(d.Name, m) {
+					d.Declared = true
+				}
+			}
+		}
+		for _, m := range cfg.Models {
+			if !modelInstalled(details, m) {
+				resp.Missing = append(resp.Missing, m)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func modelInstalled(details []shared.ModelDetail, name string) bool {
+	for _, d := range details {
+		if shared.SameModel(d.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ─── Last use ─────────────────────────────────────────────────────────────────
+
+// modelUsage records when each model last finished a generation here.
+type modelUsage struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+var modelUse = &modelUsage{used: make(map[string]time.Time)}
+
+func (u *modelUsage) touch(model string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used[model] = time.Now()
+}
+
+// last returns the unix millis installed (an Ollama name) was last used,
+// or 0. Agents run models by their declared name, which may be untagged.
+func (u *modelUsage) last(installed string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var latest time.Time
+	for model, t := range u.used {
+		if shared.SameModel(installed, model) && t.After(latest) {
+			latest = t
+		}
+	}
+	if latest.IsZero() {
+		return 0
+	}
+	return latest.UnixMilli()
+}
+
+// since lists the models used after t.
+func (u *modelUsage) since(t time.Time) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var models []string
+	for model, used := range u.used {
+		if used.After(t) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// ─── Size classes ─────────────────────────────────────────────────────────────
+
+// paramsRe finds a parameter count in a model name: "llama3:70b",
+// "phi3:3.8b", "llama-2-13b-chat".
+var paramsRe = regexp.MustCompile(`(?i)(?:^|[^a-z0-9.])(\d+(?:\.\d+)?[bm])(?:[^a-z0-9]|$)`)
+
+// inferTiers gives the models without a -tiers entry the tier of their
+// parameter count: as Ollama reports it, if it answers, else as the
+// model's name has it. Models with neither keep no tier, so tasks with a
+// min_quality never run on them.
+func inferTiers(caps []shared.ModelCapability, host string, port int) {
+	var details []shared.ModelDetail
+	if llama == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		details, _ = describeOllamaModels(ctx, host, port)
+		cancel()
+	}
+	for i := range caps {
+		if caps[i].Tier != "" {
+			continue
+		}
+		for _, d := range details {
+			if shared.SameModel(d.Name, caps[i].Name) {
+				caps[i].Tier = shared.TierForParams(d.ParameterSize)
+			}
+		}
+		if m := paramsRe.FindStringSubmatch(caps[i].Name); caps[i].Tier == "" && m != nil {
+			caps[i].Tier = shared.TierForParams(m[1])
+		}
+	}
+}
+
+// ─── Ollama helpers ───────────────────────────────────────────────────────────
+
+// contextLengths caches /api/show answers by model digest: a model's
+// trained context length only changes when the model does.
+var contextLengths sync.Map // digest → int
+
+// describeOllamaModels lists the installed models with their details.
+// Loaded state and context length are best effort: if Ollama can't answer
+// those calls, the fields are left empty.
+func describeOllamaModels(ctx context.Context, host string, port int) ([]shared.ModelDetail, error) {
+	var tags struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			Size       uint64    `json:"size"`
+			Digest     string    `json:"digest"`
+			ModifiedAt time.Time `json:"modified_at"`
+			Details    struct {
+				Format            string `json:"format"`
+				Family            string `json:"family"`
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	if err := ollamaJSON(ctx, host, port, "GET", "/api/tags", nil, &tags); err != nil {
+		return nil, err
+	}
+
+	var ps struct {
+		Models []struct {
+			Name      string    `json:"name"`
+			SizeVRAM  uint64    `json:"size_vram"`
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"models"`
+	}
+	ollamaJSON(ctx, host, port, "GET", "/api/ps", nil, &ps)
+
+	details := make([]shared.ModelDetail, len(tags.Models))
+	for i, m := range tags.Models {
+		d := shared.ModelDetail{
+			Name:          m.Name,
+			SizeBytes:     m.Size,
+			Digest:        m.Digest,
+			Format:        m.Details.Format,
+			Family:        m.Details.Family,
+			ParameterSize: m.Details.ParameterSize,
+			Quantization:  m.Details.QuantizationLevel,
+			ContextLength: ollamaContextLength(ctx, host, port, m.Name, m.Digest),
+		}
+		if !m.ModifiedAt.IsZero() {
+			d.ModifiedAt = m.ModifiedAt.UnixMilli()
+		}
+		for _, p := range ps.Models {
+			if p.Name == m.Name {
+				d.Loaded = true
+				d.VRAMBytes = p.SizeVRAM
+				if !p.ExpiresAt.IsZero() {
+					d.ExpiresAt = p.ExpiresAt.UnixMilli()
+				}
+			}
+		}
+		details[i] = d
+	}
+	return details, nil
+}
+
+// ollamaContextLength reads the model's trained context length from
+// /api/show, whose model_info keys it as "<architecture>.context_length".
+func ollamaContextLength(ctx context.Context, host string, port int, model, digest string) int {
+	if n, ok := contextLengths.Load(digest); ok && digest != "" {
+		return n.(int)
+	}
+	var show struct {
+		ModelInfo map[string]any `json:"model_info"`
+	}
+	if err := ollamaJSON(ctx, host, port, "POST", "/api/show", map[string]string{"model": model}, &show); err != nil {
+		return 0
+	}
+	n := 0
+	for k, v := range show.ModelInfo {
+		if f, ok := v.(float64); ok && strings.HasSuffix(k, ".context_length") {
+			n = int(f)
+		}
+	}
+	if digest != "" {
+		contextLengths.Store(digest, n)
+	}
+	return n
+}
+
+// ollamaJSON calls an Ollama API endpoint and decodes its JSON answer.
+func ollamaJSON(ctx context.Context, host string, port int, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	url := shared.BaseURL(host, port) + path
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama unreachable on :%d (%w)", port, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama %s returned HTTP %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse ollama %s: %w", path, err)
+	}
+	return nil
+}
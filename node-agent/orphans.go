@@ -0,0 +1,196 @@
+// node-agent/orphans.go
+// Pipeline steps that outlive the orchestrator's connection.
+//
+// A pipeline step's task (one sent to /execute with a lineage) runs apart
+// from its request. If the orchestrator goes away before the answer is
+// ready — it crashed, or was restarted halfway through a long pipeline —
+// the task becomes an orphan: it keeps generating for -stream-grace, and
+// what it ends with is kept for orphanRetention. The restarted
+// orchestrator lists orphans with GET /orphans, which also keeps running
+// ones going for another -stream-grace, records the finished ones as its
+// pipelines' steps and claims them with DELETE /orphans/{id} (see the
+// orchestrator's recovery.go). An orphan nobody asks about within the
+// grace period is cancelled.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// orphanRetention is how long a finished orphan waits to be claimed.
+	orphanRetention = 6 * time.Hour
+	// orphanMax caps how many orphans are kept.
+	orphanMax = 256
+)
+
+var orphans = &orphanTable{}
+
+// orphanTable holds the orphaned tasks, oldest first.
+type orphanTable struct {
+	mu    sync.Mutex
+	tasks []*orphan
+}
+
+// orphan is one pipeline step's task run apart from its request.
+type orphan struct {
+	task     shared.OrphanedTask
+	cancel   context.CancelFunc
+	done     chan struct{}
+	result   shared.TaskResult
+	orphaned bool        // its request ended before the result was ready
+	finished bool        // executeTask returned
+	grace    *time.Timer // cancels generation unless the orchestrator asks
+}
+
+// execute runs a pipeline step's task and returns its result. If ctx
+// ends first the task carries on as an orphan and false is returned.
+func (t *orphanTable) execute(ctx context.Context, cfg Config, req shared.TaskRequest) (shared.TaskResult, bool) {
+	genCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	o := &orphan{
+		task: shared.OrphanedTask{
+			TaskID:    req.TaskID,
+			Lineage:   req.Lineage,
+			Status:    shared.OrphanRunning,
+			StartedAt: time.Now().UnixMilli(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer cancel()
+		result := executeTask(genCtx, cfg, req)
+		t.finish(o, result)
+	}()
+
+	select {
+	case <-o.done:
+		return o.result, true
+	case <-ctx.Done():
+	}
+	t.adopt(cfg, o)
+	return shared.TaskResult{}, false
+}
+
+// finish stores a task's result, and keeps it if the task was orphaned.
+func (t *orphanTable) finish(o *orphan, result shared.TaskResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o.result, o.finished = result, true
+	close(o.done)
+	if o.orphaned {
+		o.settle()
+	}
+}
+
+// adopt keeps a task whose request ended before its result was ready.
+func (t *orphanTable) adopt(cfg Config, o *orphan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o.orphaned = true
+	t.expire()
+	if len(t.tasks) >= orphanMax {
+		t.tasks[0].cancel()
+		t.tasks = t.tasks[1:]
+	}
+	t.tasks = append(t.tasks, o)
+	if o.finished {
+		o.settle()
+		return
+	}
+	log.Printf("[Agent:%s] Orchestrator went away during task %s (step %d of pipeline %s) — keeping it",
+		cfg.NodeID, o.task.TaskID, o.task.Lineage.StepIndex+1, o.task.Lineage.PipelineID)
+	o.grace = time.AfterFunc(streamGrace, func() {
+		t.mu.Lock()
+		abandoned := !o.finished
+		t.mu.Unlock()
+		if abandoned {
+			log.Printf("[Agent] Orphaned task %s not asked for within %v — cancelling it", o.task.TaskID, streamGrace)
+			o.cancel()
+		}
+	})
+}
+
+// settle records an orphan's result; call with t.mu held.
+func (o *orphan) settle() {
+	if o.grace != nil {
+		o.grace.Stop()
+	}
+	result := o.result
+	o.task.Result = &result
+	o.task.FinishedAt = time.Now().UnixMilli()
+	o.task.Status = shared.OrphanDone
+	if !result.Success {
+		o.task.Status = shared.OrphanFailed
+	}
+}
+
+// list returns the orphans, giving running ones another grace period.
+func (t *orphanTable) list() []shared.OrphanedTask {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	tasks := make([]shared.OrphanedTask, 0, len(t.tasks))
+	for _, o := range t.tasks {
+		if !o.finished && o.grace != nil {
+			o.grace.Reset(streamGrace)
+		}
+		tasks = append(tasks, o.task)
+	}
+	return tasks
+}
+
+// claim drops an orphan the orchestrator has taken over.
+func (t *orphanTable) claim(taskID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, o := range t.tasks {
+		if o.task.TaskID == taskID {
+			o.cancel()
+			t.tasks = append(t.tasks[:i], t.tasks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// expire drops finished orphans kept longer than orphanRetention; call
+// with t.mu held.
+func (t *orphanTable) expire() {
+	cutoff := time.Now().Add(-orphanRetention).UnixMilli()
+	kept := t.tasks[:0]
+	for _, o := range t.tasks {
+		if !o.finished || o.task.FinishedAt > cutoff {
+			kept = append(kept, o)
+		}
+	}
+	t.tasks = kept
+}
+
+// ─── GET /orphans, DELETE /orphans/{id} ───────────────────────────────────────
+
+func makeOrphansHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared.OrphanList{NodeID: cfg.NodeID, Tasks: orphans.list()})
+	}
+}
+
+func makeClaimOrphanHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !orphans.claim(r.PathValue("id")) {
+			http.Error(w, "no such orphaned task", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Agent:%s] Orphaned task %s claimed", cfg.NodeID, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
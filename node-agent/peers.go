@@ -0,0 +1,139 @@
+// node-agent/peers.go
+// Peer discovery for the orchestrator's topology map.
+//
+// The agent advertises itself over mDNS as "_echo-node._tcp" (instance
+// name and node_id TXT record = its node ID) and browses for the other
+// agents every peerScanInterval, timing peerDials TCP connects to each.
+// Heartbeats carry the latest list; the orchestrator combines them into
+// GET /topology.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+
+	"echo-system/shared"
+)
+
+const (
+	peerServiceName = "_echo-node._tcp"
+
+	// peerScanInterval is how often the agent browses for peers.
+	peerScanInterval = 30 * time.Second
+	// peerDials is how many TCP connects measure a peer's RTT and loss;
+	// peerDialTimeout bounds each.
+	peerDials       = 3
+	peerDialTimeout = 2 * time.Second
+)
+
+var (
+	peersMu sync.RWMutex
+	peers   []shared.PeerLink // latest scan; nil while discovery is off
+)
+
+// startPeerDiscovery advertises the agent and starts browsing for peers.
+// Returns a cleanup function that stops the advertisement.
+func startPeerDiscovery(cfg Config) (func(), error) {
+	ip := net.ParseIP(cfg.AgentHost)
+	if ip == nil {
+		return nil, fmt.Errorf("agent host %q isn't an IP address", cfg.AgentHost)
+	}
+	service, err := mdns.NewMDNSService(
+		cfg.NodeID,      // instance name
+		peerServiceName, // service type
+		mdnsDomain+".",  // domain
+		"",              // host name (empty = use OS hostname)
+		cfg.AgentPort,   // port
+		[]net.IP{ip},    // IPs to advertise
+		[]string{"node_id=" + cfg.NodeID, fmt.Sprintf("api=%d", shared.MeshAPIVersion)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("mdns service creation failed: %w", err)
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return nil, fmt.Errorf("mdns server start failed: %w", err)
+	}
+	log.Printf("[Peers] Advertising %s as %s on %s", cfg.NodeID, peerServiceName, net.JoinHostPort(cfg.AgentHost, strconv.Itoa(cfg.AgentPort)))
+
+	peersMu.Lock()
+	peers = []shared.PeerLink{}
+	peersMu.Unlock()
+	go peerLoop(cfg.NodeID)
+
+	return func() { server.Shutdown() }, nil
+}
+
+// peerLoop scans for peers immediately and then periodically.
+func peerLoop(self string) {
+	for {
+		found := scanPeers(self)
+		peersMu.Lock()
+		peers = found
+		peersMu.Unlock()
+		time.Sleep(peerScanInterval)
+	}
+}
+
+// scanPeers browses mDNS for other agents and times connects to each.
+func scanPeers(self string) []shared.PeerLink {
+	// Lookup never blocks on the channel; it returns when its query ends
+	entriesCh := make(chan *mdns.ServiceEntry, 64)
+	if err := mdns.Lookup(peerServiceName, entriesCh); err != nil {
+		log.Printf("[Peers] mDNS lookup failed: %v", err)
+	}
+	close(entriesCh)
+
+	found := []shared.PeerLink{}
+	byID := make(map[string]bool)
+	for entry := range entriesCh {
+		id := parseTXT(entry.InfoFields)["node_id"]
+		ip := entry.AddrV4
+		if ip == nil {
+			ip = entry.Addr
+		}
+		if id == "" || id == self || byID[id] || ip == nil {
+			continue
+		}
+		byID[id] = true
+
+		found = append(found, dialPeer(id, net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port))))
+	}
+	return found
+}
+
+// dialPeer times peerDials connects to a peer: the median of those that
+// succeeded, and the share that failed.
+func dialPeer(id, addr string) shared.PeerLink {
+	peer := shared.PeerLink{NodeID: id, Addr: addr}
+	var rtts []float64
+	for i := 0; i < peerDials; i++ {
+		start := time.Now()
+		if conn, err := net.DialTimeout("tcp", addr, peerDialTimeout); err == nil {
+			rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+			conn.Close()
+		}
+	}
+	if len(rtts) > 0 {
+		slices.Sort(rtts)
+		peer.RTTMs = rtts[len(rtts)/2]
+		peer.Reachable = true
+	}
+	peer.Loss = float64(peerDials-len(rtts)) / peerDials
+	return peer
+}
+
+// currentPeers returns the latest scan for heartbeats (nil = discovery off).
+func currentPeers() []shared.PeerLink {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	return peers
+}
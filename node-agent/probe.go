@@ -0,0 +1,88 @@
+// node-agent/probe.go
+// Endpoints the orchestrator uses to verify this agent's declared
+// capabilities at registration time: list the models Ollama really has
+// (GET /models, see models.go), and run a 1-token generation to prove a
+// model actually loads.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// probeTimeout bounds a single probe generation. Loading a large model from
+// disk on a cold node can take a while, so be generous.
+const probeTimeout = 2 * time.Minute
+
+// ─── POST /probe ──────────────────────────────────────────────────────────────
+
+func makeProbeHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req shared.ProbeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		startedAt := time.Now()
+		result := shared.ProbeResult{Model: req.Model, OK: true}
+		probe := func() error { return probeOllama(ctx, cfg.OllamaHost, cfg.OllamaPort, req.Model) }
+		if llama != nil {
+			probe = func() error { return llama.probe(ctx) }
+		}
+		if err := probe(); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+		}
+		result.LatencyMs = time.Since(startedAt).Milliseconds()
+		log.Printf("[Agent:%s] Probe %s ok=%v (%dms)", cfg.NodeID, req.Model, result.OK, result.LatencyMs)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// ─── Ollama helpers ───────────────────────────────────────────────────────────
+
+// probeOllama runs a 1-token generation. Ollama answers a missing or broken
+// model with a non-200 status and an {"error": ...} body.
+func probeOllama(ctx context.Context, host string, port int, model string) error {
+	body, _ := json.Marshal(map[string]any{
+		"model":   model,
+		"prompt":  "hi",
+		"stream":  false,
+		"options": map[string]any{"num_predict": 1},
+	})
+	url := shared.BaseURL(host, port) + "/api/generate"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama unreachable on :%d (%w)", port, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+	return nil
+}
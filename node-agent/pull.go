@@ -0,0 +1,91 @@
+// node-agent/pull.go
+// POST /pull — the orchestrator asks this agent to pull a model into its
+// local Ollama after checking that it fits. Once pulled the model is
+// advertised, for the task types the request named (see capabilities.go).
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// pullTimeout bounds a single model download. Multi-GB models on a slow
+// link take a while.
+const pullTimeout = 60 * time.Minute
+
+func makePullHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req shared.PullRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), pullTimeout)
+		defer cancel()
+
+		log.Printf("[Agent:%s] Pulling model %s", cfg.NodeID, req.Model)
+		startedAt := time.Now()
+		result := shared.PullResult{NodeID: cfg.NodeID, Model: req.Model, Success: true}
+		if llama != nil {
+			result.Success = false
+			result.Error = "the llama.cpp backend serves a single GGUF file and can't pull models"
+		} else if err := pullOllama(ctx, cfg.OllamaHost, cfg.OllamaPort, req.Model); err != nil {
+			result.Success = false
+			result.Error = err.Error()
+		}
+		result.LatencyMs = time.Since(startedAt).Milliseconds()
+		log.Printf("[Agent:%s] Pull %s success=%v (%dms)", cfg.NodeID, req.Model, result.Success, result.LatencyMs)
+
+		// Disk space just changed — don't wait for the next sample
+		go sampleResources(cfg.ModelsDir)
+		// Routable here from the next heartbeat, sent right away
+		if result.Success {
+			advertised.pulled(req.Model, req.Types)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// pullOllama downloads a model via Ollama's /api/pull and waits for it.
+func pullOllama(ctx context.Context, host string, port int, model string) error {
+	body, _ := json.Marshal(map[string]any{"model": model, "stream": false})
+	url := shared.BaseURL(host, port) + "/api/pull"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama unreachable on :%d (%w)", port, err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+	var status struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err == nil && status.Error != "" {
+		return fmt.Errorf("ollama pull failed: %s", status.Error)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// node-agent/resend.go
+// Answering the orchestrator again without generating again.
+//
+// Every result carries the checksum of its content. When a reply reaches
+// the orchestrator cut short or with content that doesn't match it, the
+// orchestrator POSTs the task to /execute?resend=1 once more. The agent
+// keeps the results it has returned in the last few minutes, so it can
+// send the same one again rather than re-running the task; only when it
+// no longer has it (restarted, or the result aged out) does it generate
+// anew.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// resendKeep is how long a returned result is kept for a resend.
+	resendKeep = 2 * time.Minute
+	// resendMax caps how many results are kept.
+	resendMax = 64
+)
+
+type sentResult struct {
+	result shared.TaskResult
+	at     time.Time
+}
+
+// sentResults are the results /execute returned lately, oldest first.
+type sentResults struct {
+	mu      sync.Mutex
+	results []sentResult
+}
+
+var sent = &sentResults{}
+
+// add keeps a returned result. Failures aren't kept: a resend of one
+// should try the task again.
+func (s *sentResults) add(result shared.TaskResult) {
+	if result.TaskID == "" || (!result.Success && !result.Partial) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if len(s.results) >= resendMax {
+		s.results = s.results[1:]
+	}
+	s.results = append(s.results, sentResult{result: result, at: time.Now()})
+}
+
+// get returns the result returned for a task, if it's still kept.
+func (s *sentResults) get(taskID string) (shared.TaskResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for i := len(s.results) - 1; i >= 0; i-- {
+		if s.results[i].result.TaskID == taskID {
+			return s.results[i].result, true
+		}
+	}
+	return shared.TaskResult{}, false
+}
+
+// expire drops results older than resendKeep; call with s.mu held.
+func (s *sentResults) expire() {
+	n := 0
+	for n < len(s.results) && time.Since(s.results[n].at) > resendKeep {
+		n++
+	}
+	s.results = s.results[n:]
+}
@@ -0,0 +1,125 @@
+// node-agent/resources.go
+// Reports free disk space on the Ollama models volume and free GPU memory
+// so the orchestrator can refuse model pulls that won't fit. The GPU
+// temperature read alongside feeds the thermal monitor (thermal.go).
+//
+// Sampling shells out to nvidia-smi, so it runs on its own slower ticker and
+// heartbeats just send the latest snapshot.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// resourceSampleInterval is how often disk and VRAM are re-measured.
+const resourceSampleInterval = 15 * time.Second
+
+var (
+	resourcesMu sync.RWMutex
+	resources   *shared.Resources // latest snapshot, nil until the first sample
+)
+
+// defaultModelsDir mirrors Ollama's own lookup: $OLLAMA_MODELS, else ~/.ollama/models.
+func defaultModelsDir() string {
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".ollama", "models")
+}
+
+// resourceLoop samples resources immediately and then periodically.
+func resourceLoop(modelsDir string) {
+	sampleResources(modelsDir)
+	ticker := time.NewTicker(resourceSampleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sampleResources(modelsDir)
+	}
+}
+
+// existingDir returns dir, or its nearest existing parent: the models dir
+// may not exist yet on a fresh install, and the parent lives on the same
+// volume.
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func sampleResources(modelsDir string) {
+	var res shared.Resources
+
+	dir := existingDir(modelsDir)
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		log.Printf("[Agent] Disk space check on %s failed: %v", dir, err)
+	} else {
+		res.DiskFreeBytes, res.DiskTotalBytes = free, total
+	}
+
+	var gpuTemp float64
+	res.VRAMFreeBytes, res.VRAMTotalBytes, gpuTemp = gpuStats()
+	thermal.observeGPU(gpuTemp)
+
+	resourcesMu.Lock()
+	resources = &res
+	resourcesMu.Unlock()
+}
+
+// currentResources returns the latest snapshot (nil before the first sample).
+func currentResources() *shared.Resources {
+	resourcesMu.RLock()
+	defer resourcesMu.RUnlock()
+	return resources
+}
+
+// gpuStats sums free/total memory across NVIDIA GPUs via nvidia-smi and
+// returns the hottest GPU's temperature (°C). Returns zeros when there's
+// no GPU or the tool isn't installed.
+func gpuStats() (free, total uint64, tempC float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=memory.free,memory.total,temperature.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, 0, 0
+	}
+	const mib = 1 << 20
+	for _, line := range strings.Split(string(bytes.TrimSpace(out)), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			continue
+		}
+		f, err1 := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		t, err2 := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		free += f * mib
+		total += t * mib
+		if c, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64); err == nil {
+			tempC = max(tempC, c)
+		}
+	}
+	return free, total, tempC
+}
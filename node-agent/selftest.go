@@ -0,0 +1,205 @@
+// node-agent/selftest.go
+// POST /selftest: an end-to-end smoke test of this node.
+//
+// When a node misbehaves, the first questions are always the same: does
+// the backend answer, do the models load, is the disk full, is the clock
+// right? The self-test answers them in one call, with a structured report
+// of one check each:
+//
+//	backend  Ollama's model list (or llama.cpp's /health) answers
+//	model    a 1-token generation per model, one at a time so they don't
+//	         compete for memory; models Ollama doesn't have fail at once
+//	disk     free space on the models volume: warn under 10%, fail under 1 GiB
+//	clock    set at all, and within max_skew_ms of the caller's reference_ms
+//
+// It runs locally (curl -X POST localhost:9001/selftest) or remotely
+// through the orchestrator's POST /admin/nodes/{id}/selftest, which sends
+// its own clock as the reference. The report's ok is false if any check
+// failed; warnings don't count.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	selfTestMinDiskBytes = 1 << 30 // free space below which the disk check fails
+	selfTestLowDisk      = 0.10    // free fraction below which it warns
+	selfTestMaxSkew      = 2 * time.Second
+)
+
+// ─── POST /selftest ───────────────────────────────────────────────────────────
+
+func makeSelfTestHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req shared.SelfTestRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		report := runSelfTest(r.Context(), cfg, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// runSelfTest runs every check and reports them in order.
+func runSelfTest(ctx context.Context, cfg Config, req shared.SelfTestRequest) shared.SelfTestReport {
+	startedAt := time.Now()
+	report := shared.SelfTestReport{
+		NodeID:    cfg.NodeID,
+		Backend:   backendOllama,
+		Version:   shared.Version,
+		StartedAt: startedAt.UnixMilli(),
+	}
+	if llama != nil {
+		report.Backend = backendLlamaCpp
+	}
+
+	// The clock first, before the generations make reference_ms stale
+	report.Checks = append(report.Checks, selfTestClock(startedAt, req))
+
+	models := req.Models
+	if len(models) == 0 {
+		_, models = advertised.current()
+	}
+	backend, details := selfTestBackend(ctx, cfg)
+	report.Checks = append(report.Checks, backend)
+	for _, model := range models {
+		report.Checks = append(report.Checks, selfTestModel(ctx, cfg, model, backend.Status == shared.SelfTestOK, details))
+	}
+	report.Checks = append(report.Checks, selfTestDisk(cfg.ModelsDir))
+
+	report.OK = true
+	failed := 0
+	for _, c := range report.Checks {
+		if c.Status == shared.SelfTestFail {
+			report.OK = false
+			failed++
+		}
+	}
+	report.DurationMs = time.Since(startedAt).Milliseconds()
+	log.Printf("[Agent:%s] Self-test: %d checks, %d failed (%dms)", cfg.NodeID, len(report.Checks), failed, report.DurationMs)
+	return report
+}
+
+// ─── Checks ───────────────────────────────────────────────────────────────────
+
+// selfTestBackend checks that the backend answers, returning the models
+// Ollama has (nil for llama.cpp, which serves its one model).
+func selfTestBackend(ctx context.Context, cfg Config) (shared.SelfTestCheck, []shared.ModelDetail) {
+	check := shared.SelfTestCheck{Check: "backend", Status: shared.SelfTestOK}
+	ctx, cancel := context.WithTimeout(ctx, watchdogTimeout)
+	defer cancel()
+	sent := time.Now()
+
+	if llama != nil {
+		err := llama.health(ctx)
+		check.LatencyMs = time.Since(sent).Milliseconds()
+		if err != nil {
+			check.Status, check.Message = shared.SelfTestFail, "llama.cpp server: "+err.Error()
+		} else {
+			check.Message = "llama.cpp server is healthy"
+		}
+		return check, nil
+	}
+	details, err := describeOllamaModels(ctx, cfg.OllamaHost, cfg.OllamaPort)
+	check.LatencyMs = time.Since(sent).Milliseconds()
+	if err != nil {
+		check.Status, check.Message = shared.SelfTestFail, err.Error()
+		return check, nil
+	}
+	check.Message = fmt.Sprintf("ollama answers with %d installed models", len(details))
+	return check, details
+}
+
+// selfTestModel runs a 1-token generation on model, unless the backend is
+// down or doesn't have it.
+func selfTestModel(ctx context.Context, cfg Config, model string, backendUp bool, details []shared.ModelDetail) shared.SelfTestCheck {
+	check := shared.SelfTestCheck{Check: "model", Model: model, Status: shared.SelfTestOK}
+	if !backendUp {
+		check.Status, check.Message = shared.SelfTestSkip, "not tried: the backend is down"
+		return check
+	}
+	if llama == nil {
+		if _, ok := findDetail(details, model); !ok {
+			check.Status, check.Message = shared.SelfTestFail, "not installed in ollama"
+			return check
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	sent := time.Now()
+	var err error
+	if llama != nil {
+		err = llama.probe(ctx)
+	} else {
+		err = probeOllama(ctx, cfg.OllamaHost, cfg.OllamaPort, model)
+	}
+	check.LatencyMs = time.Since(sent).Milliseconds()
+	if err != nil {
+		check.Status, check.Message = shared.SelfTestFail, err.Error()
+	} else {
+		check.Message = "generated a token"
+	}
+	return check
+}
+
+// selfTestDisk checks the free space on the models volume.
+func selfTestDisk(modelsDir string) shared.SelfTestCheck {
+	check := shared.SelfTestCheck{Check: "disk", Status: shared.SelfTestOK}
+	if modelsDir == "" {
+		check.Status, check.Message = shared.SelfTestSkip, "no models directory known"
+		return check
+	}
+	dir := existingDir(modelsDir)
+	free, total, err := diskSpace(dir)
+	switch {
+	case err != nil:
+		check.Status, check.Message = shared.SelfTestWarn, fmt.Sprintf("can't read free space on %s: %v", dir, err)
+	case free < selfTestMinDiskBytes:
+		check.Status, check.Message = shared.SelfTestFail, fmt.Sprintf("%s: only %.1f GiB free", dir, gib(free))
+	case total > 0 && float64(free)/float64(total) < selfTestLowDisk:
+		check.Status, check.Message = shared.SelfTestWarn, fmt.Sprintf("%s: %.1f of %.1f GiB free", dir, gib(free), gib(total))
+	default:
+		check.Message = fmt.Sprintf("%s: %.1f of %.1f GiB free", dir, gib(free), gib(total))
+	}
+	return check
+}
+
+// selfTestClock checks that the clock was set, and agrees with the
+// caller's if it sent one.
+func selfTestClock(now time.Time, req shared.SelfTestRequest) shared.SelfTestCheck {
+	check := shared.SelfTestCheck{Check: "clock", Status: shared.SelfTestOK, Message: now.UTC().Format(time.RFC3339)}
+	if now.Year() < 2024 {
+		check.Status, check.Message = shared.SelfTestFail, "the system clock says "+now.Format(time.RFC3339)+"; enable NTP"
+		return check
+	}
+	if req.ReferenceMs == 0 {
+		return check
+	}
+	maxSkew := selfTestMaxSkew
+	if req.MaxSkewMs > 0 {
+		maxSkew = time.Duration(req.MaxSkewMs) * time.Millisecond
+	}
+	skew := time.Duration(now.UnixMilli()-req.ReferenceMs) * time.Millisecond
+	if skew > maxSkew || skew < -maxSkew {
+		check.Status, check.Message = shared.SelfTestWarn, fmt.Sprintf("%s off the caller's clock, more than %s; enable NTP", skew.Round(time.Millisecond), maxSkew)
+	} else {
+		check.Message += fmt.Sprintf(", %s off the caller's clock", skew.Round(time.Millisecond))
+	}
+	return check
+}
+
+func gib(bytes uint64) float64 { return float64(bytes) / (1 << 30) }
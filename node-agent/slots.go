@@ -0,0 +1,132 @@
+// node-agent/slots.go
+// Parallel request slots per model.
+//
+// Ollama can run several generations of one model at once
+// (OLLAMA_NUM_PARALLEL). An agent declares that parallelism with -parallel
+// and reports free slots per model in every heartbeat, so the orchestrator
+// can keep a dual-GPU box serving 4-8 concurrent generations instead of
+// marking it busy at the node-wide -busy-threshold.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"echo-system/shared"
+)
+
+// slotTracker counts in-flight generations per model against the declared
+// slots. A nil tracker means no slots were declared; its methods are no-ops.
+type slotTracker struct {
+	mu     sync.Mutex
+	models []string       // declaration order, for stable reports
+	total  map[string]int // declared slots per model
+	inUse  map[string]int // generations currently running per model
+}
+
+// slots is the agent's tracker; nil unless -parallel or OLLAMA_NUM_PARALLEL is set.
+var slots *slotTracker
+
+// parseSlots parses the -parallel flag: either one number applied to every
+// model ("4") or per-model counts ("mistral:4,codellama:2"). An empty flag
+// falls back to OLLAMA_NUM_PARALLEL; if that's unset too, nil is returned.
+func parseSlots(flag string, models []string) (*slotTracker, error) {
+	flag = strings.TrimSpace(flag)
+	if flag == "" {
+		flag = strings.TrimSpace(os.Getenv("OLLAMA_NUM_PARALLEL"))
+	}
+	if flag == "" {
+		return nil, nil
+	}
+
+	t := &slotTracker{total: make(map[string]int), inUse: make(map[string]int)}
+	add := func(model, count string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid slot count %q for %s", count, model)
+		}
+		if _, dup := t.total[model]; !dup {
+			t.models = append(t.models, model)
+		}
+		t.total[model] = n
+		return nil
+	}
+
+	if !strings.Contains(flag, ":") {
+		for _, m := range models {
+			if m = strings.TrimSpace(m); m != "" {
+				if err := add(m, flag); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return t, nil
+	}
+	for _, entry := range strings.Split(flag, ",") {
+		model, count, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid -parallel entry %q (want model:count)", entry)
+		}
+		if err := add(strings.TrimSpace(model), count); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// acquire marks a generation of model as running and returns the function
+// that releases it. Running past the declared slots is allowed — Ollama
+// queues the excess — it just reports zero free.
+func (t *slotTracker) acquire(model string) func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.inUse[model]++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.inUse[model]--
+		t.mu.Unlock()
+	}
+}
+
+// report returns the current free slots per declared model. A node
+// shedding load for its temperature advertises half its slots (rounded
+// up), or none free when it's hot.
+func (t *slotTracker) report() []shared.ModelSlots {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	shed := thermal.current()
+	list := make([]shared.ModelSlots, 0, len(t.models))
+	for _, m := range t.models {
+		total := t.total[m]
+		if shed == shared.ThermalThrottled {
+			total = (total + 1) / 2
+		}
+		free := total - t.inUse[m]
+		if free < 0 || shed == shared.ThermalHot {
+			free = 0
+		}
+		list = append(list, shared.ModelSlots{Model: m, Total: total, Free: free})
+	}
+	return list
+}
+
+// full reports whether every declared slot is taken.
+func (t *slotTracker) full() bool {
+	for _, s := range t.report() {
+		if s.Free > 0 {
+			return false
+		}
+	}
+	return true
+}
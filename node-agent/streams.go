@@ -0,0 +1,225 @@
+// node-agent/streams.go
+// Streamed generations that outlive the orchestrator's connection.
+//
+// A streamed task generates into a transcript here rather than straight
+// into the /execute/stream response, which only follows it. If the
+// orchestrator goes away mid-stream (a crash or restart), generation keeps
+// going for -stream-grace; an orchestrator that comes back reattaches with
+// GET /execute/stream/{id} and is sent the transcript so far, then the live
+// tokens. With nobody reattached by then the generation is cancelled. Finished transcripts are kept for streamRetention, so a
+// task that finished while the orchestrator was down can still be fetched.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+// streamGrace is how long a stream nobody follows keeps generating; set
+// from -stream-grace.
+var streamGrace = 30 * time.Second
+
+// streamRetention is how long a finished transcript is kept.
+const streamRetention = 5 * time.Minute
+
+var streams = &streamTable{streams: make(map[string]*taskStream)}
+
+// streamTable holds the streamed tasks running or recently finished.
+type streamTable struct {
+	mu      sync.Mutex
+	streams map[string]*taskStream
+}
+
+// taskStream is one streamed task's transcript.
+type taskStream struct {
+	taskID string
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	text      []byte
+	final     *shared.TaskChunk // the done chunk, once generation succeeded
+	finished  bool              // generation ended, with final or failed
+	wake      chan struct{}     // closed and replaced on every change
+	followers int
+	orphaned  *time.Timer // cancels generation once nobody follows
+}
+
+// start runs a task's generation in the background and registers its
+// transcript; header holds the headers it came with. A task ID seen
+// before replaces the older stream. A task whose model doesn't fit the
+// memory budget isn't started; the refusal is returned instead.
+func (t *streamTable) start(cfg Config, req shared.TaskRequest, header http.Header) (*taskStream, *shared.TaskResult) {
+	model := resolveModel(cfg, req)
+	release, err := memGuard.admit(model)
+	if err != nil {
+		log.Printf("[Agent:%s] Refusing stream %s: %v", cfg.NodeID, req.TaskID, err)
+		result := refusal(req, model, err)
+		return nil, &result
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if req.TimeoutMs > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(req.TimeoutMs)*time.Millisecond)
+	}
+	ctx = withPassedHeaders(ctx, header)
+	s := &taskStream{taskID: req.TaskID, cancel: cancel, wake: make(chan struct{})}
+	t.mu.Lock()
+	t.streams[req.TaskID] = s
+	t.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		defer release()
+		atomic.AddInt64(&activeTasks, 1)
+		defer atomic.AddInt64(&activeTasks, -1)
+		defer slots.acquire(model)()
+
+		timings, err := streamGenerate(ctx, cfg, model, req, func(token string, done bool, timings *shared.TaskTimings) {
+			s.append(token)
+		})
+		if err != nil {
+			log.Printf("[Agent:%s] Stream error: %v", cfg.NodeID, err)
+		} else {
+			logTimings(cfg, req.TaskID, timings)
+		}
+		s.finish(timings, err)
+		time.AfterFunc(streamRetention, func() { t.remove(s) })
+	}()
+	return s, nil
+}
+
+// get returns a running or recently finished stream.
+func (t *streamTable) get(taskID string) (*taskStream, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.streams[taskID]
+	return s, ok
+}
+
+func (t *streamTable) remove(s *taskStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.streams[s.taskID] == s {
+		delete(t.streams, s.taskID)
+	}
+}
+
+// changed wakes followers; call with s.mu held.
+func (s *taskStream) changed() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (s *taskStream) append(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text = append(s.text, token...)
+	s.changed()
+}
+
+func (s *taskStream) finish(timings *shared.TaskTimings, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.final = &shared.TaskChunk{TaskID: s.taskID, Done: true, Timings: timings}
+	}
+	s.finished = true
+	if s.orphaned != nil {
+		s.orphaned.Stop()
+	}
+	s.changed()
+}
+
+// follow sends the transcript so far as one chunk, then each new token,
+// until the stream finishes or ctx ends. A failed generation ends without
+// a done chunk, as the orchestrator expects.
+func (s *taskStream) follow(ctx context.Context, emit func(shared.TaskChunk)) {
+	s.mu.Lock()
+	s.followers++
+	if s.orphaned != nil {
+		s.orphaned.Stop()
+	}
+	s.mu.Unlock()
+	defer s.unfollow()
+
+	offset := 0
+	for {
+		s.mu.Lock()
+		text, final, finished, wake := s.text, s.final, s.finished, s.wake
+		s.mu.Unlock()
+		if offset < len(text) {
+			emit(shared.TaskChunk{TaskID: s.taskID, Token: string(text[offset:])})
+			offset = len(text)
+		}
+		if finished {
+			if final != nil {
+				emit(*final)
+			}
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unfollow drops a follower; the last one leaving a running stream starts
+// the grace period after which it's cancelled.
+func (s *taskStream) unfollow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.followers--
+	if s.followers > 0 || s.finished {
+		return
+	}
+	s.orphaned = time.AfterFunc(streamGrace, func() {
+		s.mu.Lock()
+		abandoned := s.followers == 0 && !s.finished
+		s.mu.Unlock()
+		if abandoned {
+			log.Printf("[Agent] Stream %s not reattached within %v — cancelling it", s.taskID, streamGrace)
+			s.cancel()
+		}
+	})
+}
+
+// writeChunks follows s into an NDJSON response.
+func writeChunks(w http.ResponseWriter, r *http.Request, s *taskStream) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	s.follow(r.Context(), func(chunk shared.TaskChunk) {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "%s\n", data)
+		flusher.Flush()
+	})
+}
+
+// ─── Reattach: GET /execute/stream/{id} ───────────────────────────────────────
+
+func makeReattachStreamHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := streams.get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "no such stream", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Agent:%s] Reattaching stream %s", cfg.NodeID, s.taskID)
+		writeChunks(w, r, s)
+	}
+}
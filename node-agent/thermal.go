@@ -0,0 +1,134 @@
+// node-agent/thermal.go
+// Temperature-based load shedding.
+//
+// A fanless mini-PC that keeps taking tasks heats up until the CPU
+// throttles itself and every generation crawls. The agent samples its CPU
+// sensors (and the GPU temperature nvidia-smi reports with the resource
+// sample) and sheds load before that happens: above -thermal-throttle it
+// advertises half its capacity — half its declared slots, and the
+// orchestrator halves its busy threshold — and above -thermal-busy it
+// reports busy with no free slots, so tasks go elsewhere while any other
+// node can take them. A state is left only once the temperature drops
+// thermalHysteresis below its threshold, so a node hovering at the limit
+// doesn't flap.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// thermalSampleInterval is how often the CPU sensors are read.
+	thermalSampleInterval = 5 * time.Second
+
+	// thermalHysteresis is how far (°C) below a threshold the temperature
+	// must drop to leave its state.
+	thermalHysteresis = 5.0
+)
+
+// thermalMonitor turns temperature readings into a ThermalState. A nil
+// monitor means shedding is off; its methods are no-ops.
+type thermalMonitor struct {
+	throttleAt float64 // °C; 0 = never throttle
+	busyAt     float64 // °C; 0 = never report busy
+
+	mu      sync.Mutex
+	cpuTemp float64
+	gpuTemp float64
+	state   shared.ThermalState
+}
+
+// thermal is the agent's monitor; nil when both thresholds are 0.
+var thermal *thermalMonitor
+
+// newThermalMonitor returns a monitor for the thresholds, nil if both are off.
+func newThermalMonitor(throttleAt, busyAt float64) *thermalMonitor {
+	if throttleAt <= 0 && busyAt <= 0 {
+		return nil
+	}
+	return &thermalMonitor{throttleAt: throttleAt, busyAt: busyAt, state: shared.ThermalNormal}
+}
+
+// thermalLoop samples the CPU sensors immediately and then periodically.
+func thermalLoop() {
+	if thermal == nil {
+		return
+	}
+	for {
+		thermal.observe(cpuTemperature(), -1)
+		time.Sleep(thermalSampleInterval)
+	}
+}
+
+// observeGPU records the GPU temperature from a resource sample.
+func (m *thermalMonitor) observeGPU(tempC float64) {
+	if m == nil {
+		return
+	}
+	m.observe(-1, tempC)
+}
+
+// observe records new readings (negative = unchanged) and updates the state.
+func (m *thermalMonitor) observe(cpuC, gpuC float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cpuC >= 0 {
+		m.cpuTemp = cpuC
+	}
+	if gpuC >= 0 {
+		m.gpuTemp = gpuC
+	}
+	temp := max(m.cpuTemp, m.gpuTemp)
+
+	// Each threshold is entered at its value and left thermalHysteresis
+	// below it
+	above := func(threshold float64, in bool) bool {
+		if threshold <= 0 {
+			return false
+		}
+		if in {
+			return temp > threshold-thermalHysteresis
+		}
+		return temp >= threshold
+	}
+	next := shared.ThermalNormal
+	switch {
+	case above(m.busyAt, m.state == shared.ThermalHot):
+		next = shared.ThermalHot
+	case above(m.throttleAt, m.state != shared.ThermalNormal):
+		next = shared.ThermalThrottled
+	}
+	if next != m.state {
+		log.Printf("[Thermal] %.0f°C — %s → %s", temp, m.state, next)
+		m.state = next
+	}
+}
+
+// current returns the shedding state (normal when the monitor is off).
+func (m *thermalMonitor) current() shared.ThermalState {
+	if m == nil {
+		return shared.ThermalNormal
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// report returns the readings for heartbeats; nil when the monitor is off
+// or no sensor has been read.
+func (m *thermalMonitor) report() *shared.Thermal {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cpuTemp == 0 && m.gpuTemp == 0 {
+		return nil
+	}
+	return &shared.Thermal{CPUTempC: m.cpuTemp, GPUTempC: m.gpuTemp, State: m.state}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+// node-agent/thermal_linux.go
+// CPU temperature from sysfs on Linux.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cpuTemperature returns the hottest reading (°C) of the kernel's thermal
+// zones and CPU hwmon sensors (coretemp, k10temp, ...), 0 if there are none.
+func cpuTemperature() float64 {
+	files, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	hwmons, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	for _, dir := range hwmons {
+		name, _ := os.ReadFile(filepath.Join(dir, "name"))
+		switch strings.TrimSpace(string(name)) {
+		case "coretemp", "k10temp", "zenpower", "cpu_thermal", "soc_thermal":
+			inputs, _ := filepath.Glob(filepath.Join(dir, "temp*_input"))
+			files = append(files, inputs...)
+		}
+	}
+
+	hottest := 0.0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		// Disabled zones read negative or absurd values
+		if c := float64(milli) / 1000; err == nil && c > 0 && c < 150 {
+			hottest = max(hottest, c)
+		}
+	}
+	return hottest
+}
@@ -0,0 +1,140 @@
+// node-agent/watchdog.go
+// Watchdog for the local Ollama backend.
+//
+// The agent probes Ollama's /api/version every few seconds. While the probe
+// fails, heartbeats report backend_down so the orchestrator stops routing
+// here. If a restart command is configured (-ollama-restart-cmd), a locally
+// managed Ollama is restarted once it has been dead for a few probes.
+// With -backend llamacpp the llama.cpp server's /health is probed instead,
+// and the agent restarts the server itself unless it's still loading.
+// Each probe also refreshes the models reported as loaded (see loaded.go)
+// and the capabilities advertised (see capabilities.go).
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	watchdogInterval = 5 * time.Second
+	watchdogTimeout  = 3 * time.Second
+
+	// watchdogFailures is how many consecutive failed probes mark the
+	// backend down (and trigger a restart, if configured).
+	watchdogFailures = 3
+
+	// restartCooldown is the minimum gap between restart attempts, giving a
+	// restarted Ollama time to load before we try again.
+	restartCooldown = 60 * time.Second
+)
+
+// backendDown is true while the backend is failing its health probe.
+var backendDown atomic.Bool
+
+// watchdogLoop probes the backend forever, updating backendDown and
+// restarting it when configured. The llama.cpp server is always restarted:
+// the agent owns its process.
+func watchdogLoop(cfg Config) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	backend := "Ollama on " + ollamaAddr(cfg.OllamaHost, cfg.OllamaPort)
+	probe := func() error { return probeOllamaVersion(cfg.OllamaHost, cfg.OllamaPort) }
+	var restart func()
+	if cfg.OllamaRestartCmd != "" {
+		restart = func() { restartOllama(cfg.OllamaRestartCmd) }
+	}
+	if llama != nil {
+		backend = fmt.Sprintf("llama.cpp on :%d", llama.port)
+		probe = func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), watchdogTimeout)
+			defer cancel()
+			return llama.health(ctx)
+		}
+		restart = llama.restart
+	}
+
+	failures := 0
+	var lastRestart time.Time
+	for range ticker.C {
+		err := probe()
+		if err == nil {
+			loaded.refresh(cfg)
+			advertised.refresh(cfg)
+			if backendDown.Swap(false) {
+				log.Printf("[Watchdog] %s is back up", backend)
+			}
+			failures = 0
+			continue
+		}
+
+		loaded.clear()
+		failures++
+		if failures < watchdogFailures {
+			continue
+		}
+		if !backendDown.Swap(true) {
+			log.Printf("[Watchdog] %s is down (%v) — reporting backend_down", backend, err)
+		}
+		if restart != nil && !errors.Is(err, errLlamaLoading) && time.Since(lastRestart) >= restartCooldown {
+			lastRestart = time.Now()
+			restart()
+		}
+	}
+}
+
+// probeOllamaVersion checks that Ollama answers GET /api/version.
+func probeOllamaVersion(host string, port int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), watchdogTimeout)
+	defer cancel()
+
+	url := shared.BaseURL(host, port) + "/api/version"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	setBackendHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// restartOllama runs the configured restart command through the shell.
+// The command may exit quickly ("systemctl restart ollama") or keep
+// running as the new backend ("ollama serve") — it is started, not waited
+// on, and reaped in the background.
+func restartOllama(command string) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	log.Printf("[Watchdog] Restarting Ollama: %s", command)
+	if err := cmd.Start(); err != nil {
+		log.Printf("[Watchdog] Restart command failed to start: %v", err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("[Watchdog] Restart command exited: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,119 @@
+// node-agent/work.go
+// Pull mode, for agents the orchestrator can't connect to.
+//
+// With -pull the agent registers with "pull": true and, instead of waiting
+// for the orchestrator to POST /execute, long-polls GET /work for its next
+// task, runs it like /execute would and posts the result to
+// POST /results/ingest. All connections go from the agent outwards, so it
+// works from behind NAT or a firewall. -pull-workers pollers run side by
+// side.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// workWait is how long each GET /work may wait for a task.
+	workWait = 25 * time.Second
+	// workRetryDelay is the pause after a failed poll.
+	workRetryDelay = 3 * time.Second
+	// resultAttempts is how often a result is offered before it's dropped.
+	resultAttempts = 3
+)
+
+// workClient gives up on a poll a little after the orchestrator would
+// have answered it, so a dead connection doesn't stall the poller.
+var workClient = &http.Client{Timeout: workWait + 15*time.Second}
+
+// workLoop pulls and runs tasks forever.
+func workLoop(cfg Config) {
+	for {
+		item, err := pollWork(cfg)
+		if err != nil {
+			log.Printf("[Agent:%s] Polling for work failed, retrying in %v: %v", cfg.NodeID, workRetryDelay, err)
+			time.Sleep(workRetryDelay)
+			continue
+		}
+		if item == nil {
+			continue
+		}
+
+		log.Printf("[Agent:%s] Executing pulled task %s", cfg.NodeID, item.Request.TaskID)
+		ctx := withPassedHeaders(context.Background(), headerMap(item.Headers))
+		result := executeTask(ctx, cfg, item.Request)
+		postResult(cfg, shared.WorkResult{WorkID: item.WorkID, NodeID: cfg.NodeID, Result: result})
+	}
+}
+
+// pollWork waits for the next task; nil when none came in time.
+func pollWork(cfg Config) (*shared.WorkItem, error) {
+	q := url.Values{}
+	q.Set("node_id", cfg.NodeID)
+	q.Set("wait", fmt.Sprint(int(workWait.Seconds())))
+	req, err := http.NewRequest("GET", cfg.OrchestratorURL+"/work?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	resp, err := workClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var item shared.WorkItem
+		if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+			return nil, fmt.Errorf("decoding work item: %w", err)
+		}
+		return &item, nil
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		// 404/401 until the heartbeat loop has re-registered us
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+}
+
+// postResult hands a result back, retrying briefly over a network blip
+// or when the orchestrator got it damaged (422, checksum mismatch).
+func postResult(cfg Config, res shared.WorkResult) {
+	body, _ := json.Marshal(res)
+	var err error
+	for attempt := 1; attempt <= resultAttempts; attempt++ {
+		var req *http.Request
+		req, err = http.NewRequest("POST", cfg.OrchestratorURL+"/results/ingest", bytes.NewReader(body))
+		if err != nil {
+			break
+		}
+		req.Header.Set("Content-Type", "application/json")
+		authorize(req)
+		var resp *http.Response
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode == http.StatusGone:
+				log.Printf("[Agent:%s] Result of task %s no longer wanted (timed out)", cfg.NodeID, res.Result.TaskID)
+				return
+			case resp.StatusCode < 400:
+				return
+			}
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		time.Sleep(time.Second)
+	}
+	log.Printf("[Agent:%s] Dropping result of task %s: %v", cfg.NodeID, res.Result.TaskID, err)
+}
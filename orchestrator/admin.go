@@ -0,0 +1,173 @@
+// orchestrator/admin.go
+// Admin API for registry management, used by the dashboard's admin panel.
+//
+//	POST   /admin/nodes/{id}/drain   stop routing new tasks to a node
+//	DELETE /admin/nodes/{id}/drain   resume routing to it
+//	DELETE /admin/nodes/{id}         evict a node from the registry
+//	POST   /admin/nodes/{id}/selftest run the node's self-test (see selftest.go)
+//	POST   /admin/nodes/{id}/pull     pull a model onto the node (see pull.go)
+//	POST   /admin/flush              drop load profiles and routing snapshots
+//	GET    /admin/routing            current routing strategy
+//	PUT    /admin/routing            change it (least-loaded | round-robin)
+//	GET    /admin/routing/weights    routing weights (see weights.go)
+//	PUT    /admin/routing/weights    change them
+//	GET    /admin/dlq                list dead-lettered tasks
+//	POST   /admin/dlq/{id}/retry     re-run a dead-lettered task
+//	DELETE /admin/dlq                clear the dead-letter queue
+//	*      /admin/aliases/...        model aliases and rollouts (see aliases.go)
+//
+// With -admin-token set, every admin endpoint requires "Authorization:
+// Bearer <token>", which the request chain checks (see middleware.go).
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// adminToken guards the admin endpoints ("" = open); set from -admin-token.
+var adminToken string
+
+// strategy is the active routing strategy; roundRobin is its rotation counter.
+var (
+	strategy   atomic.Value // shared.RoutingStrategy
+	roundRobin atomic.Uint64
+)
+
+// currentStrategy returns the active routing strategy.
+func currentStrategy() shared.RoutingStrategy {
+	if s, ok := strategy.Load().(shared.RoutingStrategy); ok {
+		return s
+	}
+	return shared.StrategyLeastLoaded
+}
+
+// ─── Nodes ────────────────────────────────────────────────────────────────────
+
+func handleDrainNode(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodeID := r.PathValue("id")
+		if !registry.SetDraining(nodeID, draining) {
+			writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("node %q is not registered", nodeID))
+			return
+		}
+		EmitNodeAdmin(nodeID, draining)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"node_id": nodeID, "draining": draining})
+	}
+}
+
+func handleEvictNode(w http.ResponseWriter, r *http.Request) {
+	nodeID := r.PathValue("id")
+	removed := registry.Remove(nodeID)
+	timeouts.forget(nodeID)
+	if !identities.forget(nodeID) && !removed {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("node %q is not registered", nodeID))
+		return
+	}
+	EmitNodeEvicted(nodeID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"node_id": nodeID, "evicted": true})
+}
+
+// ─── Flush ────────────────────────────────────────────────────────────────────
+
+func handleFlush(w http.ResponseWriter, r *http.Request) {
+	dropped := registry.FlushProfiles()
+	latencies := timeouts.flush()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"flushed":           []string{"load_profiles", "routing_snapshots", "timeout_latencies"},
+		"load_profiles":     dropped,
+		"timeout_latencies": latencies,
+	})
+}
+
+// ─── Routing strategy ─────────────────────────────────────────────────────────
+
+func handleGetRouting(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shared.RoutingConfig{Strategy: currentStrategy()})
+}
+
+func handleSetRouting(w http.ResponseWriter, r *http.Request) {
+	var cfg shared.RoutingConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch cfg.Strategy {
+	case shared.StrategyLeastLoaded, shared.StrategyRoundRobin:
+	default:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("unknown strategy %q (want %s or %s)",
+			cfg.Strategy, shared.StrategyLeastLoaded, shared.StrategyRoundRobin))
+		return
+	}
+	strategy.Store(cfg.Strategy)
+	saveRoutingConfig()
+	log.Printf("[Admin] Routing strategy set to %s", cfg.Strategy)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// ─── Dead-letter queue ────────────────────────────────────────────────────────
+
+func handleListDLQ(w http.ResponseWriter, r *http.Request) {
+	list := deadLetters.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"tasks": list,
+		"count": len(list),
+	})
+}
+
+// handleRetryDLQ re-runs a dead-lettered task. On success the entry is
+// removed and the result returned; on failure it stays queued.
+func handleRetryDLQ(w http.ResponseWriter, r *http.Request) {
+	entry, ok := deadLetters.Get(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "dead-letter entry not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), taskTimeout)
+	defer cancel()
+
+	// The retry runs under a new task ID, recorded as a retry of the original
+	retry := entry.Request
+	retry.TaskID = uuid.New().String()
+	if !lineageLog.Has(entry.Request.TaskID) {
+		lineageLog.Record(shared.LineageNode{ID: entry.Request.TaskID, Error: entry.Error})
+	}
+
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, retry, nil)
+	lineageLog.Record(taskLineageNode(retry.TaskID, entry.Request.TaskID, shared.RelationRetry, result, err))
+	if err != nil {
+		deadLetters.Fail(entry.ID, err)
+		writeProblem(w, r, http.StatusServiceUnavailable, fmt.Sprintf("retry failed: %v", err))
+		return
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	deadLetters.Remove(entry.ID)
+	EmitTaskDone(result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleClearDLQ(w http.ResponseWriter, r *http.Request) {
+	n := deadLetters.Clear()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"cleared": n})
+}
@@ -0,0 +1,372 @@
+// orchestrator/alerts.go
+// Alert rules and notifications.
+//
+// Every -alert-interval the orchestrator checks four rules, each off when
+// its limit is 0:
+//
+//	node_offline  a registered node has sent no heartbeat for -alert-node-offline
+//	error_rate    more than -alert-error-rate of the tasks in the last 5 minutes
+//	              failed (checked from alertMinTasks tasks on)
+//	queue_depth   more than -alert-queue-depth tasks wait for a node: queued for
+//	              pull-mode agents, waiting for an exclusive model or for a
+//	              pipeline slot
+//	disk_low      less than -alert-disk-free of a node's models volume is free
+//
+// A rule fires once per subject (a node, or the whole mesh) when its
+// condition starts to hold and resolves when it stops; both transitions are
+// logged, broadcast as alert events and sent to every configured channel:
+// -alert-webhook gets the shared.Alert as JSON, -alert-ntfy is an ntfy topic
+// URL (e.g. https://ntfy.sh/my-mesh), and -alert-telegram-chat a chat the bot
+// whose token is in $ECHO_TELEGRAM_BOT_TOKEN posts to. Delivery is best
+// effort: a channel that fails is logged, not retried. GET /alerts lists the
+// firing alerts and the last resolved ones; the state is in memory only.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// telegramTokenEnv holds the Telegram bot token for -alert-telegram-chat.
+const telegramTokenEnv = "ECHO_TELEGRAM_BOT_TOKEN"
+
+const (
+	// alertErrorWindow is how far back error_rate looks, and alertMinTasks
+	// how many tasks that window needs before a rate means anything.
+	alertErrorWindow = 5 * time.Minute
+	alertMinTasks    = 10
+
+	// alertResolvedLimit caps the resolved alerts kept for GET /alerts.
+	alertResolvedLimit = 50
+
+	// alertSendTimeout bounds one notification to one channel.
+	alertSendTimeout = 10 * time.Second
+)
+
+// alerts evaluates the rules; its limits and channels are set from flags.
+var alerts = &alertManager{
+	Interval:    15 * time.Second,
+	NodeOffline: 5 * time.Minute,
+	ErrorRate:   0.2,
+	DiskFree:    0.05,
+	firing:      make(map[string]*shared.Alert),
+}
+
+// alertManager holds the rules' limits and the alerts' state.
+type alertManager struct {
+	Interval    time.Duration
+	NodeOffline time.Duration // 0 = off
+	ErrorRate   float64       // failed fraction; 0 = off
+	QueueDepth  int           // waiting tasks; 0 = off
+	DiskFree    float64       // free fraction; 0 = off
+
+	Webhook      string // URL
+	Ntfy         string // topic URL
+	TelegramChat string // chat ID
+
+	channels []alertChannel
+
+	mu       sync.Mutex
+	firing   map[string]*shared.Alert // keyed by Alert.ID
+	resolved []shared.Alert           // newest first, capped at alertResolvedLimit
+}
+
+// start sets up the channels and starts the evaluation loop; call once
+// after flags are parsed.
+func (m *alertManager) start() {
+	client := &http.Client{Timeout: alertSendTimeout}
+	if m.Webhook != "" {
+		m.channels = append(m.channels, &webhookChannel{url: m.Webhook, client: client})
+	}
+	if m.Ntfy != "" {
+		m.channels = append(m.channels, &ntfyChannel{url: m.Ntfy, client: client})
+	}
+	if m.TelegramChat != "" {
+		token := os.Getenv(telegramTokenEnv)
+		if token == "" {
+			log.Printf("[Alerts] %s is not set — not sending alerts to Telegram", telegramTokenEnv)
+		} else {
+			m.channels = append(m.channels, &telegramChannel{token: token, chat: m.TelegramChat, client: client})
+		}
+	}
+	if m.Interval <= 0 {
+		log.Printf("[Alerts] -alert-interval is 0 — alert rules are not checked")
+		return
+	}
+	names := make([]string, len(m.channels))
+	for i, c := range m.channels {
+		names[i] = c.Name()
+	}
+	log.Printf("[Alerts] Checking %d rules every %s, notifying %v", len(m.rules()), m.Interval, names)
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.evaluate()
+		}
+	}()
+}
+
+// rules lists the enabled rules and their limits.
+func (m *alertManager) rules() []shared.AlertRuleConfig {
+	rules := []shared.AlertRuleConfig{}
+	if m.NodeOffline > 0 {
+		rules = append(rules, shared.AlertRuleConfig{Rule: shared.AlertNodeOffline, Threshold: m.NodeOffline.Seconds()})
+	}
+	if m.ErrorRate > 0 {
+		rules = append(rules, shared.AlertRuleConfig{Rule: shared.AlertErrorRate, Threshold: m.ErrorRate})
+	}
+	if m.QueueDepth > 0 {
+		rules = append(rules, shared.AlertRuleConfig{Rule: shared.AlertQueueDepth, Threshold: float64(m.QueueDepth)})
+	}
+	if m.DiskFree > 0 {
+		rules = append(rules, shared.AlertRuleConfig{Rule: shared.AlertDiskLow, Threshold: m.DiskFree})
+	}
+	return rules
+}
+
+// ─── Evaluation ───────────────────────────────────────────────────────────────
+
+// evaluate checks every rule, fires the alerts whose condition started to
+// hold and resolves those whose condition no longer does.
+func (m *alertManager) evaluate() {
+	holding := m.check()
+
+	m.mu.Lock()
+	var changed []shared.Alert
+	now := time.Now().UnixMilli()
+	for id, a := range holding {
+		if cur, ok := m.firing[id]; ok {
+			cur.Value = a.Value
+			cur.Message = a.Message
+			continue
+		}
+		a.State = shared.AlertFiring
+		a.FiredAt = now
+		m.firing[id] = a
+		changed = append(changed, *a)
+	}
+	for id, a := range m.firing {
+		if holding[id] != nil {
+			continue
+		}
+		delete(m.firing, id)
+		a.State = shared.AlertResolved
+		a.ResolvedAt = now
+		m.resolved = append([]shared.Alert{*a}, m.resolved...)
+		if len(m.resolved) > alertResolvedLimit {
+			m.resolved = m.resolved[:alertResolvedLimit]
+		}
+		changed = append(changed, *a)
+	}
+	m.mu.Unlock()
+
+	for _, a := range changed {
+		log.Printf("[Alerts] %s %s: %s", a.State, a.ID, a.Message)
+		EmitAlert(a)
+		m.notify(a)
+	}
+}
+
+// check returns the alerts whose condition holds right now, keyed by ID.
+func (m *alertManager) check() map[string]*shared.Alert {
+	holding := make(map[string]*shared.Alert)
+	add := func(rule shared.AlertRule, subject string, value, threshold float64, format string, args ...any) {
+		id := string(rule) + ":" + subject
+		holding[id] = &shared.Alert{
+			ID: id, Rule: rule, Subject: subject,
+			Message: fmt.Sprintf(format, args...),
+			Value:   value, Threshold: threshold,
+		}
+	}
+
+	for _, node := range registry.AllNodes() {
+		if m.NodeOffline > 0 {
+			silent := time.Since(time.UnixMilli(node.LastHeartbeat))
+			if silent >= m.NodeOffline {
+				add(shared.AlertNodeOffline, node.NodeID, silent.Seconds(), m.NodeOffline.Seconds(),
+					"%s has sent no heartbeat for %s", node.NodeID, silent.Round(time.Second))
+			}
+		}
+		if m.DiskFree > 0 && node.Resources != nil && node.Resources.DiskTotalBytes > 0 {
+			free := float64(node.Resources.DiskFreeBytes) / float64(node.Resources.DiskTotalBytes)
+			if free < m.DiskFree {
+				add(shared.AlertDiskLow, node.NodeID, free, m.DiskFree,
+					"%s has %s (%.1f%%) free on its models volume", node.NodeID, formatBytes(node.Resources.DiskFreeBytes), free*100)
+			}
+		}
+	}
+
+	if m.ErrorRate > 0 {
+		var tasks, failed int64
+		for _, p := range statsSeries.Series(alertErrorWindow, time.Minute) {
+			tasks += p.Tasks
+			failed += p.FailedTasks
+		}
+		if tasks >= alertMinTasks {
+			if rate := float64(failed) / float64(tasks); rate > m.ErrorRate {
+				add(shared.AlertErrorRate, "mesh", rate, m.ErrorRate,
+					"%d of %d tasks failed in the last %s (%.0f%%)", failed, tasks, alertErrorWindow, rate*100)
+			}
+		}
+	}
+
+	if m.QueueDepth > 0 {
+		if depth := queueDepth(); depth > m.QueueDepth {
+			add(shared.AlertQueueDepth, "mesh", float64(depth), float64(m.QueueDepth),
+				"%d tasks are waiting for a node", depth)
+		}
+	}
+	return holding
+}
+
+// list returns the firing alerts, oldest first, and the resolved ones.
+func (m *alertManager) list() (firing, resolved []shared.Alert) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	firing = make([]shared.Alert, 0, len(m.firing))
+	for _, a := range m.firing {
+		firing = append(firing, *a)
+	}
+	sort.Slice(firing, func(i, j int) bool {
+		if firing[i].FiredAt != firing[j].FiredAt {
+			return firing[i].FiredAt < firing[j].FiredAt
+		}
+		return firing[i].ID < firing[j].ID
+	})
+	resolved = append([]shared.Alert{}, m.resolved...)
+	return firing, resolved
+}
+
+// alertsResponse is the GET /alerts body.
+type alertsResponse struct {
+	Firing   []shared.Alert           `json:"firing"`
+	Resolved []shared.Alert           `json:"resolved"` // newest first, at most 50
+	Rules    []shared.AlertRuleConfig `json:"rules"`    // the enabled rules
+	Channels []string                 `json:"channels"` // where notifications go: webhook, ntfy, telegram
+}
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	firing, resolved := alerts.list()
+	resp := alertsResponse{Firing: firing, Resolved: resolved, Rules: alerts.rules(), Channels: []string{}}
+	for _, c := range alerts.channels {
+		resp.Channels = append(resp.Channels, c.Name())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ─── Channels ─────────────────────────────────────────────────────────────────
+
+// alertChannel delivers alert notifications somewhere.
+type alertChannel interface {
+	Name() string
+	Send(ctx context.Context, a shared.Alert) error
+}
+
+// notify sends a to every channel in the background.
+func (m *alertManager) notify(a shared.Alert) {
+	for _, c := range m.channels {
+		go func(c alertChannel) {
+			ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+			defer cancel()
+			if err := c.Send(ctx, a); err != nil {
+				log.Printf("[Alerts] Sending %s %s to %s failed: %v", a.State, a.ID, c.Name(), err)
+			}
+		}(c)
+	}
+}
+
+// alertTitle is the one-line summary used by the text channels.
+func alertTitle(a shared.Alert) string {
+	if a.State == shared.AlertResolved {
+		return fmt.Sprintf("Resolved: %s on %s", a.Rule, a.Subject)
+	}
+	return fmt.Sprintf("Alert: %s on %s", a.Rule, a.Subject)
+}
+
+// postAlert sends body to target and fails on a non-2xx answer. Errors leave
+// the URL out: Telegram's carries the bot token.
+func postAlert(ctx context.Context, client *http.Client, target, contentType string, body []byte, header map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookChannel POSTs the alert as JSON.
+type webhookChannel struct {
+	url    string
+	client *http.Client
+}
+
+func (c *webhookChannel) Name() string { return "webhook" }
+
+func (c *webhookChannel) Send(ctx context.Context, a shared.Alert) error {
+	body, _ := json.Marshal(a)
+	return postAlert(ctx, c.client, c.url, "application/json", body, nil)
+}
+
+// ntfyChannel publishes to an ntfy topic: the message as the body, the
+// title, priority and an emoji tag as headers.
+type ntfyChannel struct {
+	url    string
+	client *http.Client
+}
+
+func (c *ntfyChannel) Name() string { return "ntfy" }
+
+func (c *ntfyChannel) Send(ctx context.Context, a shared.Alert) error {
+	header := map[string]string{"Title": alertTitle(a), "Priority": "high", "Tags": "warning"}
+	if a.State == shared.AlertResolved {
+		header["Priority"], header["Tags"] = "default", "white_check_mark"
+	}
+	return postAlert(ctx, c.client, c.url, "text/plain", []byte(a.Message), header)
+}
+
+// telegramChannel sends the alert as a bot message to one chat.
+type telegramChannel struct {
+	token  string
+	chat   string
+	client *http.Client
+}
+
+func (c *telegramChannel) Name() string { return "telegram" }
+
+func (c *telegramChannel) Send(ctx context.Context, a shared.Alert) error {
+	body, _ := json.Marshal(map[string]string{
+		"chat_id": c.chat,
+		"text":    alertTitle(a) + "\n" + a.Message,
+	})
+	return postAlert(ctx, c.client, "https://api.telegram.org/bot"+c.token+"/sendMessage", "application/json", body, nil)
+}
@@ -0,0 +1,442 @@
+// orchestrator/aliases.go
+// Model aliases with blue/green rollouts.
+//
+// An alias is a name clients send as model_hint ("smart") that the
+// orchestrator resolves to a concrete model ("llama3:8b") before routing.
+// Re-pointing an alias can be done at once (PUT /admin/aliases/{name}) or
+// staged: a rollout sends a percentage of the alias's tasks to the new
+// target and tallies both sides. Once the new target has enough samples,
+// the rollout rolls itself back if its error rate exceeds max_error_rate
+// or its average latency exceeds max_latency_ratio times the current
+// target's. The operator raises the percentage as confidence grows and
+// promotes the rollout to make the new target the alias's own.
+//
+//	GET    /admin/aliases                      list aliases and rollouts
+//	PUT    /admin/aliases/{name}               create or re-point an alias
+//	DELETE /admin/aliases/{name}               remove it
+//	POST   /admin/aliases/{name}/rollout       start a rollout or change its percent/thresholds
+//	POST   /admin/aliases/{name}/promote       make the rollout target the alias's target
+//	DELETE /admin/aliases/{name}/rollout       abort the rollout
+//
+// Every rollout transition is published as an alias_rollout event, plus a
+// checkpoint each time the new target completes another min_samples tasks.
+// Aliases are saved to <data-dir>/aliases.json.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	defaultRolloutMaxErrorRate    = 0.1
+	defaultRolloutMaxLatencyRatio = 1.5
+	defaultRolloutMinSamples      = 20
+)
+
+// aliasStore holds the aliases; the mutex also serializes saves.
+type aliasStore struct {
+	mu      sync.Mutex
+	aliases map[string]*shared.ModelAlias
+	path    string // "" until loadAliases; nothing is saved
+}
+
+var aliases = &aliasStore{aliases: make(map[string]*shared.ModelAlias)}
+
+// aliasPick is what an alias resolved to for one task.
+type aliasPick struct {
+	alias     string
+	model     string
+	candidate bool  // sent to the rollout target
+	rollout   int64 // StartedAt of the rollout it was counted for, 0 if none
+}
+
+// resolve maps model to a concrete model if it names an alias, choosing
+// the rollout target for the rollout's share of tasks.
+func (s *aliasStore) resolve(model string) (aliasPick, bool) {
+	if model == "" {
+		return aliasPick{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.aliases[model]
+	if !ok {
+		return aliasPick{}, false
+	}
+	pick := aliasPick{alias: a.Name, model: a.Target}
+	if ro := a.Rollout; ro != nil && ro.Status == shared.RolloutActive {
+		pick.rollout = ro.StartedAt
+		if rand.Float64()*100 < ro.Percent {
+			pick.model = ro.Target
+			pick.candidate = true
+		}
+	}
+	return pick, true
+}
+
+// record counts a task's outcome toward the rollout it was picked under
+// and rolls the rollout back if the new target has regressed.
+func (s *aliasStore) record(pick aliasPick, latencyMs int64, failed bool) {
+	if pick.rollout == 0 {
+		return
+	}
+	s.mu.Lock()
+	a, ok := s.aliases[pick.alias]
+	if !ok || a.Rollout == nil || a.Rollout.Status != shared.RolloutActive || a.Rollout.StartedAt != pick.rollout {
+		s.mu.Unlock()
+		return
+	}
+	ro := a.Rollout
+	side := &ro.Stable
+	if pick.candidate {
+		side = &ro.Candidate
+	}
+	side.Tasks++
+	if failed {
+		side.Failures++
+	} else {
+		side.AvgLatencyMs += (float64(latencyMs) - side.AvgLatencyMs) / float64(side.Tasks-side.Failures)
+	}
+
+	action, reason := "", rolloutRegression(ro)
+	switch {
+	case reason != "":
+		action = "rolled_back"
+		ro.Status = shared.RolloutRolledBack
+		ro.Reason = reason
+		s.save()
+	case pick.candidate && ro.Candidate.Tasks%ro.MinSamples == 0:
+		action = "checkpoint"
+	}
+	snapshot := cloneAlias(a)
+	s.mu.Unlock()
+
+	if action == "" {
+		return
+	}
+	if action == "rolled_back" {
+		log.Printf("[Aliases] Rolled back %s → %s: %s", snapshot.Name, snapshot.Rollout.Target, reason)
+	}
+	EmitAliasRollout(snapshot, action)
+}
+
+// pickServed reports whether the task ran on the model the alias picked:
+// a task rescued by a fallback model, or by failing over to a node that
+// answered with another model, counts against the pick.
+func pickServed(pick aliasPick, result *shared.TaskResult, err error) bool {
+	if err != nil || result.ModelFallback != nil {
+		return false
+	}
+	return result.ModelUsed == "" || shared.SameModel(result.ModelUsed, pick.model)
+}
+
+// rolloutRegression returns why the rollout's target is worse than the
+// alias's current target, or "" if it isn't (yet).
+func rolloutRegression(ro *shared.AliasRollout) string {
+	c, st := ro.Candidate, ro.Stable
+	if c.Tasks < ro.MinSamples {
+		return ""
+	}
+	if rate := float64(c.Failures) / float64(c.Tasks); rate > ro.MaxErrorRate {
+		return fmt.Sprintf("error rate %.0f%% over %d tasks exceeds %.0f%%",
+			100*rate, c.Tasks, 100*ro.MaxErrorRate)
+	}
+	if c.Tasks-c.Failures >= ro.MinSamples && st.Tasks-st.Failures >= ro.MinSamples &&
+		st.AvgLatencyMs > 0 && c.AvgLatencyMs > ro.MaxLatencyRatio*st.AvgLatencyMs {
+		return fmt.Sprintf("average latency %.0fms is %.1fx the current target's %.0fms (limit %.1fx)",
+			c.AvgLatencyMs, c.AvgLatencyMs/st.AvgLatencyMs, st.AvgLatencyMs, ro.MaxLatencyRatio)
+	}
+	return ""
+}
+
+// list returns copies of the aliases, sorted by name.
+func (s *aliasStore) list() []shared.ModelAlias {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]shared.ModelAlias, 0, len(s.aliases))
+	for _, a := range s.aliases {
+		list = append(list, cloneAlias(a))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// isAliasTarget reports whether name is some alias's target or rollout
+// target. Must be called with s.mu held.
+func (s *aliasStore) isAliasTarget(name string) bool {
+	for _, a := range s.aliases {
+		if a.Target == name || (a.Rollout != nil && a.Rollout.Target == name) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneAlias(a *shared.ModelAlias) shared.ModelAlias {
+	c := *a
+	if a.Rollout != nil {
+		ro := *a.Rollout
+		c.Rollout = &ro
+	}
+	return c
+}
+
+// ─── Persistence ──────────────────────────────────────────────────────────────
+
+// loadAliases restores the aliases saved in dataDir.
+func loadAliases(dataDir string) {
+	aliases.mu.Lock()
+	defer aliases.mu.Unlock()
+	aliases.path = filepath.Join(dataDir, "aliases.json")
+	raw, err := os.ReadFile(aliases.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Aliases] Failed to read %s: %v", aliases.path, err)
+		}
+		return
+	}
+	var list []shared.ModelAlias
+	if err := json.Unmarshal(raw, &list); err != nil {
+		log.Printf("[Aliases] Ignoring unreadable %s: %v", aliases.path, err)
+		return
+	}
+	for i := range list {
+		aliases.aliases[list[i].Name] = &list[i]
+	}
+	log.Printf("[Aliases] Restored %d alias(es)", len(list))
+}
+
+// save persists the aliases (write temp + rename). Rollout counters are
+// saved with them but only on transitions, not after every task. Must be
+// called with s.mu held.
+func (s *aliasStore) save() {
+	if s.path == "" {
+		return
+	}
+	list := make([]*shared.ModelAlias, 0, len(s.aliases))
+	for _, a := range s.aliases {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		log.Printf("[Aliases] Failed to save aliases: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("[Aliases] Failed to save aliases: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.Printf("[Aliases] Failed to save aliases: %v", err)
+	}
+}
+
+// ─── Admin handlers ───────────────────────────────────────────────────────────
+
+func handleListAliases(w http.ResponseWriter, r *http.Request) {
+	list := aliases.list()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"aliases": list, "count": len(list)})
+}
+
+// handleSetAlias creates an alias or re-points it at once, ending any
+// rollout in progress.
+func handleSetAlias(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var body shared.ModelAlias
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Target == "" {
+		writeProblem(w, r, http.StatusBadRequest, "target is required")
+		return
+	}
+
+	aliases.mu.Lock()
+	if _, ok := aliases.aliases[body.Target]; ok || body.Target == name {
+		aliases.mu.Unlock()
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("target %q is an alias; aliases must point at models", body.Target))
+		return
+	}
+	a, exists := aliases.aliases[name]
+	if !exists && aliases.isAliasTarget(name) {
+		aliases.mu.Unlock()
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("%q is already an alias target and can't be an alias", name))
+		return
+	}
+	var aborted *shared.ModelAlias
+	if exists && a.Rollout != nil && a.Rollout.Status == shared.RolloutActive {
+		a.Rollout.Status = shared.RolloutRolledBack
+		a.Rollout.Reason = fmt.Sprintf("alias re-pointed to %s", body.Target)
+		c := cloneAlias(a)
+		aborted = &c
+	}
+	if !exists {
+		a = &shared.ModelAlias{Name: name}
+		aliases.aliases[name] = a
+	}
+	a.Target = body.Target
+	a.Rollout = nil
+	aliases.save()
+	resp := cloneAlias(a)
+	aliases.mu.Unlock()
+
+	if aborted != nil {
+		EmitAliasRollout(*aborted, "aborted")
+	}
+	log.Printf("[Admin] Alias %s → %s", name, body.Target)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	aliases.mu.Lock()
+	a, ok := aliases.aliases[name]
+	if ok {
+		delete(aliases.aliases, name)
+		aliases.save()
+	}
+	aliases.mu.Unlock()
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("alias %q not found", name))
+		return
+	}
+	log.Printf("[Admin] Alias %s removed", name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// handleStartRollout starts a rollout, or updates the percentage and
+// thresholds of the active one to the same target (keeping its tallies).
+func handleStartRollout(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var cfg shared.AliasRollout
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateRollout(&cfg); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	aliases.mu.Lock()
+	a, ok := aliases.aliases[name]
+	if !ok {
+		aliases.mu.Unlock()
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("alias %q not found", name))
+		return
+	}
+	if _, isAlias := aliases.aliases[cfg.Target]; isAlias || cfg.Target == a.Target {
+		aliases.mu.Unlock()
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("rollout target must be a model other than the alias's target %q", a.Target))
+		return
+	}
+	action := "started"
+	if ro := a.Rollout; ro != nil && ro.Status == shared.RolloutActive && ro.Target == cfg.Target {
+		action = "updated"
+		ro.Percent = cfg.Percent
+		ro.MaxErrorRate = cfg.MaxErrorRate
+		ro.MaxLatencyRatio = cfg.MaxLatencyRatio
+		ro.MinSamples = cfg.MinSamples
+	} else {
+		a.Rollout = &shared.AliasRollout{
+			Target:          cfg.Target,
+			Percent:         cfg.Percent,
+			MaxErrorRate:    cfg.MaxErrorRate,
+			MaxLatencyRatio: cfg.MaxLatencyRatio,
+			MinSamples:      cfg.MinSamples,
+			Status:          shared.RolloutActive,
+			StartedAt:       time.Now().UnixMilli(),
+		}
+	}
+	aliases.save()
+	resp := cloneAlias(a)
+	aliases.mu.Unlock()
+
+	log.Printf("[Admin] Alias %s rollout %s: %.0f%% → %s", name, action, cfg.Percent, cfg.Target)
+	EmitAliasRollout(resp, action)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validateRollout checks a rollout request and fills in default thresholds.
+func validateRollout(cfg *shared.AliasRollout) error {
+	switch {
+	case cfg.Target == "":
+		return fmt.Errorf("target is required")
+	case cfg.Percent < 0 || cfg.Percent > 100:
+		return fmt.Errorf("percent must be between 0 and 100, got %v", cfg.Percent)
+	case cfg.MaxErrorRate < 0 || cfg.MaxErrorRate > 1:
+		return fmt.Errorf("max_error_rate must be between 0 and 1, got %v", cfg.MaxErrorRate)
+	case cfg.MaxLatencyRatio != 0 && cfg.MaxLatencyRatio < 1:
+		return fmt.Errorf("max_latency_ratio must be at least 1, got %v", cfg.MaxLatencyRatio)
+	case cfg.MinSamples < 0:
+		return fmt.Errorf("min_samples must not be negative, got %d", cfg.MinSamples)
+	}
+	if cfg.MaxErrorRate == 0 {
+		cfg.MaxErrorRate = defaultRolloutMaxErrorRate
+	}
+	if cfg.MaxLatencyRatio == 0 {
+		cfg.MaxLatencyRatio = defaultRolloutMaxLatencyRatio
+	}
+	if cfg.MinSamples == 0 {
+		cfg.MinSamples = defaultRolloutMinSamples
+	}
+	return nil
+}
+
+// handleEndRollout promotes (the rollout target becomes the alias's) or
+// aborts (traffic returns to the alias's target) the active rollout.
+func handleEndRollout(promote bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		aliases.mu.Lock()
+		a, ok := aliases.aliases[name]
+		if !ok {
+			aliases.mu.Unlock()
+			writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("alias %q not found", name))
+			return
+		}
+		ro := a.Rollout
+		if ro == nil || ro.Status != shared.RolloutActive {
+			aliases.mu.Unlock()
+			writeProblem(w, r, http.StatusConflict, fmt.Sprintf("alias %q has no active rollout", name))
+			return
+		}
+		action := "aborted"
+		ro.Status = shared.RolloutRolledBack
+		ro.Reason = "aborted by operator"
+		event := cloneAlias(a)
+		if promote {
+			action = "promoted"
+			ro.Reason = ""
+			event = cloneAlias(a)
+			a.Target = ro.Target
+			a.Rollout = nil
+		}
+		aliases.save()
+		resp := cloneAlias(a)
+		aliases.mu.Unlock()
+
+		log.Printf("[Admin] Alias %s rollout %s (%s → %s)", name, action, event.Target, event.Rollout.Target)
+		EmitAliasRollout(event, action)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,813 @@
+// orchestrator/apidocs.go
+// The API catalogue behind GET /openapi.json: one apiOp per route registered
+// in main.go. checkAPIDocs refuses to start the orchestrator if a route is
+// added without an entry here (or an entry outlives its route), so the spec
+// can't drift from the handlers.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+
+	"echo-system/shared"
+)
+
+// ─── Response bodies built from maps ──────────────────────────────────────────
+// Several handlers encode ad-hoc maps; these types document their shape.
+
+// statusResponse is the body of GET /status.
+type statusResponse struct {
+	Nodes      []shared.NodeInfo `json:"nodes"`       // registered nodes, then absent ones from the inventory
+	NodeCount  int               `json:"node_count"`  // registered nodes
+	ServerTime int64             `json:"server_time"` // unix ms
+}
+
+// pipelineRunList is the body of GET /pipelines/runs.
+type pipelineRunList struct {
+	Runs  []shared.PipelineRunSummary `json:"runs"`
+	Count int                         `json:"count"`
+}
+
+type templateList struct {
+	Templates []shared.PipelineTemplate `json:"templates"`
+	Count     int                       `json:"count"`
+}
+
+type ingestResponse struct {
+	Status string `json:"status"` // "accepted"
+}
+
+type routingPreview struct {
+	Routing map[string]string `json:"routing"` // task type ("" = any) → "node (model: m)"
+	Nodes   []shared.NodeInfo `json:"nodes"`
+}
+
+type lockList struct {
+	Locks []ModelLock `json:"locks"`
+	Count int         `json:"count"`
+}
+
+type mirrorResults struct {
+	Enabled bool                  `json:"enabled"`
+	Percent float64               `json:"percent"`
+	NodeID  string                `json:"node_id"`
+	Model   string                `json:"model"`
+	Results []shared.MirrorRecord `json:"results"`
+	Count   int                   `json:"count"`
+}
+
+type statsSeriesResponse struct {
+	WindowSecs int64               `json:"window_secs"`
+	StepSecs   int64               `json:"step_secs"`
+	Points     []shared.StatsPoint `json:"points"`
+}
+
+type availabilityResponse struct {
+	WindowSecs int64                     `json:"window_secs"`
+	Nodes      []shared.NodeAvailability `json:"nodes"`
+}
+
+type requestStatsResponse struct {
+	Since  int64               `json:"since"` // unix ms the counts start at
+	Routes []shared.RouteStats `json:"routes"`
+}
+
+type drainResponse struct {
+	NodeID   string `json:"node_id"`
+	Draining bool   `json:"draining"`
+}
+
+type evictResponse struct {
+	NodeID  string `json:"node_id"`
+	Evicted bool   `json:"evicted"`
+}
+
+type flushResponse struct {
+	Flushed      []string `json:"flushed"`
+	LoadProfiles int      `json:"load_profiles"` // profiles dropped
+}
+
+type deadLetterList struct {
+	Tasks []shared.DeadLetter `json:"tasks"`
+	Count int                 `json:"count"`
+}
+
+type clearedResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+type fileList struct {
+	Files []shared.FileInfo `json:"files"` // newest first
+	Count int               `json:"count"`
+}
+
+type aliasList struct {
+	Aliases []shared.ModelAlias `json:"aliases"`
+	Count   int                 `json:"count"`
+}
+
+// ─── Catalogue ────────────────────────────────────────────────────────────────
+
+var (
+	idParam     = func(what string) apiParam { return apiParam{Name: "id", In: "path", Description: what} }
+	nodeIDParam = idParam("Node ID")
+
+	aliasNameParam = apiParam{Name: "name", In: "path", Description: "Alias name"}
+)
+
+var apiOps = []apiOp{
+	// ── Tasks ────────────────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/task", ID: "submitTask", Tag: "tasks",
+		Summary: "Run a task on the best available node and return the full result",
+		Description: "Send either prompt or messages (chat turns, fitted into the target model's context window), or for embed tasks up to 2048 texts in input, embedded in batches and returned in embeddings with embed_timings. " +
+			"With format json, output that isn't valid JSON is repaired with a re-prompt (json_repaired) or the task fails with error_code INVALID_JSON. " +
+			"min_quality keeps the task on models of that size class or larger, as declared by the nodes. " +
+			"With verify, a second model (-verify-model, or another than the one that answered) checks the answer and verification carries its verdict and confidence. " +
+			"While no node can take the task it waits for one, for -queue-timeout or queue_timeout_ms (negative fails at once). " +
+			"503 when every candidate node failed, or none could take the task in time.",
+		Request:     shared.TaskRequest{},
+		Response:    shared.TaskResult{},
+		RateLimited: true,
+	},
+	{
+		Method: "POST", Path: "/task/stream", ID: "streamTask", Tag: "tasks",
+		Summary: "Run a task and stream its output as server-sent events, one JSON TaskChunk per data: line",
+		Description: "A node failing before its first token is replaced by the next one, announced by an \"event: failover\" line whose data is a FailoverEvent. " +
+			"A failure after tokens were sent ends the stream with a done chunk carrying error. " +
+			"With format json, a done chunk with json_repaired carries the repaired document in text, replacing the streamed output. " +
+			"With verify, the done chunk waits for the answer to be checked and carries verification. " +
+			"Responses carry X-Accel-Buffering: no, open with a retry: hint and get a \": keep-alive\" comment line when quiet for -sse-keepalive.",
+		Params: []apiParam{
+			{Name: "mode", In: "query", Description: "Overrides stream_mode",
+				Enum: []string{string(shared.StreamModeDelta), string(shared.StreamModeFull)}},
+			{Name: "granularity", In: "query", Description: "Overrides stream_granularity",
+				Enum: []string{string(shared.GranularityToken), string(shared.GranularityWord), string(shared.GranularitySentence)}},
+		},
+		Request:     shared.TaskRequest{},
+		Response:    shared.TaskChunk{},
+		ContentType: "text/event-stream",
+		RateLimited: true,
+	},
+	{
+		Method: "GET", Path: "/task/stream/{id}", ID: "resumeStream", Tag: "tasks",
+		Summary: "Resume a task stream after a dropped connection or an orchestrator restart",
+		Description: "Each chunk event of /task/stream has an SSE id: the bytes of the answer sent so far. " +
+			"Given the last one received, this sends the rest in the stream's mode, reattaching to the node if the task is still running. " +
+			"410 if the node no longer has a running stream, or it runs on a pull-mode node (retry once it's done).",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "Task ID"},
+			{Name: "Last-Event-ID", In: "header", Description: "id of the last event received (default: send the whole answer)"},
+			{Name: "last_event_id", In: "query", Description: "Same as Last-Event-ID, for clients that can't set headers"},
+		},
+		Response:    shared.TaskChunk{},
+		ContentType: "text/event-stream",
+	},
+	{
+		Method: "POST", Path: "/task/async", ID: "submitTaskAsync", Tag: "tasks",
+		Summary: "Queue a task and return at once with its task ID; poll GET /task/{id} for the result",
+		Description: "Takes and checks the same body as POST /task, then runs the task in the background exactly as POST /task would. " +
+			"Location and status_url give the URL to poll. 409 when the task_id was already submitted, 503 when too many async tasks are waiting.",
+		Request:     shared.TaskRequest{},
+		Response:    shared.AsyncTask{},
+		Status:      http.StatusAccepted,
+		RateLimited: true,
+	},
+	{
+		Method: "GET", Path: "/task/{id}", ID: "getAsyncTask", Tag: "tasks",
+		Summary:     "The status of a task sent to POST /task/async: queued, running, done with its result, or failed with its problem",
+		Description: "Finished tasks are kept for an hour. They're held in memory, so after an orchestrator restart this answers 404.",
+		Params:      []apiParam{idParam("Task ID")},
+		Response:    shared.AsyncTask{},
+	},
+	{
+		Method: "GET", Path: "/tasks/{id}/lineage", ID: "getTaskLineage", Tag: "pipelines",
+		Summary:     "The lineage tree a task belongs to: its pipeline, steps, map items, retries and mirrored copies",
+		Description: "For a pipeline step's (or map item's) task, also its pipeline, step, attempt and recorded step result. 404 when the task was never part of a pipeline, retry or mirror.",
+		Params:      []apiParam{idParam("Task ID")},
+		Response:    shared.TaskLineageRecord{},
+	},
+	{
+		Method: "POST", Path: "/tasks/{id}/feedback", ID: "giveTaskFeedback", Tag: "tasks",
+		Summary: "Rate a task's output up or down, comment on it or give the output it should have been",
+		Description: "Kept with the node, model and type the task ran with and summed in GET /stats/feedback; a rating also counts toward the node's reputation. " +
+			"Posting again replaces the feedback (200 instead of 201). 404 when the task's result is no longer known, as for share links.",
+		Params:   []apiParam{idParam("Task ID")},
+		Request:  shared.FeedbackRequest{},
+		Response: shared.TaskFeedback{},
+		Status:   http.StatusCreated,
+	},
+	{
+		Method: "GET", Path: "/tasks/{id}/feedback", ID: "getTaskFeedback", Tag: "tasks",
+		Summary:  "The feedback given on a task",
+		Params:   []apiParam{idParam("Task ID")},
+		Response: shared.TaskFeedback{},
+	},
+	{
+		Method: "GET", Path: "/history", ID: "listTaskHistory", Tag: "tasks",
+		Summary: "Page through finished tasks, newest first: prompt, node, model, latency and outcome",
+		Description: "Tasks sent to /task, /task/async and /task/stream, conversation turns, and pipeline steps and map items, kept in <data-dir>/tasks.db for -task-history-retention. " +
+			"Times are Unix ms, RFC 3339, or a span back from now such as 24h or 7d. Pass a page's next_cursor as cursor for the next one. " +
+			"503 when the history database couldn't be opened.",
+		Params: []apiParam{
+			{Name: "node", In: "query", Description: "Only tasks that ran on this node"},
+			{Name: "type", In: "query", Description: "Only tasks of this type"},
+			{Name: "since", In: "query", Description: "Only tasks that finished at or after this time"},
+			{Name: "until", In: "query", Description: "Only tasks that finished before this time"},
+			{Name: "limit", In: "query", Description: "Tasks per page, 1 to 500 (default 50)"},
+			{Name: "cursor", In: "query", Description: "A previous page's next_cursor"},
+		},
+		Response: shared.TaskHistoryPage{},
+	},
+	{
+		Method: "POST", Path: "/tasks/{id}/share", ID: "shareTask", Tag: "share",
+		Summary: "Make a signed, expiring link to a task's result for someone without access to the API",
+		Description: "The task must have finished recently enough for its result to be known: one of the last 1000 tasks, a resumable stream, a pipeline step or a deferred task. " +
+			"The result is copied for as long as the link lasts. The body is optional.",
+		Params:   []apiParam{idParam("Task ID")},
+		Request:  shared.ShareRequest{},
+		Response: shared.ShareLink{},
+		Status:   http.StatusCreated,
+	},
+	{
+		Method: "POST", Path: "/pipelines/runs/{id}/share", ID: "sharePipelineRun", Tag: "share",
+		Summary:  "Make a signed, expiring link to a pipeline run; the body is optional",
+		Params:   []apiParam{idParam("Pipeline ID")},
+		Request:  shared.ShareRequest{},
+		Response: shared.ShareLink{},
+		Status:   http.StatusCreated,
+	},
+	{
+		Method: "GET", Path: "/share/{token}", ID: "getShared", Tag: "share",
+		Summary:  "The result a share link was made for; 410 once it expired",
+		Params:   []apiParam{{Name: "token", In: "path", Description: "Token from the share link"}},
+		Response: shared.SharedResult{},
+	},
+
+	// ── Conversations ────────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/conversations", ID: "createConversation", Tag: "conversations",
+		Summary: "Start a conversation the orchestrator keeps, optionally pinned to a model and/or node",
+		Description: "messages seed the history, e.g. with a system prompt. " +
+			"400 when the pinned node isn't registered or doesn't have the pinned model.",
+		Request:  shared.ConversationRequest{},
+		Response: shared.Conversation{},
+		Status:   http.StatusCreated,
+	},
+	{
+		Method: "GET", Path: "/conversations/{id}", ID: "getConversation", Tag: "conversations",
+		Summary:  "A conversation's history, pin, summary and recorded pins and switches",
+		Params:   []apiParam{idParam("Conversation ID")},
+		Response: shared.Conversation{},
+	},
+	{
+		Method: "DELETE", Path: "/conversations/{id}", ID: "deleteConversation", Tag: "conversations",
+		Summary:  "Delete a conversation",
+		Params:   []apiParam{idParam("Conversation ID")},
+		Response: shared.Conversation{},
+	},
+	{
+		Method: "POST", Path: "/conversations/{id}/messages", ID: "sendConversationTurn", Tag: "conversations",
+		Summary: "Run the next user turn over the conversation's history on its pinned model and node",
+		Description: "The turn and its answer join the history once the task succeeds; a failed turn leaves it as it was. " +
+			"A node pin allows no failover. 409 while another turn or a switch is in progress.",
+		Params:      []apiParam{idParam("Conversation ID")},
+		Request:     shared.ConversationTurn{},
+		Response:    shared.TaskResult{},
+		RateLimited: true,
+	},
+	{
+		Method: "PUT", Path: "/conversations/{id}/pin", ID: "pinConversation", Tag: "conversations",
+		Summary: "Pin a conversation to a model and/or node, replacing its pin",
+		Description: "A new model with a smaller context window than the current one has the older turns summarized, as for a switch. " +
+			"400 when the node isn't registered or doesn't have the model; 409 while a turn is in progress.",
+		Params:      []apiParam{idParam("Conversation ID")},
+		Request:     shared.ConversationPinRequest{},
+		Response:    shared.Conversation{},
+		RateLimited: true,
+	},
+	{
+		Method: "DELETE", Path: "/conversations/{id}/pin", ID: "unpinConversation", Tag: "conversations",
+		Summary:  "Let routing choose the model and node for a conversation's turns again",
+		Params:   []apiParam{idParam("Conversation ID")},
+		Response: shared.Conversation{},
+	},
+	{
+		Method: "POST", Path: "/conversations/{id}/switch", ID: "switchConversationModel", Tag: "conversations",
+		Summary: "Move a conversation to another model midway, keeping its pinned node unless node_id names another",
+		Description: "When the new model's context window is smaller and the history no longer fits it, the older turns are summarized once and the summary is sent in their place from then on. " +
+			"The switch is recorded in changes, with how many turns were summarized. 400 when the pinned node doesn't have the model.",
+		Params:      []apiParam{idParam("Conversation ID")},
+		Request:     shared.ConversationPinRequest{},
+		Response:    shared.Conversation{},
+		RateLimited: true,
+	},
+
+	// ── Files ────────────────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/files", ID: "uploadFile", Tag: "files",
+		Summary: "Upload a file (the raw bytes as the body) for tasks to reference by ID in files",
+		Description: "The ID is the hex SHA-256 of the content: uploading the same bytes again answers 200 with the stored file. " +
+			"Text files and the text members of zip archives are put ahead of the prompt; images go to the model beside it. " +
+			"413 over -max-file-bytes.",
+		Params:      []apiParam{{Name: "name", In: "query", Description: "File name shown to the model"}},
+		Request:     []byte{},
+		RequestType: "application/octet-stream",
+		Response:    shared.FileInfo{},
+		Status:      http.StatusCreated,
+	},
+	{
+		Method: "GET", Path: "/files", ID: "listFiles", Tag: "files",
+		Summary:  "List uploaded files",
+		Response: fileList{},
+	},
+	{
+		Method: "GET", Path: "/files/{id}", ID: "getFile", Tag: "files",
+		Summary:     "Download a file's content; agents fetch task files here",
+		Params:      []apiParam{idParam("File ID")},
+		Response:    []byte{},
+		ContentType: "application/octet-stream",
+	},
+
+	// ── Pipelines ────────────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/pipeline", ID: "runPipeline", Tag: "pipelines",
+		Summary: "Run a multi-step pipeline, sent as JSON or YAML; answers 409 if a run with its pipeline_id is still running " +
+			"and 500 with the partial result if a step fails",
+		Request:     shared.PipelineRequest{},
+		RequestYAML: true,
+		Response:    shared.PipelineResult{},
+		Errors:      map[int]any{http.StatusInternalServerError: shared.PipelineResult{}},
+		RateLimited: true,
+	},
+	{
+		Method: "POST", Path: "/summarize", ID: "summarize", Tag: "pipelines",
+		Summary: "Summarize a long document: chunk it, summarize the chunks in parallel across nodes, then combine them; " +
+			"answers 413 if it has too many chunks, 409 if a run with its pipeline_id is still running " +
+			"and 500 with the partial traces if a step fails",
+		Request:     shared.SummarizeRequest{},
+		Response:    shared.SummarizeResult{},
+		Errors:      map[int]any{http.StatusInternalServerError: shared.SummarizeResult{}},
+		RateLimited: true,
+	},
+	{
+		Method: "POST", Path: "/chunk", ID: "chunkText", Tag: "pipelines",
+		Summary:  "Cut a text into chunks of a token budget, as /summarize and map steps do, e.g. to embed a document for retrieval",
+		Request:  shared.ChunkRequest{},
+		Response: shared.ChunkResult{},
+	},
+	{
+		Method: "GET", Path: "/pipelines/templates/builtin", ID: "listPipelineTemplates", Tag: "pipelines",
+		Summary:  "List the built-in pipeline templates",
+		Response: templateList{},
+	},
+	{
+		Method: "GET", Path: "/pipelines/runs", ID: "listPipelineRuns", Tag: "pipelines",
+		Summary: "List persisted pipeline runs, newest first",
+		Params: []apiParam{{Name: "source", In: "query",
+			Description: "Only runs whose client key name or remote IP equals this, or whose user agent contains it"}},
+		Response: pipelineRunList{},
+	},
+	{
+		Method: "GET", Path: "/pipelines/runs/{id}", ID: "getPipelineRun", Tag: "pipelines",
+		Summary:  "Get a persisted pipeline run",
+		Params:   []apiParam{idParam("Pipeline ID")},
+		Response: shared.PipelineRun{},
+	},
+
+	// ── Offline bundles ──────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/bundles/tasks", ID: "deferTask", Tag: "bundles",
+		Summary:     "Queue a low-priority task for offline bundling; poll GET /bundles/tasks/{id} for the result",
+		Request:     shared.TaskRequest{},
+		Response:    shared.DeferredTask{},
+		Status:      http.StatusAccepted,
+		RateLimited: true,
+	},
+	{
+		Method: "GET", Path: "/bundles/tasks/{id}", ID: "getDeferredTask", Tag: "bundles",
+		Summary:  "Get a deferred task and, once uploaded, its result",
+		Params:   []apiParam{idParam("Task ID")},
+		Response: shared.DeferredTask{},
+	},
+	{
+		Method: "POST", Path: "/bundles/claim", ID: "claimBundle", Tag: "agents",
+		Summary:  "Claim a batch of deferred tasks to run locally (called by agents)",
+		Request:  shared.BundleClaimRequest{},
+		Response: shared.TaskBundle{},
+		Session:  true,
+	},
+	{
+		Method: "POST", Path: "/bundles/{id}/results", ID: "uploadBundleResults", Tag: "agents",
+		Summary:  "Upload a bundle's results (called by agents)",
+		Params:   []apiParam{idParam("Bundle ID")},
+		Request:  shared.BundleUpload{},
+		Response: shared.BundleUploadResponse{},
+		Session:  true,
+	},
+
+	// ── Nodes and models ─────────────────────────────────────────────────────
+	{
+		Method: "GET", Path: "/status", ID: "getStatus", Tag: "nodes",
+		Summary:  "List registered nodes",
+		Response: statusResponse{},
+	},
+	{
+		Method: "GET", Path: "/version", ID: "getVersion", Tag: "nodes",
+		Summary:  "The orchestrator's build and the mesh API version it speaks",
+		Response: shared.VersionInfo{},
+	},
+	{
+		Method: "GET", Path: "/topology", ID: "getTopology", Tag: "nodes",
+		Summary:     "Which nodes see which others over mDNS, with the RTT between them",
+		Description: "Built from the peers agents report in their heartbeats; nodes whose agents don't discover peers have reporting false.",
+		Response:    shared.Topology{},
+	},
+	{
+		Method: "GET", Path: "/nodes/{id}/models", ID: "getNodeModels", Tag: "nodes",
+		Summary:     "List the models installed on a node, with details from its Ollama",
+		Description: "Relayed from the agent: size, family, quantization, context length, loaded state and last use per model, plus declared models that aren't installed. 502 when the agent or its Ollama can't answer.",
+		Params:      []apiParam{idParam("Node ID")},
+		Response:    shared.ModelListResponse{},
+	},
+	{
+		Method: "POST", Path: "/register", ID: "registerNode", Tag: "agents",
+		Summary: "Register a node or refresh its capabilities (called by agents)",
+		Description: "Returns a new session token for the node's later calls. While the node is alive, " +
+			"re-registering needs its current token (else 409). Agents speaking another mesh API version get 426.",
+		Request:  shared.RegisterRequest{},
+		Response: shared.RegisterResponse{},
+	},
+	{
+		Method: "POST", Path: "/heartbeat", ID: "heartbeat", Tag: "agents",
+		Summary: "Report a node's status, and any changes to its models (called by agents every few seconds)",
+		Description: "capability_changes is applied to the node's models at once and announced as a capability_changed event. " +
+			"404 means the node isn't registered (e.g. it was evicted) and must register again; " +
+			"401 that the session token is missing or stale; 409 that capability_changes builds on a version the orchestrator doesn't have; " +
+			"426 that it speaks another mesh API version.",
+		Request: shared.HeartbeatRequest{},
+		Session: true,
+	},
+	{
+		Method: "GET", Path: "/ping", ID: "ping", Tag: "agents",
+		Summary:     "Answer 204 at once (called by agents timing their link to the orchestrator)",
+		Description: "Agents report the round trip, jitter and loss of these probes as link in their heartbeats.",
+		Status:      http.StatusNoContent,
+	},
+	{
+		Method: "GET", Path: "/work", ID: "pullWork", Tag: "agents",
+		Summary: "Wait for a pull-mode node's next task (called by agents)",
+		Description: "Long-polls; 204 when no task arrived within wait. 404 means the node must register again, " +
+			"409 that it didn't register with pull.",
+		Params: []apiParam{
+			{Name: "node_id", In: "query", Description: "The polling node", Required: true},
+			{Name: "wait", In: "query", Description: "Seconds to wait for a task (default 25, max 60)"},
+		},
+		Response: shared.WorkItem{},
+		Session:  true,
+	},
+	{
+		Method: "POST", Path: "/results/ingest", ID: "ingestWorkResult", Tag: "agents",
+		Summary:     "Return a pulled task's result (called by agents)",
+		Description: "410 when nobody waits for the result any more, e.g. the task timed out. 422 when the content doesn't match the result's checksum; post it again.",
+		Request:     shared.WorkResult{},
+		Response:    ingestResponse{},
+		Session:     true,
+	},
+
+	// ── Observability ────────────────────────────────────────────────────────
+	{
+		Method: "GET", Path: "/debug/routing", ID: "previewRouting", Tag: "observability",
+		Summary:  "Show where the next task of each type would be routed",
+		Response: routingPreview{},
+	},
+	{
+		Method: "GET", Path: "/debug/locks", ID: "listModelLocks", Tag: "observability",
+		Summary:  "List held exclusive-model locks",
+		Response: lockList{},
+	},
+	{
+		Method: "GET", Path: "/debug/requests", ID: "listRequestStats", Tag: "observability",
+		Summary:     "Requests served per route since the orchestrator started, with error, rate-limit and panic counts and latencies",
+		Description: "Counted by the request chain every endpoint goes through; requests no route matched are counted as unmatched.",
+		Response:    requestStatsResponse{},
+	},
+	{
+		Method: "GET", Path: "/debug/dashboards", ID: "listDashboards", Tag: "observability",
+		Summary:     "Connected dashboards with their event queue, dropped and coalesced counts",
+		Description: "Queued stats and node_status events are replaced by newer ones; a dashboard that can't work a full queue down to half within 10s is disconnected with close code 1013.",
+		Response:    dashboardList{},
+	},
+	{
+		Method: "GET", Path: "/mirror/results", ID: "listMirrorResults", Tag: "observability",
+		Summary:  "Compare mirrored tasks: production result next to the candidate's",
+		Response: mirrorResults{},
+	},
+	{
+		Method: "GET", Path: "/stats/series", ID: "getStatsSeries", Tag: "observability",
+		Summary: "Per-step rollups of task counts and latency",
+		Params: []apiParam{
+			{Name: "window", In: "query", Description: "How far back, e.g. 6h or 7d (default 24h, max 30d)"},
+			{Name: "step", In: "query", Description: "Bucket size, e.g. 5m or 1h (default 1h, min 1m)"},
+		},
+		Response: statsSeriesResponse{},
+	},
+	{
+		Method: "GET", Path: "/stats/availability", ID: "getStatsAvailability", Tag: "observability",
+		Summary:     "Per-node uptime and mean time between offlines, from heartbeat history",
+		Description: "Only time the orchestrator was running counts. GET /status carries each node's 24h figures as availability.",
+		Params: []apiParam{
+			{Name: "window", In: "query", Description: "How far back, e.g. 6h or 7d (default 24h, max 30d)"},
+		},
+		Response: availabilityResponse{},
+	},
+	{
+		Method: "GET", Path: "/stats/feedback", ID: "getStatsFeedback", Tag: "observability",
+		Summary:     "Task feedback summed per model, per model and task type, and per node, best score first",
+		Description: "score is the share of ratings that are up.",
+		Params: []apiParam{
+			{Name: "window", In: "query", Description: "How far back, e.g. 7d, or all (default 30d)"},
+			{Name: "type", In: "query", Description: "Only tasks of this type"},
+		},
+		Response: shared.FeedbackSummary{},
+	},
+	{
+		Method: "GET", Path: "/stats/fairshare", ID: "getStatsFairShare", Tag: "observability",
+		Summary: "GPU-seconds, shares and held tasks per tenant, with Jain's fairness index",
+		Description: "Tenants are client key names, or anonymous. share is the tenant's part of the decayed GPU-seconds; fair_share, its part of the weights. " +
+			"fairness is Jain's index over share/fair_share: 1 when every tenant gets its fair share.",
+		Response: shared.FairShareStats{},
+	},
+	{
+		Method: "GET", Path: "/stats/timeouts", ID: "getStatsTimeouts", Tag: "observability",
+		Summary: "How long each node may take to answer tasks for each model before they fail over",
+		Description: "With -adaptive-timeout, a node and model with 20 recent latencies waits 1.5 × their p99 plus 5s, between -task-timeout-min and -task-timeout; " +
+			"without, it gets the full -task-timeout. timed_out counts attempts that failed over for running past it.",
+		Response: shared.TimeoutStats{},
+	},
+	{
+		Method: "GET", Path: "/cloud/usage", ID: "getCloudUsage", Tag: "observability",
+		Summary:  "Today's cloud fallback spend against its daily token cap",
+		Response: shared.CloudUsage{},
+	},
+	{
+		Method: "GET", Path: "/alerts", ID: "listAlerts", Tag: "observability",
+		Summary:     "Firing and recently resolved alerts, with the enabled rules and notification channels",
+		Description: "Rules are checked every -alert-interval; each fires once per node (or once for the mesh) and resolves when its condition clears.",
+		Response:    alertsResponse{},
+	},
+	{
+		Method: "GET", Path: "/ws", ID: "subscribeEvents", Tag: "observability",
+		Summary: "WebSocket stream of MeshEvent messages (task, node, pipeline, alert and stats events): " +
+			"JSON text messages, or CBOR binary ones with the echo-mesh.cbor subprotocol",
+		Description: "Browser pages on another host than the orchestrator's need an origin allowed by -cors-origins; others are refused with 403.",
+		Params: []apiParam{
+			{Name: "Sec-WebSocket-Protocol", In: "header", Description: "echo-mesh.cbor for CBOR binary messages, echo-mesh.json (or none) for JSON text", Enum: []string{"echo-mesh.cbor", "echo-mesh.json"}},
+		},
+		Status:      http.StatusSwitchingProtocols,
+		ContentType: "-",
+	},
+
+	// ── Admin ────────────────────────────────────────────────────────────────
+	{
+		Method: "POST", Path: "/admin/nodes/{id}/drain", ID: "drainNode", Tag: "admin",
+		Summary:  "Stop routing new tasks to a node; in-flight tasks finish",
+		Params:   []apiParam{nodeIDParam},
+		Response: drainResponse{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/nodes/{id}/drain", ID: "undrainNode", Tag: "admin",
+		Summary:  "Resume routing to a drained node",
+		Params:   []apiParam{nodeIDParam},
+		Response: drainResponse{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/nodes/{id}", ID: "evictNode", Tag: "admin",
+		Summary:  "Remove a node from the registry and forget its identity key",
+		Params:   []apiParam{nodeIDParam},
+		Response: evictResponse{},
+	},
+	{
+		Method: "POST", Path: "/admin/nodes/{id}/selftest", ID: "selfTestNode", Tag: "admin",
+		Summary: "Run a node's self-test: backend reachability, a 1-token generation per model, disk space and clock; " +
+			"the body is optional and may name the models to test",
+		Params:   []apiParam{nodeIDParam},
+		Request:  shared.SelfTestRequest{},
+		Response: shared.SelfTestReport{},
+	},
+	{
+		Method: "POST", Path: "/admin/nodes/{id}/pull", ID: "pullModel", Tag: "admin",
+		Summary: "Pull a model onto a node",
+		Description: "Checks the node's free disk and VRAM first: 507 when the model doesn't fit, 409 when the node hasn't reported its resources. " +
+			"Once pulled, the agent advertises the model for types with its next heartbeat. node_id in the body is ignored.",
+		Params:   []apiParam{nodeIDParam},
+		Request:  shared.PullRequest{},
+		Response: shared.PullResult{},
+		Errors: map[int]any{
+			http.StatusInsufficientStorage: shared.PlacementError{},
+			http.StatusConflict:            shared.PlacementError{},
+			http.StatusBadGateway:          shared.PullResult{},
+		},
+		RateLimited: true,
+	},
+	{
+		Method: "POST", Path: "/admin/flush", ID: "flushCaches", Tag: "admin",
+		Summary:  "Drop learned load profiles and routing snapshots",
+		Response: flushResponse{},
+	},
+	{
+		Method: "GET", Path: "/admin/routing", ID: "getRoutingStrategy", Tag: "admin",
+		Summary:  "Get the routing strategy",
+		Response: shared.RoutingConfig{},
+	},
+	{
+		Method: "PUT", Path: "/admin/routing", ID: "setRoutingStrategy", Tag: "admin",
+		Summary:  "Set the routing strategy",
+		Request:  shared.RoutingConfig{},
+		Response: shared.RoutingConfig{},
+	},
+	{
+		Method: "GET", Path: "/admin/routing/weights", ID: "getRoutingWeights", Tag: "admin",
+		Summary:  "Get the routing weights",
+		Response: shared.RoutingWeights{},
+	},
+	{
+		Method: "PUT", Path: "/admin/routing/weights", ID: "setRoutingWeights", Tag: "admin",
+		Summary:  "Set routing weights (0..100 each); fields left out keep their value",
+		Request:  shared.RoutingWeights{},
+		Response: shared.RoutingWeights{},
+	},
+	{
+		Method: "GET", Path: "/admin/pipelines/schedule", ID: "getPipelineSchedule", Tag: "admin",
+		Summary:  "Get the pipeline slot limit and scheduling policy, with the steps running and waiting",
+		Response: shared.PipelineSchedule{},
+	},
+	{
+		Method: "PUT", Path: "/admin/pipelines/schedule", ID: "setPipelineSchedule", Tag: "admin",
+		Summary:     "Set the pipeline slot limit and scheduling policy; fields left out keep their value",
+		Description: "With shortest-remaining, steps of the pipelines with the fewest steps left get free slots first.",
+		Request:     shared.PipelineSchedule{},
+		Response:    shared.PipelineSchedule{},
+	},
+	{
+		Method: "GET", Path: "/admin/fairshare", ID: "getFairShare", Tag: "admin",
+		Summary:  "Get the fair-share scheduler settings and tenant weights",
+		Response: shared.FairShareConfig{},
+	},
+	{
+		Method: "PUT", Path: "/admin/fairshare", ID: "setFairShare", Tag: "admin",
+		Summary:     "Set the fair-share scheduler settings; fields left out keep their value, weights given replace all weights",
+		Description: "When every node that could run a task is busy, tasks are held and released lightest tenant first, by decayed GPU-seconds over weight.",
+		Request:     shared.FairShareConfig{},
+		Response:    shared.FairShareConfig{},
+	},
+	{
+		Method: "GET", Path: "/admin/dlq", ID: "listDeadLetters", Tag: "admin",
+		Summary:  "List tasks that failed on every node (last 200)",
+		Response: deadLetterList{},
+	},
+	{
+		Method: "POST", Path: "/admin/dlq/{id}/retry", ID: "retryDeadLetter", Tag: "admin",
+		Summary:  "Re-run a dead-lettered task; it leaves the queue on success",
+		Params:   []apiParam{idParam("Dead-letter ID")},
+		Response: shared.TaskResult{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/dlq", ID: "clearDeadLetters", Tag: "admin",
+		Summary:  "Clear the dead-letter queue",
+		Response: clearedResponse{},
+	},
+	{
+		Method: "GET", Path: "/admin/queue", ID: "listQueue", Tag: "admin",
+		Summary:     "List the work waiting at the orchestrator, each queue in the order it will go",
+		Description: "Async tasks waiting to run, tasks held by fair sharing for a node, tasks waiting for a node when none can take them, and pipeline steps waiting for a pipeline slot (by pipeline ID). Under /admin rather than /queue, as promoting and cancelling act on other clients' tasks.",
+		Response:    shared.QueueList{},
+	},
+	{
+		Method: "POST", Path: "/admin/queue/{id}/promote", ID: "promoteQueued", Tag: "admin",
+		Summary:  "Move a waiting task or pipeline step to the front of its queue",
+		Params:   []apiParam{idParam("Task ID, or pipeline ID for a pipeline step")},
+		Response: shared.QueueEntry{},
+	},
+	{
+		Method: "POST", Path: "/admin/queue/{id}/cancel", ID: "cancelQueued", Tag: "admin",
+		Summary:  "Drop a waiting task or pipeline step; the task fails with 410, the pipeline at that step",
+		Params:   []apiParam{idParam("Task ID, or pipeline ID for a pipeline step")},
+		Response: shared.QueueEntry{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/files/{id}", ID: "deleteFile", Tag: "admin",
+		Summary:  "Remove an uploaded file; tasks still referencing it fail",
+		Params:   []apiParam{idParam("File ID")},
+		Response: shared.FileInfo{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/shares", ID: "revokeShares", Tag: "admin",
+		Summary:  "Revoke every share link by replacing the signing key; cleared counts the shared task results removed",
+		Response: clearedResponse{},
+	},
+	{
+		Method: "POST", Path: "/admin/switchover", ID: "switchover", Tag: "admin",
+		Summary:  "Send every dashboard connected to this orchestrator a switchover event pointing at another one, then disconnect them",
+		Request:  shared.SwitchoverRequest{},
+		Response: switchoverResponse{},
+	},
+	{
+		Method: "GET", Path: "/admin/aliases", ID: "listAliases", Tag: "admin",
+		Summary:  "List model aliases and their rollouts",
+		Response: aliasList{},
+	},
+	{
+		Method: "PUT", Path: "/admin/aliases/{name}", ID: "setAlias", Tag: "admin",
+		Summary:  "Create an alias or re-point it at once; ends any rollout in progress",
+		Params:   []apiParam{aliasNameParam},
+		Request:  shared.ModelAlias{},
+		Response: shared.ModelAlias{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/aliases/{name}", ID: "deleteAlias", Tag: "admin",
+		Summary:  "Remove an alias",
+		Params:   []apiParam{aliasNameParam},
+		Response: shared.ModelAlias{},
+	},
+	{
+		Method: "POST", Path: "/admin/aliases/{name}/rollout", ID: "startAliasRollout", Tag: "admin",
+		Summary:  "Send a percentage of an alias's tasks to a new target, rolling back automatically on regression; re-posting the same target updates percent and thresholds",
+		Params:   []apiParam{aliasNameParam},
+		Request:  shared.AliasRollout{},
+		Response: shared.ModelAlias{},
+	},
+	{
+		Method: "DELETE", Path: "/admin/aliases/{name}/rollout", ID: "abortAliasRollout", Tag: "admin",
+		Summary:  "Abort the active rollout; all traffic returns to the alias's target",
+		Params:   []apiParam{aliasNameParam},
+		Response: shared.ModelAlias{},
+	},
+	{
+		Method: "POST", Path: "/admin/aliases/{name}/promote", ID: "promoteAliasRollout", Tag: "admin",
+		Summary:  "Make the active rollout's target the alias's target",
+		Params:   []apiParam{aliasNameParam},
+		Response: shared.ModelAlias{},
+	},
+
+	// ── API docs and dashboard ───────────────────────────────────────────────
+	{
+		Method: "GET", Path: "/openapi.json", ID: "getOpenAPI", Tag: "docs",
+		Summary:  "This document",
+		Response: map[string]any{},
+	},
+	{
+		Method: "GET", Path: "/docs", ID: "getAPIDocs", Tag: "docs",
+		Summary:     "Swagger UI for this document",
+		Response:    "",
+		ContentType: "text/html",
+	},
+	{Method: "GET", Path: "/dashboard", Hidden: true},
+	{Method: "GET", Path: "/dashboard/", Hidden: true},
+}
+
+// apiEnums lists the values of string types that are enumerations.
+var apiEnums = map[reflect.Type][]string{
+	reflect.TypeOf(shared.TaskType("")): {
+		string(shared.TaskTypeText), string(shared.TaskTypeCode), string(shared.TaskTypeVision),
+		string(shared.TaskTypeSummarize), string(shared.TaskTypeEmbed),
+	},
+	reflect.TypeOf(shared.StreamMode("")):   {string(shared.StreamModeDelta), string(shared.StreamModeFull)},
+	reflect.TypeOf(shared.OutputFormat("")): {string(shared.FormatJSON)},
+	reflect.TypeOf(shared.StreamGranularity("")): {
+		string(shared.GranularityToken), string(shared.GranularityWord), string(shared.GranularitySentence),
+	},
+	reflect.TypeOf(shared.NodeStatus("")): {
+		string(shared.StatusIdle), string(shared.StatusBusy), string(shared.StatusOverloaded),
+		string(shared.StatusOffline), string(shared.StatusBackendDown), string(shared.StatusAbsent),
+	},
+	reflect.TypeOf(shared.ThermalState("")): {
+		string(shared.ThermalNormal), string(shared.ThermalThrottled), string(shared.ThermalHot),
+	},
+	reflect.TypeOf(shared.HealthGrade("")): {
+		string(shared.HealthGreen), string(shared.HealthYellow), string(shared.HealthRed),
+	},
+	reflect.TypeOf(shared.PipelineRunStatus("")): {
+		string(shared.RunRunning), string(shared.RunSucceeded), string(shared.RunFailed), string(shared.RunInterrupted),
+	},
+	reflect.TypeOf(shared.RoutingStrategy("")): {string(shared.StrategyLeastLoaded), string(shared.StrategyRoundRobin)},
+	reflect.TypeOf(shared.PipelinePolicy("")):  {string(shared.PipelineFIFO), string(shared.PipelineShortestRemaining)},
+	reflect.TypeOf(shared.RolloutStatus("")):   {string(shared.RolloutActive), string(shared.RolloutRolledBack)},
+	reflect.TypeOf(shared.LineageRelation("")): {
+		string(shared.RelationStep), string(shared.RelationItem), string(shared.RelationRetry), string(shared.RelationMirror),
+	},
+	reflect.TypeOf(shared.AlertRule("")): {
+		string(shared.AlertNodeOffline), string(shared.AlertErrorRate), string(shared.AlertQueueDepth), string(shared.AlertDiskLow),
+	},
+	reflect.TypeOf(shared.AlertState("")): {string(shared.AlertFiring), string(shared.AlertResolved)},
+	reflect.TypeOf(shared.DeferredStatus("")): {
+		string(shared.DeferredQueued), string(shared.DeferredBundled), string(shared.DeferredDone),
+	},
+	reflect.TypeOf(shared.ProblemType("")): {
+		string(shared.ProblemInvalidRequest), string(shared.ProblemUnauthorized), string(shared.ProblemNotFound),
+		string(shared.ProblemConflict), string(shared.ProblemGone), string(shared.ProblemTooLarge),
+		string(shared.ProblemInternal), string(shared.ProblemNodeFailed), string(shared.ProblemUnavailable),
+		string(shared.ProblemTimeout), string(shared.ProblemInsufficientStorage),
+	},
+}
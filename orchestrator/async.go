@@ -0,0 +1,263 @@
+// orchestrator/async.go
+// POST /task/async and GET /task/{id}: tasks that don't hold a connection.
+//
+// POST /task answers once the task is done, and a long generation on a CPU
+// node takes minutes — longer than many proxies and load balancers let a
+// request sit idle, so the client gets a 504 from the proxy while the node
+// carries on. POST /task/async checks the task as POST /task does, answers
+// 202 at once with its task ID and the URL to poll, and runs it in the
+// background just as POST /task would (deduplication, failover, dead
+// letters, dashboard events). GET /task/{id} says whether it's queued,
+// running, done with its result, or failed with the problem POST /task
+// would have answered.
+//
+// At most maxAsyncRunning tasks run at once and the rest wait their turn,
+// up to maxAsyncQueued; beyond that submissions get 503. Finished tasks
+// can be polled for asyncRetention. They're held in memory only: after a
+// restart GET /task/{id} answers 404. An operator can move a queued task
+// to the front or cancel it (see queue.go); a cancelled task fails with
+// 410.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+const (
+	// maxAsyncRunning is how many async tasks are routed at once.
+	maxAsyncRunning = 64
+	// maxAsyncQueued is how many may wait for one of them to finish.
+	maxAsyncQueued = 1000
+	// asyncRetention is how long a finished task can be polled.
+	asyncRetention = time.Hour
+)
+
+var asyncTasks = &asyncTable{tasks: make(map[string]*shared.AsyncTask)}
+
+// asyncTable holds the async tasks, and the queue of those waiting.
+type asyncTable struct {
+	mu      sync.Mutex
+	tasks   map[string]*shared.AsyncTask
+	order   []string // task IDs in submission order
+	queue   []*asyncJob
+	running int
+}
+
+// asyncJob is a queued task and the context it runs in.
+type asyncJob struct {
+	ctx      context.Context
+	req      shared.TaskRequest
+	promoted bool
+}
+
+// submit queues a checked task, returning the status to refuse it with.
+func (t *asyncTable) submit(ctx context.Context, req shared.TaskRequest) (*shared.AsyncTask, int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	if _, ok := t.tasks[req.TaskID]; ok {
+		return nil, http.StatusConflict, fmt.Errorf("task %s was already submitted", req.TaskID)
+	}
+	if len(t.queue) >= maxAsyncQueued {
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("%d async tasks are already waiting; try again later", len(t.queue))
+	}
+	task := &shared.AsyncTask{
+		TaskID:      req.TaskID,
+		Status:      shared.AsyncQueued,
+		StatusURL:   externalPath("/task/" + req.TaskID),
+		SubmittedAt: time.Now().UnixMilli(),
+	}
+	t.tasks[req.TaskID] = task
+	t.order = append(t.order, req.TaskID)
+	t.queue = append(t.queue, &asyncJob{ctx: context.WithoutCancel(ctx), req: req})
+	t.dispatch()
+	copy := *task
+	return &copy, 0, nil
+}
+
+// dispatch starts queued tasks while there's room; call with t.mu held.
+func (t *asyncTable) dispatch() {
+	for t.running < maxAsyncRunning && len(t.queue) > 0 {
+		job := t.queue[0]
+		t.queue = t.queue[1:]
+		t.running++
+		task := t.tasks[job.req.TaskID]
+		task.Status, task.StartedAt = shared.AsyncRunning, time.Now().UnixMilli()
+		go t.run(job)
+	}
+}
+
+// run executes a task and records how it ended.
+func (t *asyncTable) run(job *asyncJob) {
+	ctx, cancel := context.WithTimeout(job.ctx, taskTimeout)
+	defer cancel()
+	result, status, err := executeTask(ctx, job.req)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	task := t.tasks[job.req.TaskID]
+	task.FinishedAt = time.Now().UnixMilli()
+	if err != nil {
+		log.Printf("[Async] Task %s failed: %v", job.req.TaskID, err)
+		p := newProblem(nil, status, err.Error())
+		p.Instance, p.TaskID = task.StatusURL, job.req.TaskID
+		task.Status, task.Error = shared.AsyncFailed, &p
+	} else {
+		task.Status, task.Result = shared.AsyncDone, result
+	}
+	t.running--
+	t.dispatch()
+}
+
+// queued lists the tasks waiting to run, next first.
+func (t *asyncTable) queued() []shared.QueueEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entries := []shared.QueueEntry{}
+	for i, job := range t.queue {
+		submitted := t.tasks[job.req.TaskID].SubmittedAt
+		entries = append(entries, shared.QueueEntry{
+			ID:         job.req.TaskID,
+			Kind:       shared.QueueAsync,
+			Position:   i,
+			Type:       job.req.Type,
+			ModelHint:  job.req.ModelHint,
+			TargetNode: job.req.TargetNode,
+			Source:     job.req.Source,
+			QueuedAt:   submitted,
+			WaitedMs:   now - submitted,
+			Promoted:   job.promoted,
+		})
+	}
+	return entries
+}
+
+// waitingTasks counts the tasks waiting to run.
+func (t *asyncTable) waitingTasks() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue)
+}
+
+// find returns the index of a queued task, or -1. Must be called with
+// t.mu held.
+func (t *asyncTable) find(taskID string) int {
+	for i, job := range t.queue {
+		if job.req.TaskID == taskID {
+			return i
+		}
+	}
+	return -1
+}
+
+// promote moves a queued task to the front, to run next; false if no such
+// task is queued.
+func (t *asyncTable) promote(taskID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.find(taskID)
+	if i < 0 {
+		return false
+	}
+	job := t.queue[i]
+	job.promoted = true
+	copy(t.queue[1:i+1], t.queue[:i])
+	t.queue[0] = job
+	return true
+}
+
+// cancel drops a queued task, which fails with 410; false if no such task
+// is queued.
+func (t *asyncTable) cancel(taskID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.find(taskID)
+	if i < 0 {
+		return false
+	}
+	t.queue = append(t.queue[:i], t.queue[i+1:]...)
+	task := t.tasks[taskID]
+	p := newProblem(nil, http.StatusGone, errQueueCancelled.Error())
+	p.Instance, p.TaskID = task.StatusURL, taskID
+	task.Status, task.Error, task.FinishedAt = shared.AsyncFailed, &p, time.Now().UnixMilli()
+	log.Printf("[Async] Task %s cancelled while queued", taskID)
+	return true
+}
+
+// get returns a copy of a task.
+func (t *asyncTable) get(taskID string) (*shared.AsyncTask, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	task, ok := t.tasks[taskID]
+	if !ok {
+		return nil, false
+	}
+	copy := *task
+	return &copy, true
+}
+
+// expire drops tasks finished longer than asyncRetention ago; call with
+// t.mu held.
+func (t *asyncTable) expire() {
+	cutoff := time.Now().Add(-asyncRetention).UnixMilli()
+	kept := t.order[:0]
+	for _, id := range t.order {
+		if task := t.tasks[id]; task.FinishedAt != 0 && task.FinishedAt < cutoff {
+			delete(t.tasks, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+}
+
+// ─── POST /task/async ─────────────────────────────────────────────────────────
+
+func handleTaskAsync(w http.ResponseWriter, r *http.Request) {
+	var req shared.TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Source = requestSource(r)
+	if req.TaskID == "" {
+		req.TaskID = uuid.New().String()
+	}
+	if status, err := checkTask(req); err != nil {
+		writeProblem(w, r, status, err.Error())
+		return
+	}
+	task, status, err := asyncTasks.submit(r.Context(), req)
+	if err != nil {
+		writeTaskProblem(w, r, status, req.TaskID, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", task.StatusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+// ─── GET /task/{id} ───────────────────────────────────────────────────────────
+
+func handleGetAsyncTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := asyncTasks.get(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "async task not found (finished tasks are kept for an hour, and not across restarts)")
+		return
+	}
+	shared.WriteJSON(w, r, http.StatusOK, task, compressMinBytes)
+}
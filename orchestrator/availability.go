@@ -0,0 +1,278 @@
+// orchestrator/availability.go
+// Per-node availability over rolling windows, from heartbeat history.
+//
+// The tracker keeps, per node, the spans of time it was heartbeating: a
+// heartbeat within 15s of the last one (the same grace isAlive gives)
+// extends the current span, a later one starts a new span. The gap between
+// two spans is an outage. It also keeps the spans the orchestrator itself
+// was running, so that a restart isn't blamed on the nodes: only time the
+// orchestrator was watching counts, and a node that went quiet while it
+// was down didn't go offline as far as availability is concerned.
+//
+// GET /stats/availability reports, per node, the share of the watched
+// window it was up, how many times it went offline and the mean time
+// between those offlines — which machines can be trusted with the models
+// that take minutes to load. GET /status carries the 24h figures. Spans
+// are kept for availabilityRetention and saved to
+// <data-dir>/availability.json once a minute.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	availabilityRetention = 30 * 24 * time.Hour
+	availabilityWindow    = 24 * time.Hour // on GET /status and by default
+
+	// availabilityGapMs is how long a node may go without heartbeats before
+	// it counts as offline, as in isAlive.
+	availabilityGapMs = 15_000
+
+	// availabilityTick is how often the orchestrator marks itself running.
+	availabilityTick = 5 * time.Second
+)
+
+// upSpan is a stretch of time something was up, in unix ms.
+type upSpan struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// availabilityState is what's saved to availability.json.
+type availabilityState struct {
+	Observed []upSpan            `json:"observed"` // the orchestrator was running
+	Nodes    map[string][]upSpan `json:"nodes"`    // the node was heartbeating
+}
+
+// AvailabilityTracker records heartbeat history.
+type AvailabilityTracker struct {
+	mu    sync.Mutex
+	path  string
+	state availabilityState
+	dirty bool
+}
+
+var availability *AvailabilityTracker
+
+// NewAvailabilityTracker loads the history from dataDir (if present) and
+// starts marking the orchestrator as running.
+func NewAvailabilityTracker(dataDir string) *AvailabilityTracker {
+	t := &AvailabilityTracker{
+		path:  filepath.Join(dataDir, "availability.json"),
+		state: availabilityState{Nodes: make(map[string][]upSpan)},
+	}
+	if raw, err := os.ReadFile(t.path); err == nil {
+		var state availabilityState
+		if err := json.Unmarshal(raw, &state); err != nil {
+			log.Printf("[Availability] Ignoring corrupt %s: %v", t.path, err)
+		} else {
+			if state.Nodes == nil {
+				state.Nodes = make(map[string][]upSpan)
+			}
+			t.state = state
+			log.Printf("[Availability] Loaded the history of %d nodes from %s", len(state.Nodes), t.path)
+		}
+	}
+	t.observe()
+	go t.loop()
+	return t
+}
+
+// extend adds an instant at now to spans: it lengthens the last span if
+// that ended within the gap, else starts a new one.
+func extend(spans []upSpan, now int64) []upSpan {
+	if n := len(spans); n > 0 && now-spans[n-1].End <= availabilityGapMs {
+		spans[n-1].End = max(spans[n-1].End, now)
+		return spans
+	}
+	return append(spans, upSpan{Start: now, End: now})
+}
+
+// heartbeat records that nodeID registered or sent a heartbeat.
+func (t *AvailabilityTracker) heartbeat(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Nodes[nodeID] = extend(t.state.Nodes[nodeID], time.Now().UnixMilli())
+	t.dirty = true
+}
+
+// observe records that the orchestrator is running.
+func (t *AvailabilityTracker) observe() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Observed = extend(t.state.Observed, time.Now().UnixMilli())
+	t.dirty = true
+}
+
+// loop marks the orchestrator running every tick and saves once a minute.
+func (t *AvailabilityTracker) loop() {
+	tick := time.NewTicker(availabilityTick)
+	defer tick.Stop()
+	save := time.NewTicker(statsSaveInterval)
+	defer save.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.observe()
+		case <-save.C:
+			if err := t.save(); err != nil {
+				log.Printf("[Availability] Failed to save %s: %v", t.path, err)
+			}
+		}
+	}
+}
+
+// save prunes spans older than the retention and writes the history.
+func (t *AvailabilityTracker) save() error {
+	t.mu.Lock()
+	oldest := time.Now().Add(-availabilityRetention).UnixMilli()
+	prune := func(spans []upSpan) []upSpan {
+		i := sort.Search(len(spans), func(i int) bool { return spans[i].End >= oldest })
+		if i > 0 {
+			t.dirty = true
+		}
+		return spans[i:]
+	}
+	t.state.Observed = prune(t.state.Observed)
+	for id, spans := range t.state.Nodes {
+		if spans = prune(spans); len(spans) == 0 {
+			delete(t.state.Nodes, id)
+		} else {
+			t.state.Nodes[id] = spans
+		}
+	}
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(t.state)
+	t.dirty = false
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// report works out nodeID's availability over the window ending now; nil
+// if it has no history in it.
+func (t *AvailabilityTracker) report(nodeID string, window time.Duration) *shared.NodeAvailability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reportLocked(nodeID, window, time.Now().UnixMilli())
+}
+
+func (t *AvailabilityTracker) reportLocked(nodeID string, window time.Duration, now int64) *shared.NodeAvailability {
+	spans := t.state.Nodes[nodeID]
+	from := now - window.Milliseconds()
+	if len(spans) == 0 || spans[len(spans)-1].End+availabilityGapMs < from {
+		return nil
+	}
+	a := &shared.NodeAvailability{NodeID: nodeID, WindowSecs: int64(window.Seconds())}
+
+	// Watched: the window, since the node first showed up, while the
+	// orchestrator was running (which it is now)
+	watchFrom := max(from, spans[0].Start)
+	var tracked int64
+	for i, s := range t.state.Observed {
+		end := s.End
+		if i == len(t.state.Observed)-1 {
+			end = now
+		}
+		tracked += overlapMs(s.Start, end, watchFrom, now)
+	}
+
+	var up int64
+	for i, s := range spans {
+		end := s.End
+		if i == len(spans)-1 && now-end <= availabilityGapMs {
+			end, a.Online = now, true
+		}
+		up += overlapMs(s.Start, end, from, now)
+		if a.Online {
+			continue
+		}
+		// It went offline once the gap passed, if anyone was watching
+		if wentAt := s.End + availabilityGapMs; wentAt >= from && wentAt <= now && t.observedAt(wentAt) {
+			a.Offlines++
+			a.LastOfflineAt = wentAt
+		}
+	}
+
+	a.TrackedSecs = tracked / 1000
+	a.UpSecs = min(up, tracked) / 1000
+	if tracked > 0 {
+		a.UptimePct = math.Round(float64(min(up, tracked))/float64(tracked)*10_000) / 100
+	}
+	if a.Offlines > 0 {
+		a.MTBOSecs = a.UpSecs / int64(a.Offlines)
+	}
+	return a
+}
+
+// observedAt reports whether the orchestrator was running at ms.
+func (t *AvailabilityTracker) observedAt(ms int64) bool {
+	spans := t.state.Observed
+	slack := availabilityTick.Milliseconds()
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].End+slack >= ms })
+	return i < len(spans) && spans[i].Start <= ms
+}
+
+// overlapMs is how much of [start, end] falls in [from, to].
+func overlapMs(start, end, from, to int64) int64 {
+	return max(0, min(end, to)-max(start, from))
+}
+
+// nodes lists the nodes with history in the window.
+func (t *AvailabilityTracker) nodes(window time.Duration) []*shared.NodeAvailability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UnixMilli()
+	var list []*shared.NodeAvailability
+	for id := range t.state.Nodes {
+		if a := t.reportLocked(id, window, now); a != nil {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NodeID < list[j].NodeID })
+	return list
+}
+
+// ─── Client: GET /stats/availability ──────────────────────────────────────────
+// Query param: window (default 24h, max 30 days), as for /stats/series.
+
+func handleStatsAvailability(w http.ResponseWriter, r *http.Request) {
+	window, err := parseSpan(r.URL.Query().Get("window"), availabilityWindow)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid window: "+err.Error())
+		return
+	}
+	window = min(window, availabilityRetention)
+
+	nodes := availability.nodes(window)
+	if nodes == nil {
+		nodes = []*shared.NodeAvailability{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"window_secs": int64(window.Seconds()),
+		"nodes":       nodes,
+	})
+}
@@ -0,0 +1,64 @@
+// orchestrator/baseurl.go
+// Reverse-proxy support: serving under a sub-path and advertising an
+// external base URL.
+//
+// With -base-path /echo the orchestrator answers both /echo/task (proxy
+// forwards the full path) and /task (proxy already stripped the prefix).
+// -public-url is the address clients see (e.g. https://example.com/echo);
+// it's used in mDNS TXT records and in links the orchestrator hands out.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var (
+	// basePath is the normalized sub-path ("" or "/echo"); set from -base-path.
+	basePath string
+
+	// publicURL is the external base URL without a trailing slash ("" = not
+	// behind a proxy); set from -public-url.
+	publicURL string
+)
+
+// configureBaseURL normalizes and stores the -base-path / -public-url flags.
+func configureBaseURL(path, url string) {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path != "" {
+		path = "/" + path
+	}
+	basePath = path
+	publicURL = strings.TrimRight(strings.TrimSpace(url), "/")
+}
+
+// externalPath turns an orchestrator route ("/pipelines/runs/x") into the
+// link a client should follow: absolute under -public-url when set,
+// otherwise prefixed with -base-path.
+func externalPath(route string) string {
+	if publicURL != "" {
+		return publicURL + route
+	}
+	return basePath + route
+}
+
+// withBasePath serves h under basePath. Requests that arrive with the
+// prefix have it stripped; requests without it (the proxy stripped it
+// already) pass through untouched.
+func withBasePath(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/dashboard/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.StripPrefix(basePath, h).ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
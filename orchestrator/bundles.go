@@ -0,0 +1,407 @@
+// orchestrator/bundles.go
+// Offline task bundles for nodes that come and go.
+//
+// Clients queue low-priority work with POST /bundles/tasks. An agent with
+// bundling enabled claims a batch of queued tasks it can handle as a bundle
+// and executes it locally — if the node goes offline (a laptop leaving the
+// house) it keeps working and uploads the results when it reconnects. The
+// orchestrator reconciles uploads into the deferred task records, the
+// stats series and the dashboard. Bundles whose node hasn't reported back
+// within -bundle-expiry have their unfinished tasks re-queued; a late upload
+// is still accepted for tasks nobody else has finished.
+//
+// State is persisted to <data-dir>/bundles.json.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// bundleExpiry is how long a bundle may stay out before its unfinished
+// tasks are re-queued; set from the -bundle-expiry flag.
+var bundleExpiry = 24 * time.Hour
+
+// deferredRetention is how long finished deferred tasks stay queryable.
+const deferredRetention = 7 * 24 * time.Hour
+
+// maxBundleTasks caps how many tasks a single claim can take.
+const maxBundleTasks = 100
+
+var bundles *BundleStore
+
+// BundleStore holds the deferred task queue and outstanding bundles.
+type BundleStore struct {
+	mu      sync.Mutex
+	path    string
+	tasks   map[string]*shared.DeferredTask // keyed by task_id
+	order   []string                        // task IDs in submission order
+	bundles map[string]*shared.TaskBundle   // outstanding bundles, keyed by bundle_id
+}
+
+// bundleState is the on-disk form of the store.
+type bundleState struct {
+	Tasks   []*shared.DeferredTask `json:"tasks"`
+	Bundles []*shared.TaskBundle   `json:"bundles"`
+}
+
+// NewBundleStore loads persisted bundle state from dataDir and starts the
+// expiry loop.
+func NewBundleStore(dataDir string) *BundleStore {
+	b := &BundleStore{
+		path:    filepath.Join(dataDir, "bundles.json"),
+		tasks:   make(map[string]*shared.DeferredTask),
+		bundles: make(map[string]*shared.TaskBundle),
+	}
+	if raw, err := os.ReadFile(b.path); err == nil {
+		var state bundleState
+		if err := json.Unmarshal(raw, &state); err != nil {
+			log.Printf("[Bundles] Ignoring corrupt %s: %v", b.path, err)
+		} else {
+			for _, t := range state.Tasks {
+				b.tasks[t.Request.TaskID] = t
+				b.order = append(b.order, t.Request.TaskID)
+			}
+			for _, bundle := range state.Bundles {
+				b.bundles[bundle.BundleID] = bundle
+			}
+			log.Printf("[Bundles] Loaded %d deferred tasks, %d outstanding bundles", len(b.tasks), len(b.bundles))
+		}
+	}
+	go b.expireLoop()
+	return b
+}
+
+// Enqueue adds a deferred task to the queue, or fails if one with its task
+// ID is already there.
+func (b *BundleStore) Enqueue(req shared.TaskRequest) (*shared.DeferredTask, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.tasks[req.TaskID]; exists {
+		return nil, fmt.Errorf("deferred task %q already exists", req.TaskID)
+	}
+	t := &shared.DeferredTask{
+		Request:  req,
+		Status:   shared.DeferredQueued,
+		QueuedAt: time.Now().UnixMilli(),
+	}
+	b.tasks[req.TaskID] = t
+	b.order = append(b.order, req.TaskID)
+	b.persist()
+	copy := *t
+	return &copy, nil
+}
+
+// Get returns a copy of a deferred task.
+func (b *BundleStore) Get(taskID string) (*shared.DeferredTask, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.tasks[taskID]
+	if !ok {
+		return nil, false
+	}
+	copy := *t
+	return &copy, true
+}
+
+// Claim bundles up to max queued tasks the node can handle, oldest first.
+func (b *BundleStore) Claim(node *shared.NodeInfo, max int) *shared.TaskBundle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bundle := &shared.TaskBundle{
+		BundleID: uuid.New().String(),
+		NodeID:   node.NodeID,
+		Tasks:    []shared.TaskRequest{},
+		IssuedAt: time.Now().UnixMilli(),
+	}
+	for _, id := range b.order {
+		if len(bundle.Tasks) >= max {
+			break
+		}
+		t := b.tasks[id]
+		if t.Status != shared.DeferredQueued || !canRunOffline(node, t.Request) {
+			continue
+		}
+		t.Status = shared.DeferredBundled
+		t.BundleID = bundle.BundleID
+		t.NodeID = node.NodeID
+		bundle.Tasks = append(bundle.Tasks, withTypeOptions(t.Request))
+	}
+	if len(bundle.Tasks) > 0 {
+		b.bundles[bundle.BundleID] = bundle
+		b.persist()
+		log.Printf("[Bundles] Bundle %s → node %s (%d tasks)", bundle.BundleID, node.NodeID, len(bundle.Tasks))
+	}
+	return bundle
+}
+
+// canRunOffline reports whether a node can take a task without routing
+// fallbacks: it must have the hinted model, or a model for the task type,
+// of the task's min_quality tier if it has one. Targeted tasks go only to their node, which is the only way a canary
+// node gets any.
+func canRunOffline(node *shared.NodeInfo, req shared.TaskRequest) bool {
+	switch {
+	case req.TargetNode != "" && req.TargetNode != node.NodeID:
+		return false
+	case req.TargetNode == "" && node.Canary:
+		return false
+	}
+	if !shared.MeetsQuality(node.Capabilities, expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality), req.MinQuality) {
+		return false
+	}
+	if req.ModelHint != "" {
+		return containsModel(node.Models, req.ModelHint)
+	}
+	return req.Type == shared.TaskTypeAny || shared.CanHandle(node.Capabilities, req.Type)
+}
+
+// Reconcile stores uploaded results. The first result for a task wins;
+// later ones (from a re-queued copy) are counted as duplicates. Accepted
+// results are returned for event emission.
+func (b *BundleStore) Reconcile(bundleID string, upload shared.BundleUpload) (shared.BundleUploadResponse, []*shared.TaskResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var resp shared.BundleUploadResponse
+	var accepted []*shared.TaskResult
+	now := time.Now().UnixMilli()
+	for i := range upload.Results {
+		result := upload.Results[i]
+		t, ok := b.tasks[result.TaskID]
+		switch {
+		case !ok:
+			resp.Unknown++
+			continue
+		case t.Status == shared.DeferredDone:
+			resp.Duplicates++
+			continue
+		}
+		result.RoutedTo = upload.NodeID
+		result.Source = t.Request.Source
+		result.Metadata = t.Request.Metadata
+		if result.TaskType == "" {
+			result.TaskType = t.Request.Type
+		}
+		result.PromptTokens = shared.EstimateTokens(t.Request.Prompt)
+		result.CompletionTokens = shared.EstimateTokens(result.Content)
+		t.Status = shared.DeferredDone
+		t.NodeID = upload.NodeID
+		t.CompletedAt = now
+		t.Result = &result
+		resp.Accepted++
+		accepted = append(accepted, &result)
+	}
+	// The bundle is settled once every task in it has a result
+	if bundle, ok := b.bundles[bundleID]; ok && b.settled(bundle) {
+		delete(b.bundles, bundleID)
+	}
+	b.persist()
+	return resp, accepted
+}
+
+// settled reports whether every task in a bundle is done or was re-queued
+// away from it. Must be called with the lock held.
+func (b *BundleStore) settled(bundle *shared.TaskBundle) bool {
+	for _, req := range bundle.Tasks {
+		if t, ok := b.tasks[req.TaskID]; ok && t.Status == shared.DeferredBundled && t.BundleID == bundle.BundleID {
+			return false
+		}
+	}
+	return true
+}
+
+// expireLoop re-queues the unfinished tasks of bundles held too long and
+// drops finished tasks past deferredRetention.
+func (b *BundleStore) expireLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.expire()
+	}
+}
+
+func (b *BundleStore) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutoff := time.Now().Add(-bundleExpiry).UnixMilli()
+	changed := false
+	for id, bundle := range b.bundles {
+		if bundle.IssuedAt > cutoff {
+			continue
+		}
+		requeued := 0
+		for _, req := range bundle.Tasks {
+			t, ok := b.tasks[req.TaskID]
+			if ok && t.Status == shared.DeferredBundled && t.BundleID == id {
+				t.Status = shared.DeferredQueued
+				t.BundleID = ""
+				t.NodeID = ""
+				requeued++
+			}
+		}
+		delete(b.bundles, id)
+		changed = true
+		log.Printf("[Bundles] Bundle %s on node %s expired — re-queued %d tasks", id, bundle.NodeID, requeued)
+	}
+
+	doneCutoff := time.Now().Add(-deferredRetention).UnixMilli()
+	kept := b.order[:0]
+	for _, id := range b.order {
+		if t := b.tasks[id]; t.Status == shared.DeferredDone && t.CompletedAt < doneCutoff {
+			delete(b.tasks, id)
+			changed = true
+			continue
+		}
+		kept = append(kept, id)
+	}
+	b.order = kept
+
+	if changed {
+		b.persist()
+	}
+}
+
+// persist writes the store to disk, logging failures. Must be called with
+// the lock held.
+func (b *BundleStore) persist() {
+	state := bundleState{
+		Tasks:   make([]*shared.DeferredTask, 0, len(b.order)),
+		Bundles: make([]*shared.TaskBundle, 0, len(b.bundles)),
+	}
+	for _, id := range b.order {
+		state.Tasks = append(state.Tasks, b.tasks[id])
+	}
+	for _, bundle := range b.bundles {
+		state.Bundles = append(state.Bundles, bundle)
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		tmp := b.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, b.path)
+		}
+	}
+	if err != nil {
+		log.Printf("[Bundles] Failed to persist %s: %v", b.path, err)
+	}
+}
+
+// ─── Client: POST /bundles/tasks ──────────────────────────────────────────────
+// Queues a low-priority task for offline bundling. Returns 202 immediately;
+// poll GET /bundles/tasks/{id} for the result.
+
+func handleDeferTask(w http.ResponseWriter, r *http.Request) {
+	var req shared.TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Source = requestSource(r)
+	if req.TaskID == "" {
+		req.TaskID = uuid.New().String()
+	}
+	if err := checkPromptSize(req.Prompt); err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if status, err := checkEmbedInput(req); err != nil {
+		writeProblem(w, r, status, err.Error())
+		return
+	}
+	if err := checkQuality(req.MinQuality); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkMetadata(req.Metadata); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkFiles(req.Files); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := bundles.Enqueue(req)
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(t)
+}
+
+// ─── Client: GET /bundles/tasks/{id} ──────────────────────────────────────────
+
+func handleGetDeferredTask(w http.ResponseWriter, r *http.Request) {
+	t, ok := bundles.Get(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "deferred task not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// ─── Agent: POST /bundles/claim ───────────────────────────────────────────────
+
+func handleClaimBundle(w http.ResponseWriter, r *http.Request) {
+	var req shared.BundleClaimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !requireSession(w, r, req.NodeID) {
+		return
+	}
+	node, err := registry.GetNode(req.NodeID)
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if req.MaxTasks <= 0 || req.MaxTasks > maxBundleTasks {
+		req.MaxTasks = maxBundleTasks
+	}
+
+	bundle := bundles.Claim(node, req.MaxTasks)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// ─── Agent: POST /bundles/{id}/results ────────────────────────────────────────
+
+func handleBundleResults(w http.ResponseWriter, r *http.Request) {
+	var upload shared.BundleUpload
+	if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !requireSession(w, r, upload.NodeID) {
+		return
+	}
+	bundleID := r.PathValue("id")
+
+	resp, accepted := bundles.Reconcile(bundleID, upload)
+	for _, result := range accepted {
+		EmitTaskDone(result)
+	}
+	log.Printf("[Bundles] Results for bundle %s from %s: %d accepted, %d duplicate, %d unknown",
+		bundleID, upload.NodeID, resp.Accepted, resp.Duplicates, resp.Unknown)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,75 @@
+// orchestrator/canary.go
+// Canary nodes and targeted tasks.
+//
+// An agent started with -canary joins the mesh without taking production
+// traffic: routing leaves it out, so operators can try a new Ollama version
+// or an experimental model on a mesh member without risking user-facing
+// tasks. It gets only two kinds of work: mirrored tasks (see mirror.go),
+// for which canaries are the preferred candidates, and tasks that name it
+// in target_node. A targeted task runs on that node or fails — it never
+// fails over to another node, canary or not.
+
+package main
+
+import (
+	"fmt"
+
+	"echo-system/shared"
+)
+
+// targetNode resolves a task's target_node for routing; exclude holds the
+// nodes already tried.
+func targetNode(req shared.TaskRequest, exclude map[string]bool) (*shared.NodeInfo, error) {
+	if exclude[req.TargetNode] {
+		return nil, fmt.Errorf("target node %s already failed", req.TargetNode)
+	}
+	node, err := registry.GetNode(req.TargetNode)
+	if err != nil {
+		return nil, err
+	}
+	if !routable(node) {
+		return nil, fmt.Errorf("target node %s can't take tasks (%s)", req.TargetNode, unroutableReason(node))
+	}
+	if model := expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality); !shared.MeetsQuality(node.Capabilities, model, req.MinQuality) {
+		return nil, fmt.Errorf("target node %s has no %s model for the task", req.TargetNode, req.MinQuality)
+	}
+	return node, nil
+}
+
+// unroutableReason names why routable rejects a node.
+func unroutableReason(node *shared.NodeInfo) string {
+	switch {
+	case !isAlive(node):
+		return "offline"
+	case node.Probing:
+		return "being probed"
+	case node.Draining:
+		return "draining"
+	}
+	return string(node.Status)
+}
+
+// FindMirrorNode picks the candidate for a mirrored task: the least loaded
+// canary node that can serve it, otherwise the best production node.
+func (r *Registry) FindMirrorNode(taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier, exclude map[string]bool) (*shared.NodeInfo, error) {
+	var best *shared.NodeInfo
+	for _, node := range r.AllNodes() {
+		if !node.Canary || exclude[node.NodeID] || !routable(node) {
+			continue
+		}
+		// It must have the hinted model or one for the task type
+		if (modelHint != "" || taskType != shared.TaskTypeAny) && routeTier(node, taskType, modelHint) == 3 {
+			continue
+		}
+		if !shared.MeetsQuality(node.Capabilities, expectedModel(node, taskType, modelHint, language, minQuality), minQuality) {
+			continue
+		}
+		if best == nil || node.ActiveTasks < best.ActiveTasks {
+			best = node
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	return r.FindBestNodeExcluding(taskType, modelHint, language, minQuality, exclude)
+}
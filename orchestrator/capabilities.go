@@ -0,0 +1,96 @@
+// orchestrator/capabilities.go
+// Capability changes carried by heartbeats.
+//
+// A node's models change while it runs: a pull finishes, a model is
+// removed with ollama rm, a model is re-created with another context
+// length. Re-registering for that would reset the node's session and run
+// the capability probe again, so the agent instead sends what changed with
+// its next heartbeat (see the agent's capabilities.go). The registry
+// applies the delta under the node's shard lock, which invalidates the
+// routing snapshot, so the next task routes on the new models; a
+// capability_changed event tells the dashboards.
+//
+// Each delta takes the node's capability version from base to seq. One
+// whose seq the node already has was applied before (the agent didn't see
+// the answer) and is acknowledged again; one whose base is ahead of the
+// node's version built on a change the orchestrator never got, and is
+// refused with 409 so the agent re-registers with its full capabilities.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"echo-system/shared"
+)
+
+var errStaleCapabilities = errors.New("capability changes build on a version this orchestrator doesn't have; re-register")
+
+// ApplyCapabilities applies a heartbeat's capability delta to a node and
+// returns its models and capabilities afterwards; changed is false when
+// the delta had been applied already.
+func (r *Registry) ApplyCapabilities(nodeID string, d *shared.CapabilityDelta) (models []string, caps []shared.ModelCapability, changed bool, err error) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, nil, false, errUnknownNode
+	}
+	switch {
+	case d.Seq <= node.CapabilitySeq:
+		return node.Models, node.Capabilities, false, nil
+	case d.Base > node.CapabilitySeq:
+		return nil, nil, false, errStaleCapabilities
+	}
+
+	gone := make(map[string]bool, len(d.Removed)+len(d.Added))
+	for _, name := range d.Removed {
+		gone[name] = true
+	}
+	for _, c := range d.Added {
+		gone[c.Name] = true // replaced below
+	}
+	kept := make([]shared.ModelCapability, 0, len(node.Capabilities)+len(d.Added))
+	for _, c := range node.Capabilities {
+		if !gone[c.Name] {
+			kept = append(kept, c)
+		}
+	}
+	caps = append(kept, d.Added...)
+
+	models = make([]string, 0, len(node.Models)+len(d.Added))
+	for _, m := range node.Models {
+		if !gone[m] {
+			models = append(models, m)
+		}
+	}
+	for _, c := range d.Added {
+		models = append(models, c.Name)
+	}
+
+	node.Capabilities, node.Models = caps, models
+	node.CapabilitySeq = d.Seq
+	node.CapabilitiesChanged = time.Now().UnixMilli()
+	for _, c := range d.Added {
+		log.Printf("[Registry] Node %s capability v%d: %s handles %v (context %d)", nodeID, d.Seq, c.Name, c.Types, c.ContextLength)
+	}
+	for _, name := range d.Removed {
+		log.Printf("[Registry] Node %s capability v%d: %s removed", nodeID, d.Seq, name)
+	}
+	return models, caps, true, nil
+}
+
+// contextLength returns the trained context window node reported for
+// model, 0 if it didn't.
+func contextLength(node *shared.NodeInfo, model string) int {
+	for _, c := range node.Capabilities {
+		if c.Name == model {
+			return c.ContextLength
+		}
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+// orchestrator/capexpiry.go
+// Capabilities that go unconfirmed.
+//
+// A node's capabilities are what it registered with, plus the changes its
+// heartbeats brought (capabilities.go). An agent that can't read its
+// backend's model list, or an older agent that never sends changes, keeps
+// advertising a model someone deleted by hand weeks ago, and routing keeps
+// sending it tasks that fail.
+//
+// So capabilities expire. They're confirmed at registration, and whenever
+// a heartbeat says when the agent last checked its models against the
+// backend, which it does after every good watchdog probe. Once a node's
+// capabilities have gone -capability-ttl unconfirmed they're marked stale, the node
+// turns yellow, and the orchestrator re-validates them itself: it asks
+// the agent's GET /models what the backend has, drops the models that are
+// gone (with a capability_changed event), and confirms the rest. If the
+// agent can't answer, or pulls its work (the orchestrator can't reach
+// pull-mode agents), the node stays stale and is tried again on the next
+// sweep. -capability-ttl 0 never expires them.
+
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// capabilityTTL is how long a node's capabilities stay confirmed; set from
+// -capability-ttl.
+var capabilityTTL = 6 * time.Hour
+
+const (
+	// capabilitySweepInterval is the longest between looks for stale
+	// capabilities; a short -capability-ttl is swept more often.
+	capabilitySweepInterval = time.Minute
+	// revalidateTimeout bounds asking an agent for its models.
+	revalidateTimeout = 10 * time.Second
+)
+
+// revalidating holds the IDs of nodes being re-validated.
+var revalidating sync.Map
+
+// startCapabilitySweeper looks for stale capabilities and re-validates
+// them, unless capabilities never expire.
+func startCapabilitySweeper() {
+	if capabilityTTL <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(min(capabilitySweepInterval, capabilityTTL/3))
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, node := range registry.ExpireCapabilities(time.Now().Add(-capabilityTTL).UnixMilli()) {
+				go revalidateCapabilities(node)
+			}
+		}
+	}()
+}
+
+// confirmCapabilities records that node's models were confirmed installed
+// at (Unix ms), clearing stale unless that's too long ago still. Must be
+// called with the node's shard locked.
+func confirmCapabilities(node *shared.NodeInfo, at int64) {
+	if at <= node.CapabilitiesConfirmed {
+		return
+	}
+	node.CapabilitiesConfirmed = at
+	if node.CapabilitiesStale && (capabilityTTL <= 0 || time.Now().UnixMilli()-at < capabilityTTL.Milliseconds()) {
+		node.CapabilitiesStale = false
+		log.Printf("[Registry] Node %s's capabilities are confirmed again", node.NodeID)
+	}
+}
+
+// ExpireCapabilities marks stale the capabilities of live nodes last
+// confirmed before cutoff (Unix ms), and returns copies of all such nodes
+// as they were before, so CapabilitiesStale tells the newly stale apart.
+func (r *Registry) ExpireCapabilities(cutoff int64) []*shared.NodeInfo {
+	var stale []*shared.NodeInfo
+	for _, s := range r.shards {
+		s.mu.Lock()
+		for _, node := range s.nodes {
+			if !isAlive(node) || node.CapabilitiesConfirmed >= cutoff {
+				continue
+			}
+			copy := *node
+			stale = append(stale, &copy)
+			if !node.CapabilitiesStale {
+				node.CapabilitiesStale = true
+				s.snapshot.Store(nil)
+				log.Printf("[Registry] Node %s's capabilities unconfirmed for %s — re-validating them",
+					node.NodeID, formatUnconfirmed(node, time.Now()))
+			}
+		}
+		s.mu.Unlock()
+	}
+	return stale
+}
+
+// formatUnconfirmed renders how long a node's capabilities have gone
+// unconfirmed, e.g. "6h2m", or "40s" under a minute.
+func formatUnconfirmed(node *shared.NodeInfo, now time.Time) string {
+	d := now.Sub(time.UnixMilli(node.CapabilitiesConfirmed))
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+// Revalidated drops the models a re-validation found gone from a node and
+// confirms the rest, returning its models and capabilities afterwards.
+func (r *Registry) Revalidated(nodeID string, dropped []string) ([]string, []shared.ModelCapability, bool) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, nil, false
+	}
+	for _, name := range dropped {
+		log.Printf("[Registry] Node %s: %s is no longer installed — dropped", nodeID, name)
+	}
+	dropModels(node, dropped)
+	confirmCapabilities(node, time.Now().UnixMilli())
+	return node.Models, node.Capabilities, true
+}
+
+// revalidateCapabilities asks a stale node's agent which models its
+// backend has and drops the others.
+func revalidateCapabilities(node *shared.NodeInfo) {
+	if node.Pull {
+		return
+	}
+	if _, busy := revalidating.LoadOrStore(node.NodeID, true); busy {
+		return
+	}
+	defer revalidating.Delete(node.NodeID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	defer cancel()
+	installed, err := fetchAgentModels(ctx, node)
+	if err != nil {
+		if !node.CapabilitiesStale {
+			log.Printf("[Registry] Node %s: cannot re-validate capabilities (%v) — left stale", node.NodeID, err)
+		}
+		return
+	}
+	var dropped []string
+	for _, m := range node.Models {
+		if !modelInstalled(installed, m) {
+			dropped = append(dropped, m)
+		}
+	}
+	models, caps, ok := registry.Revalidated(node.NodeID, dropped)
+	if ok && len(dropped) > 0 {
+		EmitCapabilityChanged(shared.CapabilityEvent{NodeID: node.NodeID, Removed: dropped, Models: models, Capabilities: caps})
+	}
+}
@@ -0,0 +1,140 @@
+// orchestrator/cbor.go
+// CBOR framing for dashboard events.
+//
+// A dashboard on a slow link — a phone on a mobile network, a browser or
+// WASM client watching a busy mesh — spends most of its bandwidth on the
+// event stream's JSON punctuation and number text. Clients that ask for
+// the echo-mesh.cbor WebSocket subprotocol get each event as one binary
+// message holding the same document in CBOR (RFC 8949): the same keys and
+// values as the JSON event, with numbers as CBOR integers, or as the
+// shortest float that keeps their value. Any CBOR decoder reads it; the
+// dashboard's own is a few dozen lines.
+//
+// Events are marshalled to JSON once as before, and converted from that,
+// so every event — relayed ones from other replicas too — reads the same
+// in both framings. Map keys are written sorted, so the same event always
+// gives the same bytes.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborSimple = 7 << 5
+)
+
+// jsonToCBOR converts a JSON document to CBOR.
+func jsonToCBOR(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := writeCBOR(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCBOR(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(cborSimple | 22)
+	case bool:
+		if v {
+			buf.WriteByte(cborSimple | 21)
+		} else {
+			buf.WriteByte(cborSimple | 20)
+		}
+	case json.Number:
+		return writeCBORNumber(buf, v)
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeCBORHead(buf, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			writeCBORHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+			if err := writeCBOR(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unexpected %T", v)
+	}
+	return nil
+}
+
+// writeCBORNumber writes an integer as one, and anything else as the
+// smallest float that holds it exactly.
+func writeCBORNumber(buf *bytes.Buffer, n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		if i >= 0 {
+			writeCBORHead(buf, cborUint, uint64(i))
+		} else {
+			writeCBORHead(buf, cborNegInt, uint64(-1-i))
+		}
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return err
+	}
+	if f32 := float32(f); float64(f32) == f {
+		buf.WriteByte(cborSimple | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, math.Float32bits(f32)))
+	} else {
+		buf.WriteByte(cborSimple | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	}
+	return nil
+}
+
+// writeCBORHead writes a major type with its argument in the fewest bytes.
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
@@ -0,0 +1,61 @@
+// orchestrator/chunk.go
+// POST /chunk: the text splitters of /summarize and map steps, for clients.
+//
+// Retrieval needs a document embedded chunk by chunk, and the chunks cut
+// the way the mesh cuts them for its own prompts (see shared/chunking).
+// POST /chunk returns them with their estimated sizes, ready to go into an
+// embed task's input. No node is involved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"echo-system/shared"
+	"echo-system/shared/chunking"
+)
+
+const (
+	// minChunkRequestTokens keeps a text from becoming a flood of tiny chunks
+	minChunkRequestTokens = 16
+	// maxChunks caps the chunks of one request
+	maxChunks = 10000
+)
+
+func handleChunk(w http.ResponseWriter, r *http.Request) {
+	var req shared.ChunkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch {
+	case req.ChunkTokens < minChunkRequestTokens:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("chunk_tokens must be at least %d", minChunkRequestTokens))
+		return
+	case !chunking.Valid(req.Split):
+		writeProblem(w, r, http.StatusBadRequest, "split must be one of "+strings.Join(chunking.Methods, ", "))
+		return
+	case req.OverlapTokens < 0 || req.OverlapTokens > req.ChunkTokens/2:
+		writeProblem(w, r, http.StatusBadRequest, "overlap_tokens must be between 0 and half of chunk_tokens")
+		return
+	}
+
+	split := req.Split
+	if split == "" {
+		split = chunking.MethodParagraphs
+	}
+	chunks, _ := chunking.Split(split, req.Text, chunking.Options{MaxTokens: req.ChunkTokens, Overlap: req.OverlapTokens})
+	if len(chunks) > maxChunks {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the text is %d chunks, over the limit of %d; raise chunk_tokens", len(chunks), maxChunks))
+		return
+	}
+	res := shared.ChunkResult{Split: split, Chunks: make([]shared.TextChunk, len(chunks))}
+	for i, chunk := range chunks {
+		res.Chunks[i] = shared.TextChunk{Index: i, Text: chunk, Tokens: shared.EstimateTokens(chunk)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
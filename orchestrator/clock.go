@@ -0,0 +1,61 @@
+// orchestrator/clock.go
+// Clock skew between agents and the orchestrator.
+//
+// Liveness and history only use the orchestrator's clock: heartbeats are
+// stamped when they arrive, and runs, steps and lineage when they happen
+// here. Agents still report times of their own — when a model was pulled,
+// when Ollama will unload it, when the agent last used it — and on a
+// machine whose clock is off those land minutes or days away from the
+// rest. Agents send their clock with every registration and heartbeat; the
+// difference to the orchestrator's at arrival is the node's clock_skew_ms
+// (positive when the agent is ahead; network delay makes it look up to one
+// trip behind, well under the threshold). A node off by more than
+// -max-clock-skew is logged when it registers or drifts past it and graded
+// yellow, and agent times the orchestrator relays are shifted back by the
+// skew, so everything it returns or persists is in orchestrator time.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxClockSkew is the skew above which a node is flagged; set from the
+// -max-clock-skew flag. 0 turns the check off.
+var maxClockSkew = 2 * time.Second
+
+// clockSkew is how far ahead an agent that reported agentMs at nowMs is;
+// 0 when the agent didn't report its clock.
+func clockSkew(agentMs, nowMs int64) int64 {
+	if agentMs == 0 {
+		return 0
+	}
+	return agentMs - nowMs
+}
+
+// skewExceeded reports whether a skew is above -max-clock-skew.
+func skewExceeded(skewMs int64) bool {
+	if skewMs < 0 {
+		skewMs = -skewMs
+	}
+	return maxClockSkew > 0 && skewMs > maxClockSkew.Milliseconds()
+}
+
+// formatSkew describes a skew, e.g. "12.5s ahead".
+func formatSkew(skewMs int64) string {
+	dir := "ahead"
+	if skewMs < 0 {
+		dir, skewMs = "behind", -skewMs
+	}
+	return fmt.Sprintf("%s %s", (time.Duration(skewMs) * time.Millisecond).Round(100*time.Millisecond), dir)
+}
+
+// toOrchestratorTime converts a Unix ms time from an agent with the given
+// skew to orchestrator time; 0 (unset) stays 0.
+func toOrchestratorTime(agentMs, skewMs int64) int64 {
+	if agentMs == 0 {
+		return 0
+	}
+	return agentMs - skewMs
+}
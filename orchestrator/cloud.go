@@ -0,0 +1,335 @@
+// orchestrator/cloud.go
+// Cloud fallback: a virtual node backed by a remote OpenAI-compatible API.
+//
+// With -cloud-url set, tasks that opted in with allow_cloud are sent to the
+// remote API when no local node can serve them — none has the capability,
+// all are overloaded or draining, or every candidate failed. Such results
+// carry routed_to "cloud". Spend is capped per UTC day in tokens
+// (-cloud-daily-tokens); once the cap is reached the fallback refuses
+// tasks until midnight UTC. The day's spend is saved to
+// <data-dir>/cloud_usage.json, so a restart doesn't reset the cap. The API
+// key is read from ECHO_CLOUD_API_KEY so it never shows up in the process
+// list.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// cloudKeyEnv holds the API key for the cloud fallback.
+const cloudKeyEnv = "ECHO_CLOUD_API_KEY"
+
+// cloud is the fallback; disabled unless -cloud-url is set.
+var cloud = &cloudFallback{client: &http.Client{Timeout: 2 * time.Minute}}
+
+// cloudFallback calls an OpenAI-compatible chat completions API and
+// enforces the daily token cap.
+type cloudFallback struct {
+	URL         string // base URL, e.g. https://api.openai.com/v1
+	Model       string
+	DailyTokens int // 0 = no cap
+	MaxTokens   int // completion tokens requested per task
+	apiKey      string
+	client      *http.Client
+
+	mu    sync.Mutex
+	usage shared.CloudUsage
+	path  string // where the day's usage is saved
+}
+
+// init reads the API key and restores the day's usage saved in dataDir;
+// call once after flags are parsed.
+func (c *cloudFallback) init(dataDir string) {
+	c.URL = strings.TrimRight(c.URL, "/")
+	c.apiKey = os.Getenv(cloudKeyEnv)
+	if !c.enabled() {
+		return
+	}
+	c.path = filepath.Join(dataDir, "cloud_usage.json")
+	c.load()
+	if c.apiKey == "" {
+		log.Printf("[Cloud] %s is not set — requests to %s are sent without an API key", cloudKeyEnv, c.URL)
+	}
+	log.Printf("[Cloud] Fallback enabled: %s model=%s daily-tokens=%d", c.URL, c.Model, c.DailyTokens)
+}
+
+func (c *cloudFallback) enabled() bool { return c.URL != "" }
+
+// rollDay resets the counters at the start of a new UTC day. Must be
+// called with c.mu held.
+func (c *cloudFallback) rollDay() {
+	if day := time.Now().UTC().Format("2006-01-02"); c.usage.Day != day {
+		c.usage = shared.CloudUsage{Day: day}
+	}
+}
+
+// load restores the usage saved by a previous process; rollDay drops it if
+// it's from an earlier day.
+func (c *cloudFallback) load() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Cloud] Failed to read %s: %v", c.path, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(raw, &c.usage); err != nil {
+		log.Printf("[Cloud] Ignoring unreadable %s: %v", c.path, err)
+		c.usage = shared.CloudUsage{}
+		return
+	}
+	c.rollDay()
+	if c.usage.Tokens > 0 {
+		log.Printf("[Cloud] Restored today's usage: %d tokens in %d requests", c.usage.Tokens, c.usage.Requests)
+	}
+}
+
+// save persists the day's usage (write temp + rename). Must be called with
+// c.mu held.
+func (c *cloudFallback) save() {
+	if c.path == "" {
+		return
+	}
+	data, _ := json.Marshal(c.usage)
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("[Cloud] Failed to save usage: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		log.Printf("[Cloud] Failed to save usage: %v", err)
+	}
+}
+
+// reserve books an estimated token spend against today's cap, refusing the
+// task if it would exceed it. settle later replaces the estimate with the
+// API's reported usage.
+func (c *cloudFallback) reserve(estimate int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollDay()
+	if c.DailyTokens > 0 && c.usage.Tokens+estimate > c.DailyTokens {
+		c.usage.Refused++
+		c.save()
+		return fmt.Errorf("cloud daily token cap reached (%d of %d used)", c.usage.Tokens, c.DailyTokens)
+	}
+	c.usage.Tokens += estimate
+	c.usage.Requests++
+	c.save()
+	return nil
+}
+
+func (c *cloudFallback) settle(estimate, actual int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollDay()
+	c.usage.Tokens += actual - estimate
+	if c.usage.Tokens < 0 {
+		c.usage.Tokens = 0
+	}
+	c.save()
+}
+
+// Usage returns today's spend.
+func (c *cloudFallback) Usage() shared.CloudUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollDay()
+	u := c.usage
+	u.Enabled = c.enabled()
+	u.Model = c.Model
+	u.DailyTokens = c.DailyTokens
+	return u
+}
+
+// chatRequest / chatResponse are the subset of the OpenAI chat completions
+// schema the fallback uses.
+type chatRequest struct {
+	Model     string        `json:"model"`
+	Messages  []chatMessage `json:"messages"`
+	MaxTokens int           `json:"max_tokens,omitempty"`
+
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+}
+
+// chatResponseFormat {"type": "json_object"} is OpenAI's JSON mode.
+type chatResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// Execute runs a task on the remote API.
+func (c *cloudFallback) Execute(ctx context.Context, req shared.TaskRequest) (*shared.TaskResult, error) {
+	// No agent fetches the files: inline them here
+	if len(req.Files) > 0 {
+		prompt, images, err := expandFiles(req)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) > 0 {
+			return nil, fmt.Errorf("the cloud fallback can't take image files")
+		}
+		req.Prompt = prompt
+	}
+	estimate := shared.EstimateTokens(req.Prompt) + c.MaxTokens
+	if err := c.reserve(estimate); err != nil {
+		return nil, err
+	}
+	actual := 0
+	defer func() { c.settle(estimate, actual) }()
+
+	chatReq := chatRequest{
+		Model:     c.Model,
+		Messages:  []chatMessage{{Role: "user", Content: req.Prompt}},
+		MaxTokens: c.MaxTokens,
+	}
+	if req.Format == shared.FormatJSON {
+		chatReq.ResponseFormat = &chatResponseFormat{Type: "json_object"}
+	}
+	// Of the generation options, those OpenAI shares with Ollama
+	opts := withTypeOptions(req).Options
+	if v, ok := optionFloat(opts, "temperature"); ok {
+		chatReq.Temperature = &v
+	}
+	if v, ok := optionFloat(opts, "top_p"); ok {
+		chatReq.TopP = &v
+	}
+	body, _ := json.Marshal(chatReq)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("cloud API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("cloud API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var chat chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		return nil, fmt.Errorf("failed to decode cloud response: %w", err)
+	}
+	if len(chat.Choices) == 0 {
+		return nil, fmt.Errorf("cloud API returned no choices")
+	}
+
+	result := &shared.TaskResult{
+		TaskID:           req.TaskID,
+		Content:          chat.Choices[0].Message.Content,
+		ModelUsed:        chat.Model,
+		PromptTokens:     chat.Usage.PromptTokens,
+		CompletionTokens: chat.Usage.CompletionTokens,
+	}
+	if result.ModelUsed == "" {
+		result.ModelUsed = c.Model
+	}
+	// Servers that don't report usage are charged by estimate
+	if result.PromptTokens == 0 && result.CompletionTokens == 0 {
+		result.PromptTokens = shared.EstimateTokens(req.Prompt)
+		result.CompletionTokens = shared.EstimateTokens(result.Content)
+	}
+	actual = result.PromptTokens + result.CompletionTokens
+	return result, nil
+}
+
+// routeToCloud serves a task no local node could take. localErr is why
+// local routing failed; it's kept in the error if the cloud fails too.
+func routeToCloud(ctx context.Context, req shared.TaskRequest, localErr error) (*shared.TaskResult, error) {
+	log.Printf("[Cloud] Task %s type=%q → cloud (%v)", req.TaskID, req.Type, localErr)
+	result, err := cloud.Execute(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("no local node (%v) and cloud fallback failed: %w", localErr, err)
+	}
+
+	result.RoutedTo = shared.CloudNodeID
+	result.TaskType = req.Type
+	result.Lineage = req.Lineage
+	result.Source = req.Source
+	result.Metadata = req.Metadata
+	result.Success = true
+
+	EmitTaskRouted(req, shared.CloudNodeID)
+	return result, nil
+}
+
+// streamFromCloud answers a streamed task from the cloud fallback. The
+// remote call isn't streamed: the whole reply arrives as one chunk,
+// followed by the done chunk.
+func streamFromCloud(ctx context.Context, out streamSink, req shared.TaskRequest, localErr error) {
+	startedAt := time.Now()
+	result, err := routeToCloud(ctx, req, localErr)
+	if err != nil {
+		out.fail(req.TaskID, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+
+	chunk := shared.TaskChunk{TaskID: req.TaskID, Token: result.Content, RoutedTo: shared.CloudNodeID}
+	if req.StreamMode == shared.StreamModeFull {
+		chunk.Token, chunk.Text = "", result.Content
+	}
+	out.send("", chunk)
+
+	done := shared.TaskChunk{
+		TaskID:    req.TaskID,
+		Done:      true,
+		RoutedTo:  shared.CloudNodeID,
+		LatencyMs: result.LatencyMs,
+		Metadata:  req.Metadata,
+	}
+	if req.StreamMode == shared.StreamModeFull {
+		done.Text = result.Content
+	}
+	out.send("", done)
+}
+
+// handleCloudUsage reports today's cloud spend.
+// GET /cloud/usage
+func handleCloudUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cloud.Usage())
+}
@@ -0,0 +1,137 @@
+// orchestrator/compress.go
+// Prompt compression by a small local model.
+//
+// On CPU nodes a large model's time to first token grows with the prompt:
+// evaluating a few thousand tokens of pasted context can take longer than
+// the answer. A task with "compress" has a small, fast model (-compress-model
+// or its own "model") condense the prompt to about target_tokens first, and
+// the large model then reads the short version. Compression is best effort:
+// if it fails, or doesn't come out shorter, the task is sent as it was. The
+// result's metadata carries an "echo.compress" note either way.
+//
+// Pipeline steps take the same option for their input (the previous step's
+// output), which is compressed before it goes into the step's template; a
+// step with "compress" and no template is a compress step, whose output is
+// the compressed input.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// compressModel and compressTargetTokens are the defaults for tasks'
+// compress options; set from -compress-model and -compress-target-tokens.
+var (
+	compressModel        string
+	compressTargetTokens = 1024
+)
+
+// compressNoteKey is the result metadata key describing the compression.
+const compressNoteKey = "echo.compress"
+
+// compressInstruction asks for the compressed text; %d is a word budget.
+const compressInstruction = "Compress the text below to at most %d words for another model to work from. " +
+	"Keep every instruction and question as written, and all facts, names, numbers, code and constraints; " +
+	"drop repetition, filler and formatting. Reply with the compressed text only.\n\n%s"
+
+// checkCompress rejects invalid compress options.
+func checkCompress(opts *shared.CompressOptions) error {
+	if opts != nil && opts.TargetTokens < 0 {
+		return fmt.Errorf("compress.target_tokens must not be negative")
+	}
+	return nil
+}
+
+// compressText condenses text with task, a summarize task routed like any
+// other (it shows up on the dashboard and in stats); task's ID, metadata
+// and cloud opt-in are kept. Text already within the target comes back
+// as is, with no result.
+func compressText(ctx context.Context, task shared.TaskRequest, text string, opts shared.CompressOptions) (string, *shared.TaskResult, error) {
+	target := opts.TargetTokens
+	if target == 0 {
+		target = compressTargetTokens
+	}
+	before := shared.EstimateTokens(text)
+	if before <= target {
+		return text, nil, nil
+	}
+
+	task.Type = shared.TaskTypeSummarize
+	task.ModelHint = opts.Model
+	if task.ModelHint == "" {
+		task.ModelHint = compressModel
+	}
+	task.Prompt = fmt.Sprintf(compressInstruction, target*3/4, text)
+
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, task, nil)
+	if err != nil {
+		return text, nil, err
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+
+	compressed := strings.TrimSpace(result.Content)
+	if compressed == "" || shared.EstimateTokens(compressed) >= before {
+		return text, result, fmt.Errorf("%s on %s returned nothing shorter", modelOrDefault(result.ModelUsed), result.RoutedTo)
+	}
+	return compressed, result, nil
+}
+
+// compressPrompt applies a task's compress option to its prompt and notes
+// the outcome in its metadata.
+func compressPrompt(ctx context.Context, req *shared.TaskRequest) {
+	if req.Compress == nil {
+		return
+	}
+	before := shared.EstimateTokens(req.Prompt)
+	task := shared.TaskRequest{
+		TaskID:     uuid.New().String(),
+		AllowCloud: req.AllowCloud,
+		Source:     req.Source,
+		Metadata:   map[string]string{"echo.compress_for": req.TaskID},
+	}
+	compressed, result, err := compressText(ctx, task, req.Prompt, *req.Compress)
+	var note string
+	switch {
+	case err != nil:
+		note = fmt.Sprintf("compression failed (%v), sent uncompressed", err)
+	case result == nil:
+		return // already within the target
+	default:
+		note = fmt.Sprintf("compressed ~%d → ~%d tokens with %s on %s in %dms",
+			before, shared.EstimateTokens(compressed), result.ModelUsed, result.RoutedTo, result.LatencyMs)
+		req.Prompt = compressed
+	}
+	log.Printf("[Compress] Task %s: %s", req.TaskID, note)
+
+	metadata := make(map[string]string, len(req.Metadata)+1)
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+	metadata[compressNoteKey] = note
+	req.Metadata = metadata
+}
+
+// runCompressStep runs a pipeline compress step as taskReq: its output is
+// the compressed input, or the input itself when that's within the target.
+func runCompressStep(ctx context.Context, taskReq shared.TaskRequest, input string, opts shared.CompressOptions) (*shared.TaskResult, error) {
+	compressed, result, err := compressText(ctx, taskReq, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return &shared.TaskResult{TaskID: taskReq.TaskID, Content: input, Success: true, Metadata: taskReq.Metadata}, nil
+	}
+	result.Content = compressed
+	return result, nil
+}
@@ -0,0 +1,278 @@
+// orchestrator/context.go
+// Context shaping for chat-style tasks.
+//
+// A task may carry a conversation in Messages instead of a single prompt.
+// Before dispatch the orchestrator fits it into the target model's context
+// window: system messages and as many recent turns as fit are kept
+// verbatim, older turns are summarized by a summarize-capable node and the
+// summary is injected after the system messages. If summarization fails the
+// older turns are dropped instead; older turns too long for the summarizing
+// model's window are cut between turns, and only the latest that fit are
+// summarized. Either way the result's metadata carries
+// an "echo.context" note saying what was done. The conversation is then
+// flattened into Prompt, so agents need no changes.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+	"echo-system/shared/chunking"
+)
+
+// contextWindow is the default context window in tokens; contextWindows
+// overrides it per model. Set from -context-window and -context-windows.
+var (
+	contextWindow  = 4096
+	contextWindows = map[string]int{}
+)
+
+const (
+	// contextReplyShare: 1/N of the window is left free for the reply
+	contextReplyShare = 4
+	// contextSummaryShare: the summary may take 1/N of the prompt budget
+	contextSummaryShare = 5
+)
+
+// contextNoteKey is the result metadata key describing any shaping done.
+const contextNoteKey = "echo.context"
+
+// parseContextWindows parses -context-windows ("mistral:8192,llama3:8192").
+func parseContextWindows(flag string) (map[string]int, error) {
+	windows := make(map[string]int)
+	for _, entry := range strings.Split(flag, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		// Model names may contain ':' (llama3:70b), so split on the last one
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -context-windows entry %q (want model:tokens)", entry)
+		}
+		n, err := strconv.Atoi(entry[i+1:])
+		if err != nil || n < 256 {
+			return nil, fmt.Errorf("invalid context window in %q (want at least 256 tokens)", entry)
+		}
+		windows[entry[:i]] = n
+	}
+	return windows, nil
+}
+
+// targetWindow predicts the model a task will run on and returns its
+// context window, no larger than the trained context length the node
+// reports for it. When no node is available the default is used.
+func targetWindow(ctx context.Context, req shared.TaskRequest) (int, string) {
+	model := req.ModelHint
+	node, err := selectNode(ctx, req, nil)
+	if err != nil {
+		return windowFor(model), model
+	}
+	model = expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality)
+	window := windowFor(model)
+	if n := contextLength(node, model); n > 0 && n < window {
+		window = n
+	}
+	return window, model
+}
+
+// windowFor returns a model's context window.
+func windowFor(model string) int {
+	if w, ok := contextWindows[model]; ok {
+		return w
+	}
+	return contextWindow
+}
+
+// promptBudget is the share of a window a prompt may take, leaving room
+// for the reply.
+func promptBudget(window int) int {
+	return window - window/contextReplyShare
+}
+
+// shapeContext fits req.Messages (plus req.Prompt, taken as the latest user
+// turn) into the target model's window and flattens them into req.Prompt,
+// which replaces them.
+func shapeContext(ctx context.Context, req *shared.TaskRequest) error {
+	msgs := req.Messages
+	if req.Prompt != "" {
+		msgs = append(msgs[:len(msgs):len(msgs)], shared.ChatMessage{Role: "user", Content: req.Prompt})
+	}
+
+	var system, turns []shared.ChatMessage
+	for i, m := range msgs {
+		switch m.Role {
+		case "system":
+			system = append(system, m)
+		case "user", "assistant":
+			turns = append(turns, m)
+		default:
+			return fmt.Errorf("messages[%d]: unknown role %q (want system, user or assistant)", i, m.Role)
+		}
+	}
+	if len(turns) == 0 {
+		return fmt.Errorf("messages must contain at least one user or assistant turn")
+	}
+
+	window, model := targetWindow(ctx, *req)
+	budget := promptBudget(window)
+	before := messagesTokens(system) + messagesTokens(turns)
+	req.Messages = nil
+	if before <= budget {
+		req.Prompt = flattenMessages(system, turns)
+		return nil
+	}
+	keep, summaryBudget := splitTurns(system, turns, budget)
+	older, recent := turns[:keep], turns[keep:]
+
+	action := "truncated"
+	if len(older) > 0 {
+		summary, err := summarizeTurns(ctx, *req, older, summaryBudget)
+		if err != nil {
+			log.Printf("[Context] Task %s: summarizing %d turns failed (%v) — dropping them", req.TaskID, len(older), err)
+		} else {
+			action = "summarized"
+			system = append(system, summaryMessage(summary))
+		}
+	}
+	req.Prompt = flattenMessages(system, recent)
+
+	note := fmt.Sprintf("%s %d of %d turns (~%d → ~%d tokens, window %d for %s)",
+		action, len(older), len(turns), before, shared.EstimateTokens(req.Prompt), window, modelOrDefault(model))
+	log.Printf("[Context] Task %s: %s", req.TaskID, note)
+	metadata := make(map[string]string, len(req.Metadata)+1)
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+	metadata[contextNoteKey] = note
+	req.Metadata = metadata
+	return nil
+}
+
+// splitTurns finds how many of the oldest turns to summarize so that the
+// system messages, a summary of summaryBudget tokens and the remaining
+// turns fit in budget; the latest turn is always kept.
+func splitTurns(system, turns []shared.ChatMessage, budget int) (keep, summaryBudget int) {
+	summaryBudget = budget / contextSummaryShare
+	avail := budget - messagesTokens(system) - summaryBudget
+	keep, used := len(turns), 0
+	for keep > 0 {
+		n := messageTokens(turns[keep-1])
+		if used+n > avail && keep < len(turns) {
+			break
+		}
+		used += n
+		keep--
+	}
+	return keep, summaryBudget
+}
+
+// summarizeTurns condenses older turns with a summarize task routed like
+// any other (it shows up on the dashboard and in stats).
+func summarizeTurns(ctx context.Context, parent shared.TaskRequest, turns []shared.ChatMessage, maxTokens int) (string, error) {
+	words := maxTokens * 3 / 4
+	text := flattenTurns(turns)
+	if budget := summaryPromptBudget(ctx, "", parent.Language); shared.EstimateTokens(text) > budget {
+		text = latestChunks(chunking.Paragraphs(text, chunking.Options{MaxTokens: budget}), budget)
+		log.Printf("[Context] Task %s: older turns over the summarizer's %d-token budget — summarizing the latest ~%d tokens",
+			parent.TaskID, budget, shared.EstimateTokens(text))
+	}
+	req := shared.TaskRequest{
+		TaskID: uuid.New().String(),
+		Type:   shared.TaskTypeSummarize,
+		Prompt: fmt.Sprintf("Summarize the following conversation in at most %d words. "+
+			"Keep facts, decisions, names, numbers and open questions; drop pleasantries.\n\n%s",
+			words, text),
+		AllowCloud: parent.AllowCloud,
+		Source:     parent.Source,
+		Metadata:   map[string]string{"echo.context_for": parent.TaskID},
+	}
+
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, req, nil)
+	if err != nil {
+		return "", err
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+
+	summary := strings.TrimSpace(result.Content)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary from %s", result.RoutedTo)
+	}
+	return summary, nil
+}
+
+// latestChunks joins the last chunks that fit in budget together.
+func latestChunks(chunks []string, budget int) string {
+	first, used := len(chunks)-1, shared.EstimateTokens(chunks[len(chunks)-1])
+	for first > 0 {
+		n := shared.EstimateTokens(chunks[first-1])
+		if used+n > budget {
+			break
+		}
+		used += n
+		first--
+	}
+	return strings.Join(chunks[first:], "\n\n")
+}
+
+// summaryMessage injects a summary of older turns after the system
+// messages.
+func summaryMessage(summary string) shared.ChatMessage {
+	return shared.ChatMessage{Role: "system", Content: "Summary of the earlier conversation:\n" + summary}
+}
+
+// flattenMessages renders a conversation as a single prompt ending with an
+// assistant cue.
+func flattenMessages(system, turns []shared.ChatMessage) string {
+	var b strings.Builder
+	for _, m := range system {
+		b.WriteString("System: " + m.Content + "\n\n")
+	}
+	b.WriteString(flattenTurns(turns))
+	b.WriteString("\n\nAssistant:")
+	return b.String()
+}
+
+func flattenTurns(turns []shared.ChatMessage) string {
+	parts := make([]string, len(turns))
+	for i, m := range turns {
+		role := "User"
+		switch m.Role {
+		case "assistant":
+			role = "Assistant"
+		case "system":
+			role = "System"
+		}
+		parts[i] = role + ": " + m.Content
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// messageTokens estimates a turn's tokens, including its role label.
+func messageTokens(m shared.ChatMessage) int {
+	return shared.EstimateTokens(m.Content) + 4
+}
+
+func messagesTokens(msgs []shared.ChatMessage) int {
+	n := 0
+	for _, m := range msgs {
+		n += messageTokens(m)
+	}
+	return n
+}
+
+func modelOrDefault(model string) string {
+	if model == "" {
+		return "the default model"
+	}
+	return model
+}
@@ -0,0 +1,542 @@
+// orchestrator/conversations.go
+// Conversations kept by the orchestrator, with model pinning and switching.
+//
+// POST /conversations starts a chat; each turn posted to it runs as a task
+// over the history so far, fitted into the model's window as in context.go,
+// and the answer is appended with the task, model and node that produced
+// it. A conversation may be pinned to a model (its turns carry it as
+// model_hint) and/or a node (its turns go there or fail, as target_node),
+// so a long chat keeps the model that has its context warm.
+//
+// POST /conversations/{id}/switch moves it to another model midway. When
+// the new model's window is smaller than the old one's and the history no
+// longer fits, the older turns are summarized once, at the switch, and the
+// summary is sent in their place from then on; the turns themselves stay
+// in the history. Every pin, unpin and switch is recorded in the
+// conversation's changes, at the message it happened.
+//
+// Conversations are saved to <data-dir>/conversations/<id>.json after every
+// change; ones untouched for conversationRetention are dropped at startup.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+const conversationRetention = 30 * 24 * time.Hour
+
+// conversationMetaKey tags a turn's task with its conversation.
+const conversationMetaKey = "echo.conversation"
+
+var conversations *ConversationStore
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	errConversationBusy     = errors.New("conversation has a turn or switch in progress; retry once it's done")
+)
+
+// ConversationStore keeps conversations in memory and mirrors them to disk.
+type ConversationStore struct {
+	mu    sync.Mutex
+	dir   string
+	convs map[string]*shared.Conversation
+	busy  map[string]bool // a turn or switch is running
+}
+
+// NewConversationStore opens (or creates) the conversations directory under
+// dataDir and loads the conversations saved there.
+func NewConversationStore(dataDir string) (*ConversationStore, error) {
+	dir := filepath.Join(dataDir, "conversations")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create conversations dir: %w", err)
+	}
+	s := &ConversationStore{
+		dir:   dir,
+		convs: make(map[string]*shared.Conversation),
+		busy:  make(map[string]bool),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read conversations dir: %w", err)
+	}
+	oldest := time.Now().Add(-conversationRetention).UnixMilli()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[Conversations] Skipping %s: %v", path, err)
+			continue
+		}
+		var c shared.Conversation
+		if err := json.Unmarshal(raw, &c); err != nil || c.ConversationID == "" {
+			log.Printf("[Conversations] Skipping corrupt %s", path)
+			continue
+		}
+		if c.UpdatedAt < oldest {
+			os.Remove(path)
+			continue
+		}
+		s.convs[c.ConversationID] = &c
+	}
+	if len(s.convs) > 0 {
+		log.Printf("[Conversations] Loaded %d conversations from %s", len(s.convs), dir)
+	}
+	return s, nil
+}
+
+// cloneConversation copies c deeply enough to be changed outside the lock.
+func cloneConversation(c *shared.Conversation) *shared.Conversation {
+	cp := *c
+	cp.Messages = slices.Clone(c.Messages)
+	cp.Changes = slices.Clone(c.Changes)
+	return &cp
+}
+
+// get returns a copy of a conversation.
+func (s *ConversationStore) get(id string) (*shared.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[id]
+	if !ok {
+		return nil, errConversationNotFound
+	}
+	return cloneConversation(c), nil
+}
+
+// begin marks a conversation busy for a turn or switch and returns a copy
+// to work on; end (or put) releases it.
+func (s *ConversationStore) begin(id string) (*shared.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[id]
+	if !ok {
+		return nil, errConversationNotFound
+	}
+	if s.busy[id] {
+		return nil, errConversationBusy
+	}
+	s.busy[id] = true
+	return cloneConversation(c), nil
+}
+
+// end releases a conversation taken with begin, unchanged.
+func (s *ConversationStore) end(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.busy, id)
+}
+
+// put stores a new or changed conversation, releases it and saves it.
+// A conversation deleted while it was busy stays deleted.
+func (s *ConversationStore) put(c *shared.Conversation, created bool) error {
+	c.UpdatedAt = time.Now().UnixMilli()
+	s.mu.Lock()
+	delete(s.busy, c.ConversationID)
+	if _, ok := s.convs[c.ConversationID]; !ok && !created {
+		s.mu.Unlock()
+		return errConversationNotFound
+	}
+	s.convs[c.ConversationID] = cloneConversation(c)
+	data, err := json.MarshalIndent(c, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, c.ConversationID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// remove deletes a conversation, returning it.
+func (s *ConversationStore) remove(id string) (*shared.Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[id]
+	if !ok {
+		return nil, false
+	}
+	delete(s.convs, id)
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[Conversations] Failed to remove %s: %v", id, err)
+	}
+	return c, true
+}
+
+// ─── Prompting ────────────────────────────────────────────────────────────────
+
+// promptParts splits a conversation into what its next task is sent: the
+// system messages, then the turns after the summary with their indexes in
+// Messages. The summary itself is left out.
+func promptParts(c *shared.Conversation) (system, turns []shared.ChatMessage, index []int) {
+	for i, m := range c.Messages {
+		msg := shared.ChatMessage{Role: m.Role, Content: m.Content}
+		switch {
+		case m.Role == "system":
+			system = append(system, msg)
+		case i >= c.SummaryThrough:
+			turns = append(turns, msg)
+			index = append(index, i)
+		}
+	}
+	return system, turns, index
+}
+
+// promptMessages is the history a conversation's next turn is sent with.
+func promptMessages(c *shared.Conversation) []shared.ChatMessage {
+	system, turns, _ := promptParts(c)
+	if c.Summary != "" {
+		system = append(system, summaryMessage(c.Summary))
+	}
+	return append(system, turns...)
+}
+
+// currentModel is the model a conversation runs on: its pin, else the one
+// that gave the last answer.
+func currentModel(c *shared.Conversation) string {
+	if c.Model != "" {
+		return c.Model
+	}
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if m := c.Messages[i]; m.Role == "assistant" && m.Model != "" {
+			return m.Model
+		}
+	}
+	return ""
+}
+
+// ─── Pinning and switching ────────────────────────────────────────────────────
+
+// checkPin checks that a pin can be served: the node is registered and,
+// when both are pinned, has the model.
+func checkPin(model, nodeID string) error {
+	if nodeID == "" {
+		return nil
+	}
+	node, err := registry.GetNode(nodeID)
+	if err != nil {
+		return fmt.Errorf("node %s is not registered", nodeID)
+	}
+	if model != "" && !slices.Contains(node.Models, model) {
+		return fmt.Errorf("node %s doesn't have model %s", nodeID, model)
+	}
+	return nil
+}
+
+// repin changes a conversation's pin and records the change. When the
+// model changes to one with a smaller window that the history no longer
+// fits, the older turns are summarized first; if that fails the switch
+// still happens and each turn is shaped on its own, as for any task.
+func repin(ctx context.Context, c *shared.Conversation, kind shared.ConversationChangeKind, model, nodeID string, source *shared.TaskSource) {
+	change := shared.ConversationChange{
+		Kind:      kind,
+		At:        time.Now().UnixMilli(),
+		AtMessage: len(c.Messages),
+		FromModel: currentModel(c),
+		Model:     model,
+		FromNode:  c.NodeID,
+		NodeID:    nodeID,
+	}
+	c.Model, c.NodeID = model, nodeID
+
+	if model != "" && model != change.FromModel {
+		from, to := windowFor(change.FromModel), windowFor(model)
+		if to < from {
+			change.Summarized, change.Note = resummarize(ctx, c, to, source)
+		}
+	}
+	c.Changes = append(c.Changes, change)
+	log.Printf("[Conversations] %s: %s %s → %s%s", c.ConversationID, kind,
+		pinLabel(change.FromModel, change.FromNode), pinLabel(model, nodeID), noteSuffix(change.Note))
+}
+
+// resummarize fits a conversation's history into window by summarizing
+// its older turns, together with any earlier summary. It returns how many
+// turns were summarized and a note on what was done. The summarize task is
+// attributed to source.
+func resummarize(ctx context.Context, c *shared.Conversation, window int, source *shared.TaskSource) (int, string) {
+	system, turns, index := promptParts(c)
+	budget := promptBudget(window)
+	before := messagesTokens(system) + messagesTokens(turns)
+	if c.Summary != "" {
+		before += messageTokens(summaryMessage(c.Summary))
+	}
+	if before <= budget {
+		return 0, ""
+	}
+	keep, summaryBudget := splitTurns(system, turns, budget)
+	if keep == 0 {
+		return 0, fmt.Sprintf("~%d tokens is over the %d-token window, but only the latest turn is left to keep", before, window)
+	}
+
+	older := turns[:keep]
+	if c.Summary != "" {
+		older = append([]shared.ChatMessage{summaryMessage(c.Summary)}, older...)
+	}
+	parent := shared.TaskRequest{TaskID: c.ConversationID, Source: source}
+	summary, err := summarizeTurns(ctx, parent, older, summaryBudget)
+	if err != nil {
+		return 0, fmt.Sprintf("summarizing %d turns failed (%v); turns are shaped one by one instead", keep, err)
+	}
+	c.Summary, c.SummaryThrough = summary, index[keep]
+	return keep, fmt.Sprintf("summarized %d turns (~%d tokens) to fit the %d-token window", keep, before, window)
+}
+
+// pinLabel names a pin for log lines.
+func pinLabel(model, nodeID string) string {
+	switch {
+	case model == "" && nodeID == "":
+		return "unpinned"
+	case nodeID == "":
+		return model
+	case model == "":
+		return "node " + nodeID
+	}
+	return model + " on " + nodeID
+}
+
+func noteSuffix(note string) string {
+	if note == "" {
+		return ""
+	}
+	return " (" + note + ")"
+}
+
+// ─── Client: POST /conversations ──────────────────────────────────────────────
+
+func handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	var req shared.ConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := checkPin(req.Model, req.NodeID); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	now := time.Now().UnixMilli()
+	c := &shared.Conversation{
+		ConversationID: uuid.New().String(),
+		Type:           req.Type,
+		Messages:       []shared.ConversationMessage{},
+		CreatedAt:      now,
+	}
+	for i, m := range req.Messages {
+		if m.Role != "system" && m.Role != "user" && m.Role != "assistant" {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("messages[%d]: unknown role %q (want system, user or assistant)", i, m.Role))
+			return
+		}
+		c.Messages = append(c.Messages, shared.ConversationMessage{Role: m.Role, Content: m.Content, At: now})
+	}
+	if req.Model != "" || req.NodeID != "" {
+		repin(r.Context(), c, shared.ConversationPin, req.Model, req.NodeID, requestSource(r))
+	}
+	if err := conversations.put(c, true); err != nil {
+		log.Printf("[Conversations] Failed to save %s: %v", c.ConversationID, err)
+	}
+	shared.WriteJSON(w, r, http.StatusCreated, c, compressMinBytes)
+}
+
+// ─── Client: GET / DELETE /conversations/{id} ─────────────────────────────────
+
+func handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	c, err := conversations.get(r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	shared.WriteJSON(w, r, http.StatusOK, c, compressMinBytes)
+}
+
+func handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	c, ok := conversations.remove(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, errConversationNotFound.Error())
+		return
+	}
+	shared.WriteJSON(w, r, http.StatusOK, c, compressMinBytes)
+}
+
+// ─── Client: POST /conversations/{id}/messages ────────────────────────────────
+// Runs the next user turn and answers with its TaskResult. The turn and
+// its answer are added to the history once the task succeeds (or returns
+// a partial answer); a failed turn leaves the history as it was.
+
+func handleConversationTurn(w http.ResponseWriter, r *http.Request) {
+	var turn shared.ConversationTurn
+	if err := json.NewDecoder(r.Body).Decode(&turn); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(turn.Content) == "" {
+		writeProblem(w, r, http.StatusBadRequest, "content is required")
+		return
+	}
+	if err := checkFormat(turn.Format); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkPromptSize(turn.Content); err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err := checkMetadata(turn.Metadata); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c, ok := beginConversation(w, r)
+	if !ok {
+		return
+	}
+	metadata := map[string]string{conversationMetaKey: c.ConversationID}
+	for k, v := range turn.Metadata {
+		metadata[k] = v
+	}
+	req := shared.TaskRequest{
+		TaskID:     uuid.New().String(),
+		Prompt:     turn.Content,
+		Type:       c.Type,
+		ModelHint:  c.Model,
+		TargetNode: c.NodeID,
+		Format:     turn.Format,
+		Options:    turn.Options,
+		Messages:   promptMessages(c),
+		AllowCloud: turn.AllowCloud,
+		Source:     requestSource(r),
+		Metadata:   metadata,
+	}
+	askedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), taskTimeout)
+	defer cancel()
+	if err := shapeContext(ctx, &req); err != nil {
+		conversations.end(c.ConversationID)
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, led, err := runTask(ctx, req)
+	if led {
+		taskHistory.record(req, result, err, askedAt)
+	}
+	if err != nil && led {
+		deadLetters.Add(req, err)
+	}
+	if err != nil && !errors.Is(err, errPartialResult) {
+		conversations.end(c.ConversationID)
+		status := http.StatusServiceUnavailable
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		writeTaskProblem(w, r, status, req.TaskID, fmt.Sprintf("all nodes failed: %v", err))
+		return
+	}
+	result.LatencyMs = time.Since(askedAt).Milliseconds()
+	if led {
+		EmitTaskDone(result)
+		mirror.MaybeMirror(req, result)
+	}
+
+	if result.Success || result.Partial {
+		c.Messages = append(c.Messages,
+			shared.ConversationMessage{Role: "user", Content: turn.Content, At: askedAt.UnixMilli()},
+			shared.ConversationMessage{Role: "assistant", Content: result.Content, At: time.Now().UnixMilli(),
+				TaskID: result.TaskID, Model: result.ModelUsed, NodeID: result.RoutedTo})
+		if err := conversations.put(c, false); err != nil {
+			log.Printf("[Conversations] Failed to save %s: %v", c.ConversationID, err)
+		}
+	} else {
+		conversations.end(c.ConversationID)
+	}
+	shared.WriteJSON(w, r, http.StatusOK, result, compressMinBytes)
+}
+
+// beginConversation takes the request's conversation for a turn or
+// switch, answering the request itself when it can't.
+func beginConversation(w http.ResponseWriter, r *http.Request) (*shared.Conversation, bool) {
+	c, err := conversations.begin(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errConversationNotFound):
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return nil, false
+	case err != nil:
+		writeProblem(w, r, http.StatusConflict, err.Error())
+		return nil, false
+	}
+	return c, true
+}
+
+// ─── Client: PUT / DELETE /conversations/{id}/pin, POST .../switch ────────────
+
+// handleConversationPin handles PUT (pin: replace the pin with the body's
+// model and/or node), DELETE (unpin) and POST .../switch (move to the
+// body's model, keeping the pinned node unless the body names another).
+func handleConversationPin(kind shared.ConversationChangeKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var pin shared.ConversationPinRequest
+		if kind != shared.ConversationUnpin {
+			if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+				return
+			}
+		}
+		switch {
+		case kind == shared.ConversationPin && pin.Model == "" && pin.NodeID == "":
+			writeProblem(w, r, http.StatusBadRequest, "model or node_id is required")
+			return
+		case kind == shared.ConversationSwitch && pin.Model == "":
+			writeProblem(w, r, http.StatusBadRequest, "model is required")
+			return
+		}
+
+		c, ok := beginConversation(w, r)
+		if !ok {
+			return
+		}
+		if kind == shared.ConversationSwitch && pin.NodeID == "" {
+			pin.NodeID = c.NodeID
+		}
+		if err := checkPin(pin.Model, pin.NodeID); err != nil {
+			conversations.end(c.ConversationID)
+			if kind == shared.ConversationSwitch && pin.NodeID == c.NodeID {
+				err = fmt.Errorf("%v; name another node_id or unpin the node first", err)
+			}
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), taskTimeout)
+		defer cancel()
+		repin(ctx, c, kind, pin.Model, pin.NodeID, requestSource(r))
+		if err := conversations.put(c, false); err != nil {
+			if errors.Is(err, errConversationNotFound) {
+				writeProblem(w, r, http.StatusNotFound, err.Error())
+				return
+			}
+			log.Printf("[Conversations] Failed to save %s: %v", c.ConversationID, err)
+		}
+		shared.WriteJSON(w, r, http.StatusOK, c, compressMinBytes)
+	}
+}
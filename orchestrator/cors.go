@@ -0,0 +1,210 @@
+// orchestrator/cors.go
+// Letting browser apps on other origins call the API.
+//
+// A page served from elsewhere than the orchestrator — a web app, a
+// notebook, a dashboard of one's own — may only read the API's answers if
+// they carry CORS headers for its origin, and may only send a JSON body,
+// an Authorization or a Last-Event-ID header once a preflight OPTIONS
+// request says it can. -cors-origins lists the origins allowed, exactly or with a
+// "*." subdomain wildcard; -cors-headers the request headers they may send,
+// to which the -pass-headers are added. Preflights are answered for every
+// route with the methods it really has, so a misspelt path or a header
+// that isn't allowed comes back as a problem rather than an opaque
+// browser error.
+//
+// Browsers don't preflight WebSockets, so GET /ws checks the origin
+// itself: pages on the orchestrator's own host (the dashboard) and the
+// -cors-origins may open it; clients that send no Origin aren't browsers
+// and may too.
+//
+//	-cors-origins "https://app.example.com,https://*.tailnet.example,http://localhost:3000"
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set from the -cors-origins, -cors-headers and -cors-max-age flags.
+var (
+	corsOrigins []string      // "*" allows any origin; empty sends no CORS headers
+	corsHeaders []string      // request headers preflights allow, besides the -pass-headers
+	corsMaxAge  time.Duration // how long browsers may cache a preflight's answer
+)
+
+// corsExposed are the response headers scripts on other origins may read.
+const corsExposed = "Retry-After, ETag, Content-Disposition"
+
+// corsMethods are the methods routes are registered with, in the order
+// preflights list them.
+var corsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// parseCORSOrigins parses the -cors-origins flag value: "*", or origins
+// such as "https://app.example.com" whose host may start with "*." to
+// allow its subdomains.
+func parseCORSOrigins(flag string) ([]string, error) {
+	var origins []string
+	for _, o := range strings.Split(flag, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o == "" {
+			continue
+		}
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("invalid origin %q (want scheme://host[:port], e.g. https://app.example.com)", o)
+			}
+			if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+				return nil, fmt.Errorf("invalid origin %q: only a leading *. wildcard is allowed", o)
+			}
+		}
+		origins = append(origins, strings.ToLower(o))
+	}
+	return origins, nil
+}
+
+// parseCORSHeaders parses the -cors-headers flag value: header names, or
+// "*" for any.
+func parseCORSHeaders(flag string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(flag, ",") {
+		switch name = strings.TrimSpace(name); {
+		case name == "":
+		case name == "*":
+			names = append(names, name)
+		case strings.ContainsAny(name, " \t:*"):
+			return nil, fmt.Errorf("%q is not a header name", name)
+		default:
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names, nil
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin for a request's
+// origin; "" when it isn't allowed.
+func corsOrigin(origin string) string {
+	origin = strings.ToLower(origin)
+	for _, o := range corsOrigins {
+		switch {
+		case o == "*":
+			return "*"
+		case origin == "":
+		case o == origin, originMatches(o, origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// originMatches reports whether origin is a subdomain allowed by a
+// "scheme://*.domain" pattern.
+func originMatches(pattern, origin string) bool {
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	rest, ok := strings.CutPrefix(origin, scheme+"://")
+	return ok && strings.HasSuffix(rest, "."+host)
+}
+
+// corsAllowedHeaders returns the request headers preflights allow.
+func corsAllowedHeaders() []string {
+	return append(append([]string(nil), corsHeaders...), passHeaderNames...)
+}
+
+// headerAllowed reports whether a page may send the named request header.
+func headerAllowed(name string) bool {
+	for _, h := range corsAllowedHeaders() {
+		if h == "*" || strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods lists the methods the mux serves r's path with.
+func (m *apiMux) routeMethods(r *http.Request) []string {
+	var methods []string
+	for _, method := range corsMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := m.ServeMux.Handler(probe); pattern != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// withCORS lets pages from -cors-origins call the API, answering their
+// preflight requests itself.
+func (m *apiMux) withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		allow := corsOrigin(origin)
+		if allow == "" {
+			if preflight && origin != "" && len(corsOrigins) > 0 {
+				writeProblem(w, r, http.StatusForbidden, fmt.Sprintf("origin %s is not allowed by -cors-origins", origin))
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr := w.Header()
+		hdr.Set("Access-Control-Allow-Origin", allow)
+		if allow != "*" {
+			hdr.Add("Vary", "Origin")
+		}
+		if !preflight {
+			hdr.Set("Access-Control-Expose-Headers", corsExposed)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		methods := m.routeMethods(r)
+		if len(methods) == 0 {
+			writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("no route for %s", r.URL.Path))
+			return
+		}
+		for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if name = strings.TrimSpace(name); name != "" && !headerAllowed(name) {
+				writeProblem(w, r, http.StatusForbidden, fmt.Sprintf("request header %s is not allowed by -cors-headers", name))
+				return
+			}
+		}
+		hdr.Set("Access-Control-Allow-Methods", strings.Join(append(methods, http.MethodOptions), ", "))
+		if asked := r.Header.Get("Access-Control-Request-Headers"); asked != "" {
+			hdr.Set("Access-Control-Allow-Headers", asked)
+		}
+		hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// ─── WebSocket origins ────────────────────────────────────────────────────────
+
+// wsOriginAllowed reports whether a page on r's origin may open GET /ws.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || corsOrigin(origin) != "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if publicURL != "" {
+		if pu, err := url.Parse(publicURL); err == nil && strings.EqualFold(u.Host, pu.Host) {
+			return true
+		}
+	}
+	return false
+}
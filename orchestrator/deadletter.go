@@ -0,0 +1,112 @@
+// orchestrator/deadletter.go
+// Dead-letter queue for tasks that failed on every node.
+//
+// When routeWithFailover runs out of nodes (or a pipeline step fails) the
+// request is kept here so an operator can inspect it and retry it from the
+// admin panel once the mesh has recovered. The queue is in-memory and
+// bounded; the oldest entries are dropped first.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// deadLetterCapacity bounds the queue.
+const deadLetterCapacity = 200
+
+var deadLetters = &DeadLetterQueue{}
+
+// DeadLetterQueue holds failed tasks, oldest first.
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	entries []*shared.DeadLetter
+}
+
+// Add records a task that couldn't be served.
+func (q *DeadLetterQueue) Add(req shared.TaskRequest, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries = append(q.entries, &shared.DeadLetter{
+		ID:       uuid.New().String(),
+		Request:  req,
+		Error:    err.Error(),
+		Attempts: 1,
+		FailedAt: time.Now().UnixMilli(),
+	})
+	if len(q.entries) > deadLetterCapacity {
+		q.entries = q.entries[len(q.entries)-deadLetterCapacity:]
+	}
+	log.Printf("[DLQ] Task %s dead-lettered: %v", req.TaskID, err)
+}
+
+// List returns copies of all entries, newest first.
+func (q *DeadLetterQueue) List() []shared.DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]shared.DeadLetter, 0, len(q.entries))
+	for i := len(q.entries) - 1; i >= 0; i-- {
+		list = append(list, *q.entries[i])
+	}
+	return list
+}
+
+// Get returns a copy of one entry.
+func (q *DeadLetterQueue) Get(id string) (shared.DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, e := range q.entries {
+		if e.ID == id {
+			return *e, true
+		}
+	}
+	return shared.DeadLetter{}, false
+}
+
+// Fail records another failed attempt for an entry.
+func (q *DeadLetterQueue) Fail(id string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, e := range q.entries {
+		if e.ID == id {
+			e.Attempts++
+			e.Error = err.Error()
+			e.FailedAt = time.Now().UnixMilli()
+			return
+		}
+	}
+}
+
+// Remove drops one entry (e.g. after a successful retry).
+func (q *DeadLetterQueue) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, e := range q.entries {
+		if e.ID == id {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear empties the queue and returns how many entries were dropped.
+func (q *DeadLetterQueue) Clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := len(q.entries)
+	q.entries = nil
+	return n
+}
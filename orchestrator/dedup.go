@@ -0,0 +1,315 @@
+// orchestrator/dedup.go
+// Coalescing of identical concurrent tasks.
+//
+// When several clients submit the same task at once — typically a shared
+// dashboard button pressed a few times — only the first is generated. The
+// others join it: /task callers get a copy of its result, /task/stream
+// callers a replay of its events so far followed by the live ones. Joined
+// results and final chunks carry deduplicated=true and dedup_of, the task
+// that actually ran. A successful task stays joinable for -dedup-window
+// after it finished, so a click landing just after the answer shares it
+// too; a failed one is forgotten at once so a retry runs again.
+//
+// Tasks are identical when their prompt (after context fitting), embed
+// input, files, compression, type, model hint, language, min_quality,
+// format, verify, options, target node, cloud opt-in and passed headers
+// match, and for streams the stream options too. Clients that want a fresh
+// generation send "no_dedup": true.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// dedupWindow is how long a finished task can still be joined; set from
+// -dedup-window. Zero turns deduplication off.
+var dedupWindow = 2 * time.Second
+
+var dedup = &dedupTable{flights: make(map[string]*flight)}
+
+// flight is one generation shared by every identical task that joined it.
+type flight struct {
+	leader  string // ID of the task that runs the generation
+	waiters int    // callers still waiting for or reading it
+	cancel  context.CancelFunc
+
+	// Guarded by dedupTable.mu
+	result *shared.TaskResult // /task
+	err    error
+	events []streamEvent // /task/stream: everything sent so far, in order
+	failed bool
+	done   bool
+	wake   chan struct{} // closed and replaced whenever the flight progresses
+}
+
+// streamEvent is one SSE event of a shared stream; failMsg set means the
+// stream ended with sseWriter.fail.
+type streamEvent struct {
+	event      string
+	v          any
+	failStatus int
+	failMsg    string
+}
+
+// dedupTable tracks flights by task key.
+type dedupTable struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// dedupKey identifies req among concurrent tasks; ok is false when req
+// must run on its own. Tasks passing different client headers to the
+// backends (-pass-headers) never share a generation.
+func dedupKey(ctx context.Context, req shared.TaskRequest, stream bool) (key string, ok bool) {
+	if dedupWindow <= 0 || req.NoDedup {
+		return "", false
+	}
+	id := struct {
+		Stream     bool
+		Prompt     string
+		Input      []string
+		Files      []string
+		Compress   *shared.CompressOptions
+		Type       shared.TaskType
+		ModelHint  string
+		Language   string
+		MinQuality shared.QualityTier
+		Format     shared.OutputFormat
+		Verify     bool
+		Options    map[string]any
+		TargetNode string
+		AllowCloud bool
+		Mode       shared.StreamMode
+		SnapshotMs int
+		Unit       shared.StreamGranularity
+		Headers    map[string]string
+	}{stream, req.Prompt, req.Input, req.Files, req.Compress, req.Type, req.ModelHint, req.Language, req.MinQuality, req.Format, req.Verify, req.Options, req.TargetNode, req.AllowCloud, "", 0, "", passedHeaders(ctx)}
+	if stream {
+		id.Mode, id.SnapshotMs, id.Unit = req.StreamMode, req.SnapshotIntervalMs, req.StreamGranularity
+	}
+	b, _ := json.Marshal(id)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// join returns the flight running key, starting one led by taskID with
+// run if there is none. run gets a context with the values of the
+// leader's ctx, cancelled once every caller has left.
+func (t *dedupTable) join(ctx context.Context, key, taskID string, run func(ctx context.Context, f *flight)) *flight {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.flights[key]; ok {
+		f.waiters++
+		log.Printf("[Dedup] Task %s joined identical task %s", taskID, f.leader)
+		return f
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight{leader: taskID, waiters: 1, cancel: cancel, wake: make(chan struct{})}
+	t.flights[key] = f
+	go run(ctx, f)
+	return f
+}
+
+// leave drops a caller from f, abandoning the generation if it was the
+// last one still waiting.
+func (t *dedupTable) leave(f *flight) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f.waiters--
+	if f.waiters == 0 && !f.done {
+		f.cancel()
+	}
+}
+
+// progress wakes f's callers after update, applied under the lock.
+func (t *dedupTable) progress(f *flight, update func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	update()
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+// finish marks f done and keeps it joinable for dedupWindow if it
+// succeeded.
+func (t *dedupTable) finish(key string, f *flight) {
+	t.progress(f, func() { f.done = true })
+	f.cancel()
+	forget := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.flights[key] == f {
+			delete(t.flights, key)
+		}
+	}
+	if f.failed {
+		forget()
+		return
+	}
+	time.AfterFunc(dedupWindow, forget)
+}
+
+// ─── /task ────────────────────────────────────────────────────────────────────
+
+// runTask executes req through routeWithFailover, sharing the generation
+// with identical tasks. led reports whether req's own generation produced
+// the result; the result is the caller's to modify either way. As with
+// routeWithFailover, a partial result comes with errPartialResult.
+func runTask(ctx context.Context, req shared.TaskRequest) (result *shared.TaskResult, led bool, err error) {
+	key, ok := dedupKey(ctx, req, false)
+	if !ok {
+		compressPrompt(ctx, &req)
+		result, err := routeWithFailover(ctx, req, nil)
+		if err == nil {
+			ensureJSON(ctx, req, result)
+			ensureVerified(ctx, req, result)
+		}
+		return result, true, err
+	}
+	f := dedup.join(ctx, key, req.TaskID, func(fctx context.Context, f *flight) {
+		fctx, cancel := context.WithTimeout(fctx, taskTimeout)
+		defer cancel()
+		req := req
+		compressPrompt(fctx, &req)
+		result, err := routeWithFailover(fctx, req, nil)
+		if err == nil {
+			ensureJSON(fctx, req, result)
+			ensureVerified(fctx, req, result)
+		}
+		dedup.progress(f, func() { f.result, f.err, f.failed = result, err, err != nil })
+		dedup.finish(key, f)
+	})
+	defer dedup.leave(f)
+
+	for {
+		dedup.mu.Lock()
+		done, wake := f.done, f.wake
+		result, err = f.result, f.err
+		dedup.mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	led = f.leader == req.TaskID
+	if result == nil {
+		return nil, led, err
+	}
+	copied := *result
+	if !led {
+		copied.TaskID = req.TaskID
+		copied.Lineage = req.Lineage
+		copied.Source = req.Source
+		copied.Metadata = req.Metadata
+		copied.Deduplicated = true
+		copied.DedupOf = f.leader
+	}
+	return &copied, led, err
+}
+
+// ─── /task/stream ─────────────────────────────────────────────────────────────
+
+// streamSink receives a task stream's events: an *sseWriter, or the
+// recorder of a shared stream.
+type streamSink interface {
+	send(event string, v any)
+	fail(taskID string, status int, msg string)
+}
+
+// flightSink records a shared stream's events for its callers.
+type flightSink struct{ f *flight }
+
+func (s flightSink) send(event string, v any) {
+	dedup.progress(s.f, func() {
+		s.f.events = append(s.f.events, streamEvent{event: event, v: v})
+		if c, ok := v.(shared.TaskChunk); ok && c.Done && c.Error != "" {
+			s.f.failed = true
+		}
+	})
+}
+
+func (s flightSink) fail(taskID string, status int, msg string) {
+	dedup.progress(s.f, func() {
+		s.f.events = append(s.f.events, streamEvent{failStatus: status, failMsg: msg})
+		s.f.failed = true
+	})
+}
+
+// runTaskStream streams req to sse, sharing the generation with identical
+// streamed tasks.
+func runTaskStream(ctx context.Context, req shared.TaskRequest, sse *sseWriter) {
+	key, ok := dedupKey(ctx, req, true)
+	if !ok {
+		streamTask(ctx, req, sse)
+		return
+	}
+	f := dedup.join(ctx, key, req.TaskID, func(fctx context.Context, f *flight) {
+		streamTask(fctx, req, flightSink{f})
+		dedup.finish(key, f)
+	})
+	defer dedup.leave(f)
+
+	led := f.leader == req.TaskID
+	if !led {
+		streamLog.alias(req, f.leader)
+	}
+	sent := 0
+	for {
+		dedup.mu.Lock()
+		events, done, wake := f.events[sent:], f.done, f.wake
+		dedup.mu.Unlock()
+		for _, ev := range events {
+			if ev.failMsg != "" {
+				sse.fail(req.TaskID, ev.failStatus, ev.failMsg)
+				continue
+			}
+			v := ev.v
+			if !led {
+				v = rewriteForJoiner(v, req, f.leader)
+			}
+			sse.send(ev.event, v)
+		}
+		sent += len(events)
+		if done {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rewriteForJoiner readdresses an event of the leader's stream to the
+// joined task.
+func rewriteForJoiner(v any, req shared.TaskRequest, leader string) any {
+	switch ev := v.(type) {
+	case shared.TaskChunk:
+		ev.TaskID = req.TaskID
+		if ev.Done {
+			ev.Metadata = req.Metadata
+			ev.Deduplicated = true
+			ev.DedupOf = leader
+		}
+		return ev
+	case shared.FailoverEvent:
+		ev.TaskID = req.TaskID
+		return ev
+	}
+	return v
+}
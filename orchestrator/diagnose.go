@@ -0,0 +1,311 @@
+// orchestrator/diagnose.go
+// Startup self-checks (-diagnose).
+//
+// "Nothing connects" is almost always the network rather than the mesh:
+// the port is taken, multicast never leaves the machine (WSL2, Docker
+// bridge networks, Windows' Public firewall profile) so agents' mDNS
+// discovery finds nothing, a firewall drops the agent port, or a clock is
+// far enough off to confuse liveness. -diagnose checks each of these with
+// the flags it was given, prints one line per finding with what to do
+// about it, and exits — non-zero if any check failed. It advertises only a
+// throwaway service, never _echo-mesh._tcp, so running agents aren't
+// pointed at an orchestrator that isn't serving.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+
+	"echo-system/shared"
+)
+
+// diagMDNSService is the service the mDNS self-test advertises and looks
+// for.
+const diagMDNSService = "_echo-diagnose._tcp"
+
+// diagTimeout bounds each network check.
+const diagTimeout = 3 * time.Second
+
+// diagLevel grades a finding.
+type diagLevel string
+
+const (
+	diagOK   diagLevel = "ok"
+	diagWarn diagLevel = "WARN"
+	diagFail diagLevel = "FAIL"
+)
+
+// diagFinding is one line of the report, with what to do about it.
+type diagFinding struct {
+	level diagLevel
+	check string
+	msg   string
+	fix   string
+}
+
+// diagOptions is what -diagnose checks, from the orchestrator's flags.
+type diagOptions struct {
+	listen    string
+	dataDir   string
+	inventory string
+}
+
+// runDiagnostics runs every check, prints the report and returns the exit
+// status.
+func runDiagnostics(opts diagOptions) int {
+	// The mDNS library and the stores log as they go; only the report counts
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	fmt.Printf("Echo orchestrator %s diagnostics (%s/%s)\n\n", shared.Version, runtime.GOOS, runtime.GOARCH)
+
+	var findings []diagFinding
+	add := func(f ...diagFinding) {
+		for _, one := range f {
+			printFinding(one)
+			findings = append(findings, one)
+		}
+	}
+	add(diagClock())
+	port, portFinding := diagPort(opts.listen)
+	add(portFinding)
+	add(diagDataDir(opts.dataDir))
+	add(diagNetwork(port)...)
+	add(diagMDNS(port)...)
+	add(diagAgents(opts.inventory)...)
+
+	failed, warned := 0, 0
+	for _, f := range findings {
+		switch f.level {
+		case diagFail:
+			failed++
+		case diagWarn:
+			warned++
+		}
+	}
+	fmt.Printf("\n%d checks: %d failed, %d warnings\n", len(findings), failed, warned)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printFinding(f diagFinding) {
+	fmt.Printf("  %-5s %-8s %s\n", f.level, f.check, f.msg)
+	if f.fix != "" && f.level != diagOK {
+		fmt.Printf("  %-5s %-8s → %s\n", "", "", f.fix)
+	}
+}
+
+// ─── Checks ───────────────────────────────────────────────────────────────────
+
+// diagClock catches a clock that was never set: heartbeats, history and
+// TLS certificates all go wrong with it.
+func diagClock() diagFinding {
+	now := time.Now()
+	if now.Year() < 2024 {
+		return diagFinding{diagFail, "clock", fmt.Sprintf("the system clock says %s", now.Format(time.RFC3339)),
+			"set the date and enable NTP (timedatectl set-ntp true; on Windows w32tm /resync)"}
+	}
+	return diagFinding{level: diagOK, check: "clock", msg: now.UTC().Format(time.RFC3339)}
+}
+
+// diagPort checks that -listen can be bound, and returns the TCP port (0
+// for a socket).
+func diagPort(listen string) (int, diagFinding) {
+	ln, err := shared.Listen(listen)
+	if err != nil {
+		return 0, diagFinding{diagFail, "port", fmt.Sprintf("can't listen on %s: %v", listen, err),
+			"stop whatever holds it (another orchestrator?) or pass a different -listen"}
+	}
+	defer ln.Close()
+	if tcp, ok := ln.Addr().(*net.TCPAddr); ok {
+		return tcp.Port, diagFinding{level: diagOK, check: "port", msg: listen + " is free"}
+	}
+	return 0, diagFinding{level: diagOK, check: "port", msg: listen + " can be bound (socket: same-host agents only)"}
+}
+
+// diagDataDir checks that -data-dir is writable: runs, routing config and
+// stream logs live there.
+func diagDataDir(dir string) diagFinding {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return diagFinding{diagFail, "data-dir", err.Error(), "pass a writable -data-dir"}
+	}
+	f, err := os.CreateTemp(dir, ".diagnose-*")
+	if err != nil {
+		return diagFinding{diagFail, "data-dir", fmt.Sprintf("%s isn't writable: %v", dir, err), "fix its permissions or pass a writable -data-dir"}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	abs, _ := filepath.Abs(dir)
+	return diagFinding{level: diagOK, check: "data-dir", msg: abs + " is writable"}
+}
+
+// diagNetwork lists the addresses agents on other machines can use.
+func diagNetwork(port int) []diagFinding {
+	if port == 0 {
+		return nil
+	}
+	ips := getOutboundIPs()
+	if len(ips) == 0 {
+		return []diagFinding{{diagWarn, "network", "no non-loopback IPv4 address",
+			"only agents on this machine can connect; attach it to the network the agents are on"}}
+	}
+	urls := make([]string, len(ips))
+	for i, ip := range ips {
+		urls[i] = shared.BaseURL(ip.String(), port)
+	}
+	return []diagFinding{{diagOK, "network",
+		"agents elsewhere reach this host at " + strings.Join(urls, ", "),
+		""}}
+}
+
+// diagMDNS advertises a throwaway service and looks it up: if the query
+// gets no answer, multicast isn't working on this host and agents started
+// with -orchestrator auto won't find the orchestrator either. Known
+// environments where it can't leave the machine get a warning regardless.
+func diagMDNS(port int) []diagFinding {
+	if port == 0 {
+		return []diagFinding{{level: diagOK, check: "mdns", msg: "skipped: nothing is advertised on a socket"}}
+	}
+	manual := fmt.Sprintf("start agents with -orchestrator http://<this host's IP>:%d instead of mDNS discovery", port)
+	var findings []diagFinding
+	if env := multicastIsolation(); env != "" {
+		findings = append(findings, diagFinding{diagWarn, "mdns", env + ": multicast doesn't reach other machines", manual})
+	}
+
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("echo-diagnose-%d", time.Now().UnixNano())
+	ips := getOutboundIPs()
+	if len(ips) == 0 {
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	service, err := mdns.NewMDNSService(instance, diagMDNSService, mdnsDomain, "", port, ips, []string{"diagnose on " + hostname})
+	if err != nil {
+		return append(findings, diagFinding{diagFail, "mdns", fmt.Sprintf("can't create an mDNS service: %v", err), manual})
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return append(findings, diagFinding{diagFail, "mdns",
+			fmt.Sprintf("can't join the mDNS multicast group (UDP 5353): %v", err), firewallHint(port) + "; or " + manual})
+	}
+	defer server.Shutdown()
+
+	entries := make(chan *mdns.ServiceEntry, 16)
+	params := &mdns.QueryParam{Service: diagMDNSService, Domain: "local", Timeout: diagTimeout, Entries: entries, DisableIPv6: true}
+	if err := mdns.Query(params); err != nil {
+		return append(findings, diagFinding{diagFail, "mdns", fmt.Sprintf("mDNS query failed: %v", err), firewallHint(port) + "; or " + manual})
+	}
+	close(entries)
+	for entry := range entries {
+		if strings.Contains(entry.Name, instance) {
+			return append(findings, diagFinding{level: diagOK, check: "mdns", msg: "multicast works: this host answered its own query"})
+		}
+	}
+	return append(findings, diagFinding{diagWarn, "mdns",
+		fmt.Sprintf("no answer to a multicast query within %s — agents won't discover this orchestrator", diagTimeout),
+		firewallHint(port) + "; or " + manual})
+}
+
+// multicastIsolation names the environment when this host's multicast is
+// known not to reach the LAN, else "".
+func multicastIsolation() string {
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil && strings.Contains(strings.ToLower(string(release)), "microsoft") {
+		return "running under WSL (NAT networking; try networkingMode=mirrored in .wslconfig)"
+	}
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return "running in a container (a bridge network; use host networking)"
+		}
+	}
+	return ""
+}
+
+// firewallHint says what to open for discovery and registration.
+func firewallHint(port int) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("allow inbound UDP 5353 and TCP %d in Windows Firewall, and make the network Private (Public blocks discovery)", port)
+	}
+	return fmt.Sprintf("allow UDP 5353 (multicast 224.0.0.251) and TCP %d through the firewall", port)
+}
+
+// diagAgents checks the -inventory nodes: that their agent port can be
+// reached from here, and that their clock agrees with this one.
+func diagAgents(path string) []diagFinding {
+	if path == "" {
+		return []diagFinding{{level: diagOK, check: "agents", msg: "skipped: pass -inventory to check the agents' reachability and clocks"}}
+	}
+	inv, err := loadInventory(path)
+	if err != nil {
+		return []diagFinding{{diagFail, "agents", err.Error(), "fix the -inventory file"}}
+	}
+	var findings []diagFinding
+	for _, node := range inv.nodes {
+		findings = append(findings, diagAgent(node))
+	}
+	if len(findings) == 0 {
+		findings = append(findings, diagFinding{level: diagOK, check: "agents", msg: "the inventory lists no nodes"})
+	}
+	return findings
+}
+
+// diagAgent checks one inventory node.
+func diagAgent(node inventoryNode) diagFinding {
+	if node.Host == "" {
+		return diagFinding{level: diagOK, check: "agents", msg: node.NodeID + ": skipped, no host in the inventory"}
+	}
+	port := node.Port
+	if port == 0 {
+		port = 9001
+	}
+	base := shared.BaseURL(node.Host, port)
+	if _, ok := shared.SocketPath(node.Host); !ok {
+		addr := net.JoinHostPort(node.Host, fmt.Sprint(port))
+		conn, err := net.DialTimeout("tcp", addr, diagTimeout)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return diagFinding{diagFail, "agents", fmt.Sprintf("%s: no answer from %s within %s", node.NodeID, addr, diagTimeout),
+					fmt.Sprintf("a firewall is dropping TCP %d on %s, or the host is down", port, node.Host)}
+			}
+			return diagFinding{diagFail, "agents", fmt.Sprintf("%s: can't connect to %s: %v", node.NodeID, addr, err),
+				fmt.Sprintf("check the agent is running with -port %d (refused means the host is up but nothing listens)", port)}
+		}
+		conn.Close()
+	}
+
+	client := &http.Client{Timeout: diagTimeout}
+	sent := time.Now()
+	resp, err := client.Get(base + "/health")
+	if err != nil {
+		return diagFinding{diagFail, "agents", fmt.Sprintf("%s: GET %s/health failed: %v", node.NodeID, base, err),
+			"something other than an Echo agent may hold that port"}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return diagFinding{diagWarn, "agents", fmt.Sprintf("%s: %s/health answered %s", node.NodeID, base, resp.Status),
+			"something other than an Echo agent may hold that port"}
+	}
+
+	// Date has whole seconds; compare against the middle of the round trip
+	msg := fmt.Sprintf("%s: %s is reachable", node.NodeID, base)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		mid := sent.Add(time.Since(sent) / 2)
+		skew := date.Add(500 * time.Millisecond).Sub(mid)
+		if maxClockSkew > 0 && (skew > maxClockSkew+time.Second || skew < -maxClockSkew-time.Second) {
+			return diagFinding{diagWarn, "agents", fmt.Sprintf("%s: reachable, but its clock is %s", node.NodeID, formatSkew(skew.Milliseconds())),
+				"enable NTP on both machines (timedatectl set-ntp true; on Windows w32tm /resync)"}
+		}
+	}
+	return diagFinding{level: diagOK, check: "agents", msg: msg}
+}
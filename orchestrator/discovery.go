@@ -9,38 +9,41 @@ import (
 	"log"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/hashicorp/mdns"
+
+	"echo-system/shared"
 )
 
 const (
-	mdnsServiceName  = "_echo-mesh._tcp"
-	mdnsDomain       = "local."
-	orchestratorPort = 8080
+	mdnsServiceName = "_echo-mesh._tcp"
+	mdnsDomain      = "local."
 )
 
+// agentAuth is the credential agents need to register, advertised in the
+// "auth" TXT record; "none" while registration is open.
+const agentAuth = "none"
+
 // startMDNS advertises the orchestrator as an mDNS service on the local network.
 // Node-agents browse for "_echo-mesh._tcp" to find the orchestrator automatically.
+// port is the TCP port the orchestrator listens on.
 // Returns a cleanup function that should be called on shutdown.
-func startMDNS() (func(), error) {
+func startMDNS(port int) (func(), error) {
 	hostname, _ := os.Hostname()
 
 	// Get the machine's non-loopback IP so agents on other hosts can reach us
 	ips := getOutboundIPs()
-	log.Printf("[mDNS] Advertising %s on port %d (IPs: %v)", mdnsServiceName, orchestratorPort, ips)
+	log.Printf("[mDNS] Advertising %s on port %d (IPs: %v)", mdnsServiceName, port, ips)
 
-	// Build the mDNS service entry
-	info := []string{
-		fmt.Sprintf("echo-mesh orchestrator on %s", hostname),
-	}
 	service, err := mdns.NewMDNSService(
-		hostname,         // instance name
-		mdnsServiceName,  // service type
-		mdnsDomain,       // domain
-		"",               // host name (empty = use OS hostname)
-		orchestratorPort, // port
-		ips,              // IPs to advertise
-		info,             // TXT records
+		hostname,          // instance name
+		mdnsServiceName,   // service type
+		mdnsDomain,        // domain
+		"",                // host name (empty = use OS hostname)
+		port,              // port
+		ips,               // IPs to advertise
+		mdnsTXT(hostname), // TXT records
 	)
 	if err != nil {
 		return nil, fmt.Errorf("mdns service creation failed: %w", err)
@@ -60,6 +63,34 @@ func startMDNS() (func(), error) {
 	return cleanup, nil
 }
 
+// mdnsTXT builds the TXT records. Besides where to reach the orchestrator,
+// they say what it expects of agents, so an incompatible agent can refuse
+// it with a clear error before trying to register:
+//
+//	version=v1.4.0  api=1  proto=http|https  auth=none
+func mdnsTXT(hostname string) []string {
+	proto := "http"
+	if strings.HasPrefix(publicURL, "https://") {
+		proto = "https"
+	}
+	info := []string{
+		fmt.Sprintf("echo-mesh orchestrator on %s", hostname),
+		"version=" + shared.Version,
+		fmt.Sprintf("api=%d", shared.MeshAPIVersion),
+		"proto=" + proto,
+		"auth=" + agentAuth,
+	}
+	// Behind a reverse proxy, agents must use the external URL / sub-path
+	// rather than ip:port
+	if publicURL != "" {
+		info = append(info, "url="+publicURL)
+	}
+	if basePath != "" {
+		info = append(info, "path="+basePath)
+	}
+	return info
+}
+
 // getOutboundIPs returns non-loopback IPv4 addresses on this machine.
 func getOutboundIPs() []net.IP {
 	var result []net.IP
@@ -0,0 +1,51 @@
+// orchestrator/eventbus_nats.go
+// NATS transport for the event bus, on nats.go.
+//
+// The URL is nats.go's: nats://[user:password@]host:4222, or tls:// to
+// require TLS (a nats:// server that asks for TLS gets it too). A wrong
+// address, credential or certificate stops the orchestrator at startup.
+// Once connected, the connection retries forever: publishes made while
+// it's down are buffered and the subscription is restored on reconnect.
+
+package main
+
+import (
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsBus struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATSBus(rawURL, subject string) (*natsBus, error) {
+	conn, err := nats.Connect(rawURL,
+		nats.Name("echo-orchestrator"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("[Events] NATS connection lost: %v — reconnecting", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Printf("[Events] NATS reconnected to %s", c.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsBus{conn: conn, subject: subject}, nil
+}
+
+func (b *natsBus) Publish(msg []byte) error {
+	return b.conn.Publish(b.subject, msg)
+}
+
+func (b *natsBus) Subscribe(deliver func(msg []byte)) error {
+	_, err := b.conn.Subscribe(b.subject, func(m *nats.Msg) {
+		deliver(m.Data)
+	})
+	return err
+}
@@ -0,0 +1,55 @@
+// orchestrator/eventbus_redis.go
+// Redis pub/sub transport for the event bus, on go-redis.
+//
+// The URL is go-redis's: redis://[user:password@]host:6379[/db], or
+// rediss:// for TLS. Subscribe waits for Redis to confirm the
+// subscription, so a wrong address, password or certificate stops the
+// orchestrator at startup. go-redis pools the publishing connections and,
+// after a dropped connection, resubscribes on its own.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisTimeout = 5 * time.Second
+
+type redisBus struct {
+	client  *redis.Client
+	channel string
+}
+
+func newRedisBus(rawURL, channel string) (*redisBus, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -event-bus URL: %w", err)
+	}
+	return &redisBus{client: redis.NewClient(opts), channel: channel}, nil
+}
+
+func (b *redisBus) Publish(msg []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, b.channel, msg).Err()
+}
+
+func (b *redisBus) Subscribe(deliver func(msg []byte)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	sub := b.client.Subscribe(ctx, b.channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("redis: %w", err)
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			deliver([]byte(msg.Payload))
+		}
+	}()
+	return nil
+}
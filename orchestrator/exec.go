@@ -0,0 +1,241 @@
+// orchestrator/exec.go
+// Exec steps: run generated code and feed back what it printed.
+//
+// A step with an "exec" block sends code — its "code" template, else the
+// step's input with any Markdown code fence stripped — to a node whose
+// agent runs that language in its sandbox (agent flag -exec; nodes declare
+// the languages at registration), and the program's stdout becomes the
+// step's output. Code that fails (non-zero exit or time limit) fails the
+// step, unless "max_fixes" allows a repair: the failure output goes to the
+// step's model with the code, its answer is run instead, and so on up to
+// max_fixes times — a self-correcting generate-and-run loop that stays on
+// the mesh:
+//
+//	{"type": "code", "prompt_template": "Write a Python script that {{initial_input}}"},
+//	{"type": "code", "exec": {"language": "python", "max_fixes": 2}}
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// maxExecFixes caps an exec step's max_fixes.
+const maxExecFixes = 5
+
+// defaultFixTemplate asks the step's model to repair code that failed.
+const defaultFixTemplate = "This {{exec_language}} program failed. Fix it so it runs correctly. " +
+	"Reply with only the complete corrected program.\n\nProgram:\n{{code}}\n\nOutput:\n{{exec_output}}"
+
+// execOutputShown is how much of a failed run's output goes into errors
+// and fix prompts.
+const execOutputShown = 4000
+
+// checkExec rejects an invalid exec step.
+func checkExec(step shared.PipelineStep) error {
+	switch {
+	case step.Exec == nil:
+		return nil
+	case step.Map != nil || step.Compress != nil || step.Fetch != nil:
+		return fmt.Errorf("an exec step can't also map, compress or fetch")
+	case step.PromptTemplate != "":
+		return fmt.Errorf("an exec step has no prompt_template (use exec.code for the code)")
+	case step.Exec.Language == "":
+		return fmt.Errorf("exec.language is required")
+	case step.Exec.MaxFixes < 0 || step.Exec.MaxFixes > maxExecFixes:
+		return fmt.Errorf("exec.max_fixes must be between 0 and %d", maxExecFixes)
+	case step.Exec.TimeoutMs < 0:
+		return fmt.Errorf("exec.timeout_ms must not be negative")
+	}
+	return nil
+}
+
+// execNodes lists the reachable nodes running language, least loaded
+// first.
+func execNodes(language string) []*shared.NodeInfo {
+	var nodes []*shared.NodeInfo
+	for _, node := range registry.AllNodes() {
+		if !routable(node) || node.Pull || node.Canary {
+			continue
+		}
+		for _, lang := range node.Exec {
+			if lang == language {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].ActiveTasks < nodes[j].ActiveTasks })
+	return nodes
+}
+
+// runExec runs code on the least loaded node that can, moving on to the
+// next when a node can't be reached or its sandbox can't start.
+func runExec(ctx context.Context, req shared.ExecRequest) (*shared.ExecResult, error) {
+	nodes := execNodes(req.Language)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node runs %s code (agents enable it with -exec)", req.Language)
+	}
+	var lastErr error
+	for _, node := range nodes {
+		registry.IncrementLoad(node.NodeID, "")
+		result, err := forwardExec(ctx, node, req)
+		registry.DecrementLoad(node.NodeID, "")
+		if err == nil && result.Error == "" {
+			return result, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: %s", node.NodeID, result.Error)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("[Exec] %v — trying the next node", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// forwardExec asks a node's agent to run the code.
+func forwardExec(ctx context.Context, node *shared.NodeInfo, req shared.ExecRequest) (*shared.ExecResult, error) {
+	body, _ := json.Marshal(req)
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/exec"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	withSession(httpReq, node.NodeID)
+	resp, err := agentClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s unreachable: %w", node.NodeID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP %d", node.NodeID, resp.StatusCode)
+	}
+	var result shared.ExecResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%s: failed to decode exec result: %w", node.NodeID, err)
+	}
+	return &result, nil
+}
+
+// runExecStep runs a pipeline exec step as taskReq: code runs, and while
+// it fails and fixes are left, the step's model rewrites it.
+func runExecStep(ctx context.Context, taskReq shared.TaskRequest, step shared.PipelineStep, code string) (*shared.TaskResult, error) {
+	opts := *step.Exec
+	started := time.Now()
+	for fix := 0; ; fix++ {
+		run, err := runExec(ctx, shared.ExecRequest{ExecID: uuid.New().String(), Language: opts.Language, Code: code, TimeoutMs: opts.TimeoutMs})
+		if err != nil {
+			return nil, err
+		}
+		if run.Succeeded() {
+			log.Printf("[Exec] Task %s: ran on %s in %dms after %d fixes", taskReq.TaskID, run.NodeID, run.LatencyMs, fix)
+			return &shared.TaskResult{
+				TaskID:    taskReq.TaskID,
+				Content:   run.Stdout,
+				RoutedTo:  run.NodeID,
+				TaskType:  taskReq.Type,
+				LatencyMs: time.Since(started).Milliseconds(),
+				Success:   true,
+				Metadata:  taskReq.Metadata,
+			}, nil
+		}
+
+		failure := execFailure(run)
+		if fix >= opts.MaxFixes {
+			return nil, fmt.Errorf("%s on %s: %s", opts.Language, run.NodeID, failure)
+		}
+		log.Printf("[Exec] Task %s: %s — asking for fix %d/%d", taskReq.TaskID, firstLine(failure), fix+1, opts.MaxFixes)
+		if code, err = fixCode(ctx, taskReq, step, code, failure); err != nil {
+			return nil, fmt.Errorf("fixing code that failed (%s): %w", firstLine(failure), err)
+		}
+	}
+}
+
+// execFailure describes a failed run with the tail of its output.
+func execFailure(run *shared.ExecResult) string {
+	status := fmt.Sprintf("exit status %d", run.ExitCode)
+	if run.TimedOut {
+		status = "timed out"
+	}
+	output := strings.TrimSpace(run.Stdout + "\n" + run.Stderr)
+	if len(output) > execOutputShown {
+		output = "…" + output[len(output)-execOutputShown:]
+	}
+	if output == "" {
+		return status
+	}
+	return status + "\n" + output
+}
+
+// fixCode asks the step's model for a corrected program, routed like any
+// task of the step's type (code by default).
+func fixCode(ctx context.Context, taskReq shared.TaskRequest, step shared.PipelineStep, code, failure string) (string, error) {
+	tmpl := step.Exec.FixTemplate
+	if tmpl == "" {
+		tmpl = defaultFixTemplate
+	}
+	fix := shared.TaskRequest{
+		TaskID:     uuid.New().String(),
+		Type:       taskReq.Type,
+		ModelHint:  taskReq.ModelHint,
+		Language:   taskReq.Language,
+		AllowCloud: taskReq.AllowCloud,
+		Source:     taskReq.Source,
+		Metadata:   map[string]string{"echo.fix_for": taskReq.TaskID},
+		Prompt: strings.NewReplacer(
+			"{{code}}", code,
+			"{{exec_output}}", failure,
+			"{{exec_language}}", step.Exec.Language,
+		).Replace(tmpl),
+	}
+	if fix.Type == "" {
+		fix.Type = shared.TaskTypeCode
+	}
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, fix, nil)
+	if err != nil {
+		return "", err
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+	return extractCode(result.Content), nil
+}
+
+// extractCode returns the first Markdown fenced block in text, else text:
+// models tend to wrap code in fences, which no interpreter accepts.
+func extractCode(text string) string {
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return strings.TrimSpace(text)
+	}
+	body := text[start+3:]
+	if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+		body = body[nl+1:] // the fence's language tag
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return strings.TrimSpace(body)
+}
+
+// firstLine is s up to its first newline.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,505 @@
+// orchestrator/fairshare.go
+// Fair sharing of busy nodes between tenants.
+//
+// Tenants are the -client-keys names tasks are attributed to (see
+// source.go); tasks without a key share the "anonymous" tenant. Each
+// dispatch's time on its node — the nearest thing to the GPU-seconds it
+// cost — is charged to the task's tenant, and that use halves every
+// half_life_s, so only recent use counts.
+//
+// With fair sharing enabled (PUT /admin/fairshare), a task that finds
+// every node that could run it busy is held at the orchestrator instead
+// of queuing on a node. As nodes free up, held tasks go out one at a time,
+// the task of the tenant with the fewest recent GPU-seconds per unit of
+// weight first, so one tenant's batch job can't crowd the others out. The
+// admin's weights (default 1) give tenants bigger shares. Nothing is held
+// while a node is free, however much a tenant has used, and a task held
+// for max_wait_ms goes anyway: heavy tenants slow down, they don't starve.
+// GET /stats/fairshare sets each tenant's use against its share, and an
+// operator can move a held task to the front or cancel it (see queue.go).
+//
+// The configuration is saved with the routing config.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// anonymousTenant is charged for tasks without a client key.
+const anonymousTenant = "anonymous"
+
+// fairShareTick is how often held tasks are reconsidered, besides
+// whenever a task finishes.
+const fairShareTick = 50 * time.Millisecond
+
+var defaultFairShare = shared.FairShareConfig{HalfLifeS: 600, MaxWaitMs: 30_000}
+
+var fairShare = &fairScheduler{
+	cfg:     defaultFairShare,
+	tenants: make(map[string]*tenantUsage),
+	kick:    make(chan struct{}, 1),
+}
+
+// fairScheduler tracks tenants' use and holds tasks under contention.
+type fairScheduler struct {
+	mu      sync.Mutex
+	cfg     shared.FairShareConfig
+	tenants map[string]*tenantUsage
+	waiting []*fairWaiter
+	seq     uint64
+	kick    chan struct{} // wakes loop early
+}
+
+// tenantUsage is one tenant's use of the nodes.
+type tenantUsage struct {
+	recent  float64   // decayed GPU-seconds, as of at
+	at      time.Time // when recent was last decayed
+	total   float64
+	tasks   int
+	held    int
+	waitMs  int64
+	overdue int
+}
+
+// fairWaiter is a task held for a node; ready is closed when it may go,
+// or when it's cancelled.
+type fairWaiter struct {
+	tenant    string
+	req       shared.TaskRequest
+	seq       uint64 // arrival order
+	promoted  uint64 // when moved to the front; 0 if it wasn't
+	cancelled bool
+	since     time.Time
+	ready     chan struct{}
+}
+
+// fairAdmitted marks a context whose task has been through admit, so
+// failover and alias retries aren't held again.
+type fairAdmitted struct{}
+
+// tenantOf names the tenant a task is charged to.
+func tenantOf(src *shared.TaskSource) string {
+	if src == nil || src.Key == "" {
+		return anonymousTenant
+	}
+	return src.Key
+}
+
+// start runs the loop that releases held tasks.
+func (f *fairScheduler) start() {
+	go func() {
+		ticker := time.NewTicker(fairShareTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.kick:
+			case <-ticker.C:
+			}
+			f.pass()
+		}
+	}()
+}
+
+// wake has the loop reconsider held tasks now.
+func (f *fairScheduler) wake() {
+	select {
+	case f.kick <- struct{}{}:
+	default:
+	}
+}
+
+// admit returns once req may be dispatched: at once unless fair sharing
+// is on and every node that could run it is busy (or other tasks are
+// held already), else when it's released or has waited max_wait_ms (0 =
+// as long as it takes). It returns ctx's error if the wait is abandoned,
+// and errQueueCancelled if an operator cancels the task.
+func (f *fairScheduler) admit(ctx context.Context, req shared.TaskRequest) (context.Context, error) {
+	if ctx.Value(fairAdmitted{}) != nil {
+		return ctx, nil
+	}
+	ctx = context.WithValue(ctx, fairAdmitted{}, true)
+
+	f.mu.Lock()
+	if !f.cfg.Enabled || (len(f.waiting) == 0 && !contended(req)) {
+		f.mu.Unlock()
+		return ctx, nil
+	}
+	now := time.Now()
+	f.seq++
+	w := &fairWaiter{tenant: tenantOf(req.Source), req: req, seq: f.seq, since: now, ready: make(chan struct{})}
+	f.waiting = append(f.waiting, w)
+	f.usage(w.tenant, now).held++
+	maxWait := time.Duration(f.cfg.MaxWaitMs) * time.Millisecond
+	log.Printf("[FairShare] Task %s (%s) held for a node (%d held)", req.TaskID, w.tenant, len(f.waiting))
+	f.mu.Unlock()
+	f.wake()
+
+	var expired <-chan time.Time // never, without a max wait
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-w.ready:
+		if w.cancelled {
+			return ctx, errQueueCancelled
+		}
+		return ctx, nil
+	case <-expired:
+	case <-ctx.Done():
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.leave(w, time.Now()) {
+		// Released or cancelled just as the wait ended
+		if w.cancelled {
+			return ctx, errQueueCancelled
+		}
+		return ctx, ctx.Err()
+	}
+	if ctx.Err() != nil {
+		return ctx, ctx.Err()
+	}
+	f.usage(w.tenant, time.Now()).overdue++
+	log.Printf("[FairShare] Task %s (%s) sent after %v held", req.TaskID, w.tenant, maxWait)
+	return ctx, nil
+}
+
+// charge bills the time since a dispatch started to the task's tenant,
+// and has the loop look for a held task to take the freed node.
+func (f *fairScheduler) charge(src *shared.TaskSource, since time.Time) {
+	now := time.Now()
+	f.mu.Lock()
+	u := f.usage(tenantOf(src), now)
+	u.recent += now.Sub(since).Seconds()
+	u.total += now.Sub(since).Seconds()
+	u.tasks++
+	f.mu.Unlock()
+	f.wake()
+}
+
+// pass releases one held task that a node is free for, the lightest
+// tenant's first; all of them once fair sharing is off.
+func (f *fairScheduler) pass() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if !f.cfg.Enabled {
+		for len(f.waiting) > 0 {
+			w := f.waiting[0]
+			f.leave(w, now)
+			close(w.ready)
+		}
+		return
+	}
+	for _, w := range f.ordered(now) {
+		if !contended(w.req) {
+			f.leave(w, now)
+			close(w.ready)
+			return
+		}
+	}
+}
+
+// ordered returns the held tasks in the order they're released: those
+// moved to the front, the last moved first, then the lightest tenant's.
+// Must be called with f.mu held.
+func (f *fairScheduler) ordered(now time.Time) []*fairWaiter {
+	order := append([]*fairWaiter(nil), f.waiting...)
+	for _, w := range order {
+		f.usage(w.tenant, now) // decayed to the same instant for comparing
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].promoted != order[j].promoted {
+			return order[i].promoted > order[j].promoted
+		}
+		a, b := f.load(order[i].tenant), f.load(order[j].tenant)
+		if a != b {
+			return a < b
+		}
+		return order[i].seq < order[j].seq
+	})
+	return order
+}
+
+// held lists the held tasks in release order.
+func (f *fairScheduler) held() []shared.QueueEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	entries := []shared.QueueEntry{}
+	for i, w := range f.ordered(now) {
+		entries = append(entries, shared.QueueEntry{
+			ID:         w.req.TaskID,
+			Kind:       shared.QueueHeld,
+			Position:   i,
+			Type:       w.req.Type,
+			ModelHint:  w.req.ModelHint,
+			TargetNode: w.req.TargetNode,
+			Tenant:     w.tenant,
+			Source:     w.req.Source,
+			QueuedAt:   w.since.UnixMilli(),
+			WaitedMs:   now.Sub(w.since).Milliseconds(),
+			Promoted:   w.promoted > 0,
+		})
+	}
+	return entries
+}
+
+// find returns the held task with the given ID. Must be called with f.mu
+// held.
+func (f *fairScheduler) find(taskID string) *fairWaiter {
+	for _, w := range f.waiting {
+		if w.req.TaskID == taskID {
+			return w
+		}
+	}
+	return nil
+}
+
+// promote moves a held task to the front, to go as soon as a node is free
+// for it; false if no such task is held.
+func (f *fairScheduler) promote(taskID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := f.find(taskID)
+	if w == nil {
+		return false
+	}
+	f.seq++
+	w.promoted = f.seq
+	return true
+}
+
+// cancel drops a held task, which fails with errQueueCancelled; false if
+// no such task is held.
+func (f *fairScheduler) cancel(taskID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := f.find(taskID)
+	if w == nil {
+		return false
+	}
+	f.leave(w, time.Now())
+	w.cancelled = true
+	close(w.ready)
+	return true
+}
+
+// waitingTasks counts the held tasks.
+func (f *fairScheduler) waitingTasks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiting)
+}
+
+// leave drops w from the held tasks, counting its wait; false if it
+// wasn't held anymore. Must be called with f.mu held.
+func (f *fairScheduler) leave(w *fairWaiter, now time.Time) bool {
+	for i, other := range f.waiting {
+		if other == w {
+			f.waiting = append(f.waiting[:i], f.waiting[i+1:]...)
+			f.usage(w.tenant, now).waitMs += now.Sub(w.since).Milliseconds()
+			return true
+		}
+	}
+	return false
+}
+
+// usage returns a tenant's use, decayed to now. Must be called with f.mu
+// held.
+func (f *fairScheduler) usage(tenant string, now time.Time) *tenantUsage {
+	u, ok := f.tenants[tenant]
+	if !ok {
+		u = &tenantUsage{at: now}
+		f.tenants[tenant] = u
+	}
+	if halfLife := float64(f.cfg.HalfLifeS); halfLife > 0 && now.After(u.at) {
+		u.recent *= math.Exp2(-now.Sub(u.at).Seconds() / halfLife)
+	}
+	u.at = now
+	return u
+}
+
+// load is a tenant's recent GPU-seconds per unit of weight. Must be
+// called with f.mu held.
+func (f *fairScheduler) load(tenant string) float64 {
+	return f.tenants[tenant].recent / f.weight(tenant)
+}
+
+// weight is a tenant's share weight. Must be called with f.mu held.
+func (f *fairScheduler) weight(tenant string) float64 {
+	if w, ok := f.cfg.Weights[tenant]; ok {
+		return w
+	}
+	return 1
+}
+
+// contended reports whether every node that could run req is busy for
+// it. Only nodes in the best routing tier count, as routing would pick
+// one of them; with no node at all there's nothing to wait for.
+func contended(req shared.TaskRequest) bool {
+	var cands []*shared.NodeInfo
+	if req.TargetNode != "" {
+		node, err := registry.GetNode(req.TargetNode)
+		if err != nil {
+			return false
+		}
+		cands = []*shared.NodeInfo{node}
+	} else {
+		cands = registry.RankCandidates(req.Type, req.ModelHint, req.Language, req.MinQuality, nil)
+	}
+	if len(cands) == 0 {
+		return false
+	}
+	tier := routeTier(cands[0], req.Type, req.ModelHint)
+	for _, node := range cands {
+		if routeTier(node, req.Type, req.ModelHint) != tier {
+			continue
+		}
+		model := expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality)
+		if busy, _ := busyFor(node, model); !busy && !exclusiveLocked(node, model) {
+			return false
+		}
+	}
+	return true
+}
+
+// config returns the current configuration.
+func (f *fairScheduler) config() shared.FairShareConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg
+}
+
+// set changes the configuration; turning fair sharing off releases the
+// held tasks.
+func (f *fairScheduler) set(cfg shared.FairShareConfig) {
+	f.mu.Lock()
+	f.cfg = cfg
+	f.mu.Unlock()
+	f.wake()
+}
+
+// stats reports each tenant's use against its share.
+func (f *fairScheduler) stats() shared.FairShareStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	waiting := make(map[string]int)
+	for _, w := range f.waiting {
+		waiting[w.tenant]++
+	}
+
+	out := shared.FairShareStats{Enabled: f.cfg.Enabled, Waiting: len(f.waiting), Fairness: 1, Tenants: []shared.TenantShare{}}
+	var used, weights, sum, sumSq float64
+	active := 0
+	for name := range f.tenants {
+		u := f.usage(name, now)
+		used += u.recent
+		// Tenants with recent use or held tasks are the ones competing
+		if u.recent >= 0.001 || waiting[name] > 0 {
+			x := u.recent / f.weight(name)
+			weights += f.weight(name)
+			sum += x
+			sumSq += x * x
+			active++
+		}
+	}
+	if sumSq > 0 {
+		out.Fairness = sum * sum / (float64(active) * sumSq)
+	}
+	for name, u := range f.tenants {
+		t := shared.TenantShare{
+			Tenant:          name,
+			Weight:          f.weight(name),
+			GPUSeconds:      u.recent,
+			TotalGPUSeconds: u.total,
+			Tasks:           u.tasks,
+			Waiting:         waiting[name],
+			Held:            u.held,
+			WaitMs:          u.waitMs,
+			Overdue:         u.overdue,
+		}
+		if used > 0 {
+			t.Share = u.recent / used
+		}
+		if weights > 0 && (u.recent >= 0.001 || waiting[name] > 0) {
+			t.FairShare = t.Weight / weights
+		}
+		out.Tenants = append(out.Tenants, t)
+	}
+	sort.Slice(out.Tenants, func(i, j int) bool {
+		if out.Tenants[i].GPUSeconds != out.Tenants[j].GPUSeconds {
+			return out.Tenants[i].GPUSeconds > out.Tenants[j].GPUSeconds
+		}
+		return out.Tenants[i].Tenant < out.Tenants[j].Tenant
+	})
+	return out
+}
+
+func validateFairShare(cfg shared.FairShareConfig) error {
+	if cfg.HalfLifeS < 1 {
+		return fmt.Errorf("half_life_s must be at least 1")
+	}
+	if cfg.MaxWaitMs < 0 {
+		return fmt.Errorf("max_wait_ms must not be negative")
+	}
+	for tenant, w := range cfg.Weights {
+		if !(w > 0) || math.IsInf(w, 0) {
+			return fmt.Errorf("weight for %q must be above 0", tenant)
+		}
+	}
+	return nil
+}
+
+// ─── Admin: GET/PUT /admin/fairshare ──────────────────────────────────────────
+
+func handleGetFairShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fairShare.config())
+}
+
+// handleSetFairShare updates the configuration; fields left out keep their
+// value, and weights replace the old ones as a whole.
+func handleSetFairShare(w http.ResponseWriter, r *http.Request) {
+	next := fairShare.config()
+	prev := next.Weights
+	next.Weights = nil
+	if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if next.Weights == nil {
+		next.Weights = prev
+	}
+	if err := validateFairShare(next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fairShare.set(next)
+	saveRoutingConfig()
+	log.Printf("[Admin] Fair share enabled=%v, half-life %ds, max wait %dms, weights %v",
+		next.Enabled, next.HalfLifeS, next.MaxWaitMs, next.Weights)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(next)
+}
+
+// ─── GET /stats/fairshare ─────────────────────────────────────────────────────
+
+func handleFairShareStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fairShare.stats())
+}
@@ -0,0 +1,108 @@
+// orchestrator/fallback.go
+// Model fallback chains: degrade to a smaller model when one can't run.
+//
+// When an agent reports that a task's model is missing (MODEL_NOT_FOUND) or
+// doesn't fit in memory (OOM), trying the same model on the next node often
+// fails the same way. With -fallback-models, each task type gets an ordered
+// chain of models, largest first; such a failure retries the task with the
+// next model down the chain, on any node (including the one that failed),
+// and the result's model_fallback records the substitution. Without a chain
+// for the task's type, the failure fails over like any other.
+//
+//	-fallback-models "text=llama3:70b,llama3:8b,phi3;code=codellama:34b,codellama:7b"
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"echo-system/shared"
+)
+
+// fallbackAnyType keys the chain used for task types without their own.
+const fallbackAnyType = "*"
+
+// fallbackChains maps a task type (or fallbackAnyType) to its models,
+// largest first; set from the -fallback-models flag.
+var fallbackChains map[shared.TaskType][]string
+
+// parseFallbackChains parses the -fallback-models flag value.
+// Format: "text=llama3:70b,llama3:8b;code=codellama:34b,codellama:7b;*=phi3"
+func parseFallbackChains(flag string) (map[shared.TaskType][]string, error) {
+	chains := make(map[shared.TaskType][]string)
+	for _, entry := range strings.Split(flag, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		taskType, list, ok := strings.Cut(entry, "=")
+		taskType = strings.TrimSpace(taskType)
+		if !ok || taskType == "" {
+			return nil, fmt.Errorf("invalid -fallback-models entry %q (want type=model,model,...)", entry)
+		}
+		var models []string
+		for _, m := range strings.Split(list, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				models = append(models, m)
+			}
+		}
+		if len(models) < 2 {
+			return nil, fmt.Errorf("-fallback-models chain for %q needs at least two models", taskType)
+		}
+		chains[shared.TaskType(taskType)] = models
+	}
+	return chains, nil
+}
+
+// nextFallbackModel returns the model after failed in the task type's
+// chain, or "" if there is none.
+func nextFallbackModel(taskType shared.TaskType, failed string) string {
+	chain, ok := fallbackChains[taskType]
+	if !ok {
+		chain = fallbackChains[fallbackAnyType]
+	}
+	for i, m := range chain {
+		if m == failed && i+1 < len(chain) {
+			return chain[i+1]
+		}
+	}
+	return ""
+}
+
+// agentTaskError is a failure reported by an agent in its TaskResult, as
+// opposed to the agent being unreachable.
+type agentTaskError struct {
+	Code    string // shared.ErrCode*, "" if unclassified
+	Model   string
+	Message string
+}
+
+func (e *agentTaskError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("agent failed the task (%s): %s", e.Code, e.Message)
+	}
+	return "agent failed the task: " + e.Message
+}
+
+// memoryRefused reports whether an agent turned a task down because its
+// model would exceed the node's memory budget. The node is fine, only full
+// for now: the task goes elsewhere without marking it suspect.
+func memoryRefused(err error) bool {
+	var ae *agentTaskError
+	return errors.As(err, &ae) && ae.Code == shared.ErrCodeMemoryBudget
+}
+
+// modelFailure returns err's error code if it's a failure a smaller model
+// may avoid, along with the model that failed.
+func modelFailure(err error) (code, model string, ok bool) {
+	var ae *agentTaskError
+	if !errors.As(err, &ae) {
+		return "", "", false
+	}
+	switch ae.Code {
+	case shared.ErrCodeModelNotFound, shared.ErrCodeOOM:
+		return ae.Code, ae.Model, true
+	}
+	return "", "", false
+}
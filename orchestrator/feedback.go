@@ -0,0 +1,311 @@
+// orchestrator/feedback.go
+// Human feedback on task outputs.
+//
+// Whoever reads a task's output can say what they thought of it with
+// POST /tasks/{id}/feedback: a thumbs up or down, a comment, and the
+// output it should have been. Feedback is kept with the node, model and
+// type the task ran with, appended to <data-dir>/feedback.jsonl (the
+// latest line for a task wins), and shows on the task's lineage record.
+// GET /stats/feedback sums it per model, per model and task type, and per
+// node, so users can see which models do best on their own workloads
+// rather than on benchmarks.
+//
+// A rating also counts as an outcome in the node's reputation, which
+// routing weighs (see weights.go): a node whose answers people keep
+// turning down loses ground like one whose tasks fail. Changing a rating
+// counts again; re-posting the same one doesn't.
+//
+// Feedback is taken on tasks whose result is still known, as for share
+// links: the last finished tasks, resumable streams, pipeline steps,
+// deferred tasks and anything in the lineage log.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// Limits on feedback text, which is kept for good.
+const (
+	maxFeedbackComment = 4 << 10
+	maxCorrectedOutput = 256 << 10
+)
+
+// feedbackWindow is how far back GET /stats/feedback looks by default.
+const feedbackWindow = 30 * 24 * time.Hour
+
+var feedback *FeedbackStore
+
+// FeedbackStore holds the latest feedback on each task.
+type FeedbackStore struct {
+	mu     sync.RWMutex
+	byTask map[string]*shared.TaskFeedback
+	file   *os.File // append-only log, nil if unavailable
+}
+
+// NewFeedbackStore loads the feedback log under dataDir and opens it for
+// appending. A log that can't be opened only costs persistence.
+func NewFeedbackStore(dataDir string) *FeedbackStore {
+	s := &FeedbackStore{byTask: make(map[string]*shared.TaskFeedback)}
+	path := filepath.Join(dataDir, "feedback.jsonl")
+	if f, err := os.Open(path); err == nil {
+		// No line limit: JSON escaping can make a line several times
+		// longer than the correction it carries.
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			var fb shared.TaskFeedback
+			if len(line) > 0 && json.Unmarshal(line, &fb) == nil && fb.TaskID != "" {
+				s.byTask[fb.TaskID] = &fb
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("[Feedback] Stopped reading %s: %v", path, err)
+				}
+				break
+			}
+		}
+		f.Close()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("[Feedback] Cannot open %s (%v) — feedback kept in memory only", path, err)
+	} else {
+		s.file = f
+	}
+	log.Printf("[Feedback] Loaded feedback on %d tasks from %s", len(s.byTask), path)
+	return s
+}
+
+// Put records fb, replacing the task's earlier feedback; it returns that
+// earlier feedback, nil if there was none.
+func (s *FeedbackStore) Put(fb shared.TaskFeedback) *shared.TaskFeedback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.byTask[fb.TaskID]
+	if prev != nil {
+		fb.CreatedAt = prev.CreatedAt
+	}
+	s.byTask[fb.TaskID] = &fb
+	if s.file != nil {
+		line, _ := json.Marshal(fb)
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			log.Printf("[Feedback] Failed to persist feedback on %s: %v", fb.TaskID, err)
+		}
+	}
+	return prev
+}
+
+// Get returns a copy of a task's feedback.
+func (s *FeedbackStore) Get(taskID string) (*shared.TaskFeedback, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fb, ok := s.byTask[taskID]
+	if !ok {
+		return nil, false
+	}
+	copied := *fb
+	return &copied, true
+}
+
+// Summary sums the feedback given since (unix ms), on tasks of taskType
+// if it isn't empty.
+func (s *FeedbackStore) Summary(since int64, taskType shared.TaskType) shared.FeedbackSummary {
+	type key struct {
+		model, node string
+		typ         shared.TaskType
+	}
+	groups := make(map[key]*shared.FeedbackStats)
+	add := func(k key, fb *shared.TaskFeedback) {
+		st, ok := groups[k]
+		if !ok {
+			st = &shared.FeedbackStats{Model: k.model, NodeID: k.node, TaskType: k.typ}
+			groups[k] = st
+		}
+		switch fb.Rating {
+		case shared.RatingUp:
+			st.Up++
+		case shared.RatingDown:
+			st.Down++
+		}
+		if fb.Comment != "" {
+			st.Comments++
+		}
+		if fb.CorrectedOutput != "" {
+			st.Corrections++
+		}
+		st.LastAt = max(st.LastAt, fb.UpdatedAt)
+	}
+
+	out := shared.FeedbackSummary{Since: since}
+	s.mu.RLock()
+	for _, fb := range s.byTask {
+		if fb.UpdatedAt < since || (taskType != "" && fb.TaskType != taskType) {
+			continue
+		}
+		out.Tasks++
+		if fb.Model != "" {
+			add(key{model: fb.Model}, fb)
+			add(key{model: fb.Model, typ: fb.TaskType}, fb)
+		}
+		if fb.NodeID != "" {
+			add(key{node: fb.NodeID}, fb)
+		}
+	}
+	s.mu.RUnlock()
+
+	out.Models, out.Workloads, out.Nodes = []shared.FeedbackStats{}, []shared.FeedbackStats{}, []shared.FeedbackStats{}
+	for k, st := range groups {
+		if rated := st.Up + st.Down; rated > 0 {
+			st.Score = float64(st.Up) / float64(rated)
+		}
+		switch {
+		case k.node != "":
+			out.Nodes = append(out.Nodes, *st)
+		case k.typ != "":
+			out.Workloads = append(out.Workloads, *st)
+		default:
+			out.Models = append(out.Models, *st)
+		}
+	}
+	for _, list := range [][]shared.FeedbackStats{out.Models, out.Workloads, out.Nodes} {
+		sort.Slice(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if a.Score != b.Score {
+				return a.Score > b.Score
+			}
+			if a.Up+a.Down != b.Up+b.Down {
+				return a.Up+a.Down > b.Up+b.Down
+			}
+			return a.Model+a.NodeID+string(a.TaskType) < b.Model+b.NodeID+string(b.TaskType)
+		})
+	}
+	return out
+}
+
+// RecordFeedback counts a rating as an outcome in the node's reputation.
+func (r *Registry) RecordFeedback(nodeID string, rating shared.FeedbackRating) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	outcome := 0.0
+	if rating == shared.RatingUp {
+		outcome = 1
+	}
+	node.Reputation = reputationAlpha*outcome + (1-reputationAlpha)*node.Reputation
+}
+
+// taskRun finds the node, model and type a task ran with, wherever its
+// result is still known.
+func taskRun(taskID string) (nodeID, model string, taskType shared.TaskType, ok bool) {
+	if result, found := shares.findTask(taskID); found {
+		return result.RoutedTo, result.ModelUsed, result.TaskType, true
+	}
+	if n, found := lineageLog.Get(taskID); found && !n.Pipeline {
+		return n.RoutedTo, n.ModelUsed, "", true
+	}
+	return "", "", "", false
+}
+
+// ─── Client: POST /tasks/{id}/feedback ────────────────────────────────────────
+
+func handlePostFeedback(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	var req shared.FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch {
+	case req.Rating != "" && req.Rating != shared.RatingUp && req.Rating != shared.RatingDown:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("unknown rating %q (want up or down)", req.Rating))
+		return
+	case req.Rating == "" && req.Comment == "" && req.CorrectedOutput == "":
+		writeProblem(w, r, http.StatusBadRequest, "rating, comment or corrected_output is required")
+		return
+	case len(req.Comment) > maxFeedbackComment:
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("comment is over %d bytes", maxFeedbackComment))
+		return
+	case len(req.CorrectedOutput) > maxCorrectedOutput:
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("corrected_output is over %d bytes", maxCorrectedOutput))
+		return
+	}
+	nodeID, model, taskType, ok := taskRun(taskID)
+	if !ok {
+		writeTaskProblem(w, r, http.StatusNotFound, taskID, "task result not found (it may be too old to give feedback on)")
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	fb := shared.TaskFeedback{
+		TaskID:          taskID,
+		Rating:          req.Rating,
+		Comment:         req.Comment,
+		CorrectedOutput: req.CorrectedOutput,
+		NodeID:          nodeID,
+		Model:           model,
+		TaskType:        taskType,
+		Source:          requestSource(r),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	prev := feedback.Put(fb)
+	if fb.Rating != "" && (prev == nil || prev.Rating != fb.Rating) {
+		registry.RecordFeedback(nodeID, fb.Rating)
+	}
+	status := http.StatusCreated
+	if prev != nil {
+		fb.CreatedAt = prev.CreatedAt
+		status = http.StatusOK
+	}
+	log.Printf("[Feedback] Task %s (%s on %s): rating %q, %d-byte comment, %d-byte correction",
+		taskID, model, nodeID, fb.Rating, len(fb.Comment), len(fb.CorrectedOutput))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(fb)
+}
+
+func handleGetFeedback(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	fb, ok := feedback.Get(taskID)
+	if !ok {
+		writeTaskProblem(w, r, http.StatusNotFound, taskID, "no feedback on this task")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fb)
+}
+
+// ─── GET /stats/feedback ──────────────────────────────────────────────────────
+
+func handleFeedbackStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since int64
+	if v := q.Get("window"); v != "all" {
+		window, err := parseSpan(v, feedbackWindow)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid window: "+err.Error())
+			return
+		}
+		since = time.Now().Add(-window).UnixMilli()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feedback.Summary(since, shared.TaskType(q.Get("type"))))
+}
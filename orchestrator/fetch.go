@@ -0,0 +1,238 @@
+// orchestrator/fetch.go
+// Fetch steps: a web page's text as pipeline input.
+//
+// A step with a "fetch" block runs on the orchestrator, not a node: it
+// downloads a URL — its "url" template, else the step's input — and strips
+// the page to its readable text, which is the step's output and, from then
+// on, {{fetched_content}} in later steps' templates. A "summarize this
+// article" pipeline is then one fetch step and one prompt:
+//
+//	{"initial_input": "https://go.dev/blog/",
+//	 "steps": [{"fetch": {}},
+//	           {"type": "summarize", "prompt_template": "Summarize:\n{{fetched_content}}"}]}
+//
+// Downloads are limited to the hosts in -fetch-allow (and their
+// subdomains; redirects included), -fetch-max-bytes of the body and
+// -fetch-timeout. Without -fetch-allow any host may be fetched, but only
+// at a public address: a pipeline can't reach the orchestrator's own
+// ports, the LAN or cloud metadata endpoints. The check is on the address
+// dialled, after DNS, so redirects and names resolving to private
+// addresses are caught too.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"echo-system/shared"
+)
+
+// fetchAllow, fetchMaxBytes and fetchTimeout limit fetch steps; set from
+// -fetch-allow, -fetch-max-bytes and -fetch-timeout. An empty allowlist
+// allows any host at a public address.
+var (
+	fetchAllow    []string
+	fetchMaxBytes int64 = 2 << 20
+	fetchTimeout        = 30 * time.Second
+)
+
+// fetchMaxChars is the readable text a fetch step keeps by default, to fit
+// a small model's context.
+const fetchMaxChars = 16_000
+
+var (
+	htmlDropRe  = regexp.MustCompile(`(?is)<(script|style|noscript|head|nav|footer|aside|svg|template)\b[^>]*>.*?</(script|style|noscript|head|nav|footer|aside|svg|template)\s*>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/h[1-6]|/tr|/pre|/blockquote|/section|/article)\b[^>]*>`)
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlSpaceRe = regexp.MustCompile(`[^\S\n]+`)
+	htmlLinesRe = regexp.MustCompile(`\s*\n\s*`)
+)
+
+// parseFetchAllow parses -fetch-allow: comma-separated host names.
+func parseFetchAllow(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, strings.TrimPrefix(h, "."))
+		}
+	}
+	return hosts
+}
+
+// fetchAllowed reports whether host, or a domain it's under, is in
+// -fetch-allow.
+func fetchAllowed(host string) bool {
+	if len(fetchAllow) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range fetchAllow {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFetchURL rejects a URL a fetch step may not download.
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http(s) URL", u.String())
+	}
+	if !fetchAllowed(u.Hostname()) {
+		return fmt.Errorf("host %q is not in -fetch-allow", u.Hostname())
+	}
+	return nil
+}
+
+// sharedCGNAT is 100.64.0.0/10, the carrier-grade NAT range that mesh
+// VPNs such as Tailscale hand out.
+var sharedCGNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether ip is reachable on the internet: not loopback,
+// private, link-local (cloud metadata endpoints), CGNAT, multicast or
+// unspecified.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedCGNAT.Contains(ip)
+}
+
+// fetchDialControl refuses connections to non-public addresses unless
+// -fetch-allow lists the hosts that may be fetched.
+func fetchDialControl(network, address string, _ syscall.RawConn) error {
+	if len(fetchAllow) > 0 {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%s is not a public address (list the host in -fetch-allow to fetch from it)", addrPort.Addr())
+	}
+	return nil
+}
+
+// fetchClient checks every redirect against the allowlist as well, and
+// every address it dials with fetchDialControl. It doesn't use
+// HTTP_PROXY: through a proxy the address fetched couldn't be checked.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: fetchDialControl}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkFetchURL(req.URL)
+	},
+}
+
+// checkFetch rejects an invalid fetch step.
+func checkFetch(step shared.PipelineStep) error {
+	switch {
+	case step.Fetch == nil:
+		return nil
+	case step.Map != nil || step.Compress != nil:
+		return fmt.Errorf("a fetch step can't also map or compress")
+	case step.PromptTemplate != "":
+		return fmt.Errorf("a fetch step has no prompt_template (use fetch.url for the URL)")
+	case step.Fetch.MaxChars < 0:
+		return fmt.Errorf("fetch.max_chars must not be negative")
+	}
+	return nil
+}
+
+// runFetchStep runs a pipeline fetch step as taskReq: its output is the
+// readable text of rawURL.
+func runFetchStep(ctx context.Context, taskReq shared.TaskRequest, rawURL string, opts shared.PipelineFetch) (*shared.TaskResult, error) {
+	maxChars := opts.MaxChars
+	if maxChars == 0 {
+		maxChars = fetchMaxChars
+	}
+	started := time.Now()
+	text, err := fetchPageText(ctx, rawURL, maxChars)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Fetch] %s: %d chars of text", strings.TrimSpace(rawURL), len(text))
+	return &shared.TaskResult{
+		TaskID:    taskReq.TaskID,
+		Content:   text,
+		LatencyMs: time.Since(started).Milliseconds(),
+		Success:   true,
+		Metadata:  taskReq.Metadata,
+	}, nil
+}
+
+// fetchPageText downloads a URL and returns at most maxChars of its
+// readable text: HTML tags, scripts, styles and navigation stripped,
+// entities decoded, one line per block.
+func fetchPageText(ctx context.Context, rawURL string, maxChars int) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an http(s) URL", rawURL)
+	}
+	if err := checkFetchURL(u); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("fetch %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBytes))
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+
+	text := string(raw)
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		text = htmlDropRe.ReplaceAllString(text, " ")
+		text = htmlBreakRe.ReplaceAllString(text, "\n")
+		text = html.UnescapeString(htmlTagRe.ReplaceAllString(text, " "))
+	}
+	text = htmlSpaceRe.ReplaceAllString(text, " ")
+	text = strings.TrimSpace(htmlLinesRe.ReplaceAllString(text, "\n"))
+	if len(text) > maxChars {
+		cut := maxChars
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	if text == "" {
+		return "", fmt.Errorf("fetch %s: page has no readable text", rawURL)
+	}
+	return text, nil
+}
@@ -0,0 +1,287 @@
+// orchestrator/files.go
+// Files tasks reference instead of inlining.
+//
+// A document, image or code archive too large to paste into a prompt is
+// uploaded once with POST /files (the raw bytes as the body, ?name= to
+// label it) and referenced by ID from any number of tasks' "files". Agents
+// fetch what a task references from GET /files/{id} when they run it and
+// keep it in their -file-cache; IDs are content hashes (see
+// shared/files.go), so the orchestrator can serve files as immutable and a
+// cached copy is never stale. The cloud fallback reads them here.
+//
+// Files live under <data-dir>/files: the content as <id>, its FileInfo as
+// <id>.json. They're kept until removed with DELETE /admin/files/{id}.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// maxFileBytes is the largest upload accepted; set from -max-file-bytes.
+var maxFileBytes int64 = 64 << 20
+
+var fileStore *FileStore
+
+// FileStore indexes the uploaded files under a directory.
+type FileStore struct {
+	dir   string
+	mu    sync.RWMutex
+	files map[string]shared.FileInfo
+}
+
+// NewFileStore loads the files under <dataDir>/files.
+func NewFileStore(dataDir string) *FileStore {
+	s := &FileStore{dir: filepath.Join(dataDir, "files"), files: make(map[string]shared.FileInfo)}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		log.Printf("[Files] Cannot create %s: %v", s.dir, err)
+	}
+	metas, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	for _, path := range metas {
+		var info shared.FileInfo
+		data, err := os.ReadFile(path)
+		if err != nil || json.Unmarshal(data, &info) != nil || !shared.ValidFileID(info.ID) {
+			continue
+		}
+		if _, err := os.Stat(s.path(info.ID)); err == nil {
+			s.files[info.ID] = info
+		}
+	}
+	log.Printf("[Files] Loaded %d files from %s", len(s.files), s.dir)
+	return s
+}
+
+func (s *FileStore) path(id string) string { return filepath.Join(s.dir, id) }
+
+// Put stores the content read from r, up to maxFileBytes. Content already
+// stored keeps its first upload's name and time; created reports whether
+// it's new.
+func (s *FileStore) Put(name, contentType string, r io.Reader) (info shared.FileInfo, created bool, err error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return info, false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	head := make([]byte, 0, 512)
+	n, err := io.Copy(io.MultiWriter(tmp, hash, &prefixWriter{buf: &head}), io.LimitReader(r, maxFileBytes+1))
+	if err != nil {
+		return info, false, err
+	}
+	if n > maxFileBytes {
+		return info, false, errFileTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return info, false, err
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.files[id]; ok {
+		return existing, false, nil
+	}
+	// Generic types (curl --data-binary sends a form type) are sniffed
+	switch contentType {
+	case "", "application/octet-stream", "application/x-www-form-urlencoded":
+		contentType = http.DetectContentType(head)
+	}
+	info = shared.FileInfo{ID: id, Name: name, ContentType: contentType, SizeBytes: n, CreatedAt: time.Now().UnixMilli()}
+	meta, _ := json.Marshal(info)
+	if err := os.Rename(tmp.Name(), s.path(id)); err != nil {
+		return info, false, err
+	}
+	if err := os.WriteFile(s.path(id)+".json", meta, 0o644); err != nil {
+		os.Remove(s.path(id))
+		return info, false, err
+	}
+	s.files[id] = info
+	return info, true, nil
+}
+
+// errFileTooLarge is returned by Put for uploads over -max-file-bytes.
+var errFileTooLarge = errors.New("file too large")
+
+// prefixWriter keeps the first cap(buf) bytes written, for sniffing the
+// content type.
+type prefixWriter struct{ buf *[]byte }
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if room := cap(*p.buf) - len(*p.buf); room > 0 {
+		*p.buf = append(*p.buf, b[:min(room, len(b))]...)
+	}
+	return len(b), nil
+}
+
+// Get returns a file's info.
+func (s *FileStore) Get(id string) (shared.FileInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, ok := s.files[id]
+	return info, ok
+}
+
+// Read returns a file's content.
+func (s *FileStore) Read(id string) (shared.FileInfo, []byte, error) {
+	info, ok := s.Get(id)
+	if !ok {
+		return info, nil, fmt.Errorf("unknown file %s", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	return info, data, err
+}
+
+// List returns every file, newest first.
+func (s *FileStore) List() []shared.FileInfo {
+	s.mu.RLock()
+	list := make([]shared.FileInfo, 0, len(s.files))
+	for _, info := range s.files {
+		list = append(list, info)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	return list
+}
+
+// Delete removes a file; tasks still referencing it will fail.
+func (s *FileStore) Delete(id string) (shared.FileInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.files[id]
+	if !ok {
+		return info, false
+	}
+	delete(s.files, id)
+	os.Remove(s.path(id))
+	os.Remove(s.path(id) + ".json")
+	return info, true
+}
+
+// checkFiles rejects a task referencing more than shared.MaxTaskFiles
+// files, or any that isn't stored.
+func checkFiles(ids []string) error {
+	if len(ids) > shared.MaxTaskFiles {
+		return fmt.Errorf("task references %d files, over the limit of %d", len(ids), shared.MaxTaskFiles)
+	}
+	for _, id := range ids {
+		if _, ok := fileStore.Get(id); !ok {
+			return fmt.Errorf("unknown file %q (upload it with POST /files)", id)
+		}
+	}
+	return nil
+}
+
+// expandFiles reads a task's files and puts them in front of its prompt,
+// for backends that can't fetch them themselves (the cloud fallback).
+func expandFiles(req shared.TaskRequest) (string, [][]byte, error) {
+	files := make([]shared.TaskFile, 0, len(req.Files))
+	for _, id := range req.Files {
+		info, data, err := fileStore.Read(id)
+		if err != nil {
+			return "", nil, err
+		}
+		files = append(files, shared.TaskFile{Name: fileLabel(info), Data: data})
+	}
+	return shared.ExpandFiles(req.Prompt, files)
+}
+
+// fileLabel names a file in prompts: its upload name, else its ID.
+func fileLabel(info shared.FileInfo) string {
+	if info.Name != "" {
+		return info.Name
+	}
+	return info.ID
+}
+
+// ─── Client: POST /files ──────────────────────────────────────────────────────
+
+func handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if name == "." || name == string(filepath.Separator) {
+		name = ""
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	info, created, err := fileStore.Put(name, contentType, r.Body)
+	if errors.Is(err, errFileTooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is over the %d-byte limit", maxFileBytes))
+		return
+	}
+	if err != nil {
+		log.Printf("[Files] Upload failed: %v", err)
+		writeProblem(w, r, http.StatusInternalServerError, "could not store file")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		log.Printf("[Files] Stored %s (%s, %d bytes)", info.ID[:12], fileLabel(info), info.SizeBytes)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(info)
+}
+
+// ─── Client: GET /files, GET /files/{id} ──────────────────────────────────────
+
+func handleListFiles(w http.ResponseWriter, r *http.Request) {
+	list := fileStore.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"files": list,
+		"count": len(list),
+	})
+}
+
+// handleGetFile serves a file's content. The ID is the content's hash, so
+// it's served as immutable.
+func handleGetFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	info, ok := fileStore.Get(id)
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	f, err := os.Open(fileStore.path(id))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("ETag", `"`+id+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if info.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": info.Name}))
+	}
+	http.ServeContent(w, r, "", time.UnixMilli(info.CreatedAt), f)
+}
+
+// ─── Admin: DELETE /admin/files/{id} ──────────────────────────────────────────
+
+func handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	info, ok := fileStore.Delete(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	log.Printf("[Admin] File %s (%s) removed", info.ID[:12], fileLabel(info))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
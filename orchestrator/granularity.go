@@ -0,0 +1,85 @@
+// orchestrator/granularity.go
+// Coarser streaming granularity for /task/stream.
+//
+// Agents stream one token at a time. A client that asks for
+// stream_granularity "word" or "sentence" gets the text in whole words or
+// sentences instead: the orchestrator holds tokens back until a boundary
+// and sends everything up to it as one chunk, and whatever is left with
+// the done chunk. Simple frontends re-render less often and remote clients
+// receive fewer, larger events.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"echo-system/shared"
+)
+
+// tokenBatcher holds streamed text back until a boundary of its
+// granularity.
+type tokenBatcher struct {
+	granularity shared.StreamGranularity
+	pending     string
+}
+
+// add appends a token and returns the text now ready to send, "" while
+// it's all held back.
+func (b *tokenBatcher) add(token string) string {
+	b.pending += token
+	cut := 0
+	switch b.granularity {
+	case shared.GranularityWord:
+		cut = wordBoundary(b.pending)
+	case shared.GranularitySentence:
+		cut = sentenceBoundary(b.pending)
+	default:
+		cut = len(b.pending)
+	}
+	ready := b.pending[:cut]
+	b.pending = b.pending[cut:]
+	return ready
+}
+
+// flush returns everything held back.
+func (b *tokenBatcher) flush() string {
+	ready := b.pending
+	b.pending = ""
+	return ready
+}
+
+// held is how many bytes of text are held back.
+func (b *tokenBatcher) held() int {
+	return len(b.pending)
+}
+
+// wordBoundary returns the end of the last whitespace in s, 0 if none.
+func wordBoundary(s string) int {
+	cut := 0
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			cut = i + utf8.RuneLen(r)
+		}
+	}
+	return cut
+}
+
+// sentenceBoundary returns the end of the last complete sentence in s, 0
+// if none: a terminator followed by whitespace, a line break, or a CJK
+// full stop (which isn't followed by a space).
+func sentenceBoundary(s string) int {
+	cut := 0
+	var prev rune
+	for i, r := range s {
+		switch {
+		case r == '\n' || strings.ContainsRune("。！？", r):
+			cut = i + utf8.RuneLen(r)
+		case unicode.IsSpace(r) && strings.ContainsRune(".!?…", prev):
+			cut = i + utf8.RuneLen(r)
+		}
+		prev = r
+	}
+	return cut
+}
@@ -0,0 +1,159 @@
+// orchestrator/health.go
+// Traffic-light health grades for nodes.
+//
+// NodeStatus is what routing needs; people watching the mesh want one
+// answer to "is this node OK?". Each node gets a grade computed whenever it
+// is read, from eight signals, the worst one winning:
+//
+//	heartbeat   yellow after two missed beats, red once marked offline
+//	failures    the fast-moving failure rate of its recent tasks, for five
+//	            minutes after the last failure
+//	pressure    overloaded/busy, backend down, little free disk or VRAM,
+//	            shedding load for its temperature
+//	reputation  the long-run success rate routing also weighs
+//	clock       yellow while its clock is off by more than -max-clock-skew
+//	link        yellow while its link to the orchestrator grades poor
+//	models      yellow while its capabilities are stale (see capexpiry.go)
+//	version     yellow while its release differs from the orchestrator's
+//
+// The grade and the reason it isn't green appear in /status, node events
+// and the routing log lines.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// failureRateAlpha is the EWMA factor for FailureRate — much faster
+	// than reputationAlpha so a node that starts failing shows it at once.
+	failureRateAlpha = 0.3
+
+	// healthStaleHeartbeatMs is the heartbeat age (two missed 3s beats)
+	// after which a node turns yellow.
+	healthStaleHeartbeatMs = 7_000
+
+	// healthFailureMemoryMs is how long after its last failure a node's
+	// failure rate still counts; an idle node gets no successes to dilute it.
+	healthFailureMemoryMs = 5 * 60_000
+)
+
+// healthCheck is the worst signal seen so far.
+type healthCheck struct {
+	grade  shared.HealthGrade
+	reason string
+}
+
+// nodeHealth grades node at now.
+func nodeHealth(node *shared.NodeInfo, now time.Time) (shared.HealthGrade, string) {
+	var worst healthCheck
+	check := func(grade shared.HealthGrade, reason string, args ...any) {
+		if healthRank(grade) > healthRank(worst.grade) {
+			worst = healthCheck{grade, fmt.Sprintf(reason, args...)}
+		}
+	}
+
+	// Heartbeat freshness
+	age := now.UnixMilli() - node.LastHeartbeat
+	switch {
+	case node.Status == shared.StatusOffline || !isAlive(node):
+		check(shared.HealthRed, "no heartbeat for %ds", age/1000)
+	case age >= healthStaleHeartbeatMs:
+		check(shared.HealthYellow, "last heartbeat %ds ago", age/1000)
+	}
+
+	// Recent failures
+	recent := now.UnixMilli()-node.LastFailureAt < healthFailureMemoryMs
+	switch {
+	case !recent:
+	case node.FailureRate >= 0.5:
+		check(shared.HealthRed, "%.0f%% of recent tasks failed", node.FailureRate*100)
+	case node.FailureRate >= 0.2:
+		check(shared.HealthYellow, "%.0f%% of recent tasks failed", node.FailureRate*100)
+	}
+
+	// Pressure; a hot node is busy too, but the heat is the news
+	if state := thermalState(node.Thermal); state != shared.ThermalNormal {
+		check(shared.HealthYellow, "%s (%s)", state, formatTemps(node.Thermal))
+	}
+	switch node.Status {
+	case shared.StatusBackendDown:
+		check(shared.HealthRed, "backend not responding")
+	case shared.StatusOverloaded:
+		check(shared.HealthYellow, "overloaded")
+	case shared.StatusBusy:
+		check(shared.HealthYellow, "busy (%d active tasks)", node.ActiveTasks)
+	}
+	if res := node.Resources; res != nil {
+		if res.DiskTotalBytes > 0 && float64(res.DiskFreeBytes) < 0.05*float64(res.DiskTotalBytes) {
+			check(shared.HealthYellow, "disk almost full (%s free)", formatBytes(res.DiskFreeBytes))
+		}
+		if res.VRAMTotalBytes > 0 && float64(res.VRAMFreeBytes) < 0.05*float64(res.VRAMTotalBytes) {
+			check(shared.HealthYellow, "VRAM almost full (%s free)", formatBytes(res.VRAMFreeBytes))
+		}
+	}
+
+	// Reputation
+	switch {
+	case node.Reputation < 0.5:
+		check(shared.HealthRed, "reputation %.2f", node.Reputation)
+	case node.Reputation < 0.8:
+		check(shared.HealthYellow, "reputation %.2f", node.Reputation)
+	}
+
+	// Clock
+	if skewExceeded(node.ClockSkewMs) {
+		check(shared.HealthYellow, "clock %s", formatSkew(node.ClockSkewMs))
+	}
+
+	// Link
+	if node.Link != nil && node.Link.Grade == shared.LinkPoor {
+		check(shared.HealthYellow, "poor link (%s)", formatLink(node.Link))
+	}
+
+	// Models
+	if node.CapabilitiesStale {
+		check(shared.HealthYellow, "models unconfirmed for %s", formatUnconfirmed(node, now))
+	}
+
+	// Version
+	if skew := versionSkew(node.Version); skew != "" {
+		check(shared.HealthYellow, "%s", skew)
+	}
+
+	if worst.grade == "" {
+		return shared.HealthGreen, ""
+	}
+	return worst.grade, worst.reason
+}
+
+// healthRank orders grades from best to worst.
+func healthRank(g shared.HealthGrade) int {
+	switch g {
+	case shared.HealthGreen:
+		return 1
+	case shared.HealthYellow:
+		return 2
+	case shared.HealthRed:
+		return 3
+	}
+	return 0
+}
+
+// gradeNode fills in a node copy's health as of now.
+func gradeNode(node *shared.NodeInfo) {
+	node.Health, node.HealthReason = nodeHealth(node, time.Now())
+}
+
+// healthLabel renders a node's grade for log lines, e.g. "yellow: busy".
+func healthLabel(node *shared.NodeInfo) string {
+	grade, reason := nodeHealth(node, time.Now())
+	if reason == "" {
+		return string(grade)
+	}
+	return fmt.Sprintf("%s: %s", grade, reason)
+}
@@ -0,0 +1,371 @@
+// orchestrator/history.go
+// Persistent history store for pipeline runs.
+//
+// Every pipeline run is written to disk as a JSON file under
+// <data-dir>/pipelines/<hex of pipeline_id>.json. The file is rewritten
+// after each step so results of long overnight pipelines survive client
+// disconnects and orchestrator restarts. A pipeline_id can't start a new run
+// while a run under it is still going.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// errRunActive is returned by StartRun for a pipeline ID whose run is still
+// going.
+var errRunActive = errors.New("a run with this pipeline_id is still running")
+
+// HistoryStore keeps pipeline runs in memory and mirrors them to disk.
+type HistoryStore struct {
+	mu   sync.RWMutex
+	dir  string                         // directory holding one JSON file per run
+	runs map[string]*shared.PipelineRun // keyed by pipeline_id
+
+	// tasks maps a step's task ID to the run that produced it, for lineage
+	// lookups and collision detection
+	tasks map[string]string
+
+	// interrupted are the runs this process found running at startup
+	interrupted []string
+}
+
+// NewHistoryStore opens (or creates) the history directory under dataDir and
+// loads any runs persisted by a previous orchestrator process.
+func NewHistoryStore(dataDir string) (*HistoryStore, error) {
+	dir := filepath.Join(dataDir, "pipelines")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create history dir: %w", err)
+	}
+	h := &HistoryStore{
+		dir:   dir,
+		runs:  make(map[string]*shared.PipelineRun),
+		tasks: make(map[string]string),
+	}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// load reads every persisted run from disk. Runs that were still "running"
+// belong to a previous process that died mid-pipeline, so they are marked
+// interrupted.
+func (h *HistoryStore) load() error {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return fmt.Errorf("read history dir: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(h.dir, e.Name()))
+		if err != nil {
+			log.Printf("[History] Skipping %s: %v", e.Name(), err)
+			continue
+		}
+		var run shared.PipelineRun
+		if err := json.Unmarshal(raw, &run); err != nil {
+			log.Printf("[History] Skipping corrupt run %s: %v", e.Name(), err)
+			continue
+		}
+		if run.Status == shared.RunRunning {
+			run.Status = shared.RunInterrupted
+			run.Error = "orchestrator restarted before the pipeline finished"
+			if err := h.write(&run); err != nil {
+				log.Printf("[History] Failed to mark %s interrupted: %v", run.PipelineID, err)
+			}
+			h.interrupted = append(h.interrupted, run.PipelineID)
+		}
+		h.runs[run.PipelineID] = &run
+		for _, step := range run.Steps {
+			h.indexStep(run.PipelineID, step)
+		}
+		h.rename(e.Name(), &run)
+	}
+	log.Printf("[History] Loaded %d pipeline runs from %s", len(h.runs), h.dir)
+	return nil
+}
+
+// ─── Recording ────────────────────────────────────────────────────────────────
+
+// rename moves a run loaded from a file named otherwise, by an older
+// orchestrator, to its file.
+func (h *HistoryStore) rename(name string, run *shared.PipelineRun) {
+	path := h.runPath(run.PipelineID)
+	if filepath.Join(h.dir, name) == path {
+		return
+	}
+	if err := h.write(run); err != nil {
+		log.Printf("[History] Failed to rename %s: %v", name, err)
+		return
+	}
+	os.Remove(filepath.Join(h.dir, name))
+}
+
+// StartRun records a new pipeline run in the "running" state, or returns
+// errRunActive if a run with its ID is still running. A re-run replaces the
+// run before it; StartRun returns that run's last attempts (see
+// LastAttempts), read under the same lock, for the re-run's steps to carry
+// on from.
+func (h *HistoryStore) StartRun(req shared.PipelineRequest) (map[int]shared.PipelineStepResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev, ok := h.runs[req.PipelineID]
+	if ok && prev.Status == shared.RunRunning {
+		return nil, errRunActive
+	}
+	previous := lastAttempts(prev)
+	run := &shared.PipelineRun{
+		PipelineID: req.PipelineID,
+		Status:     shared.RunRunning,
+		Definition: req,
+		Steps:      []shared.PipelineStepResult{},
+		StartedAt:  time.Now().UnixMilli(),
+	}
+	h.runs[run.PipelineID] = run
+	h.persist(run)
+	return previous, nil
+}
+
+// RecordStep appends a finished step result to a running pipeline.
+func (h *HistoryStore) RecordStep(pipelineID string, step shared.PipelineStepResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	run, ok := h.runs[pipelineID]
+	if !ok {
+		return
+	}
+	if owner, dup := h.tasks[step.TaskID]; dup {
+		log.Printf("[History] Task ID collision: %s (step %d of %s) already recorded for pipeline %s",
+			step.TaskID, step.StepIndex, pipelineID, owner)
+	}
+	h.indexStep(pipelineID, step)
+	run.Steps = append(run.Steps, step)
+	h.persist(run)
+}
+
+// indexStep maps a step's task ID, and those of its map items, to the run.
+// Must be called with h.mu held.
+func (h *HistoryStore) indexStep(pipelineID string, step shared.PipelineStepResult) {
+	h.tasks[step.TaskID] = pipelineID
+	for _, item := range step.Items {
+		h.tasks[item.TaskID] = pipelineID
+	}
+}
+
+// LastAttempts returns the latest recorded attempt of each step of a
+// pipeline run, keyed by step index: a resumed run's steps continue the
+// attempt count and lineage of these.
+func (h *HistoryStore) LastAttempts(pipelineID string) map[int]shared.PipelineStepResult {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return lastAttempts(h.runs[pipelineID])
+}
+
+// lastAttempts returns the latest attempt of each step of run (nil for
+// none). Must be called with h.mu held.
+func lastAttempts(run *shared.PipelineRun) map[int]shared.PipelineStepResult {
+	last := make(map[int]shared.PipelineStepResult)
+	if run == nil {
+		return last
+	}
+	for _, step := range run.Steps {
+		if step.Attempt >= last[step.StepIndex].Attempt {
+			last[step.StepIndex] = step
+		}
+	}
+	return last
+}
+
+// Interrupted returns copies of the runs that were still running when the
+// previous process died, for recovery.go.
+func (h *HistoryStore) Interrupted() []shared.PipelineRun {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var runs []shared.PipelineRun
+	for _, id := range h.interrupted {
+		if run, ok := h.runs[id]; ok && run.Status == shared.RunInterrupted {
+			copied := *run
+			copied.Steps = append([]shared.PipelineStepResult(nil), run.Steps...)
+			runs = append(runs, copied)
+		}
+	}
+	return runs
+}
+
+// ResumeRun puts an interrupted run back in the "running" state with the
+// steps it's resumed after.
+func (h *HistoryStore) ResumeRun(pipelineID string, steps []shared.PipelineStepResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	run, ok := h.runs[pipelineID]
+	if !ok {
+		return
+	}
+	run.Status = shared.RunRunning
+	run.Error = ""
+	run.ResumedAt = time.Now().UnixMilli()
+	run.Steps = append([]shared.PipelineStepResult(nil), steps...)
+	for _, step := range run.Steps {
+		h.indexStep(run.PipelineID, step)
+	}
+	h.persist(run)
+}
+
+// FinishRun stores the final outcome of a pipeline run.
+func (h *HistoryStore) FinishRun(result *shared.PipelineResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	run, ok := h.runs[result.PipelineID]
+	if !ok {
+		return
+	}
+	run.Steps = result.Steps
+	for _, step := range run.Steps {
+		h.indexStep(run.PipelineID, step)
+	}
+	run.FinalOutput = result.FinalOutput
+	run.Error = result.Error
+	run.LatencyMs = result.LatencyMs
+	run.FinishedAt = time.Now().UnixMilli()
+	if result.Success {
+		run.Status = shared.RunSucceeded
+	} else {
+		run.Status = shared.RunFailed
+	}
+	h.persist(run)
+}
+
+// persist writes a run to disk, logging (not returning) failures so a full
+// disk never breaks an in-flight pipeline. Must be called with the lock held.
+func (h *HistoryStore) persist(run *shared.PipelineRun) {
+	if err := h.write(run); err != nil {
+		log.Printf("[History] Failed to persist run %s: %v", run.PipelineID, err)
+	}
+}
+
+// write atomically replaces the run's JSON file (write temp + rename).
+func (h *HistoryStore) write(run *shared.PipelineRun) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := h.runPath(run.PipelineID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runPath maps a pipeline ID to its file. The name is the ID in hex, so a
+// client-supplied ID can't escape the history directory, and no two IDs
+// share a file, even on a case-insensitive filesystem.
+func (h *HistoryStore) runPath(pipelineID string) string {
+	return filepath.Join(h.dir, hex.EncodeToString([]byte(pipelineID))+".json")
+}
+
+// ─── Queries ──────────────────────────────────────────────────────────────────
+
+// GetRun returns a copy of a single run, or false if it doesn't exist.
+func (h *HistoryStore) GetRun(pipelineID string) (*shared.PipelineRun, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	run, ok := h.runs[pipelineID]
+	if !ok {
+		return nil, false
+	}
+	copy := *run
+	copy.Steps = append([]shared.PipelineStepResult(nil), run.Steps...)
+	return &copy, true
+}
+
+// ListRuns returns summaries of the runs submitted by source (all runs if
+// it's empty), newest first. See TaskSource.Matches.
+func (h *HistoryStore) ListRuns(source string) []shared.PipelineRunSummary {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := make([]shared.PipelineRunSummary, 0, len(h.runs))
+	for _, run := range h.runs {
+		if source != "" && !run.Definition.Source.Matches(source) {
+			continue
+		}
+		list = append(list, shared.PipelineRunSummary{
+			PipelineID:     run.PipelineID,
+			Status:         run.Status,
+			TotalSteps:     len(run.Definition.Steps),
+			CompletedSteps: len(run.Steps),
+			StartedAt:      run.StartedAt,
+			FinishedAt:     run.FinishedAt,
+			LatencyMs:      run.LatencyMs,
+			Metadata:       run.Definition.Metadata,
+			Source:         run.Definition.Source,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt > list[j].StartedAt })
+	return list
+}
+
+// FindTask resolves a step (or map item) task ID to its lineage and the
+// recorded step result.
+func (h *HistoryStore) FindTask(taskID string) (*shared.TaskLineageRecord, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	run, ok := h.runs[h.tasks[taskID]]
+	if !ok {
+		return nil, false
+	}
+	for _, step := range run.Steps {
+		item := 0
+		if step.TaskID != taskID {
+			for _, it := range step.Items {
+				if it.TaskID == taskID {
+					item = it.Index + 1
+				}
+			}
+			if item == 0 {
+				continue
+			}
+		}
+		return &shared.TaskLineageRecord{
+			TaskID: taskID,
+			Lineage: &shared.TaskLineage{
+				PipelineID: run.PipelineID,
+				StepIndex:  step.StepIndex,
+				Attempt:    step.Attempt,
+				Item:       item,
+			},
+			RunURL: runURL(run.PipelineID),
+			Step:   &step,
+		}, true
+	}
+	return nil, false
+}
+
+// runURL is the link clients use to fetch a persisted run.
+func runURL(pipelineID string) string {
+	return externalPath("/pipelines/runs/" + pipelineID)
+}
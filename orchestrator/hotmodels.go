@@ -0,0 +1,102 @@
+// orchestrator/hotmodels.go
+// Back-to-back tasks on one node and model.
+//
+// A batch job — a map step summarizing fifty sections, a script looping over
+// documents — sends one node task after task for the same model. Two things
+// cost time between them: a fresh connection per task once more than two
+// are in flight to the agent (http.DefaultTransport keeps only two idle per
+// host), and Ollama unloading the model when its keep-alive runs out or
+// another model wants the memory, so the next task waits for a reload.
+// Tasks go to agents through agentClient, which keeps enough idle
+// connections per agent for a batch to reuse them, and the orchestrator
+// tracks runs per (node, model): a task dispatched while another for the
+// same node and model is in flight, or within hotRunGap of the last one
+// finishing, carries a keep-alive (-keep-model-hot) that the agent hands to
+// Ollama, so the model stays resident until the run is over.
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hotRunGap is how long after a task finishes the next one for the same
+// node and model still continues its run.
+const hotRunGap = 5 * time.Second
+
+// keepModelHot is the keep-alive sent with back-to-back tasks; set from the
+// -keep-model-hot flag. 0 leaves the model's lifetime to the backend.
+var keepModelHot = 10 * time.Minute
+
+// agentClient sends tasks to agents. It's http.DefaultClient with more idle
+// connections kept per agent, so concurrent tasks to a node reuse them
+// instead of dialing anew. The transport is a clone of the default one,
+// which keeps unix-socket agents reachable (see shared/unixsock.go).
+var agentClient = &http.Client{Transport: agentTransport()}
+
+func agentTransport() http.RoundTripper {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
+	}
+	t = t.Clone()
+	t.MaxIdleConns = 256
+	t.MaxIdleConnsPerHost = 32
+	return t
+}
+
+var runs = &runTracker{runs: make(map[lockKey]*modelRun)}
+
+// modelRun is the current run of tasks for one node and model.
+type modelRun struct {
+	inFlight int
+	tasks    int       // tasks in the run so far
+	lastDone time.Time // when the last task finished
+}
+
+// runTracker follows runs of tasks per (node, model).
+type runTracker struct {
+	mu   sync.Mutex
+	runs map[lockKey]*modelRun
+}
+
+// begin records a task dispatched to nodeID for model. It returns the
+// keep-alive to send with the task ("" unless it continues a run) and the
+// function to call when the task finishes.
+func (t *runTracker) begin(nodeID, model string) (keepAlive string, end func()) {
+	if keepModelHot <= 0 || model == "" {
+		return "", func() {}
+	}
+	key := lockKey{nodeID, model}
+
+	t.mu.Lock()
+	run, ok := t.runs[key]
+	if !ok {
+		run = &modelRun{}
+		t.runs[key] = run
+	}
+	if run.inFlight == 0 && time.Since(run.lastDone) > hotRunGap {
+		run.tasks = 0
+	}
+	run.inFlight++
+	run.tasks++
+	continues := run.tasks > 1
+	if run.tasks == 2 {
+		log.Printf("[Runs] Back-to-back tasks for %s on %s — keeping it loaded for %s", model, nodeID, keepModelHot)
+	}
+	t.mu.Unlock()
+
+	end = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		run.inFlight--
+		run.lastDone = time.Now()
+	}
+	if continues {
+		keepAlive = keepModelHot.String()
+	}
+	return keepAlive, end
+}
@@ -0,0 +1,188 @@
+// orchestrator/identity.go
+// Pinning node IDs to agent identity keys.
+//
+// Agents with an identity file sign their registrations (see
+// shared/identity.go). The first key to register a node ID is pinned to
+// it; afterwards only a registration signed by that key may claim the ID,
+// and one that is may do so straight away, even while the node still
+// looks alive — it's the same agent, restarted or moved to another host
+// or port, and it takes over its node with the stats and reputation the
+// registry kept for it. Without a key, registration works as before: the
+// ID is free once the node has gone offline.
+//
+// Evicting a node (DELETE /admin/nodes/{id}) forgets its key too, so an
+// agent that lost its identity file can claim its ID again. Pins are
+// saved to <data-dir>/identities.json.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+var (
+	errBadIdentity      = errors.New("invalid identity proof")
+	errIdentityMismatch = errors.New("node ID is pinned to another agent's identity key")
+)
+
+// identityPin is a node ID's pinned key.
+type identityPin struct {
+	NodeID    string `json:"node_id"`
+	Key       string `json:"key"` // base64 Ed25519 public key
+	PinnedAt  int64  `json:"pinned_at"`
+	LastProof int64  `json:"last_proof"` // time of the last accepted registration, in the agent's clock
+}
+
+// identityStore holds the pins; the mutex also serializes saves.
+type identityStore struct {
+	mu   sync.Mutex
+	pins map[string]*identityPin
+	path string // "" until loadIdentities; nothing is saved
+}
+
+var identities = &identityStore{pins: make(map[string]*identityPin)}
+
+// admit runs a registration past its node's pinned key, registers it with
+// register and pins its key, holding s.mu throughout: two first
+// registrations of a node ID with different keys can't both pass the
+// check before either is pinned. status is the HTTP status for err.
+func (s *identityStore) admit(req shared.RegisterRequest, register func(proven bool) (string, error)) (session string, status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proven, err := s.check(req)
+	if err != nil {
+		return "", identityStatus(err), err
+	}
+	if session, err = register(proven); err != nil {
+		return "", sessionStatus(err), err
+	}
+	s.accept(req)
+	return session, 0, nil
+}
+
+// check verifies a registration against the node's pinned key. proven
+// reports that it was signed by that key, which lets it take over a live
+// node; a key not pinned yet proves nothing. Must be called with s.mu
+// held.
+func (s *identityStore) check(req shared.RegisterRequest) (proven bool, err error) {
+	pin := s.pins[req.NodeID]
+	if req.IdentityKey == "" {
+		if pin != nil {
+			return false, fmt.Errorf("%w; register with its identity file or have an operator evict the node", errIdentityMismatch)
+		}
+		return false, nil
+	}
+	if err := shared.VerifyIdentityProof(&req); err != nil {
+		return false, fmt.Errorf("%w: %v", errBadIdentity, err)
+	}
+	switch {
+	case pin == nil:
+		return false, nil
+	case pin.Key != req.IdentityKey:
+		return false, fmt.Errorf("%w (%s, not %s); choose another -id or have an operator evict the node",
+			errIdentityMismatch, shared.IdentityFingerprint(pin.Key), shared.IdentityFingerprint(req.IdentityKey))
+	case req.Time <= pin.LastProof:
+		return false, fmt.Errorf("%w: the registration is no newer than the last one accepted (a replay, or the agent's clock went back)", errBadIdentity)
+	}
+	return true, nil
+}
+
+// accept records a registration that check let through and the registry
+// took: the node's key is pinned if it wasn't, and the proof's time is
+// remembered. Must be called with s.mu held.
+func (s *identityStore) accept(req shared.RegisterRequest) {
+	if req.IdentityKey == "" {
+		return
+	}
+	pin := s.pins[req.NodeID]
+	if pin == nil {
+		pin = &identityPin{NodeID: req.NodeID, Key: req.IdentityKey, PinnedAt: time.Now().UnixMilli()}
+		s.pins[req.NodeID] = pin
+		log.Printf("[Registry] Node %s pinned to identity %s", req.NodeID, shared.IdentityFingerprint(req.IdentityKey))
+	}
+	pin.LastProof = max(pin.LastProof, req.Time)
+	s.save()
+}
+
+// forget drops a node's pin; false if it had none.
+func (s *identityStore) forget(nodeID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pins[nodeID]; !ok {
+		return false
+	}
+	delete(s.pins, nodeID)
+	s.save()
+	log.Printf("[Registry] Node %s's identity forgotten", nodeID)
+	return true
+}
+
+// identityStatus maps a check error to its HTTP status: 409 for a node
+// pinned to another key, 401 for a proof that doesn't check out.
+func identityStatus(err error) int {
+	if errors.Is(err, errIdentityMismatch) {
+		return http.StatusConflict
+	}
+	return http.StatusUnauthorized
+}
+
+// ─── Persistence ──────────────────────────────────────────────────────────────
+
+func loadIdentities(dataDir string) {
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+	identities.path = filepath.Join(dataDir, "identities.json")
+	raw, err := os.ReadFile(identities.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Registry] Failed to read %s: %v", identities.path, err)
+		}
+		return
+	}
+	var list []identityPin
+	if err := json.Unmarshal(raw, &list); err != nil {
+		log.Printf("[Registry] Ignoring unreadable %s: %v", identities.path, err)
+		return
+	}
+	for i := range list {
+		identities.pins[list[i].NodeID] = &list[i]
+	}
+	log.Printf("[Registry] Restored %d node identity pin(s)", len(list))
+}
+
+// save persists the pins (write temp + rename). Must be called with s.mu
+// held.
+func (s *identityStore) save() {
+	if s.path == "" {
+		return
+	}
+	list := make([]*identityPin, 0, len(s.pins))
+	for _, p := range s.pins {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NodeID < list[j].NodeID })
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		log.Printf("[Registry] Failed to save identity pins: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("[Registry] Failed to save identity pins: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.Printf("[Registry] Failed to save identity pins: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// orchestrator/inventory.go
+// Static inventory of expected nodes.
+//
+// Nodes join by registering, so a node that never comes up simply isn't
+// there — nothing says it's missing. With -inventory the orchestrator reads
+// the nodes it expects from a JSON file:
+//
+//	{"nodes": [
+//	  {"node_id": "node-a", "host": "10.0.0.5", "port": 9001, "models": ["mistral"]},
+//	  {"node_id": "node-b", "host": "10.0.0.6", "port": 9001, "models": ["codellama"]}
+//	]}
+//
+// Listed nodes that aren't registered appear in GET /status with status
+// "absent", and each one that hasn't joined -inventory-grace after the
+// orchestrator started is reported once with a node_absent event. The
+// inventory doesn't route: nodes still have to register to get tasks.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"echo-system/shared"
+)
+
+// inventory is the expected nodes; nil without -inventory.
+var inventory *Inventory
+
+// inventoryNode is one expected node in the inventory file.
+type inventoryNode struct {
+	NodeID string   `json:"node_id"`
+	Host   string   `json:"host,omitempty"`
+	Port   int      `json:"port,omitempty"`
+	Models []string `json:"models,omitempty"`
+}
+
+// Inventory is the set of nodes the mesh is expected to have.
+type Inventory struct {
+	nodes []inventoryNode
+}
+
+// loadInventory reads an inventory file.
+func loadInventory(path string) (*Inventory, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading inventory: %w", err)
+	}
+	var file struct {
+		Nodes []inventoryNode `json:"nodes"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parsing inventory %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, n := range file.Nodes {
+		if n.NodeID == "" {
+			return nil, fmt.Errorf("inventory %s: node %d has no node_id", path, i+1)
+		}
+		if seen[n.NodeID] {
+			return nil, fmt.Errorf("inventory %s: node %s is listed twice", path, n.NodeID)
+		}
+		seen[n.NodeID] = true
+		if n.Models == nil {
+			file.Nodes[i].Models = []string{}
+		}
+	}
+	log.Printf("[Inventory] Expecting %d nodes from %s", len(file.Nodes), path)
+	return &Inventory{nodes: file.Nodes}, nil
+}
+
+// absent returns the inventory's nodes missing from registered, as
+// NodeInfos with status absent. Safe on a nil inventory.
+func (inv *Inventory) absent(registered []*shared.NodeInfo) []*shared.NodeInfo {
+	if inv == nil {
+		return nil
+	}
+	present := make(map[string]bool, len(registered))
+	for _, n := range registered {
+		present[n.NodeID] = true
+	}
+	var list []*shared.NodeInfo
+	for _, n := range inv.nodes {
+		if present[n.NodeID] {
+			continue
+		}
+		list = append(list, &shared.NodeInfo{
+			NodeID:       n.NodeID,
+			AgentHost:    n.Host,
+			AgentPort:    n.Port,
+			Models:       n.Models,
+			Capabilities: []shared.ModelCapability{},
+			Status:       shared.StatusAbsent,
+			Health:       shared.HealthRed,
+			HealthReason: "listed in the inventory but not registered",
+		})
+	}
+	return list
+}
+
+// watch reports the nodes that haven't joined once grace has passed.
+func (inv *Inventory) watch(grace time.Duration) {
+	if inv == nil || grace <= 0 {
+		return
+	}
+	time.AfterFunc(grace, func() {
+		for _, node := range inv.absent(registry.AllNodes()) {
+			log.Printf("[Inventory] Node %s hasn't joined within %s of startup", node.NodeID, grace)
+			EmitNodeAbsent(node)
+		}
+	})
+}
@@ -0,0 +1,357 @@
+// orchestrator/jsonmode.go
+// JSON mode: tasks with "format": "json".
+//
+// Agents hand the format to their backend (Ollama's format option, a JSON
+// grammar on llama.cpp) and the cloud fallback asks for a JSON object,
+// which usually yields valid JSON — but not always: a generation cut off
+// at the token limit, or a model that wraps the document in prose, leaves
+// it broken. The orchestrator checks streamed output as it arrives with a
+// jsonScanner, so the verdict is ready the moment the node is done, and
+// checks /task results whole. Invalid output gets one repair re-prompt to
+// the same model before the client sees the end of the task: /task returns
+// the repaired document, /task/stream sends it as the done chunk's text
+// with json_repaired set. If the repair fails too, the task fails: /task
+// results get error_code INVALID_JSON, streams an error on the done chunk.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// jsonRepairPrompt asks a model to fix its own output.
+const jsonRepairPrompt = "The text below was supposed to be a single valid JSON document, but it isn't (%v). " +
+	"Reply with only the corrected JSON document, keeping its content, and nothing else.\n\n%s"
+
+// ─── Incremental validation ───────────────────────────────────────────────────
+
+// jsonState is what a jsonScanner expects next.
+type jsonState int
+
+const (
+	jsValue       jsonState = iota // a value
+	jsArrayFirst                   // a value or ']' right after '['
+	jsObjectFirst                  // a key or '}' right after '{'
+	jsKey                          // a key after ','
+	jsColon                        // ':' after a key
+	jsAfterValue                   // ',' or the closing bracket, or the end at the top level
+	jsString                       // inside a string
+	jsEscape                       // after '\' in a string
+	jsUnicode                      // in the 4 hex digits of \u
+	jsLiteral                      // inside true, false or null
+	jsNumber                       // inside a number
+	jsDone                         // the top-level value is complete
+)
+
+// jsonExpected names what each state expects, for error messages.
+var jsonExpected = map[jsonState]string{
+	jsValue:       "a value",
+	jsArrayFirst:  "a value or ']'",
+	jsObjectFirst: "a key or '}'",
+	jsKey:         "a key",
+	jsColon:       "':'",
+	jsAfterValue:  "',' or a closing bracket",
+	jsDone:        "the end of the document",
+}
+
+// jsonScanner checks a JSON document fed to it piece by piece, remembering
+// the first syntax error. It holds only the nesting and the token being
+// read, so checking a stream costs the same per token however long the
+// output gets.
+type jsonScanner struct {
+	state   jsonState
+	nesting []byte // open '{' and '['
+	key     bool   // the string being read is an object key
+	pending string // rest of the literal being read
+	hex     int    // \u digits still to read
+	number  []byte // the number being read
+	offset  int    // bytes consumed
+	err     error  // first syntax error
+}
+
+// write feeds the next piece of output.
+func (s *jsonScanner) write(text string) {
+	for i := 0; i < len(text) && s.err == nil; i++ {
+		s.step(text[i])
+		s.offset++
+	}
+}
+
+// finish reports whether everything written is exactly one JSON document.
+func (s *jsonScanner) finish() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.state == jsNumber {
+		s.endNumber()
+	}
+	switch {
+	case s.err != nil:
+		return s.err
+	case s.state == jsDone:
+		return nil
+	case s.offset == 0 || (s.state == jsValue && len(s.nesting) == 0):
+		return errors.New("no JSON in the output")
+	}
+	if len(s.nesting) == 0 {
+		return errors.New("output ends inside a value")
+	}
+	return fmt.Errorf("output ends inside the document (%d unclosed brackets)", len(s.nesting))
+}
+
+func (s *jsonScanner) step(c byte) {
+	switch s.state {
+	case jsString:
+		switch {
+		case c == '"':
+			s.endString()
+		case c == '\\':
+			s.state = jsEscape
+		case c < 0x20:
+			s.fail(c, "string contents")
+		}
+		return
+	case jsEscape:
+		switch {
+		case c == 'u':
+			s.state, s.hex = jsUnicode, 4
+		case strings.IndexByte(`"\/bfnrt`, c) >= 0:
+			s.state = jsString
+		default:
+			s.fail(c, "an escape sequence")
+		}
+		return
+	case jsUnicode:
+		if !isHexDigit(c) {
+			s.fail(c, "a hex digit")
+			return
+		}
+		if s.hex--; s.hex == 0 {
+			s.state = jsString
+		}
+		return
+	case jsLiteral:
+		if c != s.pending[0] {
+			s.fail(c, fmt.Sprintf("%q", s.pending[0]))
+			return
+		}
+		if s.pending = s.pending[1:]; s.pending == "" {
+			s.endValue()
+		}
+		return
+	case jsNumber:
+		if strings.IndexByte("0123456789+-.eE", c) >= 0 {
+			s.number = append(s.number, c)
+			return
+		}
+		if s.endNumber(); s.err != nil {
+			return
+		}
+	}
+
+	if isSpace(c) {
+		return
+	}
+	switch s.state {
+	case jsValue, jsArrayFirst:
+		if c == ']' && s.state == jsArrayFirst {
+			s.closeBracket()
+			return
+		}
+		s.beginValue(c)
+	case jsObjectFirst, jsKey:
+		switch {
+		case c == '"':
+			s.state, s.key = jsString, true
+		case c == '}' && s.state == jsObjectFirst:
+			s.closeBracket()
+		default:
+			s.fail(c, jsonExpected[s.state])
+		}
+	case jsColon:
+		if c != ':' {
+			s.fail(c, jsonExpected[s.state])
+			return
+		}
+		s.state = jsValue
+	case jsAfterValue:
+		top := s.nesting[len(s.nesting)-1]
+		switch {
+		case c == ',' && top == '{':
+			s.state = jsKey
+		case c == ',':
+			s.state = jsValue
+		case c == '}' && top == '{', c == ']' && top == '[':
+			s.closeBracket()
+		default:
+			s.fail(c, jsonExpected[s.state])
+		}
+	default: // jsDone
+		s.fail(c, jsonExpected[s.state])
+	}
+}
+
+// beginValue starts reading the value that begins with c.
+func (s *jsonScanner) beginValue(c byte) {
+	switch {
+	case c == '{':
+		s.nesting = append(s.nesting, c)
+		s.state = jsObjectFirst
+	case c == '[':
+		s.nesting = append(s.nesting, c)
+		s.state = jsArrayFirst
+	case c == '"':
+		s.state, s.key = jsString, false
+	case c == 't':
+		s.state, s.pending = jsLiteral, "rue"
+	case c == 'f':
+		s.state, s.pending = jsLiteral, "alse"
+	case c == 'n':
+		s.state, s.pending = jsLiteral, "ull"
+	case c == '-' || (c >= '0' && c <= '9'):
+		s.state, s.number = jsNumber, append(s.number[:0], c)
+	default:
+		s.fail(c, jsonExpected[s.state])
+	}
+}
+
+// endString finishes a key or a string value.
+func (s *jsonScanner) endString() {
+	if s.key {
+		s.state = jsColon
+		return
+	}
+	s.endValue()
+}
+
+// endNumber checks the number just read.
+func (s *jsonScanner) endNumber() {
+	if !json.Valid(s.number) {
+		s.err = fmt.Errorf("invalid number %q before byte %d", s.number, s.offset)
+		return
+	}
+	s.endValue()
+}
+
+// closeBracket pops the innermost open bracket.
+func (s *jsonScanner) closeBracket() {
+	s.nesting = s.nesting[:len(s.nesting)-1]
+	s.endValue()
+}
+
+// endValue moves past a complete value.
+func (s *jsonScanner) endValue() {
+	if len(s.nesting) == 0 {
+		s.state = jsDone
+		return
+	}
+	s.state = jsAfterValue
+}
+
+func (s *jsonScanner) fail(c byte, expected string) {
+	s.err = fmt.Errorf("unexpected %q at byte %d, expected %s", c, s.offset, expected)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// checkFormat validates a task's format option.
+func checkFormat(f shared.OutputFormat) error {
+	if f != "" && f != shared.FormatJSON {
+		return fmt.Errorf("unknown format %q (want json or none)", f)
+	}
+	return nil
+}
+
+// checkJSON reports whether text is exactly one JSON document.
+func checkJSON(text string) error {
+	var s jsonScanner
+	s.write(text)
+	return s.finish()
+}
+
+// ─── Repair ───────────────────────────────────────────────────────────────────
+
+// repairJSON asks model to fix output, which failed the check with
+// syntaxErr, and returns the repaired document. The repair is a task of
+// its own, routed like any other so it shows up on the dashboard.
+func repairJSON(ctx context.Context, parent shared.TaskRequest, model, output string, syntaxErr error) (string, error) {
+	log.Printf("[JSON] Task %s output isn't valid JSON (%v) — asking %s to repair it", parent.TaskID, syntaxErr, modelOrDefault(model))
+	req := shared.TaskRequest{
+		TaskID:     uuid.New().String(),
+		Type:       parent.Type,
+		ModelHint:  model,
+		Format:     shared.FormatJSON,
+		Prompt:     fmt.Sprintf(jsonRepairPrompt, syntaxErr, output),
+		AllowCloud: parent.AllowCloud,
+		Source:     parent.Source,
+		NoDedup:    true,
+		Metadata:   map[string]string{"echo.json_repair_for": parent.TaskID},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, taskTimeout)
+	defer cancel()
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, req, nil)
+	if err != nil {
+		return "", fmt.Errorf("output isn't valid JSON (%v) and the repair failed: %w", syntaxErr, err)
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+
+	if err := checkJSON(result.Content); err != nil {
+		return "", fmt.Errorf("output isn't valid JSON (%v), nor is the repaired version (%v)", syntaxErr, err)
+	}
+	log.Printf("[JSON] Task %s repaired by %s on %s", parent.TaskID, result.ModelUsed, result.RoutedTo)
+	return result.Content, nil
+}
+
+// ensureJSON checks a successful format=json result, repairing it in
+// place or marking it failed.
+func ensureJSON(ctx context.Context, req shared.TaskRequest, result *shared.TaskResult) {
+	if req.Format != shared.FormatJSON || !result.Success {
+		return
+	}
+	syntaxErr := checkJSON(result.Content)
+	if syntaxErr == nil {
+		return
+	}
+	fixed, err := repairJSON(ctx, req, result.ModelUsed, result.Content, syntaxErr)
+	if err != nil {
+		result.Success = false
+		result.Error = err.Error()
+		result.ErrorCode = shared.ErrCodeInvalidJSON
+		return
+	}
+	result.Content = fixed
+	result.CompletionTokens = shared.EstimateTokens(fixed)
+	result.JSONRepaired = true
+}
+
+// finishJSONStream completes the done chunk of a streamed format=json task
+// whose output failed the check: with the repaired document, or the error.
+func finishJSONStream(ctx context.Context, req shared.TaskRequest, model, output string, syntaxErr error, done *shared.TaskChunk) {
+	fixed, err := repairJSON(ctx, req, model, output, syntaxErr)
+	if err != nil {
+		done.Error = err.Error()
+		return
+	}
+	done.Token = ""
+	done.Text = fixed
+	done.JSONRepaired = true
+}
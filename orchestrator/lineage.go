@@ -0,0 +1,198 @@
+// orchestrator/lineage.go
+// Task lineage across pipelines, retries and mirroring.
+//
+// One answer can sit several tasks away from the request that caused it: a
+// pipeline runs each step as its own task, a map step fans out into item
+// tasks, re-running a pipeline or retrying a dead letter repeats a task
+// under a new ID, and mirroring copies a task to a candidate. Each time one
+// of those tasks finishes, the orchestrator appends it to
+// <data-dir>/lineage.jsonl with its parent, how it relates to it and how it
+// turned out (node, model, error, failed attempts). GET /tasks/{id}/lineage
+// rebuilds the whole tree from the root, so a strange output can be traced
+// back through every failover and retry that led to it.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// lineageMaxDepth bounds the walk up to a tree's root, in case the log
+// holds a cycle (task IDs are client-supplied).
+const lineageMaxDepth = 64
+
+var lineageLog *LineageStore
+
+// LineageStore indexes lineage.jsonl by task and parent.
+type LineageStore struct {
+	mu       sync.RWMutex
+	nodes    map[string]*shared.LineageNode // keyed by task or pipeline ID; no Children
+	children map[string][]string            // parent ID → child IDs, oldest first
+	file     *os.File                       // append-only log, nil if unavailable
+}
+
+// NewLineageStore loads the lineage log under dataDir and opens it for
+// appending. A log that can't be read or opened only costs persistence.
+func NewLineageStore(dataDir string) *LineageStore {
+	l := &LineageStore{
+		nodes:    make(map[string]*shared.LineageNode),
+		children: make(map[string][]string),
+	}
+	path := filepath.Join(dataDir, "lineage.jsonl")
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var n shared.LineageNode
+			if json.Unmarshal(scanner.Bytes(), &n) == nil && n.ID != "" {
+				l.index(&n)
+			}
+		}
+		f.Close()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("[Lineage] Cannot open %s (%v) — lineage kept in memory only", path, err)
+	} else {
+		l.file = f
+	}
+	log.Printf("[Lineage] Loaded %d tasks from %s", len(l.nodes), path)
+	return l
+}
+
+// Record stores a finished task or pipeline. Recording an ID again updates
+// its outcome; a parent it already has is kept.
+func (l *LineageStore) Record(n shared.LineageNode) {
+	if n.ID == "" {
+		return
+	}
+	n.Children = nil
+	if n.Timestamp == 0 {
+		n.Timestamp = time.Now().UnixMilli()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.index(&n)
+	if l.file != nil {
+		line, _ := json.Marshal(n)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			log.Printf("[Lineage] Failed to persist %s: %v", n.ID, err)
+		}
+	}
+}
+
+// index adds n to the maps. Must be called with l.mu held (or before the
+// store is shared).
+func (l *LineageStore) index(n *shared.LineageNode) {
+	if old, ok := l.nodes[n.ID]; ok && old.Parent != "" {
+		n.Parent, n.Relation = old.Parent, old.Relation
+	} else if n.Parent != "" && n.Parent != n.ID {
+		l.children[n.Parent] = append(l.children[n.Parent], n.ID)
+	} else {
+		n.Parent, n.Relation = "", ""
+	}
+	l.nodes[n.ID] = n
+}
+
+// Has reports whether id has been recorded.
+func (l *LineageStore) Has(id string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.nodes[id]
+	return ok
+}
+
+// Get returns a copy of id's record, without children.
+func (l *LineageStore) Get(id string) (shared.LineageNode, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	n, ok := l.nodes[id]
+	if !ok {
+		return shared.LineageNode{}, false
+	}
+	return *n, true
+}
+
+// Tree returns the tree id belongs to, from its root, or false if id is
+// neither recorded nor anyone's parent.
+func (l *LineageStore) Tree(id string) (*shared.LineageNode, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.nodes[id]; !ok && len(l.children[id]) == 0 {
+		return nil, false
+	}
+	root := id
+	for depth := 0; depth < lineageMaxDepth; depth++ {
+		n, ok := l.nodes[root]
+		if !ok || n.Parent == "" {
+			break
+		}
+		root = n.Parent
+	}
+	return l.subtree(root, make(map[string]bool)), true
+}
+
+// subtree copies id and its descendants. Must be called with l.mu held.
+func (l *LineageStore) subtree(id string, seen map[string]bool) *shared.LineageNode {
+	seen[id] = true
+	node := &shared.LineageNode{ID: id}
+	if n, ok := l.nodes[id]; ok {
+		copied := *n
+		node = &copied
+	}
+	for _, child := range l.children[id] {
+		if !seen[child] {
+			node.Children = append(node.Children, l.subtree(child, seen))
+		}
+	}
+	return node
+}
+
+// ─── Recording helpers ────────────────────────────────────────────────────────
+
+// taskLineageNode describes a finished task: its result, or err when it
+// got none.
+func taskLineageNode(id, parent string, rel shared.LineageRelation, result *shared.TaskResult, err error) shared.LineageNode {
+	n := shared.LineageNode{ID: id, Parent: parent, Relation: rel}
+	if result != nil {
+		n.RoutedTo = result.RoutedTo
+		n.ModelUsed = result.ModelUsed
+		n.Success = result.Success
+		n.Error = result.Error
+		n.Attempts = result.Attempts
+	}
+	if err != nil {
+		n.Success = false
+		n.Error = err.Error()
+	}
+	return n
+}
+
+// recordPipelineLineage records a finished pipeline run and its steps' map
+// items; the steps themselves are recorded as they finish.
+func recordPipelineLineage(result *shared.PipelineResult) {
+	for _, step := range result.Steps {
+		for _, item := range step.Items {
+			lineageLog.Record(shared.LineageNode{
+				ID: item.TaskID, Parent: step.TaskID, Relation: shared.RelationItem,
+				RoutedTo: item.RoutedTo, ModelUsed: item.ModelUsed,
+				Success: item.Success, Error: item.Error,
+			})
+		}
+	}
+	lineageLog.Record(shared.LineageNode{
+		ID:       result.PipelineID,
+		Pipeline: true,
+		Success:  result.Success,
+		Error:    result.Error,
+	})
+}
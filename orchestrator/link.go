@@ -0,0 +1,87 @@
+// orchestrator/link.go
+// Agents' links to the orchestrator.
+//
+// Agents time small requests to GET /ping and report the round trip,
+// jitter and loss in their heartbeats (see node-agent/link.go). The
+// orchestrator grades each link:
+//
+//	good  below every fair threshold
+//	fair  round trip from linkFairRTTMs, jitter from linkFairJitterMs or
+//	      loss from linkFairLoss: noticeable on streams
+//	poor  round trip from linkPoorRTTMs, jitter from linkPoorJitterMs or
+//	      loss from linkPoorLoss: tokens arrive in bursts
+//
+// A poor link turns the node's health yellow, the dashboard colours the
+// node's line to the orchestrator by its grade, and GET /topology lists
+// each node's link. Routing weighs it with the link weight (see
+// weights.go): the worst of round trip, jitter and loss as a share of its
+// poor threshold, capped at 1. Nodes that don't report a link count as 0.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"echo-system/shared"
+)
+
+const (
+	linkFairRTTMs    = 50
+	linkFairJitterMs = 20
+	linkFairLoss     = 0.01
+	linkPoorRTTMs    = 250
+	linkPoorJitterMs = 100
+	linkPoorLoss     = 0.05
+)
+
+// linkGrade grades a reported link.
+func linkGrade(q *shared.LinkQuality) shared.LinkGrade {
+	switch {
+	case q.RTTMs >= linkPoorRTTMs || q.JitterMs >= linkPoorJitterMs || q.Loss >= linkPoorLoss:
+		return shared.LinkPoor
+	case q.RTTMs >= linkFairRTTMs || q.JitterMs >= linkFairJitterMs || q.Loss >= linkFairLoss:
+		return shared.LinkFair
+	}
+	return shared.LinkGood
+}
+
+// linkPenalty is a node's link signal for routing, from 0 (perfect or not
+// reported) to 1 (poor).
+func linkPenalty(node *shared.NodeInfo) float64 {
+	q := node.Link
+	if q == nil {
+		return 0
+	}
+	return min(1, max(q.RTTMs/linkPoorRTTMs, q.JitterMs/linkPoorJitterMs, q.Loss/linkPoorLoss))
+}
+
+// setLink records a node's reported link, graded, logging when it turns
+// poor or recovers. Must be called with the node's shard locked.
+func setLink(node *shared.NodeInfo, q *shared.LinkQuality) {
+	if q != nil {
+		q.Grade = linkGrade(q)
+	}
+	wasPoor := node.Link != nil && node.Link.Grade == shared.LinkPoor
+	isPoor := q != nil && q.Grade == shared.LinkPoor
+	switch {
+	case isPoor && !wasPoor:
+		log.Printf("[Registry] Node %s's link to the orchestrator is poor (%s)", node.NodeID, formatLink(q))
+	case wasPoor && !isPoor && q != nil:
+		log.Printf("[Registry] Node %s's link to the orchestrator recovered (%s)", node.NodeID, formatLink(q))
+	}
+	node.Link = q
+}
+
+// formatLink renders a link for logs and health reasons, e.g.
+// "rtt 340ms, jitter 80ms, 12% loss".
+func formatLink(q *shared.LinkQuality) string {
+	return fmt.Sprintf("rtt %.0fms, jitter %.0fms, %.0f%% loss", q.RTTMs, q.JitterMs, q.Loss*100)
+}
+
+// ─── Node agent: GET /ping ────────────────────────────────────────────────────
+
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
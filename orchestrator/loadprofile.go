@@ -0,0 +1,166 @@
+// orchestrator/loadprofile.go
+// Adaptive busy threshold derived from observed latency.
+//
+// Each node declares a busy threshold at registration (default 5 active
+// tasks). On top of that, the orchestrator watches how task latency changes
+// as concurrency on the node rises: once latency at some concurrency level
+// degrades past busyDegradeFactor × the single-task baseline, that level
+// becomes the node's effective busy threshold. A beefy GPU box that stays
+// fast at 12 concurrent tasks is no longer capped at the same 5 as a
+// Raspberry Pi, and a Pi that slows down at 2 is marked busy at 2.
+
+package main
+
+import "echo-system/shared"
+
+const (
+	// defaultBusyThreshold applies when an agent doesn't declare one.
+	defaultBusyThreshold = 5
+
+	// busyDegradeFactor is how much slower than baseline a concurrency level
+	// may be before the node counts as busy at that level.
+	busyDegradeFactor = 2.0
+
+	// minProfileSamples is how many completed tasks a concurrency level needs
+	// before its average latency is trusted.
+	minProfileSamples = 5
+
+	// profileAlpha is the EWMA smoothing factor for latency averages.
+	profileAlpha = 0.2
+
+	// maxProfileLevel caps the concurrency levels tracked per node.
+	maxProfileLevel = 64
+)
+
+// adaptiveBusy enables latency-derived thresholds; set from the -adaptive-busy flag.
+var adaptiveBusy = true
+
+// latencyLevel is the smoothed latency observed at one concurrency level.
+type latencyLevel struct {
+	avgMs   float64
+	samples int
+}
+
+// loadProfile tracks latency per concurrency level for one node.
+// Index i holds tasks that ran with i+1 tasks in flight.
+type loadProfile struct {
+	levels []latencyLevel
+}
+
+// record folds one completed task's latency into the profile.
+func (p *loadProfile) record(concurrency int, latencyMs int64) {
+	if concurrency < 1 || concurrency > maxProfileLevel {
+		return
+	}
+	for len(p.levels) < concurrency {
+		p.levels = append(p.levels, latencyLevel{})
+	}
+	lvl := &p.levels[concurrency-1]
+	if lvl.samples == 0 {
+		lvl.avgMs = float64(latencyMs)
+	} else {
+		lvl.avgMs = profileAlpha*float64(latencyMs) + (1-profileAlpha)*lvl.avgMs
+	}
+	lvl.samples++
+}
+
+// threshold returns the adaptive busy threshold, or declared when there
+// isn't enough data yet. If no degradation has been seen, the threshold
+// grows to one past the highest level the node has handled well.
+func (p *loadProfile) threshold(declared int) int {
+	if len(p.levels) == 0 || p.levels[0].samples < minProfileSamples {
+		return declared
+	}
+	baseline := p.levels[0].avgMs
+	highestGood := 1
+	for i := 1; i < len(p.levels); i++ {
+		lvl := p.levels[i]
+		if lvl.samples < minProfileSamples {
+			continue
+		}
+		if lvl.avgMs > busyDegradeFactor*baseline {
+			return i + 1
+		}
+		highestGood = i + 1
+	}
+	if highestGood+1 > declared {
+		return highestGood + 1
+	}
+	return declared
+}
+
+// effectiveBusyThreshold returns the threshold the registry should apply to
+// a node. Must be called with at least the shard's read lock held.
+func (s *registryShard) effectiveBusyThreshold(node *shared.NodeInfo) int {
+	declared := node.BusyThreshold
+	if declared <= 0 {
+		declared = defaultBusyThreshold
+	}
+	if !adaptiveBusy {
+		return declared
+	}
+	if p, ok := s.profiles[node.NodeID]; ok {
+		return p.threshold(declared)
+	}
+	return declared
+}
+
+// RecordLatency feeds a completed task into the node's load profile and
+// refreshes its effective busy threshold.
+func (r *Registry) RecordLatency(nodeID string, concurrency int, latencyMs int64) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	p, ok := s.profiles[nodeID]
+	if !ok {
+		p = &loadProfile{}
+		s.profiles[nodeID] = p
+	}
+	p.record(concurrency, latencyMs)
+	node.EffectiveBusyThreshold = s.effectiveBusyThreshold(node)
+	recordOutcome(node, true, latencyMs)
+}
+
+// RecordTimings folds an agent-reported timing split into the node's
+// smoothed averages. The NodeTimings is replaced rather than updated in
+// place, since snapshots of the node share the pointer.
+func (r *Registry) RecordTimings(nodeID string, t *shared.TaskTimings) {
+	if t == nil {
+		return
+	}
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	next := shared.NodeTimings{
+		AvgQueueMs:      float64(t.QueueMs),
+		AvgLoadMs:       float64(t.LoadMs),
+		AvgFirstTokenMs: float64(t.FirstTokenMs),
+		AvgGenerationMs: float64(t.GenerationMs),
+		TokensPerSec:    t.TokensPerSec,
+	}
+	if prev := node.Timings; prev != nil {
+		ewma := func(avg, v float64) float64 { return profileAlpha*v + (1-profileAlpha)*avg }
+		next.AvgQueueMs = ewma(prev.AvgQueueMs, next.AvgQueueMs)
+		next.AvgLoadMs = ewma(prev.AvgLoadMs, next.AvgLoadMs)
+		next.AvgFirstTokenMs = ewma(prev.AvgFirstTokenMs, next.AvgFirstTokenMs)
+		next.AvgGenerationMs = ewma(prev.AvgGenerationMs, next.AvgGenerationMs)
+		if t.TokensPerSec == 0 {
+			next.TokensPerSec = prev.TokensPerSec
+		} else if prev.TokensPerSec > 0 {
+			next.TokensPerSec = ewma(prev.TokensPerSec, t.TokensPerSec)
+		}
+		next.Samples = prev.Samples
+	}
+	next.Samples++
+	node.Timings = &next
+}
@@ -0,0 +1,157 @@
+// orchestrator/locks.go
+// Locks for exclusive models.
+//
+// A node can declare a model exclusive (agent flag -exclusive): it must run
+// one generation at a time, whatever -parallel or the busy threshold say —
+// typically a 70B model that only just fits and would thrash swap if Ollama
+// ran two. The orchestrator holds a lock per (node, model) for every task
+// dispatched to such a model. While it's held, routing treats the node as
+// busy for that model so other nodes are preferred; if the locked node is
+// still the best choice, the task waits for the lock instead of piling onto
+// Ollama.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+var modelLocks = NewLockManager()
+
+type lockKey struct {
+	node  string
+	model string
+}
+
+// heldLock is a taken lock; done is closed on release to wake waiters.
+type heldLock struct {
+	taskID  string
+	since   time.Time
+	waiters int
+	done    chan struct{}
+}
+
+// LockManager serializes tasks per (node, model).
+type LockManager struct {
+	mu   sync.Mutex
+	held map[lockKey]*heldLock
+}
+
+func NewLockManager() *LockManager {
+	return &LockManager{held: make(map[lockKey]*heldLock)}
+}
+
+// Acquire takes the lock for (nodeID, model), waiting while another task
+// holds it. It returns the release function, or ctx's error if the wait
+// is abandoned.
+func (m *LockManager) Acquire(ctx context.Context, nodeID, model, taskID string) (func(), error) {
+	key := lockKey{nodeID, model}
+	for {
+		m.mu.Lock()
+		l, busy := m.held[key]
+		if !busy {
+			l = &heldLock{taskID: taskID, since: time.Now(), done: make(chan struct{})}
+			m.held[key] = l
+			m.mu.Unlock()
+			return func() { m.release(key, l) }, nil
+		}
+		l.waiters++
+		m.mu.Unlock()
+
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			m.mu.Lock()
+			l.waiters--
+			m.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *LockManager) release(key lockKey, l *heldLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held[key] == l {
+		delete(m.held, key)
+		close(l.done)
+	}
+}
+
+// waiting counts the tasks blocked on a held lock.
+func (m *LockManager) waiting() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, l := range m.held {
+		n += l.waiters
+	}
+	return n
+}
+
+// Held reports whether (nodeID, model) is locked.
+func (m *LockManager) Held(nodeID, model string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.held[lockKey{nodeID, model}]
+	return ok
+}
+
+// ModelLock describes a held lock, as listed by GET /debug/locks.
+type ModelLock struct {
+	NodeID  string `json:"node_id"`
+	Model   string `json:"model"`
+	TaskID  string `json:"task_id"`
+	HeldMs  int64  `json:"held_ms"`
+	Waiters int    `json:"waiters"`
+}
+
+// List returns the held locks, longest-held first.
+func (m *LockManager) List() []ModelLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]ModelLock, 0, len(m.held))
+	for k, l := range m.held {
+		list = append(list, ModelLock{
+			NodeID:  k.node,
+			Model:   k.model,
+			TaskID:  l.taskID,
+			HeldMs:  time.Since(l.since).Milliseconds(),
+			Waiters: l.waiters,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].HeldMs > list[j].HeldMs })
+	return list
+}
+
+// lockExclusive takes the model lock if the node declared the model
+// exclusive; otherwise it's a no-op. The returned function releases it.
+func lockExclusive(ctx context.Context, node *shared.NodeInfo, model, taskID string) (func(), error) {
+	if !shared.IsExclusive(node.Capabilities, model) {
+		return func() {}, nil
+	}
+	return modelLocks.Acquire(ctx, node.NodeID, model, taskID)
+}
+
+// exclusiveLocked reports whether a task for model must wait on node.
+func exclusiveLocked(node *shared.NodeInfo, model string) bool {
+	return shared.IsExclusive(node.Capabilities, model) && modelLocks.Held(node.NodeID, model)
+}
+
+// handleListLocks shows the held exclusive-model locks.
+// GET /debug/locks
+func handleListLocks(w http.ResponseWriter, r *http.Request) {
+	locks := modelLocks.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"locks": locks,
+		"count": len(locks),
+	})
+}
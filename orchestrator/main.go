@@ -6,13 +6,18 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,46 +27,341 @@ import (
 
 var registry = NewRegistry()
 
-// taskTimeout is how long we wait for a node to respond before giving up
-// and trying a failover node. Ollama on CPU can be slow, so 3 minutes.
-const taskTimeout = 3 * time.Minute
+// history persists pipeline runs; opened in main once flags are parsed.
+var history *HistoryStore
+
+// agentTimeoutMargin is how much earlier than the orchestrator's own
+// deadline an agent is told to stop generating, leaving time for the
+// partial result to travel back.
+const agentTimeoutMargin = 2 * time.Second
+
+// errPartialResult is returned by routeWithFailover, together with the
+// result, when the task timed out on a node that had produced part of
+// its answer.
+var errPartialResult = errors.New("task timed out mid-generation")
+
+// defaultSnapshotInterval is the minimum gap between full-text snapshots
+// when a client streams with stream_mode=full.
+const defaultSnapshotInterval = 250 * time.Millisecond
+
+// compressMinBytes is the size from which /task results are compressed
+// for clients that accept zstd/gzip (-1 = never); set from -compress-min-bytes.
+var compressMinBytes = shared.DefaultCompressMinBytes
+
+// maxLineBytes is the longest line accepted from an agent's NDJSON stream;
+// set from -max-line-bytes.
+var maxLineBytes = shared.DefaultMaxLineBytes
+
+// maxPromptTokens rejects oversized prompts before dispatch (0 = no limit);
+// set from the -max-prompt-tokens flag.
+var maxPromptTokens int
 
 func main() {
-	mux := http.NewServeMux()
+	dataDir := flag.String("data-dir", "data", "Directory for persisted pipeline run history")
+	flag.BoolVar(&adaptiveBusy, "adaptive-busy", true, "Adapt each node's busy threshold from observed latency under concurrency")
+	flag.DurationVar(&taskTimeout, "task-timeout", taskTimeout, "How long a node may take to answer a task before it fails over, without latency history; the most an adaptive timeout can be")
+	flag.DurationVar(&minTaskTimeout, "task-timeout-min", minTaskTimeout, "The least an adaptive task timeout can be")
+	flag.BoolVar(&adaptiveTimeout, "adaptive-timeout", true, "Time out each node and model from the p99 of its recent latencies rather than after -task-timeout")
+	flag.BoolVar(&probeOnRegister, "probe", false, "Verify agent capabilities at registration (list models + 1-token generation)")
+	var mirrorCfg MirrorConfig
+	flag.Float64Var(&mirrorCfg.Percent, "mirror-percent", 0, "Percentage of tasks (0-100) duplicated to the mirror candidate for evaluation")
+	flag.StringVar(&mirrorCfg.NodeID, "mirror-node", "", "Candidate node ID that receives mirrored tasks")
+	flag.StringVar(&mirrorCfg.Model, "mirror-model", "", "Candidate model that runs mirrored tasks")
+	flag.IntVar(&compressMinBytes, "compress-min-bytes", shared.DefaultCompressMinBytes, "Compress /task results of at least this many bytes with zstd/gzip when the client accepts it (-1 = never)")
+	flag.IntVar(&maxLineBytes, "max-line-bytes", shared.DefaultMaxLineBytes, "Longest single line accepted from an agent's token stream")
+	flag.IntVar(&maxPromptTokens, "max-prompt-tokens", 0, "Reject prompts estimated above this many tokens (0 = no limit)")
+	flag.Int64Var(&maxFileBytes, "max-file-bytes", maxFileBytes, "Largest file accepted by POST /files, in bytes")
+	basePathFlag := flag.String("base-path", "", "Serve all endpoints under this sub-path when behind a reverse proxy (e.g. /echo)")
+	publicURLFlag := flag.String("public-url", "", "External base URL clients use to reach the orchestrator (e.g. https://example.com/echo)")
+	routingWebhook := flag.String("routing-webhook", "", "URL consulted during routing that may veto or reorder candidate nodes")
+	flag.DurationVar(&bundleExpiry, "bundle-expiry", 24*time.Hour, "Re-queue unfinished tasks of offline bundles not reported back within this time")
+	flag.StringVar(&adminToken, "admin-token", "", "Bearer token required by the /admin endpoints (empty = no auth)")
+	clientKeysFlag := flag.String("client-keys", "", "Named bearer tokens tasks are attributed to, comma-separated name=token pairs expanded from the environment (e.g. laptop=${LAPTOP_KEY}); not required to submit tasks")
+	flag.StringVar(&cloud.URL, "cloud-url", "", "OpenAI-compatible API base URL for the cloud fallback (e.g. https://api.openai.com/v1); key from $"+cloudKeyEnv)
+	flag.StringVar(&cloud.Model, "cloud-model", "gpt-4o-mini", "Model requested from the cloud fallback")
+	flag.IntVar(&cloud.DailyTokens, "cloud-daily-tokens", 200000, "Cloud fallback spending cap in tokens per UTC day (0 = no cap)")
+	flag.IntVar(&cloud.MaxTokens, "cloud-max-tokens", 1024, "Max completion tokens requested per cloud task")
+	flag.IntVar(&contextWindow, "context-window", contextWindow, "Default model context window in tokens, used to fit chat-style tasks (messages)")
+	flag.StringVar(&compressModel, "compress-model", "", "Small, fast model that compresses prompts of tasks asking for it (default: routed as any summarize task)")
+	flag.IntVar(&compressTargetTokens, "compress-target-tokens", compressTargetTokens, "Tokens prompts are compressed to when a task's compress option sets no target")
+	flag.StringVar(&verifyModel, "verify-model", "", "Model that checks the answers of tasks with verify (default: another model than the one that answered)")
+	fetchAllowFlag := flag.String("fetch-allow", "", "Hosts pipeline fetch steps may download from, comma-separated; each allows its subdomains too (empty = any host at a public address)")
+	flag.Int64Var(&fetchMaxBytes, "fetch-max-bytes", fetchMaxBytes, "Most bytes of a page a pipeline fetch step reads")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", fetchTimeout, "Time limit for a pipeline fetch step's download")
+	contextWindowsFlag := flag.String("context-windows", "", "Per-model context windows overriding -context-window (e.g. mistral:8192,llama3:70b:8192)")
+	taskOptionsFlag := flag.String("task-options", "", "Default generation options per task type, overridden by the task's own options (e.g. code=temperature:0.1,num_predict:1024;summarize=temperature:0.3)")
+	fallbackModelsFlag := flag.String("fallback-models", "", "Per-type model chains, largest first, tried in turn when a model is missing or out of memory on a node (e.g. text=llama3:70b,llama3:8b;code=codellama:34b,codellama:7b)")
+	eventBus := flag.String("event-bus", "", "Share dashboard events with other orchestrator replicas over Redis or NATS (e.g. redis://:password@redis:6379, nats://nats:4222; rediss:// and tls:// for TLS)")
+	eventChannel := flag.String("event-channel", defaultEventChannel, "Redis channel or NATS subject for -event-bus")
+	templatesDir := flag.String("pipeline-templates", "", "Directory of pipeline templates, one .yaml, .yml or .json file each, offered besides the built-in ones (a file may replace a built-in of the same name)")
+	inventoryPath := flag.String("inventory", "", "JSON file of the nodes the mesh should have; missing ones show as absent in /status")
+	inventoryGrace := flag.Duration("inventory-grace", 5*time.Minute, "Send a node_absent event for each inventory node not registered this long after startup (0 = never)")
+	flag.DurationVar(&recoveryWindow, "recover-window", recoveryWindow, "Ask nodes this long after startup for the in-flight steps of interrupted pipelines, then resume them (0 = leave them interrupted)")
+	flag.DurationVar(&alerts.Interval, "alert-interval", alerts.Interval, "How often alert rules are checked (0 = never)")
+	flag.DurationVar(&alerts.NodeOffline, "alert-node-offline", alerts.NodeOffline, "Alert when a registered node has sent no heartbeat for this long (0 = off)")
+	flag.Float64Var(&alerts.ErrorRate, "alert-error-rate", alerts.ErrorRate, "Alert when more than this fraction of the last 5 minutes' tasks failed (0 = off)")
+	flag.IntVar(&alerts.QueueDepth, "alert-queue-depth", 0, "Alert when more than this many tasks wait for a node (0 = off)")
+	flag.Float64Var(&alerts.DiskFree, "alert-disk-free", alerts.DiskFree, "Alert when less than this fraction of a node's models volume is free (0 = off)")
+	flag.StringVar(&alerts.Webhook, "alert-webhook", "", "URL that alerts are POSTed to as JSON when they fire and resolve")
+	flag.StringVar(&alerts.Ntfy, "alert-ntfy", "", "ntfy topic URL that alerts are published to (e.g. https://ntfy.sh/my-mesh)")
+	flag.StringVar(&alerts.TelegramChat, "alert-telegram-chat", "", "Telegram chat ID that alerts are sent to; bot token from $"+telegramTokenEnv)
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", maxClockSkew, "Warn about and grade yellow nodes whose clock is off from the orchestrator's by more than this (0 = never)")
+	replicaID := flag.String("replica-id", "", "Name of this replica in shared events (default: hostname plus a random suffix)")
+	passHeadersFlag := flag.String("pass-headers", "", "Comma-separated client request headers that tasks carry to the agents' backends, e.g. Authorization,X-Tenant (agents must allow them too)")
+	switchoverFlag := flag.String("switchover-url", "", "Base URL of the orchestrator dashboards move to when this one shuts down, e.g. a standby (default: reconnect here)")
+	flag.DurationVar(&keepModelHot, "keep-model-hot", keepModelHot, "Ask Ollama to keep a model loaded this long after back-to-back tasks for it on a node (0 = leave it to Ollama)")
+	flag.DurationVar(&taskHistoryRetention, "task-history-retention", taskHistoryRetention, "How long finished tasks are kept in the task history, <data-dir>/tasks.db (0 = forever)")
+	flag.DurationVar(&capabilityTTL, "capability-ttl", capabilityTTL, "Mark a node's models stale and re-validate them with its agent once unconfirmed this long (0 = never)")
+	flag.IntVar(&nodeQueue.maxDepth, "queue-depth", nodeQueue.maxDepth, "Tasks that may wait at the orchestrator for a node when none can take them (0 = fail at once with 503)")
+	flag.DurationVar(&nodeQueue.timeout, "queue-timeout", nodeQueue.timeout, "How long a task may wait for a node before it fails with 503")
+	flag.DurationVar(&dedupWindow, "dedup-window", dedupWindow, "Share one generation between identical tasks submitted concurrently or within this long of each other (0 = never)")
+	listen := flag.String("listen", ":8080", "Address to serve on, or unix:/path to serve only same-host clients and agents through a socket")
+	simulatePath := flag.String("simulate", "", "JSON file of a virtual mesh: replay a workload against it under each routing strategy, print the comparison and exit")
+	simulateWorkload := flag.String("simulate-workload", "", "Workload for -simulate, as recorded by -record-workload (default: made up from the -simulate file)")
+	recordWorkload := flag.String("record-workload", "", "Append every finished task's arrival time, type and token counts to this file, for -simulate-workload")
+	corsOriginsFlag := flag.String("cors-origins", "*", "Origins whose pages may call the API and open /ws, comma-separated, e.g. https://app.example.com,https://*.example.net (* = any, empty = same origin only)")
+	corsHeadersFlag := flag.String("cors-headers", "Authorization,Content-Type,Accept,Last-Event-ID,If-None-Match", "Request headers pages on -cors-origins may send, comma-separated, besides the -pass-headers (* = any)")
+	flag.DurationVar(&corsMaxAge, "cors-max-age", 10*time.Minute, "How long browsers may cache the answer to a CORS preflight")
+	flag.DurationVar(&sseKeepAlive, "sse-keepalive", sseKeepAlive, "Send a comment line on task streams quiet for this long, so proxies don't close them (0 = never)")
+	flag.DurationVar(&sseRetry, "sse-retry", sseRetry, "Reconnect delay suggested to stream clients in the retry: field (0 = none)")
+	flag.Float64Var(&rateLimit, "rate-limit", 0, "Requests per second each client (client key, else IP) may make to the endpoints that start work; over it they get 429 (0 = no limit)")
+	flag.IntVar(&rateBurst, "rate-burst", 0, "Requests a client may make at once before -rate-limit applies (default: the rate, rounded up)")
+	flag.BoolVar(&logRequests, "log-requests", false, "Log every HTTP request with its status, size and duration")
+	showVersion := flag.Bool("version", false, "Print the build's version and mesh API version and exit")
+	diagnose := flag.Bool("diagnose", false, "Check the port, data dir, mDNS multicast, -inventory agents and clocks, print findings and exit (non-zero on failures)")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("echo-orchestrator", shared.BuildInfo())
+		return
+	}
+	if *simulatePath != "" {
+		os.Exit(runSimulation(*simulatePath, *simulateWorkload))
+	}
+	if *diagnose {
+		os.Exit(runDiagnostics(diagOptions{listen: *listen, dataDir: *dataDir, inventory: *inventoryPath}))
+	}
+	configureBaseURL(*basePathFlag, *publicURLFlag)
+
+	var err error
+	if corsOrigins, err = parseCORSOrigins(*corsOriginsFlag); err != nil {
+		log.Fatalf("[Orchestrator] -cors-origins: %v", err)
+	}
+	if corsHeaders, err = parseCORSHeaders(*corsHeadersFlag); err != nil {
+		log.Fatalf("[Orchestrator] -cors-headers: %v", err)
+	}
+	if passHeaderNames, err = shared.ParseHeaderNames(*passHeadersFlag); err != nil {
+		log.Fatalf("[Orchestrator] -pass-headers: %v", err)
+	}
+	if clientKeys, err = parseClientKeys(*clientKeysFlag); err != nil {
+		log.Fatalf("[Orchestrator] -client-keys: %v", err)
+	}
+	if *switchoverFlag != "" {
+		if switchoverURL, err = checkSwitchoverURL(*switchoverFlag); err != nil {
+			log.Fatalf("[Orchestrator] -switchover-url: %v", err)
+		}
+	}
+	history, err = NewHistoryStore(*dataDir)
+	if err != nil {
+		log.Fatalf("[Orchestrator] Failed to open history store: %v", err)
+	}
+	conversations, err = NewConversationStore(*dataDir)
+	if err != nil {
+		log.Fatalf("[Orchestrator] Failed to open conversation store: %v", err)
+	}
+	loadIdentities(*dataDir)
+	if *recordWorkload != "" {
+		if workloadLog, err = openWorkloadRecorder(*recordWorkload); err != nil {
+			log.Fatalf("[Orchestrator] -record-workload: %v", err)
+		}
+	}
+	mirror = NewMirror(mirrorCfg, *dataDir)
+	lineageLog = NewLineageStore(*dataDir)
+	taskHistory = NewTaskHistoryStore(*dataDir)
+	feedback = NewFeedbackStore(*dataDir)
+	cloud.init(*dataDir)
+	fetchAllow = parseFetchAllow(*fetchAllowFlag)
+	windows, err := parseContextWindows(*contextWindowsFlag)
+	if err != nil {
+		log.Fatalf("[Orchestrator] %v", err)
+	}
+	contextWindows = windows
+	chains, err := parseFallbackChains(*fallbackModelsFlag)
+	if err != nil {
+		log.Fatalf("[Orchestrator] %v", err)
+	}
+	fallbackChains = chains
+	if typeOptions, err = parseTypeOptions(*taskOptionsFlag); err != nil {
+		log.Fatalf("[Orchestrator] %v", err)
+	}
+	if *eventBus != "" {
+		if *replicaID == "" {
+			*replicaID = defaultReplicaID()
+		}
+		if err := startEventBus(*eventBus, *eventChannel, *replicaID); err != nil {
+			log.Fatalf("[Orchestrator] %v", err)
+		}
+	}
+	if *templatesDir != "" {
+		if err := loadPipelineTemplates(*templatesDir); err != nil {
+			log.Fatalf("[Orchestrator] -pipeline-templates: %v", err)
+		}
+	}
+	if *inventoryPath != "" {
+		if inventory, err = loadInventory(*inventoryPath); err != nil {
+			log.Fatalf("[Orchestrator] %v", err)
+		}
+		inventory.watch(*inventoryGrace)
+	}
+	loadRoutingConfig(*dataDir)
+	fairShare.start()
+	nodeQueue.start()
+	startCapabilitySweeper()
+	loadAliases(*dataDir)
+	statsSeries = NewStatsSeries(*dataDir)
+	startQueueSampler()
+	availability = NewAvailabilityTracker(*dataDir)
+	bundles = NewBundleStore(*dataDir)
+	fileStore = NewFileStore(*dataDir)
+	streamLog = NewStreamLog(*dataDir)
+	shares = NewShareStore(*dataDir)
+	alerts.start()
+	if *routingWebhook != "" {
+		RegisterRoutingHook(newWebhookHook(*routingWebhook))
+	}
+	startRecovery()
+
+	mux := newAPIMux()
 
 	// ── Client-facing endpoints ──────────────────────────────────────────────
 	mux.HandleFunc("POST /task", handleTask)              // non-streaming
 	mux.HandleFunc("POST /task/stream", handleTaskStream) // streaming SSE
-	mux.HandleFunc("POST /pipeline", handlePipeline)      // Phase 4: multi-step pipeline
+	mux.HandleFunc("GET /task/stream/{id}", handleResumeStream)
+	mux.HandleFunc("POST /task/async", handleTaskAsync)
+	mux.HandleFunc("GET /task/{id}", handleGetAsyncTask)
+	mux.HandleFunc("POST /pipeline", handlePipeline) // Phase 4: multi-step pipeline
+	mux.HandleFunc("POST /summarize", handleSummarize)
+	mux.HandleFunc("POST /chunk", handleChunk)
+	mux.HandleFunc("GET /pipelines/templates/builtin", handleListTemplates)
+	mux.HandleFunc("GET /pipelines/runs", handleListPipelineRuns)
+	mux.HandleFunc("GET /pipelines/runs/{id}", handleGetPipelineRun)
+	mux.HandleFunc("GET /tasks/{id}/lineage", handleTaskLineage)
+	mux.HandleFunc("GET /history", handleTaskHistory)
+	mux.HandleFunc("POST /tasks/{id}/share", handleShareTask)
+	mux.HandleFunc("POST /tasks/{id}/feedback", handlePostFeedback)
+	mux.HandleFunc("GET /tasks/{id}/feedback", handleGetFeedback)
+	mux.HandleFunc("POST /pipelines/runs/{id}/share", handleSharePipeline)
+	mux.HandleFunc("GET /share/{token}", handleGetShare)
+	mux.HandleFunc("POST /conversations", handleCreateConversation)
+	mux.HandleFunc("GET /conversations/{id}", handleGetConversation)
+	mux.HandleFunc("DELETE /conversations/{id}", handleDeleteConversation)
+	mux.HandleFunc("POST /conversations/{id}/messages", handleConversationTurn)
+	mux.HandleFunc("PUT /conversations/{id}/pin", handleConversationPin(shared.ConversationPin))
+	mux.HandleFunc("DELETE /conversations/{id}/pin", handleConversationPin(shared.ConversationUnpin))
+	mux.HandleFunc("POST /conversations/{id}/switch", handleConversationPin(shared.ConversationSwitch))
+	mux.HandleFunc("POST /files", handleUploadFile)
+	mux.HandleFunc("GET /files", handleListFiles)
+	mux.HandleFunc("GET /files/{id}", handleGetFile)
+	mux.HandleFunc("POST /bundles/tasks", handleDeferTask)
+	mux.HandleFunc("GET /bundles/tasks/{id}", handleGetDeferredTask)
+	mux.HandleFunc("POST /bundles/claim", handleClaimBundle)
+	mux.HandleFunc("POST /bundles/{id}/results", handleBundleResults)
+	mux.HandleFunc("GET /work", handleWork)
+	mux.HandleFunc("POST /results/ingest", handleIngestResult)
+	mux.HandleFunc("GET /nodes/{id}/models", handleNodeModels)
+
+	// ── Admin (see admin.go) ─────────────────────────────────────────────────
+	mux.HandleFunc("POST /admin/nodes/{id}/drain", handleDrainNode(true))
+	mux.HandleFunc("DELETE /admin/nodes/{id}/drain", handleDrainNode(false))
+	mux.HandleFunc("DELETE /admin/nodes/{id}", handleEvictNode)
+	mux.HandleFunc("POST /admin/nodes/{id}/selftest", handleNodeSelfTest)
+	mux.HandleFunc("POST /admin/nodes/{id}/pull", handleModelPull)
+	mux.HandleFunc("POST /admin/flush", handleFlush)
+	mux.HandleFunc("GET /admin/routing", handleGetRouting)
+	mux.HandleFunc("PUT /admin/routing", handleSetRouting)
+	mux.HandleFunc("GET /admin/routing/weights", handleGetWeights)
+	mux.HandleFunc("PUT /admin/routing/weights", handleSetWeights)
+	mux.HandleFunc("GET /admin/pipelines/schedule", handleGetSchedule)
+	mux.HandleFunc("PUT /admin/pipelines/schedule", handleSetSchedule)
+	mux.HandleFunc("GET /admin/fairshare", handleGetFairShare)
+	mux.HandleFunc("PUT /admin/fairshare", handleSetFairShare)
+	mux.HandleFunc("GET /admin/dlq", handleListDLQ)
+	mux.HandleFunc("POST /admin/dlq/{id}/retry", handleRetryDLQ)
+	mux.HandleFunc("DELETE /admin/dlq", handleClearDLQ)
+	mux.HandleFunc("GET /admin/queue", handleListQueue)
+	mux.HandleFunc("POST /admin/queue/{id}/promote", handlePromoteQueued)
+	mux.HandleFunc("POST /admin/queue/{id}/cancel", handleCancelQueued)
+	mux.HandleFunc("DELETE /admin/files/{id}", handleDeleteFile)
+	mux.HandleFunc("DELETE /admin/shares", handleRevokeShares)
+	mux.HandleFunc("POST /admin/switchover", handleSwitchover)
+	mux.HandleFunc("GET /admin/aliases", handleListAliases)
+	mux.HandleFunc("PUT /admin/aliases/{name}", handleSetAlias)
+	mux.HandleFunc("DELETE /admin/aliases/{name}", handleDeleteAlias)
+	mux.HandleFunc("POST /admin/aliases/{name}/rollout", handleStartRollout)
+	mux.HandleFunc("DELETE /admin/aliases/{name}/rollout", handleEndRollout(false))
+	mux.HandleFunc("POST /admin/aliases/{name}/promote", handleEndRollout(true))
 
 	// ── Node-agent endpoints ─────────────────────────────────────────────────
 	mux.HandleFunc("POST /register", handleRegister)
 	mux.HandleFunc("POST /heartbeat", handleHeartbeat)
+	mux.HandleFunc("GET /ping", handlePing)
 
 	// ── Debug / status ───────────────────────────────────────────────────────
 	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /version", handleVersion)
+	mux.HandleFunc("GET /topology", handleTopology)
 	mux.HandleFunc("GET /debug/routing", handleDebugRouting)
+	mux.HandleFunc("GET /debug/locks", handleListLocks)
+	mux.HandleFunc("GET /debug/dashboards", handleListDashboards)
+	mux.HandleFunc("GET /debug/requests", handleDebugRequests)
+	mux.HandleFunc("GET /mirror/results", handleMirrorResults)
+	mux.HandleFunc("GET /stats/series", handleStatsSeries)
+	mux.HandleFunc("GET /stats/availability", handleStatsAvailability)
+	mux.HandleFunc("GET /stats/feedback", handleFeedbackStats)
+	mux.HandleFunc("GET /stats/fairshare", handleFairShareStats)
+	mux.HandleFunc("GET /stats/timeouts", handleTimeoutStats)
+	mux.HandleFunc("GET /cloud/usage", handleCloudUsage)
+	mux.HandleFunc("GET /alerts", handleAlerts)
 	// ── Phase 5: Dashboard ─────────────────────────────────────────────
 	mux.HandleFunc("GET /ws", handleWS)
 	mux.Handle("GET /dashboard/", http.StripPrefix("/dashboard/", http.FileServer(http.Dir("dashboard"))))
 	mux.HandleFunc("GET /dashboard", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/dashboard/", http.StatusMovedPermanently)
+		http.Redirect(w, r, basePath+"/dashboard/", http.StatusMovedPermanently)
 	})
 
+	// ── API docs (see openapi.go) ────────────────────────────────────────────
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /docs", handleDocs)
+	if err := checkAPIDocs(mux.patterns); err != nil {
+		log.Fatalf("[Orchestrator] %v", err)
+	}
+
 	// Start background stats broadcaster
 	StartStatsBroadcast()
 
-	// ── Phase 6: mDNS zero-config discovery ──────────────────────────────────
-	mdnsCleanup, err := startMDNS()
+	ln, err := shared.Listen(*listen)
 	if err != nil {
-		log.Printf("[Orchestrator] mDNS advertisement failed (non-fatal): %v", err)
-	} else {
-		defer mdnsCleanup()
+		log.Fatalf("[Orchestrator] Listen error: %v", err)
 	}
 
-	addr := ":8080"
-	log.Printf("[Orchestrator] Listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	// ── Phase 6: mDNS zero-config discovery ──────────────────────────────────
+	// A socket can't be reached from the network, so there's nothing to advertise
+	if tcp, ok := ln.Addr().(*net.TCPAddr); ok {
+		mdnsCleanup, err := startMDNS(tcp.Port)
+		if err != nil {
+			log.Printf("[Orchestrator] mDNS advertisement failed (non-fatal): %v", err)
+		} else {
+			defer mdnsCleanup()
+		}
+	}
+
+	log.Printf("[Orchestrator] Listening on %s (base path %q, public URL %q)", *listen, basePath, publicURL)
+	srv := &http.Server{Handler: withBasePath(mux.handler())}
+	stopped := shutdownOnSignal(srv)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-stopped
+	log.Printf("[Orchestrator] Stopped")
 }
 
 // ─── Client: POST /task ───────────────────────────────────────────────────────
@@ -70,73 +370,327 @@ func main() {
 func handleTask(w http.ResponseWriter, r *http.Request) {
 	var req shared.TaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	req.Source = requestSource(r)
 	if req.TaskID == "" {
 		req.TaskID = uuid.New().String()
 	}
-	if req.Prompt == "" {
-		http.Error(w, "prompt is required", http.StatusBadRequest)
+	if status, err := checkTask(req); err != nil {
+		writeProblem(w, r, status, err.Error())
 		return
 	}
 
-	startedAt := time.Now()
-
 	// Wrap with a timeout so a hung node doesn't block forever
 	ctx, cancel := context.WithTimeout(r.Context(), taskTimeout)
 	defer cancel()
 
-	result, err := routeWithFailover(ctx, req, nil)
+	result, status, err := executeTask(ctx, req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("all nodes failed: %v", err), http.StatusServiceUnavailable)
+		writeTaskProblem(w, r, status, req.TaskID, err.Error())
 		return
 	}
+	shared.WriteJSON(w, r, http.StatusOK, result, compressMinBytes)
+}
+
+// checkTask validates a task sent to POST /task or /task/async, returning
+// the status to reject it with.
+func checkTask(req shared.TaskRequest) (int, error) {
+	if req.Prompt == "" && len(req.Messages) == 0 && len(req.Input) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("prompt, messages or input is required")
+	}
+	if err := checkFormat(req.Format); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkVerify(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkQuality(req.MinQuality); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkPromptSize(req.Prompt); err != nil {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if status, err := checkEmbedInput(req); err != nil {
+		return status, err
+	}
+	if err := checkMetadata(req.Metadata); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkFiles(req.Files); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkCompress(req.Compress); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// executeTask runs a checked task within ctx, returning the status to
+// fail it with.
+func executeTask(ctx context.Context, req shared.TaskRequest) (*shared.TaskResult, int, error) {
+	startedAt := time.Now()
+	if len(req.Messages) > 0 {
+		if err := shapeContext(ctx, &req); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	// Identical tasks in flight share one generation (see dedup.go)
+	result, led, err := runTask(ctx, req)
+	if led {
+		taskHistory.record(req, result, err, startedAt)
+	}
+	if errors.Is(err, errQueueCancelled) {
+		return nil, http.StatusGone, err
+	}
+	if err != nil && led {
+		deadLetters.Add(req, err)
+	}
+	if err != nil && !errors.Is(err, errPartialResult) {
+		status := http.StatusServiceUnavailable
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		return nil, status, fmt.Errorf("all nodes failed: %v", err)
+	}
 
 	result.LatencyMs = time.Since(startedAt).Milliseconds()
 
-	// Emit dashboard event
-	EmitTaskDone(result)
+	// Emit dashboard event — once per generation
+	if led {
+		EmitTaskDone(result)
+		mirror.MaybeMirror(req, result)
+	}
+	return result, http.StatusOK, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+// checkPromptSize rejects prompts whose estimated token count exceeds
+// -max-prompt-tokens, before they're dispatched to a node that would
+// silently truncate them.
+func checkPromptSize(prompt string) error {
+	if maxPromptTokens <= 0 {
+		return nil
+	}
+	if n := shared.EstimateTokens(prompt); n > maxPromptTokens {
+		return fmt.Errorf("prompt is ~%d tokens, over the %d-token limit", n, maxPromptTokens)
+	}
+	return nil
+}
+
+// maxEmbedInputs caps the texts one embed task may carry; bigger
+// ingestions are split into several tasks, which spreads them over nodes.
+const maxEmbedInputs = 2048
+
+// checkEmbedInput validates an embed task's input, returning the status to
+// reject it with.
+func checkEmbedInput(req shared.TaskRequest) (int, error) {
+	if len(req.Input) == 0 {
+		return 0, nil
+	}
+	switch {
+	case req.Type != shared.TaskTypeEmbed:
+		return http.StatusBadRequest, fmt.Errorf("input is only taken by embed tasks")
+	case req.Prompt != "" || len(req.Messages) > 0 || len(req.Files) > 0:
+		return http.StatusBadRequest, fmt.Errorf("send input, or a prompt, messages or files to embed, not both")
+	case req.AllowCloud:
+		return http.StatusBadRequest, fmt.Errorf("the cloud fallback can't embed; drop allow_cloud")
+	case len(req.Input) > maxEmbedInputs:
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("%d inputs, over the limit of %d per task (split them over several)", len(req.Input), maxEmbedInputs)
+	}
+	for i, text := range req.Input {
+		if text == "" {
+			return http.StatusBadRequest, fmt.Errorf("input[%d] is empty", i)
+		}
+		if err := checkPromptSize(text); err != nil {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("input[%d]: %v", i, err)
+		}
+	}
+	return 0, nil
+}
+
+// checkQuality validates a task's min_quality.
+func checkQuality(q shared.QualityTier) error {
+	if q != "" && !shared.ValidQuality(q) {
+		return fmt.Errorf("unknown min_quality %q (want small, medium or large)", q)
+	}
+	return nil
+}
+
+// Limits on client metadata, which is stored with every task and event.
+const (
+	maxMetadataKeys  = 32
+	maxMetadataBytes = 4 << 10 // keys + values combined
+)
+
+// checkMetadata rejects client metadata too large to carry around.
+func checkMetadata(md map[string]string) error {
+	if len(md) > maxMetadataKeys {
+		return fmt.Errorf("metadata has %d keys, over the limit of %d", len(md), maxMetadataKeys)
+	}
+	size := 0
+	for k, v := range md {
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataBytes {
+		return fmt.Errorf("metadata is %d bytes, over the limit of %d", size, maxMetadataBytes)
+	}
+	return nil
 }
 
 // routeWithFailover tries to execute a task, and if the chosen node fails,
 // automatically retries on the next best available node.
 func routeWithFailover(ctx context.Context, req shared.TaskRequest, tried map[string]bool) (*shared.TaskResult, error) {
+	// Aliases resolve once, before the first attempt
+	if pick, ok := aliases.resolve(req.ModelHint); ok {
+		req.ModelHint = pick.model
+		startedAt := time.Now()
+		result, err := routeWithFailover(ctx, req, tried)
+		aliases.record(pick, time.Since(startedAt).Milliseconds(), !pickServed(pick, result, err))
+		return result, err
+	}
 	if tried == nil {
 		tried = make(map[string]bool)
 	}
 
-	node, err := registry.FindBestNodeExcluding(req.Type, req.ModelHint, tried)
+	// Under contention, tasks of heavy tenants wait for the others' (see
+	// fairshare.go)
+	ctx, err := fairShare.admit(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// With no node for it yet, the first attempt waits for one (see
+	// nodequeue.go)
+	node, err := selectNodeOrWait(ctx, req, tried)
 	if err != nil {
-		return nil, fmt.Errorf("no more nodes to try (tried %d): %w", len(tried), err)
+		err = fmt.Errorf("no more nodes to try (tried %d): %w", len(tried), err)
+		if req.AllowCloud && cloud.enabled() {
+			return routeToCloud(ctx, req, err)
+		}
+		return nil, err
 	}
 
-	log.Printf("[Orchestrator] Task %s type=%q → node %s (attempt %d)",
-		req.TaskID, req.Type, node.NodeID, len(tried)+1)
-	registry.IncrementLoad(node.NodeID)
-	defer registry.DecrementLoad(node.NodeID)
+	log.Printf("[Orchestrator] Task %s type=%q → node %s [%s] (attempt %d)",
+		req.TaskID, req.Type, node.NodeID, healthLabel(node), len(tried)+1)
+	model := expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality)
+	release, err := lockExclusive(ctx, node, model, req.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for exclusive model %s on %s: %w", model, node.NodeID, err)
+	}
+	defer release()
+	concurrency := registry.IncrementLoad(node.NodeID, model)
+	defer registry.DecrementLoad(node.NodeID, model)
+	recordDispatch(node, model)
+	keepAlive, endRun := runs.begin(node.NodeID, model)
+	defer endRun()
+	req.KeepAlive = keepAlive
 
-	result, err := forwardTask(ctx, node, req)
+	dispatchedAt := time.Now()
+	defer fairShare.charge(req.Source, dispatchedAt)
+	attemptCtx, cancel := attemptContext(ctx, node, model)
+	result, err := forwardTask(attemptCtx, node, req)
+	if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		// Past its adaptive timeout (see timeouts.go), with time left to fail over
+		timeouts.timedOut(node.NodeID, model)
+		err = fmt.Errorf("no answer within %v, the timeout for %s on this node", time.Since(dispatchedAt).Round(time.Second), model)
+	}
+	cancel()
+	var attempt shared.TaskAttempt
+	if err != nil {
+		attempt = failedAttempt(node.NodeID, model, err, time.Since(dispatchedAt))
+		EmitTaskFailover(shared.FailoverEvent{
+			TaskID:     req.TaskID,
+			Attempt:    len(tried) + 1,
+			FailedNode: node.NodeID,
+			Reason:     attempt.Error,
+			ErrorCode:  attempt.ErrorCode,
+		})
+	}
+	if code, failedModel, ok := modelFailure(err); ok {
+		if failedModel == "" {
+			failedModel = model
+		}
+		if next := nextFallbackModel(req.Type, failedModel); next != "" {
+			log.Printf("[Orchestrator] Node %s can't run %s (%s) — falling back to %s",
+				node.NodeID, failedModel, code, next)
+			smaller := req
+			smaller.ModelHint = next
+			result, err := routeWithFailover(ctx, smaller, nil)
+			if result == nil {
+				return nil, err
+			}
+			result.Attempts = append([]shared.TaskAttempt{attempt}, result.Attempts...)
+			if result.ModelFallback == nil {
+				result.ModelFallback = &shared.ModelFallback{To: result.ModelUsed}
+			}
+			result.ModelFallback.From = failedModel
+			result.ModelFallback.NodeID = node.NodeID
+			result.ModelFallback.Reason = code
+			return result, err
+		}
+	}
 	if err != nil {
 		tried[node.NodeID] = true
-		log.Printf("[Orchestrator] Node %s failed (%v) — trying failover", node.NodeID, err)
-		registry.MarkSuspect(node.NodeID)
-		return routeWithFailover(ctx, req, tried)
+		if memoryRefused(err) {
+			log.Printf("[Orchestrator] Node %s has no memory for %s — trying another node", node.NodeID, model)
+		} else {
+			log.Printf("[Orchestrator] Node %s failed (%v) — trying failover", node.NodeID, err)
+			registry.MarkSuspect(node.NodeID)
+		}
+		result, err := routeWithFailover(ctx, req, tried)
+		if result != nil {
+			result.Attempts = append([]shared.TaskAttempt{attempt}, result.Attempts...)
+		}
+		return result, err
+	}
+
+	if !result.Partial {
+		registry.RecordLatency(node.NodeID, concurrency, time.Since(dispatchedAt).Milliseconds())
+		registry.RecordTimings(node.NodeID, result.Timings)
+		timeouts.record(node.NodeID, model, time.Since(dispatchedAt))
 	}
+	recordTransfer(node.NodeID, result.Transfer)
 
 	result.RoutedTo = node.NodeID
 	result.TaskType = req.Type
-	result.Success = true
+	result.Lineage = req.Lineage
+	result.Source = req.Source
+	result.Metadata = req.Metadata
+	result.Success = !result.Partial
+	result.PromptTokens = shared.EstimateTokens(req.Prompt)
+	for _, text := range req.Input {
+		result.PromptTokens += shared.EstimateTokens(text)
+	}
+	result.CompletionTokens = shared.EstimateTokens(result.Content)
 
 	// Emit routing event for dashboard
-	EmitTaskRouted(req.TaskID, req.Type, node.NodeID, req.Prompt)
+	EmitTaskRouted(req, node.NodeID)
 
+	// Out of time: there's none left to fail over, so keep what the node
+	// generated rather than throwing it away
+	if result.Partial {
+		log.Printf("[Orchestrator] Task %s timed out on %s — returning %d chars of partial output",
+			req.TaskID, node.NodeID, len(result.Content))
+		return result, errPartialResult
+	}
 	return result, nil
 }
 
+// failedAttempt describes a failed try of a task on a node.
+func failedAttempt(nodeID, model string, err error, took time.Duration) shared.TaskAttempt {
+	attempt := shared.TaskAttempt{NodeID: nodeID, Model: model, Error: err.Error(), LatencyMs: took.Milliseconds()}
+	var ae *agentTaskError
+	if errors.As(err, &ae) {
+		attempt.Error = ae.Message
+		attempt.ErrorCode = ae.Code
+		if ae.Model != "" {
+			attempt.Model = ae.Model
+		}
+	}
+	return attempt
+}
+
 // ─── Client: POST /task/stream ────────────────────────────────────────────────
 // Streams tokens back as Server-Sent Events (SSE).
 // Each event is a JSON-encoded TaskChunk.
@@ -144,49 +698,282 @@ func routeWithFailover(ctx context.Context, req shared.TaskRequest, tried map[st
 func handleTaskStream(w http.ResponseWriter, r *http.Request) {
 	var req shared.TaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	req.Source = requestSource(r)
 	if req.TaskID == "" {
 		req.TaskID = uuid.New().String()
 	}
-
-	node, err := registry.FindBestNode(req.Type, req.ModelHint)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("no available nodes: %v", err), http.StatusServiceUnavailable)
+	// The stream mode may also be negotiated with ?mode=delta|full
+	if m := r.URL.Query().Get("mode"); m != "" {
+		req.StreamMode = shared.StreamMode(m)
+	}
+	if req.StreamMode == "" {
+		req.StreamMode = shared.StreamModeDelta
+	}
+	if req.StreamMode != shared.StreamModeDelta && req.StreamMode != shared.StreamModeFull {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("unknown stream_mode %q (want delta or full)", req.StreamMode))
 		return
 	}
-
-	log.Printf("[Orchestrator] Stream task %s type=%q → node %s", req.TaskID, req.Type, node.NodeID)
-	startedAt := time.Now()
-	registry.IncrementLoad(node.NodeID)
-	defer registry.DecrementLoad(node.NodeID)
-
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if g := r.URL.Query().Get("granularity"); g != "" {
+		req.StreamGranularity = shared.StreamGranularity(g)
+	}
+	switch req.StreamGranularity {
+	case "":
+		req.StreamGranularity = shared.GranularityToken
+	case shared.GranularityToken, shared.GranularityWord, shared.GranularitySentence:
+	default:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("unknown stream_granularity %q (want token, word or sentence)", req.StreamGranularity))
+		return
+	}
+	if err := checkFormat(req.Format); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkVerify(req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkQuality(req.MinQuality); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Input) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "embed inputs aren't streamed; send them to POST /task")
+		return
+	}
+	if err := checkPromptSize(req.Prompt); err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err := checkMetadata(req.Metadata); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkFiles(req.Files); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkCompress(req.Compress); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Messages) > 0 {
+		if err := shapeContext(r.Context(), &req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "streaming not supported")
 		return
 	}
+	sse := &sseWriter{w: w, flusher: flusher}
+	defer sse.keepAlive()()
+	// Identical streams in flight share one generation (see dedup.go)
+	runTaskStream(r.Context(), req, sse)
+}
 
-	// Forward to node-agent and pipe the stream back
-	err = forwardTaskStream(r.Context(), node, req, func(chunk shared.TaskChunk) {
-		if chunk.Done {
-			chunk.LatencyMs = time.Since(startedAt).Milliseconds()
-		}
-		chunk.RoutedTo = node.NodeID
+// streamTask runs a streamed task, sending its events to out until the
+// task is done or ctx is cancelled.
+func streamTask(ctx context.Context, req shared.TaskRequest, out streamSink) {
+	compressPrompt(ctx, &req)
+	out = taskHistory.watch(req, out)
+	snapshotInterval := defaultSnapshotInterval
+	if req.SnapshotIntervalMs > 0 {
+		snapshotInterval = time.Duration(req.SnapshotIntervalMs) * time.Millisecond
+	}
+	resolvedAt := time.Now()
+	pick, aliased := aliases.resolve(req.ModelHint)
+	if aliased {
+		req.ModelHint = pick.model
+	}
 
-		data, _ := json.Marshal(chunk)
-		fmt.Fprintf(w, "data: %s\n\n", data)
-		flusher.Flush()
-	})
+	streamed := false
+	if aliased {
+		defer func() { aliases.record(pick, time.Since(resolvedAt).Milliseconds(), !streamed) }()
+	}
 
+	// A node that fails before its first token is skipped, like in
+	// routeWithFailover, and the client told with a failover event. Once
+	// tokens have been sent the task can't move without repeating them.
+	ctx, err := fairShare.admit(ctx, req)
 	if err != nil {
-		log.Printf("[Orchestrator] Stream error for task %s: %v", req.TaskID, err)
+		streamLog.forget(req.TaskID)
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, errQueueCancelled) {
+			status = http.StatusGone
+		}
+		out.fail(req.TaskID, status, fmt.Sprintf("held for a node: %v", err))
+		return
+	}
+	tried := make(map[string]bool)
+	var failed *shared.FailoverEvent
+	for {
+		node, err := selectNodeOrWait(ctx, req, tried)
+		if err != nil {
+			if len(tried) > 0 {
+				err = fmt.Errorf("no more nodes to try (tried %d): %w", len(tried), err)
+			}
+			streamLog.forget(req.TaskID)
+			if req.AllowCloud && cloud.enabled() {
+				setStreamModel(out, cloud.Model)
+				streamFromCloud(ctx, out, req, err)
+				return
+			}
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, errQueueCancelled) {
+				status = http.StatusGone
+			}
+			out.fail(req.TaskID, status, fmt.Sprintf("no available nodes: %v", err))
+			return
+		}
+		if failed != nil {
+			failed.NextNode = node.NodeID
+			out.send("failover", *failed)
+		}
+
+		log.Printf("[Orchestrator] Stream task %s type=%q → node %s [%s] (attempt %d)",
+			req.TaskID, req.Type, node.NodeID, healthLabel(node), len(tried)+1)
+		startedAt := time.Now()
+		model := expectedModel(node, req.Type, req.ModelHint, req.Language, req.MinQuality)
+		setStreamModel(out, model)
+		release, err := lockExclusive(ctx, node, model, req.TaskID)
+		if err != nil {
+			out.fail(req.TaskID, http.StatusServiceUnavailable,
+				fmt.Sprintf("waiting for exclusive model %s on %s: %v", model, node.NodeID, err))
+			return
+		}
+		registry.IncrementLoad(node.NodeID, model)
+		recordDispatch(node, model)
+		keepAlive, endRun := runs.begin(node.NodeID, model)
+		req.KeepAlive = keepAlive
+
+		// Forward to node-agent and pipe the stream back. The accumulated
+		// text backs full-mode snapshots and mirroring; the batcher holds
+		// text back to the requested granularity (see granularity.go). In
+		// JSON mode the scanner checks the output as it goes, and a done
+		// chunk ending invalid JSON is held until it's repaired (see
+		// jsonmode.go), after the node's slot and lock are released. With
+		// verify every done chunk is held that way until the answer is
+		// checked (see verify.go).
+		var content strings.Builder
+		var latencyMs int64
+		var lastSnapshot time.Time
+		batch := &tokenBatcher{granularity: req.StreamGranularity}
+		var jsonCheck *jsonScanner
+		if req.Format == shared.FormatJSON {
+			jsonCheck = &jsonScanner{}
+		}
+		var heldDone *shared.TaskChunk
+		var jsonErr error
+		var final shared.TaskChunk
+		tokensSent := false
+		streamLog.start(req, node, model)
+		err = forwardTaskStream(ctx, node, req, func(chunk shared.TaskChunk) {
+			content.WriteString(chunk.Token)
+			if jsonCheck != nil {
+				jsonCheck.write(chunk.Token)
+			}
+			chunk.Token = batch.add(chunk.Token)
+			if chunk.Done {
+				chunk.Token += batch.flush()
+			} else if chunk.Token == "" {
+				return
+			}
+			if chunk.Done {
+				chunk.LatencyMs = time.Since(startedAt).Milliseconds()
+				chunk.Metadata = req.Metadata
+				latencyMs = chunk.LatencyMs
+				registry.RecordTimings(node.NodeID, chunk.Timings)
+				recordTransfer(node.NodeID, chunk.Transfer)
+			}
+			chunk.RoutedTo = node.NodeID
+
+			if req.StreamMode == shared.StreamModeFull {
+				// Snapshots are throttled; the final one is always sent
+				if !chunk.Done && time.Since(lastSnapshot) < snapshotInterval {
+					return
+				}
+				lastSnapshot = time.Now()
+				chunk.Token = ""
+				chunk.Text = content.String()[:content.Len()-batch.held()]
+			}
+			if chunk.Done && jsonCheck != nil {
+				if jsonErr = jsonCheck.finish(); jsonErr != nil {
+					heldDone = &chunk
+					return
+				}
+			}
+			if chunk.Done && req.Verify {
+				heldDone = &chunk
+				return
+			}
+			if chunk.Done {
+				final = chunk
+			}
+			tokensSent = true
+			out.send("", chunk)
+		})
+		endRun()
+		registry.DecrementLoad(node.NodeID, model)
+		release()
+		fairShare.charge(req.Source, startedAt)
+
+		if err == nil && heldDone != nil {
+			if jsonErr != nil {
+				finishJSONStream(ctx, req, model, content.String(), jsonErr, heldDone)
+				if heldDone.JSONRepaired {
+					content.Reset()
+					content.WriteString(heldDone.Text)
+				}
+			}
+			if req.Verify && heldDone.Error == "" {
+				heldDone.Verification = verifyAnswer(ctx, req, model, content.String())
+			}
+			out.send("", *heldDone)
+			final = *heldDone
+		}
+		if err == nil {
+			streamed = true
+			streamLog.finish(req.TaskID, content.String(), final)
+			mirror.MaybeMirror(req, &shared.TaskResult{
+				TaskID:    req.TaskID,
+				Content:   content.String(),
+				RoutedTo:  node.NodeID,
+				TaskType:  req.Type,
+				LatencyMs: latencyMs,
+				Success:   true,
+				Metadata:  req.Metadata,
+			})
+			return
+		}
+		log.Printf("[Orchestrator] Stream error for task %s on %s: %v", req.TaskID, node.NodeID, err)
+		if tokensSent || ctx.Err() != nil {
+			// A stream whose client went away stays resumable (see resume.go)
+			final = shared.TaskChunk{TaskID: req.TaskID, Done: true, RoutedTo: node.NodeID, Error: err.Error()}
+			if ctx.Err() == nil {
+				streamLog.finish(req.TaskID, content.String(), final)
+			}
+			out.send("", final)
+			return
+		}
+
+		tried[node.NodeID] = true
+		if !memoryRefused(err) {
+			registry.MarkSuspect(node.NodeID)
+		}
+		attempt := failedAttempt(node.NodeID, model, err, time.Since(startedAt))
+		failed = &shared.FailoverEvent{
+			TaskID:     req.TaskID,
+			Attempt:    len(tried),
+			FailedNode: node.NodeID,
+			Reason:     attempt.Error,
+			ErrorCode:  attempt.ErrorCode,
+		}
+		EmitTaskFailover(*failed)
 	}
 }
 
@@ -195,20 +982,45 @@ func handleTaskStream(w http.ResponseWriter, r *http.Request) {
 func handleRegister(w http.ResponseWriter, r *http.Request) {
 	var req shared.RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "invalid body")
 		return
 	}
 	if req.NodeID == "" {
-		http.Error(w, "node_id is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "node_id is required")
+		return
+	}
+	if err := checkAgentAPI(req.NodeID, req.APIVersion, req.Version); err != nil {
+		log.Printf("[Registry] Refused to register %s: %v", req.NodeID, err)
+		writeProblem(w, r, http.StatusUpgradeRequired, err.Error())
+		return
+	}
+	warnAgentVersion(req)
+	session, status, err := identities.admit(req, func(proven bool) (string, error) {
+		return registry.Register(req, bearerToken(r), proven)
+	})
+	if err != nil {
+		log.Printf("[Registry] Refused to register %s from %s: %v", req.NodeID, r.RemoteAddr, err)
+		writeProblem(w, r, status, err.Error())
 		return
 	}
-	registry.Register(req)
+	availability.heartbeat(req.NodeID)
+	nodeQueue.wake()
 
 	// Emit dashboard event
 	EmitNodeRegistered(req)
 
+	if probeOnRegister && !req.Pull {
+		go probeNode(req)
+	}
+	go recovery.nodeJoined(req)
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "registered"})
+	json.NewEncoder(w).Encode(shared.RegisterResponse{
+		Status:       "registered",
+		SessionToken: session,
+		Version:      shared.Version,
+		APIVersion:   shared.MeshAPIVersion,
+	})
 }
 
 // ─── Node agent: POST /heartbeat ──────────────────────────────────────────────
@@ -216,17 +1028,42 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 	var req shared.HeartbeatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if err := checkAgentAPI(req.NodeID, req.APIVersion, req.Version); err != nil {
+		writeProblem(w, r, http.StatusUpgradeRequired, err.Error())
 		return
 	}
-	if !registry.Heartbeat(req) {
-		// Node isn't registered — tell it to re-register
-		http.Error(w, "unknown node, please re-register", http.StatusNotFound)
+	// 404 (node isn't registered) tells the agent to re-register; 401 is a
+	// heartbeat without the node's session token, likely spoofed
+	if err := registry.Heartbeat(req, bearerToken(r)); err != nil {
+		if errors.Is(err, errBadSession) {
+			log.Printf("[Registry] Rejected heartbeat for %s from %s: %v", req.NodeID, r.RemoteAddr, err)
+		}
+		writeProblem(w, r, sessionStatus(err), err.Error())
 		return
 	}
+	availability.heartbeat(req.NodeID)
+	if d := req.CapabilityChanges; d != nil {
+		models, caps, changed, err := registry.ApplyCapabilities(req.NodeID, d)
+		if err != nil {
+			writeProblem(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		if changed {
+			EmitCapabilityChanged(shared.CapabilityEvent{NodeID: req.NodeID, Added: d.Added, Removed: d.Removed, Models: models, Capabilities: caps})
+		}
+	}
+
+	nodeQueue.wake()
 
 	// Emit status update for dashboard
-	EmitNodeStatus(req.NodeID, req.Status, req.ActiveTasks)
+	node, _ := registry.GetNode(req.NodeID)
+	EmitNodeStatus(req.NodeID, req.Status, req.ActiveTasks, node)
+	if req.Peers != nil {
+		topology.update(req.NodeID, req.Peers)
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -235,9 +1072,12 @@ func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	nodes := registry.AllNodes()
+	for _, node := range nodes {
+		node.Availability = availability.report(node.NodeID, availabilityWindow)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"nodes":       nodes,
+		"nodes":       append(nodes, inventory.absent(nodes)...),
 		"node_count":  len(nodes),
 		"server_time": time.Now().UnixMilli(),
 	})
@@ -274,25 +1114,58 @@ func handleDebugRouting(w http.ResponseWriter, r *http.Request) {
 
 func handlePipeline(w http.ResponseWriter, r *http.Request) {
 	var req shared.PipelineRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if isYAML(r) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = decodeYAML(body, &req)
+		}
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid YAML pipeline: "+err.Error())
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	req.Source = requestSource(r)
+	if req.InitialInput == "" {
+		writeProblem(w, r, http.StatusBadRequest, "initial_input is required")
+		return
+	}
+	if err := checkMetadata(req.Metadata); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Template != "" {
+		if err := applyTemplate(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	if len(req.Steps) == 0 {
-		http.Error(w, "pipeline must have at least one step", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "pipeline must have at least one step")
 		return
 	}
-	if req.InitialInput == "" {
-		http.Error(w, "initial_input is required", http.StatusBadRequest)
+	if err := checkVariables(req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkSteps(req.Steps); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Use the per-request context with pipeline-level timeout
+	// Pipeline-level timeout, detached from the client connection so a
+	// disconnect doesn't abort a long run — results land in the history store
 	// (each step already gets the task timeout via routeWithFailover)
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(len(req.Steps))*taskTimeout)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Duration(len(req.Steps))*taskTimeout)
 	defer cancel()
 
-	result := ExecutePipeline(ctx, req)
+	result, err := ExecutePipeline(ctx, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, err.Error())
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if !result.Success {
@@ -301,53 +1174,221 @@ func handlePipeline(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// checkSteps rejects pipeline steps whose options don't fit together.
+func checkSteps(steps []shared.PipelineStep) error {
+	for i, step := range steps {
+		err := checkCompress(step.Compress)
+		if err == nil && step.Compress != nil && step.Map != nil {
+			err = fmt.Errorf("a map step can't compress its input")
+		}
+		if err == nil {
+			err = checkFetch(step)
+		}
+		if err == nil {
+			err = checkExec(step)
+		}
+		if err == nil && step.Map != nil {
+			err = validateMap(step.Map)
+		}
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ─── Client: GET /pipelines/runs ──────────────────────────────────────────────
+// Lists persisted pipeline runs, newest first; ?source= keeps those whose
+// client key, remote IP or user agent matches (see source.go).
+
+func handleListPipelineRuns(w http.ResponseWriter, r *http.Request) {
+	runs := history.ListRuns(r.URL.Query().Get("source"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"runs":  runs,
+		"count": len(runs),
+	})
+}
+
+// ─── Client: GET /pipelines/runs/{id} ─────────────────────────────────────────
+// Returns a persisted run with its definition, step results and final output.
+
+func handleGetPipelineRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := history.GetRun(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "pipeline run not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+// ─── Client: GET /tasks/{id}/lineage ──────────────────────────────────────────
+// Returns the lineage tree a task belongs to (see lineage.go) and, for a
+// pipeline step's task, its pipeline, step index, attempt and step result.
+
+func handleTaskLineage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	tree, inTree := lineageLog.Tree(id)
+	rec, ok := history.FindTask(id)
+	if !ok && !inTree {
+		writeProblem(w, r, http.StatusNotFound, "task has no recorded lineage")
+		return
+	}
+	if !ok {
+		rec = &shared.TaskLineageRecord{TaskID: id}
+	}
+	if !inTree {
+		tree = &shared.LineageNode{ID: id, Success: rec.Step.Success, Error: rec.Step.Error}
+	}
+	rec.Tree = tree
+	rec.Feedback, _ = feedback.Get(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
 // ─── Forwarding helpers ───────────────────────────────────────────────────────
 
 // forwardTask sends a task to a node-agent and waits for the full response.
+// A response damaged on the way (see shared/checksum.go) is asked for once
+// more.
 func forwardTask(ctx context.Context, node *shared.NodeInfo, req shared.TaskRequest) (*shared.TaskResult, error) {
+	req = withTypeOptions(req)
+	if node.Pull {
+		return work.dispatch(ctx, node, req)
+	}
+	if deadline, ok := taskDeadline(ctx); ok {
+		if left := time.Until(deadline) - agentTimeoutMargin; left > 0 {
+			req.TimeoutMs = left.Milliseconds()
+		}
+	}
 	body, _ := json.Marshal(req)
-	url := fmt.Sprintf("http://%s:%d/execute", node.AgentHost, node.AgentPort)
+	result, received, err := postExecute(ctx, node, body, false)
+	sent := int64(len(body))
+	if errors.Is(err, errCorruptResult) {
+		// Cut short on the way here: the agent still has the result
+		log.Printf("[Orchestrator] Task %s: %v — asking %s again", req.TaskID, err, node.NodeID)
+		var again int64
+		result, again, err = postExecute(ctx, node, body, true)
+		sent, received = sent+int64(len(body)), received+again
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success && !result.Partial {
+		return nil, &agentTaskError{Code: result.ErrorCode, Model: result.ModelUsed, Message: result.Error}
+	}
+	result.Transfer = &shared.TaskTransfer{SentBytes: sent, ReceivedBytes: received}
+	return result, nil
+}
+
+// errCorruptResult is an agent's reply that arrived damaged: cut off, or
+// with content that doesn't match its checksum.
+var errCorruptResult = errors.New("result corrupted in transit")
+
+// postExecute POSTs an encoded task to a node's /execute and reads the
+// result. With resend the agent answers from the results it has just
+// returned, if it still has this task's, instead of generating again.
+func postExecute(ctx context.Context, node *shared.NodeInfo, body []byte, resend bool) (*shared.TaskResult, int64, error) {
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/execute"
+	if resend {
+		url += "?resend=1"
+	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	setPassedHeaders(ctx, httpReq)
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept-Encoding", shared.AcceptEncodings)
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := agentClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("agent unreachable: %w", err)
+		return nil, 0, fmt.Errorf("agent unreachable: %w", err)
 	}
 	defer resp.Body.Close()
+	counted := &countingBody{ReadCloser: resp.Body}
+	resp.Body = counted
+
+	respBody, err := shared.DecodeBody(resp)
+	if err != nil {
+		return nil, counted.n, fmt.Errorf("failed to decode agent response: %w", err)
+	}
+	defer respBody.Close()
 
 	var result shared.TaskResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode agent response: %w", err)
+	if err := json.NewDecoder(respBody).Decode(&result); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) && ctx.Err() == nil {
+			return nil, counted.n, fmt.Errorf("%w: the reply ended early", errCorruptResult)
+		}
+		return nil, counted.n, fmt.Errorf("failed to decode agent response: %w", err)
 	}
-	return &result, nil
+	if !result.ChecksumOK() {
+		return nil, counted.n, fmt.Errorf("%w: %d chars of content don't match the checksum", errCorruptResult, len(result.Content))
+	}
+	result.Checksum = ""
+	return &result, counted.n, nil
 }
 
 // forwardTaskStream sends a task to a node-agent and streams chunks back,
-// calling onChunk for each received TaskChunk.
+// calling onChunk for each received TaskChunk. The done chunk carries the
+// stream's transfer.
 func forwardTaskStream(ctx context.Context, node *shared.NodeInfo, req shared.TaskRequest, onChunk func(shared.TaskChunk)) error {
+	req = withTypeOptions(req)
+	if node.Pull {
+		return work.dispatchStream(ctx, node, req, onChunk)
+	}
 	body, _ := json.Marshal(req)
-	url := fmt.Sprintf("http://%s:%d/execute/stream", node.AgentHost, node.AgentPort)
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/execute/stream"
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
+	setPassedHeaders(ctx, httpReq)
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := agentClient.Do(httpReq)
 	if err != nil {
 		return fmt.Errorf("agent stream unreachable: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		// Refused for its memory budget, with the reason as a TaskResult
+		var result shared.TaskResult
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err == nil && result.ErrorCode != "" {
+			return &agentTaskError{Code: result.ErrorCode, Model: result.ModelUsed, Message: result.Error}
+		}
+		return fmt.Errorf("agent returned HTTP %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("agent returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	counted := &countingBody{ReadCloser: resp.Body}
+	return readAgentStream(counted, func(chunk shared.TaskChunk) {
+		if chunk.Done {
+			chunk.Transfer = &shared.TaskTransfer{SentBytes: int64(len(body)), ReceivedBytes: counted.n}
+		}
+		onChunk(chunk)
+	})
+}
+
+// readAgentStream reads an agent's NDJSON stream of TaskChunks, calling
+// onChunk for each, until the done chunk.
+func readAgentStream(body io.Reader, onChunk func(shared.TaskChunk)) error {
+	lines := shared.NewLineReader(body, maxLineBytes)
+	for {
+		line, err := lines.Next()
+		if err == io.EOF {
+			// Agents end the stream without a done chunk when generation fails
+			return fmt.Errorf("agent stream ended before the task finished")
+		}
+		if err != nil {
+			return fmt.Errorf("reading agent stream: %w", err)
+		}
 		if len(line) == 0 {
 			continue
 		}
@@ -357,8 +1398,7 @@ func forwardTaskStream(ctx context.Context, node *shared.NodeInfo, req shared.Ta
 		}
 		onChunk(chunk)
 		if chunk.Done {
-			break
+			return nil
 		}
 	}
-	return scanner.Err()
 }
@@ -0,0 +1,358 @@
+// orchestrator/middleware.go
+// The request chain every endpoint is served through.
+//
+// Rather than each route wrapping its handler in the checks it needs, the
+// whole mux sits behind one chain of layers, outermost first:
+//
+//	route     finds the apiOps entry the request matches, for the layers below
+//	observe   counts requests per route (GET /debug/requests); -log-requests logs each
+//	recover   answers a handler's panic with a 500 problem and logs its stack
+//	cors      -cors-origins, including preflight requests (see cors.go)
+//	auth      the -admin-token on /admin/ routes
+//	limit     -rate-limit per client on the routes that start work
+//	headers   picks the -pass-headers tasks carry (see passheaders.go)
+//
+// What a layer does to a route follows from its apiOps entry, so a new
+// route gets the same treatment as its neighbours without further wiring.
+// Agents' session tokens are still checked by their handlers, which learn
+// the node from the request body (see session.go).
+//
+// net/http would survive a panicking handler too, but it drops the
+// connection without an answer. A panic in a goroutine a handler started
+// still takes the orchestrator down.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// Set from the -rate-limit, -rate-burst and -log-requests flags.
+var (
+	rateLimit   float64 // requests per second per client on rate-limited routes (0 = off)
+	rateBurst   int     // requests a client may make at once (default: the rate, rounded up)
+	logRequests bool
+)
+
+// middleware is one layer of the request chain.
+type middleware func(http.Handler) http.Handler
+
+// chain puts h behind layers, the first outermost.
+func chain(h http.Handler, layers ...middleware) http.Handler {
+	for i := len(layers) - 1; i >= 0; i-- {
+		h = layers[i](h)
+	}
+	return h
+}
+
+// handler returns the mux behind the request chain.
+func (m *apiMux) handler() http.Handler {
+	return chain(m, m.withRoute, withObserve, withRecovery, m.withCORS, withAuth, withRateLimit, withPassHeaders)
+}
+
+// ─── Route ────────────────────────────────────────────────────────────────────
+
+// routeInfo is what the chain knows about a request's route.
+type routeInfo struct {
+	pattern  string // "" when no route matched
+	op       *apiOp // nil for unmatched and undocumented routes
+	panicked bool   // set by withRecovery
+}
+
+type routeKey struct{}
+
+// opsByPattern indexes apiOps by route pattern.
+var opsByPattern = sync.OnceValue(func() map[string]*apiOp {
+	ops := make(map[string]*apiOp, len(apiOps))
+	for i := range apiOps {
+		ops[apiOps[i].pattern()] = &apiOps[i]
+	}
+	return ops
+})
+
+// withRoute matches the request against the mux once, for the layers
+// that treat routes differently.
+func (m *apiMux) withRoute(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := m.ServeMux.Handler(r)
+		route := &routeInfo{pattern: pattern, op: opsByPattern()[pattern]}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+	})
+}
+
+// routeOf returns the request's route; never nil.
+func routeOf(r *http.Request) *routeInfo {
+	if route, ok := r.Context().Value(routeKey{}).(*routeInfo); ok {
+		return route
+	}
+	return &routeInfo{}
+}
+
+// ─── Observe ──────────────────────────────────────────────────────────────────
+
+// statusRecorder notes the status and size of a response. It passes
+// flushes (for SSE) and hijacks (for WebSockets) through.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// requestStats counts requests per route since the orchestrator started.
+type requestStats struct {
+	mu     sync.Mutex
+	since  time.Time
+	routes map[string]*shared.RouteStats
+}
+
+var httpStats = &requestStats{since: time.Now(), routes: make(map[string]*shared.RouteStats)}
+
+// record adds one finished request.
+func (s *requestStats) record(route *routeInfo, status int, elapsed time.Duration) {
+	name := route.pattern
+	if name == "" {
+		name = "unmatched"
+	}
+	ms := elapsed.Milliseconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.routes[name]
+	if !ok {
+		st = &shared.RouteStats{Route: name}
+		s.routes[name] = st
+	}
+	st.Requests++
+	switch {
+	case status == http.StatusTooManyRequests:
+		st.RateLimited++
+	case status >= 500:
+		st.ServerErrors++
+	case status >= 400:
+		st.ClientErrors++
+	}
+	if route.panicked {
+		st.Panics++
+	}
+	st.TotalMs += ms
+	st.MaxMs = max(st.MaxMs, ms)
+}
+
+// snapshot lists the routes' counters, by route.
+func (s *requestStats) snapshot() []shared.RouteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]shared.RouteStats, 0, len(s.routes))
+	for _, st := range s.routes {
+		cp := *st
+		cp.AvgMs = math.Round(float64(st.TotalMs)/float64(st.Requests)*10) / 10
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Route < list[j].Route })
+	return list
+}
+
+// withObserve counts every request and, with -log-requests, logs it.
+func withObserve(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			elapsed := time.Since(start)
+			httpStats.record(routeOf(r), status, elapsed)
+			if logRequests {
+				log.Printf("[HTTP] %s %s → %d (%d bytes, %dms) from %s", r.Method, r.URL.RequestURI(), status, rec.bytes, elapsed.Milliseconds(), r.RemoteAddr)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// ─── Recover ──────────────────────────────────────────────────────────────────
+
+// withRecovery turns a panicking handler into a 500 problem, when nothing
+// has been sent yet, and logs the panic with its stack.
+func withRecovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // net/http's way of aborting a response; let it
+			}
+			routeOf(r).panicked = true
+			log.Printf("[HTTP] Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			if rec, ok := w.(*statusRecorder); ok && rec.status != 0 {
+				return // too late for a problem body; the response ends here
+			}
+			writeProblem(w, r, http.StatusInternalServerError, fmt.Sprintf("internal error serving %s %s; see the orchestrator's log", r.Method, r.URL.Path))
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ─── Auth ─────────────────────────────────────────────────────────────────────
+
+// withAuth requires the -admin-token on the admin routes, when it's set.
+func withAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if op := routeOf(r).op; op != nil && op.admin() && adminToken != "" && !sameToken(bearerToken(r), adminToken) {
+			writeProblem(w, r, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ─── Rate limit ───────────────────────────────────────────────────────────────
+
+// rateBucket is a client's token bucket.
+type rateBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// rateLimiter holds a token bucket per client.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+var limiter = &rateLimiter{buckets: make(map[string]*rateBucket)}
+
+// burst is how many requests a client may make at once.
+func burst() float64 {
+	if rateBurst > 0 {
+		return float64(rateBurst)
+	}
+	return math.Max(1, math.Ceil(rateLimit))
+}
+
+// take spends one of client's tokens; when it has none left it returns
+// how long until it has one.
+func (l *rateLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := burst()
+
+	// Full buckets are the same as none; drop them now and then
+	if now.Sub(l.swept) > time.Minute {
+		for id, b := range l.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*rateLimit >= full {
+				delete(l.buckets, id)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &rateBucket{tokens: full, at: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(full, b.tokens+now.Sub(b.at).Seconds()*rateLimit)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rateLimit * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateClient names who a request counts against: its client key, else its
+// address.
+func rateClient(r *http.Request) string {
+	src := requestSource(r)
+	switch {
+	case src.Key != "":
+		return "key:" + src.Key
+	case src.RemoteIP != "":
+		return "ip:" + src.RemoteIP
+	}
+	return "local"
+}
+
+// withRateLimit holds each client to -rate-limit on the routes that start
+// work, answering 429 with a Retry-After when it's over.
+func withRateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if op := routeOf(r).op; rateLimit <= 0 || op == nil || !op.RateLimited {
+			h.ServeHTTP(w, r)
+			return
+		}
+		client := rateClient(r)
+		if ok, wait := limiter.take(client, time.Now()); !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeProblem(w, r, http.StatusTooManyRequests,
+				fmt.Sprintf("%s is over the limit of %g requests per second; retry in %ds", client, rateLimit, secs))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ─── Observability: GET /debug/requests ───────────────────────────────────────
+
+func handleDebugRequests(w http.ResponseWriter, r *http.Request) {
+	shared.WriteJSON(w, r, http.StatusOK, requestStatsResponse{
+		Since:  httpStats.since.UnixMilli(),
+		Routes: httpStats.snapshot(),
+	}, compressMinBytes)
+}
@@ -0,0 +1,192 @@
+// orchestrator/mirror.go
+// Request mirroring for model evaluation.
+//
+// A configurable percentage of production tasks is duplicated to a candidate
+// node and/or model after the client has been answered. The production and
+// candidate results are stored side-by-side (in memory and appended to
+// <data-dir>/mirror.jsonl) so a new quantization can be compared offline
+// before an alias is switched over.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// mirrorRecentLimit caps how many comparisons are kept in memory for
+// GET /mirror/results; the JSONL file keeps everything.
+const mirrorRecentLimit = 500
+
+// MirrorConfig selects which tasks are mirrored and where they go.
+type MirrorConfig struct {
+	Percent float64 // 0–100; 0 disables mirroring
+	NodeID  string  // candidate node (empty = a canary node, else any node other than the primary)
+	Model   string  // candidate model (empty = the node's default for the task type)
+}
+
+// Mirror duplicates sampled tasks to a candidate and records the comparison.
+type Mirror struct {
+	cfg MirrorConfig
+
+	mu     sync.Mutex
+	recent []shared.MirrorRecord // newest last, capped at mirrorRecentLimit
+	file   *os.File              // append-only JSONL log, nil if unavailable
+}
+
+var mirror *Mirror
+
+// NewMirror creates the mirror and opens its JSONL log under dataDir.
+// A log that can't be opened only disables persistence, not mirroring.
+func NewMirror(cfg MirrorConfig, dataDir string) *Mirror {
+	m := &Mirror{cfg: cfg}
+	if !m.Enabled() {
+		return m
+	}
+	path := filepath.Join(dataDir, "mirror.jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("[Mirror] Cannot open %s (%v) — comparisons kept in memory only", path, err)
+	} else {
+		m.file = f
+	}
+	log.Printf("[Mirror] Mirroring %.1f%% of tasks to node=%q model=%q", cfg.Percent, cfg.NodeID, cfg.Model)
+	return m
+}
+
+// Enabled reports whether any traffic is mirrored.
+func (m *Mirror) Enabled() bool {
+	return m != nil && m.cfg.Percent > 0 && (m.cfg.NodeID != "" || m.cfg.Model != "")
+}
+
+// MaybeMirror samples a completed production task and, if selected, replays
+// it against the candidate in the background. It never blocks the caller
+// and never touches the client response.
+func (m *Mirror) MaybeMirror(req shared.TaskRequest, primary *shared.TaskResult) {
+	if !m.Enabled() || rand.Float64()*100 >= m.cfg.Percent {
+		return
+	}
+	go m.run(req, primary)
+}
+
+// run executes the mirrored task and records the comparison.
+func (m *Mirror) run(req shared.TaskRequest, primary *shared.TaskResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), taskTimeout)
+	defer cancel()
+
+	record := shared.MirrorRecord{
+		TaskID:   req.TaskID,
+		TaskType: req.Type,
+		Prompt:   req.Prompt,
+		Primary: shared.MirrorOutput{
+			NodeID:    primary.RoutedTo,
+			ModelUsed: primary.ModelUsed,
+			Content:   primary.Content,
+			LatencyMs: primary.LatencyMs,
+			Success:   primary.Success,
+			Error:     primary.Error,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	if !lineageLog.Has(req.TaskID) {
+		lineageLog.Record(taskLineageNode(req.TaskID, "", "", primary, nil))
+	}
+	mirrorReq := req
+	mirrorReq.TaskID = req.TaskID + "_mirror"
+	if m.cfg.Model != "" {
+		mirrorReq.ModelHint = m.cfg.Model
+	}
+
+	node, err := m.pickNode(mirrorReq, primary.RoutedTo)
+	if err != nil {
+		record.Candidate.Error = err.Error()
+		m.store(record)
+		return
+	}
+	record.Candidate.NodeID = node.NodeID
+
+	model := expectedModel(node, mirrorReq.Type, mirrorReq.ModelHint, mirrorReq.Language, mirrorReq.MinQuality)
+	registry.IncrementLoad(node.NodeID, model)
+	startedAt := time.Now()
+	result, err := forwardTask(ctx, node, mirrorReq)
+	registry.DecrementLoad(node.NodeID, model)
+	record.Candidate.LatencyMs = time.Since(startedAt).Milliseconds()
+
+	if err != nil {
+		record.Candidate.Error = err.Error()
+	} else {
+		record.Candidate.ModelUsed = result.ModelUsed
+		record.Candidate.Content = result.Content
+		record.Candidate.Success = result.Success
+		record.Candidate.Error = result.Error
+	}
+	m.store(record)
+	lineageLog.Record(taskLineageNode(mirrorReq.TaskID, req.TaskID, shared.RelationMirror, result, err))
+	log.Printf("[Mirror] Task %s: primary %s/%s %dms vs candidate %s/%s %dms",
+		req.TaskID, record.Primary.NodeID, record.Primary.ModelUsed, record.Primary.LatencyMs,
+		record.Candidate.NodeID, record.Candidate.ModelUsed, record.Candidate.LatencyMs)
+}
+
+// pickNode resolves the candidate node: the configured node if set,
+// otherwise a canary node, or the best node other than the one that
+// served production.
+func (m *Mirror) pickNode(req shared.TaskRequest, primaryNode string) (*shared.NodeInfo, error) {
+	if m.cfg.NodeID != "" {
+		return registry.GetNode(m.cfg.NodeID)
+	}
+	return registry.FindMirrorNode(req.Type, req.ModelHint, req.Language, req.MinQuality, map[string]bool{primaryNode: true})
+}
+
+// store keeps a comparison in memory and appends it to the JSONL log.
+func (m *Mirror) store(record shared.MirrorRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recent = append(m.recent, record)
+	if len(m.recent) > mirrorRecentLimit {
+		m.recent = m.recent[len(m.recent)-mirrorRecentLimit:]
+	}
+	if m.file != nil {
+		line, _ := json.Marshal(record)
+		if _, err := m.file.Write(append(line, '\n')); err != nil {
+			log.Printf("[Mirror] Failed to append comparison: %v", err)
+		}
+	}
+}
+
+// Recent returns the in-memory comparisons, newest first.
+func (m *Mirror) Recent() []shared.MirrorRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]shared.MirrorRecord, len(m.recent))
+	for i, r := range m.recent {
+		list[len(m.recent)-1-i] = r
+	}
+	return list
+}
+
+// ─── Client: GET /mirror/results ──────────────────────────────────────────────
+
+func handleMirrorResults(w http.ResponseWriter, r *http.Request) {
+	results := mirror.Recent()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"enabled": mirror.Enabled(),
+		"percent": mirror.cfg.Percent,
+		"node_id": mirror.cfg.NodeID,
+		"model":   mirror.cfg.Model,
+		"results": results,
+		"count":   len(results),
+	})
+}
@@ -0,0 +1,249 @@
+// orchestrator/nodequeue.go
+// Waiting for a node when none can take a task.
+//
+// A task that arrives while every node that could run it is offline,
+// draining, overloaded or not registered yet used to fail at once with
+// 503, so a client had to retry through an agent's restart or a laptop
+// waking up. Instead it now waits at the orchestrator, up to -queue-depth
+// tasks at a time, and goes as soon as a capable node registers or frees
+// up: each heartbeat and registration has the queue look, and it looks
+// every nodeQueueTick anyway. A task that waited -queue-timeout (or its
+// own queue_timeout_ms) without a node fails with 503 as before, as does
+// one that finds the queue full.
+//
+// Only a task's first attempt waits. One whose node failed fails over to
+// the others or fails, since waiting wouldn't bring its node back sooner,
+// and one that may go to the cloud fallback goes there instead. Waiting
+// tasks are listed in GET /admin/queue; an operator can cancel them, but
+// not promote them, since they all go as soon as a node can take them.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// nodeQueueTick is how often waiting tasks look for a node, besides on
+// every heartbeat and registration.
+const nodeQueueTick = 250 * time.Millisecond
+
+var nodeQueue = &nodeWaitQueue{
+	maxDepth: 100,
+	timeout:  30 * time.Second,
+	kick:     make(chan struct{}, 1),
+}
+
+// nodeWaitQueue holds tasks until a node can take them.
+type nodeWaitQueue struct {
+	maxDepth int           // -queue-depth; 0 turns waiting off
+	timeout  time.Duration // -queue-timeout
+
+	mu      sync.Mutex
+	waiting []*nodeWaiter
+	kick    chan struct{} // wakes loop early
+}
+
+// nodeWaiter is a task waiting for a node; ready is closed when one may
+// take it, or when it's cancelled.
+type nodeWaiter struct {
+	req       shared.TaskRequest
+	since     time.Time
+	cancelled bool
+	ready     chan struct{}
+}
+
+// start runs the loop that releases waiting tasks.
+func (q *nodeWaitQueue) start() {
+	go func() {
+		ticker := time.NewTicker(nodeQueueTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.kick:
+			case <-ticker.C:
+			}
+			q.pass()
+		}
+	}()
+}
+
+// wake has the loop look for nodes now.
+func (q *nodeWaitQueue) wake() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// pass releases every waiting task that a node can take now, in arrival
+// order.
+func (q *nodeWaitQueue) pass() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.waiting[:0]
+	for _, w := range q.waiting {
+		if nodeFor(w.req) {
+			close(w.ready)
+			continue
+		}
+		kept = append(kept, w)
+	}
+	clear(q.waiting[len(kept):])
+	q.waiting = kept
+}
+
+// nodeFor reports whether some node could take req now, ignoring routing
+// hooks.
+func nodeFor(req shared.TaskRequest) bool {
+	if req.TargetNode != "" {
+		_, err := targetNode(req, nil)
+		return err == nil
+	}
+	return len(registry.RankCandidates(req.Type, req.ModelHint, req.Language, req.MinQuality, nil)) > 0
+}
+
+// timeoutFor is how long req may wait; 0 if it mustn't.
+func (q *nodeWaitQueue) timeoutFor(req shared.TaskRequest) time.Duration {
+	switch {
+	case q.maxDepth <= 0 || req.QueueTimeoutMs < 0:
+		return 0
+	case req.QueueTimeoutMs > 0:
+		return time.Duration(req.QueueTimeoutMs) * time.Millisecond
+	}
+	return q.timeout
+}
+
+// wait holds req until a node may take it or deadline passes. noNode is
+// why none could: wait returns it, with how long the task waited, when
+// the queue is full or the deadline passes. It returns ctx's error if the
+// wait is abandoned, and errQueueCancelled if an operator cancels the
+// task.
+func (q *nodeWaitQueue) wait(ctx context.Context, req shared.TaskRequest, deadline time.Time, noNode error) error {
+	left := time.Until(deadline)
+	if left <= 0 {
+		return noNode
+	}
+	q.mu.Lock()
+	if len(q.waiting) >= q.maxDepth {
+		q.mu.Unlock()
+		return fmt.Errorf("%w (%d tasks are already waiting for a node)", noNode, q.maxDepth)
+	}
+	w := &nodeWaiter{req: req, since: time.Now(), ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	log.Printf("[Queue] Task %s waits for a node (%d waiting): %v", req.TaskID, len(q.waiting), noNode)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(left)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		if w.cancelled {
+			return errQueueCancelled
+		}
+		log.Printf("[Queue] Task %s found a node after %v", req.TaskID, time.Since(w.since).Round(time.Millisecond))
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.leave(w) {
+		// Released or cancelled just as the wait ended
+		if w.cancelled {
+			return errQueueCancelled
+		}
+		return ctx.Err()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	log.Printf("[Queue] Task %s found no node within %v", req.TaskID, time.Since(w.since).Round(time.Millisecond))
+	return fmt.Errorf("%w, within %v of waiting", noNode, time.Since(w.since).Round(time.Second))
+}
+
+// leave drops w from the queue; false if it wasn't waiting anymore. Must
+// be called with q.mu held.
+func (q *nodeWaitQueue) leave(w *nodeWaiter) bool {
+	for i, other := range q.waiting {
+		if other == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// entries lists the waiting tasks in arrival order.
+func (q *nodeWaitQueue) entries() []shared.QueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	entries := []shared.QueueEntry{}
+	for i, w := range q.waiting {
+		entries = append(entries, shared.QueueEntry{
+			ID:         w.req.TaskID,
+			Kind:       shared.QueueNodes,
+			Position:   i,
+			Type:       w.req.Type,
+			ModelHint:  w.req.ModelHint,
+			TargetNode: w.req.TargetNode,
+			Tenant:     tenantOf(w.req.Source),
+			Source:     w.req.Source,
+			QueuedAt:   w.since.UnixMilli(),
+			WaitedMs:   now.Sub(w.since).Milliseconds(),
+		})
+	}
+	return entries
+}
+
+// cancel drops a waiting task, which fails with errQueueCancelled; false
+// if no such task is waiting.
+func (q *nodeWaitQueue) cancel(taskID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, w := range q.waiting {
+		if w.req.TaskID == taskID {
+			q.leave(w)
+			w.cancelled = true
+			close(w.ready)
+			return true
+		}
+	}
+	return false
+}
+
+// waitingTasks counts the waiting tasks.
+func (q *nodeWaitQueue) waitingTasks() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+// selectNodeOrWait picks a node for a task's first attempt as selectNode
+// does, waiting in the queue while there's none; later attempts, and
+// tasks that may go to the cloud, don't wait.
+func selectNodeOrWait(ctx context.Context, req shared.TaskRequest, tried map[string]bool) (*shared.NodeInfo, error) {
+	node, err := selectNode(ctx, req, tried)
+	if err == nil || len(tried) > 0 || (req.AllowCloud && cloud.enabled()) {
+		return node, err
+	}
+	timeout := nodeQueue.timeoutFor(req)
+	if timeout <= 0 {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for err != nil {
+		// A routing hook may still veto the node that freed up; wait on
+		if werr := nodeQueue.wait(ctx, req, deadline, err); werr != nil {
+			return nil, werr
+		}
+		node, err = selectNode(ctx, req, tried)
+	}
+	return node, nil
+}
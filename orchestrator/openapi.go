@@ -0,0 +1,351 @@
+// orchestrator/openapi.go
+// OpenAPI 3 description of the orchestrator API, served at GET /openapi.json
+// with Swagger UI at GET /docs.
+//
+// Operations are listed in apiOps (apidocs.go) with the Go types they accept
+// and return; schemas are derived from those types' JSON tags by reflection,
+// so a field added to shared.TaskRequest shows up in the spec (and in
+// clients generated from it, see clients/python) without touching the
+// catalogue. Routes are registered on an apiMux, which records them so
+// checkAPIDocs can verify every route is documented.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"echo-system/shared"
+)
+
+// apiOp documents one endpoint.
+type apiOp struct {
+	Method      string
+	Path        string
+	ID          string // operationId; also the method name in generated clients
+	Summary     string
+	Description string
+	Tag         string
+	Params      []apiParam
+	Request     any         // zero value of the JSON request body type, nil = no body; []byte{} = raw bytes
+	RequestType string      // of the request body (default application/json)
+	RequestYAML bool        // the body may also be sent as YAML (see yaml.go)
+	Response    any         // zero value of the success response type, nil = no body
+	Status      int         // success status (default 200)
+	ContentType string      // of the success response (default application/json; "-" = none)
+	Errors      map[int]any // error statuses answered with a JSON body, and its type
+	Hidden      bool        // registered but left out of the spec (static files)
+	Session     bool        // needs the calling node's session token (see session.go)
+	RateLimited bool        // counts against the client's -rate-limit (see middleware.go)
+}
+
+// apiParam is a path, query or header parameter.
+type apiParam struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Enum        []string
+	Required    bool // query parameters only; path parameters always are
+}
+
+func (op apiOp) pattern() string { return op.Method + " " + op.Path }
+
+// admin reports whether the route needs the -admin-token.
+func (op apiOp) admin() bool { return strings.HasPrefix(op.Path, "/admin/") }
+
+// ─── Route registration ───────────────────────────────────────────────────────
+
+// apiMux is the orchestrator's ServeMux; it records registered patterns so
+// checkAPIDocs can compare them with the catalogue.
+type apiMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newAPIMux() *apiMux {
+	return &apiMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *apiMux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, h)
+}
+
+func (m *apiMux) Handle(pattern string, h http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, h)
+}
+
+// checkAPIDocs reports routes missing from apiOps and entries with no route.
+func checkAPIDocs(registered []string) error {
+	documented := make(map[string]bool, len(apiOps))
+	for _, op := range apiOps {
+		documented[op.pattern()] = true
+	}
+	var problems []string
+	for _, p := range registered {
+		if !documented[p] {
+			problems = append(problems, fmt.Sprintf("route %q has no apiOps entry", p))
+		}
+		delete(documented, p)
+	}
+	for p := range documented {
+		problems = append(problems, fmt.Sprintf("apiOps entry %q has no route", p))
+	}
+	if problems != nil {
+		sort.Strings(problems)
+		return fmt.Errorf("API docs out of sync with routes (see apidocs.go):\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ─── Handlers ─────────────────────────────────────────────────────────────────
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// handleOpenAPI serves the spec. The server URL follows -public-url /
+// -base-path so generated clients work behind a proxy.
+// GET /openapi.json
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDoc, _ = json.MarshalIndent(buildOpenAPI(apiOps), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
+
+// swaggerUI loads Swagger UI from unpkg, like the dashboard loads React.
+// The spec URL is relative so it works under -base-path.
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Echo System API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+  window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+</script>
+</body>
+</html>
+`
+
+// handleDocs serves Swagger UI for the spec.
+// GET /docs
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUI))
+}
+
+// ─── Document ─────────────────────────────────────────────────────────────────
+
+// buildOpenAPI assembles the OpenAPI document for ops.
+func buildOpenAPI(ops []apiOp) map[string]any {
+	sg := &schemaGen{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, op := range ops {
+		if op.Hidden {
+			continue
+		}
+		item := paths[op.Path]
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = sg.operation(op)
+	}
+
+	server := externalPath("")
+	if server == "" {
+		server = "/"
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Echo System orchestrator",
+			"version":     "1",
+			"description": "API of the Echo System mesh orchestrator. Errors are RFC 7807 problem details (application/problem+json) unless an operation documents a JSON body of its own.",
+		},
+		"servers": []any{map[string]any{"url": server}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": sg.schemas,
+			"securitySchemes": map[string]any{
+				"adminToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Required by /admin endpoints when the orchestrator runs with -admin-token",
+				},
+				"sessionToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The session token POST /register returned to the node",
+				},
+			},
+		},
+	}
+}
+
+// ─── Schema generation ────────────────────────────────────────────────────────
+
+// schemaGen turns Go types into OpenAPI schemas, collecting named structs
+// and enums under components/schemas.
+type schemaGen struct {
+	schemas map[string]any
+}
+
+func (sg *schemaGen) operation(op apiOp) map[string]any {
+	o := map[string]any{
+		"operationId": op.ID,
+		"summary":     op.Summary,
+		"tags":        []string{op.Tag},
+	}
+	if op.Description != "" {
+		o["description"] = op.Description
+	}
+	if op.admin() {
+		o["security"] = []any{map[string]any{"adminToken": []string{}}}
+	}
+	if op.Session {
+		o["security"] = []any{map[string]any{"sessionToken": []string{}}}
+	}
+
+	var params []any
+	for _, p := range op.Params {
+		schema := map[string]any{"type": "string"}
+		if p.Enum != nil {
+			schema["enum"] = p.Enum
+		}
+		params = append(params, map[string]any{
+			"name":        p.Name,
+			"in":          p.In,
+			"required":    p.In == "path" || p.Required,
+			"description": p.Description,
+			"schema":      schema,
+		})
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+
+	if op.Request != nil {
+		requestType := op.RequestType
+		if requestType == "" {
+			requestType = "application/json"
+		}
+		content := sg.content(requestType, op.Request)
+		if op.RequestYAML {
+			content["application/yaml"] = content[requestType]
+		}
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content":  content,
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if op.Response != nil && contentType != "-" {
+		success["content"] = sg.content(contentType, op.Response)
+	}
+	responses := map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     sg.content(shared.ProblemContentType, shared.Problem{}),
+		},
+	}
+	if op.RateLimited {
+		responses[strconv.Itoa(http.StatusTooManyRequests)] = map[string]any{
+			"description": "Over the client's -rate-limit; retry after Retry-After seconds",
+			"content":     sg.content(shared.ProblemContentType, shared.Problem{}),
+		}
+	}
+	for code, body := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     sg.content("application/json", body),
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+func (sg *schemaGen) content(contentType string, body any) map[string]any {
+	if _, raw := body.([]byte); raw {
+		return map[string]any{contentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	}
+	return map[string]any{contentType: map[string]any{"schema": sg.schema(reflect.TypeOf(body))}}
+}
+
+// schema returns the schema for t, as a $ref for structs and enums.
+func (sg *schemaGen) schema(t reflect.Type) map[string]any {
+	if values, ok := apiEnums[t]; ok {
+		sg.schemas[t.Name()] = map[string]any{"type": "string", "enum": values}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return sg.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": sg.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": sg.schema(t.Elem())}
+	case reflect.Struct:
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, done := sg.schemas[name]; !done {
+			sg.schemas[name] = nil // reserve: breaks recursion
+			sg.schemas[name] = sg.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object lists a struct's JSON fields. No field is marked required: the
+// same types serve as requests, where most fields are optional.
+func (sg *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = sg.schema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
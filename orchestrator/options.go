@@ -0,0 +1,90 @@
+// orchestrator/options.go
+// Default generation options per task type.
+//
+// Tasks may carry options for the backend — Ollama's temperature,
+// num_predict, top_p, seed and so on. With -task-options the orchestrator
+// fills in defaults for each task type, so that code comes out with a low
+// temperature and summaries stay short without every client knowing how
+// the models are tuned. A client's own options win key by key; the
+// defaults are merged in when the task is sent to a node, so they reach
+// pipeline steps, pull-mode nodes and offline bundles too.
+//
+//	-task-options "code=temperature:0.1,num_predict:1024;summarize=temperature:0.3;*=top_p:0.9"
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+
+	"echo-system/shared"
+)
+
+// typeOptions maps a task type (or fallbackAnyType) to its default
+// options; set from the -task-options flag.
+var typeOptions map[shared.TaskType]map[string]any
+
+// parseTypeOptions parses the -task-options flag value. Values are JSON
+// numbers or booleans, or else taken as strings.
+// Format: "code=temperature:0.1,num_predict:1024;summarize=temperature:0.3"
+func parseTypeOptions(flag string) (map[shared.TaskType]map[string]any, error) {
+	defaults := make(map[shared.TaskType]map[string]any)
+	for _, entry := range strings.Split(flag, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		taskType, list, ok := strings.Cut(entry, "=")
+		taskType = strings.TrimSpace(taskType)
+		if !ok || taskType == "" {
+			return nil, fmt.Errorf("invalid -task-options entry %q (want type=option:value,...)", entry)
+		}
+		if _, dup := defaults[shared.TaskType(taskType)]; dup {
+			return nil, fmt.Errorf("-task-options lists %q twice", taskType)
+		}
+		opts := make(map[string]any)
+		for _, pair := range strings.Split(list, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			name, raw, ok := strings.Cut(pair, ":")
+			name, raw = strings.TrimSpace(name), strings.TrimSpace(raw)
+			if !ok || name == "" || raw == "" {
+				return nil, fmt.Errorf("invalid -task-options option %q for %q (want option:value)", pair, taskType)
+			}
+			var value any
+			if err := json.Unmarshal([]byte(raw), &value); err != nil {
+				value = raw
+			}
+			opts[name] = value
+		}
+		if len(opts) == 0 {
+			return nil, fmt.Errorf("-task-options entry for %q sets no options", taskType)
+		}
+		defaults[shared.TaskType(taskType)] = opts
+	}
+	return defaults, nil
+}
+
+// withTypeOptions merges the defaults for req's type under its own
+// options.
+func withTypeOptions(req shared.TaskRequest) shared.TaskRequest {
+	defaults, ok := typeOptions[req.Type]
+	if !ok {
+		defaults = typeOptions[fallbackAnyType]
+	}
+	if len(defaults) == 0 {
+		return req
+	}
+	merged := maps.Clone(defaults)
+	maps.Copy(merged, req.Options)
+	req.Options = merged
+	return req
+}
+
+// optionFloat reads a numeric option.
+func optionFloat(opts map[string]any, name string) (float64, bool) {
+	v, ok := opts[name].(float64)
+	return v, ok
+}
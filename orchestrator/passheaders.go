@@ -0,0 +1,51 @@
+// orchestrator/passheaders.go
+// Passing client request headers on to the agents' backends.
+//
+// A backend behind an authenticating proxy, or one that bills per caller,
+// may need something only the client has: its API key, a tenant or user
+// header. -pass-headers names the client request headers that travel with
+// every task the request routes (POST /task, /task/stream, /pipeline,
+// /summarize, conversation turns) — as headers of the /execute call, or
+// in the work item of a pull-mode node. The agent hands them to its
+// backend if its own -pass-headers names them too (see shared/headers.go).
+// They're never stored: a dead-lettered task retried later goes without
+// them.
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"echo-system/shared"
+)
+
+// passHeaderNames are the client headers tasks carry; set from -pass-headers.
+var passHeaderNames []string
+
+type passedHeadersKey struct{}
+
+// withPassHeaders makes the tasks a request routes carry its
+// -pass-headers; it's the innermost layer of the request chain.
+func withPassHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if picked := shared.PickHeaders(r.Header, passHeaderNames); picked != nil {
+			r = r.WithContext(context.WithValue(r.Context(), passedHeadersKey{}, picked))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// passedHeaders returns the client headers the tasks routed under ctx
+// carry.
+func passedHeaders(ctx context.Context) map[string]string {
+	picked, _ := ctx.Value(passedHeadersKey{}).(map[string]string)
+	return picked
+}
+
+// setPassedHeaders adds them to a request to an agent.
+func setPassedHeaders(ctx context.Context, req *http.Request) {
+	for name, value := range passedHeaders(ctx) {
+		req.Header.Set(name, value)
+	}
+}
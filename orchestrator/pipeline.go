@@ -2,9 +2,15 @@
 // Phase 4: Pipeline Engine — chains tasks across nodes.
 //
 // A pipeline is a sequence of steps where each step's output feeds into the
-// next step's prompt. The engine resolves {{prev_output}} and {{initial_input}}
-// template variables, routes each step to the best node via the registry, and
-// collects all results.
+// next step's prompt. The engine resolves {{prev_output}}, {{initial_input}}
+// and {{language}} template variables, plus the pipeline's own {{var.*}}
+// (see pipelinevars.go), routes each step to the best node via the registry, and
+// collects all results. Map steps fan out over a list (see pipelinemap.go);
+// steps may compress their input first (see compress.go); fetch steps
+// download a page for later steps' {{fetched_content}} (see fetch.go);
+// exec steps run generated code in a node's sandbox (see exec.go). Steps of
+// concurrent pipelines take turns for pipeline slots when those are limited
+// (see pipelinesched.go).
 //
 // Example: vision → summarize → code
 //   Step 1 (vision):    describe an image      → node with llava
@@ -28,42 +34,102 @@ import (
 // ─── Pipeline Engine ──────────────────────────────────────────────────────────
 
 // ExecutePipeline runs a multi-step pipeline, routing each step to the best
-// available node and threading outputs through prompt templates.
-func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) *shared.PipelineResult {
+// available node and threading outputs through prompt templates. It returns
+// errRunActive, running nothing, if a run with req's pipeline_id is still
+// going.
+func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) (*shared.PipelineResult, error) {
+	return runPipeline(ctx, req, nil)
+}
+
+// runPipeline runs the steps of a pipeline that follow done, the steps an
+// interrupted run already has when it's resumed (see recovery.go). With
+// none done it's a new run, or a re-run.
+func runPipeline(ctx context.Context, req shared.PipelineRequest, done []shared.PipelineStepResult) (*shared.PipelineResult, error) {
 	if req.PipelineID == "" {
 		req.PipelineID = uuid.New().String()
 	}
 
+	// The last attempts of the steps before this run replaces them, so a
+	// re-run's steps count on from them
 	totalStart := time.Now()
-	log.Printf("[Pipeline] Starting %s (%d steps)", req.PipelineID, len(req.Steps))
-	EmitPipelineStarted(req.PipelineID, len(req.Steps))
+	var previous map[int]shared.PipelineStepResult
+	if done == nil {
+		var err error
+		if previous, err = history.StartRun(req); err != nil {
+			return nil, err
+		}
+		log.Printf("[Pipeline] Starting %s (%d steps)", req.PipelineID, len(req.Steps))
+		EmitPipelineStarted(req.PipelineID, len(req.Steps), req.Source, req.Metadata)
+	} else {
+		log.Printf("[Pipeline] Resuming %s at step %d/%d", req.PipelineID, len(done)+1, len(req.Steps))
+		previous = history.LastAttempts(req.PipelineID)
+		history.ResumeRun(req.PipelineID, done)
+	}
 
 	results := make([]shared.PipelineStepResult, 0, len(req.Steps))
 	prevOutput := req.InitialInput
+	fetched := "" // the last fetch step's output
+	for _, step := range done {
+		results = append(results, step)
+		prevOutput = step.Content
+		if req.Steps[step.StepIndex].Fetch != nil {
+			fetched = step.Content
+		}
+	}
+	slot := pipelineSched.slot(req.PipelineID, totalStart)
+	defer slot.release()
 
 	for i, step := range req.Steps {
-		// Resolve template variables
-		prompt := resolveTemplate(step.PromptTemplate, prevOutput, req.InitialInput, i)
+		if i < len(done) {
+			continue
+		}
+		language := stepLanguage(step, req)
 
-		taskID := fmt.Sprintf("%s_step_%d", req.PipelineID, i)
+		// A fresh ID per attempt; lineage ties it back to this step
+		taskID := uuid.New().String()
+		lineage := &shared.TaskLineage{
+			PipelineID: req.PipelineID,
+			StepIndex:  i,
+			Attempt:    previous[i].Attempt + 1,
+		}
 		log.Printf("[Pipeline] Step %d/%d — type=%q model=%q",
 			i+1, len(req.Steps), step.Type, step.ModelHint)
 
 		// Build a normal TaskRequest and route it through the existing failover logic
 		taskReq := shared.TaskRequest{
-			TaskID:    taskID,
-			Prompt:    prompt,
-			Type:      step.Type,
-			ModelHint: step.ModelHint,
+			TaskID:     taskID,
+			Type:       step.Type,
+			ModelHint:  step.ModelHint,
+			Language:   language,
+			Lineage:    lineage,
+			AllowCloud: req.AllowCloud,
+			Source:     req.Source,
+			Metadata:   req.Metadata,
 		}
 
+		var taskResult *shared.TaskResult
+		var items []shared.PipelineItemResult
+		err := slot.acquire(ctx, len(req.Steps)-i)
 		stepStart := time.Now()
-		taskResult, err := routeWithFailover(ctx, taskReq, nil)
+		if err == nil {
+			taskResult, items, err = runStep(ctx, req, i, taskReq, prevOutput, fetched)
+		} else {
+			err = fmt.Errorf("waiting for a pipeline slot: %w", err)
+		}
+
+		// A re-run of the step is a retry of its last attempt
+		parent, relation := req.PipelineID, shared.RelationStep
+		if prev, ok := previous[i]; ok {
+			parent, relation = prev.TaskID, shared.RelationRetry
+		}
+		lineageLog.Record(taskLineageNode(taskID, parent, relation, taskResult, err))
 
 		stepResult := shared.PipelineStepResult{
 			StepIndex: i,
+			Attempt:   lineage.Attempt,
 			TaskID:    taskID,
 			Type:      step.Type,
+			Items:     items,
 		}
 
 		if err != nil {
@@ -72,9 +138,10 @@ func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) *shared.Pi
 			stepResult.Error = err.Error()
 			stepResult.LatencyMs = time.Since(stepStart).Milliseconds()
 			results = append(results, stepResult)
+			history.RecordStep(req.PipelineID, stepResult)
 
 			log.Printf("[Pipeline] Step %d failed: %v — aborting pipeline", i+1, err)
-			return &shared.PipelineResult{
+			failed := &shared.PipelineResult{
 				PipelineID:  req.PipelineID,
 				Steps:       results,
 				FinalOutput: "",
@@ -82,7 +149,13 @@ func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) *shared.Pi
 				LatencyMs:   time.Since(totalStart).Milliseconds(),
 				Success:     false,
 				Error:       fmt.Sprintf("step %d failed: %v", i+1, err),
+				Source:      req.Source,
+				Metadata:    req.Metadata,
 			}
+			history.FinishRun(failed)
+			recordPipelineLineage(failed)
+			EmitPipelineDone(failed)
+			return failed, nil
 		}
 
 		stepResult.RoutedTo = taskResult.RoutedTo
@@ -91,9 +164,13 @@ func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) *shared.Pi
 		stepResult.LatencyMs = taskResult.LatencyMs
 		stepResult.Success = true
 		results = append(results, stepResult)
+		history.RecordStep(req.PipelineID, stepResult)
 
 		// Thread this step's output into the next step
 		prevOutput = taskResult.Content
+		if step.Fetch != nil {
+			fetched = taskResult.Content
+		}
 
 		log.Printf("[Pipeline] Step %d done → %s (%dms, %d chars)",
 			i+1, taskResult.RoutedTo, taskResult.LatencyMs, len(taskResult.Content))
@@ -109,26 +186,97 @@ func ExecutePipeline(ctx context.Context, req shared.PipelineRequest) *shared.Pi
 		TotalSteps:  len(req.Steps),
 		LatencyMs:   time.Since(totalStart).Milliseconds(),
 		Success:     true,
+		Source:      req.Source,
+		Metadata:    req.Metadata,
 	}
+	history.FinishRun(result)
+	recordPipelineLineage(result)
 	EmitPipelineDone(result)
-	return result
+	return result, nil
+}
+
+// runStep runs step i of a pipeline as taskReq, on the previous step's
+// output; fetched is the last fetch step's output.
+func runStep(ctx context.Context, req shared.PipelineRequest, i int, taskReq shared.TaskRequest, prevOutput, fetched string) (*shared.TaskResult, []shared.PipelineItemResult, error) {
+	step := req.Steps[i]
+
+	// Compress the input before it goes into the template (see compress.go)
+	input := prevOutput
+	if step.Compress != nil && step.PromptTemplate != "" {
+		compressTask := shared.TaskRequest{
+			TaskID:     uuid.New().String(),
+			AllowCloud: req.AllowCloud,
+			Source:     req.Source,
+			Metadata:   map[string]string{"echo.compress_for": taskReq.TaskID},
+		}
+		if compressed, _, err := compressText(ctx, compressTask, input, *step.Compress); err != nil {
+			log.Printf("[Pipeline] Step %d: compressing its input failed (%v) — sending it whole", i+1, err)
+		} else {
+			input = compressed
+		}
+	}
+
+	// Resolve template variables
+	taskReq.Prompt = resolveTemplate(step.PromptTemplate, input, req.InitialInput, fetched, taskReq.Language, i, req.Variables)
+
+	switch {
+	case step.Fetch != nil:
+		url := input
+		if step.Fetch.URL != "" {
+			url = resolveTemplate(step.Fetch.URL, input, req.InitialInput, fetched, taskReq.Language, i, req.Variables)
+		}
+		result, err := runFetchStep(ctx, taskReq, url, *step.Fetch)
+		return result, nil, err
+	case step.Exec != nil:
+		code := extractCode(input)
+		if step.Exec.Code != "" {
+			code = resolveTemplate(step.Exec.Code, input, req.InitialInput, fetched, taskReq.Language, i, req.Variables)
+		}
+		result, err := runExecStep(ctx, taskReq, step, code)
+		return result, nil, err
+	case step.Map != nil:
+		// Map steps fan out over the input; items are dead-lettered individually
+		return runMapStep(ctx, req, step, *taskReq.Lineage, prevOutput, fetched)
+	case step.Compress != nil && step.PromptTemplate == "":
+		result, err := runCompressStep(ctx, taskReq, input, *step.Compress)
+		return result, nil, err
+	default:
+		startedAt := time.Now()
+		result, err := routeWithFailover(ctx, taskReq, nil)
+		taskHistory.record(taskReq, result, err, startedAt)
+		if err != nil {
+			deadLetters.Add(taskReq, err)
+		}
+		return result, nil, err
+	}
 }
 
 // ─── Template Resolution ──────────────────────────────────────────────────────
 
-// resolveTemplate replaces {{prev_output}}, {{initial_input}}, and
-// {{step_index}} in a prompt template string.
+// resolveTemplate replaces {{prev_output}}, {{initial_input}},
+// {{fetched_content}}, {{language}}, {{step_index}} and the pipeline's
+// {{var.<name>}} in a prompt template string.
 //
 // If the template is empty, the previous step's output is used as-is.
-func resolveTemplate(tmpl, prevOutput, initialInput string, stepIndex int) string {
+func resolveTemplate(tmpl, prevOutput, initialInput, fetched, language string, stepIndex int, vars map[string]string) string {
 	if tmpl == "" {
 		return prevOutput
 	}
 
-	r := strings.NewReplacer(
+	r := strings.NewReplacer(append([]string{
 		"{{prev_output}}", prevOutput,
 		"{{initial_input}}", initialInput,
+		"{{fetched_content}}", fetched,
+		"{{language}}", language,
 		"{{step_index}}", fmt.Sprintf("%d", stepIndex),
-	)
+	}, varPairs(vars)...)...)
 	return r.Replace(tmpl)
 }
+
+// stepLanguage is a step's language hint: its own, else the pipeline's.
+func stepLanguage(step shared.PipelineStep, req shared.PipelineRequest) string {
+	if step.Language != "" {
+		return step.Language
+	}
+	return req.Language
+}
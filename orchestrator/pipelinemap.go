@@ -0,0 +1,250 @@
+// orchestrator/pipelinemap.go
+// Map steps: fan a pipeline step out over a list.
+//
+// A step with a "map" block splits its input (the previous step's output)
+// into items — on a delimiter, as a JSON array, or with chunk_tokens into
+// chunks of that many tokens (see shared/chunking) — and runs its template
+// once per item, {{item}} being the item and {{item_index}} its 0-based
+// index. Items run concurrently as ordinary tasks, so the router spreads
+// them across nodes; outputs are joined in item order. If any item fails
+// (on every node), the remaining items are cancelled and the step fails.
+//
+//	{"type": "summarize", "map": {"split": "\n\n"},
+//	 "prompt_template": "Summarize this section:\n{{item}}"}
+//
+// Chunks suit documents too long for one prompt, a fetched page say:
+//
+//	{"type": "summarize", "map": {"chunk_tokens": 1000, "overlap_tokens": 100, "split": "markdown"},
+//	 "prompt_template": "List the facts in this section:\n{{item}}"}
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+	"echo-system/shared/chunking"
+)
+
+const (
+	defaultMapSplit    = "\n\n"
+	defaultMapJoin     = "\n\n"
+	defaultMapParallel = 8
+	maxMapItems        = 256
+	mapSplitJSON       = "json"
+	mapErrorsShown     = 3 // failed items named in the step error
+)
+
+// validateMap checks a step's map block before the pipeline starts.
+func validateMap(m *shared.PipelineMap) error {
+	if m.MaxParallel < 0 {
+		return fmt.Errorf("map.max_parallel must not be negative")
+	}
+	if m.ChunkTokens < 0 {
+		return fmt.Errorf("map.chunk_tokens must not be negative")
+	}
+	if m.ChunkTokens == 0 {
+		if m.OverlapTokens != 0 {
+			return fmt.Errorf("map.overlap_tokens needs map.chunk_tokens")
+		}
+		return nil
+	}
+	if !chunking.Valid(m.Split) {
+		return fmt.Errorf("map.split must be one of %s with map.chunk_tokens", strings.Join(chunking.Methods, ", "))
+	}
+	if m.OverlapTokens < 0 || m.OverlapTokens > m.ChunkTokens/2 {
+		return fmt.Errorf("map.overlap_tokens must be between 0 and half of map.chunk_tokens")
+	}
+	return nil
+}
+
+// splitMapInput turns a map step's input into its items. Blank items are
+// dropped; JSON array elements that aren't strings are passed as JSON.
+func splitMapInput(input string, m *shared.PipelineMap) ([]string, error) {
+	var raw []string
+	if m.ChunkTokens > 0 {
+		var err error
+		raw, err = chunking.Split(m.Split, input, chunking.Options{MaxTokens: m.ChunkTokens, Overlap: m.OverlapTokens})
+		if err != nil {
+			return nil, err
+		}
+	} else if m.Split == mapSplitJSON {
+		var elems []json.RawMessage
+		if err := json.Unmarshal([]byte(strings.TrimSpace(input)), &elems); err != nil {
+			return nil, fmt.Errorf("map input is not a JSON array: %v", err)
+		}
+		for _, e := range elems {
+			var s string
+			if json.Unmarshal(e, &s) != nil {
+				s = string(e)
+			}
+			raw = append(raw, s)
+		}
+	} else {
+		sep := m.Split
+		if sep == "" {
+			sep = defaultMapSplit
+		}
+		raw = strings.Split(input, sep)
+	}
+
+	items := raw[:0]
+	for _, item := range raw {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("map input has no items")
+	}
+	if len(items) > maxMapItems {
+		return nil, fmt.Errorf("map input has %d items (limit %d)", len(items), maxMapItems)
+	}
+	return items, nil
+}
+
+// runMapStep executes a map step and returns a result standing for the
+// whole step (joined content, the nodes and models used, wall-clock
+// latency) plus the per-item results.
+func runMapStep(ctx context.Context, req shared.PipelineRequest, step shared.PipelineStep, lineage shared.TaskLineage, input, fetched string) (*shared.TaskResult, []shared.PipelineItemResult, error) {
+	items, err := splitMapInput(input, step.Map)
+	if err != nil {
+		return nil, nil, err
+	}
+	parallel := step.Map.MaxParallel
+	if parallel <= 0 {
+		parallel = defaultMapParallel
+	}
+	log.Printf("[Pipeline] Step %d maps over %d items (%d at a time)", lineage.StepIndex+1, len(items), parallel)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	started := time.Now()
+	results := make([]shared.PipelineItemResult, len(items))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item string) {
+			defer wg.Done()
+			res := &results[i]
+			res.Index = i
+			res.TaskID = uuid.New().String()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				res.Error = "cancelled: " + ctx.Err().Error()
+				return
+			}
+			if ctx.Err() != nil {
+				res.Error = "cancelled: another item failed"
+				return
+			}
+
+			itemLineage := lineage
+			itemLineage.Item = i + 1
+			taskReq := shared.TaskRequest{
+				TaskID:     res.TaskID,
+				Prompt:     resolveMapTemplate(step.PromptTemplate, item, i, input, req.InitialInput, fetched, stepLanguage(step, req), lineage.StepIndex, req.Variables),
+				Type:       step.Type,
+				ModelHint:  step.ModelHint,
+				Language:   stepLanguage(step, req),
+				Lineage:    &itemLineage,
+				AllowCloud: req.AllowCloud,
+				Source:     req.Source,
+				Metadata:   req.Metadata,
+			}
+			itemStart := time.Now()
+			result, err := routeWithFailover(ctx, taskReq, nil)
+			res.LatencyMs = time.Since(itemStart).Milliseconds()
+			taskHistory.record(taskReq, result, err, itemStart)
+			if err != nil {
+				res.Error = err.Error()
+				if ctx.Err() == nil {
+					deadLetters.Add(taskReq, err)
+					cancel()
+				}
+				return
+			}
+			res.RoutedTo = result.RoutedTo
+			res.ModelUsed = result.ModelUsed
+			res.Content = result.Content
+			res.Success = true
+		}(i, item)
+	}
+	wg.Wait()
+
+	var failed []string
+	outputs := make([]string, len(results))
+	var nodes, models []string
+	for i, res := range results {
+		if !res.Success {
+			if !strings.HasPrefix(res.Error, "cancelled") && len(failed) < mapErrorsShown {
+				failed = append(failed, fmt.Sprintf("item %d: %s", i+1, res.Error))
+			}
+			continue
+		}
+		outputs[i] = res.Content
+		nodes = appendUnique(nodes, res.RoutedTo)
+		models = appendUnique(models, res.ModelUsed)
+	}
+	if len(failed) > 0 {
+		return nil, results, fmt.Errorf("map failed: %s", strings.Join(failed, "; "))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, results, err
+	}
+
+	join := step.Map.Join
+	if join == "" {
+		join = defaultMapJoin
+	}
+	return &shared.TaskResult{
+		Content:   strings.Join(outputs, join),
+		RoutedTo:  strings.Join(nodes, ","),
+		ModelUsed: strings.Join(models, ","),
+		TaskType:  step.Type,
+		LatencyMs: time.Since(started).Milliseconds(),
+		Success:   true,
+	}, results, nil
+}
+
+// resolveMapTemplate fills a map step's template for one item: {{item}} and
+// {{item_index}} plus the usual pipeline variables. An empty template sends
+// the item as-is.
+func resolveMapTemplate(tmpl, item string, index int, prevOutput, initialInput, fetched, language string, stepIndex int, vars map[string]string) string {
+	if tmpl == "" {
+		return item
+	}
+	r := strings.NewReplacer(append([]string{
+		"{{item}}", item,
+		"{{item_index}}", strconv.Itoa(index),
+		"{{prev_output}}", prevOutput,
+		"{{initial_input}}", initialInput,
+		"{{fetched_content}}", fetched,
+		"{{language}}", language,
+		"{{step_index}}", strconv.Itoa(stepIndex),
+	}, varPairs(vars)...)...)
+	return r.Replace(tmpl)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,316 @@
+// orchestrator/pipelinesched.go
+// Pipeline slots and the order steps take them in.
+//
+// Without a limit every pipeline step is dispatched as soon as the step
+// before it is done, and under load a pipeline one step from finishing
+// queues on the nodes behind the first steps of pipelines that just
+// started, so they all finish late. PUT /admin/pipelines/schedule sets how
+// many steps may run at once across all pipelines ("slots"; a map step
+// counts as one, its items limited by its own max_parallel) and the order
+// the steps waiting for a slot get one in:
+//
+//	fifo                in the order they became ready
+//	shortest-remaining  steps of the pipelines with the fewest steps left
+//	                    (this one included) first, then of the pipeline
+//	                    that started earliest — so pipelines close to done
+//	                    finish first and average completion time drops
+//
+// A pipeline keeps its slot from one step to the next, and its next step
+// competes for it with the steps waiting — so under shortest-remaining a
+// pipeline that has started rarely gives its slot up, and new pipelines
+// can wait a long time on a saturated mesh. An operator can move a waiting
+// step to the front, or cancel it and fail its pipeline (see queue.go).
+// The schedule is saved with the routing config.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+var pipelineSched = &stepScheduler{policy: shared.PipelineFIFO}
+
+// stepScheduler hands out pipeline slots.
+type stepScheduler struct {
+	mu      sync.Mutex
+	policy  shared.PipelinePolicy
+	slots   int // 0 = unlimited
+	running int
+	waiting []*stepWaiter
+	seq     uint64
+}
+
+// stepWaiter is a pipeline step waiting for a slot; ready is closed once it
+// has one, or when it's cancelled.
+type stepWaiter struct {
+	pipelineID string
+	started    time.Time // when its pipeline started
+	remaining  int       // steps its pipeline has left, this one included
+	seq        uint64    // arrival order
+	promoted   uint64    // when moved to the front; 0 if it wasn't
+	cancelled  bool
+	since      time.Time
+	ready      chan struct{}
+}
+
+// pipelineSlot is one pipeline's hold on a slot.
+type pipelineSlot struct {
+	s          *stepScheduler
+	pipelineID string
+	started    time.Time
+	held       bool
+}
+
+// slot returns pipelineID's (not yet held) slot; the pipeline started at
+// started.
+func (s *stepScheduler) slot(pipelineID string, started time.Time) *pipelineSlot {
+	return &pipelineSlot{s: s, pipelineID: pipelineID, started: started}
+}
+
+// acquire waits for a slot for the pipeline's next step, remaining steps
+// from its end. A slot held for the previous step is put up for it first.
+// It returns ctx's error if the wait is abandoned, and errQueueCancelled if
+// an operator cancels the step.
+func (p *pipelineSlot) acquire(ctx context.Context, remaining int) error {
+	s := p.s
+	s.mu.Lock()
+	if p.held {
+		p.held = false
+		s.running--
+	}
+	if s.slots <= 0 || (s.running < s.slots && len(s.waiting) == 0) {
+		s.running++
+		p.held = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.seq++
+	w := &stepWaiter{pipelineID: p.pipelineID, started: p.started, remaining: remaining, seq: s.seq, since: time.Now(), ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.admit()
+	if w.waiting(s) {
+		log.Printf("[Pipeline] %s waits for a pipeline slot (%d running, %d waiting)", p.pipelineID, s.running, len(s.waiting))
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		if w.cancelled {
+			return errQueueCancelled
+		}
+		p.held = true
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.waiting(s) {
+			s.remove(w)
+			return ctx.Err()
+		}
+		if w.cancelled {
+			return errQueueCancelled
+		}
+		// Handed a slot just as the wait ended
+		s.running--
+		s.admit()
+		return ctx.Err()
+	}
+}
+
+// release gives up the slot, if held.
+func (p *pipelineSlot) release() {
+	if !p.held {
+		return
+	}
+	p.held = false
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	p.s.running--
+	p.s.admit()
+}
+
+// waiting reports whether w is still waiting. Must be called with s.mu
+// held.
+func (w *stepWaiter) waiting(s *stepScheduler) bool {
+	for _, other := range s.waiting {
+		if other == w {
+			return true
+		}
+	}
+	return false
+}
+
+// remove drops w from the waiting steps. Must be called with s.mu held.
+func (s *stepScheduler) remove(w *stepWaiter) {
+	for i, other := range s.waiting {
+		if other == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// admit hands free slots to waiting steps in policy order. Must be called
+// with s.mu held.
+func (s *stepScheduler) admit() {
+	for len(s.waiting) > 0 && (s.slots <= 0 || s.running < s.slots) {
+		next := 0
+		for i, w := range s.waiting {
+			if s.before(w, s.waiting[next]) {
+				next = i
+			}
+		}
+		w := s.waiting[next]
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.running++
+		close(w.ready)
+	}
+}
+
+// before reports whether a gets a slot ahead of b: steps moved to the
+// front first, the last moved first, then in policy order.
+func (s *stepScheduler) before(a, b *stepWaiter) bool {
+	if a.promoted != b.promoted {
+		return a.promoted > b.promoted
+	}
+	if s.policy == shared.PipelineShortestRemaining {
+		if a.remaining != b.remaining {
+			return a.remaining < b.remaining
+		}
+		if !a.started.Equal(b.started) {
+			return a.started.Before(b.started)
+		}
+	}
+	return a.seq < b.seq
+}
+
+// get returns the schedule with the current slot usage.
+func (s *stepScheduler) get() shared.PipelineSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return shared.PipelineSchedule{Policy: s.policy, Slots: s.slots, Running: s.running, Waiting: len(s.waiting)}
+}
+
+// set changes the policy and slot count; raising or lifting the limit
+// admits waiting steps at once.
+func (s *stepScheduler) set(cfg shared.PipelineSchedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy, s.slots = cfg.Policy, cfg.Slots
+	s.admit()
+}
+
+// steps lists the waiting steps in the order they get a slot.
+func (s *stepScheduler) steps() []shared.QueueEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := append([]*stepWaiter(nil), s.waiting...)
+	sort.SliceStable(order, func(i, j int) bool { return s.before(order[i], order[j]) })
+	now := time.Now()
+	entries := []shared.QueueEntry{}
+	for i, w := range order {
+		entries = append(entries, shared.QueueEntry{
+			ID:        w.pipelineID,
+			Kind:      shared.QueuePipeline,
+			Position:  i,
+			Remaining: w.remaining,
+			QueuedAt:  w.since.UnixMilli(),
+			WaitedMs:  now.Sub(w.since).Milliseconds(),
+			Promoted:  w.promoted > 0,
+		})
+	}
+	return entries
+}
+
+// find returns the waiting step of a pipeline. Must be called with s.mu
+// held.
+func (s *stepScheduler) find(pipelineID string) *stepWaiter {
+	for _, w := range s.waiting {
+		if w.pipelineID == pipelineID {
+			return w
+		}
+	}
+	return nil
+}
+
+// promote moves a pipeline's waiting step to the front, to take the next
+// free slot; false if the pipeline has no step waiting.
+func (s *stepScheduler) promote(pipelineID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.find(pipelineID)
+	if w == nil {
+		return false
+	}
+	s.seq++
+	w.promoted = s.seq
+	return true
+}
+
+// cancel drops a pipeline's waiting step, which fails with
+// errQueueCancelled; false if the pipeline has no step waiting.
+func (s *stepScheduler) cancel(pipelineID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.find(pipelineID)
+	if w == nil {
+		return false
+	}
+	s.remove(w)
+	w.cancelled = true
+	close(w.ready)
+	return true
+}
+
+// waitingSteps counts the steps waiting for a slot.
+func (s *stepScheduler) waitingSteps() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting)
+}
+
+func validateSchedule(cfg shared.PipelineSchedule) error {
+	switch cfg.Policy {
+	case shared.PipelineFIFO, shared.PipelineShortestRemaining:
+	default:
+		return fmt.Errorf("unknown policy %q (want %s or %s)", cfg.Policy, shared.PipelineFIFO, shared.PipelineShortestRemaining)
+	}
+	if cfg.Slots < 0 {
+		return fmt.Errorf("slots must not be negative")
+	}
+	return nil
+}
+
+// ─── Admin: GET/PUT /admin/pipelines/schedule ─────────────────────────────────
+
+func handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pipelineSched.get())
+}
+
+// handleSetSchedule updates the schedule; fields left out keep their value.
+func handleSetSchedule(w http.ResponseWriter, r *http.Request) {
+	next := pipelineSched.get()
+	if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateSchedule(next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pipelineSched.set(next)
+	saveRoutingConfig()
+	log.Printf("[Admin] Pipeline schedule set to %s with %d slots", next.Policy, next.Slots)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pipelineSched.get())
+}
@@ -0,0 +1,82 @@
+// orchestrator/pipelinevars.go
+// Pipeline variables: {{var.<name>}} in step templates.
+//
+// A pipeline's "variables" are values every step's templates can use next
+// to {{prev_output}} and {{initial_input}}, fixed for the whole run. One
+// pipeline definition then serves different audiences or tones by changing
+// its variables rather than rewriting its prompts:
+//
+//	"variables": {"audience": "executives", "tone": "formal"},
+//	"steps": [{"type": "summarize", "prompt_template": "Summarize this for {{var.audience}} in a {{var.tone}} tone.\n\n{{prev_output}}"}]
+//
+// A template naming a variable the pipeline doesn't define is rejected
+// before any step runs, rather than sending a model the placeholder.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"echo-system/shared"
+)
+
+const (
+	maxPipelineVars      = 64
+	maxPipelineVarsBytes = 16 << 10 // names + values combined
+)
+
+var (
+	varNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	varRefRe  = regexp.MustCompile(`\{\{var\.([^{}]*)\}\}`)
+)
+
+// checkVariables rejects invalid variables and templates referring to
+// variables the pipeline doesn't define.
+func checkVariables(req shared.PipelineRequest) error {
+	if len(req.Variables) > maxPipelineVars {
+		return fmt.Errorf("variables has %d names, over the limit of %d", len(req.Variables), maxPipelineVars)
+	}
+	size := 0
+	for name, value := range req.Variables {
+		if !varNameRe.MatchString(name) {
+			return fmt.Errorf("variable name %q must be letters, digits, _ or -", name)
+		}
+		size += len(name) + len(value)
+	}
+	if size > maxPipelineVarsBytes {
+		return fmt.Errorf("variables are %d bytes, over the limit of %d", size, maxPipelineVarsBytes)
+	}
+	for i, step := range req.Steps {
+		for _, tmpl := range stepTemplates(step) {
+			for _, ref := range varRefRe.FindAllStringSubmatch(tmpl, -1) {
+				if _, ok := req.Variables[ref[1]]; !ok {
+					return fmt.Errorf("step %d: {{var.%s}} is not defined in variables", i+1, ref[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stepTemplates lists the templates of a step that pipeline variables are
+// resolved in.
+func stepTemplates(step shared.PipelineStep) []string {
+	list := []string{step.PromptTemplate}
+	if step.Fetch != nil {
+		list = append(list, step.Fetch.URL)
+	}
+	if step.Exec != nil {
+		list = append(list, step.Exec.Code)
+	}
+	return list
+}
+
+// varPairs returns strings.NewReplacer pairs resolving vars.
+func varPairs(vars map[string]string) []string {
+	pairs := make([]string, 0, 2*len(vars))
+	for name, value := range vars {
+		pairs = append(pairs, "{{var."+name+"}}", value)
+	}
+	return pairs
+}
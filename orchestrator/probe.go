@@ -0,0 +1,183 @@
+// orchestrator/probe.go
+// Optional capability probing at registration.
+//
+// Instead of trusting the capabilities an agent declares on its command
+// line, the orchestrator asks the agent which models Ollama really has
+// installed, runs a 1-token generation on each declared model, and keeps
+// only the (node, model) pairs that passed. The node is held out of routing
+// while the probe runs.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"echo-system/shared"
+)
+
+// probeOnRegister enables capability probing; set from the -probe flag.
+var probeOnRegister bool
+
+// probeNodeTimeout bounds the whole probe of one node (all of its models).
+const probeNodeTimeout = 5 * time.Minute
+
+// probeNode verifies a freshly registered node's capabilities in the
+// background and applies the verified set to the registry.
+func probeNode(req shared.RegisterRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeNodeTimeout)
+	defer cancel()
+
+	node := &shared.NodeInfo{NodeID: req.NodeID, AgentHost: req.AgentHost, AgentPort: req.AgentPort}
+	if node.AgentHost == "" {
+		node.AgentHost = "localhost"
+	}
+
+	installed, err := fetchAgentModels(ctx, node)
+	if err != nil {
+		// Older agents don't expose /models — fall back to trusting them
+		log.Printf("[Probe] Node %s: cannot list models (%v) — keeping declared capabilities", req.NodeID, err)
+		registry.ApplyProbe(req.NodeID, nil)
+		return
+	}
+
+	var dropped []string
+	for _, c := range req.Capabilities {
+		if !modelInstalled(installed, c.Name) {
+			log.Printf("[Probe] Node %s: dropping %s — not installed in Ollama (has %v)", req.NodeID, c.Name, installed)
+			dropped = append(dropped, c.Name)
+			continue
+		}
+		result, err := probeAgentModel(ctx, node, c.Name)
+		if err != nil {
+			log.Printf("[Probe] Node %s: dropping %s — probe failed: %v", req.NodeID, c.Name, err)
+			dropped = append(dropped, c.Name)
+			continue
+		}
+		if !result.OK {
+			log.Printf("[Probe] Node %s: dropping %s — generation failed: %s", req.NodeID, c.Name, result.Error)
+			dropped = append(dropped, c.Name)
+			continue
+		}
+		log.Printf("[Probe] Node %s: verified %s (%dms)", req.NodeID, c.Name, result.LatencyMs)
+	}
+	// Only models with capabilities are probed, and kept
+	for _, m := range req.Models {
+		if !slices.ContainsFunc(req.Capabilities, func(c shared.ModelCapability) bool { return c.Name == m }) {
+			dropped = append(dropped, m)
+		}
+	}
+
+	// Re-announce the node so the dashboard shows the verified capabilities
+	req.Models, req.Capabilities = registry.ApplyProbe(req.NodeID, dropped)
+	EmitNodeRegistered(req)
+}
+
+// modelInstalled reports whether Ollama's model list contains name.
+func modelInstalled(installed []string, name string) bool {
+	for _, m := range installed {
+		if shared.SameModel(m, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchAgentModels asks the agent for the models installed in its Ollama.
+func fetchAgentModels(ctx context.Context, node *shared.NodeInfo) ([]string, error) {
+	list, err := fetchAgentModelList(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	return list.Models, nil
+}
+
+// fetchAgentModelList fetches the agent's GET /models, with per-model
+// details from agents that report them.
+func fetchAgentModelList(ctx context.Context, node *shared.NodeInfo) (*shared.ModelListResponse, error) {
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/models"
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned HTTP %d", resp.StatusCode)
+	}
+
+	var list shared.ModelListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	return &list, nil
+}
+
+// ─── Client: GET /nodes/{id}/models ───────────────────────────────────────────
+
+// handleNodeModels relays a node's model inventory from its agent: what
+// Ollama has installed, loaded state, and declared models that are missing.
+func handleNodeModels(w http.ResponseWriter, r *http.Request) {
+	node, err := registry.GetNode(r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if node.Pull {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("node %s is in pull mode and can't be reached", node.NodeID))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	list, err := fetchAgentModelList(ctx, node)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, fmt.Sprintf("node %s: %v", node.NodeID, err))
+		return
+	}
+	list.NodeID = node.NodeID
+	// The agent's times are on its clock
+	for i := range list.Details {
+		d := &list.Details[i]
+		d.ModifiedAt = toOrchestratorTime(d.ModifiedAt, node.ClockSkewMs)
+		d.ExpiresAt = toOrchestratorTime(d.ExpiresAt, node.ClockSkewMs)
+		d.LastUsed = toOrchestratorTime(d.LastUsed, node.ClockSkewMs)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// probeAgentModel asks the agent to run a 1-token generation on model.
+func probeAgentModel(ctx context.Context, node *shared.NodeInfo, model string) (*shared.ProbeResult, error) {
+	body, _ := json.Marshal(shared.ProbeRequest{Model: model})
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/probe"
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned HTTP %d", resp.StatusCode)
+	}
+
+	var result shared.ProbeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode probe result: %w", err)
+	}
+	return &result, nil
+}
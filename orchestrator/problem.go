@@ -0,0 +1,83 @@
+// orchestrator/problem.go
+// Error responses as RFC 7807 problem details.
+//
+// Every error a handler answers is an application/problem+json body (see
+// shared.Problem) rather than a bare string: "type" names the kind of
+// failure for SDKs and scripts to branch on, "detail" is the message that
+// used to be the whole body, and "retryable" says whether sending the same
+// request again later may work. Errors that carry a richer body of their
+// own — a failed pipeline's partial result, a refused model placement —
+// keep it.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"echo-system/shared"
+)
+
+// problemTypes maps a status to the problem type it reports.
+var problemTypes = map[int]shared.ProblemType{
+	http.StatusBadRequest:            shared.ProblemInvalidRequest,
+	http.StatusUnauthorized:          shared.ProblemUnauthorized,
+	http.StatusForbidden:             shared.ProblemForbidden,
+	http.StatusNotFound:              shared.ProblemNotFound,
+	http.StatusConflict:              shared.ProblemConflict,
+	http.StatusGone:                  shared.ProblemGone,
+	http.StatusRequestEntityTooLarge: shared.ProblemTooLarge,
+	http.StatusUpgradeRequired:       shared.ProblemIncompatible,
+	http.StatusTooManyRequests:       shared.ProblemRateLimited,
+	http.StatusInternalServerError:   shared.ProblemInternal,
+	http.StatusBadGateway:            shared.ProblemNodeFailed,
+	http.StatusServiceUnavailable:    shared.ProblemUnavailable,
+	http.StatusGatewayTimeout:        shared.ProblemTimeout,
+	http.StatusInsufficientStorage:   shared.ProblemInsufficientStorage,
+}
+
+// newProblem describes an error answered with status. Failures of the mesh
+// rather than of the request are retryable.
+func newProblem(r *http.Request, status int, detail string) shared.Problem {
+	p := shared.Problem{
+		Type:   problemTypes[status],
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if p.Type == "" {
+		p.Type = shared.ProblemInternal
+	}
+	if r != nil {
+		p.Instance = externalPath(r.URL.Path)
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		p.Retryable = true
+	}
+	return p
+}
+
+// writeProblem answers an error with a problem body; it replaces
+// http.Error.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	sendProblem(w, newProblem(r, status, detail))
+}
+
+// writeTaskProblem answers an error about a task.
+func writeTaskProblem(w http.ResponseWriter, r *http.Request, status int, taskID, detail string) {
+	p := newProblem(r, status, detail)
+	p.TaskID = taskID
+	sendProblem(w, p)
+}
+
+func sendProblem(w http.ResponseWriter, p shared.Problem) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", shared.ProblemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(p)
+}
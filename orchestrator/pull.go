@@ -0,0 +1,164 @@
+// orchestrator/pull.go
+// Orchestrated model pulls.
+//
+// POST /admin/nodes/{id}/pull places a model on a node, but only after
+// checking the disk and VRAM space the node last reported in its heartbeat.
+// Placements that won't fit are refused with a structured PlacementError
+// instead of failing halfway through a multi-GB download. A pull can fill a
+// node's disk, so it's an admin endpoint: with -admin-token set it needs
+// the token.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// pullForwardTimeout matches the agent's own pull timeout plus slack.
+const pullForwardTimeout = 65 * time.Minute
+
+// checkPlacement returns nil if the model fits on the node, or a
+// PlacementError describing the shortfall.
+func checkPlacement(node *shared.NodeInfo, req shared.PullRequest) *shared.PlacementError {
+	res := node.Resources
+	if res == nil || res.DiskTotalBytes == 0 {
+		return &shared.PlacementError{
+			Error:         "unknown_resources",
+			Message:       fmt.Sprintf("node %s has not reported disk space yet", node.NodeID),
+			NodeID:        node.NodeID,
+			Model:         req.Model,
+			RequiredBytes: req.SizeBytes,
+		}
+	}
+	if req.SizeBytes > res.DiskFreeBytes {
+		return &shared.PlacementError{
+			Error: "insufficient_disk",
+			Message: fmt.Sprintf("%s needs %s of disk but node %s has %s free",
+				req.Model, formatBytes(req.SizeBytes), node.NodeID, formatBytes(res.DiskFreeBytes)),
+			NodeID:         node.NodeID,
+			Model:          req.Model,
+			RequiredBytes:  req.SizeBytes,
+			AvailableBytes: res.DiskFreeBytes,
+		}
+	}
+
+	// Nodes without a (detectable) GPU run models on CPU — no VRAM to check
+	vram := req.VRAMBytes
+	if vram == 0 {
+		vram = req.SizeBytes
+	}
+	if res.VRAMTotalBytes > 0 && vram > res.VRAMFreeBytes {
+		return &shared.PlacementError{
+			Error: "insufficient_vram",
+			Message: fmt.Sprintf("%s needs %s of GPU memory but node %s has %s free",
+				req.Model, formatBytes(vram), node.NodeID, formatBytes(res.VRAMFreeBytes)),
+			NodeID:         node.NodeID,
+			Model:          req.Model,
+			RequiredBytes:  vram,
+			AvailableBytes: res.VRAMFreeBytes,
+		}
+	}
+	return nil
+}
+
+// formatBytes renders a byte count in GiB/MiB for error messages.
+func formatBytes(b uint64) string {
+	const gib, mib = 1 << 30, 1 << 20
+	if b >= gib {
+		return fmt.Sprintf("%.1f GiB", float64(b)/gib)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(b)/mib)
+}
+
+// ─── Admin: POST /admin/nodes/{id}/pull ───────────────────────────────────────
+
+func handleModelPull(w http.ResponseWriter, r *http.Request) {
+	var req shared.PullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.NodeID = r.PathValue("id")
+	if req.Model == "" {
+		writeProblem(w, r, http.StatusBadRequest, "model is required")
+		return
+	}
+	if req.SizeBytes == 0 {
+		writeProblem(w, r, http.StatusBadRequest, "size_bytes is required")
+		return
+	}
+
+	node, err := registry.GetNode(req.NodeID)
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if node.Pull {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("node %s is in pull mode and can't be reached", node.NodeID))
+		return
+	}
+
+	if perr := checkPlacement(node, req); perr != nil {
+		log.Printf("[Pull] Refusing %s on %s: %s", req.Model, req.NodeID, perr.Message)
+		status := http.StatusInsufficientStorage
+		if perr.Error == "unknown_resources" {
+			status = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(perr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), pullForwardTimeout)
+	defer cancel()
+
+	log.Printf("[Pull] Pulling %s onto %s (%s)", req.Model, req.NodeID, formatBytes(req.SizeBytes))
+	result, err := forwardPull(ctx, node, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, fmt.Sprintf("pull failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Success {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// forwardPull asks the agent to pull the model and waits for the outcome.
+func forwardPull(ctx context.Context, node *shared.NodeInfo, req shared.PullRequest) (*shared.PullResult, error) {
+	body, _ := json.Marshal(req)
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/pull"
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	withSession(httpReq, node.NodeID)
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned HTTP %d", resp.StatusCode)
+	}
+
+	var result shared.PullResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode agent response: %w", err)
+	}
+	return &result, nil
+}
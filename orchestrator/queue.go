@@ -0,0 +1,141 @@
+// orchestrator/queue.go
+// GET /admin/queue, POST /admin/queue/{id}/promote and /cancel: what's
+// waiting at the orchestrator, and moving it along or dropping it.
+//
+// When the mesh is backlogged, work waits in four places: tasks sent to
+// POST /task/async wait for one of the running async tasks to finish
+// (async.go), tasks that find every node busy are held by fair sharing
+// (fairshare.go), tasks that find no node at all wait for one to register
+// or free up (nodequeue.go), and pipeline steps wait for a pipeline slot
+// (pipelinesched.go). GET /admin/queue lists all of it in the order it
+// will go, with how long each has waited. Promoting an entry moves it to
+// the front of its queue (the last promoted goes first); a held task still
+// waits for a node that's free for it, and tasks waiting for a node can't
+// be promoted, as they all go once one can take them. Cancelling an entry drops it: the
+// task fails with 410 and isn't dead-lettered, and a pipeline fails at the
+// step that was waiting.
+//
+// These are admin endpoints rather than a public /queue: promoting and
+// cancelling reorder and drop other clients' tasks.
+//
+// Entries are named by task ID, and pipeline steps by pipeline ID. Every
+// queueSampleInterval the total depth, counting tasks queued on pull-mode
+// nodes and waiting for exclusive models too, is sampled into the stats
+// series, so GET /stats/series shows how deep the queues ran.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// queueSampleInterval is how often the queue depth is sampled.
+const queueSampleInterval = 5 * time.Second
+
+// errQueueCancelled fails work an operator cancelled while it waited.
+var errQueueCancelled = errors.New("cancelled from the queue by an operator")
+
+// queueDepth counts the tasks waiting at the orchestrator, for a node or
+// for their turn.
+func queueDepth() int {
+	return asyncTasks.waitingTasks() + fairShare.waitingTasks() + nodeQueue.waitingTasks() +
+		pipelineSched.waitingSteps() + work.queued() + modelLocks.waiting()
+}
+
+// startQueueSampler records the queue depth in the stats series.
+func startQueueSampler() {
+	go func() {
+		ticker := time.NewTicker(queueSampleInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			statsSeries.RecordQueueDepth(queueDepth())
+		}
+	}()
+}
+
+// listQueue lists the queues' entries: async tasks, then held tasks, then
+// tasks waiting for a node, then pipeline steps, each in the order they'll
+// go.
+func listQueue() shared.QueueList {
+	var entries []shared.QueueEntry
+	entries = append(entries, asyncTasks.queued()...)
+	entries = append(entries, fairShare.held()...)
+	entries = append(entries, nodeQueue.entries()...)
+	entries = append(entries, pipelineSched.steps()...)
+	return shared.QueueList{Depth: len(entries), Entries: entries}
+}
+
+// queueEntry finds an entry by ID.
+func queueEntry(id string) (shared.QueueEntry, bool) {
+	for _, e := range listQueue().Entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return shared.QueueEntry{}, false
+}
+
+// ─── Admin: GET /admin/queue ──────────────────────────────────────────────────
+
+func handleListQueue(w http.ResponseWriter, r *http.Request) {
+	shared.WriteJSON(w, r, http.StatusOK, listQueue(), compressMinBytes)
+}
+
+// ─── Admin: POST /admin/queue/{id}/promote and /cancel ────────────────────────
+
+func handlePromoteQueued(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	e, ok := queueEntry(id)
+	if ok && e.Kind == shared.QueueNodes {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("task %s waits for a node and goes as soon as one can take it; it can't be promoted", id))
+		return
+	}
+	if ok {
+		switch e.Kind {
+		case shared.QueueAsync:
+			ok = asyncTasks.promote(id)
+		case shared.QueueHeld:
+			ok = fairShare.promote(id)
+		case shared.QueuePipeline:
+			ok = pipelineSched.promote(id)
+		}
+	}
+	if ok {
+		e, ok = queueEntry(id)
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("nothing is waiting with ID %q", id))
+		return
+	}
+	log.Printf("[Admin] Promoted %s %s to the front of its queue", e.Kind, id)
+	shared.WriteJSON(w, r, http.StatusOK, e, compressMinBytes)
+}
+
+func handleCancelQueued(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	e, ok := queueEntry(id)
+	if ok {
+		switch e.Kind {
+		case shared.QueueAsync:
+			ok = asyncTasks.cancel(id)
+		case shared.QueueHeld:
+			ok = fairShare.cancel(id)
+		case shared.QueueNodes:
+			ok = nodeQueue.cancel(id)
+		case shared.QueuePipeline:
+			ok = pipelineSched.cancel(id)
+		}
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("nothing is waiting with ID %q", id))
+		return
+	}
+	log.Printf("[Admin] Cancelled %s %s from its queue", e.Kind, id)
+	shared.WriteJSON(w, r, http.StatusOK, e, compressMinBytes)
+}
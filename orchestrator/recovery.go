@@ -0,0 +1,320 @@
+// orchestrator/recovery.go
+// Resuming the pipelines a crash or restart interrupted.
+//
+// Pipeline runs still "running" when the orchestrator died are marked
+// interrupted as history loads (see history.go). Their finished steps are
+// on disk, but the step that was in flight ran on a node: agents keep a
+// pipeline step going when its orchestrator goes away, and hold on to
+// what it ends with (see node-agent/orphans.go).
+//
+// For -recover-window after startup the orchestrator asks each agent for
+// its orphaned tasks with GET /orphans: the inventory's nodes straight
+// away, the others as they register again. The in-flight step of an
+// interrupted run, once found finished, is recorded as the run's step
+// (recovered: true), claimed from the agent with DELETE /orphans/{id},
+// and the run resumes from the step after it. An orphan still generating
+// is asked about every recoveryPoll until it finishes. If the step failed
+// on its node, or no node has it by the end of the window, the run
+// resumes from the step itself, once a node has registered to run it.
+// Resumed runs are "running" again, with resumed_at set, and dashboards
+// get a pipeline_resumed event.
+//
+// Only steps sent to /execute as one task are recovered: a run whose
+// in-flight step fetches, execs, maps or only compresses resumes from that
+// step at once. Pull-mode nodes aren't asked. A plain task's client went
+// away with the connection, so plain tasks aren't recovered.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// recoveryPoll is how often agents still running an orphaned step
+	// are asked again. Keep it under the agents' -stream-grace.
+	recoveryPoll = 5 * time.Second
+	// recoveryRequestTimeout bounds one GET /orphans.
+	recoveryRequestTimeout = 10 * time.Second
+)
+
+// recoveryWindow is how long after startup nodes are asked for the steps
+// of interrupted runs; set from -recover-window. 0 leaves runs interrupted.
+var recoveryWindow = 2 * time.Minute
+
+var recovery *Recovery
+
+// Recovery tracks the interrupted runs waiting for their in-flight step.
+type Recovery struct {
+	mu       sync.Mutex
+	runs     map[string]*recoveringRun // keyed by pipeline ID
+	deadline time.Time
+}
+
+// recoveringRun is an interrupted run and where its in-flight step is.
+type recoveringRun struct {
+	run  shared.PipelineRun
+	next int // index of the step that was in flight
+
+	// The agent still generating the step, if one said so
+	nodeID string
+	addr   string
+}
+
+// startRecovery looks for the in-flight steps of the runs history found
+// interrupted, and resumes the runs.
+func startRecovery() {
+	runs := history.Interrupted()
+	if len(runs) == 0 {
+		return
+	}
+	if recoveryWindow <= 0 {
+		log.Printf("[Recovery] %d interrupted pipeline runs left as they are (-recover-window 0)", len(runs))
+		return
+	}
+	r := &Recovery{runs: make(map[string]*recoveringRun), deadline: time.Now().Add(recoveryWindow)}
+	for _, run := range runs {
+		rr := &recoveringRun{run: run, next: len(run.Steps)}
+		if rr.next < len(run.Definition.Steps) && recoverableStep(run.Definition.Steps[rr.next]) {
+			r.runs[run.PipelineID] = rr
+		} else {
+			go r.resume(rr, nil)
+		}
+	}
+	recovery = r
+	if len(r.runs) > 0 {
+		log.Printf("[Recovery] %d interrupted pipeline runs; asking nodes for the steps of %d for %v", len(runs), len(r.runs), recoveryWindow)
+		go r.loop()
+	}
+}
+
+// loop asks the inventory's nodes, then keeps asking the agents running
+// orphaned steps until every run has resumed.
+func (r *Recovery) loop() {
+	if inventory != nil {
+		for _, n := range inventory.nodes {
+			if n.Host != "" && n.Port != 0 {
+				go r.ask(n.NodeID, shared.BaseURL(n.Host, n.Port))
+			}
+		}
+	}
+	ticker := time.NewTicker(recoveryPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		waiting := make(map[string]string)
+		for id, rr := range r.runs {
+			switch {
+			case rr.nodeID != "":
+				waiting[rr.nodeID] = rr.addr
+			case time.Now().After(r.deadline):
+				log.Printf("[Recovery] No node has step %d of %s — running it again", rr.next+1, id)
+				delete(r.runs, id)
+				go r.resume(rr, nil)
+			}
+		}
+		left := len(r.runs)
+		r.mu.Unlock()
+		if left == 0 {
+			log.Printf("[Recovery] Done")
+			return
+		}
+		for nodeID, addr := range waiting {
+			go r.ask(nodeID, addr)
+		}
+	}
+}
+
+// nodeJoined asks a node that registered during the window.
+func (r *Recovery) nodeJoined(req shared.RegisterRequest) {
+	if r == nil || req.Pull {
+		return
+	}
+	r.mu.Lock()
+	asking := len(r.runs) > 0 && time.Now().Before(r.deadline)
+	r.mu.Unlock()
+	if asking {
+		host := req.AgentHost
+		if host == "" {
+			host = "localhost"
+		}
+		r.ask(req.NodeID, shared.BaseURL(host, req.AgentPort))
+	}
+}
+
+// ask fetches a node's orphaned tasks and settles the runs they belong to.
+func (r *Recovery) ask(nodeID, addr string) {
+	list, err := fetchOrphans(addr)
+	if err != nil {
+		log.Printf("[Recovery] Node %s: %v", nodeID, err)
+		r.lost(nodeID)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, task := range list.Tasks {
+		lineage := task.Lineage
+		if lineage == nil || lineage.Item != 0 {
+			continue
+		}
+		rr, ok := r.runs[lineage.PipelineID]
+		if !ok || lineage.StepIndex != rr.next {
+			continue
+		}
+		switch task.Status {
+		case shared.OrphanRunning:
+			if rr.nodeID == "" {
+				log.Printf("[Recovery] Step %d of %s is still running on %s", rr.next+1, rr.run.PipelineID, nodeID)
+			}
+			rr.nodeID, rr.addr = nodeID, addr
+			continue
+		case shared.OrphanDone:
+			result := task.Result
+			if result == nil || !result.Success || (result.Checksum != "" && result.Checksum != shared.ContentChecksum(result.Content)) {
+				continue
+			}
+			result.RoutedTo = nodeID
+			log.Printf("[Recovery] Collected step %d of %s from %s", rr.next+1, rr.run.PipelineID, nodeID)
+			delete(r.runs, rr.run.PipelineID)
+			go r.resume(rr, &task)
+		case shared.OrphanFailed:
+			log.Printf("[Recovery] Step %d of %s failed on %s — running it again", rr.next+1, rr.run.PipelineID, nodeID)
+			delete(r.runs, rr.run.PipelineID)
+			go r.resume(rr, nil)
+		}
+		go claimOrphan(nodeID, addr, task.TaskID)
+	}
+
+	// A run whose step this node was running, and no longer lists, is lost
+	for id, rr := range r.runs {
+		if rr.nodeID == nodeID && !listsStep(list, id, rr.next) {
+			rr.nodeID, rr.addr = "", ""
+		}
+	}
+}
+
+// lost forgets that an unreachable node was running steps.
+func (r *Recovery) lost(nodeID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rr := range r.runs {
+		if rr.nodeID == nodeID {
+			rr.nodeID, rr.addr = "", ""
+		}
+	}
+}
+
+// recoverableStep reports whether a step runs as one task on a node, the
+// task its orphan would be.
+func recoverableStep(step shared.PipelineStep) bool {
+	return step.Fetch == nil && step.Exec == nil && step.Map == nil &&
+		(step.Compress == nil || step.PromptTemplate != "")
+}
+
+// listsStep reports whether an orphan list still has a run's step.
+func listsStep(list *shared.OrphanList, pipelineID string, step int) bool {
+	for _, task := range list.Tasks {
+		if l := task.Lineage; l != nil && l.PipelineID == pipelineID && l.StepIndex == step && l.Item == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// resume records a collected step, if there is one, and runs the rest of
+// the pipeline.
+func (r *Recovery) resume(rr *recoveringRun, collected *shared.OrphanedTask) {
+	run := rr.run
+	done := append([]shared.PipelineStepResult{}, run.Steps...)
+	if collected != nil {
+		result := collected.Result
+		step := shared.PipelineStepResult{
+			StepIndex: rr.next,
+			Attempt:   collected.Lineage.Attempt,
+			TaskID:    collected.TaskID,
+			Type:      run.Definition.Steps[rr.next].Type,
+			RoutedTo:  result.RoutedTo,
+			ModelUsed: result.ModelUsed,
+			Content:   result.Content,
+			LatencyMs: result.LatencyMs,
+			Success:   true,
+			Recovered: true,
+		}
+		parent, relation := run.PipelineID, shared.RelationStep
+		if prev, ok := history.LastAttempts(run.PipelineID)[rr.next]; ok {
+			parent, relation = prev.TaskID, shared.RelationRetry
+		}
+		lineageLog.Record(taskLineageNode(step.TaskID, parent, relation, result, nil))
+		done = append(done, step)
+	}
+
+	// The steps left need nodes to go to: wait for the first to register
+	// again, until the window ends
+	for registry.count() == 0 && time.Now().Before(r.deadline) {
+		time.Sleep(recoveryPoll)
+	}
+
+	EmitPipelineResumed(run.Definition, len(done), len(done)-len(run.Steps))
+	req := run.Definition
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(req.Steps)-len(done)+1)*taskTimeout)
+	defer cancel()
+	runPipeline(ctx, req, done)
+}
+
+// fetchOrphans asks an agent for its orphaned tasks.
+func fetchOrphans(addr string) (*shared.OrphanList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", addr+"/orphans", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// Agents from before orphans keep none
+		return &shared.OrphanList{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /orphans returned HTTP %d", resp.StatusCode)
+	}
+	var list shared.OrphanList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode orphans: %w", err)
+	}
+	return &list, nil
+}
+
+// claimOrphan tells an agent it can drop an orphaned task.
+func claimOrphan(nodeID, addr, taskID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", addr+"/orphans/"+taskID, nil)
+	if err != nil {
+		return
+	}
+	withSession(req, nodeID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("[Recovery] Failed to claim task %s from %s: %v", taskID, nodeID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		// 401 from a node that hasn't registered again yet: the agent
+		// drops the orphan after its retention
+		log.Printf("[Recovery] Failed to claim task %s from %s: HTTP %d", taskID, nodeID, resp.StatusCode)
+	}
+}
@@ -1,45 +1,134 @@
 // orchestrator/registry.go
 // Keeps track of all connected node-agents.
 // Thread-safe — multiple HTTP handlers read/write concurrently.
+//
+// Nodes are sharded by a hash of their ID so that heartbeats and load
+// updates for different nodes don't contend on one lock. Routing reads
+// per-shard snapshots (immutable copies rebuilt after a write) and never
+// blocks heartbeat handling for longer than one shard's copy.
 
 package main
 
 import (
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"echo-system/shared"
 )
 
+// registryShards is the number of independently locked node shards.
+const registryShards = 16
+
 // Registry holds all known nodes and provides routing decisions.
 type Registry struct {
-	mu    sync.RWMutex
-	nodes map[string]*shared.NodeInfo // keyed by node_id
+	shards [registryShards]*registryShard
+}
+
+// registryShard owns the nodes whose ID hashes to it.
+type registryShard struct {
+	mu       sync.RWMutex
+	nodes    map[string]*shared.NodeInfo // keyed by node_id
+	profiles map[string]*loadProfile     // latency per concurrency level, keyed by node_id
+	drained  map[string]bool             // node IDs an operator drained; survives re-registration
+	sessions map[string]string           // session token per node_id; replaced on every registration
+
+	// snapshot holds read-only copies of nodes for routing; nil after a
+	// write until the next reader rebuilds it. Only stored with mu held.
+	snapshot atomic.Pointer[[]*shared.NodeInfo]
 }
 
 func NewRegistry() *Registry {
-	r := &Registry{
-		nodes: make(map[string]*shared.NodeInfo),
+	r := &Registry{}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{
+			nodes:    make(map[string]*shared.NodeInfo),
+			profiles: make(map[string]*loadProfile),
+			drained:  make(map[string]bool),
+			sessions: make(map[string]string),
+		}
 	}
 	// Start background goroutine that marks stale nodes as offline
 	go r.evictLoop()
 	return r
 }
 
+// shard returns the shard that owns nodeID.
+func (r *Registry) shard(nodeID string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(nodeID))
+	return r.shards[h.Sum32()%registryShards]
+}
+
+// lock write-locks the shard and invalidates its routing snapshot.
+func (s *registryShard) lock() {
+	s.mu.Lock()
+	s.snapshot.Store(nil)
+}
+
+// nodesSnapshot returns read-only copies of the shard's nodes, rebuilding
+// the snapshot if a write invalidated it. Callers must not mutate them.
+func (s *registryShard) nodesSnapshot() []*shared.NodeInfo {
+	if snap := s.snapshot.Load(); snap != nil {
+		return *snap
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if snap := s.snapshot.Load(); snap != nil {
+		return *snap
+	}
+	list := make([]*shared.NodeInfo, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		copy := *n
+		list = append(list, &copy)
+	}
+	s.snapshot.Store(&list)
+	return list
+}
+
+// count returns the number of registered nodes across all shards.
+func (r *Registry) count() int {
+	total := 0
+	for _, s := range r.shards {
+		s.mu.RLock()
+		total += len(s.nodes)
+		s.mu.RUnlock()
+	}
+	return total
+}
+
 // ─── Registration ─────────────────────────────────────────────────────────────
 
-func (r *Registry) Register(req shared.RegisterRequest) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// Register adds or refreshes a node and returns its new session token.
+// token is the caller's current one, required while the node is alive.
+func (r *Registry) Register(req shared.RegisterRequest, token string, proven bool) (string, error) {
+	s := r.shard(req.NodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	// Nobody but its own agent may take over a live node's ID: the one
+	// holding its session token, or its pinned identity key (identity.go)
+	if prev, ok := s.nodes[req.NodeID]; ok && isAlive(prev) && !sameToken(token, s.sessions[req.NodeID]) {
+		if !proven {
+			return "", errSessionConflict
+		}
+		log.Printf("[Registry] Node %s re-registered by its identity while still online, from %s:%d (was %s:%d)",
+			req.NodeID, req.AgentHost, req.AgentPort, prev.AgentHost, prev.AgentPort)
+	}
 
 	now := time.Now().UnixMilli()
 	agentHost := req.AgentHost
 	if agentHost == "" {
 		agentHost = "localhost"
 	}
-	r.nodes[req.NodeID] = &shared.NodeInfo{
+	node := &shared.NodeInfo{
 		NodeID:        req.NodeID,
 		AgentHost:     agentHost,
 		AgentPort:     req.AgentPort,
@@ -50,30 +139,165 @@ func (r *Registry) Register(req shared.RegisterRequest) {
 		ActiveTasks:   0,
 		LastHeartbeat: now,
 		RegisteredAt:  now,
+		Probing:       probeOnRegister && !req.Pull, // the orchestrator can't reach pull-mode agents to probe them
+		BusyThreshold: req.BusyThreshold,
+		Slots:         declaredSlots(req.Slots),
+		Draining:      s.drained[req.NodeID],
+		Reputation:    1,
+		Local:         isLocalHost(agentHost),
+		Pull:          req.Pull,
+		Canary:        req.Canary,
+		Exec:          req.Exec,
+		Version:       req.Version,
+		APIVersion:    req.APIVersion,
+		Identity:      shared.IdentityFingerprint(req.IdentityKey),
+		CapabilitySeq: req.CapabilitySeq,
+
+		CapabilitiesConfirmed: now,
+	}
+	// Routing signals and stats survive re-registration
+	if prev, ok := s.nodes[req.NodeID]; ok {
+		node.AvgLatencyMs = prev.AvgLatencyMs
+		node.Reputation = prev.Reputation
+		node.FailureRate = prev.FailureRate
+		node.LastFailureAt = prev.LastFailureAt
+		node.Timings = prev.Timings
+		node.Transfer = prev.Transfer
 	}
+	node.EffectiveBusyThreshold = s.effectiveBusyThreshold(node)
+	node.ClockSkewMs = clockSkew(req.Time, now)
+	if skewExceeded(node.ClockSkewMs) {
+		log.Printf("[Registry] Node %s's clock is %s of the orchestrator's", req.NodeID, formatSkew(node.ClockSkewMs))
+	}
+	s.nodes[req.NodeID] = node
 	log.Printf("[Registry] Node registered: %s (agent :%d, ollama :%d, models: %v)",
 		req.NodeID, req.AgentPort, req.OllamaPort, req.Models)
 	for _, cap := range req.Capabilities {
 		log.Printf("[Registry]   %s handles: %v", cap.Name, cap.Types)
 	}
+
+	session := newSessionToken()
+	s.sessions[req.NodeID] = session
+	return session, nil
+}
+
+// ApplyProbe drops the models that failed a node's probe and makes it
+// routable again, returning its models and capabilities. Changes that
+// heartbeats brought in while the probe ran (capabilities.go) stand.
+func (r *Registry) ApplyProbe(nodeID string, dropped []string) ([]string, []shared.ModelCapability) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, nil
+	}
+	dropModels(node, dropped)
+	node.Probing = false
+	log.Printf("[Registry] Node %s probe complete: %d verified models %v", nodeID, len(node.Models), node.Models)
+	return node.Models, node.Capabilities
+}
+
+// dropModels removes models and their capabilities from a node. Must be
+// called with the node's shard locked.
+func dropModels(node *shared.NodeInfo, dropped []string) {
+	caps := make([]shared.ModelCapability, 0, len(node.Capabilities))
+	for _, c := range node.Capabilities {
+		if !slices.Contains(dropped, c.Name) {
+			caps = append(caps, c)
+		}
+	}
+	models := make([]string, 0, len(node.Models))
+	for _, m := range node.Models {
+		if !slices.Contains(dropped, m) {
+			models = append(models, m)
+		}
+	}
+	node.Capabilities = caps
+	node.Models = models
 }
 
 // ─── Heartbeat ────────────────────────────────────────────────────────────────
 
-// Heartbeat updates a node's last-seen time and load metrics.
-// Returns false if the node isn't registered.
-func (r *Registry) Heartbeat(req shared.HeartbeatRequest) bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// Heartbeat updates a node's last-seen time and load metrics, provided
+// token is the node's session token. Returns errUnknownNode if the node
+// isn't registered.
+func (r *Registry) Heartbeat(req shared.HeartbeatRequest, token string) error {
+	s := r.shard(req.NodeID)
+	s.lock()
+	defer s.mu.Unlock()
 
-	node, ok := r.nodes[req.NodeID]
+	node, ok := s.nodes[req.NodeID]
 	if !ok {
-		return false
+		return errUnknownNode
+	}
+	if !sameToken(token, s.sessions[req.NodeID]) {
+		return errBadSession
 	}
 	node.LastHeartbeat = time.Now().UnixMilli()
-	node.Status = req.Status
 	node.ActiveTasks = req.ActiveTasks
-	return true
+	if req.Time != 0 {
+		skew := clockSkew(req.Time, node.LastHeartbeat)
+		if was, now := skewExceeded(node.ClockSkewMs), skewExceeded(skew); was != now {
+			if now {
+				log.Printf("[Registry] Node %s's clock drifted to %s of the orchestrator's", req.NodeID, formatSkew(skew))
+			} else {
+				log.Printf("[Registry] Node %s's clock is back in sync (%s)", req.NodeID, formatSkew(skew))
+			}
+		}
+		node.ClockSkewMs = skew
+	}
+	if req.Resources != nil {
+		node.Resources = req.Resources
+	}
+	if req.Slots != nil {
+		node.Slots = req.Slots
+	}
+	node.LoadedModels = req.Loaded
+	node.LoadedMemory = loadedMemory(req.LoadedMemory, node.ClockSkewMs)
+	if req.Version != "" {
+		node.Version, node.APIVersion = req.Version, req.APIVersion
+	}
+	if was, now := thermalState(node.Thermal), thermalState(req.Thermal); was != now {
+		log.Printf("[Registry] Node %s thermal state %s → %s (%s)", req.NodeID, was, now, formatTemps(req.Thermal))
+	}
+	node.Thermal = req.Thermal
+	setLink(node, req.Link)
+	if req.CapabilitiesChecked != 0 {
+		// On the orchestrator's clock
+		confirmCapabilities(node, req.CapabilitiesChecked-node.ClockSkewMs)
+	}
+	node.Status = req.Status
+	// The agent only knows its declared threshold; idle/busy is decided
+	// here against the effective (possibly adapted) one
+	if req.Status == shared.StatusIdle || req.Status == shared.StatusBusy {
+		node.Status = loadStatus(node)
+	}
+	return nil
+}
+
+// CheckSession reports whether token is nodeID's session token.
+func (r *Registry) CheckSession(nodeID, token string) error {
+	s := r.shard(nodeID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.nodes[nodeID]; !ok {
+		return errUnknownNode
+	}
+	if !sameToken(token, s.sessions[nodeID]) {
+		return errBadSession
+	}
+	return nil
+}
+
+// Session returns nodeID's current session token, "" for unknown nodes.
+func (r *Registry) Session(nodeID string) string {
+	s := r.shard(nodeID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sessions[nodeID]
 }
 
 // ─── Routing ──────────────────────────────────────────────────────────────────
@@ -86,51 +310,125 @@ func (r *Registry) Heartbeat(req shared.HeartbeatRequest) bool {
 //  3. Any available node  (fallback if no type was specified)
 //  4. Fewest active tasks (tiebreaker at each level)
 func (r *Registry) FindBestNode(taskType shared.TaskType, modelHint string) (*shared.NodeInfo, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	return r.findBest(taskType, modelHint, nil)
+	return r.findBest(taskType, modelHint, "", "", nil)
 }
 
 // ─── Load tracking ────────────────────────────────────────────────────────────
 
-func (r *Registry) IncrementLoad(nodeID string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if node, ok := r.nodes[nodeID]; ok {
+// IncrementLoad records a task dispatched to a node (taking a slot of model,
+// if the node declares slots) and returns the number of tasks now in
+// flight there (used to build the node's load profile).
+func (r *Registry) IncrementLoad(nodeID, model string) int {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+	if node, ok := s.nodes[nodeID]; ok {
 		node.ActiveTasks++
-		if node.ActiveTasks >= 5 {
+		adjustSlot(node, model, -1)
+		if loadStatus(node) == shared.StatusBusy {
 			node.Status = shared.StatusBusy
 		}
+		return node.ActiveTasks
 	}
+	return 0
 }
 
-func (r *Registry) DecrementLoad(nodeID string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if node, ok := r.nodes[nodeID]; ok {
+// DecrementLoad records a task finishing on a node, freeing its slot.
+func (r *Registry) DecrementLoad(nodeID, model string) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+	if node, ok := s.nodes[nodeID]; ok {
 		if node.ActiveTasks > 0 {
 			node.ActiveTasks--
 		}
-		if node.ActiveTasks < 5 {
+		adjustSlot(node, model, +1)
+		if loadStatus(node) == shared.StatusIdle {
 			node.Status = shared.StatusIdle
 		}
 	}
 }
 
+// loadStatus returns idle or busy for a node: by free slots when it
+// declares them, otherwise by its effective busy threshold, halved while
+// the node is thermally throttled. A hot node is always busy. Must be
+// called with at least the shard's read lock held.
+func loadStatus(node *shared.NodeInfo) shared.NodeStatus {
+	thermal := thermalState(node.Thermal)
+	if thermal == shared.ThermalHot {
+		return shared.StatusBusy
+	}
+	if len(node.Slots) > 0 {
+		if slotsFull(node) {
+			return shared.StatusBusy
+		}
+		return shared.StatusIdle
+	}
+	threshold := node.EffectiveBusyThreshold
+	if thermal == shared.ThermalThrottled {
+		threshold = max(1, threshold/2)
+	}
+	if node.ActiveTasks >= threshold {
+		return shared.StatusBusy
+	}
+	return shared.StatusIdle
+}
+
+// thermalState is a node's reported thermal state, normal if it reports none.
+func thermalState(t *shared.Thermal) shared.ThermalState {
+	if t == nil || t.State == "" {
+		return shared.ThermalNormal
+	}
+	return t.State
+}
+
+// formatTemps renders a thermal report for logs, e.g. "CPU 84°C, GPU 71°C".
+func formatTemps(t *shared.Thermal) string {
+	if t == nil {
+		return "no readings"
+	}
+	var parts []string
+	if t.CPUTempC > 0 {
+		parts = append(parts, fmt.Sprintf("CPU %.0f°C", t.CPUTempC))
+	}
+	if t.GPUTempC > 0 {
+		parts = append(parts, fmt.Sprintf("GPU %.0f°C", t.GPUTempC))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // ─── Status ───────────────────────────────────────────────────────────────────
 
 func (r *Registry) AllNodes() []*shared.NodeInfo {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	list := make([]*shared.NodeInfo, 0, len(r.nodes))
-	for _, n := range r.nodes {
-		copy := *n // return a copy so callers can't mutate registry state
-		list = append(list, &copy)
+	var list []*shared.NodeInfo
+	for _, s := range r.shards {
+		for _, n := range s.nodesSnapshot() {
+			copy := *n // return a copy so callers can't mutate registry state
+			gradeNode(&copy)
+			list = append(list, &copy)
+		}
 	}
 	return list
 }
 
+// GetNode returns a copy of a single live, routable node by ID.
+func (r *Registry) GetNode(nodeID string) (*shared.NodeInfo, error) {
+	s := r.shard(nodeID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("node %q is not registered", nodeID)
+	}
+	if !isAlive(node) || node.Status == shared.StatusOffline {
+		return nil, fmt.Errorf("node %q is offline", nodeID)
+	}
+	copy := *node
+	gradeNode(&copy)
+	return &copy, nil
+}
+
 // ─── Eviction loop ────────────────────────────────────────────────────────────
 
 // evictLoop runs every 5 seconds and marks nodes as offline
@@ -139,20 +437,40 @@ func (r *Registry) evictLoop() {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 	for range ticker.C {
-		r.mu.Lock()
-		for id, node := range r.nodes {
-			if node.Status != shared.StatusOffline && !r.isAlive(node) {
-				node.Status = shared.StatusOffline
-				log.Printf("[Registry] Node went offline: %s (no heartbeat for 15s)", id)
-			}
+		for _, s := range r.shards {
+			s.evictStale()
+		}
+	}
+}
+
+// evictStale marks the shard's silent nodes offline. The write lock (and
+// snapshot invalidation) is only taken when something actually changed.
+func (s *registryShard) evictStale() {
+	s.mu.RLock()
+	stale := false
+	for _, node := range s.nodes {
+		if node.Status != shared.StatusOffline && !isAlive(node) {
+			stale = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+	for id, node := range s.nodes {
+		if node.Status != shared.StatusOffline && !isAlive(node) {
+			node.Status = shared.StatusOffline
+			log.Printf("[Registry] Node went offline: %s (no heartbeat for 15s)", id)
 		}
-		r.mu.Unlock()
 	}
 }
 
 // isAlive checks if the node sent a heartbeat recently.
-// Must be called with at least a read lock held.
-func (r *Registry) isAlive(node *shared.NodeInfo) bool {
+func isAlive(node *shared.NodeInfo) bool {
 	return time.Now().UnixMilli()-node.LastHeartbeat < 15_000
 }
 
@@ -167,89 +485,263 @@ func containsModel(models []string, target string) bool {
 
 // FindBestNodeExcluding is like FindBestNode but skips nodes in the
 // already-tried set. Used by the failover router.
-func (r *Registry) FindBestNodeExcluding(taskType shared.TaskType, modelHint string, exclude map[string]bool) (*shared.NodeInfo, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	return r.findBest(taskType, modelHint, exclude)
+// language, if set, is the task's language hint; minQuality its min_quality.
+func (r *Registry) FindBestNodeExcluding(taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier, exclude map[string]bool) (*shared.NodeInfo, error) {
+	return r.findBest(taskType, modelHint, language, minQuality, exclude)
 }
 
 // findBest is the shared routing logic used by both FindBestNode and
-// FindBestNodeExcluding. Must be called with at least a read lock held.
+// FindBestNodeExcluding. It returns a copy of the chosen node.
 //
 // Routing tiers (tried in order, picks lowest active_tasks within each tier):
 //
 //	Tier 1: exact model name match (model_hint)
 //	Tier 2: task type match via capabilities
 //	Tier 3: any live node (fallback when type is TaskTypeAny)
-func (r *Registry) findBest(taskType shared.TaskType, modelHint string, exclude map[string]bool) (*shared.NodeInfo, error) {
-	isCandidate := func(node *shared.NodeInfo) bool {
-		if exclude != nil && exclude[node.NodeID] {
-			return false
-		}
-		if !r.isAlive(node) {
-			return false
-		}
-		if node.Status == shared.StatusOverloaded || node.Status == shared.StatusOffline {
-			return false
+//
+// Within a tier, nodes with a model declaring the task's language come first,
+// and nodes not busy with the model already loaded (see warm.go) before
+// those that would load it. With a minQuality, nodes without a model of
+// that tier for the task are left out.
+func (r *Registry) findBest(taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier, exclude map[string]bool) (*shared.NodeInfo, error) {
+	ranked := r.rankCandidates(taskType, modelHint, language, minQuality, exclude)
+	if len(ranked) == 0 {
+		if minQuality != "" {
+			return nil, fmt.Errorf("no node available for type=%q model=%q min_quality=%q (registered: %d)", taskType, modelHint, minQuality, r.count())
 		}
-		return true
+		return nil, fmt.Errorf("no node available for type=%q model=%q (registered: %d)", taskType, modelHint, r.count())
 	}
 
-	pickBetter := func(current, candidate *shared.NodeInfo) *shared.NodeInfo {
-		if current == nil || candidate.ActiveTasks < current.ActiveTasks {
-			return candidate
+	best := *ranked[0]
+	switch routeTier(&best, taskType, modelHint) {
+	case 1:
+		log.Printf("[Registry] Routing via tier1 (exact model: %s)", modelHint)
+	case 2:
+		log.Printf("[Registry] Routing via tier2 (task type: %s)", taskType)
+	default:
+		log.Printf("[Registry] Routing via tier3 (any node — no type specified)")
+	}
+	model := expectedModel(&best, taskType, modelHint, language, minQuality)
+	if minQuality != "" {
+		log.Printf("[Registry] Quality %s or larger: %s", minQuality, model)
+	}
+	if modelWarm(&best, model) {
+		log.Printf("[Registry] %s has %s loaded", best.NodeID, model)
+	}
+	if language != "" {
+		if shared.SpeaksLanguage(best.Capabilities, model, language) {
+			log.Printf("[Registry] Language %s: %s declares it", language, model)
+		} else {
+			log.Printf("[Registry] Language %s: no routable model declares it", language)
 		}
-		return current
 	}
+	return &best, nil
+}
 
-	var tier1, tier2, tier3 *shared.NodeInfo
+// RankCandidates returns copies of every routable node for a task, best
+// first. Used when routing hooks need to see the full candidate list.
+func (r *Registry) RankCandidates(taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier, exclude map[string]bool) []*shared.NodeInfo {
+	ranked := r.rankCandidates(taskType, modelHint, language, minQuality, exclude)
+	list := make([]*shared.NodeInfo, len(ranked))
+	for i, n := range ranked {
+		copy := *n
+		list[i] = &copy
+	}
+	return list
+}
 
-	for _, node := range r.nodes {
-		if !isCandidate(node) {
-			continue
+// rankCandidates filters out unroutable nodes, and nodes whose model for
+// the task is below minQuality, and sorts the rest by tier,
+// then nodes whose model for the task declares its language, then nodes
+// not busy for the task (free slots for its model, or below their busy
+// threshold), then nodes with that model loaded (see warm.go), then by
+// weighted score (see weights.go), then fewest active tasks. The returned nodes are shared snapshot copies and must not be
+// mutated.
+func (r *Registry) rankCandidates(taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier, exclude map[string]bool) []*shared.NodeInfo {
+	isCandidate := func(node *shared.NodeInfo) bool {
+		if exclude != nil && exclude[node.NodeID] {
+			return false
 		}
+		// Canary nodes only get tasks targeted at them (see canary.go)
+		return routable(node) && !node.Canary
+	}
 
-		// Tier 1: exact model name requested
-		if modelHint != "" && containsModel(node.Models, modelHint) {
-			tier1 = pickBetter(tier1, node)
-			continue
+	// Tiers are computed once per node rather than inside the comparator;
+	// with hundreds of nodes that dominates the cost of a routing decision
+	type ranked struct {
+		node  *shared.NodeInfo
+		model string
+		tier  int
+		lang  bool // its model declares the task's language
+		busy  bool
+		warm  bool    // its model is loaded
+		score float64 // weighted routing score, lower is better
+	}
+	var cands []ranked
+	maxLatency := 0.0
+	for _, s := range r.shards {
+		for _, node := range s.nodesSnapshot() {
+			if isCandidate(node) {
+				model := expectedModel(node, taskType, modelHint, language, minQuality)
+				if !shared.MeetsQuality(node.Capabilities, model, minQuality) {
+					continue
+				}
+				busy, _ := busyFor(node, model)
+				if exclusiveLocked(node, model) {
+					busy = true
+				}
+				cands = append(cands, ranked{
+					node:  node,
+					model: model,
+					tier:  routeTier(node, taskType, modelHint),
+					lang:  language != "" && shared.SpeaksLanguage(node.Capabilities, model, language),
+					busy:  busy,
+					warm:  modelWarm(node, model),
+				})
+				maxLatency = math.Max(maxLatency, node.AvgLatencyMs)
+			}
 		}
+	}
+	w := currentWeights()
+	for i := range cands {
+		cands[i].score = nodeScore(w, cands[i].node, cands[i].model, maxLatency)
+	}
 
-		// Tier 2: node has a model that handles this task type
-		if taskType != shared.TaskTypeAny && shared.CanHandle(node.Capabilities, taskType) {
-			tier2 = pickBetter(tier2, node)
-			continue
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		if a.tier != b.tier {
+			return a.tier < b.tier
+		}
+		if a.lang != b.lang {
+			return a.lang
+		}
+		if a.busy != b.busy {
+			return !a.busy
+		}
+		if a.warm != b.warm {
+			return a.warm
 		}
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		return a.node.ActiveTasks < b.node.ActiveTasks
+	})
+	// Round-robin rotates through the leading group of equally ranked nodes.
+	// Snapshot order follows map iteration, so the group is put in node ID
+	// order first or the rotation would be random
+	if currentStrategy() == shared.StrategyRoundRobin && len(cands) > 1 {
+		n := 1
+		for n < len(cands) && cands[n].tier == cands[0].tier && cands[n].lang == cands[0].lang && cands[n].busy == cands[0].busy && cands[n].warm == cands[0].warm {
+			n++
+		}
+		sort.Slice(cands[:n], func(i, j int) bool { return cands[i].node.NodeID < cands[j].node.NodeID })
+		off := int(roundRobin.Add(1) % uint64(n))
+		leaders := append(append([]ranked(nil), cands[off:n]...), cands[:off]...)
+		copy(cands, leaders)
+	}
 
-		// Tier 3: no type preference — any live node works
-		tier3 = pickBetter(tier3, node)
+	list := make([]*shared.NodeInfo, len(cands))
+	for i, c := range cands {
+		list[i] = c.node
 	}
+	return list
+}
 
-	// Return highest-priority tier that found a node
-	if tier1 != nil {
-		log.Printf("[Registry] Routing via tier1 (exact model: %s)", modelHint)
-		return tier1, nil
+// routable reports whether a node can take new tasks at all.
+func routable(node *shared.NodeInfo) bool {
+	if !isAlive(node) || node.Probing || node.Draining {
+		return false
 	}
-	if tier2 != nil {
-		log.Printf("[Registry] Routing via tier2 (task type: %s)", taskType)
-		return tier2, nil
+	switch node.Status {
+	case shared.StatusOverloaded, shared.StatusOffline, shared.StatusBackendDown:
+		return false
 	}
-	if tier3 != nil {
-		log.Printf("[Registry] Routing via tier3 (any node — no type specified)")
-		return tier3, nil
+	return true
+}
+
+// routeTier classifies a node for a task:
+//
+//	1: has the exact model requested via model_hint
+//	2: has a model that handles the task type
+//	3: any live node (fallback)
+func routeTier(node *shared.NodeInfo, taskType shared.TaskType, modelHint string) int {
+	if modelHint != "" && containsModel(node.Models, modelHint) {
+		return 1
 	}
+	if taskType != shared.TaskTypeAny && shared.CanHandle(node.Capabilities, taskType) {
+		return 2
+	}
+	return 3
+}
+
+// ─── Admin ────────────────────────────────────────────────────────────────────
 
-	return nil, fmt.Errorf("no node available for type=%q model=%q (registered: %d)", taskType, modelHint, len(r.nodes))
+// SetDraining drains (or undrains) a node: a draining node finishes its
+// in-flight tasks but is skipped by routing. The flag survives the node
+// re-registering. Returns false if the node isn't registered.
+func (r *Registry) SetDraining(nodeID string, draining bool) bool {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return false
+	}
+	node.Draining = draining
+	if draining {
+		s.drained[nodeID] = true
+	} else {
+		delete(s.drained, nodeID)
+	}
+	log.Printf("[Registry] Node %s draining=%v", nodeID, draining)
+	return true
+}
+
+// Remove evicts a node from the registry. A live agent will re-register on
+// its next heartbeat; drain it first to keep it out of routing.
+func (r *Registry) Remove(nodeID string) bool {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodes[nodeID]; !ok {
+		return false
+	}
+	delete(s.nodes, nodeID)
+	delete(s.profiles, nodeID)
+	delete(s.sessions, nodeID)
+	log.Printf("[Registry] Node %s evicted by operator", nodeID)
+	return true
+}
+
+// FlushProfiles discards every node's adaptive load profile (thresholds
+// fall back to the declared ones) and all routing snapshots. Returns the
+// number of profiles dropped.
+func (r *Registry) FlushProfiles() int {
+	dropped := 0
+	for _, s := range r.shards {
+		s.lock()
+		dropped += len(s.profiles)
+		s.profiles = make(map[string]*loadProfile)
+		for _, node := range s.nodes {
+			node.EffectiveBusyThreshold = s.effectiveBusyThreshold(node)
+		}
+		s.mu.Unlock()
+	}
+	log.Printf("[Registry] Flushed %d load profiles and routing snapshots", dropped)
+	return dropped
 }
 
 // MarkSuspect temporarily marks a node as overloaded after a task failure.
 // It will recover automatically on the next successful heartbeat.
 func (r *Registry) MarkSuspect(nodeID string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if node, ok := r.nodes[nodeID]; ok {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+	if node, ok := s.nodes[nodeID]; ok {
 		node.Status = shared.StatusOverloaded
+		recordOutcome(node, false, 0)
 		log.Printf("[Registry] Node %s marked suspect after failure", nodeID)
 	}
 }
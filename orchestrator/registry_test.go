@@ -0,0 +1,117 @@
+// orchestrator/registry_test.go
+// Benchmark of the routing hot path.
+//
+// Registers simulated nodes in a fresh registry, then measures routing on
+// its own and routing while every node heartbeats concurrently — the
+// classroom/lab scenario where hundreds of agents share one orchestrator.
+// No network or Ollama is involved:
+//
+//	go test ./orchestrator -run '^$' -bench Routing
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"echo-system/shared"
+)
+
+// benchTaskTypes are the models/types handed out round-robin to simulated nodes.
+var benchTaskTypes = []struct {
+	model string
+	types []shared.TaskType
+}{
+	{"mistral", []shared.TaskType{shared.TaskTypeText, shared.TaskTypeSummarize}},
+	{"codellama", []shared.TaskType{shared.TaskTypeCode}},
+	{"llava", []shared.TaskType{shared.TaskTypeVision}},
+	{"nomic-embed-text", []shared.TaskType{shared.TaskTypeEmbed}},
+}
+
+func BenchmarkRouting(b *testing.B) {
+	// Routing logs every decision; silence it for the duration
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, n := range []int{10, 100, 500, 1000} {
+		b.Run(fmt.Sprintf("nodes=%d/idle", n), func(b *testing.B) {
+			r, _, _ := benchRegistry(n)
+			benchRoute(b, r, nil)
+		})
+
+		// Every node heartbeats continuously alongside routing, with load
+		// changes invalidating snapshots as real dispatches would
+		b.Run(fmt.Sprintf("nodes=%d/heartbeats", n), func(b *testing.B) {
+			r, ids, tokens := benchRegistry(n)
+			benchRoute(b, r, func(i int) {
+				id := ids[i%n]
+				r.Heartbeat(shared.HeartbeatRequest{NodeID: id, Status: shared.StatusIdle, ActiveTasks: i % 3}, tokens[i%n])
+				r.IncrementLoad(id, "")
+				r.DecrementLoad(id, "")
+			})
+		})
+	}
+}
+
+// benchRegistry registers n simulated nodes in a fresh registry and returns
+// it with their IDs and tokens.
+func benchRegistry(n int) (r *Registry, ids, tokens []string) {
+	r = NewRegistry()
+	ids = make([]string, n)
+	tokens = make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("sim-%04d", i)
+		bt := benchTaskTypes[i%len(benchTaskTypes)]
+		tokens[i], _ = r.Register(shared.RegisterRequest{
+			NodeID:       ids[i],
+			AgentPort:    9000 + i,
+			OllamaPort:   11434,
+			Models:       []string{bt.model},
+			Capabilities: []shared.ModelCapability{{Name: bt.model, Types: bt.types}},
+		}, "", false)
+	}
+	return r, ids, tokens
+}
+
+// benchRoute routes tasks of each type in turn on parallel goroutines, with
+// bg (if set) called in a loop on another until they're done, and reports
+// bg's calls per second.
+func benchRoute(b *testing.B, r *Registry, bg func(i int)) {
+	var stop atomic.Bool
+	var bgOps atomic.Int64
+	started, done := make(chan struct{}), make(chan struct{})
+	if bg != nil {
+		go func() {
+			defer close(done)
+			close(started)
+			for i := 0; !stop.Load(); i++ {
+				bg(i)
+				bgOps.Add(1)
+			}
+		}()
+	} else {
+		close(started)
+		close(done)
+	}
+	<-started
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for i := int(next.Add(1)); pb.Next(); i++ {
+			bt := benchTaskTypes[i%len(benchTaskTypes)]
+			r.FindBestNode(bt.types[0], "")
+		}
+	})
+	b.StopTimer()
+	stop.Store(true)
+	<-done
+	if bg != nil {
+		b.ReportMetric(float64(bgOps.Load())/b.Elapsed().Seconds(), "heartbeats/s")
+	}
+}
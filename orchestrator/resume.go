@@ -0,0 +1,354 @@
+// orchestrator/resume.go
+// Resuming task streams after a dropped connection or a restart.
+//
+// Every event of a /task/stream carrying text has an SSE id: the number of
+// bytes of the answer the client has been sent by then (see sseWriter). A
+// client whose connection drops — or whose orchestrator crashed and came
+// back — reconnects with GET /task/stream/{id} and that id as
+// Last-Event-ID, and is sent the rest of the answer.
+//
+// For that the orchestrator records each streamed task under
+// <data-dir>/streams when it's forwarded to a node: which node and agent
+// address, and the stream's mode. Agents keep generating a stream their
+// orchestrator lost for -stream-grace and hold on to finished transcripts
+// a while (see node-agent/streams.go), so a stream still running is
+// reattached to; one that finished while the client was away is replayed
+// from the answer recorded when it finished. Streams served by the cloud
+// fallback aren't recorded, and ones running on pull-mode nodes can only
+// be fetched once they're done.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// streamDoneRetention is how long a finished stream can be resumed.
+	streamDoneRetention = 10 * time.Minute
+	// streamRunningRetention bounds how long a stream that never finished
+	// (its orchestrator and client both gave up) is kept.
+	streamRunningRetention = time.Hour
+)
+
+var streamLog *StreamLog
+
+// streamRecord is what resuming a stream needs. A joiner of a shared
+// stream (see dedup.go) gets a record pointing at its leader's.
+type streamRecord struct {
+	TaskID     string                   `json:"task_id"`
+	LeaderID   string                   `json:"leader_id,omitempty"`
+	NodeID     string                   `json:"node_id,omitempty"`
+	AgentHost  string                   `json:"agent_host,omitempty"`
+	AgentPort  int                      `json:"agent_port,omitempty"`
+	Pull       bool                     `json:"pull,omitempty"`
+	Model      string                   `json:"model,omitempty"`
+	Mode       shared.StreamMode        `json:"mode,omitempty"`
+	Unit       shared.StreamGranularity `json:"granularity,omitempty"`
+	SnapshotMs int                      `json:"snapshot_interval_ms,omitempty"`
+	Metadata   map[string]string        `json:"metadata,omitempty"`
+	StartedAt  int64                    `json:"started_at"`
+
+	// Set once the stream finished
+	Done       bool              `json:"done,omitempty"`
+	Content    string            `json:"content,omitempty"`
+	Final      *shared.TaskChunk `json:"final,omitempty"` // the done chunk, without text
+	FinishedAt int64             `json:"finished_at,omitempty"`
+}
+
+// StreamLog persists the records of recent streams, one file each.
+type StreamLog struct {
+	dir     string
+	mu      sync.Mutex
+	records map[string]*streamRecord
+}
+
+// NewStreamLog loads the stream records under <dataDir>/streams, dropping
+// expired ones.
+func NewStreamLog(dataDir string) *StreamLog {
+	l := &StreamLog{dir: filepath.Join(dataDir, "streams"), records: make(map[string]*streamRecord)}
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		log.Printf("[Streams] Cannot create %s (%v) — streams won't resume after a restart", l.dir, err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(l.dir, "*.json"))
+	for _, path := range paths {
+		var rec streamRecord
+		data, err := os.ReadFile(path)
+		if err != nil || json.Unmarshal(data, &rec) != nil || rec.TaskID == "" {
+			continue
+		}
+		l.records[rec.TaskID] = &rec
+	}
+	l.expire()
+	log.Printf("[Streams] Loaded %d resumable streams from %s", len(l.records), l.dir)
+	go l.expireLoop()
+	return l
+}
+
+// path names a record's file after a hash of its task ID, which is
+// client-supplied.
+func (l *StreamLog) path(taskID string) string {
+	sum := sha256.Sum256([]byte(taskID))
+	return filepath.Join(l.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// save writes rec to disk. Must be called with l.mu held.
+func (l *StreamLog) save(rec *streamRecord) {
+	data, _ := json.Marshal(rec)
+	path := l.path(rec.TaskID)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		log.Printf("[Streams] Failed to persist %s: %v", rec.TaskID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("[Streams] Failed to persist %s: %v", rec.TaskID, err)
+	}
+}
+
+// start records req being forwarded to node, replacing the record of an
+// earlier attempt.
+func (l *StreamLog) start(req shared.TaskRequest, node *shared.NodeInfo, model string) {
+	rec := &streamRecord{
+		TaskID:     req.TaskID,
+		NodeID:     node.NodeID,
+		AgentHost:  node.AgentHost,
+		AgentPort:  node.AgentPort,
+		Pull:       node.Pull,
+		Model:      model,
+		Mode:       req.StreamMode,
+		Unit:       req.StreamGranularity,
+		SnapshotMs: req.SnapshotIntervalMs,
+		Metadata:   req.Metadata,
+		StartedAt:  time.Now().UnixMilli(),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[rec.TaskID] = rec
+	l.save(rec)
+}
+
+// alias records that req joined leader's stream.
+func (l *StreamLog) alias(req shared.TaskRequest, leader string) {
+	rec := &streamRecord{TaskID: req.TaskID, LeaderID: leader, Metadata: req.Metadata, StartedAt: time.Now().UnixMilli()}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[rec.TaskID] = rec
+	l.save(rec)
+}
+
+// finish records a stream's whole answer and its done chunk.
+func (l *StreamLog) finish(taskID, content string, final shared.TaskChunk) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec, ok := l.records[taskID]
+	if !ok {
+		return
+	}
+	final.Token, final.Text = "", ""
+	done := *rec
+	done.Done, done.Content, done.Final, done.FinishedAt = true, content, &final, time.Now().UnixMilli()
+	l.records[taskID] = &done
+	l.save(&done)
+}
+
+// forget drops a stream's record, once it's no longer on any node.
+func (l *StreamLog) forget(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.records[taskID]; ok {
+		delete(l.records, taskID)
+		os.Remove(l.path(taskID))
+	}
+}
+
+// get returns the record of the stream that served taskID, and taskID's
+// own record if it joined that stream.
+func (l *StreamLog) get(taskID string) (rec, joined *streamRecord, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec, ok = l.records[taskID]
+	if ok && rec.LeaderID != "" {
+		joined = rec
+		rec, ok = l.records[rec.LeaderID]
+	}
+	if !ok {
+		return nil, nil, false
+	}
+	copied := *rec
+	return &copied, joined, true
+}
+
+func (l *StreamLog) expireLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		l.expire()
+		l.mu.Unlock()
+	}
+}
+
+// expire drops records past their retention. Must be called with l.mu
+// held (or before the log is shared).
+func (l *StreamLog) expire() {
+	now := time.Now()
+	for id, rec := range l.records {
+		keep := rec.StartedAt > now.Add(-streamRunningRetention).UnixMilli()
+		if rec.Done {
+			keep = rec.FinishedAt > now.Add(-streamDoneRetention).UnixMilli()
+		}
+		if !keep {
+			delete(l.records, id)
+			os.Remove(l.path(id))
+		}
+	}
+}
+
+// ─── Client: GET /task/stream/{id} ────────────────────────────────────────────
+
+// handleResumeStream sends the part of a stream after Last-Event-ID (the
+// header, or ?last_event_id= for clients that can't set it) as SSE events
+// like /task/stream's; with neither, the whole answer.
+func handleResumeStream(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	offset := 0
+	if lastID != "" {
+		n, err := strconv.Atoi(lastID)
+		if err != nil || n < 0 {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("invalid Last-Event-ID %q (want the id of the last event received)", lastID))
+			return
+		}
+		offset = n
+	}
+	rec, joined, ok := streamLog.get(taskID)
+	if !ok {
+		writeTaskProblem(w, r, http.StatusNotFound, taskID, "no resumable stream for this task")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	sse := &sseWriter{w: w, flusher: flusher, offset: offset}
+	defer sse.keepAlive()()
+
+	// Chunks are readdressed to the task asked about, as in runTaskStream
+	address := func(chunk shared.TaskChunk) shared.TaskChunk {
+		if joined != nil {
+			return rewriteForJoiner(chunk, shared.TaskRequest{TaskID: taskID, Metadata: joined.Metadata}, rec.TaskID).(shared.TaskChunk)
+		}
+		return chunk
+	}
+
+	if rec.Done {
+		log.Printf("[Streams] Replaying finished stream %s from byte %d", taskID, offset)
+		final := *rec.Final
+		if rec.Mode == shared.StreamModeFull {
+			final.Text = rec.Content
+		} else if offset < len(rec.Content) {
+			final.Token = rec.Content[offset:]
+		}
+		sse.send("", address(final))
+		return
+	}
+	if rec.Pull {
+		p := newProblem(r, http.StatusGone, fmt.Sprintf("task is still running on pull-mode node %s, which can't be reattached to — fetch it once it's done", rec.NodeID))
+		p.TaskID, p.Retryable = taskID, true
+		sendProblem(w, p)
+		return
+	}
+	log.Printf("[Streams] Reattaching stream %s on %s from byte %d", taskID, rec.NodeID, offset)
+	reattachStream(r.Context(), rec, offset, sse, address)
+}
+
+// reattachStream follows rec's generation on its agent, sending what comes
+// after offset to sse the way streamTask would have. If it finishes, the
+// record is completed for later resumes.
+func reattachStream(ctx context.Context, rec *streamRecord, offset int, sse *sseWriter, address func(shared.TaskChunk) shared.TaskChunk) {
+	agentURL := shared.BaseURL(rec.AgentHost, rec.AgentPort) + "/execute/stream/" + url.PathEscape(rec.TaskID)
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", agentURL, nil)
+	if err != nil {
+		sse.fail(rec.TaskID, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp, err := agentClient.Do(httpReq)
+	if err != nil {
+		sse.fail(rec.TaskID, http.StatusBadGateway, fmt.Sprintf("node %s unreachable: %v", rec.NodeID, err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		sse.fail(rec.TaskID, http.StatusGone, fmt.Sprintf("node %s no longer has this stream (it restarted, or gave up waiting for a reattach)", rec.NodeID))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		sse.fail(rec.TaskID, http.StatusBadGateway, fmt.Sprintf("node %s returned HTTP %d: %s", rec.NodeID, resp.StatusCode, strings.TrimSpace(string(msg))))
+		return
+	}
+
+	// The agent replays from the start, so full-mode snapshots are whole;
+	// in delta mode what the client already has is skipped
+	snapshotInterval := defaultSnapshotInterval
+	if rec.SnapshotMs > 0 {
+		snapshotInterval = time.Duration(rec.SnapshotMs) * time.Millisecond
+	}
+	var content strings.Builder
+	var lastSnapshot time.Time
+	batch := &tokenBatcher{granularity: rec.Unit}
+	err = readAgentStream(resp.Body, func(chunk shared.TaskChunk) {
+		token := chunk.Token
+		if skip := offset - content.Len(); skip > 0 && rec.Mode != shared.StreamModeFull {
+			token = token[min(skip, len(token)):]
+		}
+		content.WriteString(chunk.Token)
+		chunk.Token = batch.add(token)
+		if chunk.Done {
+			chunk.Token += batch.flush()
+		} else if chunk.Token == "" {
+			return
+		}
+		chunk.TaskID = rec.TaskID
+		chunk.RoutedTo = rec.NodeID
+		if chunk.Done {
+			chunk.Metadata = rec.Metadata
+			streamLog.finish(rec.TaskID, content.String(), chunk)
+		}
+		if rec.Mode == shared.StreamModeFull {
+			if !chunk.Done && time.Since(lastSnapshot) < snapshotInterval {
+				return
+			}
+			lastSnapshot = time.Now()
+			chunk.Token = ""
+			chunk.Text = content.String()[:content.Len()-batch.held()]
+		}
+		sse.send("", address(chunk))
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[Streams] Reattached stream %s on %s failed: %v", rec.TaskID, rec.NodeID, err)
+		chunk := shared.TaskChunk{TaskID: rec.TaskID, Done: true, RoutedTo: rec.NodeID, Error: err.Error()}
+		streamLog.finish(rec.TaskID, content.String(), chunk)
+		sse.send("", address(chunk))
+	}
+}
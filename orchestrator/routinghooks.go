@@ -0,0 +1,139 @@
+// orchestrator/routinghooks.go
+// Extension points for custom routing policies.
+//
+// After the built-in router ranks the candidate nodes for a task, every
+// registered RoutingHook is consulted in turn and may veto or reorder them.
+// This lets advanced users implement policies such as cost caps or
+// office-hours rules without forking the orchestrator, either by compiling a
+// hook in (call RegisterRoutingHook from an init function in a file added to
+// this package) or by pointing -routing-webhook at an HTTP service.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// routingWebhookTimeout bounds a webhook call so a slow policy service
+// can't stall routing.
+const routingWebhookTimeout = 2 * time.Second
+
+// RoutingHook may veto or reorder the candidates for a task. It returns the
+// nodes to keep, best first. Returning an error leaves the candidates as
+// they were (hooks fail open).
+type RoutingHook interface {
+	Name() string
+	Reorder(ctx context.Context, req shared.TaskRequest, candidates []*shared.NodeInfo) ([]*shared.NodeInfo, error)
+}
+
+// routingHooks are consulted in registration order.
+var routingHooks []RoutingHook
+
+// RegisterRoutingHook adds a hook to the routing chain. Call it during
+// startup (e.g. from init), before the server starts handling requests.
+func RegisterRoutingHook(h RoutingHook) {
+	routingHooks = append(routingHooks, h)
+	log.Printf("[Routing] Hook registered: %s", h.Name())
+}
+
+// selectNode picks the node for a task: the built-in ranking, filtered and
+// reordered by any registered hooks. Without hooks it's exactly the
+// registry's FindBestNodeExcluding. A task with a target_node goes there
+// or nowhere (see canary.go).
+func selectNode(ctx context.Context, req shared.TaskRequest, exclude map[string]bool) (*shared.NodeInfo, error) {
+	if req.TargetNode != "" {
+		return targetNode(req, exclude)
+	}
+	if len(routingHooks) == 0 {
+		return registry.FindBestNodeExcluding(req.Type, req.ModelHint, req.Language, req.MinQuality, exclude)
+	}
+
+	candidates := registry.RankCandidates(req.Type, req.ModelHint, req.Language, req.MinQuality, exclude)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no node available for type=%q model=%q", req.Type, req.ModelHint)
+	}
+
+	for _, h := range routingHooks {
+		reordered, err := h.Reorder(ctx, req, candidates)
+		if err != nil {
+			log.Printf("[Routing] Hook %s failed (%v) — ignoring it for task %s", h.Name(), err, req.TaskID)
+			continue
+		}
+		candidates = reordered
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("all candidate nodes vetoed by routing hook %s", h.Name())
+		}
+	}
+	return candidates[0], nil
+}
+
+// ─── Webhook hook ─────────────────────────────────────────────────────────────
+
+// webhookHook delegates routing decisions to an external HTTP service.
+// It POSTs a RoutingHookRequest and expects a RoutingHookResponse.
+type webhookHook struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookHook(url string) *webhookHook {
+	return &webhookHook{
+		url:    url,
+		client: &http.Client{Timeout: routingWebhookTimeout},
+	}
+}
+
+func (h *webhookHook) Name() string { return "webhook " + h.url }
+
+func (h *webhookHook) Reorder(ctx context.Context, req shared.TaskRequest, candidates []*shared.NodeInfo) ([]*shared.NodeInfo, error) {
+	payload := shared.RoutingHookRequest{Task: req, Candidates: make([]shared.NodeInfo, len(candidates))}
+	for i, c := range candidates {
+		payload.Candidates[i] = *c
+	}
+	body, _ := json.Marshal(payload)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("webhook unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+
+	var decision shared.RoutingHookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook response: %w", err)
+	}
+
+	byID := make(map[string]*shared.NodeInfo, len(candidates))
+	for _, c := range candidates {
+		byID[c.NodeID] = c
+	}
+	kept := make([]*shared.NodeInfo, 0, len(decision.Order))
+	for _, id := range decision.Order {
+		if node, ok := byID[id]; ok {
+			kept = append(kept, node)
+			delete(byID, id) // ignore duplicates
+		}
+	}
+	if len(kept) < len(candidates) || decision.Reason != "" {
+		log.Printf("[Routing] Webhook kept %d/%d candidates for task %s (%s)",
+			len(kept), len(candidates), req.TaskID, decision.Reason)
+	}
+	return kept, nil
+}
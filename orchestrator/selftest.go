@@ -0,0 +1,99 @@
+// orchestrator/selftest.go
+// POST /admin/nodes/{id}/selftest: run a node's self-test remotely.
+//
+// The agent's POST /selftest checks its backend, a 1-token generation per
+// model, its disk and its clock (see the agent's selftest.go). This relays
+// it, sending the orchestrator's clock and -max-clock-skew (unless 0) so
+// the agent's clock check compares against the mesh's, and answers with
+// the report, its start time in orchestrator time. A report with failed
+// checks is still 200: the test ran; ok says how it went.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"echo-system/shared"
+)
+
+// selfTestTimeout bounds a relayed self-test. Each model's generation may
+// take up to the agent's probe timeout when it loads from disk.
+const selfTestTimeout = 10 * time.Minute
+
+func handleNodeSelfTest(w http.ResponseWriter, r *http.Request) {
+	var req shared.SelfTestRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	node, err := registry.GetNode(r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if node.Pull {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("node %s is in pull mode and can't be reached", node.NodeID))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+	defer cancel()
+	if maxClockSkew > 0 {
+		req.ReferenceMs = time.Now().UnixMilli()
+		req.MaxSkewMs = maxClockSkew.Milliseconds()
+	}
+	report, err := forwardSelfTest(ctx, node, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, fmt.Sprintf("node %s: %v", node.NodeID, err))
+		return
+	}
+	report.StartedAt = toOrchestratorTime(report.StartedAt, node.ClockSkewMs)
+	failed := 0
+	for _, c := range report.Checks {
+		if c.Status == shared.SelfTestFail {
+			failed++
+		}
+	}
+	log.Printf("[Admin] Self-test of %s: %d checks, %d failed (%dms)", node.NodeID, len(report.Checks), failed, report.DurationMs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// forwardSelfTest runs the agent's self-test and waits for its report.
+func forwardSelfTest(ctx context.Context, node *shared.NodeInfo, req shared.SelfTestRequest) (*shared.SelfTestReport, error) {
+	body, _ := json.Marshal(req)
+	url := shared.BaseURL(node.AgentHost, node.AgentPort) + "/selftest"
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("agent %s has no self-test; upgrade it", node.Version)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned HTTP %d", resp.StatusCode)
+	}
+
+	var report shared.SelfTestReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode self-test report: %w", err)
+	}
+	return &report, nil
+}
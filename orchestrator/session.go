@@ -0,0 +1,79 @@
+// orchestrator/session.go
+// Per-node session tokens.
+//
+// Registration stays open, but it hands the agent a random session token
+// that every later call speaking for the node must carry as
+// "Authorization: Bearer <token>": heartbeats, GET /work, result
+// submissions and bundle claims. Without it anyone on the LAN could post a
+// heartbeat for someone else's node, marking it offline or lying about its
+// load, or collect its pulled tasks. A node that is still alive can only be
+// re-registered with its current token; once it has gone offline any agent
+// may claim the ID again, so a restarted agent gets back in after at most
+// the 15s heartbeat timeout.
+//
+// The token also works the other way: agents only run exec steps, pull
+// models and give up orphaned steps for a caller presenting it, so only
+// this orchestrator can (see withSession).
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	errUnknownNode     = errors.New("unknown node, please re-register")
+	errBadSession      = errors.New("missing or wrong session token")
+	errSessionConflict = errors.New("node is registered and alive; re-register with its session token or wait until it goes offline")
+)
+
+// newSessionToken returns a random 128-bit token.
+func newSessionToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sameToken compares tokens in constant time; an empty token never matches.
+func sameToken(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// bearerToken returns the request's "Authorization: Bearer" token.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// sessionStatus maps a session check error to its HTTP status: 404 tells
+// the agent to register again, 401 that the token is wrong.
+func sessionStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownNode):
+		return http.StatusNotFound
+	case errors.Is(err, errSessionConflict):
+		return http.StatusConflict
+	}
+	return http.StatusUnauthorized
+}
+
+// withSession adds nodeID's session token to a request to its agent.
+func withSession(req *http.Request, nodeID string) {
+	if token := registry.Session(nodeID); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// requireSession checks that r carries nodeID's session token, answering
+// the request itself when it doesn't.
+func requireSession(w http.ResponseWriter, r *http.Request, nodeID string) bool {
+	if err := registry.CheckSession(nodeID, bearerToken(r)); err != nil {
+		writeProblem(w, r, sessionStatus(err), err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,400 @@
+// orchestrator/share.go
+// Share links: one result, readable by whoever has the link.
+//
+// POST /tasks/{id}/share and POST /pipelines/runs/{id}/share answer with a
+// link, GET /share/{token}, that serves that task's result or that run and
+// nothing else until it expires ("expires_in", 24h by default, 30 days at
+// most). The token names the result and its expiry and is signed with a
+// key kept in <data-dir>/share.key, so links need no table of their own
+// and can't be altered to reach another result; DELETE /admin/shares
+// replaces the key, revoking every link handed out.
+//
+// The orchestrator doesn't keep every task's result, so sharing a task
+// copies its result to <data-dir>/shares/ for as long as its longest link
+// lasts. A task can be shared while its result is still known: one of the
+// last shareRecentResults finished tasks, a stream that can still be
+// resumed, a pipeline step or a deferred task. A pipeline link serves the
+// run from history, as it is when the link is opened. Shared copies leave
+// out request metadata, lineage and failed attempts, which describe the
+// requester and the mesh rather than the answer.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+
+	// shareRecentResults is how many finished task results are kept in
+	// memory to be shared.
+	shareRecentResults = 1000
+)
+
+var shares *ShareStore
+
+var (
+	errShareInvalid = errors.New("share link not found")
+	errShareExpired = errors.New("share link expired")
+)
+
+// sharePayload is what a token is a signature of.
+type sharePayload struct {
+	Kind      string `json:"k"` // "task" or "pipeline"
+	ID        string `json:"id"`
+	ExpiresAt int64  `json:"exp"` // unix millis
+}
+
+// shareSnapshot is a shared task result on disk.
+type shareSnapshot struct {
+	ExpiresAt int64              `json:"expires_at"`
+	Result    *shared.TaskResult `json:"result"`
+}
+
+// ShareStore signs share links and keeps the task results they serve.
+type ShareStore struct {
+	dir     string
+	keyPath string
+	mu      sync.Mutex
+	key     []byte
+	recent  map[string]*shared.TaskResult
+	order   []string // recent's task IDs, oldest first
+}
+
+// NewShareStore loads the signing key under dataDir, creating one if there
+// is none, and drops expired snapshots.
+func NewShareStore(dataDir string) *ShareStore {
+	s := &ShareStore{
+		dir:     filepath.Join(dataDir, "shares"),
+		keyPath: filepath.Join(dataDir, "share.key"),
+		recent:  make(map[string]*shared.TaskResult),
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		log.Printf("[Share] Cannot create %s (%v) — task results can't be shared", s.dir, err)
+	}
+	if key, err := os.ReadFile(s.keyPath); err == nil && len(key) == 32 {
+		s.key = key
+	} else {
+		s.rotateKey()
+	}
+	s.expire()
+	go func() {
+		for range time.Tick(time.Hour) {
+			s.expire()
+		}
+	}()
+	return s
+}
+
+// rotateKey replaces the signing key. Must be called with s.mu held (or
+// before s is shared).
+func (s *ShareStore) rotateKey() {
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.key = key
+	if err := os.WriteFile(s.keyPath, key, 0o600); err != nil {
+		log.Printf("[Share] Cannot save %s (%v) — links stop working on restart", s.keyPath, err)
+	}
+}
+
+// remember keeps a finished task's result for sharing.
+func (s *ShareStore) remember(result *shared.TaskResult) {
+	if s == nil || result.TaskID == "" {
+		return
+	}
+	copied := *result
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recent[result.TaskID]; !ok {
+		s.order = append(s.order, result.TaskID)
+	}
+	s.recent[result.TaskID] = &copied
+	for len(s.order) > shareRecentResults {
+		delete(s.recent, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// ShareTask signs a link to a task's result, copying the result so it
+// outlives the places it's known from.
+func (s *ShareStore) ShareTask(taskID string, ttl time.Duration) (shared.ShareLink, bool, error) {
+	result, ok := s.findTask(taskID)
+	if !ok {
+		return shared.ShareLink{}, false, nil
+	}
+	linkExpiry := time.Now().Add(ttl).UnixMilli()
+	keepUntil := linkExpiry
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snap, ok := s.readSnapshot(taskID); ok && snap.ExpiresAt > keepUntil {
+		keepUntil = snap.ExpiresAt
+	}
+	data, _ := json.Marshal(shareSnapshot{ExpiresAt: keepUntil, Result: downscopeResult(result)})
+	if err := os.WriteFile(s.snapshotPath(taskID), data, 0o644); err != nil {
+		return shared.ShareLink{}, true, err
+	}
+	link := s.sign(sharePayload{Kind: "task", ID: taskID, ExpiresAt: linkExpiry})
+	link.TaskID = taskID
+	return link, true, nil
+}
+
+// SharePipeline signs a link to a pipeline run.
+func (s *ShareStore) SharePipeline(pipelineID string, ttl time.Duration) shared.ShareLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link := s.sign(sharePayload{Kind: "pipeline", ID: pipelineID, ExpiresAt: time.Now().Add(ttl).UnixMilli()})
+	link.PipelineID = pipelineID
+	return link
+}
+
+// sign makes the link for p. Must be called with s.mu held.
+func (s *ShareStore) sign(p sharePayload) shared.ShareLink {
+	payload, _ := json.Marshal(p)
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return shared.ShareLink{Token: token, URL: externalPath("/share/" + token), ExpiresAt: p.ExpiresAt}
+}
+
+// Open checks a token and returns what it shares.
+func (s *ShareStore) Open(token string) (*shared.SharedResult, error) {
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	payload, err1 := base64.RawURLEncoding.DecodeString(encPayload)
+	sum, err2 := base64.RawURLEncoding.DecodeString(encMAC)
+	if !ok || err1 != nil || err2 != nil {
+		return nil, errShareInvalid
+	}
+	s.mu.Lock()
+	mac := hmac.New(sha256.New, s.key)
+	s.mu.Unlock()
+	mac.Write(payload)
+	var p sharePayload
+	if !hmac.Equal(sum, mac.Sum(nil)) || json.Unmarshal(payload, &p) != nil {
+		return nil, errShareInvalid
+	}
+	if time.Now().UnixMilli() >= p.ExpiresAt {
+		return nil, errShareExpired
+	}
+
+	out := &shared.SharedResult{ExpiresAt: p.ExpiresAt}
+	switch p.Kind {
+	case "task":
+		s.mu.Lock()
+		snap, ok := s.readSnapshot(p.ID)
+		s.mu.Unlock()
+		if !ok {
+			return nil, errShareInvalid
+		}
+		out.TaskID, out.Result = p.ID, snap.Result
+	case "pipeline":
+		run, ok := history.GetRun(p.ID)
+		if !ok {
+			return nil, errShareInvalid
+		}
+		run.Definition.Metadata, run.Definition.Source = nil, nil
+		out.PipelineID, out.Run = p.ID, run
+	default:
+		return nil, errShareInvalid
+	}
+	return out, nil
+}
+
+// Revoke replaces the signing key, so every link handed out stops working,
+// and drops the shared task results.
+func (s *ShareStore) Revoke() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotateKey()
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	for _, path := range paths {
+		os.Remove(path)
+	}
+	return len(paths)
+}
+
+// findTask looks for a task's result wherever it's still known.
+func (s *ShareStore) findTask(taskID string) (*shared.TaskResult, bool) {
+	s.mu.Lock()
+	result, ok := s.recent[taskID]
+	s.mu.Unlock()
+	if ok {
+		return result, true
+	}
+	if rec, _, ok := streamLog.get(taskID); ok && rec.Done && rec.Final != nil {
+		return &shared.TaskResult{
+			TaskID:    taskID,
+			Content:   rec.Content,
+			RoutedTo:  rec.Final.RoutedTo,
+			ModelUsed: rec.Model,
+			LatencyMs: rec.Final.LatencyMs,
+			Success:   rec.Final.Error == "",
+			Error:     rec.Final.Error,
+			Timings:   rec.Final.Timings,
+		}, true
+	}
+	if found, ok := history.FindTask(taskID); ok {
+		step := found.Step
+		result := &shared.TaskResult{TaskID: taskID, Content: step.Content, RoutedTo: step.RoutedTo, ModelUsed: step.ModelUsed,
+			TaskType: step.Type, LatencyMs: step.LatencyMs, Success: step.Success, Error: step.Error}
+		if item := found.Lineage.Item; item > 0 && item <= len(step.Items) {
+			it := step.Items[item-1]
+			result.Content, result.RoutedTo, result.ModelUsed = it.Content, it.RoutedTo, it.ModelUsed
+			result.LatencyMs, result.Success, result.Error = it.LatencyMs, it.Success, it.Error
+		}
+		return result, true
+	}
+	if deferred, ok := bundles.Get(taskID); ok && deferred.Result != nil {
+		return deferred.Result, true
+	}
+	return nil, false
+}
+
+// downscopeResult is the copy of a result a link serves.
+func downscopeResult(result *shared.TaskResult) *shared.TaskResult {
+	copied := *result
+	copied.Metadata, copied.Lineage, copied.Attempts, copied.Source = nil, nil, nil, nil
+	return &copied
+}
+
+// snapshotPath names a shared result's file after a hash of its task ID,
+// which is client-supplied.
+func (s *ShareStore) snapshotPath(taskID string) string {
+	sum := sha256.Sum256([]byte(taskID))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// readSnapshot reads a shared result that hasn't expired. Must be called
+// with s.mu held.
+func (s *ShareStore) readSnapshot(taskID string) (*shareSnapshot, bool) {
+	data, err := os.ReadFile(s.snapshotPath(taskID))
+	if err != nil {
+		return nil, false
+	}
+	var snap shareSnapshot
+	if json.Unmarshal(data, &snap) != nil || snap.Result == nil || time.Now().UnixMilli() >= snap.ExpiresAt {
+		return nil, false
+	}
+	return &snap, true
+}
+
+// expire removes the shared results whose links have all expired.
+func (s *ShareStore) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	now := time.Now().UnixMilli()
+	for _, path := range paths {
+		var snap shareSnapshot
+		data, err := os.ReadFile(path)
+		if err != nil || json.Unmarshal(data, &snap) != nil || now >= snap.ExpiresAt {
+			os.Remove(path)
+		}
+	}
+}
+
+// shareTTL reads the optional body of a share request.
+func shareTTL(r *http.Request) (time.Duration, error) {
+	var req shared.ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("invalid request body")
+	}
+	if req.ExpiresIn == "" {
+		return defaultShareTTL, nil
+	}
+	ttl, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("expires_in: want a positive duration such as 72h, got %q", req.ExpiresIn)
+	}
+	if ttl > maxShareTTL {
+		return 0, fmt.Errorf("expires_in: at most %s", maxShareTTL)
+	}
+	return ttl, nil
+}
+
+// ─── Client: POST /tasks/{id}/share, POST /pipelines/runs/{id}/share ──────────
+
+func handleShareTask(w http.ResponseWriter, r *http.Request) {
+	ttl, err := shareTTL(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	taskID := r.PathValue("id")
+	link, found, err := shares.ShareTask(taskID, ttl)
+	switch {
+	case !found:
+		writeTaskProblem(w, r, http.StatusNotFound, taskID, "task result not found (it may be too old to share)")
+		return
+	case err != nil:
+		writeTaskProblem(w, r, http.StatusInternalServerError, taskID, fmt.Sprintf("saving the shared result: %v", err))
+		return
+	}
+	log.Printf("[Share] Task %s shared until %s", taskID, time.UnixMilli(link.ExpiresAt).Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+func handleSharePipeline(w http.ResponseWriter, r *http.Request) {
+	ttl, err := shareTTL(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pipelineID := r.PathValue("id")
+	if _, ok := history.GetRun(pipelineID); !ok {
+		writeProblem(w, r, http.StatusNotFound, "pipeline run not found")
+		return
+	}
+	link := shares.SharePipeline(pipelineID, ttl)
+	log.Printf("[Share] Pipeline run %s shared until %s", pipelineID, time.UnixMilli(link.ExpiresAt).Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// ─── Public: GET /share/{token} ───────────────────────────────────────────────
+
+func handleGetShare(w http.ResponseWriter, r *http.Request) {
+	result, err := shares.Open(r.PathValue("token"))
+	switch {
+	case errors.Is(err, errShareExpired):
+		writeProblem(w, r, http.StatusGone, err.Error())
+		return
+	case err != nil:
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ─── Admin: DELETE /admin/shares ──────────────────────────────────────────────
+
+func handleRevokeShares(w http.ResponseWriter, r *http.Request) {
+	removed := shares.Revoke()
+	log.Printf("[Admin] Share links revoked (%d shared task results removed)", removed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clearedResponse{Cleared: removed})
+}
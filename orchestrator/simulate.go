@@ -0,0 +1,568 @@
+// orchestrator/simulate.go
+// Simulation mode: routing strategies compared on a virtual mesh
+// (-simulate mesh.json).
+//
+// Trying a new scheduler shouldn't take eight physical machines. The
+// simulation registers the nodes described in a JSON file with a fresh
+// registry, then replays a workload against it once per routing strategy,
+// in virtual time: every task is routed by the real ranking code, waits
+// for one of its node's generation slots and takes as long as the node's
+// speed says, give or take its jitter. A node fails a share of its tasks,
+// which fail over as they would live; nodes heartbeat every 5 virtual
+// seconds, so a node marked suspect recovers. The comparison of latency
+// and throughput is printed and the orchestrator exits; nothing touches
+// the network.
+//
+//	{"seed": 1,
+//	 "nodes": [
+//	   {"node_id": "gpu", "capabilities": [{"name": "mistral", "types": ["text", "code"]}],
+//	    "tokens_per_sec": 60, "parallel": 4},
+//	   {"node_id": "pi", "count": 3, "capabilities": [{"name": "mistral", "types": ["text"]}],
+//	    "tokens_per_sec": 4, "failure_rate": 0.05, "jitter": 0.3}
+//	 ],
+//	 "workload": {"tasks": 500, "rate_per_sec": 2, "types": {"text": 3, "code": 1}},
+//	 "strategies": [{"name": "least-loaded"}, {"name": "latency", "weights": {"load": 1, "latency": 1}}]}
+//
+// The workload is made up from "workload" (Poisson arrivals, token counts
+// spread around the given means) unless -simulate-workload names a
+// recorded one (see workload.go). Without "strategies" the built-in
+// strategies and two weightings are compared.
+
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// simHeartbeatMs is how often, in virtual time, simulated nodes
+	// heartbeat.
+	simHeartbeatMs = 5000
+
+	defaultSimTasks            = 500
+	defaultSimRate             = 1.0
+	defaultSimPromptTokens     = 300
+	defaultSimCompletionTokens = 200
+)
+
+// ─── Configuration ────────────────────────────────────────────────────────────
+
+// simNodeConfig describes a node of the virtual mesh.
+type simNodeConfig struct {
+	NodeID       string                   `json:"node_id"`
+	Count        int                      `json:"count,omitempty"` // this many alike, as node_id-1, -2, ...
+	Capabilities []shared.ModelCapability `json:"capabilities"`
+	Local        bool                     `json:"local,omitempty"` // on the orchestrator's host, for the locality weight
+
+	TokensPerSec       float64 `json:"tokens_per_sec"`                  // generation speed
+	PromptTokensPerSec float64 `json:"prompt_tokens_per_sec,omitempty"` // prompt processing (default 10× tokens_per_sec)
+	OverheadMs         int64   `json:"overhead_ms,omitempty"`           // per task: the network, loading
+	Parallel           int     `json:"parallel,omitempty"`              // generations at once (default 1); more wait for a slot
+	BusyThreshold      int     `json:"busy_threshold,omitempty"`        // as the agent declares it
+	FailureRate        float64 `json:"failure_rate,omitempty"`          // share of tasks that fail, 0..1
+	Jitter             float64 `json:"jitter,omitempty"`                // service times vary by up to ± this share
+}
+
+// simWorkloadConfig describes a made-up workload.
+type simWorkloadConfig struct {
+	Tasks            int                         `json:"tasks,omitempty"`             // default 500
+	RatePerSec       float64                     `json:"rate_per_sec,omitempty"`      // mean arrivals per second (default 1)
+	Types            map[shared.TaskType]float64 `json:"types,omitempty"`             // relative share of each type (default all text)
+	PromptTokens     int                         `json:"prompt_tokens,omitempty"`     // mean per task (default 300)
+	CompletionTokens int                         `json:"completion_tokens,omitempty"` // mean per task (default 200)
+}
+
+// simStrategy is a routing configuration to compare.
+type simStrategy struct {
+	Name     string                 `json:"name"`
+	Strategy shared.RoutingStrategy `json:"strategy,omitempty"` // default least-loaded, or the name if it's a strategy
+	Weights  *shared.RoutingWeights `json:"weights,omitempty"`  // default load only
+}
+
+// simConfig is the -simulate file.
+type simConfig struct {
+	Seed       int64             `json:"seed,omitempty"`
+	Nodes      []simNodeConfig   `json:"nodes"`
+	Workload   simWorkloadConfig `json:"workload"`
+	Strategies []simStrategy     `json:"strategies,omitempty"`
+}
+
+// defaultSimStrategies are compared when the file names none.
+var defaultSimStrategies = []simStrategy{
+	{Name: string(shared.StrategyLeastLoaded)},
+	{Name: string(shared.StrategyRoundRobin)},
+	{Name: "latency-weighted", Weights: &shared.RoutingWeights{Load: 1, Latency: 1}},
+	{Name: "reputation-weighted", Weights: &shared.RoutingWeights{Load: 1, Reputation: 1}},
+}
+
+// loadSimConfig reads and checks a -simulate file.
+func loadSimConfig(path string) (*simConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading simulation: %w", err)
+	}
+	var cfg simConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing simulation %s: %w", path, err)
+	}
+	if len(cfg.Nodes) == 0 {
+		return nil, fmt.Errorf("simulation %s has no nodes", path)
+	}
+	seen := make(map[string]bool)
+	for i, n := range cfg.Nodes {
+		switch {
+		case n.NodeID == "":
+			return nil, fmt.Errorf("simulation %s: node %d has no node_id", path, i+1)
+		case seen[n.NodeID]:
+			return nil, fmt.Errorf("simulation %s: node %s is listed twice", path, n.NodeID)
+		case len(n.Capabilities) == 0:
+			return nil, fmt.Errorf("simulation %s: node %s has no capabilities", path, n.NodeID)
+		case n.TokensPerSec <= 0:
+			return nil, fmt.Errorf("simulation %s: node %s needs tokens_per_sec", path, n.NodeID)
+		case n.FailureRate < 0 || n.FailureRate > 1:
+			return nil, fmt.Errorf("simulation %s: node %s's failure_rate must be between 0 and 1", path, n.NodeID)
+		case n.Jitter < 0 || n.Jitter >= 1:
+			return nil, fmt.Errorf("simulation %s: node %s's jitter must be at least 0 and below 1", path, n.NodeID)
+		case n.Count < 0 || n.Parallel < 0 || n.OverheadMs < 0 || n.PromptTokensPerSec < 0:
+			return nil, fmt.Errorf("simulation %s: node %s has a negative setting", path, n.NodeID)
+		}
+		seen[n.NodeID] = true
+	}
+	if len(cfg.Strategies) == 0 {
+		cfg.Strategies = append([]simStrategy(nil), defaultSimStrategies...)
+	}
+	for i, s := range cfg.Strategies {
+		if s.Name == "" {
+			return nil, fmt.Errorf("simulation %s: strategy %d has no name", path, i+1)
+		}
+		if s.Strategy == "" {
+			switch shared.RoutingStrategy(s.Name) {
+			case shared.StrategyLeastLoaded, shared.StrategyRoundRobin:
+				cfg.Strategies[i].Strategy = shared.RoutingStrategy(s.Name)
+			default:
+				cfg.Strategies[i].Strategy = shared.StrategyLeastLoaded
+			}
+		}
+		if st := cfg.Strategies[i].Strategy; st != shared.StrategyLeastLoaded && st != shared.StrategyRoundRobin {
+			return nil, fmt.Errorf("simulation %s: strategy %s: unknown routing strategy %q", path, s.Name, st)
+		}
+		if s.Weights != nil {
+			if err := validateWeights(*s.Weights); err != nil {
+				return nil, fmt.Errorf("simulation %s: strategy %s: %w", path, s.Name, err)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+// syntheticWorkload makes up the workload cfg describes.
+func syntheticWorkload(cfg simWorkloadConfig, rng *rand.Rand) []workloadTask {
+	n, rate := cfg.Tasks, cfg.RatePerSec
+	if n <= 0 {
+		n = defaultSimTasks
+	}
+	if rate <= 0 {
+		rate = defaultSimRate
+	}
+	prompt, completion := cfg.PromptTokens, cfg.CompletionTokens
+	if prompt <= 0 {
+		prompt = defaultSimPromptTokens
+	}
+	if completion <= 0 {
+		completion = defaultSimCompletionTokens
+	}
+	types := make([]shared.TaskType, 0, len(cfg.Types))
+	var total float64
+	for t, share := range cfg.Types {
+		if share > 0 {
+			types = append(types, t)
+			total += share
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] }) // same seed, same workload
+	pick := func() shared.TaskType {
+		x := rng.Float64() * total
+		for _, t := range types {
+			if x -= cfg.Types[t]; x < 0 {
+				return t
+			}
+		}
+		return shared.TaskTypeText
+	}
+	// Token counts spread evenly between half and one and a half the mean
+	spread := func(mean int) int { return int(float64(mean) * (0.5 + rng.Float64())) }
+
+	tasks := make([]workloadTask, n)
+	var at float64
+	for i := range tasks {
+		at += rng.ExpFloat64() / rate * 1000
+		tasks[i] = workloadTask{
+			AtMs:             int64(at),
+			Type:             pick(),
+			PromptTokens:     spread(prompt),
+			CompletionTokens: spread(completion),
+		}
+	}
+	return tasks
+}
+
+// ─── Run ──────────────────────────────────────────────────────────────────────
+
+// runSimulation replays the workload against the mesh in cfgPath under
+// each strategy, prints the comparison and returns the exit code.
+func runSimulation(cfgPath, workloadPath string) int {
+	cfg, err := loadSimConfig(cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = 1
+	}
+	var tasks []workloadTask
+	source := fmt.Sprintf("made up, seed %d", seed)
+	if workloadPath != "" {
+		if tasks, err = loadWorkload(workloadPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		source = "recorded in " + workloadPath
+	} else {
+		tasks = syntheticWorkload(cfg.Workload, rand.New(rand.NewSource(seed)))
+	}
+
+	// Routing logs every decision; silence it for the duration
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	// The strategies are tried through the live routing settings
+	prevStrategy, prevWeights := currentStrategy(), currentWeights()
+	defer func() {
+		strategy.Store(prevStrategy)
+		weights.Store(&prevWeights)
+	}()
+
+	nodes := 0
+	for _, n := range cfg.Nodes {
+		nodes += max(n.Count, 1)
+	}
+	span := time.Duration(tasks[len(tasks)-1].AtMs-tasks[0].AtMs) * time.Millisecond
+	fmt.Printf("Simulating %d nodes, %d tasks over %s (%s), %d strategies\n\n",
+		nodes, len(tasks), span.Round(time.Second), source, len(cfg.Strategies))
+	fmt.Printf("  %-22s %6s %6s %9s %9s %9s %9s %9s %10s\n",
+		"strategy", "done", "failed", "failovers", "p50", "p95", "p99", "avg wait", "tasks/min")
+
+	var spreads []string
+	for _, s := range cfg.Strategies {
+		strategy.Store(s.Strategy)
+		w := defaultWeights
+		if s.Weights != nil {
+			w = *s.Weights
+		}
+		weights.Store(&w)
+
+		sim := newMeshSim(cfg.Nodes, rand.New(rand.NewSource(seed)))
+		res := sim.run(tasks)
+		fmt.Printf("  %-22s %6d %6d %9d %9s %9s %9s %9s %10.1f\n", s.Name,
+			res.done, res.failed, res.failovers,
+			simDuration(res.percentile(50)), simDuration(res.percentile(95)), simDuration(res.percentile(99)),
+			simDuration(res.avgWaitMs), res.perMinute())
+		spreads = append(spreads, fmt.Sprintf("  %-22s %s", s.Name, sim.spread()))
+	}
+	fmt.Printf("\nTasks per node:\n%s\n", strings.Join(spreads, "\n"))
+	return 0
+}
+
+// simDuration formats virtual milliseconds.
+func simDuration(ms float64) string {
+	d := time.Duration(ms * float64(time.Millisecond))
+	if d >= 10*time.Second {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// ─── Virtual mesh ─────────────────────────────────────────────────────────────
+
+// simNode is a node of the virtual mesh.
+type simNode struct {
+	cfg     simNodeConfig
+	id      string
+	token   string
+	running int
+	waiting []*simTask // for a generation slot
+	done    int
+	failed  int
+}
+
+// simTask is a task travelling through the virtual mesh.
+type simTask struct {
+	w           workloadTask
+	arrivedMs   int64
+	tried       map[string]bool
+	node        *simNode
+	model       string
+	concurrency int   // the node's load when it was dispatched there
+	queuedMs    int64 // when it reached its node
+	startedMs   int64 // when it got a slot
+	fails       bool
+}
+
+type simEventKind int
+
+const (
+	simArrive simEventKind = iota
+	simFinish
+	simHeartbeat
+)
+
+type simEvent struct {
+	atMs int64
+	seq  int // keeps events at the same time in order
+	kind simEventKind
+	task *simTask
+	node *simNode
+}
+
+type simEvents []*simEvent
+
+func (q simEvents) Len() int { return len(q) }
+func (q simEvents) Less(i, j int) bool {
+	if q[i].atMs != q[j].atMs {
+		return q[i].atMs < q[j].atMs
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simEvents) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *simEvents) Push(x any)   { *q = append(*q, x.(*simEvent)) }
+func (q *simEvents) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// meshSim is one run of the workload against a fresh registry.
+type meshSim struct {
+	reg    *Registry
+	rng    *rand.Rand
+	nodes  []*simNode
+	byID   map[string]*simNode
+	events simEvents
+	seq    int
+	nowMs  int64
+
+	latencies  []float64 // arrival to finish, per finished task
+	waitSumMs  float64   // time spent waiting for a slot, summed
+	failovers  int
+	failed     int
+	lastDoneMs int64
+}
+
+// simResult is what a run measured.
+type simResult struct {
+	done, failed, failovers int
+	latencies               []float64 // sorted
+	avgWaitMs               float64
+	spanMs                  int64
+}
+
+func newMeshSim(cfgs []simNodeConfig, rng *rand.Rand) *meshSim {
+	m := &meshSim{reg: NewRegistry(), rng: rng, byID: make(map[string]*simNode)}
+	port := 9000
+	for _, c := range cfgs {
+		count := max(c.Count, 1)
+		for i := 1; i <= count; i++ {
+			id := c.NodeID
+			if c.Count > 1 {
+				id = fmt.Sprintf("%s-%d", c.NodeID, i)
+			}
+			host := "192.0.2.1" // TEST-NET: not this host
+			if c.Local {
+				host = "localhost"
+			}
+			models := make([]string, len(c.Capabilities))
+			for j, capability := range c.Capabilities {
+				models[j] = capability.Name
+			}
+			port++
+			token, _ := m.reg.Register(shared.RegisterRequest{
+				NodeID:        id,
+				AgentHost:     host,
+				AgentPort:     port,
+				OllamaPort:    11434,
+				Models:        models,
+				Capabilities:  c.Capabilities,
+				BusyThreshold: c.BusyThreshold,
+			}, "", false)
+			n := &simNode{cfg: c, id: id, token: token}
+			m.nodes = append(m.nodes, n)
+			m.byID[id] = n
+		}
+	}
+	return m
+}
+
+func (m *meshSim) schedule(atMs int64, kind simEventKind, task *simTask, node *simNode) {
+	m.seq++
+	heap.Push(&m.events, &simEvent{atMs: atMs, seq: m.seq, kind: kind, task: task, node: node})
+}
+
+// run replays tasks, shifted to start at 0, until every one has finished
+// or failed.
+func (m *meshSim) run(tasks []workloadTask) simResult {
+	start := tasks[0].AtMs
+	for _, t := range tasks {
+		m.schedule(t.AtMs-start, simArrive, &simTask{w: t, arrivedMs: t.AtMs - start, tried: make(map[string]bool)}, nil)
+	}
+	for _, n := range m.nodes {
+		m.schedule(simHeartbeatMs, simHeartbeat, nil, n)
+	}
+
+	pending := len(tasks)
+	for pending > 0 && m.events.Len() > 0 {
+		ev := heap.Pop(&m.events).(*simEvent)
+		m.nowMs = ev.atMs
+		switch ev.kind {
+		case simArrive:
+			if !m.dispatch(ev.task) {
+				pending--
+			}
+		case simFinish:
+			if m.finish(ev.task) {
+				pending--
+			}
+		case simHeartbeat:
+			n := ev.node
+			m.reg.Heartbeat(shared.HeartbeatRequest{
+				NodeID:      n.id,
+				Status:      shared.StatusIdle,
+				ActiveTasks: n.running + len(n.waiting),
+			}, n.token)
+			m.schedule(m.nowMs+simHeartbeatMs, simHeartbeat, nil, n)
+		}
+	}
+
+	sort.Float64s(m.latencies)
+	res := simResult{
+		done:      len(m.latencies),
+		failed:    m.failed,
+		failovers: m.failovers,
+		latencies: m.latencies,
+		spanMs:    m.lastDoneMs,
+	}
+	if res.done > 0 {
+		res.avgWaitMs = m.waitSumMs / float64(res.done)
+	}
+	return res
+}
+
+// dispatch routes a task to a node as routeWithFailover would; false when
+// no node is left to try and the task has failed.
+func (m *meshSim) dispatch(t *simTask) bool {
+	picked, err := m.reg.FindBestNodeExcluding(t.w.Type, t.w.ModelHint, "", "", t.tried)
+	if err != nil {
+		m.failed++
+		return false
+	}
+	n := m.byID[picked.NodeID]
+	t.node = n
+	t.model = expectedModel(picked, t.w.Type, t.w.ModelHint, "", "")
+	t.concurrency = m.reg.IncrementLoad(n.id, t.model)
+	t.queuedMs = m.nowMs
+	if n.running < max(n.cfg.Parallel, 1) {
+		m.start(t)
+	} else {
+		n.waiting = append(n.waiting, t)
+	}
+	return true
+}
+
+// start gives a task one of its node's slots.
+func (m *meshSim) start(t *simTask) {
+	n, c := t.node, t.node.cfg
+	n.running++
+	t.startedMs = m.nowMs
+
+	promptRate := c.PromptTokensPerSec
+	if promptRate <= 0 {
+		promptRate = c.TokensPerSec * 10
+	}
+	ms := float64(c.OverheadMs) +
+		float64(t.w.PromptTokens)/promptRate*1000 +
+		float64(t.w.CompletionTokens)/c.TokensPerSec*1000
+	ms *= 1 + c.Jitter*(2*m.rng.Float64()-1)
+	t.fails = m.rng.Float64() < c.FailureRate
+	if t.fails {
+		ms /= 2 // it gives up partway through
+	}
+	m.schedule(m.nowMs+int64(math.Max(ms, 1)), simFinish, t, n)
+}
+
+// finish ends a task's run on its node, failing it over if it failed;
+// true when the task is done for good.
+func (m *meshSim) finish(t *simTask) bool {
+	n := t.node
+	n.running--
+	m.reg.DecrementLoad(n.id, t.model)
+	if len(n.waiting) > 0 {
+		next := n.waiting[0]
+		n.waiting = n.waiting[1:]
+		m.start(next)
+	}
+
+	if t.fails {
+		n.failed++
+		m.reg.MarkSuspect(n.id)
+		t.tried[n.id] = true
+		m.failovers++
+		return !m.dispatch(t)
+	}
+	n.done++
+	m.reg.RecordLatency(n.id, t.concurrency, m.nowMs-t.queuedMs)
+	m.latencies = append(m.latencies, float64(m.nowMs-t.arrivedMs))
+	m.waitSumMs += float64(t.startedMs - t.queuedMs)
+	m.lastDoneMs = m.nowMs
+	return true
+}
+
+// spread lists how many tasks each node finished (and failed).
+func (m *meshSim) spread() string {
+	parts := make([]string, len(m.nodes))
+	for i, n := range m.nodes {
+		parts[i] = fmt.Sprintf("%s %d", n.id, n.done)
+		if n.failed > 0 {
+			parts[i] += fmt.Sprintf(" (%d failed)", n.failed)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// percentile returns the p-th percentile latency in ms.
+func (r simResult) percentile(p float64) float64 {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	return r.latencies[max(i, 0)]
+}
+
+// perMinute is the throughput: finished tasks per minute of virtual time.
+func (r simResult) perMinute() float64 {
+	if r.spanMs <= 0 {
+		return 0
+	}
+	return float64(r.done) / (float64(r.spanMs) / 60000)
+}
@@ -0,0 +1,81 @@
+// orchestrator/slots.go
+// Parallel request slots as the capacity unit.
+//
+// Agents may declare how many generations each model can run at once
+// (Ollama's OLLAMA_NUM_PARALLEL) and report free slots per model in their
+// heartbeats. For such nodes the router stops comparing active tasks to the
+// node-wide busy threshold: a node is busy for a task only when the model
+// that task would run has no free slot, and the node as a whole is busy
+// only when every slot is taken. Between heartbeats the registry adjusts
+// free slots itself as it dispatches and completes tasks.
+
+package main
+
+import "echo-system/shared"
+
+// expectedModel predicts which model a node will run for a task, using the
+// same rules as the agent.
+func expectedModel(node *shared.NodeInfo, taskType shared.TaskType, modelHint, language string, minQuality shared.QualityTier) string {
+	return shared.ResolveModel(node.Capabilities, node.Models, modelHint, taskType, language, minQuality)
+}
+
+// modelSlots returns the node's slot entry for model, or nil if the node
+// hasn't declared slots for it.
+func modelSlots(node *shared.NodeInfo, model string) *shared.ModelSlots {
+	for i := range node.Slots {
+		if node.Slots[i].Model == model {
+			return &node.Slots[i]
+		}
+	}
+	return nil
+}
+
+// slotsFull reports whether a node that declares slots has none free.
+func slotsFull(node *shared.NodeInfo) bool {
+	for _, s := range node.Slots {
+		if s.Free > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// busyFor reports whether a node is busy for a task running model, and how
+// many slots it has free for it (-1 when slots aren't declared).
+func busyFor(node *shared.NodeInfo, model string) (busy bool, free int) {
+	if s := modelSlots(node, model); s != nil {
+		return s.Free <= 0, s.Free
+	}
+	return node.Status == shared.StatusBusy, -1
+}
+
+// adjustSlot moves one slot of model between free and taken (delta -1 on
+// dispatch, +1 on completion), clamped to the declared total. The slice is
+// copied first since routing snapshots share it. Must be called with the
+// shard's write lock held.
+func adjustSlot(node *shared.NodeInfo, model string, delta int) {
+	if modelSlots(node, model) == nil {
+		return
+	}
+	node.Slots = append([]shared.ModelSlots(nil), node.Slots...)
+	s := modelSlots(node, model)
+	s.Free += delta
+	if s.Free < 0 {
+		s.Free = 0
+	}
+	if s.Free > s.Total {
+		s.Free = s.Total
+	}
+}
+
+// declaredSlots copies the slots an agent registered with, all free.
+func declaredSlots(slots []shared.ModelSlots) []shared.ModelSlots {
+	if len(slots) == 0 {
+		return nil
+	}
+	list := make([]shared.ModelSlots, len(slots))
+	for i, s := range slots {
+		list[i] = shared.ModelSlots{Model: s.Model, Total: s.Total, Free: s.Total}
+	}
+	return list
+}
@@ -0,0 +1,74 @@
+// orchestrator/source.go
+// Who asked the mesh for each task.
+//
+// Every task and pipeline submitted through POST /task, /task/stream,
+// /pipeline, /summarize or /bundles/tasks records its source: the name of the client
+// key it presented, the remote IP and the User-Agent. The source is echoed
+// in the result, sent with task_routed, task_done and pipeline events, and
+// kept with pipeline runs, whose listing filters on it with ?source=. The
+// tasks a request spawns (pipeline steps, map items, compressions, JSON
+// repairs) carry the same source.
+//
+// Client keys name the bearer tokens clients present, from -client-keys
+// ("laptop=${LAPTOP_KEY},phone=${PHONE_KEY}", values expanded from the
+// environment). They attribute, they don't authorize: a request without a
+// key, or with one that isn't listed, still runs and is known by its IP
+// and user agent alone. The admin token counts as the key "admin". The
+// remote IP is the connection's; behind a reverse proxy that's the proxy.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"echo-system/shared"
+)
+
+// clientKeys maps client key names to their tokens; set from -client-keys.
+var clientKeys = map[string]string{}
+
+// parseClientKeys parses -client-keys: name=token pairs separated by commas.
+func parseClientKeys(flag string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(flag, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(entry, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(os.ExpandEnv(token))
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid entry %q (want name=token)", strings.TrimSpace(entry))
+		}
+		if name == "admin" {
+			return nil, fmt.Errorf(`"admin" is reserved for the admin token`)
+		}
+		if _, dup := keys[name]; dup {
+			return nil, fmt.Errorf("key %q is listed twice", name)
+		}
+		keys[name] = token
+	}
+	return keys, nil
+}
+
+// requestSource attributes a submitted task or pipeline to r's client.
+func requestSource(r *http.Request) *shared.TaskSource {
+	src := &shared.TaskSource{UserAgent: r.UserAgent()}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		src.RemoteIP = host // none for unix socket peers
+	}
+	if token := bearerToken(r); token != "" {
+		if sameToken(token, adminToken) {
+			src.Key = "admin"
+		}
+		for name, want := range clientKeys {
+			if sameToken(token, want) {
+				src.Key = name
+			}
+		}
+	}
+	return src
+}
@@ -0,0 +1,149 @@
+// orchestrator/sse.go
+// Server-Sent Events for task streams (POST /task/stream, GET
+// /task/stream/{id}).
+//
+// Streams must survive the reverse proxies people put in front of the
+// orchestrator. nginx buffers responses unless told otherwise, which
+// turns a stream into one burst at the end; X-Accel-Buffering: no turns
+// that off per response, and Cache-Control: no-transform keeps compressing
+// proxies (Cloudflare among them) from holding tokens back. Proxies also
+// close connections that stay quiet — 60s in nginx, 100s through a
+// Cloudflare Tunnel — while a big model loads or a task queues, so a
+// comment line goes out after each -sse-keepalive without an event. The
+// first event tells the client to wait -sse-retry before reconnecting,
+// which EventSource does by itself; resuming picks up where the stream
+// left off (see resume.go).
+//
+// Headers go out with the first event or keep-alive, whichever is first.
+// A task failing before either gets an HTTP error; one failing later, a
+// final chunk carrying the error.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// Set from the -sse-keepalive and -sse-retry flags.
+var (
+	sseKeepAlive = 15 * time.Second // quiet time before a comment line; 0 sends none
+	sseRetry     = 3 * time.Second  // reconnect delay sent to clients; 0 sends none
+)
+
+// sseWriter writes a task stream's Server-Sent Events, sending the SSE
+// headers with the first one. Each TaskChunk event's id is the number of
+// bytes of the answer sent up to and including it, for resuming with
+// Last-Event-ID (see resume.go).
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	mu        sync.Mutex // serializes events and keep-alives
+	started   bool
+	failed    bool // answered with an HTTP error instead
+	offset    int
+	lastWrite time.Time
+}
+
+// start sends the SSE headers and the retry hint. Must be called with
+// s.mu held.
+func (s *sseWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	h := s.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache, no-transform")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	if sseRetry > 0 {
+		fmt.Fprintf(s.w, "retry: %d\n\n", sseRetry.Milliseconds())
+	}
+}
+
+// send writes v as an event's JSON data; event "" is the default
+// (message) event that carries TaskChunks.
+func (s *sseWriter) send(event string, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	data, _ := json.Marshal(v)
+	if event != "" {
+		fmt.Fprintf(s.w, "event: %s\n", event)
+	}
+	if chunk, ok := v.(shared.TaskChunk); ok && event == "" {
+		if chunk.Text != "" {
+			s.offset = len(chunk.Text)
+		} else {
+			s.offset += len(chunk.Token)
+		}
+		fmt.Fprintf(s.w, "id: %d\n", s.offset)
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	s.flusher.Flush()
+	s.lastWrite = time.Now()
+}
+
+// fail ends the task with an error: an HTTP error if nothing was streamed
+// yet, otherwise a final chunk carrying it.
+func (s *sseWriter) fail(taskID string, status int, msg string) {
+	s.mu.Lock()
+	if !s.started {
+		s.failed = true
+		writeTaskProblem(s.w, nil, status, taskID, msg)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.send("", shared.TaskChunk{TaskID: taskID, Done: true, Error: msg})
+}
+
+// keepAlive writes a comment line whenever the stream has been quiet for
+// -sse-keepalive, until the returned function is called; the handler must
+// call it before returning.
+func (s *sseWriter) keepAlive() (stop func()) {
+	if sseKeepAlive <= 0 {
+		return func() {}
+	}
+	s.mu.Lock()
+	s.lastWrite = time.Now()
+	s.mu.Unlock()
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(sseKeepAlive)
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			s.mu.Lock()
+			if s.failed {
+				s.mu.Unlock()
+				return
+			}
+			if time.Since(s.lastWrite) >= sseKeepAlive {
+				s.start()
+				fmt.Fprint(s.w, ": keep-alive\n\n")
+				s.flusher.Flush()
+				s.lastWrite = time.Now()
+			}
+			next := sseKeepAlive - time.Since(s.lastWrite)
+			s.mu.Unlock()
+			timer.Reset(next)
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
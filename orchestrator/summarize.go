@@ -0,0 +1,250 @@
+// orchestrator/summarize.go
+// POST /summarize: map-reduce summaries of long documents.
+//
+// Summarizing something longer than a model's context window is what the
+// mesh is asked for most, and doing it with POST /pipeline means building
+// a map pipeline by hand. POST /summarize takes the document and does the
+// rest: it cuts the text into chunks that fit the map model's window — on
+// paragraph boundaries where it can, else on lines, sentences or words, or
+// as split asks (see shared/chunking) — has the nodes summarize the chunks in parallel, and has the reduce model
+// combine those summaries into one. The chunk summaries are kept short
+// enough for all of them to fit the reduce model's window together; a
+// document with too many chunks for that is refused.
+//
+// It runs as an ordinary pipeline of a map step and a reduce step, so it
+// shows on the dashboard and in GET /pipelines/runs and its tasks carry
+// lineage. The run's initial_input is the chunks as a JSON array. A
+// document that fits in one chunk is summarized in a single step.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"echo-system/shared"
+	"echo-system/shared/chunking"
+)
+
+const (
+	defaultSummaryWords = 250
+	maxSummaryWords     = 2000
+	minChunkTokens      = 100
+
+	// summarizePromptTokens is kept free in a window for the instructions
+	summarizePromptTokens = 200
+
+	// A chunk's summary is between these many words long
+	minChunkSummaryWords = 30
+	maxChunkSummaryWords = 200
+)
+
+// summaryPromptBudget is how many tokens of input a model's prompt can
+// take next to the instructions and the reply.
+func summaryPromptBudget(ctx context.Context, modelHint, language string) int {
+	window, _ := targetWindow(ctx, shared.TaskRequest{Type: shared.TaskTypeSummarize, ModelHint: modelHint, Language: language})
+	return window - window/contextReplyShare - summarizePromptTokens
+}
+
+// summaryPlan is how a document is summarized.
+type summaryPlan struct {
+	pipe        shared.PipelineRequest
+	chunks      []string
+	chunkTokens int
+}
+
+// planSummary cuts req's text into chunks and builds the pipeline that
+// summarizes them.
+func planSummary(ctx context.Context, req shared.SummarizeRequest) (*summaryPlan, error) {
+	words := req.MaxWords
+	if words == 0 {
+		words = defaultSummaryWords
+	}
+	chunkTokens := req.ChunkTokens
+	if chunkTokens == 0 {
+		chunkTokens = summaryPromptBudget(ctx, req.ModelHint, req.Language)
+		if maxPromptTokens > 0 {
+			chunkTokens = min(chunkTokens, maxPromptTokens-summarizePromptTokens)
+		}
+		chunkTokens = max(chunkTokens, minChunkTokens)
+	}
+
+	pipe := shared.PipelineRequest{
+		PipelineID: req.PipelineID,
+		AllowCloud: req.AllowCloud,
+		Language:   req.Language,
+		Metadata:   req.Metadata,
+		Variables:  map[string]string{"focus": ""},
+	}
+	if req.Focus != "" {
+		pipe.Variables["focus"] = " Concentrate on " + strings.TrimSpace(req.Focus) + "."
+	}
+
+	chunks, err := chunking.Split(req.Split, req.Text, chunking.Options{MaxTokens: chunkTokens, Overlap: req.OverlapTokens})
+	if err != nil {
+		return nil, err
+	}
+	plan := &summaryPlan{chunks: chunks, chunkTokens: chunkTokens}
+	if len(chunks) == 1 {
+		pipe.InitialInput = chunks[0]
+		pipe.Steps = []shared.PipelineStep{{
+			Type:      shared.TaskTypeSummarize,
+			ModelHint: req.ModelHint,
+			PromptTemplate: fmt.Sprintf("Summarize the following document in at most %d words.{{var.focus}} "+
+				"Output only the summary.\n\n{{initial_input}}", words),
+		}}
+		plan.pipe = pipe
+		return plan, nil
+	}
+	if len(chunks) > maxMapItems {
+		return nil, fmt.Errorf("the document is %d chunks of up to %d tokens, over the limit of %d; raise chunk_tokens",
+			len(chunks), chunkTokens, maxMapItems)
+	}
+
+	// Size the chunk summaries so that all of them fit the reduce model
+	reduceModel := req.ReduceModelHint
+	if reduceModel == "" {
+		reduceModel = req.ModelHint
+	}
+	reduceBudget := summaryPromptBudget(ctx, reduceModel, req.Language)
+	chunkWords := reduceBudget * 3 / 4 / len(chunks)
+	if chunkWords < minChunkSummaryWords {
+		return nil, fmt.Errorf("the summaries of %d chunks wouldn't fit the reduce model's window (~%d tokens of input); send a shorter document or raise chunk_tokens",
+			len(chunks), reduceBudget)
+	}
+	chunkWords = min(chunkWords, maxChunkSummaryWords)
+
+	array, _ := json.Marshal(chunks)
+	pipe.InitialInput = string(array)
+	pipe.Steps = []shared.PipelineStep{
+		{
+			Type:      shared.TaskTypeSummarize,
+			ModelHint: req.ModelHint,
+			Map:       &shared.PipelineMap{Split: mapSplitJSON, Join: "\n\n", MaxParallel: req.MaxParallel},
+			PromptTemplate: fmt.Sprintf("This is part of a longer document. Summarize it in at most %d words.{{var.focus}} "+
+				"Output only the summary.\n\n{{item}}", chunkWords),
+		},
+		{
+			Type:      shared.TaskTypeSummarize,
+			ModelHint: reduceModel,
+			PromptTemplate: fmt.Sprintf("These are summaries of the %d consecutive parts of one document, in order. "+
+				"Combine them into one coherent summary of the whole document in at most %d words.{{var.focus}} "+
+				"Output only the summary.\n\n{{prev_output}}", len(chunks), words),
+		},
+	}
+	plan.pipe = pipe
+	return plan, nil
+}
+
+// result traces the plan's finished pipeline.
+func (p *summaryPlan) result(pipe *shared.PipelineResult) *shared.SummarizeResult {
+	chunks := p.chunks
+	res := &shared.SummarizeResult{
+		PipelineID:  pipe.PipelineID,
+		Summary:     pipe.FinalOutput,
+		ChunkTokens: p.chunkTokens,
+		Chunks:      make([]shared.SummaryChunk, len(chunks)),
+		LatencyMs:   pipe.LatencyMs,
+		Success:     pipe.Success,
+		Error:       pipe.Error,
+		RunURL:      runURL(pipe.PipelineID),
+		Metadata:    pipe.Metadata,
+	}
+	for i, chunk := range chunks {
+		res.Chunks[i] = shared.SummaryChunk{Index: i, Tokens: shared.EstimateTokens(chunk)}
+	}
+	if len(pipe.Steps) == 0 {
+		return res
+	}
+	mapped := pipe.Steps[0]
+	if len(chunks) == 1 {
+		c := &res.Chunks[0]
+		c.TaskID, c.RoutedTo, c.ModelUsed = mapped.TaskID, mapped.RoutedTo, mapped.ModelUsed
+		c.Summary, c.LatencyMs, c.Success, c.Error = mapped.Content, mapped.LatencyMs, mapped.Success, mapped.Error
+		return res
+	}
+	for _, item := range mapped.Items {
+		if item.Index >= len(res.Chunks) {
+			continue
+		}
+		c := &res.Chunks[item.Index]
+		c.TaskID, c.RoutedTo, c.ModelUsed = item.TaskID, item.RoutedTo, item.ModelUsed
+		c.Summary, c.LatencyMs, c.Success, c.Error = item.Content, item.LatencyMs, item.Success, item.Error
+	}
+	if len(pipe.Steps) > 1 {
+		reduce := pipe.Steps[1]
+		res.Reduce = &reduce
+	}
+	return res
+}
+
+// ─── Client: POST /summarize ──────────────────────────────────────────────────
+
+func handleSummarize(w http.ResponseWriter, r *http.Request) {
+	var req shared.SummarizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch {
+	case strings.TrimSpace(req.Text) == "":
+		writeProblem(w, r, http.StatusBadRequest, "text is required")
+		return
+	case req.MaxWords < 0 || req.MaxWords > maxSummaryWords:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("max_words must be between 1 and %d", maxSummaryWords))
+		return
+	case req.ChunkTokens != 0 && req.ChunkTokens < minChunkTokens:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("chunk_tokens must be at least %d", minChunkTokens))
+		return
+	case req.MaxParallel < 0:
+		writeProblem(w, r, http.StatusBadRequest, "max_parallel must not be negative")
+		return
+	case !chunking.Valid(req.Split):
+		writeProblem(w, r, http.StatusBadRequest, "split must be one of "+strings.Join(chunking.Methods, ", "))
+		return
+	case req.OverlapTokens < 0 || (req.ChunkTokens != 0 && req.OverlapTokens > req.ChunkTokens/2):
+		writeProblem(w, r, http.StatusBadRequest, "overlap_tokens must be between 0 and half of chunk_tokens")
+		return
+	case req.ChunkTokens != 0 && maxPromptTokens > 0 && req.ChunkTokens+summarizePromptTokens > maxPromptTokens:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("chunk_tokens leaves no room for instructions under the %d-token prompt limit", maxPromptTokens))
+		return
+	}
+	if err := checkMetadata(req.Metadata); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plan, err := planSummary(r.Context(), req)
+	if err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	plan.pipe.Source = requestSource(r)
+
+	// Detached like POST /pipeline; the map step takes a task timeout per
+	// round of parallel chunks
+	parallel := req.MaxParallel
+	if parallel == 0 {
+		parallel = defaultMapParallel
+	}
+	rounds := (len(plan.chunks) + parallel - 1) / parallel
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Duration(rounds+1)*taskTimeout)
+	defer cancel()
+
+	pipe, err := ExecutePipeline(ctx, plan.pipe)
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	result := plan.result(pipe)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Success {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,123 @@
+// orchestrator/switchover.go
+// Handing dashboards over when this orchestrator goes away.
+//
+// On SIGINT or SIGTERM every connected dashboard gets a "switchover" event
+// before its WebSocket is closed, then the server stops taking requests and
+// gives in-flight ones shutdownGrace to finish; the tasks they finished
+// are then written to the task history, and the last minute of stats to
+// the stats series. The event carries
+// -switchover-url, the orchestrator dashboards should move to (a standby,
+// or the load balancer in front of the replicas); without one they
+// reconnect here once it's back. HA tooling that fails over without
+// stopping this process — a keepalived notify script, a deploy — sends
+// POST /admin/switchover with the new primary's URL instead; this
+// orchestrator keeps serving API clients until it's told otherwise.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// evictTimeout bounds the wait for dashboards to be sent the event.
+	evictTimeout = 2 * time.Second
+
+	// shutdownGrace is how long in-flight requests get to finish.
+	shutdownGrace = 10 * time.Second
+
+	// historyFlushTimeout bounds writing the task history's queue at
+	// shutdown.
+	historyFlushTimeout = 5 * time.Second
+)
+
+// switchoverURL is where dashboards go when this orchestrator shuts down;
+// set from -switchover-url.
+var switchoverURL string
+
+// checkSwitchoverURL normalizes an orchestrator base URL.
+func checkSwitchoverURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// switchover sends the dashboards a switchover event and disconnects
+// them. It returns how many there were. The event stays on this replica:
+// the others' dashboards aren't going anywhere.
+func switchover(reason, target string) int {
+	n := hub.evict(shared.MeshEvent{
+		Type:      "switchover",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      shared.SwitchoverEvent{Reason: reason, URL: target},
+	}, evictTimeout)
+	if target != "" {
+		log.Printf("[WS] Sent %d dashboard clients to %s (%s)", n, target, reason)
+	} else {
+		log.Printf("[WS] Disconnected %d dashboard clients (%s)", n, reason)
+	}
+	return n
+}
+
+// shutdownOnSignal stops srv gracefully on SIGINT or SIGTERM, handing the
+// dashboards over first. The returned channel is closed once it's done.
+func shutdownOnSignal(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("[Orchestrator] %v — shutting down", sig)
+		switchover("shutdown", switchoverURL)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("[Orchestrator] Requests still running after %s: %v", shutdownGrace, err)
+		}
+		taskHistory.sync(historyFlushTimeout)
+		if err := statsSeries.save(); err != nil {
+			log.Printf("[Stats] Failed to save %s: %v", statsSeries.path, err)
+		}
+	}()
+	return done
+}
+
+// ─── Admin: POST /admin/switchover ────────────────────────────────────────────
+
+type switchoverResponse struct {
+	URL     string `json:"url"`
+	Clients int    `json:"clients"` // dashboards sent there
+}
+
+func handleSwitchover(w http.ResponseWriter, r *http.Request) {
+	var req shared.SwitchoverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	target, err := checkSwitchoverURL(req.URL)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "url: "+err.Error())
+		return
+	}
+	n := switchover("failover", target)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(switchoverResponse{URL: target, Clients: n})
+}
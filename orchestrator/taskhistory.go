@@ -0,0 +1,546 @@
+// orchestrator/taskhistory.go
+// Persistent task history in SQLite.
+//
+// Every task a client sends (POST /task and /task/async, /task/stream,
+// conversation turns, and pipeline steps and map items) is recorded once
+// it finishes in <data-dir>/tasks.db: its prompt, where it ran, on which
+// model, how long it took and how it turned out. GET /history pages
+// through it, newest first, filtered by node, type and time range, so
+// what ran where can be audited after a restart. Tasks that joined an
+// identical one's generation (see dedup.go) are recorded under the task
+// that ran; the orchestrator's own tasks (compression, JSON repair,
+// verification) aren't recorded.
+//
+// Records are written by one goroutine, in batches, so a slow disk never
+// holds up a task; a writer taskHistoryQueueSize records behind drops new
+// ones and logs them. The queue is written out on SIGINT and SIGTERM,
+// once in-flight requests are done (see switchover.go). Records older
+// than -task-history-retention are deleted hourly. A database that can't
+// be opened only costs the history.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"echo-system/shared"
+)
+
+// taskHistoryRetention is how long finished tasks are kept; set from
+// -task-history-retention.
+var taskHistoryRetention = 30 * 24 * time.Hour
+
+const (
+	taskHistoryQueueSize  = 4096
+	taskHistoryBatch      = 256 // most records written in one transaction
+	taskHistoryPruneEvery = time.Hour
+
+	historyDefaultLimit = 50
+	historyMaxLimit     = 500
+)
+
+var taskHistory *TaskHistoryStore
+
+// TaskHistoryStore writes finished tasks to SQLite and queries them.
+type TaskHistoryStore struct {
+	db      *sql.DB        // nil if the database couldn't be opened
+	pending chan historyOp // records to write, and sync markers
+}
+
+// historyOp is a record to write, or with synced set a marker closed once
+// every record queued before it is written.
+type historyOp struct {
+	rec    shared.TaskRecord
+	synced chan struct{}
+}
+
+const taskHistorySchema = `
+CREATE TABLE IF NOT EXISTS tasks (
+	seq               INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id           TEXT    NOT NULL,
+	timestamp         INTEGER NOT NULL,
+	type              TEXT    NOT NULL DEFAULT '',
+	prompt            TEXT    NOT NULL DEFAULT '',
+	model_hint        TEXT    NOT NULL DEFAULT '',
+	routed_to         TEXT    NOT NULL DEFAULT '',
+	model_used        TEXT    NOT NULL DEFAULT '',
+	latency_ms        INTEGER NOT NULL DEFAULT 0,
+	success           INTEGER NOT NULL DEFAULT 0,
+	error             TEXT    NOT NULL DEFAULT '',
+	content           TEXT    NOT NULL DEFAULT '',
+	prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	failed_attempts   INTEGER NOT NULL DEFAULT 0,
+	stream            INTEGER NOT NULL DEFAULT 0,
+	pipeline_id       TEXT    NOT NULL DEFAULT '',
+	source            TEXT    NOT NULL DEFAULT '',
+	metadata          TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS tasks_timestamp ON tasks (timestamp);
+CREATE INDEX IF NOT EXISTS tasks_routed_to ON tasks (routed_to, seq);
+CREATE INDEX IF NOT EXISTS tasks_type ON tasks (type, seq);
+`
+
+const taskHistoryColumns = `seq, task_id, timestamp, type, prompt, model_hint, routed_to, model_used, latency_ms,
+	success, error, content, prompt_tokens, completion_tokens, failed_attempts, stream, pipeline_id, source, metadata`
+
+// taskHistoryMigrations add the columns later versions brought to a
+// database created before them.
+var taskHistoryMigrations = []struct{ column, ddl string }{
+	{"metadata", `ALTER TABLE tasks ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`},
+}
+
+// NewTaskHistoryStore opens <dataDir>/tasks.db, creating it if needed, and
+// starts writing to it.
+func NewTaskHistoryStore(dataDir string) *TaskHistoryStore {
+	h := &TaskHistoryStore{pending: make(chan historyOp, taskHistoryQueueSize)}
+	path := filepath.Join(dataDir, "tasks.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err == nil {
+		// One connection: writes never contend, and reads are short
+		db.SetMaxOpenConns(1)
+		if _, err = db.Exec(taskHistorySchema); err == nil {
+			err = migrateTaskHistory(db)
+		}
+	}
+	if err != nil {
+		log.Printf("[TaskHistory] Cannot open %s (%v) — task history not kept", path, err)
+		if db != nil {
+			db.Close()
+		}
+	} else {
+		h.db = db
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM tasks`).Scan(&n)
+		log.Printf("[TaskHistory] %d tasks recorded in %s", n, path)
+	}
+	go h.run()
+	return h
+}
+
+// migrateTaskHistory adds the columns a database from an older version
+// lacks.
+func migrateTaskHistory(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('tasks')`)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range taskHistoryMigrations {
+		if have[m.column] {
+			continue
+		}
+		if _, err := db.Exec(m.ddl); err != nil {
+			return fmt.Errorf("adding column %s: %w", m.column, err)
+		}
+		log.Printf("[TaskHistory] Added column %s", m.column)
+	}
+	return nil
+}
+
+// run writes queued records in batches and prunes old ones.
+func (h *TaskHistoryStore) run() {
+	prune := time.NewTicker(taskHistoryPruneEvery)
+	defer prune.Stop()
+	h.prune()
+	for {
+		select {
+		case op := <-h.pending:
+			batch := []historyOp{op}
+		drain:
+			for len(batch) < taskHistoryBatch {
+				select {
+				case op := <-h.pending:
+					batch = append(batch, op)
+				default:
+					break drain
+				}
+			}
+			h.write(batch)
+		case <-prune.C:
+			h.prune()
+		}
+	}
+}
+
+// write stores a batch of records in one transaction and releases its
+// sync markers.
+func (h *TaskHistoryStore) write(batch []historyOp) {
+	defer func() {
+		for _, op := range batch {
+			if op.synced != nil {
+				close(op.synced)
+			}
+		}
+	}()
+	if h.db == nil {
+		return
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		log.Printf("[TaskHistory] Failed to record %d tasks: %v", len(batch), err)
+		return
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO tasks (task_id, timestamp, type, prompt, model_hint, routed_to, model_used,
+		latency_ms, success, error, content, prompt_tokens, completion_tokens, failed_attempts, stream, pipeline_id, source, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Printf("[TaskHistory] Failed to record %d tasks: %v", len(batch), err)
+		return
+	}
+	defer stmt.Close()
+	written := 0
+	for _, op := range batch {
+		if op.synced != nil {
+			continue
+		}
+		r := op.rec
+		var source, metadata string
+		if r.Source != nil {
+			b, _ := json.Marshal(r.Source)
+			source = string(b)
+		}
+		if len(r.Metadata) > 0 {
+			b, _ := json.Marshal(r.Metadata)
+			metadata = string(b)
+		}
+		if _, err := stmt.Exec(r.TaskID, r.Timestamp, r.Type, r.Prompt, r.ModelHint, r.RoutedTo, r.ModelUsed,
+			r.LatencyMs, r.Success, r.Error, r.Content, r.PromptTokens, r.CompletionTokens, r.FailedAttempts,
+			r.Stream, r.PipelineID, source, metadata); err != nil {
+			log.Printf("[TaskHistory] Failed to record task %s: %v", r.TaskID, err)
+			continue
+		}
+		written++
+	}
+	if written == 0 {
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[TaskHistory] Failed to record %d tasks: %v", written, err)
+	}
+}
+
+// prune deletes records older than the retention.
+func (h *TaskHistoryStore) prune() {
+	if h.db == nil || taskHistoryRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-taskHistoryRetention).UnixMilli()
+	res, err := h.db.Exec(`DELETE FROM tasks WHERE timestamp < ?`, cutoff)
+	if err != nil {
+		log.Printf("[TaskHistory] Failed to prune old tasks: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[TaskHistory] Pruned %d tasks older than %v", n, taskHistoryRetention)
+	}
+}
+
+// add queues a record, dropping it if the writer has fallen too far
+// behind. Safe on a nil store.
+func (h *TaskHistoryStore) add(rec shared.TaskRecord) {
+	if h == nil || h.db == nil {
+		return
+	}
+	select {
+	case h.pending <- historyOp{rec: rec}:
+	default:
+		log.Printf("[TaskHistory] Writer is %d tasks behind — task %s not recorded", taskHistoryQueueSize, rec.TaskID)
+	}
+}
+
+// sync waits until every record queued so far is written, or for the
+// writer to catch up as far as it can within timeout.
+func (h *TaskHistoryStore) sync(timeout time.Duration) {
+	if h == nil || h.db == nil {
+		return
+	}
+	op := historyOp{synced: make(chan struct{})}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case h.pending <- op:
+	case <-timer.C:
+		return
+	}
+	select {
+	case <-op.synced:
+	case <-timer.C:
+	}
+}
+
+// ─── Recording ────────────────────────────────────────────────────────────────
+
+// record stores a finished task: its result, or err when it got none.
+// Safe on a nil store.
+func (h *TaskHistoryStore) record(req shared.TaskRequest, result *shared.TaskResult, err error, startedAt time.Time) {
+	if h == nil {
+		return
+	}
+	rec := taskRecord(req)
+	rec.LatencyMs = time.Since(startedAt).Milliseconds()
+	if result != nil {
+		rec.RoutedTo = result.RoutedTo
+		rec.ModelUsed = result.ModelUsed
+		rec.Success = result.Success
+		rec.Error = result.Error
+		rec.Content = result.Content
+		rec.PromptTokens = result.PromptTokens
+		rec.CompletionTokens = result.CompletionTokens
+		rec.FailedAttempts = len(result.Attempts)
+	}
+	if err != nil {
+		rec.Success = false
+		rec.Error = err.Error()
+	}
+	h.add(rec)
+}
+
+// taskRecord starts the record of req.
+func taskRecord(req shared.TaskRequest) shared.TaskRecord {
+	rec := shared.TaskRecord{
+		TaskID:       req.TaskID,
+		Timestamp:    time.Now().UnixMilli(),
+		Type:         req.Type,
+		Prompt:       req.Prompt,
+		ModelHint:    req.ModelHint,
+		Source:       req.Source,
+		Metadata:     req.Metadata,
+		PromptTokens: shared.EstimateTokens(req.Prompt),
+	}
+	if req.Lineage != nil {
+		rec.PipelineID = req.Lineage.PipelineID
+	}
+	return rec
+}
+
+// historySink passes a streamed task's events on and records the task
+// when its stream ends. The stream sets model once it picks a node.
+type historySink struct {
+	out       streamSink
+	req       shared.TaskRequest
+	startedAt time.Time
+	model     string
+	content   strings.Builder
+}
+
+// watch wraps out to record the task streamed to it. Returns out as it
+// is on a nil store.
+func (h *TaskHistoryStore) watch(req shared.TaskRequest, out streamSink) streamSink {
+	if h == nil || h.db == nil {
+		return out
+	}
+	return &historySink{out: out, req: req, startedAt: time.Now()}
+}
+
+func (s *historySink) send(event string, v any) {
+	s.out.send(event, v)
+	chunk, ok := v.(shared.TaskChunk)
+	if !ok {
+		return
+	}
+	if chunk.Text != "" {
+		s.content.Reset()
+		s.content.WriteString(chunk.Text)
+	} else {
+		s.content.WriteString(chunk.Token)
+	}
+	if !chunk.Done {
+		return
+	}
+	rec := s.record()
+	rec.RoutedTo = chunk.RoutedTo
+	rec.Success = chunk.Error == ""
+	rec.Error = chunk.Error
+	rec.CompletionTokens = shared.EstimateTokens(rec.Content)
+	taskHistory.add(rec)
+}
+
+// setStreamModel tells a stream's history record which model it runs on.
+func setStreamModel(out streamSink, model string) {
+	if s, ok := out.(*historySink); ok {
+		s.model = model
+	}
+}
+
+func (s *historySink) fail(taskID string, status int, msg string) {
+	s.out.fail(taskID, status, msg)
+	rec := s.record()
+	rec.Error = msg
+	taskHistory.add(rec)
+}
+
+// record starts the record of the stream as it ends.
+func (s *historySink) record() shared.TaskRecord {
+	rec := taskRecord(s.req)
+	rec.Stream = true
+	rec.ModelUsed = s.model
+	rec.LatencyMs = time.Since(s.startedAt).Milliseconds()
+	rec.Content = s.content.String()
+	return rec
+}
+
+// ─── Queries ──────────────────────────────────────────────────────────────────
+
+// historyQuery filters GET /history.
+type historyQuery struct {
+	node   string
+	typ    shared.TaskType
+	since  int64 // Unix ms, inclusive; 0 for no bound
+	until  int64 // Unix ms, exclusive; 0 for no bound
+	cursor int64 // seq to continue below; 0 from the newest
+	limit  int
+}
+
+// list returns a page of records matching q, newest first.
+func (h *TaskHistoryStore) list(q historyQuery) (shared.TaskHistoryPage, error) {
+	page := shared.TaskHistoryPage{Tasks: []shared.TaskRecord{}}
+	if h == nil || h.db == nil {
+		return page, fmt.Errorf("the task history isn't available")
+	}
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if q.node != "" {
+		add("routed_to = ?", q.node)
+	}
+	if q.typ != "" {
+		add("type = ?", q.typ)
+	}
+	if q.since > 0 {
+		add("timestamp >= ?", q.since)
+	}
+	if q.until > 0 {
+		add("timestamp < ?", q.until)
+	}
+	if q.cursor > 0 {
+		add("seq < ?", q.cursor)
+	}
+	query := `SELECT ` + taskHistoryColumns + ` FROM tasks`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY seq DESC LIMIT ?`
+	args = append(args, q.limit+1)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	var last int64
+	for rows.Next() {
+		var r shared.TaskRecord
+		var seq int64
+		var source, metadata string
+		if err := rows.Scan(&seq, &r.TaskID, &r.Timestamp, &r.Type, &r.Prompt, &r.ModelHint, &r.RoutedTo, &r.ModelUsed,
+			&r.LatencyMs, &r.Success, &r.Error, &r.Content, &r.PromptTokens, &r.CompletionTokens, &r.FailedAttempts,
+			&r.Stream, &r.PipelineID, &source, &metadata); err != nil {
+			return page, err
+		}
+		if len(page.Tasks) == q.limit {
+			page.NextCursor = strconv.FormatInt(last, 10)
+			break
+		}
+		if source != "" {
+			r.Source = &shared.TaskSource{}
+			json.Unmarshal([]byte(source), r.Source)
+		}
+		if metadata != "" {
+			json.Unmarshal([]byte(metadata), &r.Metadata)
+		}
+		page.Tasks = append(page.Tasks, r)
+		last = seq
+	}
+	page.Count = len(page.Tasks)
+	return page, rows.Err()
+}
+
+// parseHistoryTime reads a time bound of GET /history: Unix ms, RFC 3339,
+// or a span back from now such as 24h or 7d.
+func parseHistoryTime(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil && ms >= 0 {
+		return ms, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UnixMilli(), nil
+	}
+	span, err := parseSpan(v, 0)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not Unix ms, an RFC 3339 time or a span such as 24h", v)
+	}
+	return time.Now().Add(-span).UnixMilli(), nil
+}
+
+// ─── Client: GET /history ─────────────────────────────────────────────────────
+// Pages through finished tasks, newest first: ?node=, ?type=, ?since= and
+// ?until= filter them, ?limit= sizes the page and ?cursor= continues from
+// a page's next_cursor.
+
+func handleTaskHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := historyQuery{
+		node:  params.Get("node"),
+		typ:   shared.TaskType(params.Get("type")),
+		limit: historyDefaultLimit,
+	}
+	var err error
+	if q.since, err = parseHistoryTime(params.Get("since")); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid since: "+err.Error())
+		return
+	}
+	if q.until, err = parseHistoryTime(params.Get("until")); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid until: "+err.Error())
+		return
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 || q.limit > historyMaxLimit {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("invalid limit %q (want 1 to %d)", v, historyMaxLimit))
+			return
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.cursor, err = strconv.ParseInt(v, 10, 64); err != nil || q.cursor <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("invalid cursor %q", v))
+			return
+		}
+	}
+
+	// Tasks that just finished may still be queued for writing
+	taskHistory.sync(2 * time.Second)
+	page, err := taskHistory.list(q)
+	if err != nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	shared.WriteJSON(w, r, http.StatusOK, page, compressMinBytes)
+}
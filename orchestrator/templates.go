@@ -0,0 +1,211 @@
+// orchestrator/templates.go
+// Built-in pipeline templates.
+//
+// A small library of ready-made pipelines so new users see value without
+// authoring step JSON by hand. Templates are listed at
+// GET /pipelines/templates/builtin and run by name through POST /pipeline:
+//
+//	{"template": "translate-then-summarize", "initial_input": "..."}
+//
+// Teams add their own with -pipeline-templates: a directory holding one
+// template per .yaml, .yml or .json file, in the fields GET lists. YAML
+// lets multi-line prompts be written as they read (see yaml.go). A file's
+// template is named after the file unless it sets a name, and replaces a
+// shipped one of the same name. The files are read once at startup; a
+// template that wouldn't run stops the orchestrator there, not the first
+// pipeline to use it.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"echo-system/shared"
+)
+
+// builtinTemplates is the template library, in display order: the shipped
+// templates, then those from -pipeline-templates.
+var builtinTemplates = []shared.PipelineTemplate{
+	{
+		Name:        "summarize-url",
+		Description: "Fetch a web page and summarize it in a few bullet points.",
+		Input:       "an http(s) URL",
+		Steps: []shared.PipelineStep{
+			{Fetch: &shared.PipelineFetch{}},
+			{
+				Type:           shared.TaskTypeSummarize,
+				PromptTemplate: "Summarize the following web page in 5 concise bullet points. Ignore navigation, ads and boilerplate.\n\n{{fetched_content}}",
+			},
+		},
+	},
+	{
+		Name:        "translate-then-summarize",
+		Description: "Translate text into English, then summarize the translation.",
+		Input:       "text in any language",
+		Steps: []shared.PipelineStep{
+			{
+				Type:           shared.TaskTypeText,
+				PromptTemplate: "Translate the following text into English. Output only the translation.\n\n{{initial_input}}",
+			},
+			{
+				Type:           shared.TaskTypeSummarize,
+				PromptTemplate: "Summarize the following text in one short paragraph.\n\n{{prev_output}}",
+			},
+		},
+	},
+	{
+		Name:        "code-review",
+		Description: "Review code for bugs and risks, then produce a prioritized list of fixes.",
+		Input:       "source code or a diff",
+		Steps: []shared.PipelineStep{
+			{
+				Type:           shared.TaskTypeCode,
+				PromptTemplate: "Review the following code. Point out bugs, security issues, and unclear logic, quoting the relevant lines.\n\n{{initial_input}}",
+			},
+			{
+				Type:           shared.TaskTypeSummarize,
+				PromptTemplate: "Turn this code review into a prioritized checklist of fixes (most severe first), one line each.\n\n{{prev_output}}",
+			},
+		},
+	},
+	{
+		Name:        "summarize-document",
+		Description: "Summarize every section of a long document in parallel, then combine the summaries.",
+		Input:       "a document whose sections are separated by blank lines",
+		Steps: []shared.PipelineStep{
+			{
+				Type:           shared.TaskTypeSummarize,
+				PromptTemplate: "Summarize this section of a longer document in 2-3 sentences. Output only the summary.\n\n{{item}}",
+				Map:            &shared.PipelineMap{Split: "\n\n", Join: "\n"},
+			},
+			{
+				Type:           shared.TaskTypeText,
+				PromptTemplate: "These are summaries of consecutive sections of one document. Write a single coherent summary of the whole document in one paragraph.\n\n{{prev_output}}",
+			},
+		},
+	},
+	{
+		Name:        "meeting-notes",
+		Description: "Turn a raw meeting transcript into structured notes with action items.",
+		Input:       "a meeting transcript",
+		Steps: []shared.PipelineStep{
+			{
+				Type:           shared.TaskTypeSummarize,
+				PromptTemplate: "Summarize the key discussion points and decisions from this meeting transcript.\n\n{{initial_input}}",
+			},
+			{
+				Type:           shared.TaskTypeText,
+				PromptTemplate: "Using the summary and the original transcript, write meeting notes with the sections: Summary, Decisions, Action Items (owner — task — due date if mentioned).\n\nSummary:\n{{prev_output}}\n\nTranscript:\n{{initial_input}}",
+			},
+		},
+	},
+}
+
+// loadPipelineTemplates adds the templates in dir's .yaml, .yml and .json
+// files to the library.
+func loadPipelineTemplates(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	loaded := make(map[string]string) // template name → file
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		tmpl, err := readPipelineTemplate(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if other, ok := loaded[tmpl.Name]; ok {
+			return fmt.Errorf("%s: template %q is already defined in %s", path, tmpl.Name, other)
+		}
+		loaded[tmpl.Name] = name
+
+		replaced := false
+		for i, t := range builtinTemplates {
+			if t.Name == tmpl.Name {
+				builtinTemplates[i], replaced = tmpl, true
+			}
+		}
+		if !replaced {
+			builtinTemplates = append(builtinTemplates, tmpl)
+		}
+	}
+	log.Printf("[Templates] Loaded %d pipeline templates from %s", len(loaded), dir)
+	return nil
+}
+
+// readPipelineTemplate reads and checks one template file.
+func readPipelineTemplate(path string) (shared.PipelineTemplate, error) {
+	var tmpl shared.PipelineTemplate
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return tmpl, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(raw, &tmpl)
+	} else {
+		err = decodeYAML(raw, &tmpl)
+	}
+	if err != nil {
+		return tmpl, err
+	}
+	if tmpl.Name == "" {
+		tmpl.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	tmpl.File = filepath.Base(path)
+	if len(tmpl.Steps) == 0 {
+		return tmpl, fmt.Errorf("template %q has no steps", tmpl.Name)
+	}
+	if err := checkSteps(tmpl.Steps); err != nil {
+		return tmpl, fmt.Errorf("template %q: %v", tmpl.Name, err)
+	}
+	return tmpl, nil
+}
+
+// findTemplate looks up a template by name.
+func findTemplate(name string) (shared.PipelineTemplate, bool) {
+	for _, t := range builtinTemplates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return shared.PipelineTemplate{}, false
+}
+
+// applyTemplate fills req.Steps from the named template.
+func applyTemplate(req *shared.PipelineRequest) error {
+	tmpl, ok := findTemplate(req.Template)
+	if !ok {
+		return fmt.Errorf("unknown template %q", req.Template)
+	}
+	req.Steps = tmpl.Steps
+	return nil
+}
+
+// ─── Client: GET /pipelines/templates/builtin ─────────────────────────────────
+
+func handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"templates": builtinTemplates,
+		"count":     len(builtinTemplates),
+	})
+}
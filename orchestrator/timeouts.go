@@ -0,0 +1,220 @@
+// orchestrator/timeouts.go
+// Adaptive per-attempt timeouts derived from observed latency.
+//
+// A task's attempt on a node used to have the whole -task-timeout (3
+// minutes) to answer, sized for Ollama on a CPU. A GPU node that hangs
+// then holds a task for 3 minutes before it fails over, though it never
+// takes more than a few seconds. Instead, the orchestrator keeps the last
+// timeoutWindow latencies of each node and model, and once it has
+// timeoutMinSamples of them waits timeoutFactor × their p99 plus
+// timeoutMargin, but at least -task-timeout-min (20s) and at most
+// -task-timeout. A fast GPU node that hangs fails over after 20 seconds;
+// a slow CPU node whose answers take minutes still gets its full
+// allowance, and so does any node and model without enough history.
+//
+// The timeout bounds one attempt; the agent is still told the task's own
+// deadline, so a node that runs past its timeout is cut off and the task
+// tried elsewhere rather than returned half-generated. Streams and pull-mode
+// nodes keep the task's deadline. GET /stats/timeouts lists the timeouts.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// timeoutWindow is how many recent latencies are kept per node and model.
+	timeoutWindow = 200
+	// timeoutMinSamples is how many it takes before the timeout adapts.
+	timeoutMinSamples = 20
+	// timeoutFactor and timeoutMargin turn the p99 latency into a timeout.
+	timeoutFactor = 1.5
+	timeoutMargin = 5 * time.Second
+)
+
+var (
+	// taskTimeout is how long we wait for a node to respond before giving
+	// up and trying a failover node, for nodes without enough history and
+	// as the ceiling of adaptive timeouts. Ollama on CPU can be slow, so 3
+	// minutes; set from -task-timeout.
+	taskTimeout = 3 * time.Minute
+	// minTaskTimeout is the floor of adaptive timeouts; set from
+	// -task-timeout-min.
+	minTaskTimeout = 20 * time.Second
+	// adaptiveTimeout enables latency-derived timeouts; set from
+	// -adaptive-timeout.
+	adaptiveTimeout = true
+)
+
+var timeouts = &timeoutTracker{series: make(map[timeoutKey]*latencySeries)}
+
+type timeoutKey struct{ nodeID, model string }
+
+// latencySeries is one node and model's recent latencies, a ring.
+type latencySeries struct {
+	ms       []int64
+	next     int
+	timedOut int64
+}
+
+// timeoutTracker holds the latency series of every node and model.
+type timeoutTracker struct {
+	mu     sync.Mutex
+	series map[timeoutKey]*latencySeries
+}
+
+// record keeps the latency of a task a node answered in full.
+func (t *timeoutTracker) record(nodeID, model string, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(nodeID, model)
+	if len(s.ms) < timeoutWindow {
+		s.ms = append(s.ms, latency.Milliseconds())
+		return
+	}
+	s.ms[s.next] = latency.Milliseconds()
+	s.next = (s.next + 1) % timeoutWindow
+}
+
+// timedOut counts an attempt cut off by its adaptive timeout.
+func (t *timeoutTracker) timedOut(nodeID, model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(nodeID, model).timedOut++
+}
+
+// get returns a node and model's series; call with t.mu held.
+func (t *timeoutTracker) get(nodeID, model string) *latencySeries {
+	key := timeoutKey{nodeID, model}
+	s, ok := t.series[key]
+	if !ok {
+		s = &latencySeries{}
+		t.series[key] = s
+	}
+	return s
+}
+
+// timeout returns how long an attempt on a node and model may take, and
+// whether that was derived from its latencies.
+func (t *timeoutTracker) timeout(nodeID, model string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[timeoutKey{nodeID, model}]
+	if !ok {
+		return taskTimeout, false
+	}
+	return s.timeout()
+}
+
+// timeout derives the series' timeout; call with the tracker's lock held.
+func (s *latencySeries) timeout() (time.Duration, bool) {
+	if !adaptiveTimeout || len(s.ms) < timeoutMinSamples {
+		return taskTimeout, false
+	}
+	d := time.Duration(timeoutFactor*float64(s.p99()))*time.Millisecond + timeoutMargin
+	return min(max(d, minTaskTimeout), taskTimeout), true
+}
+
+// p99 returns the 99th percentile of the series' latencies in ms.
+func (s *latencySeries) p99() int64 {
+	sorted := slices.Clone(s.ms)
+	slices.Sort(sorted)
+	return sorted[(len(sorted)*99+99)/100-1]
+}
+
+// forget drops an evicted node's series.
+func (t *timeoutTracker) forget(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.series {
+		if key.nodeID == nodeID {
+			delete(t.series, key)
+		}
+	}
+}
+
+// flush drops every series, returning how many there were.
+func (t *timeoutTracker) flush() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.series)
+	t.series = make(map[timeoutKey]*latencySeries)
+	return n
+}
+
+// stats lists every node and model's timeout, by node then model.
+func (t *timeoutTracker) stats() shared.TimeoutStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := shared.TimeoutStats{
+		Enabled:   adaptiveTimeout,
+		DefaultMs: taskTimeout.Milliseconds(),
+		MinMs:     minTaskTimeout.Milliseconds(),
+		Timeouts:  []shared.TaskTimeout{},
+	}
+	for key, s := range t.series {
+		d, adaptive := s.timeout()
+		entry := shared.TaskTimeout{
+			NodeID:    key.nodeID,
+			Model:     key.model,
+			Samples:   len(s.ms),
+			TimeoutMs: d.Milliseconds(),
+			Adaptive:  adaptive,
+			TimedOut:  s.timedOut,
+		}
+		if len(s.ms) > 0 {
+			entry.P99Ms = s.p99()
+		}
+		stats.Timeouts = append(stats.Timeouts, entry)
+	}
+	sort.Slice(stats.Timeouts, func(i, j int) bool {
+		a, b := stats.Timeouts[i], stats.Timeouts[j]
+		return a.NodeID < b.NodeID || (a.NodeID == b.NodeID && a.Model < b.Model)
+	})
+	return stats
+}
+
+// ─── Attempt contexts ─────────────────────────────────────────────────────────
+
+// taskDeadlineKey holds the task's own deadline in an attempt's context,
+// for forwardTask to tell the agent.
+type taskDeadlineKey struct{}
+
+// attemptContext bounds one attempt on a node by the node and model's
+// timeout, if that's sooner than the task's deadline.
+func attemptContext(ctx context.Context, node *shared.NodeInfo, model string) (context.Context, context.CancelFunc) {
+	if node.Pull {
+		return ctx, func() {}
+	}
+	d, _ := timeouts.timeout(node.NodeID, model)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return ctx, func() {}
+	}
+	deadline, _ := ctx.Deadline()
+	return context.WithTimeout(context.WithValue(ctx, taskDeadlineKey{}, deadline), d)
+}
+
+// taskDeadline is the deadline to tell an agent: the task's, not its
+// attempt's.
+func taskDeadline(ctx context.Context) (time.Time, bool) {
+	if deadline, ok := ctx.Value(taskDeadlineKey{}).(time.Time); ok {
+		return deadline, !deadline.IsZero()
+	}
+	return ctx.Deadline()
+}
+
+// ─── GET /stats/timeouts ──────────────────────────────────────────────────────
+
+func handleTimeoutStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeouts.stats())
+}
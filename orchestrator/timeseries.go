@@ -0,0 +1,304 @@
+// orchestrator/timeseries.go
+// Stats retention: a small embedded time series of per-minute rollups.
+//
+// The dashboard counters in websocket.go reset on every restart. This store
+// keeps one bucket per minute for statsRetention (30 days), saved to
+// <data-dir>/stats.json once a minute and on shutdown, so GET /stats/series
+// can answer "tasks per hour over the last week" across restarts.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	statsRetention    = 30 * 24 * time.Hour
+	statsSaveInterval = time.Minute
+)
+
+// minuteBucket holds the counters for one minute.
+type minuteBucket struct {
+	Minute           int64 `json:"minute"` // unix seconds / 60
+	Tasks            int64 `json:"tasks"`
+	FailedTasks      int64 `json:"failed_tasks"`
+	Pipelines        int64 `json:"pipelines"`
+	LatencySumMs     int64 `json:"latency_sum_ms"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	SentBytes        int64 `json:"sent_bytes"`
+	ReceivedBytes    int64 `json:"received_bytes"`
+	Dispatches       int64 `json:"dispatches,omitempty"`
+	WarmDispatches   int64 `json:"warm_dispatches,omitempty"`
+
+	// Queue depth samples per shared.QueueDepthBounds bucket, and the deepest
+	QueueDepth    []int64 `json:"queue_depth,omitempty"`
+	QueueDepthMax int64   `json:"queue_depth_max,omitempty"`
+}
+
+// StatsSeries is the per-minute rollup store.
+type StatsSeries struct {
+	mu      sync.Mutex
+	path    string
+	buckets map[int64]*minuteBucket // keyed by minute
+	dirty   bool
+}
+
+var statsSeries *StatsSeries
+
+// NewStatsSeries loads the series from dataDir (if present) and starts the
+// background save/prune loop.
+func NewStatsSeries(dataDir string) *StatsSeries {
+	s := &StatsSeries{
+		path:    filepath.Join(dataDir, "stats.json"),
+		buckets: make(map[int64]*minuteBucket),
+	}
+	if raw, err := os.ReadFile(s.path); err == nil {
+		var list []*minuteBucket
+		if err := json.Unmarshal(raw, &list); err != nil {
+			log.Printf("[Stats] Ignoring corrupt %s: %v", s.path, err)
+		} else {
+			for _, b := range list {
+				s.buckets[b.Minute] = b
+			}
+			log.Printf("[Stats] Loaded %d minute buckets from %s", len(list), s.path)
+		}
+	}
+	go s.saveLoop()
+	return s
+}
+
+// bucket returns the current minute's bucket. Must be called with the lock held.
+func (s *StatsSeries) bucket() *minuteBucket {
+	minute := time.Now().Unix() / 60
+	b, ok := s.buckets[minute]
+	if !ok {
+		b = &minuteBucket{Minute: minute}
+		s.buckets[minute] = b
+	}
+	s.dirty = true
+	return b
+}
+
+// RecordTask counts a completed (or failed) task.
+func (s *StatsSeries) RecordTask(result *shared.TaskResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket()
+	b.Tasks++
+	if !result.Success {
+		b.FailedTasks++
+	}
+	b.LatencySumMs += result.LatencyMs
+	b.PromptTokens += int64(result.PromptTokens)
+	b.CompletionTokens += int64(result.CompletionTokens)
+}
+
+// RecordTransfer adds a task's bytes to and from its agent.
+func (s *StatsSeries) RecordTransfer(t *shared.TaskTransfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket()
+	b.SentBytes += t.SentBytes
+	b.ReceivedBytes += t.ReceivedBytes
+}
+
+// RecordDispatch counts a task sent to a node, warm if the node had its
+// model loaded.
+func (s *StatsSeries) RecordDispatch(warm bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket()
+	b.Dispatches++
+	if warm {
+		b.WarmDispatches++
+	}
+}
+
+// RecordQueueDepth adds a sample of how many tasks were waiting.
+func (s *StatsSeries) RecordQueueDepth(depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket()
+	if len(b.QueueDepth) != len(shared.QueueDepthBounds) {
+		b.QueueDepth = make([]int64, len(shared.QueueDepthBounds))
+	}
+	i := len(shared.QueueDepthBounds) - 1
+	for i > 0 && depth < shared.QueueDepthBounds[i] {
+		i--
+	}
+	b.QueueDepth[i]++
+	b.QueueDepthMax = max(b.QueueDepthMax, int64(depth))
+}
+
+// RecordPipeline counts a started pipeline.
+func (s *StatsSeries) RecordPipeline() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bucket().Pipelines++
+}
+
+// Series downsamples the buckets covering the last window into points of
+// width step, oldest first. Empty steps are included as zero points so the
+// result can be charted directly.
+func (s *StatsSeries) Series(window, step time.Duration) []shared.StatsPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stepMin := int64(step / time.Minute)
+	if stepMin < 1 {
+		stepMin = 1
+	}
+	now := time.Now().Unix() / 60
+	end := now - now%stepMin + stepMin // exclusive, aligned to step
+	start := end - int64(window/time.Minute)
+	start -= start % stepMin
+
+	points := make([]shared.StatsPoint, 0, (end-start)/stepMin)
+	for from := start; from < end; from += stepMin {
+		p := shared.StatsPoint{Timestamp: from * 60 * 1000, QueueDepth: make([]int64, len(shared.QueueDepthBounds))}
+		var latencySum, sent, warm int64
+		for m := from; m < from+stepMin; m++ {
+			b, ok := s.buckets[m]
+			if !ok {
+				continue
+			}
+			p.Tasks += b.Tasks
+			p.FailedTasks += b.FailedTasks
+			p.Pipelines += b.Pipelines
+			p.PromptTokens += b.PromptTokens
+			p.CompletionTokens += b.CompletionTokens
+			p.SentBytes += b.SentBytes
+			p.ReceivedBytes += b.ReceivedBytes
+			latencySum += b.LatencySumMs
+			sent += b.Dispatches
+			warm += b.WarmDispatches
+			for i, n := range b.QueueDepth {
+				if i < len(p.QueueDepth) {
+					p.QueueDepth[i] += n
+				}
+			}
+			p.QueueDepthMax = max(p.QueueDepthMax, b.QueueDepthMax)
+		}
+		if p.Tasks > 0 {
+			p.AvgLatencyMs = float64(latencySum) / float64(p.Tasks)
+		}
+		if sent > 0 {
+			p.WarmHitRate = float64(warm) / float64(sent)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// saveLoop prunes expired buckets and writes the series to disk.
+func (s *StatsSeries) saveLoop() {
+	ticker := time.NewTicker(statsSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.save(); err != nil {
+			log.Printf("[Stats] Failed to save %s: %v", s.path, err)
+		}
+	}
+}
+
+// save prunes expired buckets and writes the series to disk if it changed;
+// shutdownOnSignal calls it once more for the minute the loop hasn't saved.
+func (s *StatsSeries) save() error {
+	s.mu.Lock()
+	oldest := (time.Now().Add(-statsRetention)).Unix() / 60
+	for m := range s.buckets {
+		if m < oldest {
+			delete(s.buckets, m)
+			s.dirty = true
+		}
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	list := make([]*minuteBucket, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		saved := *b
+		list = append(list, &saved)
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Minute < list[j].Minute })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// ─── Client: GET /stats/series ────────────────────────────────────────────────
+// Query params: window (default 24h, max 30 days) and step (default 1h,
+// min 1m). Both accept Go durations plus a "d" suffix for days: 7d, 90m.
+
+func handleStatsSeries(w http.ResponseWriter, r *http.Request) {
+	window, err := parseSpan(r.URL.Query().Get("window"), 24*time.Hour)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid window: "+err.Error())
+		return
+	}
+	step, err := parseSpan(r.URL.Query().Get("step"), time.Hour)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid step: "+err.Error())
+		return
+	}
+	if window > statsRetention {
+		window = statsRetention
+	}
+	if step < time.Minute {
+		step = time.Minute
+	}
+	if window/step > 10_000 {
+		writeProblem(w, r, http.StatusBadRequest, "too many points: increase step or shrink window")
+		return
+	}
+
+	points := statsSeries.Series(window, step)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"window_secs": int64(window.Seconds()),
+		"step_secs":   int64(step.Seconds()),
+		"points":      points,
+	})
+}
+
+// parseSpan parses a duration like "90m", "6h" or "7d".
+func parseSpan(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	if n, ok := strings.CutSuffix(v, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("%q is not a positive number of days", v)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,108 @@
+// orchestrator/topology.go
+// Mesh topology: which nodes can see which others on the network.
+//
+// Agents advertise themselves over mDNS as "_echo-node._tcp", browse for
+// each other and report the peers they find, with the TCP connect time to
+// each, in their heartbeats. GET /topology combines the latest reports
+// into links between nodes, alongside each node's link to the orchestrator
+// (see link.go); the dashboard draws them. Nothing routes on the peer
+// links yet — they're the groundwork for locality-aware relaying.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// topologyReportTTL is how long a node's peer report counts without a
+// fresh one (agents rescan every 30s and resend the result every beat).
+const topologyReportTTL = 60 * time.Second
+
+var topology = &topologyStore{reports: make(map[string]peerReport)}
+
+// peerReport is the latest list of peers a node reported.
+type peerReport struct {
+	peers []shared.PeerLink
+	at    time.Time
+}
+
+// topologyStore holds each node's latest peer report.
+type topologyStore struct {
+	mu      sync.Mutex
+	reports map[string]peerReport // keyed by node_id
+}
+
+// update records the peers nodeID sees.
+func (t *topologyStore) update(nodeID string, peers []shared.PeerLink) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reports[nodeID] = peerReport{peers: peers, at: time.Now()}
+}
+
+// build assembles the topology of the given nodes from fresh reports.
+func (t *topologyStore) build(nodes []*shared.NodeInfo) shared.Topology {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, r := range t.reports {
+		if now.Sub(r.at) >= topologyReportTTL {
+			delete(t.reports, id)
+		}
+	}
+
+	topo := shared.Topology{
+		Nodes:       []shared.TopologyNode{},
+		Links:       []shared.TopologyLink{},
+		GeneratedAt: now.UnixMilli(),
+	}
+	seen := make(map[[2]string]bool) // {from, to} pairs reported
+	for _, node := range nodes {
+		tn := shared.TopologyNode{NodeID: node.NodeID, Status: node.Status, Health: node.Health, Link: node.Link}
+		if r, ok := t.reports[node.NodeID]; ok {
+			tn.Reporting = true
+			tn.ReportedAt = r.at.UnixMilli()
+			for _, p := range r.peers {
+				seen[[2]string{node.NodeID, p.NodeID}] = true
+			}
+		}
+		topo.Nodes = append(topo.Nodes, tn)
+	}
+	for _, tn := range topo.Nodes {
+		if !tn.Reporting {
+			continue
+		}
+		for _, p := range t.reports[tn.NodeID].peers {
+			topo.Links = append(topo.Links, shared.TopologyLink{
+				From:      tn.NodeID,
+				To:        p.NodeID,
+				Reachable: p.Reachable,
+				RTTMs:     p.RTTMs,
+				Loss:      p.Loss,
+				Mutual:    seen[[2]string{p.NodeID, tn.NodeID}],
+			})
+		}
+	}
+	sort.Slice(topo.Links, func(i, j int) bool {
+		a, b := topo.Links[i], topo.Links[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return topo
+}
+
+// ─── GET /topology ────────────────────────────────────────────────────────────
+
+func handleTopology(w http.ResponseWriter, r *http.Request) {
+	topo := topology.build(registry.AllNodes())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topo)
+}
@@ -0,0 +1,65 @@
+// orchestrator/transfer.go
+// Network transfer per task.
+//
+// forwardTask and forwardTaskStream count the bytes of each task they send
+// to an agent and of the result or token stream they read back, so users
+// whose nodes sit behind a metered or slow link (a VPN leg to a remote
+// site) can see which workloads are network-heavy. The counts go on the
+// TaskResult (or the final stream chunk), are summed per node in NodeInfo,
+// and feed the dashboard totals and the stats series.
+
+package main
+
+import (
+	"io"
+	"sync/atomic"
+
+	"echo-system/shared"
+)
+
+var (
+	sentBytes     int64 // task bodies sent to agents
+	receivedBytes int64 // result and stream bodies read from agents
+)
+
+// countingBody counts the bytes read through an HTTP response body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// recordTransfer adds a task's transfer to its node's and the mesh's totals.
+func recordTransfer(nodeID string, t *shared.TaskTransfer) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&sentBytes, t.SentBytes)
+	atomic.AddInt64(&receivedBytes, t.ReceivedBytes)
+	statsSeries.RecordTransfer(t)
+	registry.RecordTransfer(nodeID, t)
+}
+
+// RecordTransfer adds a task's transfer to the node's totals. Like
+// RecordTimings it replaces the TaskTransfer, since snapshots share it.
+func (r *Registry) RecordTransfer(nodeID string, t *shared.TaskTransfer) {
+	s := r.shard(nodeID)
+	s.lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	total := *t
+	if prev := node.Transfer; prev != nil {
+		total.SentBytes += prev.SentBytes
+		total.ReceivedBytes += prev.ReceivedBytes
+	}
+	node.Transfer = &total
+}
@@ -0,0 +1,141 @@
+// orchestrator/verify.go
+// Verification: tasks with "verify": true.
+//
+// A 3B model on a Pi answers fast and is often right, but a wrong sum or
+// code that doesn't compile reads just as confidently as a right one. A
+// task sent with verify has a second model check its answer once it's
+// done: -verify-model if set, otherwise a model other than the one that
+// answered that handles the task's type, the best-ranked node's first, and
+// only if there's no other the answering model itself (same_model). The
+// check is a format=json task of its own, routed like any other so it
+// shows up on the dashboard, asking for a verdict (pass, fail or unsure),
+// a confidence and the issues found. The result carries them in
+// verification; /task/stream sends them on the done chunk, which waits
+// for the check.
+//
+// Verification never fails the task: a check that fails, or whose reply
+// holds no verdict, comes back as verdict unverified with the error.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+// verifyModel is the model that checks answers; set from -verify-model.
+// Empty picks another model for each task.
+var verifyModel string
+
+// verifyPrompt asks a model to check an answer; %s are the request and
+// the answer.
+const verifyPrompt = "Check the answer below to the request below for obvious errors: wrong facts or arithmetic, " +
+	"code that wouldn't compile or run, or not doing what was asked. Ignore style. " +
+	`Reply with only a JSON object: {"verdict": "pass", "fail" or "unsure", "confidence": a number from 0 to 1, ` +
+	`"issues": "the errors you found, or an empty string"}.` + "\n\nREQUEST:\n%s\n\nANSWER:\n%s"
+
+// checkVerify rejects verify on tasks without an answer to check.
+func checkVerify(req shared.TaskRequest) error {
+	if req.Verify && req.Type == shared.TaskTypeEmbed {
+		return fmt.Errorf("embed tasks have no answer to verify")
+	}
+	return nil
+}
+
+// ensureVerified attaches a check of a successful verify task's answer to
+// its result.
+func ensureVerified(ctx context.Context, req shared.TaskRequest, result *shared.TaskResult) {
+	if !req.Verify || !result.Success {
+		return
+	}
+	result.Verification = verifyAnswer(ctx, req, result.ModelUsed, result.Content)
+}
+
+// verifyAnswer has a second model check answer, which model gave to req.
+func verifyAnswer(ctx context.Context, req shared.TaskRequest, model, answer string) *shared.Verification {
+	taskType := req.Type
+	if taskType == "" {
+		taskType = shared.TaskTypeText
+	}
+	verifier, same := verifierModel(taskType, req.Language, model)
+	check := shared.TaskRequest{
+		TaskID:     uuid.New().String(),
+		Type:       taskType,
+		ModelHint:  verifier,
+		Language:   req.Language,
+		Format:     shared.FormatJSON,
+		Prompt:     fmt.Sprintf(verifyPrompt, req.Prompt, answer),
+		AllowCloud: req.AllowCloud,
+		Source:     req.Source,
+		NoDedup:    true,
+		Metadata:   map[string]string{"echo.verify_for": req.TaskID},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, taskTimeout)
+	defer cancel()
+	startedAt := time.Now()
+	result, err := routeWithFailover(ctx, check, nil)
+	if err != nil {
+		log.Printf("[Verify] Task %s: check failed: %v", req.TaskID, err)
+		return &shared.Verification{Verdict: shared.VerdictUnverified, Error: err.Error()}
+	}
+	result.LatencyMs = time.Since(startedAt).Milliseconds()
+	EmitTaskDone(result)
+
+	v := parseVerdict(result.Content)
+	v.Model, v.NodeID, v.LatencyMs = result.ModelUsed, result.RoutedTo, result.LatencyMs
+	v.SameModel = same || shared.SameModel(result.ModelUsed, model)
+	log.Printf("[Verify] Task %s: %s (%.2f) from %s on %s", req.TaskID, v.Verdict, v.Confidence, result.ModelUsed, result.RoutedTo)
+	return v
+}
+
+// verifierModel picks the model to check an answer from model: one that
+// isn't model, or model itself (same) if no node has another.
+func verifierModel(taskType shared.TaskType, language, model string) (verifier string, same bool) {
+	if verifyModel != "" {
+		return verifyModel, shared.SameModel(verifyModel, model)
+	}
+	for _, node := range registry.RankCandidates(taskType, "", language, "", nil) {
+		if m := expectedModel(node, taskType, "", language, ""); m != "" && !shared.SameModel(m, model) {
+			return m, false
+		}
+		for _, c := range node.Capabilities {
+			if shared.CanHandle([]shared.ModelCapability{c}, taskType) && !shared.SameModel(c.Name, model) {
+				return c.Name, false
+			}
+		}
+	}
+	return model, true
+}
+
+// parseVerdict reads a verifier's reply, tolerating text around the JSON
+// object.
+func parseVerdict(reply string) *shared.Verification {
+	var parsed struct {
+		Verdict    shared.Verdict `json:"verdict"`
+		Confidence float64        `json:"confidence"`
+		Issues     string         `json:"issues"`
+	}
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start || json.Unmarshal([]byte(reply[start:end+1]), &parsed) != nil {
+		return &shared.Verification{Verdict: shared.VerdictUnverified, Error: "the verifier's reply isn't a JSON object"}
+	}
+	switch parsed.Verdict {
+	case shared.VerdictPass, shared.VerdictFail, shared.VerdictUnsure:
+	default:
+		return &shared.Verification{Verdict: shared.VerdictUnverified, Error: fmt.Sprintf("the verifier's reply has no verdict (got %q)", parsed.Verdict)}
+	}
+	return &shared.Verification{
+		Verdict:    parsed.Verdict,
+		Confidence: min(1, max(0, parsed.Confidence)),
+		Issues:     strings.TrimSpace(parsed.Issues),
+	}
+}
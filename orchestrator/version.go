@@ -0,0 +1,65 @@
+// orchestrator/version.go
+// GET /version, and checking the versions agents report.
+//
+// An agent speaking another mesh API version would register, heartbeat
+// and take tasks in a shape this orchestrator misreads, so registrations
+// and heartbeats from one are refused with 426 and the reason. Agents that
+// predate version reporting are let in with a warning, as are agents of
+// another release speaking the same API — the usual state halfway through
+// a rolling upgrade — which are graded yellow until the versions match.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"echo-system/shared"
+)
+
+// checkAgentAPI refuses an agent speaking another mesh API version. 0 is
+// an agent that doesn't say.
+func checkAgentAPI(nodeID string, api int, version string) error {
+	if api == 0 || api == shared.MeshAPIVersion {
+		return nil
+	}
+	return fmt.Errorf("agent %s (%s) speaks mesh API v%d but this orchestrator (%s) speaks v%d; run matching versions",
+		nodeID, versionLabel(version), api, shared.Version, shared.MeshAPIVersion)
+}
+
+// warnAgentVersion logs what can't be verified or doesn't match about a
+// registering agent's version.
+func warnAgentVersion(req shared.RegisterRequest) {
+	switch {
+	case req.APIVersion == 0:
+		log.Printf("[Registry] Node %s doesn't report its mesh API version — an older agent? Upgrade it to %s", req.NodeID, shared.Version)
+	case versionSkew(req.Version) != "":
+		log.Printf("[Registry] Node %s runs %s, this orchestrator %s", req.NodeID, req.Version, shared.Version)
+	}
+}
+
+// versionSkew describes how an agent's release differs from the
+// orchestrator's; "" when they match or either is a dev build.
+func versionSkew(agent string) string {
+	if !shared.IsRelease(agent) || !shared.IsRelease(shared.Version) || agent == shared.Version {
+		return ""
+	}
+	return fmt.Sprintf("runs %s, orchestrator %s", agent, shared.Version)
+}
+
+// versionLabel names a reported version for messages.
+func versionLabel(version string) string {
+	if version == "" {
+		return "unknown version"
+	}
+	return version
+}
+
+// ─── Client: GET /version ─────────────────────────────────────────────────────
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shared.BuildInfo())
+}
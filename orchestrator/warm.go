@@ -0,0 +1,88 @@
+// orchestrator/warm.go
+// Warm routing: tasks go to nodes that already have their model loaded.
+//
+// Loading a model into memory takes seconds, and for a large model longer
+// than many tasks take to generate. Agents report the models their backend
+// holds (Ollama's /api/ps, llama.cpp's one model) in every heartbeat, and
+// within a routing tier a node whose model for the task is loaded ranks
+// ahead of nodes that would have to load it — after the language match and
+// as long as it isn't busy, so a burst still spreads across the mesh and
+// warms the other nodes up. A model_hint match stays ahead of any warm
+// node that merely handles the task type.
+//
+// Ollama agents also report what each loaded model holds and when Ollama
+// will unload it if it stays idle (loaded_memory on the node). A model
+// whose time is up counts as cold even if the last heartbeat listed it.
+//
+// Every dispatch counts as warm or cold; the share of warm ones is the
+// warm hit rate in the dashboard stats and GET /stats/series.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+var (
+	dispatches     int64 // tasks sent to a node
+	warmDispatches int64 // of those, sent to a node with the model loaded
+)
+
+// modelWarm reports whether node's backend had model loaded at its last
+// heartbeat.
+func modelWarm(node *shared.NodeInfo, model string) bool {
+	if model == "" {
+		return false
+	}
+	now := time.Now().UnixMilli()
+	for _, m := range node.LoadedMemory {
+		if m.ExpiresAt != 0 && m.ExpiresAt <= now && shared.SameModel(m.Name, model) {
+			return false // Ollama has unloaded it since
+		}
+	}
+	for _, m := range node.LoadedModels {
+		if shared.SameModel(m, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadedMemory moves the unload times an agent reported onto the
+// orchestrator's clock.
+func loadedMemory(models []shared.LoadedModel, skewMs int64) []shared.LoadedModel {
+	if len(models) == 0 {
+		return nil
+	}
+	out := make([]shared.LoadedModel, len(models))
+	for i, m := range models {
+		if m.ExpiresAt != 0 {
+			m.ExpiresAt -= skewMs
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// recordDispatch counts a task sent to node to run model.
+func recordDispatch(node *shared.NodeInfo, model string) {
+	warm := modelWarm(node, model)
+	atomic.AddInt64(&dispatches, 1)
+	if warm {
+		atomic.AddInt64(&warmDispatches, 1)
+	}
+	statsSeries.RecordDispatch(warm)
+}
+
+// warmHitRate is the share of dispatches that found their model loaded,
+// 0..1.
+func warmHitRate() float64 {
+	n := atomic.LoadInt64(&dispatches)
+	if n == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&warmDispatches)) / float64(n)
+}
@@ -3,12 +3,18 @@
 //
 // Manages connected dashboard clients and broadcasts mesh events
 // (task routing, completions, node status changes, pipeline progress).
+// Events go out through the EventPublisher in eventbus.go, which shares
+// them with other orchestrator replicas when -event-bus is set. Events are
+// JSON text messages, or CBOR binary ones for dashboards that ask for the
+// echo-mesh.cbor subprotocol (see cbor.go).
 
 package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -31,12 +37,22 @@ var (
 	totalPipelines int64
 	latencySum     int64 // cumulative latency in ms
 	latencyCount   int64 // number of completed tasks
+	promptTokens   int64 // estimated prompt tokens of completed tasks
+	outputTokens   int64 // estimated completion tokens of completed tasks
 )
 
 // ─── WebSocket upgrader ───────────────────────────────────────────────────────
 
+// Subprotocols a dashboard may ask for: events as JSON text messages (the
+// default, also without a subprotocol) or as CBOR binary ones (see cbor.go).
+const (
+	wsProtocolJSON = "echo-mesh.json"
+	wsProtocolCBOR = "echo-mesh.cbor"
+)
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // allow any origin
+	CheckOrigin:  wsOriginAllowed, // same origin or -cors-origins (see cors.go)
+	Subprotocols: []string{wsProtocolCBOR, wsProtocolJSON},
 }
 
 // ─── EventHub ─────────────────────────────────────────────────────────────────
@@ -48,8 +64,13 @@ type EventHub struct {
 }
 
 type wsClient struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn        *websocket.Conn
+	addr        string
+	connectedAt time.Time
+	binary      bool          // events go out as CBOR binary messages
+	queue       *wsQueue      // events waiting to be written (see wsqueue.go)
+	done        chan struct{} // closed when the write pump has stopped
+	slowOnce    sync.Once
 }
 
 func NewEventHub() *EventHub {
@@ -58,23 +79,47 @@ func NewEventHub() *EventHub {
 	}
 }
 
-// Broadcast sends a MeshEvent to all connected dashboard clients.
-func (h *EventHub) Broadcast(event shared.MeshEvent) {
+// Publish sends a MeshEvent to all connected dashboard clients. A client
+// that has fallen too far behind is disconnected instead (see wsqueue.go).
+func (h *EventHub) Publish(event shared.MeshEvent) {
+	if event.Version == "" {
+		event.Version = shared.Version // relayed events keep their replica's
+	}
 	data, err := json.Marshal(event)
 	if err != nil {
 		return
 	}
+	key := coalesceKey(event)
+	frame := &wsFrame{json: data}
 
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	for client := range h.clients {
-		select {
-		case client.send <- data:
-		default:
-			// Client buffer full — drop the message
+		if client.queue.push(key, frame.of(client), false) {
+			go dropSlow(client)
+		}
+	}
+}
+
+// wsFrame is an event in the framings clients want, each encoded once.
+type wsFrame struct {
+	json, cbor []byte
+}
+
+// of returns the event framed as client wants it.
+func (f *wsFrame) of(client *wsClient) []byte {
+	if !client.binary {
+		return f.json
+	}
+	if f.cbor == nil {
+		var err error
+		if f.cbor, err = jsonToCBOR(f.json); err != nil {
+			log.Printf("[WS] Can't encode an event as CBOR: %v", err)
+			f.cbor = []byte{cborSimple | 22} // null
 		}
 	}
+	return f.cbor
 }
 
 // register adds a new client to the hub.
@@ -91,12 +136,60 @@ func (h *EventHub) unregister(client *wsClient) {
 	defer h.mu.Unlock()
 	if _, ok := h.clients[client]; ok {
 		delete(h.clients, client)
-		close(client.send)
+		client.queue.close()
 		client.conn.Close()
 		log.Printf("[WS] Dashboard client disconnected (%d remaining)", len(h.clients))
 	}
 }
 
+// evict sends every client a last event and closes its connection once
+// that (and anything queued before it) is written, waiting up to timeout
+// for the writes. It returns how many clients there were.
+func (h *EventHub) evict(event shared.MeshEvent, timeout time.Duration) int {
+	data, _ := json.Marshal(event)
+	frame := &wsFrame{json: data}
+	h.mu.Lock()
+	var evicted []*wsClient
+	for client := range h.clients {
+		client.queue.push("", frame.of(client), true)
+		client.queue.close()
+		delete(h.clients, client)
+		evicted = append(evicted, client)
+	}
+	h.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, client := range evicted {
+		select {
+		case <-client.done:
+		case <-deadline:
+			return len(evicted)
+		}
+	}
+	return len(evicted)
+}
+
+// clientStats reports each client's queue.
+func (h *EventHub) clientStats() []dashboardClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]dashboardClient, 0, len(h.clients))
+	for client := range h.clients {
+		q := client.queue
+		q.mu.Lock()
+		list = append(list, dashboardClient{
+			RemoteAddr:  client.addr,
+			ConnectedAt: client.connectedAt.UnixMilli(),
+			Queued:      len(q.messages),
+			Dropped:     q.dropped,
+			Coalesced:   q.coalesced,
+			Framing:     client.framing(),
+		})
+		q.mu.Unlock()
+	}
+	return list
+}
+
 // ClientCount returns number of connected dashboard clients.
 func (h *EventHub) ClientCount() int {
 	h.mu.RLock()
@@ -108,6 +201,11 @@ func (h *EventHub) ClientCount() int {
 
 // handleWS upgrades an HTTP connection to a WebSocket and starts read/write pumps.
 func handleWS(w http.ResponseWriter, r *http.Request) {
+	if !wsOriginAllowed(r) {
+		log.Printf("[WS] Refused a dashboard from origin %s", r.Header.Get("Origin"))
+		writeProblem(w, r, http.StatusForbidden, fmt.Sprintf("origin %s is not allowed by -cors-origins", r.Header.Get("Origin")))
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("[WS] Upgrade error: %v", err)
@@ -115,8 +213,12 @@ func handleWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &wsClient{
-		conn: conn,
-		send: make(chan []byte, 64),
+		conn:        conn,
+		addr:        r.RemoteAddr,
+		connectedAt: time.Now(),
+		binary:      conn.Subprotocol() == wsProtocolCBOR,
+		queue:       newWSQueue(),
+		done:        make(chan struct{}),
 	}
 	hub.register(client)
 
@@ -128,7 +230,21 @@ func handleWS(w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
-// sendInitialState pushes the full mesh state to a newly connected client.
+// framing names the framing the client's events go out in.
+func (c *wsClient) framing() string {
+	if c.binary {
+		return "cbor"
+	}
+	return "json"
+}
+
+// snapshot queues a marshalled event of the initial state.
+func (c *wsClient) snapshot(key string, data []byte) {
+	c.queue.push(key, (&wsFrame{json: data}).of(c), true)
+}
+
+// sendInitialState pushes the full mesh state to a newly connected client,
+// however many events that takes.
 func sendInitialState(client *wsClient) {
 	// Send all nodes
 	nodes := registry.AllNodes()
@@ -143,35 +259,43 @@ func sendInitialState(client *wsClient) {
 				ActiveTasks:  node.ActiveTasks,
 				Models:       node.Models,
 				Capabilities: node.Capabilities,
+				Draining:     node.Draining,
+				Canary:       node.Canary,
+				Health:       node.Health,
+				HealthReason: node.HealthReason,
+				Version:      node.Version,
 			},
+			Version: shared.Version,
 		}
 		data, _ := json.Marshal(evt)
-		select {
-		case client.send <- data:
-		default:
-		}
+		client.snapshot("", data)
+	}
+	for _, node := range inventory.absent(nodes) {
+		data, _ := json.Marshal(shared.MeshEvent{
+			Type:      "node_absent",
+			Timestamp: time.Now().UnixMilli(),
+			Data:      absentEvent(node),
+		})
+		client.snapshot("", data)
+	}
+	firing, _ := alerts.list()
+	for _, a := range firing {
+		data, _ := json.Marshal(shared.MeshEvent{
+			Type:      "alert",
+			Timestamp: time.Now().UnixMilli(),
+			Data:      a,
+		})
+		client.snapshot("", data)
 	}
 
 	// Send current stats
-	avgLat := float64(0)
-	if cnt := atomic.LoadInt64(&latencyCount); cnt > 0 {
-		avgLat = float64(atomic.LoadInt64(&latencySum)) / float64(cnt)
-	}
 	statsEvt := shared.MeshEvent{
 		Type:      "stats",
 		Timestamp: time.Now().UnixMilli(),
-		Data: shared.DashboardStats{
-			TotalTasks:     atomic.LoadInt64(&totalTasks),
-			TotalPipelines: atomic.LoadInt64(&totalPipelines),
-			AvgLatencyMs:   avgLat,
-			UptimeSecs:     int64(time.Since(startTime).Seconds()),
-		},
+		Data:      currentStats(),
 	}
 	data, _ := json.Marshal(statsEvt)
-	select {
-	case client.send <- data:
-	default:
-	}
+	client.snapshot(coalesceKey(statsEvt), data)
 }
 
 // ─── Read/Write pumps ─────────────────────────────────────────────────────────
@@ -194,24 +318,39 @@ func (c *wsClient) readPump() {
 	}
 }
 
-// writePump sends messages from the send channel to the WebSocket.
+// writePump writes the client's queued events to the WebSocket.
 func (c *wsClient) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.done)
 	}()
+	messageType := websocket.TextMessage
+	if c.binary {
+		messageType = websocket.BinaryMessage
+	}
 
 	for {
 		select {
-		case msg, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				return
+		case <-c.queue.wake:
+			for {
+				msg, ok, closed := c.queue.pop()
+				if closed {
+					c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+					return
+				}
+				if !ok {
+					break
+				}
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.conn.WriteMessage(messageType, msg); err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						dropSlow(c)
+					}
+					return
+				}
 			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -225,19 +364,22 @@ func (c *wsClient) writePump() {
 // ─── Event emitters — called from task/pipeline handlers ──────────────────────
 
 // EmitTaskRouted broadcasts that a task has been routed to a node.
-func EmitTaskRouted(taskID string, taskType shared.TaskType, routedTo string, prompt string) {
+func EmitTaskRouted(req shared.TaskRequest, routedTo string) {
 	atomic.AddInt64(&totalTasks, 1)
+	prompt := req.Prompt
 	if len(prompt) > 120 {
 		prompt = prompt[:120] + "…"
 	}
-	hub.Broadcast(shared.MeshEvent{
+	events.Publish(shared.MeshEvent{
 		Type:      "task_routed",
 		Timestamp: time.Now().UnixMilli(),
 		Data: shared.TaskEvent{
-			TaskID:   taskID,
-			TaskType: taskType,
+			TaskID:   req.TaskID,
+			TaskType: req.Type,
 			RoutedTo: routedTo,
 			Prompt:   prompt,
+			Source:   req.Source,
+			Metadata: req.Metadata,
 		},
 	})
 }
@@ -246,12 +388,17 @@ func EmitTaskRouted(taskID string, taskType shared.TaskType, routedTo string, pr
 func EmitTaskDone(result *shared.TaskResult) {
 	atomic.AddInt64(&latencySum, result.LatencyMs)
 	atomic.AddInt64(&latencyCount, 1)
+	atomic.AddInt64(&promptTokens, int64(result.PromptTokens))
+	atomic.AddInt64(&outputTokens, int64(result.CompletionTokens))
+	statsSeries.RecordTask(result)
+	shares.remember(result)
+	workloadLog.record(result)
 
 	content := result.Content
 	if len(content) > 200 {
 		content = content[:200] + "…"
 	}
-	hub.Broadcast(shared.MeshEvent{
+	events.Publish(shared.MeshEvent{
 		Type:      "task_done",
 		Timestamp: time.Now().UnixMilli(),
 		Data: shared.TaskEvent{
@@ -263,54 +410,139 @@ func EmitTaskDone(result *shared.TaskResult) {
 			LatencyMs: result.LatencyMs,
 			Success:   result.Success,
 			Error:     result.Error,
+			Source:    result.Source,
+			Metadata:  result.Metadata,
 		},
 	})
 }
 
+// EmitTaskFailover broadcasts that a task's attempt on a node failed and
+// the task is being retried elsewhere.
+func EmitTaskFailover(ev shared.FailoverEvent) {
+	events.Publish(shared.MeshEvent{
+		Type:      "task_failover",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      ev,
+	})
+}
+
 // EmitNodeRegistered broadcasts that a node has registered.
 func EmitNodeRegistered(req shared.RegisterRequest) {
-	hub.Broadcast(shared.MeshEvent{
+	ev := shared.NodeEvent{
+		NodeID:       req.NodeID,
+		AgentPort:    req.AgentPort,
+		Status:       shared.StatusIdle,
+		Models:       req.Models,
+		Capabilities: req.Capabilities,
+		Canary:       req.Canary,
+		Version:      req.Version,
+	}
+	if node, err := registry.GetNode(req.NodeID); err == nil {
+		ev.Health, ev.HealthReason = node.Health, node.HealthReason
+	}
+	events.Publish(shared.MeshEvent{
 		Type:      "node_registered",
 		Timestamp: time.Now().UnixMilli(),
-		Data: shared.NodeEvent{
-			NodeID:       req.NodeID,
-			AgentPort:    req.AgentPort,
-			Status:       shared.StatusIdle,
-			Models:       req.Models,
-			Capabilities: req.Capabilities,
-		},
+		Data:      ev,
 	})
 }
 
-// EmitNodeStatus broadcasts a node status update (from heartbeat).
-func EmitNodeStatus(nodeID string, status shared.NodeStatus, activeTasks int) {
-	hub.Broadcast(shared.MeshEvent{
+// EmitNodeStatus broadcasts a node status update (from heartbeat). node,
+// when known, supplies the timings and health grade.
+func EmitNodeStatus(nodeID string, status shared.NodeStatus, activeTasks int, node *shared.NodeInfo) {
+	ev := shared.NodeEvent{
+		NodeID:      nodeID,
+		Status:      status,
+		ActiveTasks: activeTasks,
+	}
+	if node != nil {
+		ev.Timings, ev.Health, ev.HealthReason = node.Timings, node.Health, node.HealthReason
+		ev.LoadedMemory, ev.Link = node.LoadedMemory, node.Link
+	}
+	events.Publish(shared.MeshEvent{
 		Type:      "node_status",
 		Timestamp: time.Now().UnixMilli(),
-		Data: shared.NodeEvent{
-			NodeID:      nodeID,
-			Status:      status,
-			ActiveTasks: activeTasks,
-		},
+		Data:      ev,
+	})
+}
+
+// EmitCapabilityChanged broadcasts a node's models changing through a
+// heartbeat.
+func EmitCapabilityChanged(ev shared.CapabilityEvent) {
+	events.Publish(shared.MeshEvent{
+		Type:      "capability_changed",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      ev,
+	})
+}
+
+// EmitNodeAdmin broadcasts an operator draining or undraining a node.
+func EmitNodeAdmin(nodeID string, draining bool) {
+	events.Publish(shared.MeshEvent{
+		Type:      "node_admin",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      shared.NodeEvent{NodeID: nodeID, Draining: draining},
+	})
+}
+
+// EmitNodeAbsent broadcasts that a node in the inventory hasn't joined.
+func EmitNodeAbsent(node *shared.NodeInfo) {
+	events.Publish(shared.MeshEvent{
+		Type:      "node_absent",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      absentEvent(node),
+	})
+}
+
+// absentEvent describes an absent inventory node.
+func absentEvent(node *shared.NodeInfo) shared.NodeEvent {
+	return shared.NodeEvent{
+		NodeID:       node.NodeID,
+		AgentPort:    node.AgentPort,
+		Status:       node.Status,
+		Models:       node.Models,
+		Health:       node.Health,
+		HealthReason: node.HealthReason,
+	}
+}
+
+// EmitAlert broadcasts that an alert fired or resolved.
+func EmitAlert(a shared.Alert) {
+	events.Publish(shared.MeshEvent{
+		Type:      "alert",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      a,
+	})
+}
+
+// EmitNodeEvicted broadcasts that an operator removed a node from the registry.
+func EmitNodeEvicted(nodeID string) {
+	events.Publish(shared.MeshEvent{
+		Type:      "node_evicted",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      shared.NodeEvent{NodeID: nodeID},
 	})
 }
 
 // EmitPipelineStarted broadcasts that a pipeline has started.
-func EmitPipelineStarted(pipelineID string, totalSteps int) {
+func EmitPipelineStarted(pipelineID string, totalSteps int, source *shared.TaskSource, metadata map[string]string) {
 	atomic.AddInt64(&totalPipelines, 1)
-	hub.Broadcast(shared.MeshEvent{
+	statsSeries.RecordPipeline()
+	events.Publish(shared.MeshEvent{
 		Type:      "pipeline_started",
 		Timestamp: time.Now().UnixMilli(),
 		Data: shared.PipelineEvent{
 			PipelineID: pipelineID,
 			TotalSteps: totalSteps,
+			Source:     source,
+			Metadata:   metadata,
 		},
 	})
 }
 
 // EmitPipelineDone broadcasts that a pipeline has completed.
 func EmitPipelineDone(result *shared.PipelineResult) {
-	hub.Broadcast(shared.MeshEvent{
+	events.Publish(shared.MeshEvent{
 		Type:      "pipeline_done",
 		Timestamp: time.Now().UnixMilli(),
 		Data: shared.PipelineEvent{
@@ -319,26 +551,78 @@ func EmitPipelineDone(result *shared.PipelineResult) {
 			LatencyMs:  result.LatencyMs,
 			Success:    result.Success,
 			Error:      result.Error,
+			RunURL:     runURL(result.PipelineID),
+			Source:     result.Source,
+			Metadata:   result.Metadata,
+		},
+	})
+}
+
+// EmitPipelineResumed broadcasts that an interrupted pipeline resumed after
+// a restart, at step from (0-based), with recovered steps collected from
+// nodes.
+func EmitPipelineResumed(req shared.PipelineRequest, from, recovered int) {
+	events.Publish(shared.MeshEvent{
+		Type:      "pipeline_resumed",
+		Timestamp: time.Now().UnixMilli(),
+		Data: shared.PipelineEvent{
+			PipelineID: req.PipelineID,
+			TotalSteps: len(req.Steps),
+			StepIndex:  from,
+			Recovered:  recovered,
+			RunURL:     runURL(req.PipelineID),
+			Source:     req.Source,
+			Metadata:   req.Metadata,
+		},
+	})
+}
+
+// EmitAliasRollout broadcasts a step of an alias rollout; a is the alias
+// as it stood with the rollout in place.
+func EmitAliasRollout(a shared.ModelAlias, action string) {
+	ro := a.Rollout
+	events.Publish(shared.MeshEvent{
+		Type:      "alias_rollout",
+		Timestamp: time.Now().UnixMilli(),
+		Data: shared.AliasEvent{
+			Alias:     a.Name,
+			From:      a.Target,
+			To:        ro.Target,
+			Action:    action,
+			Percent:   ro.Percent,
+			Reason:    ro.Reason,
+			Stable:    &ro.Stable,
+			Candidate: &ro.Candidate,
 		},
 	})
 }
 
 // EmitStats broadcasts updated dashboard stats (called periodically).
 func EmitStats() {
+	events.Publish(shared.MeshEvent{
+		Type:      "stats",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      currentStats(),
+	})
+}
+
+// currentStats snapshots the dashboard counters.
+func currentStats() shared.DashboardStats {
 	avgLat := float64(0)
 	if cnt := atomic.LoadInt64(&latencyCount); cnt > 0 {
 		avgLat = float64(atomic.LoadInt64(&latencySum)) / float64(cnt)
 	}
-	hub.Broadcast(shared.MeshEvent{
-		Type:      "stats",
-		Timestamp: time.Now().UnixMilli(),
-		Data: shared.DashboardStats{
-			TotalTasks:     atomic.LoadInt64(&totalTasks),
-			TotalPipelines: atomic.LoadInt64(&totalPipelines),
-			AvgLatencyMs:   avgLat,
-			UptimeSecs:     int64(time.Since(startTime).Seconds()),
-		},
-	})
+	return shared.DashboardStats{
+		TotalTasks:            atomic.LoadInt64(&totalTasks),
+		TotalPipelines:        atomic.LoadInt64(&totalPipelines),
+		AvgLatencyMs:          avgLat,
+		UptimeSecs:            int64(time.Since(startTime).Seconds()),
+		TotalPromptTokens:     atomic.LoadInt64(&promptTokens),
+		TotalCompletionTokens: atomic.LoadInt64(&outputTokens),
+		TotalSentBytes:        atomic.LoadInt64(&sentBytes),
+		TotalReceivedBytes:    atomic.LoadInt64(&receivedBytes),
+		WarmHitRate:           warmHitRate(),
+	}
 }
 
 // StartStatsBroadcast starts a goroutine that sends stats every 3 seconds.
@@ -0,0 +1,250 @@
+// orchestrator/weights.go
+// Tunable routing weights.
+//
+// Capability tier and busy state still come first when ranking candidates;
+// among nodes equal on both, routing orders by a weighted score of five
+// signals, each normalized to 0..1 with lower being better:
+//
+//	latency     the node's smoothed task latency / the slowest candidate's
+//	load        active tasks / effective busy threshold (taken slots / total
+//	            for nodes that declare slots)
+//	reputation  1 - the node's smoothed success rate
+//	locality    1 if the agent isn't on the orchestrator's host, else 0
+//	link        the agent's link to the orchestrator: the worst of round
+//	            trip, jitter and loss against their poor thresholds (see
+//	            link.go), 0 if it doesn't report one
+//
+// The default weights (load only) reproduce the classic least-loaded
+// ordering. Operators change them with PUT /admin/routing/weights; the
+// change applies to the next routing decision and is saved, together with
+// the routing strategy, the pipeline schedule and the fair share settings
+// (see fairshare.go), to <data-dir>/routing.json.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"echo-system/shared"
+)
+
+const (
+	// reputationAlpha is the EWMA factor for node success rates.
+	reputationAlpha = 0.1
+	// nodeLatencyAlpha is the EWMA factor for node latency.
+	nodeLatencyAlpha = 0.2
+)
+
+var defaultWeights = shared.RoutingWeights{Load: 1}
+
+var (
+	weights           atomic.Pointer[shared.RoutingWeights]
+	routingConfigPath string     // "" until loadRoutingConfig; nothing is saved
+	routingConfigMu   sync.Mutex // serializes saves
+)
+
+// currentWeights returns the active routing weights.
+func currentWeights() shared.RoutingWeights {
+	if w := weights.Load(); w != nil {
+		return *w
+	}
+	return defaultWeights
+}
+
+// routingFile is the persisted routing configuration.
+type routingFile struct {
+	Strategy  shared.RoutingStrategy   `json:"strategy"`
+	Weights   shared.RoutingWeights    `json:"weights"`
+	Pipelines *shared.PipelineSchedule `json:"pipelines,omitempty"`
+	FairShare *shared.FairShareConfig  `json:"fair_share,omitempty"`
+}
+
+// loadRoutingConfig restores the strategy, weights, pipeline schedule and
+// fair share settings saved in dataDir.
+func loadRoutingConfig(dataDir string) {
+	routingConfigPath = filepath.Join(dataDir, "routing.json")
+	raw, err := os.ReadFile(routingConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Routing] Failed to read %s: %v", routingConfigPath, err)
+		}
+		return
+	}
+	var f routingFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		log.Printf("[Routing] Ignoring unreadable %s: %v", routingConfigPath, err)
+		return
+	}
+	if f.Strategy != "" {
+		strategy.Store(f.Strategy)
+	}
+	if err := validateWeights(f.Weights); err == nil {
+		weights.Store(&f.Weights)
+	}
+	if f.Pipelines != nil && validateSchedule(*f.Pipelines) == nil {
+		pipelineSched.set(*f.Pipelines)
+	}
+	if f.FairShare != nil && validateFairShare(*f.FairShare) == nil {
+		fairShare.set(*f.FairShare)
+	}
+	log.Printf("[Routing] Restored strategy=%s weights=%+v pipelines=%s/%d",
+		currentStrategy(), currentWeights(), pipelineSched.get().Policy, pipelineSched.get().Slots)
+}
+
+// saveRoutingConfig persists the current strategy, weights, pipeline
+// schedule and fair share settings (write temp + rename).
+func saveRoutingConfig() {
+	if routingConfigPath == "" {
+		return
+	}
+	routingConfigMu.Lock()
+	defer routingConfigMu.Unlock()
+
+	schedule := pipelineSched.get()
+	schedule.Running, schedule.Waiting = 0, 0
+	fair := fairShare.config()
+	data, _ := json.MarshalIndent(routingFile{Strategy: currentStrategy(), Weights: currentWeights(), Pipelines: &schedule, FairShare: &fair}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(routingConfigPath), 0o755); err != nil {
+		log.Printf("[Routing] Failed to save routing config: %v", err)
+		return
+	}
+	tmp := routingConfigPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("[Routing] Failed to save routing config: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, routingConfigPath); err != nil {
+		log.Printf("[Routing] Failed to save routing config: %v", err)
+	}
+}
+
+func validateWeights(w shared.RoutingWeights) error {
+	for name, v := range map[string]float64{
+		"latency": w.Latency, "load": w.Load, "reputation": w.Reputation, "locality": w.Locality, "link": w.Link,
+	} {
+		if v < 0 || v > 100 || math.IsNaN(v) {
+			return fmt.Errorf("weight %s must be between 0 and 100, got %v", name, v)
+		}
+	}
+	return nil
+}
+
+// ─── Scoring ──────────────────────────────────────────────────────────────────
+
+// nodeScore is a candidate's weighted routing score; lower is better.
+// maxLatency is the highest AvgLatencyMs among the candidates.
+func nodeScore(w shared.RoutingWeights, node *shared.NodeInfo, model string, maxLatency float64) float64 {
+	score := w.Load * nodeLoad(node, model)
+	if w.Latency > 0 && maxLatency > 0 {
+		score += w.Latency * node.AvgLatencyMs / maxLatency
+	}
+	score += w.Reputation * (1 - node.Reputation)
+	if !node.Local {
+		score += w.Locality
+	}
+	if w.Link > 0 {
+		score += w.Link * linkPenalty(node)
+	}
+	return score
+}
+
+// nodeLoad is how full a node is for model, from 0 (idle) up.
+func nodeLoad(node *shared.NodeInfo, model string) float64 {
+	if s := modelSlots(node, model); s != nil && s.Total > 0 {
+		return float64(s.Total-s.Free) / float64(s.Total)
+	}
+	threshold := node.EffectiveBusyThreshold
+	if threshold <= 0 {
+		threshold = defaultBusyThreshold
+	}
+	return float64(node.ActiveTasks) / float64(threshold)
+}
+
+// recordOutcome folds a task outcome into the node's reputation and
+// recent failure rate and, on success, its smoothed latency. Must be called with the shard's write
+// lock held.
+func recordOutcome(node *shared.NodeInfo, success bool, latencyMs int64) {
+	outcome := 0.0
+	if !success {
+		node.LastFailureAt = time.Now().UnixMilli()
+	}
+	if success {
+		outcome = 1
+		if node.AvgLatencyMs == 0 {
+			node.AvgLatencyMs = float64(latencyMs)
+		} else {
+			node.AvgLatencyMs = nodeLatencyAlpha*float64(latencyMs) + (1-nodeLatencyAlpha)*node.AvgLatencyMs
+		}
+	}
+	node.Reputation = reputationAlpha*outcome + (1-reputationAlpha)*node.Reputation
+	node.FailureRate = failureRateAlpha*(1-outcome) + (1-failureRateAlpha)*node.FailureRate
+}
+
+// ─── Locality ─────────────────────────────────────────────────────────────────
+
+var (
+	localAddrsOnce sync.Once
+	localAddrs     map[string]bool
+)
+
+// isLocalHost reports whether host names the orchestrator's own machine.
+func isLocalHost(host string) bool {
+	if _, ok := shared.SocketPath(host); ok || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	localAddrsOnce.Do(func() {
+		localAddrs = make(map[string]bool)
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				localAddrs[ipnet.IP.String()] = true
+			}
+		}
+	})
+	return localAddrs[ip.String()]
+}
+
+// ─── Admin: GET/PUT /admin/routing/weights ────────────────────────────────────
+
+func handleGetWeights(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentWeights())
+}
+
+// handleSetWeights updates the weights; fields left out keep their value.
+func handleSetWeights(w http.ResponseWriter, r *http.Request) {
+	next := currentWeights()
+	if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateWeights(next); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	weights.Store(&next)
+	saveRoutingConfig()
+	log.Printf("[Admin] Routing weights set to %+v", next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(next)
+}
@@ -0,0 +1,267 @@
+// orchestrator/work.go
+// Pull-mode dispatch for agents the orchestrator can't connect to.
+//
+// An agent behind NAT or a firewall registers with "pull": true. Routing
+// treats it like any other node, but instead of POSTing to the agent's
+// /execute, forwardTask queues the task for it and waits: the agent
+// long-polls GET /work?node_id=... for its next task and posts the result
+// to POST /results/ingest. Streamed tasks routed to a pull node get the
+// whole reply as one chunk. Tasks whose caller gave up (timeout,
+// disconnect, the node failed over) are dropped before hand-out, and
+// results arriving for them are refused. So is a result whose content
+// doesn't match its checksum, with 422; the agent posts it again.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"echo-system/shared"
+)
+
+const (
+	// defaultWorkWait is how long GET /work holds the request open when
+	// no task is queued; maxWorkWait caps the agent's ?wait=.
+	defaultWorkWait = 25 * time.Second
+	maxWorkWait     = 60 * time.Second
+
+	// workQueueDepth is how many tasks can wait for one pull-mode node.
+	workQueueDepth = 64
+)
+
+var work = &workQueue{
+	queues:  make(map[string]chan *workItem),
+	pending: make(map[string]*workItem),
+}
+
+// workItem is a queued or handed-out task of a pull-mode node.
+type workItem struct {
+	id     string
+	nodeID string
+	req    shared.TaskRequest
+	ctx    context.Context        // the dispatching caller's; done means nobody waits any more
+	result chan shared.TaskResult // buffered; receives the ingested result
+}
+
+// workQueue holds each pull-mode node's queued tasks, and every task
+// awaiting its result by work ID.
+type workQueue struct {
+	mu      sync.Mutex
+	queues  map[string]chan *workItem // keyed by node_id
+	pending map[string]*workItem      // keyed by work_id
+}
+
+// queue returns nodeID's task queue, creating it on first use.
+func (q *workQueue) queue(nodeID string) chan *workItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ch, ok := q.queues[nodeID]
+	if !ok {
+		ch = make(chan *workItem, workQueueDepth)
+		q.queues[nodeID] = ch
+	}
+	return ch
+}
+
+// queued counts the tasks waiting to be fetched, across all nodes.
+func (q *workQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, ch := range q.queues {
+		n += len(ch)
+	}
+	return n
+}
+
+// dispatch queues req for a pull-mode node and waits for the agent to
+// post its result.
+func (q *workQueue) dispatch(ctx context.Context, node *shared.NodeInfo, req shared.TaskRequest) (*shared.TaskResult, error) {
+	item := &workItem{
+		id:     uuid.New().String(),
+		nodeID: node.NodeID,
+		req:    req,
+		ctx:    ctx,
+		result: make(chan shared.TaskResult, 1),
+	}
+	q.mu.Lock()
+	q.pending[item.id] = item
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, item.id)
+		q.mu.Unlock()
+	}()
+
+	select {
+	case q.queue(node.NodeID) <- item:
+	default:
+		return nil, fmt.Errorf("work queue of pull-mode node %s is full (%d tasks)", node.NodeID, workQueueDepth)
+	}
+
+	// A node that goes offline won't post its result; stop waiting so the
+	// task can fail over
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-item.result:
+			if !result.Success && !result.Partial {
+				return nil, &agentTaskError{Code: result.ErrorCode, Model: result.ModelUsed, Message: result.Error}
+			}
+			return &result, nil
+		case <-ticker.C:
+			if _, err := registry.GetNode(node.NodeID); err != nil {
+				return nil, fmt.Errorf("pull-mode node %s lost before returning a result: %w", node.NodeID, err)
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("pull-mode node %s didn't return a result: %w", node.NodeID, ctx.Err())
+		}
+	}
+}
+
+// dispatchStream runs a streamed task on a pull-mode node, relaying the
+// whole reply as one chunk.
+func (q *workQueue) dispatchStream(ctx context.Context, node *shared.NodeInfo, req shared.TaskRequest, onChunk func(shared.TaskChunk)) error {
+	result, err := q.dispatch(ctx, node, req)
+	if err != nil {
+		return err
+	}
+	onChunk(shared.TaskChunk{TaskID: req.TaskID, Token: result.Content})
+	if result.Partial {
+		return errPartialResult
+	}
+	onChunk(shared.TaskChunk{TaskID: req.TaskID, Done: true, Timings: result.Timings})
+	return nil
+}
+
+// next waits up to wait for a task for nodeID, skipping ones nobody
+// waits for any more. ok is false when none came.
+func (q *workQueue) next(ctx context.Context, nodeID string, wait time.Duration) (item *workItem, ok bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ch := q.queue(nodeID)
+	for {
+		select {
+		case item := <-ch:
+			if item.ctx.Err() != nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				// The agent hung up as the task arrived; leave it for the next poll
+				select {
+				case ch <- item:
+				default:
+				}
+				return nil, false
+			}
+			return item, true
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// ingest delivers a posted result to the task waiting for it.
+func (q *workQueue) ingest(res shared.WorkResult) error {
+	q.mu.Lock()
+	item, ok := q.pending[res.WorkID]
+	q.mu.Unlock()
+	switch {
+	case !ok:
+		return fmt.Errorf("work %q is not awaiting a result (timed out or unknown)", res.WorkID)
+	case item.nodeID != res.NodeID:
+		return fmt.Errorf("work %q was handed to %s, not %s", res.WorkID, item.nodeID, res.NodeID)
+	}
+	select {
+	case item.result <- res.Result:
+		return nil
+	default:
+		return fmt.Errorf("work %q already has a result", res.WorkID)
+	}
+}
+
+// ─── Agent: GET /work ─────────────────────────────────────────────────────────
+// Long-polls for the node's next task: 200 with a WorkItem, or 204 when
+// nothing arrived within ?wait= (default 25s, max 60s).
+
+func handleWork(w http.ResponseWriter, r *http.Request) {
+	nodeID := r.URL.Query().Get("node_id")
+	if nodeID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "node_id is required")
+		return
+	}
+	if !requireSession(w, r, nodeID) {
+		return
+	}
+	node, err := registry.GetNode(nodeID)
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if !node.Pull {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("node %q isn't registered in pull mode", nodeID))
+		return
+	}
+	wait := defaultWorkWait
+	if s := r.URL.Query().Get("wait"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs < 0 {
+			writeProblem(w, r, http.StatusBadRequest, "wait must be a number of seconds")
+			return
+		}
+		wait = min(time.Duration(secs)*time.Second, maxWorkWait)
+	}
+
+	item, ok := work.next(r.Context(), nodeID, wait)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	req := item.req
+	if deadline, ok := item.ctx.Deadline(); ok {
+		if left := time.Until(deadline) - agentTimeoutMargin; left > 0 {
+			req.TimeoutMs = left.Milliseconds()
+		}
+	}
+	log.Printf("[Work] Task %s handed to pull-mode node %s", req.TaskID, nodeID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shared.WorkItem{WorkID: item.id, Request: req, Headers: passedHeaders(item.ctx)})
+}
+
+// ─── Agent: POST /results/ingest ──────────────────────────────────────────────
+
+func handleIngestResult(w http.ResponseWriter, r *http.Request) {
+	var res shared.WorkResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !requireSession(w, r, res.NodeID) {
+		return
+	}
+	if !res.Result.ChecksumOK() {
+		log.Printf("[Orchestrator] Result of task %s from %s doesn't match its checksum (%d chars) — refusing it",
+			res.Result.TaskID, res.NodeID, len(res.Result.Content))
+		writeProblem(w, r, http.StatusUnprocessableEntity, "result content doesn't match its checksum")
+		return
+	}
+	res.Result.Checksum = ""
+	if err := work.ingest(res); err != nil {
+		writeProblem(w, r, http.StatusGone, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+}
@@ -0,0 +1,110 @@
+// orchestrator/workload.go
+// Recorded workloads, for replaying against the simulated mesh.
+//
+// With -record-workload the orchestrator appends a line of JSON to a file
+// for every task it finishes: when it arrived, its type and how many
+// tokens went in and came out. That's what -simulate-workload replays
+// against a virtual mesh (see simulate.go), so a scheduler can be tried
+// on last week's traffic instead of made-up traffic. Prompts and outputs
+// aren't recorded, and neither are deduplicated tasks, which cost the
+// mesh nothing.
+//
+//	{"at_ms":1760600000000,"type":"text","prompt_tokens":412,"completion_tokens":230}
+//
+// A hand-written workload may also set model_hint; recorded tasks are
+// replayed by type.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"echo-system/shared"
+)
+
+// workloadTask is one task of a workload file.
+type workloadTask struct {
+	AtMs             int64           `json:"at_ms"` // when it arrived, Unix ms
+	Type             shared.TaskType `json:"type"`
+	ModelHint        string          `json:"model_hint,omitempty"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+}
+
+// workloadRecorder appends finished tasks to the -record-workload file.
+type workloadRecorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// workloadLog is nil without -record-workload.
+var workloadLog *workloadRecorder
+
+// openWorkloadRecorder opens path for appending.
+func openWorkloadRecorder(path string) (*workloadRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening workload recording: %w", err)
+	}
+	return &workloadRecorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record appends a finished task. Safe on a nil recorder.
+func (w *workloadRecorder) record(result *shared.TaskResult) {
+	if w == nil || result.Deduplicated {
+		return
+	}
+	task := workloadTask{
+		AtMs:             time.Now().UnixMilli() - result.LatencyMs,
+		Type:             result.TaskType,
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(task); err != nil {
+		log.Printf("[Workload] Failed to record task %s: %v", result.TaskID, err)
+	}
+}
+
+// loadWorkload reads a workload file, sorted by arrival.
+func loadWorkload(path string) ([]workloadTask, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading workload: %w", err)
+	}
+	defer f.Close()
+
+	var tasks []workloadTask
+	lines := bufio.NewScanner(f)
+	lines.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; lines.Scan(); n++ {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		var t workloadTask
+		if err := json.Unmarshal(lines.Bytes(), &t); err != nil {
+			return nil, fmt.Errorf("workload %s line %d: %w", path, n, err)
+		}
+		if t.Type == "" {
+			t.Type = shared.TaskTypeText
+		}
+		tasks = append(tasks, t)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("reading workload %s: %w", path, err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("workload %s has no tasks", path)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].AtMs < tasks[j].AtMs })
+	return tasks, nil
+}
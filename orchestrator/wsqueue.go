@@ -0,0 +1,188 @@
+// orchestrator/wsqueue.go
+// Per-dashboard event queues, and shedding load when a dashboard can't
+// keep up.
+//
+// Each dashboard has a bounded queue its write pump drains. Events that
+// only describe current state are coalesced: a stats event replaces the
+// one still queued, and a node_status event the one still queued for the
+// same node, so a slow dashboard skips straight to the latest state
+// instead of replaying every step. Other events are dropped while the
+// queue is full, and counted. A dashboard that fills its queue and doesn't
+// work it down to half within wsSlowGrace, or that hasn't taken an event
+// in 10s, is disconnected with close code 1013 (try again later); it
+// reconnects and starts over from a fresh snapshot. GET /debug/dashboards
+// lists the connected dashboards with their counters.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"echo-system/shared"
+)
+
+const (
+	// wsQueueSize is how many events may wait for a dashboard.
+	wsQueueSize = 64
+
+	// wsSlowGrace is how long a dashboard may take to work a full queue
+	// down to half before it's disconnected.
+	wsSlowGrace = 10 * time.Second
+)
+
+// slowDisconnects counts dashboards disconnected for falling behind.
+var slowDisconnects int64
+
+// wsMessage is a queued event; key is its coalesceKey.
+type wsMessage struct {
+	key  string
+	data []byte
+}
+
+// wsQueue is a dashboard's outgoing events.
+type wsQueue struct {
+	mu        sync.Mutex
+	messages  []wsMessage
+	closed    bool          // nothing more is queued; the pump stops once it's written the rest
+	fullSince time.Time     // when the queue filled up, zero once it's down to half
+	wake      chan struct{} // signalled when something is queued or it's closed
+
+	dropped   int64
+	coalesced int64
+}
+
+func newWSQueue() *wsQueue {
+	return &wsQueue{wake: make(chan struct{}, 1)}
+}
+
+// push queues data, coalescing it with a queued event of the same key.
+// A snapshot push ignores the size limit. slow reports that the queue
+// filled up more than wsSlowGrace ago and is still over half full.
+func (q *wsQueue) push(key string, data []byte, snapshot bool) (slow bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if key != "" {
+		for i := range q.messages {
+			if q.messages[i].key == key {
+				q.messages[i].data = data
+				q.coalesced++
+				return false
+			}
+		}
+	}
+	if !snapshot && len(q.messages) >= wsQueueSize {
+		q.dropped++
+		if q.fullSince.IsZero() {
+			q.fullSince = time.Now()
+		}
+		return time.Since(q.fullSince) > wsSlowGrace
+	}
+	q.messages = append(q.messages, wsMessage{key: key, data: data})
+	q.signal()
+	return false
+}
+
+// pop takes the next event; ok is false when there's none. closed reports
+// that none will follow.
+func (q *wsQueue) pop() (data []byte, ok, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.messages) == 0 {
+		return nil, false, q.closed
+	}
+	data = q.messages[0].data
+	q.messages[0] = wsMessage{}
+	q.messages = q.messages[1:]
+	if len(q.messages) <= wsQueueSize/2 {
+		q.fullSince = time.Time{}
+	}
+	return data, true, false
+}
+
+// close stops queueing; what's queued is still written.
+func (q *wsQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.signal()
+}
+
+// signal wakes the write pump; call with q.mu held.
+func (q *wsQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// coalesceKey is the key under which a newer event replaces a queued one,
+// "" for events that are never coalesced.
+func coalesceKey(event shared.MeshEvent) string {
+	switch event.Type {
+	case "stats":
+		return "stats/" + event.Replica
+	case "node_status":
+		var nodeID string
+		switch data := event.Data.(type) {
+		case shared.NodeEvent:
+			nodeID = data.NodeID
+		case map[string]any: // relayed from another replica
+			nodeID, _ = data["node_id"].(string)
+		}
+		if nodeID != "" {
+			return "node_status/" + event.Replica + "/" + nodeID
+		}
+	}
+	return ""
+}
+
+// dropSlow disconnects a dashboard that has stopped keeping up.
+func dropSlow(c *wsClient) {
+	c.slowOnce.Do(func() {
+		atomic.AddInt64(&slowDisconnects, 1)
+		c.queue.mu.Lock()
+		dropped := c.queue.dropped
+		c.queue.mu.Unlock()
+		log.Printf("[WS] Dashboard client %s too slow (%d events dropped) — disconnecting", c.addr, dropped)
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.conn.Close()
+	})
+}
+
+// ─── GET /debug/dashboards ────────────────────────────────────────────────────
+
+// dashboardClient is one connected dashboard's queue state.
+type dashboardClient struct {
+	RemoteAddr  string `json:"remote_addr"`
+	ConnectedAt int64  `json:"connected_at"` // Unix ms
+	Queued      int    `json:"queued"`       // events waiting to be written
+	Dropped     int64  `json:"dropped"`      // events dropped while the queue was full
+	Coalesced   int64  `json:"coalesced"`    // stats/node_status events merged into a queued one
+	Framing     string `json:"framing"`      // json | cbor (see cbor.go)
+}
+
+type dashboardList struct {
+	Clients         []dashboardClient `json:"clients"`
+	SlowDisconnects int64             `json:"slow_disconnects"` // since startup
+}
+
+// handleListDashboards shows the connected dashboards' queues.
+// GET /debug/dashboards
+func handleListDashboards(w http.ResponseWriter, r *http.Request) {
+	list := dashboardList{Clients: hub.clientStats(), SlowDisconnects: atomic.LoadInt64(&slowDisconnects)}
+	sort.Slice(list.Clients, func(i, j int) bool { return list.Clients[i].ConnectedAt < list.Clients[j].ConnectedAt })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
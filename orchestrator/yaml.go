@@ -0,0 +1,573 @@
+// orchestrator/yaml.go
+// A YAML reader for pipeline definitions.
+//
+// Multi-line prompt templates are miserable to write inside JSON strings,
+// so pipelines and pipeline templates may also be written in YAML. This
+// reads the part of YAML that such documents use — block mappings and
+// sequences, plain and quoted scalars, literal (|) and folded (>) block
+// scalars with their chomping indicators, flow sequences and mappings on
+// one line, and comments — into the values encoding/json produces, then
+// decodes those like a JSON body, so a YAML pipeline has exactly the
+// fields and rules of the JSON one. Anchors, aliases, tags and multiple
+// documents are refused rather than misread.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// yamlContentTypes are the media types taken as YAML request bodies.
+var yamlContentTypes = []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+
+// isYAML reports whether a request's body is declared as YAML.
+func isYAML(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, t := range yamlContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeYAML decodes a YAML document into v as if it were the JSON
+// document with the same values.
+func decodeYAML(data []byte, v any) error {
+	value, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// ─── Parser ───────────────────────────────────────────────────────────────────
+
+// yamlLine is one line of the document: its indentation and the text after
+// it. A sequence item's content is re-indented to its own column, so
+// "- a: 1" then "  b: 2" reads as one mapping.
+type yamlLine struct {
+	num    int // 1-based, for errors
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses a single YAML document into nil, bool, int64, float64,
+// string, []any and map[string]any values.
+func parseYAML(data []byte) (any, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("YAML must be UTF-8")
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	p := &yamlParser{}
+	started := false
+	for i, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, yamlErrorf(i+1, "tabs can't indent YAML")
+		}
+		switch strings.TrimRight(raw, " \t") {
+		case "---":
+			if started {
+				return nil, yamlErrorf(i+1, "only one document is allowed")
+			}
+			started = true
+			continue
+		case "...":
+			started = true
+			continue
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			started = true
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+	}
+	p.skipBlank()
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	value, err := p.node(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.pos < len(p.lines) {
+		return nil, yamlErrorf(p.lines[p.pos].num, "unexpected indentation")
+	}
+	return value, nil
+}
+
+func yamlErrorf(line int, format string, args ...any) error {
+	return fmt.Errorf("YAML line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank moves past blank and comment-only lines.
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && (p.lines[p.pos].text == "" || strings.HasPrefix(p.lines[p.pos].text, "#")) {
+		p.pos++
+	}
+}
+
+// node parses the block node whose first line is the current one, at
+// indent.
+func (p *yamlParser) node(indent int) (any, error) {
+	line := p.lines[p.pos]
+	if isSeqItem(line.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok, err := splitKey(line); err != nil {
+		return nil, err
+	} else if ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return inlineValue(line.num, line.text)
+}
+
+// sequence parses "- item" lines at indent.
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	list := []any{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := &p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, yamlErrorf(line.num, "expected a sequence item")
+		}
+		if !isSeqItem(line.text) {
+			break // the next key of the mapping the sequence is a value of
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			p.pos++
+			item, err := p.child(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			continue
+		}
+		// The item starts on the dash's line; read it from its own column
+		line.indent += len(line.text) - len(rest)
+		line.text = rest
+		item, err := p.node(line.indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// mapping parses "key: value" lines at indent.
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		key, rest, ok, err := splitKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if line.indent > indent || !ok {
+			return nil, yamlErrorf(line.num, "expected \"key: value\"")
+		}
+		if _, dup := m[key]; dup {
+			return nil, yamlErrorf(line.num, "duplicate key %q", key)
+		}
+		p.pos++
+		var value any
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			value, err = p.child(indent, true)
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.blockScalar(line, rest, indent)
+		default:
+			value, err = inlineValue(line.num, rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// child parses the node nested under a key or dash at indent: a more
+// indented block or, under a key, a sequence at the key's indent. Nothing
+// there is null.
+func (p *yamlParser) child(indent int, key bool) (any, error) {
+	p.skipBlank()
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || (key && next.indent == indent && isSeqItem(next.text)) {
+		return p.node(next.indent)
+	}
+	return nil, nil
+}
+
+// blockScalar reads a literal (|) or folded (>) scalar whose header is on
+// line, nested deeper than indent.
+func (p *yamlParser) blockScalar(line yamlLine, header string, indent int) (string, error) {
+	folded := header[0] == '>'
+	chomp, explicit := byte(0), 0
+	for _, c := range []byte(strings.TrimSpace(strings.SplitN(header[1:], " #", 2)[0])) {
+		switch {
+		case (c == '-' || c == '+') && chomp == 0:
+			chomp = c
+		case c >= '1' && c <= '9' && explicit == 0:
+			explicit = int(c - '0')
+		default:
+			return "", yamlErrorf(line.num, "invalid block scalar header %q", header)
+		}
+	}
+
+	// The block is every following line that is blank or indented deeper
+	// than the key; its indentation is its first non-blank line's
+	blockIndent := indent + explicit
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		l := p.lines[p.pos]
+		if l.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if l.indent <= indent {
+			break
+		}
+		if explicit == 0 && blockIndent == indent {
+			blockIndent = l.indent
+		}
+		if l.indent < blockIndent {
+			return "", yamlErrorf(l.num, "block scalar line indented less than its first line")
+		}
+		lines = append(lines, strings.Repeat(" ", l.indent-blockIndent)+l.text)
+	}
+	// Trailing blank lines belong to the chomping, not the content
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	trailing := len(lines) - content
+	lines = lines[:content]
+
+	s := strings.Join(lines, "\n")
+	if folded {
+		s = fold(lines)
+	}
+	switch {
+	case content == 0:
+		if chomp == '+' {
+			return strings.Repeat("\n", trailing), nil
+		}
+		return "", nil
+	case chomp == '-':
+		return s, nil
+	case chomp == '+':
+		return s + "\n" + strings.Repeat("\n", trailing), nil
+	}
+	return s + "\n", nil
+}
+
+// fold joins a folded block's lines: lines next to each other
+// are joined with a space, a blank line between them becomes a line break,
+// and more-indented lines keep their breaks.
+func fold(lines []string) string {
+	var b strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			switch {
+			case l == "":
+				// A run of n blank lines folds to n line breaks
+				if prev != "" {
+					break
+				}
+				b.WriteByte('\n')
+			case prev == "":
+				b.WriteByte('\n')
+			case strings.HasPrefix(l, " ") || strings.HasPrefix(prev, " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(l)
+	}
+	return b.String()
+}
+
+// isSeqItem reports whether a line's text is a block sequence item.
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a "key: value" line. ok is false for lines that aren't
+// mapping entries.
+func splitKey(line yamlLine) (key, rest string, ok bool, err error) {
+	text := line.text
+	if text[0] == '"' || text[0] == '\'' {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", false, nil
+		}
+		after := text[end:]
+		if !strings.HasPrefix(after, ":") || (len(after) > 1 && after[1] != ' ') {
+			return "", "", false, nil
+		}
+		k, err := inlineValue(line.num, text[:end])
+		if err != nil {
+			return "", "", false, err
+		}
+		return k.(string), strings.TrimSpace(after[1:]), true, nil
+	}
+	if strings.ContainsAny(text[:1], "[{#&*!|>%@`") {
+		if text[0] == '&' || text[0] == '*' || text[0] == '!' {
+			return "", "", false, yamlErrorf(line.num, "anchors, aliases and tags aren't supported")
+		}
+		return "", "", false, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && i > 0 && text[i-1] == ' ' {
+			return "", "", false, nil // a comment before any colon
+		}
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// quotedEnd returns the index just past the quoted scalar text starts
+// with, -1 if it isn't closed on this line.
+func quotedEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// inlineValue parses a value written on one line: a quoted or plain
+// scalar, or a flow sequence or mapping, with any trailing comment.
+func inlineValue(num int, text string) (any, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	switch text[0] {
+	case '"', '\'':
+		end := quotedEnd(text)
+		if end < 0 {
+			return nil, yamlErrorf(num, "unclosed quote (quoted scalars must fit on one line; use | for more)")
+		}
+		if rest := strings.TrimSpace(text[end:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, yamlErrorf(num, "unexpected %q after a quoted scalar", rest)
+		}
+		return unquote(num, text[:end])
+	case '[', '{':
+		value, rest, err := flowValue(num, text)
+		if err != nil {
+			return nil, err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, yamlErrorf(num, "unexpected %q after a flow collection", rest)
+		}
+		return value, nil
+	case '&', '*', '!':
+		return nil, yamlErrorf(num, "anchors, aliases and tags aren't supported")
+	case '|', '>':
+		return nil, yamlErrorf(num, "a block scalar must follow a key")
+	}
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	return plainScalar(text), nil
+}
+
+// unquote decodes a single- or double-quoted scalar.
+func unquote(num int, text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	var b strings.Builder
+	body := text[1 : len(text)-1]
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(body) {
+			return "", yamlErrorf(num, "unfinished escape")
+		}
+		switch body[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '"', '\\', '/', ' ':
+			b.WriteByte(body[i])
+		case 'u', 'U', 'x':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[body[i]]
+			if i+size >= len(body) {
+				return "", yamlErrorf(num, "short \\%c escape", body[i])
+			}
+			code, err := strconv.ParseUint(body[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", yamlErrorf(num, "invalid \\%c escape", body[i])
+			}
+			b.WriteRune(rune(code))
+			i += size
+		default:
+			return "", yamlErrorf(num, "unknown escape \\%c", body[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// flowValue parses a flow sequence or mapping at the start of text and
+// returns what follows it.
+func flowValue(num int, text string) (any, string, error) {
+	open := text[0]
+	close := byte(']')
+	if open == '{' {
+		close = '}'
+	}
+	var list []any
+	m := map[string]any{}
+	rest := strings.TrimSpace(text[1:])
+	for {
+		if rest == "" {
+			return nil, "", yamlErrorf(num, "unclosed %c (flow collections must fit on one line)", open)
+		}
+		if rest[0] == close {
+			break
+		}
+		item, after, err := flowItem(num, rest, open == '{')
+		if err != nil {
+			return nil, "", err
+		}
+		if open == '{' {
+			kv := item.([2]any)
+			key, ok := kv[0].(string)
+			if !ok {
+				key = fmt.Sprint(kv[0])
+			}
+			if _, dup := m[key]; dup {
+				return nil, "", yamlErrorf(num, "duplicate key %q", key)
+			}
+			m[key] = kv[1]
+		} else {
+			list = append(list, item)
+		}
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if rest == "" || rest[0] != close {
+			return nil, "", yamlErrorf(num, "expected , or %c", close)
+		}
+	}
+	if open == '{' {
+		return m, rest[1:], nil
+	}
+	if list == nil {
+		list = []any{}
+	}
+	return list, rest[1:], nil
+}
+
+// flowItem parses one entry of a flow collection: a value, or for a
+// mapping a [2]any of key and value.
+func flowItem(num int, text string, pair bool) (any, string, error) {
+	value, rest, err := flowScalar(num, text, pair)
+	if err != nil || !pair {
+		return value, rest, err
+	}
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, ":") {
+		return nil, "", yamlErrorf(num, "expected \"key: value\" in a flow mapping")
+	}
+	v, after, err := flowScalar(num, strings.TrimSpace(rest[1:]), false)
+	return [2]any{value, v}, after, err
+}
+
+// flowScalar parses a nested collection or a scalar inside a flow
+// collection, ending at , ] } or, for a key, the colon.
+func flowScalar(num int, text string, key bool) (any, string, error) {
+	if text == "" {
+		return nil, "", nil
+	}
+	switch text[0] {
+	case '[', '{':
+		return flowValue(num, text)
+	case '"', '\'':
+		end := quotedEnd(text)
+		if end < 0 {
+			return nil, "", yamlErrorf(num, "unclosed quote")
+		}
+		s, err := unquote(num, text[:end])
+		return s, text[end:], err
+	}
+	end := len(text)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == ',' || c == ']' || c == '}' || (key && c == ':' && (i+1 == len(text) || text[i+1] == ' ')) {
+			end = i
+			break
+		}
+	}
+	return plainScalar(strings.TrimSpace(text[:end])), text[end:], nil
+}
+
+// plainScalar resolves an unquoted scalar as YAML 1.2's core schema does:
+// null, booleans and numbers, otherwise a string.
+func plainScalar(s string) any {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if strings.ContainsAny(s, "0123456789") && !strings.ContainsAny(s, "_xXoObB") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
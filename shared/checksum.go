@@ -0,0 +1,27 @@
+// shared/checksum.go
+// Checksums of task results, for the agent → orchestrator hop.
+//
+// Flaky Wi-Fi or a buffering proxy between an agent and the orchestrator
+// can cut a reply short in ways the JSON still survives. Agents stamp
+// each result's content with its SHA-256; the orchestrator checks it, and
+// asks again once when it doesn't match.
+
+package shared
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentChecksum is the checksum of a result's content: "sha256:" and
+// the hex digest.
+func ContentChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ChecksumOK reports whether r's content matches its checksum. Results
+// without one, from agents that predate checksums, pass.
+func (r *TaskResult) ChecksumOK() bool {
+	return r.Checksum == "" || r.Checksum == ContentChecksum(r.Content)
+}
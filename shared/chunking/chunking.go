@@ -0,0 +1,417 @@
+// shared/chunking/chunking.go
+// Cutting long text into chunks of a token budget.
+//
+// POST /summarize cuts documents for its map step, map steps cut their
+// input into chunks for ingestion (embedding a fetched document chunk by
+// chunk, say), and context shaping cuts conversations too long for the
+// model that summarizes them. They share these splitters:
+//
+//   - Paragraphs ends chunks between paragraphs where it can, else between
+//     lines, sentences or words: the default for prose.
+//   - Sentences packs whole sentences, and cuts only a sentence longer than
+//     a chunk.
+//   - Markdown keeps each header's section together where it fits, and
+//     starts the chunks of a longer section with its headers.
+//   - Tokens packs words, ignoring the text's structure.
+//
+// Sizes are estimated with shared.EstimateTokens. With Options.Overlap set
+// each chunk after the first starts with the last words of the one before
+// it, so text cut at a chunk boundary is seen whole in one of them;
+// chunks are then still no longer than MaxTokens. Chunks are trimmed, and
+// none is blank.
+
+package chunking
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"echo-system/shared"
+)
+
+// Splitting methods, as named in API requests.
+const (
+	MethodParagraphs = "paragraphs"
+	MethodSentences  = "sentences"
+	MethodMarkdown   = "markdown"
+	MethodTokens     = "tokens"
+)
+
+// Methods lists the splitting methods, the default first.
+var Methods = []string{MethodParagraphs, MethodSentences, MethodMarkdown, MethodTokens}
+
+// Options sizes the chunks.
+type Options struct {
+	MaxTokens int // the most estimated tokens in a chunk; 0 or less keeps the text whole
+	Overlap   int // tokens of the previous chunk repeated at the start of each; at most MaxTokens/2
+}
+
+// paragraphSeparators are where Paragraphs may end chunks, best first.
+var paragraphSeparators = []string{"\n\n", "\n", ". ", " "}
+
+// Valid reports whether method names a splitting method ("" is the default).
+func Valid(method string) bool {
+	return method == "" || slices.Contains(Methods, method)
+}
+
+// Split cuts text with the named method ("" is Paragraphs).
+func Split(method, text string, opts Options) ([]string, error) {
+	switch method {
+	case "", MethodParagraphs:
+		return Paragraphs(text, opts), nil
+	case MethodSentences:
+		return Sentences(text, opts), nil
+	case MethodMarkdown:
+		return Markdown(text, opts), nil
+	case MethodTokens:
+		return Tokens(text, opts), nil
+	}
+	return nil, fmt.Errorf("unknown splitting method %q (want %s)", method, strings.Join(Methods, ", "))
+}
+
+// Paragraphs cuts text between paragraphs, else lines, sentences or words.
+func Paragraphs(text string, opts Options) []string {
+	return split(text, opts, func(text string, limit int) []string {
+		return packSeparated(text, limit, paragraphSeparators)
+	})
+}
+
+// Sentences cuts text between sentences.
+func Sentences(text string, opts Options) []string {
+	return split(text, opts, func(text string, limit int) []string {
+		return pack(sentences(text), limit, packWords)
+	})
+}
+
+// Markdown cuts text between the sections of its headers. Overlap is only
+// added between the chunks of one section.
+func Markdown(text string, opts Options) []string {
+	text = strings.TrimSpace(text)
+	if chunks, whole := fits(text, opts); whole {
+		return chunks
+	}
+	return packMarkdown(text, opts.MaxTokens, overlapOf(opts))
+}
+
+// Tokens cuts text between words.
+func Tokens(text string, opts Options) []string {
+	return split(text, opts, packWords)
+}
+
+// split runs a packer with room left for the overlap, then adds it.
+func split(text string, opts Options, packer func(text string, limit int) []string) []string {
+	text = strings.TrimSpace(text)
+	if chunks, whole := fits(text, opts); whole {
+		return chunks
+	}
+	overlap := overlapOf(opts)
+	return withOverlap(packer(text, opts.MaxTokens-overlap), overlap)
+}
+
+// fits returns trimmed text as its only chunk, or none if it's blank, when
+// it needn't be cut.
+func fits(text string, opts Options) ([]string, bool) {
+	if text == "" {
+		return nil, true
+	}
+	if opts.MaxTokens <= 0 || shared.EstimateTokens(text) <= opts.MaxTokens {
+		return []string{text}, true
+	}
+	return nil, false
+}
+
+// overlapOf is the overlap opts ask for, within bounds.
+func overlapOf(opts Options) int {
+	return min(max(opts.Overlap, 0), opts.MaxTokens/2)
+}
+
+// withOverlap starts each chunk after the first with the last words of
+// the one before it, at most overlap tokens of them.
+func withOverlap(chunks []string, overlap int) []string {
+	if overlap == 0 {
+		return chunks
+	}
+	for i := len(chunks) - 1; i > 0; i-- {
+		if tail := tailTokens(chunks[i-1], overlap); tail != "" {
+			chunks[i] = tail + " " + chunks[i]
+		}
+	}
+	return chunks
+}
+
+// ─── Packing ──────────────────────────────────────────────────────────────────
+
+// pack joins consecutive pieces into chunks of at most limit tokens, cutting
+// pieces too long for one with fallback.
+func pack(pieces []string, limit int, fallback func(text string, limit int) []string) []string {
+	var chunks []string
+	var cur strings.Builder
+	curTokens := 0
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+		curTokens = 0
+	}
+	for _, piece := range pieces {
+		n := shared.EstimateTokens(piece)
+		if curTokens+n <= limit {
+			cur.WriteString(piece)
+			curTokens += n
+			continue
+		}
+		flush()
+		if n <= limit {
+			cur.WriteString(piece)
+			curTokens = n
+			continue
+		}
+		chunks = append(chunks, fallback(piece, limit)...)
+	}
+	flush()
+	return chunks
+}
+
+// packSeparated splits text after the first of seps and packs the pieces,
+// splitting pieces that are too long on the next separator.
+func packSeparated(text string, limit int, seps []string) []string {
+	if shared.EstimateTokens(text) <= limit {
+		if s := strings.TrimSpace(text); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+	if len(seps) == 0 {
+		return packRunes(text, limit)
+	}
+	return pack(strings.SplitAfter(text, seps[0]), limit, func(piece string, limit int) []string {
+		return packSeparated(piece, limit, seps[1:])
+	})
+}
+
+// packWords packs the words of text, cutting words too long for a chunk.
+func packWords(text string, limit int) []string {
+	return pack(words(text), limit, packRunes)
+}
+
+// packRunes cuts a string with no usable boundaries (a long CJK run, an
+// unbroken word) into equal parts of at most limit tokens.
+func packRunes(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	parts := (shared.EstimateTokens(text) + limit - 1) / limit
+	if parts <= 1 {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	}
+	var chunks []string
+	for start := 0; start < len(runes); {
+		// Equal parts by runes, shortened where the estimate says so
+		end := min(start+(len(runes)+parts-1)/parts, len(runes))
+		for end > start+1 && shared.EstimateTokens(string(runes[start:end])) > limit {
+			end--
+		}
+		chunks = append(chunks, string(runes[start:end]))
+		start = end
+	}
+	return chunks
+}
+
+// ─── Pieces ───────────────────────────────────────────────────────────────────
+
+// words breaks text after each whitespace run, so that rejoining the
+// pieces reproduces the text.
+func words(text string) []string {
+	var pieces []string
+	start := 0
+	inSpace := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if inSpace && !space {
+			pieces = append(pieces, text[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(text) {
+		pieces = append(pieces, text[start:])
+	}
+	return pieces
+}
+
+// sentences breaks text after each sentence: after ., ! or ? followed by
+// whitespace, after their CJK forms, and at blank lines.
+func sentences(text string) []string {
+	var pieces []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		end := -1
+		switch r := runes[i]; {
+		case r == '。' || r == '！' || r == '？':
+			end = i + 1
+		case r == '.' || r == '!' || r == '?' || r == '\n':
+			j := i + 1
+			for j < len(runes) && (runes[j] == '.' || runes[j] == '!' || runes[j] == '?' || runes[j] == '"' || runes[j] == ')') {
+				j++
+			}
+			if r == '\n' && (j >= len(runes) || runes[j] != '\n') {
+				continue
+			}
+			if j < len(runes) && !unicode.IsSpace(runes[j]) {
+				continue
+			}
+			end = j
+		}
+		if end < 0 {
+			continue
+		}
+		// The whitespace after a sentence stays with it
+		for end < len(runes) && unicode.IsSpace(runes[end]) {
+			end++
+		}
+		pieces = append(pieces, string(runes[start:end]))
+		start, i = end, end-1
+	}
+	if start < len(runes) {
+		pieces = append(pieces, string(runes[start:]))
+	}
+	return pieces
+}
+
+// tailTokens returns the last words of s, at most n tokens of them.
+func tailTokens(s string, n int) string {
+	pieces := words(s)
+	start, tokens := len(pieces), 0
+	for start > 0 {
+		t := shared.EstimateTokens(pieces[start-1])
+		if tokens+t > n {
+			break
+		}
+		tokens += t
+		start--
+	}
+	if start == len(pieces) {
+		// The last word alone is too long: take its end
+		runes := []rune(strings.TrimSpace(s))
+		i := len(runes)
+		for i > 0 && shared.EstimateTokens(string(runes[i-1:])) <= n {
+			i--
+		}
+		return string(runes[i:])
+	}
+	return strings.TrimSpace(strings.Join(pieces[start:], ""))
+}
+
+// ─── Markdown ─────────────────────────────────────────────────────────────────
+
+// section is a markdown header's text, up to the next header.
+type section struct {
+	headers []string // the header lines it's under, outermost first, its own last
+	text    string   // including its own header line
+	body    string   // without it
+}
+
+// packMarkdown packs whole sections, and cuts a section too long for a
+// chunk with Paragraphs, each of its chunks starting with the section's
+// headers.
+func packMarkdown(text string, limit, overlap int) []string {
+	var chunks []string
+	var cur strings.Builder
+	curTokens := 0
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+		curTokens = 0
+	}
+	for _, sec := range sections(text) {
+		n := shared.EstimateTokens(sec.text)
+		if curTokens+n <= limit {
+			cur.WriteString(sec.text)
+			curTokens += n
+			continue
+		}
+		flush()
+		if n <= limit {
+			cur.WriteString(sec.text)
+			curTokens = n
+			continue
+		}
+		context := strings.Join(sec.headers, "\n") + "\n\n"
+		room := limit - shared.EstimateTokens(context)
+		if len(sec.headers) == 0 || room < limit/2 {
+			// No headers, or too many to repeat
+			chunks = append(chunks, withOverlap(packSeparated(sec.text, limit-overlap, paragraphSeparators), overlap)...)
+			continue
+		}
+		for _, chunk := range withOverlap(packSeparated(sec.body, room-overlap, paragraphSeparators), overlap) {
+			chunks = append(chunks, context+chunk)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// sections breaks markdown before each ATX header outside code fences.
+func sections(text string) []section {
+	var secs []section
+	var path []string // header lines by level - 1, "" for levels skipped
+	cur := section{}
+	var b strings.Builder
+	headerLen := 0
+	add := func() {
+		if b.Len() > 0 {
+			cur.text = b.String()
+			cur.body = cur.text[headerLen:]
+			secs = append(secs, cur)
+		}
+		b.Reset()
+	}
+	fenced := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+		level := headerLevel(trimmed)
+		if fenced || level == 0 {
+			b.WriteString(line)
+			continue
+		}
+		add()
+		for len(path) < level-1 {
+			path = append(path, "")
+		}
+		path = append(path[:level-1], trimmed)
+		cur = section{}
+		for _, h := range path {
+			if h != "" {
+				cur.headers = append(cur.headers, h)
+			}
+		}
+		b.WriteString(line)
+		headerLen = len(line)
+	}
+	add()
+	return secs
+}
+
+// headerLevel returns the level of an ATX header line ("## Usage" is 2),
+// or 0 for any other line.
+func headerLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0
+	}
+	return level
+}
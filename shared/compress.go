@@ -0,0 +1,110 @@
+// shared/compress.go
+// Content negotiation helpers for compressing large non-streaming results.
+//
+// Used on both hops — agent→orchestrator and orchestrator→client. Bodies
+// below a size threshold are sent as-is (compression would only add
+// latency); larger ones use zstd when the peer accepts it, else gzip.
+
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressMinBytes is the body size from which results are compressed.
+const DefaultCompressMinBytes = 8 << 10
+
+// AcceptEncodings is the Accept-Encoding value callers send when they can
+// decode either compressed form via DecodeBody.
+const AcceptEncodings = "zstd, gzip"
+
+// zstdEncoder is safe for concurrent EncodeAll calls and costly to create.
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+
+// WriteJSON encodes v as the response body, compressing it when it's at
+// least minBytes long and the request accepts zstd or gzip. A minBytes
+// below zero disables compression.
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v any, minBytes int) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := ""
+	if minBytes >= 0 && len(body) >= minBytes {
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	switch encoding {
+	case "zstd":
+		body = zstdEncoder.EncodeAll(body, nil)
+	case "gzip":
+		var buf bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return err
+}
+
+// negotiateEncoding picks zstd over gzip from an Accept-Encoding header,
+// honouring q=0 exclusions. Returns "" when neither is acceptable.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := strings.ReplaceAll(params, " ", "")
+		if q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	switch {
+	case accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// DecodeBody wraps a response body according to its Content-Encoding.
+// Use it when the request set Accept-Encoding explicitly (which turns off
+// net/http's transparent gzip handling).
+func DecodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return gz, nil
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,128 @@
+// shared/files.go
+// Uploaded files referenced by tasks.
+//
+// A file is stored under the hex SHA-256 of its bytes, so its ID never
+// names two contents: whoever caches one by ID (agents do) never has to
+// revalidate it, and uploading the same bytes twice stores them once.
+// ExpandFiles turns a task's files into what a backend takes — text in the
+// prompt, images alongside it — on the agent, or on the orchestrator for
+// the cloud fallback.
+
+package shared
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTaskFiles is the most files one task may reference.
+const MaxTaskFiles = 16
+
+// maxArchiveEntryBytes caps how much of one archive member is inlined.
+const maxArchiveEntryBytes = 1 << 20
+
+// FileID returns the ID of a file with the given content.
+func FileID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidFileID reports whether id is a well-formed file ID (64 lowercase
+// hex digits), and so safe to use as a file name.
+func ValidFileID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// TaskFile is a referenced file's name and content, as fetched for a task.
+type TaskFile struct {
+	Name string
+	Data []byte
+}
+
+// ExpandFiles puts files in front of a prompt: text files, and the text
+// members of zip archives, each under a "--- file: name ---" header, with
+// the prompt after them. Images are returned separately, for backends that
+// take them beside the prompt. Other binary files are an error.
+func ExpandFiles(prompt string, files []TaskFile) (string, [][]byte, error) {
+	var b strings.Builder
+	var images [][]byte
+	section := func(name string, data []byte) {
+		fmt.Fprintf(&b, "--- file: %s ---\n%s", name, data)
+		if len(data) == 0 || data[len(data)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	for _, f := range files {
+		contentType := http.DetectContentType(f.Data)
+		switch {
+		case strings.HasPrefix(contentType, "image/"):
+			images = append(images, f.Data)
+		case contentType == "application/zip":
+			n, err := expandArchive(f, section)
+			if err != nil {
+				return "", nil, fmt.Errorf("file %s: %w", f.Name, err)
+			}
+			if n == 0 {
+				return "", nil, fmt.Errorf("archive %s has no text files", f.Name)
+			}
+		case isText(f.Data):
+			section(f.Name, f.Data)
+		default:
+			return "", nil, fmt.Errorf("file %s (%s) is neither text, an image nor a zip archive", f.Name, contentType)
+		}
+	}
+	b.WriteString(prompt)
+	return b.String(), images, nil
+}
+
+// expandArchive adds each text member of a zip archive as its own section,
+// named archive/member, and returns how many it added. Binary members are
+// skipped.
+func expandArchive(f TaskFile, section func(name string, data []byte)) (int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(f.Data), int64(len(f.Data)))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, member := range zr.File {
+		if member.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := member.Open()
+		if err != nil {
+			return n, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxArchiveEntryBytes))
+		rc.Close()
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", member.Name, err)
+		}
+		if !isText(data) {
+			continue
+		}
+		section(f.Name+"/"+member.Name, data)
+		n++
+	}
+	return n, nil
+}
+
+// isText reports whether data reads as UTF-8 text.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
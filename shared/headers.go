@@ -0,0 +1,66 @@
+// shared/headers.go
+// Header names passed through from clients to generation backends.
+//
+// The orchestrator's and the agent's -pass-headers each list the request
+// headers they hand on: client → orchestrator → agent → backend. A header
+// only reaches the backend if both name it. Headers that describe the
+// connection or the body rather than the caller can't be passed.
+
+package shared
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// unpassableHeaders belong to a single hop.
+var unpassableHeaders = map[string]bool{
+	"Accept-Encoding":     true,
+	"Connection":          true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Type":        true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// ParseHeaderNames parses a comma-separated list of header names into
+// their canonical form.
+func ParseHeaderNames(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, " \t:") {
+			return nil, fmt.Errorf("%q is not a header name", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if unpassableHeaders[name] {
+			return nil, fmt.Errorf("%s can't be passed through", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// PickHeaders returns the headers in h that are named in names.
+func PickHeaders(h http.Header, names []string) map[string]string {
+	var picked map[string]string
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			if picked == nil {
+				picked = make(map[string]string)
+			}
+			picked[name] = v
+		}
+	}
+	return picked
+}
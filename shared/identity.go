@@ -0,0 +1,58 @@
+// shared/identity.go
+// Agent identity keys.
+//
+// An agent keeps an Ed25519 key pair in its identity file and signs each
+// registration with it. The orchestrator pins a node ID to the first key
+// that registers it, so only that agent can claim the ID afterwards — from
+// another host or port, or right after a restart while its previous
+// registration still looks alive.
+
+package shared
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// IdentityProofMessage is what an agent signs to register: the node ID,
+// the RegisterRequest's Unix ms clock, and where the node is reached (its
+// address, port and whether it pulls its work). Orchestrators accept each
+// key's proofs only with increasing times, so a captured registration
+// can't be replayed, and a proof can't be moved to another address.
+func IdentityProofMessage(req *RegisterRequest) []byte {
+	return []byte(fmt.Sprintf("echo-system register %s %d %s %d %t",
+		req.NodeID, req.Time, req.AgentHost, req.AgentPort, req.Pull))
+}
+
+// SignIdentityProof signs a registration.
+func SignIdentityProof(key ed25519.PrivateKey, req *RegisterRequest) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, IdentityProofMessage(req)))
+}
+
+// VerifyIdentityProof checks a registration's identity key and proof,
+// both base64.
+func VerifyIdentityProof(req *RegisterRequest) error {
+	key, err := base64.StdEncoding.DecodeString(req.IdentityKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("identity_key is not a base64 Ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.IdentityProof)
+	if err != nil || !ed25519.Verify(key, IdentityProofMessage(req), sig) {
+		return errors.New("identity_proof is not a signature of this registration by identity_key")
+	}
+	return nil
+}
+
+// IdentityFingerprint shortens a base64 public key for display: the
+// first 16 hex digits of its SHA-256. "" for no key.
+func IdentityFingerprint(publicKey string) string {
+	if publicKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(publicKey))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,72 @@
+// shared/lines.go
+// Reading line-delimited streams (NDJSON, SSE) without bufio.Scanner's
+// 64KB line limit.
+//
+// Single lines can be large: Ollama's final chunk carries the whole context
+// array, and a non-streamed reply relayed as one chunk is as long as the
+// reply. LineReader accepts lines up to a configurable limit and fails
+// with a LineTooLongError naming it, instead of stopping at 64KB with
+// "token too long".
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxLineBytes is the default limit for a single streamed line.
+const DefaultMaxLineBytes = 16 << 20
+
+// LineTooLongError is returned for a line over the reader's limit.
+type LineTooLongError struct {
+	Limit int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("streamed line exceeds %d bytes (raise -max-line-bytes)", e.Limit)
+}
+
+// LineReader reads newline-terminated lines of up to a limit.
+type LineReader struct {
+	r     *bufio.Reader
+	limit int
+	line  []byte
+}
+
+// NewLineReader reads lines of up to limit bytes from r (limit <= 0 means
+// DefaultMaxLineBytes).
+func NewLineReader(r io.Reader, limit int) *LineReader {
+	if limit <= 0 {
+		limit = DefaultMaxLineBytes
+	}
+	return &LineReader{r: bufio.NewReaderSize(r, 64<<10), limit: limit}
+}
+
+// Next returns the next line without its line ending; it is only valid
+// until the following call. The error is io.EOF at the end of the stream,
+// a *LineTooLongError for an oversized line, or the read error.
+func (lr *LineReader) Next() ([]byte, error) {
+	lr.line = lr.line[:0]
+	for {
+		frag, err := lr.r.ReadSlice('\n')
+		lr.line = append(lr.line, frag...)
+		if len(bytes.TrimRight(lr.line, "\r\n")) > lr.limit {
+			return nil, &LineTooLongError{Limit: lr.limit}
+		}
+		switch {
+		case err == nil:
+			return bytes.TrimRight(lr.line, "\r\n"), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err == io.EOF && len(lr.line) > 0:
+			// Last line without a trailing newline
+			return bytes.TrimRight(lr.line, "\r\n"), nil
+		default:
+			return nil, err
+		}
+	}
+}
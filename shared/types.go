@@ -3,6 +3,12 @@
 
 package shared
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
 // ─── Task Types ───────────────────────────────────────────────────────────────
 
 // TaskType tells the orchestrator what kind of work this task requires.
@@ -25,15 +31,229 @@ type TaskRequest struct {
 	Prompt    string   `json:"prompt"`
 	Type      TaskType `json:"type,omitempty"`       // routing hint: code/text/vision/summarize
 	ModelHint string   `json:"model_hint,omitempty"` // optional: request a specific model by name
+
+	// Language of the prompt or wanted reply, as a language tag ("zh",
+	// "pt-BR"). Routing prefers models whose capabilities declare it
+	Language string `json:"language,omitempty"`
+
+	// Smallest size class of model the task may run on ("small",
+	// "medium", "large"), from the tiers nodes declare on their
+	// capabilities. Nodes without such a model for the task are skipped
+	MinQuality QualityTier `json:"min_quality,omitempty"`
+
+	// Output format: "json" constrains the backend to JSON and has the
+	// orchestrator check the output, repairing it with a re-prompt if it
+	// isn't valid. Empty means free text.
+	Format OutputFormat `json:"format,omitempty"`
+
+	// Generation options for the backend, as Ollama names them
+	// (temperature, num_predict, top_p, seed...). The orchestrator fills
+	// in its -task-options defaults for the task's type; these win
+	Options map[string]any `json:"options,omitempty"`
+
+	// Chat-style input instead of (or followed by) Prompt. The orchestrator
+	// fits the conversation into the target model's context window —
+	// summarizing older turns if needed — and flattens it into Prompt.
+	Messages []ChatMessage `json:"messages,omitempty"`
+
+	// Embed tasks: the texts to embed, which the agent sends to the
+	// backend in batches. Without them the prompt is embedded
+	Input []string `json:"input,omitempty"`
+
+	// IDs of files uploaded to POST /files that the task reads. The agent
+	// fetches them from the orchestrator (caching them by ID) and puts
+	// text files and the text in zip archives ahead of the prompt; images
+	// go to the model beside it.
+	Files []string `json:"files,omitempty"`
+
+	// Have a small, fast model condense the prompt before the task runs,
+	// for large models on slow nodes (POST /task and /task/stream)
+	Compress *CompressOptions `json:"compress,omitempty"`
+
+	// Streaming options (POST /task/stream only)
+	StreamMode         StreamMode `json:"stream_mode,omitempty"`          // delta (default) or full
+	SnapshotIntervalMs int        `json:"snapshot_interval_ms,omitempty"` // full mode: min gap between snapshots (default 250)
+
+	// Send text in whole words or sentences rather than as each token
+	// arrives (default token)
+	StreamGranularity StreamGranularity `json:"stream_granularity,omitempty"`
+
+	// Opt in to the orchestrator's cloud fallback when no local node can
+	// serve the task; such results have RoutedTo == CloudNodeID
+	AllowCloud bool `json:"allow_cloud,omitempty"`
+
+	// Always run a fresh generation, even if an identical task is in
+	// flight or has just finished
+	NoDedup bool `json:"no_dedup,omitempty"`
+
+	// Run on this node only, with no failover to others. The only way,
+	// besides mirroring, to reach a canary node
+	TargetNode string `json:"target_node,omitempty"`
+
+	// How long to wait at the orchestrator for a node when none can take
+	// the task: 0 for -queue-timeout, negative to fail at once
+	QueueTimeoutMs int64 `json:"queue_timeout_ms,omitempty"`
+
+	// Have a second model check the answer against the prompt for obvious
+	// errors; the result's Verification holds its verdict
+	Verify bool `json:"verify,omitempty"`
+
+	// Set by the orchestrator when forwarding to an agent: how long the
+	// agent has to answer. An agent that runs out of time mid-generation
+	// returns what it has produced so far as a Partial result.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+
+	// Set by the orchestrator on a task that follows others for the same
+	// model on the same node: how long the backend should keep the model
+	// loaded afterwards (an Ollama duration, e.g. "10m"), so the next task
+	// of the run doesn't wait for a reload.
+	KeepAlive string `json:"keep_alive,omitempty"`
+
+	// Set by the pipeline engine on step tasks; echoed back in TaskResult
+	Lineage *TaskLineage `json:"lineage,omitempty"`
+
+	// Set by the orchestrator from the submitting request (a client's own
+	// value is replaced); echoed back in TaskResult and dashboard events
+	Source *TaskSource `json:"source,omitempty"`
+
+	// Opaque client tags (correlation IDs, user names, feature flags).
+	// Never used for routing; persisted in history and echoed in TaskResult
+	// and dashboard events.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ChatMessage is one turn of a chat conversation. Role is "system",
+// "user" or "assistant".
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
 }
 
+// CompressOptions configures prompt compression: a summarize task on a
+// small model condenses the text to about TargetTokens before the real
+// task reads it. Text already within the target is left alone.
+type CompressOptions struct {
+	TargetTokens int    `json:"target_tokens,omitempty"` // default: the orchestrator's -compress-target-tokens
+	Model        string `json:"model,omitempty"`         // model that compresses; default -compress-model
+}
+
+// FileInfo describes a file uploaded to POST /files. ID is the hex SHA-256
+// of its content.
+type FileInfo struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	CreatedAt   int64  `json:"created_at"` // Unix ms of the first upload
+}
+
+// CloudNodeID is the RoutedTo of tasks served by the cloud fallback.
+const CloudNodeID = "cloud"
+
+// CloudUsage is the cloud fallback's spend for the current UTC day,
+// returned by GET /cloud/usage.
+type CloudUsage struct {
+	Enabled     bool   `json:"enabled"`
+	Model       string `json:"model,omitempty"`
+	Day         string `json:"day"` // YYYY-MM-DD (UTC)
+	Requests    int    `json:"requests"`
+	Tokens      int    `json:"tokens"`
+	DailyTokens int    `json:"daily_tokens,omitempty"` // cap; 0 = unlimited
+	Refused     int    `json:"refused"`                // requests refused by the cap
+}
+
+// TaskLineage links a task to the pipeline step that spawned it. Each run
+// of a step gets a fresh UUID task ID, so retries and re-runs of the same
+// step are distinguished by Attempt rather than colliding.
+type TaskLineage struct {
+	PipelineID string `json:"pipeline_id"`
+	StepIndex  int    `json:"step_index"`
+	Attempt    int    `json:"attempt"`        // 1 for the first run of this step
+	Item       int    `json:"item,omitempty"` // map steps: 1-based item number
+}
+
+// TaskSource is who submitted a task or pipeline: the name of the client
+// key it presented, where it came from and with what.
+type TaskSource struct {
+	Key       string `json:"key,omitempty"` // -client-keys name, "admin" for the admin token
+	RemoteIP  string `json:"remote_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Matches reports whether q names this source: its key or remote IP, or
+// part of its user agent (case-insensitive).
+func (s *TaskSource) Matches(q string) bool {
+	if s == nil || q == "" {
+		return false
+	}
+	return s.Key == q || s.RemoteIP == q ||
+		(s.UserAgent != "" && strings.Contains(strings.ToLower(s.UserAgent), strings.ToLower(q)))
+}
+
+// StreamMode selects what /task/stream sends in each SSE event.
+type StreamMode string
+
+const (
+	StreamModeDelta StreamMode = "delta" // one event per token, in TaskChunk.Token
+	StreamModeFull  StreamMode = "full"  // periodic snapshots of the accumulated text, in TaskChunk.Text
+)
+
+// StreamGranularity is the unit of text /task/stream sends at a time. In
+// full mode it's where snapshots of the text may end.
+type StreamGranularity string
+
+const (
+	GranularityToken    StreamGranularity = "token"    // as the node generates it
+	GranularityWord     StreamGranularity = "word"     // up to the last whitespace
+	GranularitySentence StreamGranularity = "sentence" // up to the last finished sentence or line
+)
+
+// OutputFormat is the format a task's output must be in.
+type OutputFormat string
+
+// FormatJSON asks for a single JSON document.
+const FormatJSON OutputFormat = "json"
+
 // TaskChunk is one streamed token from a node back to the client.
 type TaskChunk struct {
 	TaskID    string `json:"task_id"`
 	Token     string `json:"token"`
+	Text      string `json:"text,omitempty"` // full text so far (stream_mode=full)
 	Done      bool   `json:"done"`
 	RoutedTo  string `json:"routed_to"`
 	LatencyMs int64  `json:"latency_ms,omitempty"`
+
+	Timings  *TaskTimings      `json:"timings,omitempty"`  // on the final chunk
+	Transfer *TaskTransfer     `json:"transfer,omitempty"` // on the final chunk
+	Metadata map[string]string `json:"metadata,omitempty"` // request metadata, on the final chunk
+
+	// Set on the final chunk when the task failed after the stream began
+	Error string `json:"error,omitempty"`
+
+	// Set on the final chunk of a format=json task whose streamed output
+	// wasn't valid JSON: Text holds the repaired document, which replaces
+	// everything streamed before
+	JSONRepaired bool `json:"json_repaired,omitempty"`
+
+	// Set on the final chunk of a task sent with verify
+	Verification *Verification `json:"verification,omitempty"`
+
+	// Set on the final chunk when the stream was shared with DedupOf, an
+	// identical task submitted earlier
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	DedupOf      string `json:"dedup_of,omitempty"`
+}
+
+// FailoverEvent is sent on /task/stream as an SSE "failover" event when
+// the node running a streamed task fails before its first token and the
+// task moves to another node. Dashboards get it as a task_failover event.
+type FailoverEvent struct {
+	TaskID     string `json:"task_id"`
+	Attempt    int    `json:"attempt"`     // 1-based number of the attempt that failed
+	FailedNode string `json:"failed_node"` // node that failed
+	Reason     string `json:"reason"`
+	ErrorCode  string `json:"error_code,omitempty"` // ErrCode* class of the failure, if known
+	NextNode   string `json:"next_node,omitempty"`  // node the task moves to, if one was found
 }
 
 // TaskResult is the full response for non-streamed tasks.
@@ -46,6 +266,157 @@ type TaskResult struct {
 	LatencyMs int64    `json:"latency_ms"`
 	Success   bool     `json:"success"`
 	Error     string   `json:"error,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"` // ErrCode* class of a failure, if known
+
+	// Set with Error "timeout" when the task timed out mid-generation;
+	// Content holds the text produced until then
+	Partial bool `json:"partial,omitempty"`
+
+	// Set for format=json tasks whose output wasn't valid JSON and was
+	// repaired with a re-prompt; Content is the repaired document
+	JSONRepaired bool `json:"json_repaired,omitempty"`
+
+	// Set for successful tasks sent with verify: a second model's check
+	// of Content against the prompt
+	Verification *Verification `json:"verification,omitempty"`
+
+	// Set when the requested model failed and a smaller one from the
+	// orchestrator's fallback chain answered instead
+	ModelFallback *ModelFallback `json:"model_fallback,omitempty"`
+
+	// Attempts that failed before this result, oldest first; empty when
+	// the first node tried answered
+	Attempts []TaskAttempt `json:"attempts,omitempty"`
+
+	// Set when this result was shared with DedupOf, an identical task
+	// submitted earlier, instead of being generated again
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	DedupOf      string `json:"dedup_of,omitempty"`
+
+	// Estimated with EstimateTokens — Ollama's exact counts aren't forwarded
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+
+	// Set by the agent (ContentChecksum of Content) and checked, then
+	// cleared, by the orchestrator
+	Checksum string `json:"checksum,omitempty"`
+
+	// Embed tasks: one vector per input, in input order (one for the
+	// prompt without input), and how the backend got through them
+	Embeddings   [][]float32   `json:"embeddings,omitempty"`
+	EmbedTimings *EmbedTimings `json:"embed_timings,omitempty"`
+
+	Timings  *TaskTimings      `json:"timings,omitempty"`  // split latency on the node, if the agent measured it
+	Transfer *TaskTransfer     `json:"transfer,omitempty"` // bytes exchanged with the node's agent
+	Lineage  *TaskLineage      `json:"lineage,omitempty"`  // parent pipeline/step, if any
+	Source   *TaskSource       `json:"source,omitempty"`   // who submitted the task
+	Metadata map[string]string `json:"metadata,omitempty"` // echoed from the request
+}
+
+// Verification is a second model's check of a task's answer, for tasks
+// sent with verify.
+type Verification struct {
+	Verdict    Verdict `json:"verdict"`
+	Confidence float64 `json:"confidence"`       // the verifier's confidence in its verdict, 0..1
+	Issues     string  `json:"issues,omitempty"` // the errors the verifier found, in its words
+	Model      string  `json:"model,omitempty"`  // the verifier's model
+	NodeID     string  `json:"node_id,omitempty"`
+	LatencyMs  int64   `json:"latency_ms,omitempty"`
+
+	// Set when no other model could check the answer, so the model that
+	// gave it checked it itself
+	SameModel bool `json:"same_model,omitempty"`
+
+	// Why there's no verdict, with Verdict unverified
+	Error string `json:"error,omitempty"`
+}
+
+// Verdict is a verifier's judgement of an answer.
+type Verdict string
+
+const (
+	VerdictPass       Verdict = "pass"       // no obvious errors
+	VerdictFail       Verdict = "fail"       // errors found; see Issues
+	VerdictUnsure     Verdict = "unsure"     // the verifier couldn't tell
+	VerdictUnverified Verdict = "unverified" // the check failed or gave no verdict; see Error
+)
+
+// Error codes agents set on failed TaskResults. Both make the orchestrator
+// try a smaller model from the task type's fallback chain.
+const (
+	ErrCodeModelNotFound = "MODEL_NOT_FOUND" // the node's Ollama doesn't have the model
+	ErrCodeOOM           = "OOM"             // the model doesn't fit the node's memory
+)
+
+// ErrCodeMemoryBudget marks a task an agent refused because its model
+// would push the node past its -memory-budget; the orchestrator tries
+// another node without holding it against this one.
+const ErrCodeMemoryBudget = "MEMORY_BUDGET"
+
+// ErrCodeInvalidJSON marks a format=json task whose output was still not
+// valid JSON after the orchestrator's repair attempt.
+const ErrCodeInvalidJSON = "INVALID_JSON"
+
+// TaskAttempt is one failed try of a task on a node.
+type TaskAttempt struct {
+	NodeID    string `json:"node_id"`
+	Model     string `json:"model,omitempty"`
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code,omitempty"` // ErrCode* class of the failure, if known
+	LatencyMs int64  `json:"latency_ms"`           // until the failure
+}
+
+// ModelFallback records a model substitution.
+type ModelFallback struct {
+	From   string `json:"from"`    // model that failed
+	To     string `json:"to"`      // model that answered
+	NodeID string `json:"node_id"` // node where From failed
+	Reason string `json:"reason"`  // ErrCode* of the failure
+}
+
+// TaskTimings splits a task's time on the node that ran it. A high QueueMs
+// means contention (more slots or nodes help); a low TokensPerSec means the
+// model is slow on this hardware.
+type TaskTimings struct {
+	QueueMs      int64   `json:"queue_ms"`       // waiting for a generation slot, in the agent or Ollama's queue
+	LoadMs       int64   `json:"load_ms"`        // Ollama loading the model into memory
+	FirstTokenMs int64   `json:"first_token_ms"` // dispatch to Ollama until the first token (queue, load and prompt eval)
+	GenerationMs int64   `json:"generation_ms"`  // first token to last
+	TokensPerSec float64 `json:"tokens_per_sec,omitempty"`
+}
+
+// EmbedTimings describe how an embed task's inputs went through the
+// backend: batched, several inputs per request, or pipelined, one input
+// per request with several in flight, for backends that can't batch.
+type EmbedTimings struct {
+	Items        int     `json:"items"`
+	Requests     int     `json:"requests"`            // backend requests made
+	Pipelined    bool    `json:"pipelined,omitempty"` // one input per request
+	TotalMs      int64   `json:"total_ms"`
+	LoadMs       int64   `json:"load_ms,omitempty"`  // the backend loading the model
+	BatchMs      []int64 `json:"batch_ms,omitempty"` // per request, in order
+	ItemMs       []int64 `json:"item_ms,omitempty"`  // per input: its own request's time when pipelined, its share of its batch's otherwise
+	ItemsPerSec  float64 `json:"items_per_sec"`
+	PromptTokens int     `json:"prompt_tokens,omitempty"` // as counted by the backend
+}
+
+// TaskTransfer counts the bytes that crossed the network between the
+// orchestrator and an agent for a task: HTTP bodies as sent on the wire
+// (compressed, if they were), headers excluded. Only the attempt that
+// produced the result is counted, and pull-mode nodes aren't measured.
+type TaskTransfer struct {
+	SentBytes     int64 `json:"sent_bytes"`     // orchestrator → agent: the task
+	ReceivedBytes int64 `json:"received_bytes"` // agent → orchestrator: the result or token stream
+}
+
+// NodeTimings are a node's smoothed TaskTimings.
+type NodeTimings struct {
+	AvgQueueMs      float64 `json:"avg_queue_ms"`
+	AvgLoadMs       float64 `json:"avg_load_ms"`
+	AvgFirstTokenMs float64 `json:"avg_first_token_ms"`
+	AvgGenerationMs float64 `json:"avg_generation_ms"`
+	TokensPerSec    float64 `json:"tokens_per_sec,omitempty"`
+	Samples         int     `json:"samples"`
 }
 
 // ─── Node ─────────────────────────────────────────────────────────────────────
@@ -53,10 +424,23 @@ type TaskResult struct {
 type NodeStatus string
 
 const (
-	StatusIdle       NodeStatus = "idle"
-	StatusBusy       NodeStatus = "busy"
-	StatusOverloaded NodeStatus = "overloaded"
-	StatusOffline    NodeStatus = "offline"
+	StatusIdle        NodeStatus = "idle"
+	StatusBusy        NodeStatus = "busy"
+	StatusOverloaded  NodeStatus = "overloaded"
+	StatusOffline     NodeStatus = "offline"
+	StatusBackendDown NodeStatus = "backend_down" // agent is up but its Ollama isn't responding
+	StatusAbsent      NodeStatus = "absent"       // listed in the orchestrator's inventory but not registered (GET /status only)
+)
+
+// HealthGrade is a node's overall health for humans: heartbeat freshness,
+// recent failures, pressure and reputation folded into a traffic light.
+// Routing still goes by NodeStatus.
+type HealthGrade string
+
+const (
+	HealthGreen  HealthGrade = "green"  // serving normally
+	HealthYellow HealthGrade = "yellow" // degraded: slow heartbeats, some failures, or under pressure
+	HealthRed    HealthGrade = "red"    // offline, backend down, or mostly failing
 )
 
 // ModelCapability describes a single model and what task types it handles.
@@ -66,24 +450,257 @@ const (
 type ModelCapability struct {
 	Name  string     `json:"name"`
 	Types []TaskType `json:"types"`
+
+	// Exclusive models run one generation at a time on this node whatever
+	// the concurrency settings (e.g. a 70B model that would otherwise swap);
+	// the orchestrator serializes tasks for them
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	// Languages the model is notably good at, as language tags; tasks
+	// with a matching language hint prefer it
+	Languages []string `json:"languages,omitempty"`
+
+	// Size class of the model, for tasks with a min_quality; empty if the
+	// node doesn't know it
+	Tier QualityTier `json:"tier,omitempty"`
+
+	// Trained context window in tokens, 0 if the backend didn't say;
+	// prompts are never fitted to more than this
+	ContextLength int `json:"context_length,omitempty"`
+}
+
+// QualityTier is a model size class. Tasks can insist on at least one.
+type QualityTier string
+
+const (
+	QualitySmall  QualityTier = "small"  // under 6B parameters, e.g. a 3B model on a Pi
+	QualityMedium QualityTier = "medium" // 6B to 13B, e.g. mistral 7B
+	QualityLarge  QualityTier = "large"  // 13B and up
+)
+
+// qualityRanks orders the tiers; unknown tiers rank 0.
+var qualityRanks = map[QualityTier]int{QualitySmall: 1, QualityMedium: 2, QualityLarge: 3}
+
+// ValidQuality reports whether q names a tier.
+func ValidQuality(q QualityTier) bool {
+	return qualityRanks[q] > 0
+}
+
+// AtLeast reports whether q is min or a larger tier. Every tier, and an
+// unknown one, is at least the empty tier.
+func (q QualityTier) AtLeast(min QualityTier) bool {
+	return min == "" || qualityRanks[q] >= qualityRanks[min]
+}
+
+// TierForParams returns the tier of a model with the given parameter
+// count, as Ollama reports it ("7.2B", "137M") or model names carry it
+// ("70b"), or "" if params isn't such a count.
+func TierForParams(params string) QualityTier {
+	params = strings.ToUpper(strings.TrimSpace(params))
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(params, "B"):
+	case strings.HasSuffix(params, "M"):
+		scale = 1e-3
+	default:
+		return ""
+	}
+	n, err := strconv.ParseFloat(params[:len(params)-1], 64)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	switch billions := n * scale; {
+	case billions < 6:
+		return QualitySmall
+	case billions < 13:
+		return QualityMedium
+	default:
+		return QualityLarge
+	}
 }
 
 // RegisterRequest is sent by a node-agent to the orchestrator on startup.
 type RegisterRequest struct {
-	NodeID       string            `json:"node_id"`
-	AgentHost    string            `json:"agent_host,omitempty"` // hostname/IP for the orchestrator to reach this agent
-	AgentPort    int               `json:"agent_port"`
-	OllamaPort   int               `json:"ollama_port"`
-	Models       []string          `json:"models"`       // kept for backwards compat
-	Capabilities []ModelCapability `json:"capabilities"` // rich map used in Phase 3+
-	Status       NodeStatus        `json:"status"`
+	NodeID        string            `json:"node_id"`
+	AgentHost     string            `json:"agent_host,omitempty"` // hostname/IP for the orchestrator to reach this agent
+	AgentPort     int               `json:"agent_port"`
+	OllamaPort    int               `json:"ollama_port"`
+	Models        []string          `json:"models"`       // kept for backwards compat
+	Capabilities  []ModelCapability `json:"capabilities"` // rich map used in Phase 3+
+	Status        NodeStatus        `json:"status"`
+	BusyThreshold int               `json:"busy_threshold,omitempty"` // active tasks at which the node counts as busy (0 = default 5)
+	Slots         []ModelSlots      `json:"slots,omitempty"`          // parallel generations per model (e.g. OLLAMA_NUM_PARALLEL)
+	Pull          bool              `json:"pull,omitempty"`           // the agent fetches its tasks from GET /work; never connect to it
+	Canary        bool              `json:"canary,omitempty"`         // only mirrored tasks and tasks targeted at the node; never normal routing
+	Exec          []string          `json:"exec,omitempty"`           // languages the agent's -exec sandbox runs; none = no code execution
+	Time          int64             `json:"time,omitempty"`           // the agent's clock, Unix ms, for skew detection
+	CapabilitySeq int64             `json:"capability_seq,omitempty"` // the agent's capability version; heartbeat deltas build on it
+
+	// The agent's build and mesh API version; agents from before versions
+	// were reported send neither
+	Version    string `json:"version,omitempty"`
+	APIVersion int    `json:"api_version,omitempty"`
+
+	// The agent's identity (see identity.go): its base64 Ed25519 public
+	// key, and its signature of node_id, time, agent_host, agent_port and
+	// pull. Agents without an identity file send neither
+	IdentityKey   string `json:"identity_key,omitempty"`
+	IdentityProof string `json:"identity_proof,omitempty"`
+}
+
+// RegisterResponse answers a registration. The session token must
+// accompany the node's heartbeats, work polls and result submissions as
+// "Authorization: Bearer <token>"; it changes with every registration.
+type RegisterResponse struct {
+	Status       string `json:"status"` // "registered"
+	SessionToken string `json:"session_token"`
+
+	// The orchestrator's build and mesh API version
+	Version    string `json:"version,omitempty"`
+	APIVersion int    `json:"api_version,omitempty"`
 }
 
 // HeartbeatRequest is sent every 3 seconds from node to orchestrator.
 type HeartbeatRequest struct {
-	NodeID      string     `json:"node_id"`
-	Status      NodeStatus `json:"status"`
-	ActiveTasks int        `json:"active_tasks"`
+	NodeID      string       `json:"node_id"`
+	Status      NodeStatus   `json:"status"`
+	ActiveTasks int          `json:"active_tasks"`
+	Resources   *Resources   `json:"resources,omitempty"`     // nil from older agents
+	Slots       []ModelSlots `json:"slots,omitempty"`         // free parallel slots per model
+	Peers       []PeerLink   `json:"peers"`                   // peers seen over mDNS; null when the agent doesn't discover peers
+	Thermal     *Thermal     `json:"thermal,omitempty"`       // nil when the agent has no temperature readings
+	Link        *LinkQuality `json:"link,omitempty"`          // nil until the agent has timed its link to the orchestrator
+	Loaded      []string     `json:"loaded_models,omitempty"` // models the backend holds in memory
+	Time        int64        `json:"time,omitempty"`          // the agent's clock, Unix ms, for skew detection
+
+	// What the loaded models hold, from Ollama's /api/ps; nil for llama.cpp
+	LoadedMemory []LoadedModel `json:"loaded_memory,omitempty"`
+
+	// Models added, removed or changed since the orchestrator last
+	// acknowledged the agent's capabilities; nil when nothing changed
+	CapabilityChanges *CapabilityDelta `json:"capability_changes,omitempty"`
+
+	// The agent's clock, Unix ms, when it last checked its advertised
+	// models against the backend's; 0 if it never has
+	CapabilitiesChecked int64 `json:"capabilities_checked,omitempty"`
+
+	// As in RegisterRequest
+	Version    string `json:"version,omitempty"`
+	APIVersion int    `json:"api_version,omitempty"`
+}
+
+// CapabilityDelta is a change to a node's models, sent with a heartbeat
+// instead of re-registering. It takes the node from capability version
+// Base to Seq; the orchestrator answers 409 when it doesn't have Base,
+// and the agent then re-registers with its full capabilities.
+type CapabilityDelta struct {
+	Base    int64             `json:"base"`
+	Seq     int64             `json:"seq"`
+	Added   []ModelCapability `json:"added,omitempty"`   // new models, and changed ones replacing those of the same name
+	Removed []string          `json:"removed,omitempty"` // models the node no longer has
+}
+
+// LoadedModel is a model the backend holds in memory, as Ollama's /api/ps
+// last reported it.
+type LoadedModel struct {
+	Name      string `json:"name"`
+	SizeBytes uint64 `json:"size_bytes,omitempty"` // memory it takes in all
+	VRAMBytes uint64 `json:"vram_bytes,omitempty"` // of that, on the GPU
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix ms when Ollama unloads it unless it's used again
+}
+
+// ThermalState is how much load an agent sheds because of its temperature.
+type ThermalState string
+
+const (
+	ThermalNormal    ThermalState = "normal"
+	ThermalThrottled ThermalState = "throttled" // above -thermal-throttle: half its capacity
+	ThermalHot       ThermalState = "hot"       // above -thermal-busy: busy, no free slots
+)
+
+// Thermal is an agent's latest temperature readings, in °C (0 = no
+// sensor), and the state they put it in.
+type Thermal struct {
+	CPUTempC float64      `json:"cpu_temp_c,omitempty"` // hottest CPU/SoC sensor
+	GPUTempC float64      `json:"gpu_temp_c,omitempty"` // hottest GPU
+	State    ThermalState `json:"state"`
+}
+
+// LinkGrade sums up an agent's link to the orchestrator.
+type LinkGrade string
+
+const (
+	LinkGood LinkGrade = "good"
+	LinkFair LinkGrade = "fair" // noticeable on streams
+	LinkPoor LinkGrade = "poor" // streams stutter; the node's health turns yellow
+)
+
+// LinkQuality is how well an agent reaches the orchestrator, from the
+// probes it times.
+type LinkQuality struct {
+	RTTMs    float64   `json:"rtt_ms"`          // median round trip of the answered probes
+	JitterMs float64   `json:"jitter_ms"`       // mean change in round trip from one answered probe to the next
+	Loss     float64   `json:"loss"`            // share of probes not answered in time, 0..1
+	Probes   int       `json:"probes"`          // probes the figures are from
+	Grade    LinkGrade `json:"grade,omitempty"` // set by the orchestrator
+}
+
+// PeerLink is another node an agent found advertising itself over mDNS
+// ("_echo-node._tcp"), and how quickly it could connect to it.
+type PeerLink struct {
+	NodeID    string  `json:"node_id"`
+	Addr      string  `json:"addr"`             // host:port it advertised
+	Reachable bool    `json:"reachable"`        // a TCP connection succeeded
+	RTTMs     float64 `json:"rtt_ms,omitempty"` // median TCP connect time
+	Loss      float64 `json:"loss,omitempty"`   // share of connects that failed, 0..1
+}
+
+// Topology is the mesh as the nodes see each other (GET /topology).
+type Topology struct {
+	Nodes       []TopologyNode `json:"nodes"`
+	Links       []TopologyLink `json:"links"`
+	GeneratedAt int64          `json:"generated_at"`
+}
+
+// TopologyNode is a registered node in the topology view.
+type TopologyNode struct {
+	NodeID     string      `json:"node_id"`
+	Status     NodeStatus  `json:"status"`
+	Health     HealthGrade `json:"health"`
+	Reporting  bool        `json:"reporting"`             // the agent reports the peers it sees
+	ReportedAt int64       `json:"reported_at,omitempty"` // Unix ms of its latest report
+
+	Link *LinkQuality `json:"link,omitempty"` // its link to the orchestrator
+}
+
+// TopologyLink is one node seeing another over mDNS. To may be a node
+// that isn't registered with this orchestrator.
+type TopologyLink struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Reachable bool    `json:"reachable"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+	Loss      float64 `json:"loss,omitempty"`
+	Mutual    bool    `json:"mutual"` // To reports seeing From as well
+}
+
+// ModelSlots is a model's parallel request capacity on a node. When a node
+// declares slots, the router treats them — not the node-wide active task
+// count — as its capacity: the node is busy for a model only when that
+// model's slots are all taken.
+type ModelSlots struct {
+	Model string `json:"model"`
+	Total int    `json:"total"`
+	Free  int    `json:"free"`
+}
+
+// Resources is the free space a node can offer for model pulls.
+// Zero totals mean "unknown" (e.g. no GPU, or nvidia-smi not installed).
+type Resources struct {
+	DiskFreeBytes  uint64 `json:"disk_free_bytes"` // free space on the Ollama models volume
+	DiskTotalBytes uint64 `json:"disk_total_bytes"`
+	VRAMFreeBytes  uint64 `json:"vram_free_bytes"` // summed across GPUs
+	VRAMTotalBytes uint64 `json:"vram_total_bytes"`
 }
 
 // NodeInfo is how the orchestrator stores a connected node internally.
@@ -98,6 +715,239 @@ type NodeInfo struct {
 	ActiveTasks   int               `json:"active_tasks"`
 	LastHeartbeat int64             `json:"last_heartbeat"`
 	RegisteredAt  int64             `json:"registered_at"`
+	Probing       bool              `json:"probing,omitempty"` // capabilities still being verified — not routable yet
+
+	BusyThreshold          int `json:"busy_threshold"`           // declared by the agent
+	EffectiveBusyThreshold int `json:"effective_busy_threshold"` // adapted from observed latency
+
+	Resources *Resources   `json:"resources,omitempty"` // last reported disk/VRAM space
+	Thermal   *Thermal     `json:"thermal,omitempty"`   // last reported temperatures
+	Link      *LinkQuality `json:"link,omitempty"`      // last reported link to the orchestrator
+
+	Slots []ModelSlots `json:"slots,omitempty"` // free parallel slots per model, nil if the agent doesn't declare them
+
+	Draining bool `json:"draining,omitempty"` // set by an operator: finishes in-flight work but gets no new tasks
+
+	Pull bool `json:"pull,omitempty"` // tasks are queued for the agent to fetch from GET /work
+
+	Canary bool `json:"canary,omitempty"` // declared by the agent: gets only mirrored and targeted tasks
+
+	Exec []string `json:"exec,omitempty"` // declared by the agent: languages its sandbox runs for exec steps
+
+	LoadedModels []string `json:"loaded_models,omitempty"` // models in the backend's memory at the last heartbeat; tasks for them skip the load
+
+	// The memory each loaded model holds, with expires_at in orchestrator time
+	LoadedMemory []LoadedModel `json:"loaded_memory,omitempty"`
+
+	ClockSkewMs int64 `json:"clock_skew_ms,omitempty"` // agent clock minus orchestrator clock at its last report
+
+	Version    string `json:"version,omitempty"`     // the agent's build, as it reported it
+	APIVersion int    `json:"api_version,omitempty"` // the mesh API version it speaks; 0 if it didn't say
+
+	Identity string `json:"identity,omitempty"` // fingerprint of the agent's identity key; "" for agents without one
+
+	CapabilitySeq       int64 `json:"capability_seq,omitempty"`       // version of the capabilities, from registration and heartbeat deltas
+	CapabilitiesChanged int64 `json:"capabilities_changed,omitempty"` // unix ms of the last heartbeat delta applied
+
+	// Unix ms the node's models were last confirmed installed: at
+	// registration, by the agent's checks, or by the orchestrator re-validating them
+	CapabilitiesConfirmed int64 `json:"capabilities_confirmed,omitempty"`
+	CapabilitiesStale     bool  `json:"capabilities_stale,omitempty"` // unconfirmed for longer than -capability-ttl
+
+	// Routing signals weighed by RoutingWeights
+	AvgLatencyMs float64 `json:"avg_latency_ms,omitempty"` // smoothed latency of completed tasks
+	Reputation   float64 `json:"reputation"`               // smoothed success rate, 0..1 (starts at 1)
+	Local        bool    `json:"local,omitempty"`          // agent runs on the orchestrator's host
+
+	FailureRate   float64 `json:"failure_rate,omitempty"`    // fast-moving share of recent tasks that failed, 0..1
+	LastFailureAt int64   `json:"last_failure_at,omitempty"` // unix ms of the last failed task
+
+	Timings  *NodeTimings  `json:"timings,omitempty"`  // queue wait vs generation, from agents that report TaskTimings
+	Transfer *TaskTransfer `json:"transfer,omitempty"` // bytes exchanged for tasks since the orchestrator started
+
+	Availability *NodeAvailability `json:"availability,omitempty"` // over the last 24h, on GET /status
+
+	Health       HealthGrade `json:"health"`                  // computed when read
+	HealthReason string      `json:"health_reason,omitempty"` // why the node isn't green
+}
+
+// ─── Model pulls ──────────────────────────────────────────────────────────────
+
+// PullRequest asks the orchestrator to pull a model onto a node. On
+// POST /admin/nodes/{id}/pull, NodeID comes from the path. SizeBytes is the model's download size; VRAMBytes is what it needs in GPU
+// memory once loaded (defaults to SizeBytes).
+type PullRequest struct {
+	NodeID    string `json:"node_id,omitempty"`
+	Model     string `json:"model"`
+	SizeBytes uint64 `json:"size_bytes"`
+	VRAMBytes uint64 `json:"vram_bytes,omitempty"`
+
+	// Task types the node serves the model for once pulled; default text
+	// and summarize
+	Types []TaskType `json:"types,omitempty"`
+}
+
+// PullResult is returned by POST /admin/nodes/{id}/pull and the agent's POST /pull.
+type PullResult struct {
+	NodeID    string `json:"node_id"`
+	Model     string `json:"model"`
+	Success   bool   `json:"success"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PlacementError explains why a model can't be placed on a node.
+type PlacementError struct {
+	Error          string `json:"error"` // machine-readable: insufficient_disk, insufficient_vram, unknown_resources
+	Message        string `json:"message"`
+	NodeID         string `json:"node_id"`
+	Model          string `json:"model"`
+	RequiredBytes  uint64 `json:"required_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+}
+
+// ─── Code execution ───────────────────────────────────────────────────────────
+
+// ExecRequest asks an agent to run code in its sandbox (POST /exec), for a
+// pipeline exec step. The code is the program's source, fed to the
+// language's interpreter.
+type ExecRequest struct {
+	ExecID    string `json:"exec_id"`
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"` // capped by the agent's -exec-timeout
+}
+
+// ExecResult is the outcome of an ExecRequest. Error is set only when the
+// sandbox couldn't run the code at all; code that fails has a non-zero
+// ExitCode.
+type ExecResult struct {
+	ExecID    string `json:"exec_id"`
+	NodeID    string `json:"node_id"`
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"` // killed at the time limit
+	Truncated bool   `json:"truncated,omitempty"` // output was cut at the agent's limit
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Succeeded reports whether the code ran and exited 0.
+func (r *ExecResult) Succeeded() bool {
+	return r.Error == "" && !r.TimedOut && r.ExitCode == 0
+}
+
+// ─── Capability probing ───────────────────────────────────────────────────────
+// Used by the orchestrator to verify an agent's declared capabilities.
+
+// ModelListResponse is returned by the agent's GET /models: the models
+// actually installed in its local Ollama.
+type ModelListResponse struct {
+	Models  []string      `json:"models"`
+	Details []ModelDetail `json:"details,omitempty"`
+	Missing []string      `json:"missing,omitempty"` // declared by the agent (-models) but not installed in Ollama
+	NodeID  string        `json:"node_id,omitempty"` // set by the orchestrator's GET /nodes/{id}/models
+}
+
+// ModelDetail describes a model installed on a node, from Ollama's model
+// list, /api/show and /api/ps, plus the agent's own use of it.
+type ModelDetail struct {
+	Name          string `json:"name"`
+	SizeBytes     uint64 `json:"size_bytes"`
+	Digest        string `json:"digest,omitempty"`
+	ModifiedAt    int64  `json:"modified_at,omitempty"` // unix millis
+	Format        string `json:"format,omitempty"`      // e.g. gguf
+	Family        string `json:"family,omitempty"`      // e.g. llama
+	ParameterSize string `json:"parameter_size,omitempty"`
+	Quantization  string `json:"quantization,omitempty"`   // e.g. Q4_0
+	ContextLength int    `json:"context_length,omitempty"` // trained context window, 0 if Ollama didn't say
+	Declared      bool   `json:"declared"`                 // listed in the agent's -models
+	Loaded        bool   `json:"loaded"`                   // in memory right now
+	VRAMBytes     uint64 `json:"vram_bytes,omitempty"`     // GPU memory held while loaded
+	ExpiresAt     int64  `json:"expires_at,omitempty"`     // unix millis Ollama unloads it, while loaded
+	LastUsed      int64  `json:"last_used,omitempty"`      // unix millis of the agent's last generation with it
+}
+
+// SameModel reports whether an installed Ollama model name matches name.
+// Ollama reports untagged models as "name:latest".
+func SameModel(installed, name string) bool {
+	return installed == name || (!strings.Contains(name, ":") && installed == name+":latest")
+}
+
+// ProbeRequest asks an agent to run a 1-token generation on a model.
+type ProbeRequest struct {
+	Model string `json:"model"`
+}
+
+// ProbeResult is the agent's answer to POST /probe.
+type ProbeResult struct {
+	Model     string `json:"model"`
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SelfTestRequest is the body of an agent's POST /selftest, and of the
+// orchestrator's POST /admin/nodes/{id}/selftest. Both fields are optional.
+type SelfTestRequest struct {
+	Models []string `json:"models,omitempty"` // models to generate with (default: every advertised model)
+	// The caller's clock, unix ms, and the skew from it tolerated; the
+	// orchestrator sends its own clock and -max-clock-skew
+	ReferenceMs int64 `json:"reference_ms,omitempty"`
+	MaxSkewMs   int64 `json:"max_skew_ms,omitempty"` // default 2000
+}
+
+// Self-test check outcomes.
+const (
+	SelfTestOK   = "ok"
+	SelfTestWarn = "warn"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip"
+)
+
+// SelfTestCheck is one check of an agent's self-test.
+type SelfTestCheck struct {
+	Check     string `json:"check"` // backend | model | disk | clock
+	Model     string `json:"model,omitempty"`
+	Status    string `json:"status"` // ok | warn | fail | skip
+	Message   string `json:"message"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+}
+
+// SelfTestReport is an agent's answer to POST /selftest.
+type SelfTestReport struct {
+	NodeID     string          `json:"node_id"`
+	OK         bool            `json:"ok"`      // no check failed
+	Backend    string          `json:"backend"` // ollama | llamacpp
+	Version    string          `json:"version"`
+	StartedAt  int64           `json:"started_at"` // unix ms; orchestrator time when relayed by it
+	DurationMs int64           `json:"duration_ms"`
+	Checks     []SelfTestCheck `json:"checks"`
+}
+
+// Orphaned pipeline-step task states on an agent's GET /orphans.
+const (
+	OrphanRunning = "running" // still generating
+	OrphanDone    = "done"    // finished; result holds what it returned
+	OrphanFailed  = "failed"  // ended with an error, or nobody claimed it in time
+)
+
+// OrphanedTask is a pipeline step's task whose orchestrator went away
+// before the agent could answer it.
+type OrphanedTask struct {
+	TaskID     string       `json:"task_id"`
+	Lineage    *TaskLineage `json:"lineage"`
+	Status     string       `json:"status"`
+	StartedAt  int64        `json:"started_at"`            // unix ms, agent time
+	FinishedAt int64        `json:"finished_at,omitempty"` // unix ms, agent time
+	Result     *TaskResult  `json:"result,omitempty"`      // once done or failed
+}
+
+// OrphanList is an agent's answer to GET /orphans.
+type OrphanList struct {
+	NodeID string         `json:"node_id"`
+	Tasks  []OrphanedTask `json:"tasks"`
 }
 
 // ─── Capability helpers ───────────────────────────────────────────────────────
@@ -126,27 +976,217 @@ func CanHandle(caps []ModelCapability, t TaskType) bool {
 	return BestModelForType(caps, t) != ""
 }
 
+// IsExclusive reports whether a node declared model as exclusive.
+func IsExclusive(caps []ModelCapability, model string) bool {
+	for _, c := range caps {
+		if c.Name == model {
+			return c.Exclusive
+		}
+	}
+	return false
+}
+
+// SameLanguage reports whether two language tags name the same language,
+// comparing their primary subtags case-insensitively ("zh-CN" matches "zh").
+func SameLanguage(a, b string) bool {
+	primary := func(tag string) string {
+		tag, _, _ = strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+		return strings.ToLower(strings.TrimSpace(tag))
+	}
+	return a != "" && b != "" && primary(a) == primary(b)
+}
+
+// SpeaksLanguage reports whether a node declared model as good at lang.
+func SpeaksLanguage(caps []ModelCapability, model, lang string) bool {
+	for _, c := range caps {
+		if c.Name == model && c.speaks(lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelForLanguage returns the first model on this node that handles the
+// task type and declares lang, or "" if none does.
+func ModelForLanguage(caps []ModelCapability, t TaskType, lang string) string {
+	for _, c := range caps {
+		if c.speaks(lang) && (t == TaskTypeAny || c.handles(t)) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+func (c ModelCapability) speaks(lang string) bool {
+	for _, l := range c.Languages {
+		if SameLanguage(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c ModelCapability) handles(t TaskType) bool {
+	for _, ct := range c.Types {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// MeetsQuality reports whether a node declared model as of tier min or
+// larger.
+func MeetsQuality(caps []ModelCapability, model string, min QualityTier) bool {
+	if min == "" {
+		return true
+	}
+	for _, c := range caps {
+		if c.Name == model {
+			return c.Tier.AtLeast(min)
+		}
+	}
+	return false
+}
+
+// ResolveModel picks the model a node will run for a task: explicit
+// model_hint, then a model handling the task type that declares the
+// task's language, then any model handling the type, then the node's
+// first model. Returns "" if the node has no models at all. With a
+// minimum tier only models of that tier or larger are considered, and ""
+// is returned if there are none.
+func ResolveModel(caps []ModelCapability, models []string, hint string, t TaskType, lang string, minQuality QualityTier) string {
+	if hint != "" {
+		return hint
+	}
+	if minQuality != "" {
+		var fit []ModelCapability
+		for _, c := range caps {
+			if c.Tier.AtLeast(minQuality) && (t == TaskTypeAny || c.handles(t)) {
+				fit = append(fit, c)
+			}
+		}
+		if m := ModelForLanguage(fit, t, lang); lang != "" && m != "" {
+			return m
+		}
+		if len(fit) > 0 {
+			return fit[0].Name
+		}
+		return ""
+	}
+	if lang != "" {
+		if m := ModelForLanguage(caps, t, lang); m != "" {
+			return m
+		}
+	}
+	if t != TaskTypeAny {
+		if m := BestModelForType(caps, t); m != "" {
+			return m
+		}
+	}
+	if len(models) > 0 {
+		return models[0]
+	}
+	return ""
+}
+
 // ─── Pipeline Types ───────────────────────────────────────────────────────────
 // Used by the Phase 4 pipeline engine to chain tasks across nodes.
 
 // PipelineStep describes one step in a multi-step pipeline.
-// The prompt_template can include {{prev_output}} and {{initial_input}}.
+// The prompt_template can include {{prev_output}}, {{initial_input}} and,
+// after a fetch step, {{fetched_content}}.
 type PipelineStep struct {
 	Type           TaskType `json:"type"`                      // routing hint for this step
 	ModelHint      string   `json:"model_hint,omitempty"`      // optional: force a specific model
 	PromptTemplate string   `json:"prompt_template,omitempty"` // template with {{prev_output}}, {{initial_input}}
+	Language       string   `json:"language,omitempty"`        // overrides the pipeline's language for this step
+
+	// Map makes this a map step: the template runs once per item of the
+	// incoming input ({{item}}, {{item_index}}), in parallel across nodes
+	Map *PipelineMap `json:"map,omitempty"`
+
+	// Compress the step's input (the previous output) before it goes into
+	// the template. Without a template the step only compresses: its
+	// output is the compressed input
+	Compress *CompressOptions `json:"compress,omitempty"`
+
+	// Fetch makes this a fetch step: the orchestrator downloads a URL and
+	// the page's readable text is the step's output, and {{fetched_content}}
+	// in every later step's template
+	Fetch *PipelineFetch `json:"fetch,omitempty"`
+
+	// Exec makes this an exec step: the step's input (generated code) runs
+	// in the sandbox of a node declaring the language, and its stdout is the
+	// step's output
+	Exec *PipelineExec `json:"exec,omitempty"`
+}
+
+// PipelineExec configures an exec step. When the code fails and max_fixes
+// allows, the step's model (model_hint, type "code" by default) is asked
+// to fix it from the failure output and the fix is run instead.
+type PipelineExec struct {
+	Language    string `json:"language"`               // e.g. "python"; must be one a node's sandbox runs
+	Code        string `json:"code,omitempty"`         // template for the code (default: the step's input, code fences stripped)
+	TimeoutMs   int64  `json:"timeout_ms,omitempty"`   // per run, capped by the node's -exec-timeout
+	MaxFixes    int    `json:"max_fixes,omitempty"`    // fix-and-rerun rounds after a failure (default 0)
+	FixTemplate string `json:"fix_template,omitempty"` // prompt for a fix, with {{code}}, {{exec_output}} and {{exec_language}}
+}
+
+// PipelineFetch configures a fetch step. The URL is limited by the
+// orchestrator's -fetch-allow, -fetch-max-bytes and -fetch-timeout.
+type PipelineFetch struct {
+	URL      string `json:"url,omitempty"`       // template for the URL (default: the step's input)
+	MaxChars int    `json:"max_chars,omitempty"` // readable text kept (default 16000)
+}
+
+// PipelineMap configures a map step. The step's input (the previous
+// output) is split into items, each item runs as its own task, and the
+// outputs are joined in item order to form the step's output.
+type PipelineMap struct {
+	Split       string `json:"split,omitempty"`        // delimiter between items (default blank line); "json" = a JSON array
+	Join        string `json:"join,omitempty"`         // separator between outputs (default blank line)
+	MaxParallel int    `json:"max_parallel,omitempty"` // items in flight at once (default 8)
+
+	// Cut the input into chunks of this many tokens instead; split then
+	// names where they end: paragraphs (default), sentences, markdown or tokens
+	ChunkTokens   int `json:"chunk_tokens,omitempty"`
+	OverlapTokens int `json:"overlap_tokens,omitempty"` // of each chunk repeated at the start of the next
 }
 
 // PipelineRequest is what a client sends to POST /pipeline.
+// Either Steps or Template (the name of a built-in template) must be set.
 type PipelineRequest struct {
 	PipelineID   string         `json:"pipeline_id,omitempty"`
+	Template     string         `json:"template,omitempty"` // run a built-in template by name instead of Steps
 	Steps        []PipelineStep `json:"steps"`
-	InitialInput string         `json:"initial_input"` // seed text / first prompt
+	InitialInput string         `json:"initial_input"`         // seed text / first prompt
+	AllowCloud   bool           `json:"allow_cloud,omitempty"` // let steps fall back to the cloud node
+	Language     string         `json:"language,omitempty"`    // language hint for every step; also {{language}} in templates
+
+	Variables map[string]string `json:"variables,omitempty"` // {{var.<name>}} in every step's templates
+	Metadata  map[string]string `json:"metadata,omitempty"`  // opaque client tags, copied onto every step task
+
+	// Set by the orchestrator from the submitting request, like
+	// TaskRequest.Source, and copied onto every step task
+	Source *TaskSource `json:"source,omitempty"`
+}
+
+// PipelineTemplate is a ready-made pipeline shipped with the orchestrator
+// or loaded from its -pipeline-templates directory. Listed by
+// GET /pipelines/templates/builtin.
+type PipelineTemplate struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Input       string         `json:"input"` // what initial_input should contain
+	Steps       []PipelineStep `json:"steps"`
+	File        string         `json:"file,omitempty"` // the file it was loaded from; empty for shipped templates
 }
 
 // PipelineStepResult captures the outcome of a single pipeline step.
 type PipelineStepResult struct {
 	StepIndex int      `json:"step_index"`
+	Attempt   int      `json:"attempt,omitempty"`
 	TaskID    string   `json:"task_id"`
 	Type      TaskType `json:"task_type"`
 	RoutedTo  string   `json:"routed_to"`
@@ -155,6 +1195,21 @@ type PipelineStepResult struct {
 	LatencyMs int64    `json:"latency_ms"`
 	Success   bool     `json:"success"`
 	Error     string   `json:"error,omitempty"`
+	Recovered bool     `json:"recovered,omitempty"` // collected from its node after an orchestrator restart
+
+	Items []PipelineItemResult `json:"items,omitempty"` // map steps: one per item, in item order
+}
+
+// PipelineItemResult is the outcome of one item of a map step.
+type PipelineItemResult struct {
+	Index     int    `json:"index"`
+	TaskID    string `json:"task_id"`
+	RoutedTo  string `json:"routed_to,omitempty"`
+	ModelUsed string `json:"model_used,omitempty"`
+	Content   string `json:"content,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
 }
 
 // PipelineResult is the full response returned by POST /pipeline.
@@ -166,6 +1221,651 @@ type PipelineResult struct {
 	LatencyMs   int64                `json:"latency_ms"`
 	Success     bool                 `json:"success"`
 	Error       string               `json:"error,omitempty"`
+	Source      *TaskSource          `json:"source,omitempty"`   // who submitted the pipeline
+	Metadata    map[string]string    `json:"metadata,omitempty"` // echoed from the request
+}
+
+// PipelineRunStatus is the lifecycle state of a persisted pipeline run.
+type PipelineRunStatus string
+
+const (
+	RunRunning     PipelineRunStatus = "running"
+	RunSucceeded   PipelineRunStatus = "succeeded"
+	RunFailed      PipelineRunStatus = "failed"
+	RunInterrupted PipelineRunStatus = "interrupted" // orchestrator restarted mid-run
+)
+
+// PipelineRun is a persisted pipeline execution: the definition that was
+// submitted plus every step result produced so far. Returned by
+// GET /pipelines/runs/{id}.
+type PipelineRun struct {
+	PipelineID  string               `json:"pipeline_id"`
+	Status      PipelineRunStatus    `json:"status"`
+	Definition  PipelineRequest      `json:"definition"`
+	Steps       []PipelineStepResult `json:"steps"`
+	FinalOutput string               `json:"final_output,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	StartedAt   int64                `json:"started_at"`            // unix millis
+	FinishedAt  int64                `json:"finished_at,omitempty"` // unix millis, 0 while running
+	ResumedAt   int64                `json:"resumed_at,omitempty"`  // unix millis, when resumed after a restart
+	LatencyMs   int64                `json:"latency_ms,omitempty"`
+}
+
+// TaskLineageRecord answers GET /tasks/{id}/lineage: where a task came
+// from and what it produced. Lineage, RunURL and Step are only set for
+// pipeline step and map item tasks.
+type TaskLineageRecord struct {
+	TaskID  string              `json:"task_id"`
+	Lineage *TaskLineage        `json:"lineage,omitempty"`
+	RunURL  string              `json:"run_url,omitempty"`
+	Step    *PipelineStepResult `json:"step,omitempty"`
+	Tree    *LineageNode        `json:"tree"` // the whole tree the task is part of, from its root
+
+	Feedback *TaskFeedback `json:"feedback,omitempty"` // what a person thought of the task's output
+}
+
+// LineageRelation is how a task in a lineage tree came from its parent.
+type LineageRelation string
+
+const (
+	RelationStep   LineageRelation = "step"   // a pipeline step's task, under its pipeline
+	RelationItem   LineageRelation = "item"   // a map item's task, under its step's task
+	RelationRetry  LineageRelation = "retry"  // a re-run, under the task it repeats
+	RelationMirror LineageRelation = "mirror" // a mirrored copy, under the production task
+)
+
+// LineageNode is a task or pipeline in a lineage tree and how it turned
+// out. The orchestrator persists one per line, without Children, in
+// <data-dir>/lineage.jsonl.
+type LineageNode struct {
+	ID        string          `json:"id"`
+	Pipeline  bool            `json:"pipeline,omitempty"` // ID is a pipeline's, not a task's
+	Parent    string          `json:"parent,omitempty"`
+	Relation  LineageRelation `json:"relation,omitempty"` // how it came from Parent
+	RoutedTo  string          `json:"routed_to,omitempty"`
+	ModelUsed string          `json:"model_used,omitempty"`
+	Success   bool            `json:"success"`
+	Error     string          `json:"error,omitempty"`
+	Attempts  []TaskAttempt   `json:"attempts,omitempty"`  // failed tries on other nodes before the outcome
+	Timestamp int64           `json:"timestamp,omitempty"` // when it finished, Unix ms; 0 for a parent not recorded (yet)
+	Children  []*LineageNode  `json:"children,omitempty"`  // oldest first
+}
+
+// TaskRecord is a finished task as kept in the orchestrator's task history
+// (<data-dir>/tasks.db) and listed by GET /history.
+type TaskRecord struct {
+	TaskID           string            `json:"task_id"`
+	Timestamp        int64             `json:"timestamp"` // when it finished, Unix ms
+	Type             TaskType          `json:"type,omitempty"`
+	Prompt           string            `json:"prompt,omitempty"` // chat tasks: the conversation as flattened for the model
+	ModelHint        string            `json:"model_hint,omitempty"`
+	RoutedTo         string            `json:"routed_to,omitempty"`
+	ModelUsed        string            `json:"model_used,omitempty"`
+	LatencyMs        int64             `json:"latency_ms"`
+	Success          bool              `json:"success"`
+	Error            string            `json:"error,omitempty"`
+	Content          string            `json:"content,omitempty"`
+	PromptTokens     int               `json:"prompt_tokens,omitempty"`
+	CompletionTokens int               `json:"completion_tokens,omitempty"`
+	FailedAttempts   int               `json:"failed_attempts,omitempty"` // tries on other nodes before the outcome
+	Stream           bool              `json:"stream,omitempty"`          // sent to /task/stream
+	PipelineID       string            `json:"pipeline_id,omitempty"`     // set for pipeline steps and map items
+	Source           *TaskSource       `json:"source,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"` // client tags from the request
+}
+
+// TaskHistoryPage is a page of GET /history, newest first. NextCursor,
+// passed back as ?cursor=, gets the next page; it's empty on the last.
+type TaskHistoryPage struct {
+	Tasks      []TaskRecord `json:"tasks"`
+	Count      int          `json:"count"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// PipelineRunSummary is the compact form listed by GET /pipelines/runs.
+type PipelineRunSummary struct {
+	PipelineID     string            `json:"pipeline_id"`
+	Status         PipelineRunStatus `json:"status"`
+	TotalSteps     int               `json:"total_steps"`
+	CompletedSteps int               `json:"completed_steps"`
+	StartedAt      int64             `json:"started_at"`
+	FinishedAt     int64             `json:"finished_at,omitempty"`
+	LatencyMs      int64             `json:"latency_ms,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Source         *TaskSource       `json:"source,omitempty"`
+}
+
+// ─── Summarize ────────────────────────────────────────────────────────────────
+
+// SummarizeRequest is what a client sends to POST /summarize.
+type SummarizeRequest struct {
+	PipelineID string `json:"pipeline_id,omitempty"`
+	Text       string `json:"text"`                // the document
+	Focus      string `json:"focus,omitempty"`     // what to concentrate on, e.g. "decisions and open risks"
+	MaxWords   int    `json:"max_words,omitempty"` // length of the final summary (default 250)
+
+	// Chunk size in tokens (default: what fits the map model's window)
+	ChunkTokens int `json:"chunk_tokens,omitempty"`
+	// Where chunks end: paragraphs (default), sentences, markdown or tokens
+	Split         string `json:"split,omitempty"`
+	OverlapTokens int    `json:"overlap_tokens,omitempty"` // of each chunk repeated at the start of the next
+
+	ModelHint       string `json:"model_hint,omitempty"`        // model that summarizes the chunks
+	ReduceModelHint string `json:"reduce_model_hint,omitempty"` // model that combines them (default model_hint)
+	MaxParallel     int    `json:"max_parallel,omitempty"`      // chunks summarized at once (default 8)
+	Language        string `json:"language,omitempty"`
+	AllowCloud      bool   `json:"allow_cloud,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"` // opaque client tags, copied onto every task
+}
+
+// SummarizeResult is the response of POST /summarize.
+type SummarizeResult struct {
+	PipelineID  string              `json:"pipeline_id"`
+	Summary     string              `json:"summary"`
+	ChunkTokens int                 `json:"chunk_tokens"`     // the chunk size used
+	Chunks      []SummaryChunk      `json:"chunks"`           // in document order
+	Reduce      *PipelineStepResult `json:"reduce,omitempty"` // the combining step; none for a single chunk
+	LatencyMs   int64               `json:"latency_ms"`
+	Success     bool                `json:"success"`
+	Error       string              `json:"error,omitempty"`
+	RunURL      string              `json:"run_url"` // GET path of the persisted pipeline run
+	Metadata    map[string]string   `json:"metadata,omitempty"`
+}
+
+// SummaryChunk traces one chunk of a summarized document.
+type SummaryChunk struct {
+	Index     int    `json:"index"`
+	Tokens    int    `json:"tokens"` // estimated size of the chunk
+	TaskID    string `json:"task_id"`
+	RoutedTo  string `json:"routed_to,omitempty"`
+	ModelUsed string `json:"model_used,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ChunkRequest is what a client sends to POST /chunk.
+type ChunkRequest struct {
+	Text          string `json:"text"`
+	ChunkTokens   int    `json:"chunk_tokens"`             // the most estimated tokens in a chunk
+	Split         string `json:"split,omitempty"`          // paragraphs (default), sentences, markdown or tokens
+	OverlapTokens int    `json:"overlap_tokens,omitempty"` // of each chunk repeated at the start of the next
+}
+
+// ChunkResult is the response of POST /chunk.
+type ChunkResult struct {
+	Split  string      `json:"split"`
+	Chunks []TextChunk `json:"chunks"` // in text order
+}
+
+// TextChunk is one chunk of a text.
+type TextChunk struct {
+	Index  int    `json:"index"`
+	Text   string `json:"text"`
+	Tokens int    `json:"tokens"` // estimated
+}
+
+// ─── Routing hooks ────────────────────────────────────────────────────────────
+// Used by the orchestrator's routing webhook extension point.
+
+// RoutingHookRequest is POSTed to a routing webhook with the task and the
+// candidates the built-in router picked, best first.
+type RoutingHookRequest struct {
+	Task       TaskRequest `json:"task"`
+	Candidates []NodeInfo  `json:"candidates"`
+}
+
+// RoutingHookResponse lists the node IDs to keep, in preferred order.
+// Candidates left out are vetoed for this task.
+type RoutingHookResponse struct {
+	Order  []string `json:"order"`
+	Reason string   `json:"reason,omitempty"` // logged by the orchestrator
+}
+
+// ─── Mirroring ────────────────────────────────────────────────────────────────
+// Used to evaluate a candidate node/model against production traffic.
+
+// MirrorOutput is one side of a mirrored task.
+type MirrorOutput struct {
+	NodeID    string `json:"node_id"`
+	ModelUsed string `json:"model_used"`
+	Content   string `json:"content"`
+	LatencyMs int64  `json:"latency_ms"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MirrorRecord stores the production result and the candidate's result for
+// the same task side-by-side. Listed by GET /mirror/results.
+type MirrorRecord struct {
+	TaskID    string       `json:"task_id"`
+	TaskType  TaskType     `json:"task_type"`
+	Prompt    string       `json:"prompt"`
+	Primary   MirrorOutput `json:"primary"`
+	Candidate MirrorOutput `json:"candidate"`
+	Timestamp int64        `json:"timestamp"` // unix millis
+}
+
+// ─── Share links ──────────────────────────────────────────────────────────────
+// One task result or pipeline run, readable without access to the rest.
+
+// ShareRequest is the optional body of POST /tasks/{id}/share and POST
+// /pipelines/runs/{id}/share.
+type ShareRequest struct {
+	ExpiresIn string `json:"expires_in,omitempty"` // e.g. "72h"; default 24h, at most 720h
+}
+
+// ShareLink is a signed, expiring link to one result.
+type ShareLink struct {
+	Token      string `json:"token"`
+	URL        string `json:"url"` // GET it for the result
+	TaskID     string `json:"task_id,omitempty"`
+	PipelineID string `json:"pipeline_id,omitempty"`
+	ExpiresAt  int64  `json:"expires_at"` // unix millis
+}
+
+// SharedResult answers GET /share/{token}: the task's result or the
+// pipeline run the link was made for, without request metadata, lineage
+// or failed attempts.
+type SharedResult struct {
+	TaskID     string       `json:"task_id,omitempty"`
+	PipelineID string       `json:"pipeline_id,omitempty"`
+	Result     *TaskResult  `json:"result,omitempty"`
+	Run        *PipelineRun `json:"run,omitempty"`
+	ExpiresAt  int64        `json:"expires_at"` // unix millis
+}
+
+// ─── Feedback ─────────────────────────────────────────────────────────────────
+
+// FeedbackRating is a person's verdict on a task's output.
+type FeedbackRating string
+
+const (
+	RatingUp   FeedbackRating = "up"
+	RatingDown FeedbackRating = "down"
+)
+
+// FeedbackRequest is the body of POST /tasks/{id}/feedback; at least one
+// field must be set. Posting again replaces the task's feedback.
+type FeedbackRequest struct {
+	Rating          FeedbackRating `json:"rating,omitempty"`
+	Comment         string         `json:"comment,omitempty"`
+	CorrectedOutput string         `json:"corrected_output,omitempty"` // what the output should have been
+}
+
+// TaskFeedback is the feedback given on a task, with the node, model and
+// type the task ran with, for aggregating.
+type TaskFeedback struct {
+	TaskID          string         `json:"task_id"`
+	Rating          FeedbackRating `json:"rating,omitempty"`
+	Comment         string         `json:"comment,omitempty"`
+	CorrectedOutput string         `json:"corrected_output,omitempty"`
+	NodeID          string         `json:"node_id,omitempty"` // "cloud" for the cloud fallback
+	Model           string         `json:"model,omitempty"`
+	TaskType        TaskType       `json:"task_type,omitempty"`
+	Source          *TaskSource    `json:"source,omitempty"` // who gave the feedback
+	CreatedAt       int64          `json:"created_at"`       // unix ms
+	UpdatedAt       int64          `json:"updated_at"`
+}
+
+// FeedbackStats sums the feedback on the tasks one model (and task type),
+// or one node, ran.
+type FeedbackStats struct {
+	Model       string   `json:"model,omitempty"`
+	TaskType    TaskType `json:"task_type,omitempty"`
+	NodeID      string   `json:"node_id,omitempty"`
+	Up          int      `json:"up"`
+	Down        int      `json:"down"`
+	Comments    int      `json:"comments"`
+	Corrections int      `json:"corrections"`       // tasks given a corrected output
+	Score       float64  `json:"score"`             // share of ratings that are up, 0..1; 0 without ratings
+	LastAt      int64    `json:"last_at,omitempty"` // unix ms of the latest feedback counted
+}
+
+// FeedbackSummary answers GET /stats/feedback: feedback per model, per
+// model and task type, and per node, best score first.
+type FeedbackSummary struct {
+	Since     int64           `json:"since"` // unix ms the counts start at; 0 for all
+	Tasks     int             `json:"tasks"` // tasks with feedback counted
+	Models    []FeedbackStats `json:"models"`
+	Workloads []FeedbackStats `json:"workloads"`
+	Nodes     []FeedbackStats `json:"nodes"`
+}
+
+// ─── Errors ───────────────────────────────────────────────────────────────────
+
+// ProblemContentType is the media type of the orchestrator's error bodies.
+const ProblemContentType = "application/problem+json"
+
+// ProblemType identifies the kind of failure an error response reports,
+// for programs to branch on.
+type ProblemType string
+
+const (
+	ProblemInvalidRequest      ProblemType = "urn:echo:problem:invalid-request"      // 400: fix the request before retrying
+	ProblemUnauthorized        ProblemType = "urn:echo:problem:unauthorized"         // 401: missing or wrong admin or session token
+	ProblemForbidden           ProblemType = "urn:echo:problem:forbidden"            // 403: a browser origin or header -cors-origins doesn't allow
+	ProblemNotFound            ProblemType = "urn:echo:problem:not-found"            // 404
+	ProblemConflict            ProblemType = "urn:echo:problem:conflict"             // 409: conflicts with current state
+	ProblemGone                ProblemType = "urn:echo:problem:gone"                 // 410: no longer available
+	ProblemTooLarge            ProblemType = "urn:echo:problem:too-large"            // 413: over a size limit
+	ProblemIncompatible        ProblemType = "urn:echo:problem:incompatible"         // 426: an agent speaking another mesh API version
+	ProblemRateLimited         ProblemType = "urn:echo:problem:rate-limited"         // 429: over -rate-limit; see Retry-After
+	ProblemInternal            ProblemType = "urn:echo:problem:internal"             // 500
+	ProblemNodeFailed          ProblemType = "urn:echo:problem:node-failed"          // 502: a node answered with an error
+	ProblemUnavailable         ProblemType = "urn:echo:problem:unavailable"          // 503: no node could run it now
+	ProblemTimeout             ProblemType = "urn:echo:problem:timeout"              // 504: the task ran out of time
+	ProblemInsufficientStorage ProblemType = "urn:echo:problem:insufficient-storage" // 507
+)
+
+// Problem is an orchestrator error response: an RFC 7807 problem details
+// object, sent as application/problem+json. Detail is the human-readable
+// message; Retryable says whether the same request may succeed later
+// unchanged.
+type Problem struct {
+	Type      ProblemType `json:"type"`
+	Title     string      `json:"title"` // the status text
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"` // the request's path
+	TaskID    string      `json:"task_id,omitempty"`  // the task the error is about, if any
+	Retryable bool        `json:"retryable"`
+}
+
+// ProblemMessage returns an error body's message: the detail of a
+// Problem, else the body as text.
+func ProblemMessage(body []byte) string {
+	var p Problem
+	if json.Unmarshal(body, &p) == nil && p.Type != "" {
+		return p.Detail
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// ─── Admin ────────────────────────────────────────────────────────────────────
+
+// RoutingStrategy picks among equally ranked candidate nodes.
+type RoutingStrategy string
+
+const (
+	StrategyLeastLoaded RoutingStrategy = "least-loaded" // fewest active tasks first (default)
+	StrategyRoundRobin  RoutingStrategy = "round-robin"  // rotate through the best-ranked nodes
+)
+
+// RoutingConfig is read and changed via GET/PUT /admin/routing.
+type RoutingConfig struct {
+	Strategy RoutingStrategy `json:"strategy"`
+}
+
+// RoutingWeights tune how candidate nodes of the same capability tier and
+// busy state are ordered. Each signal is normalized to 0..1 (lower is
+// better) and the weighted sum ranks the nodes. Read and changed via
+// GET/PUT /admin/routing/weights.
+type RoutingWeights struct {
+	Latency    float64 `json:"latency"`    // smoothed task latency, relative to the slowest candidate
+	Load       float64 `json:"load"`       // active tasks over the busy threshold (or taken slots)
+	Reputation float64 `json:"reputation"` // recent failure rate
+	Locality   float64 `json:"locality"`   // penalty for agents not on the orchestrator's host
+	Link       float64 `json:"link"`       // the agent's link to the orchestrator: round trip, jitter and loss
+}
+
+// PipelinePolicy orders the pipeline steps waiting for a pipeline slot.
+type PipelinePolicy string
+
+const (
+	PipelineFIFO              PipelinePolicy = "fifo"               // in the order they became ready (default)
+	PipelineShortestRemaining PipelinePolicy = "shortest-remaining" // steps of the pipelines with the fewest steps left first
+)
+
+// PipelineSchedule is how pipeline steps share the mesh. Read and changed
+// via GET/PUT /admin/pipelines/schedule.
+type PipelineSchedule struct {
+	Policy  PipelinePolicy `json:"policy"`
+	Slots   int            `json:"slots"`             // pipeline steps run at once; 0 = no limit
+	Running int            `json:"running,omitempty"` // steps running now (read-only)
+	Waiting int            `json:"waiting,omitempty"` // steps waiting for a slot (read-only)
+}
+
+// FairShareConfig is how tenants (client keys) share the mesh when its
+// nodes are busy. Read and changed via GET/PUT /admin/fairshare.
+type FairShareConfig struct {
+	Enabled   bool               `json:"enabled"`           // hold tasks under contention, lightest tenant first
+	HalfLifeS int                `json:"half_life_s"`       // recent GPU-seconds halve in this long
+	MaxWaitMs int64              `json:"max_wait_ms"`       // a held task goes anyway after this long
+	Weights   map[string]float64 `json:"weights,omitempty"` // tenant → share weight; others weigh 1
+}
+
+// TenantShare is one tenant's use of the mesh, in GET /stats/fairshare.
+type TenantShare struct {
+	Tenant          string  `json:"tenant"` // client key name, "anonymous" for tasks without one
+	Weight          float64 `json:"weight"`
+	GPUSeconds      float64 `json:"gpu_seconds"`       // node time used recently, decayed over half_life_s
+	Share           float64 `json:"share"`             // its part of all tenants' recent GPU-seconds
+	FairShare       float64 `json:"fair_share"`        // its weight's part of the weights of tenants with recent use
+	TotalGPUSeconds float64 `json:"total_gpu_seconds"` // since the orchestrator started
+	Tasks           int     `json:"tasks"`             // dispatches charged
+	Waiting         int     `json:"waiting"`           // tasks held now
+	Held            int     `json:"held"`              // tasks held under contention so far
+	WaitMs          int64   `json:"wait_ms"`           // time its tasks spent held
+	Overdue         int     `json:"overdue"`           // held tasks sent after max_wait_ms
+}
+
+// FairShareStats is returned by GET /stats/fairshare.
+type FairShareStats struct {
+	Enabled bool `json:"enabled"`
+	Waiting int  `json:"waiting"` // tasks held now
+
+	// Jain's fairness index of the tenants' recent GPU-seconds per unit of
+	// weight: 1 when each uses its fair share, 1/n when one of n uses all
+	Fairness float64       `json:"fairness"`
+	Tenants  []TenantShare `json:"tenants"`
+}
+
+// TaskTimeout is how long the orchestrator waits for one node to answer
+// tasks for one model, listed by GET /stats/timeouts.
+type TaskTimeout struct {
+	NodeID    string `json:"node_id"`
+	Model     string `json:"model"`
+	Samples   int    `json:"samples"`          // recent latencies kept
+	P99Ms     int64  `json:"p99_ms,omitempty"` // of those latencies
+	TimeoutMs int64  `json:"timeout_ms"`
+	Adaptive  bool   `json:"adaptive"`  // false: the full -task-timeout, for lack of samples
+	TimedOut  int64  `json:"timed_out"` // attempts that failed over for running past it
+}
+
+// TimeoutStats is returned by GET /stats/timeouts.
+type TimeoutStats struct {
+	Enabled   bool          `json:"enabled"`    // -adaptive-timeout
+	DefaultMs int64         `json:"default_ms"` // -task-timeout
+	MinMs     int64         `json:"min_ms"`     // -task-timeout-min
+	Timeouts  []TaskTimeout `json:"timeouts"`
+}
+
+// QueueKind is which of the orchestrator's queues work waits in.
+type QueueKind string
+
+const (
+	QueueAsync    QueueKind = "async"    // sent to POST /task/async, waiting to run
+	QueueHeld     QueueKind = "held"     // held by fair sharing until a node frees up
+	QueuePipeline QueueKind = "pipeline" // a pipeline's next step, waiting for a pipeline slot
+	QueueNodes    QueueKind = "nodes"    // no node could take it yet; goes when one registers or frees up
+)
+
+// QueueEntry is work waiting at the orchestrator, listed by
+// GET /admin/queue.
+type QueueEntry struct {
+	ID       string    `json:"id"` // the task ID; the pipeline ID for pipeline steps
+	Kind     QueueKind `json:"kind"`
+	Position int       `json:"position"` // 0 goes next in its queue, as things stand
+
+	Type       TaskType    `json:"type,omitempty"`
+	ModelHint  string      `json:"model_hint,omitempty"`
+	TargetNode string      `json:"target_node,omitempty"`
+	Tenant     string      `json:"tenant,omitempty"` // held tasks: the tenant charged
+	Source     *TaskSource `json:"source,omitempty"`
+	Remaining  int         `json:"remaining_steps,omitempty"` // pipeline steps: steps left, this one included
+
+	QueuedAt int64 `json:"queued_at"` // unix millis
+	WaitedMs int64 `json:"waited_ms"`
+	Promoted bool  `json:"promoted,omitempty"` // moved to the front by an operator
+}
+
+// QueueList is returned by GET /admin/queue.
+type QueueList struct {
+	Depth   int          `json:"depth"`   // entries waiting in all queues
+	Entries []QueueEntry `json:"entries"` // by kind, then position
+}
+
+// QueueDepthBounds are the lower bounds of the queue depth histogram
+// buckets in StatsPoint.QueueDepth.
+var QueueDepthBounds = []int{0, 1, 2, 5, 10, 25, 50, 100}
+
+// DeadLetter is a task that failed on every node it was tried on.
+// Listed by GET /admin/dlq.
+type DeadLetter struct {
+	ID       string      `json:"id"`
+	Request  TaskRequest `json:"request"`
+	Error    string      `json:"error"`
+	Attempts int         `json:"attempts"`  // submissions so far, including admin retries
+	FailedAt int64       `json:"failed_at"` // unix millis of the latest failure
+}
+
+// ModelAlias is a name clients may use as model_hint in place of a concrete
+// model, managed via /admin/aliases. While a rollout is active, part of
+// the alias's traffic goes to the rollout's target instead.
+type ModelAlias struct {
+	Name    string        `json:"name"`
+	Target  string        `json:"target"`            // model the alias resolves to
+	Rollout *AliasRollout `json:"rollout,omitempty"` // staged move to a new target
+}
+
+// RolloutStatus is the state of an alias rollout.
+type RolloutStatus string
+
+const (
+	RolloutActive     RolloutStatus = "active"      // splitting traffic
+	RolloutRolledBack RolloutStatus = "rolled_back" // stopped; all traffic on the alias's target
+)
+
+// AliasRollout sends Percent of an alias's tasks to Target and rolls back
+// on its own when Target's error rate or latency regresses. Started and
+// updated via POST /admin/aliases/{name}/rollout; the threshold fields
+// default when left out.
+type AliasRollout struct {
+	Target          string  `json:"target"`
+	Percent         float64 `json:"percent"`                     // share of tasks sent to Target, 0..100
+	MaxErrorRate    float64 `json:"max_error_rate,omitempty"`    // roll back above this failure rate (default 0.1)
+	MaxLatencyRatio float64 `json:"max_latency_ratio,omitempty"` // roll back when Target is this many times slower (default 1.5)
+	MinSamples      int     `json:"min_samples,omitempty"`       // tasks per side before thresholds apply (default 20)
+
+	Status    RolloutStatus `json:"status,omitempty"`
+	Reason    string        `json:"reason,omitempty"`     // why it was rolled back
+	StartedAt int64         `json:"started_at,omitempty"` // unix millis
+	Stable    RolloutStats  `json:"stable"`               // tasks on the alias's target since the start
+	Candidate RolloutStats  `json:"candidate"`            // tasks on the rollout target
+}
+
+// RolloutStats tallies one side of a rollout.
+type RolloutStats struct {
+	Tasks        int     `json:"tasks"`
+	Failures     int     `json:"failures"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"` // mean over successful tasks
+}
+
+// ─── Offline bundles ──────────────────────────────────────────────────────────
+
+// DeferredStatus is the lifecycle of a low-priority task queued for bundling.
+type DeferredStatus string
+
+const (
+	DeferredQueued  DeferredStatus = "queued"  // waiting for a node to claim it
+	DeferredBundled DeferredStatus = "bundled" // handed to a node in a bundle
+	DeferredDone    DeferredStatus = "done"    // result uploaded
+)
+
+// DeferredTask is a queued low-priority task and, once uploaded, its result.
+// Returned by GET /bundles/tasks/{id}.
+type DeferredTask struct {
+	Request     TaskRequest    `json:"request"`
+	Status      DeferredStatus `json:"status"`
+	BundleID    string         `json:"bundle_id,omitempty"`
+	NodeID      string         `json:"node_id,omitempty"` // node holding (or that ran) the task
+	QueuedAt    int64          `json:"queued_at"`         // unix millis
+	CompletedAt int64          `json:"completed_at,omitempty"`
+	Result      *TaskResult    `json:"result,omitempty"`
+}
+
+// BundleClaimRequest is sent by an agent asking for a batch of deferred tasks.
+type BundleClaimRequest struct {
+	NodeID   string `json:"node_id"`
+	MaxTasks int    `json:"max_tasks"`
+}
+
+// TaskBundle is a batch of deferred tasks a node executes locally, even
+// while disconnected. Tasks is empty when nothing suitable is queued.
+type TaskBundle struct {
+	BundleID string        `json:"bundle_id"`
+	NodeID   string        `json:"node_id"`
+	Tasks    []TaskRequest `json:"tasks"`
+	IssuedAt int64         `json:"issued_at"` // unix millis
+}
+
+// BundleUpload carries a bundle's results back to the orchestrator.
+type BundleUpload struct {
+	NodeID  string       `json:"node_id"`
+	Results []TaskResult `json:"results"`
+}
+
+// BundleUploadResponse reports how uploaded results were reconciled.
+type BundleUploadResponse struct {
+	Accepted   int `json:"accepted"`   // stored as the task's result
+	Duplicates int `json:"duplicates"` // task already had a result (e.g. re-queued and run elsewhere)
+	Unknown    int `json:"unknown"`    // no such deferred task
+}
+
+// ─── Async tasks ──────────────────────────────────────────────────────────────
+
+// AsyncStatus is where a task sent to POST /task/async is.
+type AsyncStatus string
+
+const (
+	AsyncQueued  AsyncStatus = "queued"  // waiting for one of the running tasks to finish
+	AsyncRunning AsyncStatus = "running" // routed to the nodes
+	AsyncDone    AsyncStatus = "done"    // result holds its answer
+	AsyncFailed  AsyncStatus = "failed"  // error holds what POST /task would have answered
+)
+
+// AsyncTask is a task sent to POST /task/async, as GET /task/{id} reports it.
+type AsyncTask struct {
+	TaskID      string      `json:"task_id"`
+	Status      AsyncStatus `json:"status"`
+	StatusURL   string      `json:"status_url"`   // where to poll
+	SubmittedAt int64       `json:"submitted_at"` // unix millis
+	StartedAt   int64       `json:"started_at,omitempty"`
+	FinishedAt  int64       `json:"finished_at,omitempty"`
+	Result      *TaskResult `json:"result,omitempty"`
+	Error       *Problem    `json:"error,omitempty"`
+}
+
+// ─── Pull-mode dispatch ───────────────────────────────────────────────────────
+// Agents the orchestrator can't connect to (behind NAT or a firewall)
+// register with Pull set and fetch their tasks with GET /work instead.
+
+// WorkItem is a task handed to a pull-mode agent by GET /work. The
+// request's TimeoutMs is set to the time left at hand-out.
+type WorkItem struct {
+	WorkID  string            `json:"work_id"`
+	Request TaskRequest       `json:"request"`
+	Headers map[string]string `json:"headers,omitempty"` // client headers passed through for the backend (-pass-headers)
+}
+
+// WorkResult carries a pulled task's result to POST /results/ingest.
+type WorkResult struct {
+	WorkID string     `json:"work_id"`
+	NodeID string     `json:"node_id"`
+	Result TaskResult `json:"result"`
 }
 
 // ─── Dashboard / WebSocket Events ─────────────────────────────────────────────
@@ -173,9 +1873,11 @@ type PipelineResult struct {
 
 // MeshEvent is a single real-time event pushed to dashboard clients via WS.
 type MeshEvent struct {
-	Type      string `json:"type"`      // event type: task_routed, task_done, node_registered, etc.
-	Timestamp int64  `json:"timestamp"` // unix millis
-	Data      any    `json:"data"`      // event-specific payload
+	Type      string `json:"type"`              // event type: task_routed, task_done, node_registered, etc.
+	Timestamp int64  `json:"timestamp"`         // unix millis
+	Data      any    `json:"data"`              // event-specific payload
+	Replica   string `json:"replica,omitempty"` // orchestrator replica that emitted it, with -event-bus
+	Version   string `json:"version,omitempty"` // build of the orchestrator that emitted it
 }
 
 // TaskEvent is the payload for task_routed / task_done / task_failed events.
@@ -189,9 +1891,13 @@ type TaskEvent struct {
 	LatencyMs int64    `json:"latency_ms,omitempty"`
 	Success   bool     `json:"success,omitempty"`
 	Error     string   `json:"error,omitempty"`
+
+	Source   *TaskSource       `json:"source,omitempty"`   // who submitted the task
+	Metadata map[string]string `json:"metadata,omitempty"` // client tags from the request
 }
 
-// NodeEvent is the payload for node_registered / node_offline events.
+// NodeEvent is the payload for node_registered / node_status / node_admin /
+// node_evicted events.
 type NodeEvent struct {
 	NodeID       string            `json:"node_id"`
 	AgentPort    int               `json:"agent_port,omitempty"`
@@ -199,6 +1905,24 @@ type NodeEvent struct {
 	ActiveTasks  int               `json:"active_tasks"`
 	Models       []string          `json:"models,omitempty"`
 	Capabilities []ModelCapability `json:"capabilities,omitempty"`
+	Draining     bool              `json:"draining,omitempty"`
+	Canary       bool              `json:"canary,omitempty"`
+	Timings      *NodeTimings      `json:"timings,omitempty"`
+	Health       HealthGrade       `json:"health,omitempty"`
+	HealthReason string            `json:"health_reason,omitempty"`
+	LoadedMemory []LoadedModel     `json:"loaded_memory,omitempty"`
+	Link         *LinkQuality      `json:"link,omitempty"`
+	Version      string            `json:"version,omitempty"` // the agent's build
+}
+
+// CapabilityEvent is the payload for capability_changed events: a node's
+// models changed through a heartbeat delta.
+type CapabilityEvent struct {
+	NodeID       string            `json:"node_id"`
+	Added        []ModelCapability `json:"added,omitempty"`
+	Removed      []string          `json:"removed,omitempty"`
+	Models       []string          `json:"models"` // the node's models now
+	Capabilities []ModelCapability `json:"capabilities"`
 }
 
 // PipelineEvent is the payload for pipeline_started / pipeline_done events.
@@ -209,12 +1933,233 @@ type PipelineEvent struct {
 	LatencyMs  int64  `json:"latency_ms,omitempty"`
 	Success    bool   `json:"success,omitempty"`
 	Error      string `json:"error,omitempty"`
+	RunURL     string `json:"run_url,omitempty"`   // GET path of the persisted run (on done)
+	Recovered  int    `json:"recovered,omitempty"` // pipeline_resumed: steps collected from nodes
+
+	Source   *TaskSource       `json:"source,omitempty"`   // who submitted the pipeline
+	Metadata map[string]string `json:"metadata,omitempty"` // client tags from the request
+}
+
+// AliasEvent is the payload for alias_rollout events. Action is one of
+// started, updated, checkpoint, rolled_back, promoted or aborted.
+type AliasEvent struct {
+	Alias     string        `json:"alias"`
+	From      string        `json:"from"` // the alias's target
+	To        string        `json:"to"`   // the rollout target
+	Action    string        `json:"action"`
+	Percent   float64       `json:"percent"`
+	Reason    string        `json:"reason,omitempty"`
+	Stable    *RolloutStats `json:"stable,omitempty"`
+	Candidate *RolloutStats `json:"candidate,omitempty"`
+}
+
+// SwitchoverEvent is the payload for switchover events, the last event a
+// dashboard gets before the orchestrator closes its WebSocket. Reason is
+// shutdown (the process is stopping) or failover (POST /admin/switchover).
+type SwitchoverEvent struct {
+	Reason string `json:"reason"`
+	URL    string `json:"url,omitempty"` // base URL of the orchestrator to reconnect to; empty = this one, once it's back
+}
+
+// SwitchoverRequest asks the orchestrator to send its dashboards to
+// another one (POST /admin/switchover).
+type SwitchoverRequest struct {
+	URL string `json:"url"` // base URL of the new primary, e.g. http://orch-b:8080
+}
+
+// StatsPoint is one bucket of the stats time series returned by
+// GET /stats/series. Counters are summed over the bucket.
+type StatsPoint struct {
+	Timestamp        int64   `json:"timestamp"` // bucket start, unix millis
+	Tasks            int64   `json:"tasks"`
+	FailedTasks      int64   `json:"failed_tasks"`
+	Pipelines        int64   `json:"pipelines"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	SentBytes        int64   `json:"sent_bytes"`     // to agents, see TaskTransfer
+	ReceivedBytes    int64   `json:"received_bytes"` // from agents
+	WarmHitRate      float64 `json:"warm_hit_rate"`  // share of the bucket's dispatches to a node with the model loaded, 0..1
+
+	// How deep the orchestrator's queues were, sampled every few seconds:
+	// samples per QueueDepthBounds bucket, and the deepest sample
+	QueueDepth    []int64 `json:"queue_depth"`
+	QueueDepthMax int64   `json:"queue_depth_max"`
+}
+
+// NodeAvailability is how reliably a node stayed up over a window, from
+// its heartbeats (GET /stats/availability). Only time the orchestrator was
+// running counts: TrackedSecs is the part of the window it was watching
+// since the node first registered, UpSecs the part the node was up.
+// Offlines counts the times it went offline, and MTBOSecs is its mean time
+// between them (UpSecs / Offlines), absent without any.
+type NodeAvailability struct {
+	NodeID        string  `json:"node_id"`
+	WindowSecs    int64   `json:"window_secs"`
+	TrackedSecs   int64   `json:"tracked_secs"`
+	UpSecs        int64   `json:"up_secs"`
+	UptimePct     float64 `json:"uptime_pct"` // UpSecs / TrackedSecs, 0..100
+	Offlines      int     `json:"offlines"`
+	MTBOSecs      int64   `json:"mtbo_secs,omitempty"`
+	LastOfflineAt int64   `json:"last_offline_at,omitempty"` // unix ms
+	Online        bool    `json:"online"`
 }
 
 // DashboardStats is the summary sent on initial WS connection and periodically.
 type DashboardStats struct {
-	TotalTasks     int64   `json:"total_tasks"`
-	TotalPipelines int64   `json:"total_pipelines"`
-	AvgLatencyMs   float64 `json:"avg_latency_ms"`
-	UptimeSecs     int64   `json:"uptime_secs"`
+	TotalTasks            int64   `json:"total_tasks"`
+	TotalPipelines        int64   `json:"total_pipelines"`
+	AvgLatencyMs          float64 `json:"avg_latency_ms"`
+	UptimeSecs            int64   `json:"uptime_secs"`
+	TotalPromptTokens     int64   `json:"total_prompt_tokens"`     // estimated
+	TotalCompletionTokens int64   `json:"total_completion_tokens"` // estimated
+	TotalSentBytes        int64   `json:"total_sent_bytes"`        // to agents, see TaskTransfer
+	TotalReceivedBytes    int64   `json:"total_received_bytes"`    // from agents
+	WarmHitRate           float64 `json:"warm_hit_rate"`           // share of dispatches to a node with the model loaded, 0..1
+}
+
+// AlertRule names a condition the orchestrator alerts on.
+type AlertRule string
+
+const (
+	AlertNodeOffline AlertRule = "node_offline" // no heartbeat for a while
+	AlertErrorRate   AlertRule = "error_rate"   // share of recent tasks that failed
+	AlertQueueDepth  AlertRule = "queue_depth"  // tasks waiting for a node
+	AlertDiskLow     AlertRule = "disk_low"     // little free space on a node's models volume
+)
+
+// AlertState is whether an alert's condition still holds.
+type AlertState string
+
+const (
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// Alert is one rule firing for one subject. It's what GET /alerts lists,
+// what alert events carry and what the generic webhook receives.
+type Alert struct {
+	ID         string     `json:"id"` // rule:subject, e.g. "disk_low:node-a"
+	Rule       AlertRule  `json:"rule"`
+	Subject    string     `json:"subject"` // node ID, or "mesh" for mesh-wide rules
+	State      AlertState `json:"state"`
+	Message    string     `json:"message"`
+	Value      float64    `json:"value"`     // the measurement, in the rule's unit (see AlertRuleConfig)
+	Threshold  float64    `json:"threshold"` // the rule's limit when it fired
+	FiredAt    int64      `json:"fired_at"`  // Unix ms
+	ResolvedAt int64      `json:"resolved_at,omitempty"`
+}
+
+// AlertRuleConfig is an enabled rule and its limit: seconds without a
+// heartbeat for node_offline, a failed fraction for error_rate, waiting
+// tasks for queue_depth and a free fraction for disk_low.
+type AlertRuleConfig struct {
+	Rule      AlertRule `json:"rule"`
+	Threshold float64   `json:"threshold"`
+}
+
+// ─── Conversations ────────────────────────────────────────────────────────────
+
+// Conversation is a chat the orchestrator keeps (POST /conversations):
+// each turn sent to it runs as a task over the history so far. It may be
+// pinned to a model and/or a node, and switched to another model midway.
+type Conversation struct {
+	ConversationID string   `json:"conversation_id"`
+	Type           TaskType `json:"type,omitempty"`    // routing hint for its turns
+	Model          string   `json:"model,omitempty"`   // pinned model; "" lets routing choose
+	NodeID         string   `json:"node_id,omitempty"` // pinned node; "" lets routing choose
+
+	Messages []ConversationMessage `json:"messages"`
+
+	// Summary of Messages[:SummaryThrough], sent in place of those turns
+	// after a switch to a model with a smaller context window
+	Summary        string `json:"summary,omitempty"`
+	SummaryThrough int    `json:"summary_through,omitempty"`
+
+	// Pins and model switches, oldest first
+	Changes []ConversationChange `json:"changes,omitempty"`
+
+	CreatedAt int64 `json:"created_at"` // Unix ms
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// ConversationMessage is one turn of a Conversation. Assistant turns name
+// the task that produced them and where it ran.
+type ConversationMessage struct {
+	Role    string `json:"role"` // system, user or assistant
+	Content string `json:"content"`
+	At      int64  `json:"at"` // Unix ms
+	TaskID  string `json:"task_id,omitempty"`
+	Model   string `json:"model,omitempty"`
+	NodeID  string `json:"node_id,omitempty"`
+}
+
+// ConversationChangeKind is what a ConversationChange did.
+type ConversationChangeKind string
+
+const (
+	ConversationPin    ConversationChangeKind = "pin"
+	ConversationUnpin  ConversationChangeKind = "unpin"
+	ConversationSwitch ConversationChangeKind = "switch"
+)
+
+// ConversationChange records a pin or model switch in a conversation's
+// history.
+type ConversationChange struct {
+	Kind      ConversationChangeKind `json:"kind"`
+	At        int64                  `json:"at"`         // Unix ms
+	AtMessage int                    `json:"at_message"` // messages in the conversation when it happened
+	FromModel string                 `json:"from_model,omitempty"`
+	Model     string                 `json:"model,omitempty"`
+	FromNode  string                 `json:"from_node,omitempty"`
+	NodeID    string                 `json:"node_id,omitempty"`
+
+	// Turns condensed into the summary to fit the new model's smaller
+	// window, and a note on what was done (or why it wasn't)
+	Summarized int    `json:"summarized,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+// ConversationRequest is the body of POST /conversations. Messages seed
+// the history, e.g. with a system prompt.
+type ConversationRequest struct {
+	Type     TaskType      `json:"type,omitempty"`
+	Model    string        `json:"model,omitempty"`
+	NodeID   string        `json:"node_id,omitempty"`
+	Messages []ChatMessage `json:"messages,omitempty"`
+}
+
+// ConversationPinRequest is the body of PUT /conversations/{id}/pin, which
+// replaces the pin, and POST /conversations/{id}/switch, which needs
+// Model and keeps the pinned node unless NodeID is given.
+type ConversationPinRequest struct {
+	Model  string `json:"model,omitempty"`
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// ConversationTurn is the body of POST /conversations/{id}/messages: the
+// next user turn, run as a task over the conversation.
+type ConversationTurn struct {
+	Content    string            `json:"content"`
+	Format     OutputFormat      `json:"format,omitempty"`
+	Options    map[string]any    `json:"options,omitempty"`
+	AllowCloud bool              `json:"allow_cloud,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// ─── Request metrics ──────────────────────────────────────────────────────────
+
+// RouteStats counts the requests one route has served since the
+// orchestrator started (GET /debug/requests). Route is the mux pattern,
+// e.g. "POST /task", or "unmatched".
+type RouteStats struct {
+	Route        string  `json:"route"`
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"client_errors"` // 4xx answers other than 429
+	ServerErrors int64   `json:"server_errors"` // 5xx answers
+	RateLimited  int64   `json:"rate_limited"`  // 429 answers
+	Panics       int64   `json:"panics"`        // handler panics answered with a 500
+	TotalMs      int64   `json:"total_ms"`
+	AvgMs        float64 `json:"avg_ms"` // includes streams and WebSockets, for as long as they stayed open
+	MaxMs        int64   `json:"max_ms"`
 }
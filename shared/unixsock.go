@@ -0,0 +1,133 @@
+// shared/unixsock.go
+// Unix domain socket transport for all-in-one, single-machine deployments.
+//
+// Addresses written "unix:/path/to.sock" name a socket instead of a TCP
+// host:port: both binaries accept one for -listen, the agent for
+// -orchestrator and -ollama-host. Access is then governed by the socket
+// file's permissions rather than by who can reach a port.
+//
+// HTTP clients reach a socket through a synthetic host name from
+// SocketHost: http.DefaultTransport dials the socket for it, so every
+// client built on the default transport works unchanged.
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// UnixPrefix marks an address as a unix socket path.
+const UnixPrefix = "unix:"
+
+// SocketPath returns the path of a "unix:/path" address.
+func SocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, UnixPrefix)
+	return path, ok && path != ""
+}
+
+// Listen listens on a TCP address (":8080") or a unix socket
+// ("unix:/run/echo/orchestrator.sock"). A socket file left behind by a
+// previous run is replaced, and the socket is made readable and writable
+// by its owner and group only.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := SocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// BaseURL is the "http://host:port" base of a service, or the base that
+// reaches its socket when host is a "unix:/path" address (port unused).
+func BaseURL(host string, port int) string {
+	if path, ok := SocketPath(host); ok {
+		return "http://" + SocketHost(path)
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// ─── Dialing ──────────────────────────────────────────────────────────────────
+
+// socketHostSuffix is under the reserved .invalid TLD, so a synthetic host
+// can never be a real one.
+const socketHostSuffix = ".sock.invalid"
+
+var (
+	socketHostsMu   sync.Mutex
+	socketHosts     = make(map[string]string) // synthetic host → socket path
+	socketHostPaths = make(map[string]string) // socket path → synthetic host
+)
+
+// The dialer is installed before any request can be in flight.
+func init() { routeSocketHosts() }
+
+// SocketHost returns a host name that HTTP requests made through
+// http.DefaultTransport deliver to the unix socket at path, whatever the
+// URL's port: "http://" + SocketHost(path) + "/status".
+func SocketHost(path string) string {
+	socketHostsMu.Lock()
+	defer socketHostsMu.Unlock()
+	if host, ok := socketHostPaths[path]; ok {
+		return host
+	}
+	host := fmt.Sprintf("unix%d%s", len(socketHosts)+1, socketHostSuffix)
+	socketHosts[host] = path
+	socketHostPaths[path] = host
+	return host
+}
+
+// routeSocketHosts makes http.DefaultTransport dial the socket of
+// synthetic hosts, and TCP as before for everything else.
+func routeSocketHosts() {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return
+	}
+	dialTCP := t.DialContext
+	if dialTCP == nil {
+		dialTCP = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && strings.HasSuffix(host, socketHostSuffix) {
+			socketHostsMu.Lock()
+			path, ok := socketHosts[host]
+			socketHostsMu.Unlock()
+			if ok {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			}
+		}
+		return dialTCP(ctx, network, addr)
+	}
+	// Sockets are local: never send them through HTTP_PROXY
+	proxy := t.Proxy
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		if strings.HasSuffix(r.URL.Hostname(), socketHostSuffix) || proxy == nil {
+			return nil, nil
+		}
+		return proxy(r)
+	}
+}
@@ -0,0 +1,76 @@
+// shared/version.go
+// Build versions, and the mesh API version agents and orchestrators must
+// share.
+//
+// Release builds stamp the version, commit and build date into both
+// binaries (see .goreleaser.yaml); a plain go build is "dev". Agents send
+// their build and API version with every registration and heartbeat. The
+// orchestrator refuses agents speaking another mesh API version, and
+// grades a node yellow while its release differs from its own.
+
+package shared
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version, Commit and BuildDate describe the build. They're reported at
+// GET /version, in registrations and events, and advertised over mDNS.
+// Set at build time:
+//
+//	go build -ldflags "-X echo-system/shared.Version=v1.4.0 -X echo-system/shared.Commit=3f2c1ab" ./orchestrator
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = "" // RFC 3339
+)
+
+// MeshAPIVersion is the version of the agent ↔ orchestrator API (register,
+// heartbeat, execute). The orchestrator advertises it in its mDNS TXT
+// records ("api=2") and agents skip orchestrators speaking another one;
+// the orchestrator refuses to register agents speaking another one.
+const MeshAPIVersion = 2
+
+// VersionInfo is answered by GET /version, on the orchestrator and agents.
+type VersionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	BuildDate  string `json:"build_date,omitempty"`
+	APIVersion int    `json:"api_version"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+}
+
+// BuildInfo describes this binary.
+func BuildInfo() VersionInfo {
+	return VersionInfo{
+		Version:    Version,
+		Commit:     Commit,
+		BuildDate:  BuildDate,
+		APIVersion: MeshAPIVersion,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+	}
+}
+
+// String renders the version for -version and logs, e.g.
+// "v1.4.0 (commit 3f2c1ab, built 2026-10-01T12:00:00Z, mesh API v2, linux/arm64)".
+func (v VersionInfo) String() string {
+	s := v.Version + " ("
+	if v.Commit != "" {
+		s += "commit " + v.Commit + ", "
+	}
+	if v.BuildDate != "" {
+		s += "built " + v.BuildDate + ", "
+	}
+	return s + fmt.Sprintf("mesh API v%d, %s/%s, %s)", v.APIVersion, v.OS, v.Arch, v.GoVersion)
+}
+
+// IsRelease reports whether v names a release build rather than "dev" or
+// nothing.
+func IsRelease(v string) bool {
+	return v != "" && v != "dev"
+}